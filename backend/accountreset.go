@@ -0,0 +1,39 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ResetAccount deletes the cached blockchain data of the account and replaces it with a fresh,
+// uninitialized account, which resyncs from the blockchain when it is initialized. The other
+// accounts and the config are not touched. See btc.Account.Reset() for the data that is kept.
+func (backend *Backend) ResetAccount(code string) error {
+	defer backend.accountsLock.Lock()()
+	for index, account := range backend.accounts {
+		if account.Code() != code {
+			continue
+		}
+		backend.log.WithField("code", code).Info("Resetting account data")
+		backend.onAccountUninit(account)
+		fresh, err := account.Reset()
+		backend.accounts[index] = fresh
+		backend.onAccountInit(fresh)
+		backend.events <- AccountEvent{Type: "account", Code: code, Data: "reset"}
+		return err
+	}
+	return errp.Newf("unknown account %s", code)
+}
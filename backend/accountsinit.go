@@ -0,0 +1,72 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+)
+
+// accountInitConcurrency is the number of accounts initialized at the same time when a keystore
+// connects. The accounts share the device and the blockchain connections, so only a few run at once.
+const accountInitConcurrency = 4
+
+// startAccounts initializes the given accounts in the background, accountInitConcurrency at a time,
+// so that the user does not wait for each account in turn. Once all of them are initialized, the
+// "accountsReady" event is emitted. Accounts which failed to initialize are initialized again when
+// the frontend opens them, see btc.Account.Init().
+func (backend *Backend) startAccounts(accounts []*btc.Account) {
+	start := time.Now()
+	semaphore := make(chan struct{}, accountInitConcurrency)
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		account := account
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			backend.initAccount(account)
+		}()
+	}
+	wg.Wait()
+	backend.log.WithField("duration", time.Since(start)).Info("Accounts initialized")
+	backend.events <- backendEvent{Type: "backend", Data: "accountsReady"}
+}
+
+// initAccount initializes the account, unless it was replaced or removed in the meantime, e.g.
+// because the keystore was disconnected. The accounts lock is not held during the initialization,
+// which talks to the device and the blockchain, so that the accounts can be changed and read in
+// the meantime. Like the initialization requested by the frontend, it can overlap with closing the
+// account.
+func (backend *Backend) initAccount(account *btc.Account) {
+	current := func() bool {
+		defer backend.accountsLock.RLock()()
+		for _, currentAccount := range backend.accounts {
+			if currentAccount == account {
+				return true
+			}
+		}
+		return false
+	}()
+	if !current {
+		return
+	}
+	if err := account.Init(); err != nil {
+		backend.log.WithError(err).WithField("code", account.Code()).Error("Failed to initialize account")
+	}
+}
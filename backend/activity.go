@@ -0,0 +1,112 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/activity"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+)
+
+// activityAlerts are the account events which are recorded in the activity feed.
+var activityAlerts = map[btc.Event]struct{}{
+	btc.EventFeeBumpSuggested:     {},
+	btc.EventVaultUnvaultDetected: {},
+	btc.EventWatchedTxAlert:       {},
+	btc.EventInvoiceStateChanged:  {},
+}
+
+// Activity returns up to limit entries of the activity feed older than the entry with the ID
+// before, the newest first. before 0 starts at the newest entry.
+func (backend *Backend) Activity(before int64, limit int) ([]*activity.Entry, bool) {
+	return backend.activity.Entries(before, limit)
+}
+
+// ActivityUnread returns the number of activity entries the user has not seen yet.
+func (backend *Backend) ActivityUnread() int {
+	return backend.activity.Unread()
+}
+
+// MarkActivityRead marks all activity entries as seen.
+func (backend *Backend) MarkActivityRead() error {
+	return backend.activity.MarkRead()
+}
+
+// recordActivity adds the entries to the activity feed and notifies the frontends.
+func (backend *Backend) recordActivity(entries ...*activity.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := backend.activity.Add(entries...); err != nil {
+		backend.log.WithError(err).Error("Failed to record the activity")
+		return
+	}
+	backend.events <- backendEvent{Type: "activity", Data: "entriesAdded"}
+}
+
+// recordAccountActivity records the account event if it is an alert, and the new payments of the
+// account after it synced.
+func (backend *Backend) recordAccountActivity(code string, event btc.Event) {
+	if _, ok := activityAlerts[event]; ok {
+		backend.recordActivity(&activity.Entry{
+			Type: activity.TypeAlert, Account: code, Event: string(event)})
+	}
+	if event == btc.EventSyncDone {
+		backend.recordPayments(code)
+	}
+}
+
+// recordPayments records the transactions of the account which were not there at the previous
+// sync, including the ones which arrived while the app was closed.
+func (backend *Backend) recordPayments(code string) {
+	account := backend.account(code)
+	if account == nil || !account.InitialSyncDone() {
+		return
+	}
+	txs := account.Transactions()
+	txIDs := make([]string, len(txs))
+	txsByID := make(map[string]*transactions.TxInfo, len(txs))
+	for index, txInfo := range txs {
+		txIDs[index] = txInfo.Tx.TxHash().String()
+		txsByID[txIDs[index]] = txInfo
+	}
+	newTxIDs, err := backend.activity.NewTxs(code, txIDs)
+	if err != nil {
+		backend.log.WithError(err).Error("Failed to record the transactions of the activity feed")
+		return
+	}
+	entries := []*activity.Entry{}
+	// Record the oldest first, so that the newest payment is on top of the feed.
+	for index := len(newTxIDs) - 1; index >= 0; index-- {
+		txInfo := txsByID[newTxIDs[index]]
+		entry := &activity.Entry{
+			Type:    activity.TypePaymentSent,
+			Account: code,
+			Event:   string(txInfo.Type),
+			Data: map[string]string{
+				"txID":   newTxIDs[index],
+				"amount": account.Coin().FormatAmount(int64(txInfo.Amount)),
+			},
+		}
+		if txInfo.Type == transactions.TxTypeReceive {
+			entry.Type = activity.TypePaymentReceived
+		}
+		if txInfo.Timestamp != nil {
+			entry.Time = *txInfo.Timestamp
+		}
+		entries = append(entries, entry)
+	}
+	backend.recordActivity(entries...)
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package activity persists a feed of what happened in the wallet, like received payments,
+// plugged in devices and alerts, so that the app can show them after a restart. Payments which
+// arrived while the app was closed are detected on the next sync, see NewTxs().
+package activity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// maxEntries is the number of most recent entries kept. Older entries are dropped.
+const maxEntries = 1000
+
+// Type is the kind of an entry.
+type Type string
+
+const (
+	// TypePaymentReceived is an incoming transaction of an account.
+	TypePaymentReceived Type = "paymentReceived"
+	// TypePaymentSent is an outgoing transaction of an account, including sends to self.
+	TypePaymentSent Type = "paymentSent"
+	// TypeDevice is a device being plugged in or removed.
+	TypeDevice Type = "device"
+	// TypeAlert is an account event which needs the attention of the user.
+	TypeAlert Type = "alert"
+)
+
+// Entry is an item of the feed.
+type Entry struct {
+	// ID increases with every entry and is used for pagination.
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	Type Type      `json:"type"`
+	// Account is the code of the account the entry belongs to, empty for device entries.
+	Account string `json:"account,omitempty"`
+	// Event is the event the entry was recorded for, e.g. "registered" or "watchedTxAlert".
+	Event string `json:"event,omitempty"`
+	// Data holds the details depending on the type, e.g. the txid and amount of a payment.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+type state struct {
+	NextID int64 `json:"nextId"`
+	// ReadID is the ID of the newest entry the user has seen.
+	ReadID  int64    `json:"readId"`
+	Entries []*Entry `json:"entries"`
+	// KnownTxs are the txids of each account at the previous sync, by account code.
+	KnownTxs map[string][]string `json:"knownTxs"`
+}
+
+// Store persists the feed in a JSON file.
+type Store struct {
+	filename string
+	state    state
+	lock     locker.Locker
+}
+
+// NewStore creates a store persisted in the given file, loading the existing feed if the file
+// exists.
+func NewStore(filename string) *Store {
+	store := &Store{
+		filename: filename,
+		state:    state{NextID: 1, Entries: []*Entry{}, KnownTxs: map[string][]string{}},
+	}
+	store.load()
+	return store
+}
+
+func (store *Store) load() {
+	jsonBytes, err := ioutil.ReadFile(store.filename)
+	if err != nil {
+		return
+	}
+	loaded := state{}
+	if err := json.Unmarshal(jsonBytes, &loaded); err != nil {
+		return
+	}
+	if loaded.Entries == nil {
+		loaded.Entries = []*Entry{}
+	}
+	if loaded.KnownTxs == nil {
+		loaded.KnownTxs = map[string][]string{}
+	}
+	if loaded.NextID < 1 {
+		loaded.NextID = 1
+	}
+	store.state = loaded
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.Marshal(store.state)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// Add appends the entries to the feed, assigning their IDs. Entries without a time get the current
+// time. The oldest entries are dropped if the feed exceeds its capacity.
+func (store *Store) Add(entries ...*Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	defer store.lock.Lock()()
+	now := time.Now()
+	for _, entry := range entries {
+		entry.ID = store.state.NextID
+		store.state.NextID++
+		if entry.Time.IsZero() {
+			entry.Time = now
+		}
+		store.state.Entries = append(store.state.Entries, entry)
+	}
+	if len(store.state.Entries) > maxEntries {
+		store.state.Entries = append([]*Entry{},
+			store.state.Entries[len(store.state.Entries)-maxEntries:]...)
+	}
+	return store.save()
+}
+
+// Entries returns up to limit entries older than the entry with the ID before, the newest first.
+// before 0 starts at the newest entry. more is true if there are older entries left.
+func (store *Store) Entries(before int64, limit int) (entries []*Entry, more bool) {
+	defer store.lock.RLock()()
+	entries = []*Entry{}
+	for index := len(store.state.Entries) - 1; index >= 0; index-- {
+		entry := store.state.Entries[index]
+		if before != 0 && entry.ID >= before {
+			continue
+		}
+		if len(entries) == limit {
+			return entries, true
+		}
+		entries = append(entries, entry)
+	}
+	return entries, false
+}
+
+// Unread returns the number of entries added after the last call to MarkRead().
+func (store *Store) Unread() int {
+	defer store.lock.RLock()()
+	unread := 0
+	for _, entry := range store.state.Entries {
+		if entry.ID > store.state.ReadID {
+			unread++
+		}
+	}
+	return unread
+}
+
+// MarkRead marks all entries as seen by the user.
+func (store *Store) MarkRead() error {
+	defer store.lock.Lock()()
+	store.state.ReadID = store.state.NextID - 1
+	return store.save()
+}
+
+// NewTxs records the txids of the account and returns the ones which were not known at the
+// previous call, in the given order. The first call for an account only records the txids, so
+// that the whole history of a newly added account is not reported as new payments.
+func (store *Store) NewTxs(account string, txIDs []string) ([]string, error) {
+	defer store.lock.Lock()()
+	known, ok := store.state.KnownTxs[account]
+	newTxIDs := []string{}
+	if ok {
+		knownSet := make(map[string]struct{}, len(known))
+		for _, txID := range known {
+			knownSet[txID] = struct{}{}
+		}
+		for _, txID := range txIDs {
+			if _, ok := knownSet[txID]; !ok {
+				newTxIDs = append(newTxIDs, txID)
+			}
+		}
+		if len(newTxIDs) == 0 && len(known) == len(txIDs) {
+			return newTxIDs, nil
+		}
+	}
+	store.state.KnownTxs[account] = append([]string{}, txIDs...)
+	if err := store.save(); err != nil {
+		return nil, err
+	}
+	return newTxIDs, nil
+}
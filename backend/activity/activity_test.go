@@ -0,0 +1,96 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activity_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/activity"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntries(t *testing.T) {
+	filename := path.Join(test.TstTempDir("activity-"), "activity.json")
+	store := activity.NewStore(filename)
+	entries, more := store.Entries(0, 10)
+	require.Empty(t, entries)
+	require.False(t, more)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Add(&activity.Entry{Type: activity.TypeDevice, Event: "registered"}))
+	}
+	require.Equal(t, 5, store.Unread())
+
+	entries, more = store.Entries(0, 2)
+	require.True(t, more)
+	require.Equal(t, []int64{5, 4}, []int64{entries[0].ID, entries[1].ID})
+	require.False(t, entries[0].Time.IsZero())
+	entries, more = store.Entries(entries[1].ID, 2)
+	require.True(t, more)
+	require.Equal(t, []int64{3, 2}, []int64{entries[0].ID, entries[1].ID})
+	entries, more = store.Entries(entries[1].ID, 2)
+	require.False(t, more)
+	require.Len(t, entries, 1)
+
+	require.NoError(t, store.MarkRead())
+	require.Equal(t, 0, store.Unread())
+
+	// The feed and the read marker are persisted.
+	store = activity.NewStore(filename)
+	require.NoError(t, store.Add(&activity.Entry{Type: activity.TypeAlert, Account: "tbtc"}))
+	require.Equal(t, 1, store.Unread())
+	entries, _ = store.Entries(0, 1)
+	require.Equal(t, int64(6), entries[0].ID)
+	require.Equal(t, "tbtc", entries[0].Account)
+}
+
+func TestCapacity(t *testing.T) {
+	store := activity.NewStore(path.Join(test.TstTempDir("activity-"), "activity.json"))
+	for i := 0; i < 1010; i++ {
+		require.NoError(t, store.Add(&activity.Entry{Type: activity.TypeDevice}))
+	}
+	entries, more := store.Entries(0, 2000)
+	require.False(t, more)
+	require.Len(t, entries, 1000)
+	require.Equal(t, int64(1010), entries[0].ID)
+	require.Equal(t, int64(11), entries[len(entries)-1].ID)
+}
+
+func TestNewTxs(t *testing.T) {
+	filename := path.Join(test.TstTempDir("activity-"), "activity.json")
+	store := activity.NewStore(filename)
+
+	// The existing history of an account is not reported.
+	newTxIDs, err := store.NewTxs("tbtc", []string{"b", "a"})
+	require.NoError(t, err)
+	require.Empty(t, newTxIDs)
+
+	newTxIDs, err = store.NewTxs("tbtc", []string{"b", "a"})
+	require.NoError(t, err)
+	require.Empty(t, newTxIDs)
+
+	// Transactions which arrived while the app was closed are reported after a restart.
+	store = activity.NewStore(filename)
+	newTxIDs, err = store.NewTxs("tbtc", []string{"d", "c", "b", "a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"d", "c"}, newTxIDs)
+
+	// Accounts are separate.
+	newTxIDs, err = store.NewTxs("tltc", []string{"a"})
+	require.NoError(t, err)
+	require.Empty(t, newTxIDs)
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apierror contains the structured error model of the HTTP API. Handlers return errors as
+// a stable code with a category, a retryable flag and a message key, so that the frontend and
+// third-party API users can react to errors programmatically instead of matching error strings.
+package apierror
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Category groups error codes by their origin.
+type Category string
+
+const (
+	// CategoryValidation is for errors caused by invalid user input.
+	CategoryValidation Category = "validation"
+	// CategoryDevice is for errors reported by or related to a hardware wallet.
+	CategoryDevice Category = "device"
+	// CategoryNetwork is for errors when talking to a remote server.
+	CategoryNetwork Category = "network"
+	// CategoryPermission is for errors caused by missing system permissions.
+	CategoryPermission Category = "permission"
+	// CategoryInternal is for unexpected errors.
+	CategoryInternal Category = "internal"
+)
+
+// Code is a stable identifier of an error. Codes are part of the API and must not be changed.
+type Code string
+
+const (
+	// CodeInvalidInput is returned when the request is malformed or contains an invalid value.
+	CodeInvalidInput Code = "invalidInput"
+	// CodeInvalidAddress is returned when a recipient address can't be parsed.
+	CodeInvalidAddress Code = "invalidAddress"
+	// CodeInvalidAmount is returned when an amount can't be parsed or is out of range.
+	CodeInvalidAmount Code = "invalidAmount"
+	// CodeInsufficientFunds is returned when the account can't pay the amount plus fee.
+	CodeInsufficientFunds Code = "insufficientFunds"
+	// CodeFeeBumpNotPossible is returned when a tx can't be replaced by one paying a higher fee.
+	CodeFeeBumpNotPossible Code = "feeBumpNotPossible"
+	// CodeFeeCapExceeded is returned when the fee is above the configured cap. The request can be
+	// repeated with an explicit override.
+	CodeFeeCapExceeded Code = "feeCapExceeded"
+	// CodeAddressDenylisted is returned when the recipient is on one of the address denylists. The
+	// request can be repeated with an explicit override.
+	CodeAddressDenylisted Code = "addressDenylisted"
+	// CodePolicyViolation is returned when a tx is rejected by the pre-signing policy checks.
+	CodePolicyViolation Code = "policyViolation"
+	// CodeUSBPermissionDenied is returned when the app may not access the USB device.
+	CodeUSBPermissionDenied Code = "usbPermissionDenied"
+	// CodeUdevRulesInstallFailed is returned when the udev rules could not be installed.
+	CodeUdevRulesInstallFailed Code = "udevRulesInstallFailed"
+	// CodeDevice is returned for device errors without a more specific code.
+	CodeDevice Code = "device"
+	// CodeWrongPassword is returned when the device password is wrong.
+	CodeWrongPassword Code = "wrongPassword"
+	// CodePasswordPolicy is returned when a new password does not comply with the password policy.
+	CodePasswordPolicy Code = "passwordPolicy"
+	// CodeAborted is returned when the user aborted or did not confirm on the device.
+	CodeAborted Code = "aborted"
+	// CodeSDCard is returned when the SD card is needed, but not inserted.
+	CodeSDCard Code = "sdCard"
+	// CodeDeviceBusy is returned when the device is still booting up.
+	CodeDeviceBusy Code = "deviceBusy"
+	// CodeDeviceNotResponding is returned when the device did not reply in time.
+	CodeDeviceNotResponding Code = "deviceNotResponding"
+	// CodeFirmwareDowngrade is returned when a firmware upgrade would install an older firmware.
+	CodeFirmwareDowngrade Code = "firmwareDowngrade"
+	// CodeFirmwareDownloadFailed is returned when the firmware releases or a firmware binary could
+	// not be fetched or verified.
+	CodeFirmwareDownloadFailed Code = "firmwareDownloadFailed"
+	// CodeCertDownloadFailed is returned when the certificate of a server could not be fetched.
+	CodeCertDownloadFailed Code = "certDownloadFailed"
+	// CodeServerCheckFailed is returned when a connection to an Electrum server fails.
+	CodeServerCheckFailed Code = "serverCheckFailed"
+	// CodeWrongBackupPassphrase is returned when a metadata backup can't be decrypted.
+	CodeWrongBackupPassphrase Code = "wrongBackupPassphrase"
+	// CodeWrongBackupKeystore is returned when a metadata backup encrypted with the keystore key
+	// can't be decrypted with the connected wallet.
+	CodeWrongBackupKeystore Code = "wrongBackupKeystore"
+	// CodeBackupTargetFailed is returned when the metadata backup target can't be reached.
+	CodeBackupTargetFailed Code = "backupTargetFailed"
+	// CodeFeatureDisabled is returned when the endpoint belongs to a feature which is disabled, see
+	// package config/features.
+	CodeFeatureDisabled Code = "featureDisabled"
+	// CodeInternal is returned for all unexpected errors.
+	CodeInternal Code = "internal"
+)
+
+type codeInfo struct {
+	category  Category
+	retryable bool
+}
+
+var codes = map[Code]codeInfo{
+	CodeInvalidInput:           {CategoryValidation, false},
+	CodeInvalidAddress:         {CategoryValidation, false},
+	CodeInvalidAmount:          {CategoryValidation, false},
+	CodeInsufficientFunds:      {CategoryValidation, false},
+	CodeFeeBumpNotPossible:     {CategoryValidation, false},
+	CodeFeeCapExceeded:         {CategoryValidation, false},
+	CodeAddressDenylisted:      {CategoryValidation, false},
+	CodePolicyViolation:        {CategoryInternal, false},
+	CodeUSBPermissionDenied:    {CategoryPermission, true},
+	CodeUdevRulesInstallFailed: {CategoryPermission, true},
+	CodeDevice:                 {CategoryDevice, true},
+	CodeWrongPassword:          {CategoryDevice, true},
+	CodePasswordPolicy:         {CategoryValidation, false},
+	CodeAborted:                {CategoryDevice, true},
+	CodeSDCard:                 {CategoryDevice, true},
+	CodeDeviceBusy:             {CategoryDevice, true},
+	CodeDeviceNotResponding:    {CategoryDevice, true},
+	CodeFirmwareDowngrade:      {CategoryDevice, false},
+	CodeFirmwareDownloadFailed: {CategoryNetwork, true},
+	CodeCertDownloadFailed:     {CategoryNetwork, true},
+	CodeServerCheckFailed:      {CategoryNetwork, true},
+	CodeWrongBackupPassphrase:  {CategoryValidation, false},
+	CodeWrongBackupKeystore:    {CategoryValidation, false},
+	CodeBackupTargetFailed:     {CategoryNetwork, true},
+	CodeFeatureDisabled:        {CategoryValidation, false},
+	CodeInternal:               {CategoryInternal, false},
+}
+
+// Error is a structured API error.
+type Error struct {
+	Code      Code
+	Category  Category
+	Retryable bool
+	// MessageKey is the translation key of the message in the frontend, "error.<code>".
+	MessageKey string
+	// Message is the untranslated error message, for logging and as a fallback.
+	Message string
+}
+
+// New creates an error with the given code. The category and the retryable flag are determined by
+// the code. Unknown codes are treated as internal errors.
+func New(code Code, message string) *Error {
+	info, ok := codes[code]
+	if !ok {
+		info = codes[CodeInternal]
+	}
+	return &Error{
+		Code:       code,
+		Category:   info.category,
+		Retryable:  info.retryable,
+		MessageKey: "error." + string(code),
+		Message:    message,
+	}
+}
+
+// Wrap creates an error with the given code and the message of err.
+func Wrap(code Code, err error) *Error {
+	return New(code, err.Error())
+}
+
+// FromError returns the structured error wrapped in err, or an internal error if there is none.
+func FromError(err error) *Error {
+	if apiErr, ok := errp.Cause(err).(*Error); ok {
+		return apiErr
+	}
+	return Wrap(CodeInternal, err)
+}
+
+// Error implements the error interface.
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// Response returns the JSON response of a failed request. "errorMessage" is kept for clients which
+// only display the message.
+func (err *Error) Response() map[string]interface{} {
+	return map[string]interface{}{
+		"success":       false,
+		"errorCode":     err.Code,
+		"errorCategory": err.Category,
+		"retryable":     err.Retryable,
+		"messageKey":    err.MessageKey,
+		"errorMessage":  err.Message,
+	}
+}
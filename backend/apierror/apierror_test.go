@@ -0,0 +1,57 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apierror_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	err := apierror.New(apierror.CodeInsufficientFunds, "insufficient funds")
+	require.Equal(t, apierror.CategoryValidation, err.Category)
+	require.False(t, err.Retryable)
+	require.Equal(t, "error.insufficientFunds", err.MessageKey)
+	require.Equal(t, "insufficient funds", err.Error())
+
+	err = apierror.New(apierror.CodeAborted, "aborted")
+	require.Equal(t, apierror.CategoryDevice, err.Category)
+	require.True(t, err.Retryable)
+
+	err = apierror.New(apierror.Code("unknown"), "unknown")
+	require.Equal(t, apierror.CategoryInternal, err.Category)
+}
+
+func TestFromError(t *testing.T) {
+	apiErr := apierror.New(apierror.CodeDevice, "device error")
+	require.Equal(t, apiErr, apierror.FromError(errp.WithMessage(apiErr, "context")))
+
+	err := apierror.FromError(errors.New("unexpected"))
+	require.Equal(t, apierror.CodeInternal, err.Code)
+	require.Equal(t, "unexpected", err.Message)
+}
+
+func TestResponse(t *testing.T) {
+	response := apierror.New(apierror.CodeInvalidAmount, "invalid amount").Response()
+	require.Equal(t, false, response["success"])
+	require.Equal(t, apierror.CodeInvalidAmount, response["errorCode"])
+	require.Equal(t, apierror.CategoryValidation, response["errorCategory"])
+	require.Equal(t, "error.invalidAmount", response["messageKey"])
+	require.Equal(t, "invalid amount", response["errorMessage"])
+}
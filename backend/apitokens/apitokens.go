@@ -0,0 +1,171 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apitokens manages scoped, revocable read-only tokens for the local API, which can be
+// given to external dashboards without exposing full control over the wallet.
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// Scope grants access to a group of read-only endpoints.
+type Scope string
+
+const (
+	// ScopeBalances grants access to the accounts, their balances, charts and exchange rates.
+	ScopeBalances Scope = "balances"
+	// ScopeHistory grants access to the transaction history.
+	ScopeHistory Scope = "history"
+	// ScopeAddresses includes addresses in the responses. Without it, addresses are removed.
+	ScopeAddresses Scope = "addresses"
+)
+
+// Token is a read-only API token. Only the hash of the secret is stored.
+type Token struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Scopes     []Scope   `json:"scopes"`
+	Created    time.Time `json:"created"`
+	SecretHash string    `json:"secretHash"`
+}
+
+// HasScope returns true if the token was granted the scope.
+func (token *Token) HasScope(scope Scope) bool {
+	for _, tokenScope := range token.Scopes {
+		if tokenScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists the tokens in a JSON file.
+type Store struct {
+	filename string
+	tokens   []*Token
+	lock     locker.Locker
+}
+
+// NewStore creates a store persisted in the given file, loading the existing tokens if the file
+// exists.
+func NewStore(filename string) *Store {
+	store := &Store{filename: filename, tokens: []*Token{}}
+	store.load()
+	return store
+}
+
+func (store *Store) load() {
+	jsonBytes, err := ioutil.ReadFile(store.filename)
+	if err != nil {
+		return
+	}
+	tokens := []*Token{}
+	if err := json.Unmarshal(jsonBytes, &tokens); err != nil {
+		return
+	}
+	store.tokens = tokens
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.MarshalIndent(store.tokens, "", "  ")
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(length int) (string, error) {
+	randomBytes := make([]byte, length)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", errp.WithStack(err)
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// Mint creates a new token with the given scopes. The returned secret is not stored and can't be
+// retrieved later.
+func (store *Store) Mint(name string, scopes []Scope) (*Token, string, error) {
+	for _, scope := range scopes {
+		if scope != ScopeBalances && scope != ScopeHistory && scope != ScopeAddresses {
+			return nil, "", errp.Newf("unknown scope %s", scope)
+		}
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token := &Token{
+		ID:         id,
+		Name:       name,
+		Scopes:     scopes,
+		Created:    time.Now(),
+		SecretHash: hashSecret(secret),
+	}
+	defer store.lock.Lock()()
+	store.tokens = append(store.tokens, token)
+	if err := store.save(); err != nil {
+		store.tokens = store.tokens[:len(store.tokens)-1]
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// Tokens returns all tokens.
+func (store *Store) Tokens() []*Token {
+	defer store.lock.RLock()()
+	return append([]*Token{}, store.tokens...)
+}
+
+// Revoke deletes the token with the given ID.
+func (store *Store) Revoke(id string) error {
+	defer store.lock.Lock()()
+	for index, token := range store.tokens {
+		if token.ID == id {
+			store.tokens = append(store.tokens[:index], store.tokens[index+1:]...)
+			return store.save()
+		}
+	}
+	return errp.Newf("unknown token %s", id)
+}
+
+// Lookup returns the token with the given secret, or nil if there is none.
+func (store *Store) Lookup(secret string) *Token {
+	secretHash := []byte(hashSecret(secret))
+	defer store.lock.RLock()()
+	for _, token := range store.tokens {
+		if subtle.ConstantTimeCompare(secretHash, []byte(token.SecretHash)) == 1 {
+			return token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apitokens_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apitokens"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	filename := path.Join(test.TstTempDir("apitokens-"), "api-tokens.json")
+	store := apitokens.NewStore(filename)
+	require.Empty(t, store.Tokens())
+
+	_, _, err := store.Mint("dashboard", []apitokens.Scope{"everything"})
+	require.Error(t, err)
+
+	token, secret, err := store.Mint("dashboard", []apitokens.Scope{apitokens.ScopeBalances})
+	require.NoError(t, err)
+	require.True(t, token.HasScope(apitokens.ScopeBalances))
+	require.False(t, token.HasScope(apitokens.ScopeAddresses))
+	require.NotContains(t, token.SecretHash, secret)
+	require.Equal(t, token.ID, store.Lookup(secret).ID)
+	require.Nil(t, store.Lookup("wrong"))
+
+	// Tokens are persisted.
+	store = apitokens.NewStore(filename)
+	require.Len(t, store.Tokens(), 1)
+	require.Equal(t, token.ID, store.Lookup(secret).ID)
+
+	require.NoError(t, store.Revoke(token.ID))
+	require.Nil(t, store.Lookup(secret))
+	require.Error(t, store.Revoke(token.ID))
+}
@@ -44,6 +44,14 @@ type Arguments struct {
 	// devmode stores whether the application is in dev mode and, therefore, connects to the dev environment
 	devmode bool
 
+	// demoFixture stores the path to the fixture file loaded in demo mode, or is empty if the
+	// application is not in demo mode.
+	demoFixture string
+
+	// simulator stores the TCP address of a device simulator to connect to, or is empty if the
+	// application only talks to USB devices.
+	simulator string
+
 	// log is the logger for this context
 	log *logrus.Entry
 }
@@ -55,12 +63,30 @@ func NewArguments(
 	regtest bool,
 	multisig bool,
 	devmode bool,
+	demoFixture string,
+	simulator string,
 ) *Arguments {
 	if !testing && regtest {
 		panic("Cannot use -regtest with -mainnet.")
 	}
+	if !testing && demoFixture != "" {
+		panic("Cannot use -demo with -mainnet.")
+	}
 
 	cacheDirectoryPath := path.Join(mainDirectoryPath, "cache")
+	configFilename := path.Join(mainDirectoryPath, "config.json")
+	if demoFixture != "" {
+		// The demo data must neither mix with real data nor survive a restart, so that every
+		// demo starts from the same state.
+		cacheDirectoryPath = path.Join(mainDirectoryPath, "demo-cache")
+		configFilename = path.Join(mainDirectoryPath, "demo-config.json")
+		if err := os.RemoveAll(cacheDirectoryPath); err != nil {
+			panic("Cannot remove the demo cache directory.")
+		}
+		if err := os.RemoveAll(configFilename); err != nil {
+			panic("Cannot remove the demo config file.")
+		}
+	}
 	if err := os.MkdirAll(cacheDirectoryPath, 0700); err != nil {
 		panic("Cannot create the cache directory.")
 	}
@@ -69,11 +95,13 @@ func NewArguments(
 	arguments := &Arguments{
 		mainDirectoryPath:  mainDirectoryPath,
 		cacheDirectoryPath: cacheDirectoryPath,
-		configFilename:     path.Join(mainDirectoryPath, "config.json"),
+		configFilename:     configFilename,
 		testing:            testing,
 		regtest:            regtest,
 		multisig:           multisig,
 		devmode:            devmode,
+		demoFixture:        demoFixture,
+		simulator:          simulator,
 		log:                log,
 	}
 
@@ -117,3 +145,15 @@ func (arguments *Arguments) Regtest() bool {
 func (arguments *Arguments) Multisig() bool {
 	return arguments.multisig
 }
+
+// DemoFixture returns the path to the fixture file of the demo mode, or an empty string if the
+// backend is not in demo mode.
+func (arguments *Arguments) DemoFixture() string {
+	return arguments.demoFixture
+}
+
+// Simulator returns the TCP address of the device simulator, or an empty string if no simulator
+// is used.
+func (arguments *Arguments) Simulator() string {
+	return arguments.simulator
+}
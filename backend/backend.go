@@ -19,30 +19,52 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"path"
+	"runtime"
 
 	"golang.org/x/text/language"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/cloudfoundry-attic/jibber_jabber"
 	"github.com/sirupsen/logrus"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/activity"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apitokens"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bookmarks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bridge"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/customcoin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coinmetadata"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/demo"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/denylist"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/doctor"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/i18n"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/remotesigner"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/fixtures"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/resolver"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/system"
 )
 
 type backendEvent struct {
@@ -74,20 +96,99 @@ type Backend struct {
 	events chan interface{}
 
 	devices         map[string]device.Interface
+	usbManager      *usb.Manager
 	keystores       keystore.Keystores
 	onAccountInit   func(*btc.Account)
 	onAccountUninit func(*btc.Account)
 	onDeviceInit    func(device.Interface)
 	onDeviceUninit  func(string)
 
+	// activeDeviceID is the device whose keystore is used by the accounts, keystoreDevices the
+	// unlocked devices by ID. See SelectDevice().
+	activeDeviceID      string
+	keystoreDevices     map[string]device.Interface
+	deviceSelectionLock locker.Locker
+
+	// deviceFingerprints are the fingerprints of the unlocked devices by device ID. The lock also
+	// serializes the changes of the pairing registry, see recordPairedDevice().
+	deviceFingerprints map[string]string
+	pairedDevicesLock  locker.Locker
+
 	coins     map[string]coin.Coin
 	coinsLock locker.Locker
 
 	accounts     []*btc.Account
 	accountsLock locker.Locker
+	// accountKeypaths are the keypaths of the accounts, collected in initAccounts(). Guarded by
+	// accountsLock.
+	accountKeypaths []signing.AbsoluteKeypath
+
+	// extendedPublicKeys are the extended public keys of the keystores by account keypath, see
+	// prefetchExtendedPublicKeys().
+	extendedPublicKeys     map[string][]*hdkeychain.ExtendedKey
+	extendedPublicKeysLock locker.Locker
 
 	// Stored and exposed temporarily through the backend.
 	ratesUpdater coin.RatesUpdater
+	rateHistory  *btc.RateHistory
+
+	// apiTokens are the read-only tokens for external dashboards, see APITokens().
+	apiTokens *apitokens.Store
+
+	// bookmarks are the recipient addresses signed by the keystores, see AddBookmark().
+	bookmarks *bookmarks.Store
+
+	// denylist holds the address lists checked before sending, see ImportDenylist().
+	denylist *denylist.Store
+
+	// bridge serves the keystores to web wallets if enabled, see SetBridge().
+	bridge *bridge.Bridge
+
+	// remoteSignerStore keeps the apps paired for remote signing, and remoteSigner serves the
+	// keystores to them in signer mode. Both are created on first use, see remoteSigning().
+	remoteSignerStore *remotesigner.Store
+	remoteSigner      *remotesigner.Signer
+	// remoteKeystore is the keystore of the connected signer, see ConnectRemoteSigner().
+	remoteKeystore *remotesigner.Keystore
+	// remotePairingCode is shown while a signer is being paired, see PairRemoteSigner().
+	remotePairingCode string
+	remoteSignerLock  locker.Locker
+
+	// activity is the persisted feed of payments, device events and alerts, see Activity().
+	activity *activity.Store
+
+	// charts maps fiat currencies to precomputed chart data, see Chart().
+	charts     map[string]*chartData
+	chartsLock locker.Locker
+
+	// customCoins are the verified custom coin definitions by coin code, see loadCustomCoins().
+	// Guarded by coinsLock.
+	customCoins map[string]*customcoin.Definition
+
+	// coinMetadata provides the logos, decimals and block explorers of the built in coins.
+	coinMetadata *coinmetadata.Registry
+
+	// features are the feature flags, which are updated by signed rules.
+	features *features.Flags
+
+	// firmwareChannel provides the BitBox firmware releases newer than the bundled firmware.
+	firmwareChannel *bitbox.FirmwareChannel
+
+	// egress is the policy checked for all outbound connections, see egressDestinations().
+	egress *egress.Policy
+
+	// doh is the DNS-over-HTTPS resolver, see lookupIP().
+	doh     *resolver.DoH
+	dohLock locker.Locker
+
+	// dataDirectoryReport is the result of the data directory checks on startup.
+	dataDirectoryReport *doctor.Report
+
+	// demoFixture is the fixture served in demo mode, or nil if the backend is not in demo mode.
+	demoFixture *demo.Fixture
+	// electrumRecorder records the Electrum traffic of the coins while it is started, see
+	// SetElectrumRecording().
+	electrumRecorder *fixtures.Recorder
 
 	log *logrus.Entry
 }
@@ -95,17 +196,95 @@ type Backend struct {
 // NewBackend creates a new backend with the given arguments.
 func NewBackend(arguments *arguments.Arguments) *Backend {
 	log := logging.Get().WithGroup("backend")
-	return &Backend{
+	var demoFixture *demo.Fixture
+	if arguments.DemoFixture() != "" {
+		var err error
+		demoFixture, err = demo.LoadFixture(arguments.DemoFixture())
+		if err != nil {
+			log.WithError(err).Panic("Failed to load the demo fixture")
+		}
+	}
+	// Runs before any file in the data directory is opened.
+	dataDirectoryReport := doctor.Run(arguments.ConfigFilename(),
+		[]string{arguments.MainDirectoryPath(), arguments.CacheDirectoryPath()}, log)
+	backend := &Backend{
 		arguments: arguments,
 		config:    config.NewConfig(arguments.ConfigFilename()),
 		events:    make(chan interface{}, 1000),
 
-		devices:      map[string]device.Interface{},
-		keystores:    keystore.NewKeystores(),
-		coins:        map[string]coin.Coin{},
-		ratesUpdater: btc.NewRatesUpdater(),
-		log:          log,
+		devices:     map[string]device.Interface{},
+		keystores:   keystore.NewKeystores(),
+		coins:       map[string]coin.Coin{},
+		customCoins: map[string]*customcoin.Definition{},
+		charts:      map[string]*chartData{},
+		apiTokens:   apitokens.NewStore(path.Join(arguments.MainDirectoryPath(), "api-tokens.json")),
+		bookmarks: bookmarks.NewStore(
+			path.Join(arguments.MainDirectoryPath(), "address-bookmarks.json"), log),
+		denylist: denylist.NewStore(
+			path.Join(arguments.MainDirectoryPath(), "address-denylists.json"),
+			path.Join(arguments.MainDirectoryPath(), "denylists")),
+		activity:    activity.NewStore(path.Join(arguments.MainDirectoryPath(), "activity.json")),
+		demoFixture: demoFixture,
+		electrumRecorder: electrum.NewFixtureRecorder(
+			path.Join(arguments.MainDirectoryPath(), "electrum-fixture.json")),
+		log: log,
+
+		dataDirectoryReport: dataDirectoryReport,
+		keystoreDevices:     map[string]device.Interface{},
+		deviceFingerprints:  map[string]string{},
 	}
+	publicKey, err := signeddata.TrustedPublicKey()
+	if err != nil {
+		log.WithError(err).Info("Coin metadata updates are disabled")
+		publicKey = nil
+	}
+	backend.coinMetadata = coinmetadata.NewRegistry(
+		path.Join(arguments.MainDirectoryPath(), "coin-metadata.json"), publicKey,
+		logging.Get().WithGroup("coinmetadata"))
+	backend.features = features.NewFlags(
+		backend.config, runtime.GOOS, path.Join(arguments.MainDirectoryPath(), "features.json"),
+		publicKey, logging.Get().WithGroup("features"))
+	firmwareSigningKeys, err := bitbox.FirmwareSigningKeys()
+	if err != nil {
+		log.WithError(err).Info("Firmware updates are disabled")
+		firmwareSigningKeys = nil
+	}
+	backend.firmwareChannel = bitbox.NewFirmwareChannel(
+		path.Join(arguments.MainDirectoryPath(), "firmware"), firmwareSigningKeys,
+		logging.Get().WithGroup("firmware"))
+	backend.egress = egress.NewPolicy(backend.egressDestinations, func() bool {
+		return backend.config.Config().Backend.Egress.Strict
+	})
+	egress.SetChecker(backend.egress)
+	egress.SetResolver(resolver.Func(backend.lookupIP))
+	egress.SetDialerConfig(backend.dialerConfig)
+	if demoFixture != nil {
+		backend.ratesUpdater = demo.NewRatesUpdater(demoFixture.Rates)
+		backend.rateHistory = btc.NewRateHistoryFromSource(demoFixture.DailyRates)
+	} else {
+		backend.ratesUpdater = btc.NewRatesUpdater(backend.config.Config().Backend.RatesStreamAPIKey)
+		backend.rateHistory = btc.NewRateHistory()
+	}
+	backend.usbManager = usb.NewManager(
+		arguments.MainDirectoryPath(),
+		backend.Register,
+		backend.Deregister,
+		func() {
+			backend.events <- backendEvent{Type: "devices", Data: "usbPermissionDenied"}
+		},
+	)
+	backend.usbManager.Observe(func(event observable.Event) { backend.events <- event })
+	if arguments.Simulator() != "" {
+		backend.usbManager.SetSimulator(arguments.Simulator())
+	}
+	if err := backend.usbManager.SetNoise(backend.config.Config().Backend.NoiseTransport); err != nil {
+		backend.log.WithError(err).Error("Failed to enable the Noise channel")
+	}
+	backend.bridge = bridge.NewBridge(backend,
+		path.Join(arguments.MainDirectoryPath(), "bridge-pairings.json"),
+		logging.Get().WithGroup("bridge"))
+	backend.bridge.Observe(func(event observable.Event) { backend.events <- event })
+	return backend
 }
 
 func (backend *Backend) addAccount(
@@ -119,17 +298,37 @@ func (backend *Backend) addAccount(
 		backend.log.WithField("code", code).WithField("name", name).Info("skipping inactive account")
 		return
 	}
+	backend.createAccount(coin, code, name, keypath, scriptType)
+}
+
+func (backend *Backend) createAccount(
+	coin coin.Coin,
+	code string,
+	name string,
+	keypath string,
+	scriptType signing.ScriptType,
+) {
 	backend.log.WithField("code", code).WithField("name", name).Info("init account")
 	onEvent := func(code string) func(btc.Event) {
 		return func(event btc.Event) {
 			backend.events <- AccountEvent{Type: "account", Code: code, Data: string(event)}
+			go backend.recordAccountActivity(code, event)
+			if event == btc.EventSyncDone {
+				go backend.checkColdStorage(code)
+				go backend.updateCharts()
+			}
 		}
 	}
 	absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
 	if err != nil {
 		panic(err)
 	}
+	backend.accountKeypaths = append(backend.accountKeypaths, absoluteKeypath)
 	getSigningConfiguration := func() (*signing.Configuration, error) {
+		if extendedPublicKeys, ok := backend.prefetchedExtendedPublicKeys(absoluteKeypath); ok {
+			return signing.NewConfiguration(
+				scriptType, absoluteKeypath, extendedPublicKeys, backend.keystores.Count()), nil
+		}
 		return backend.keystores.Configuration(scriptType, absoluteKeypath, backend.keystores.Count())
 	}
 	if backend.arguments.Multisig() {
@@ -138,7 +337,8 @@ func (backend *Backend) addAccount(
 	switch specificCoin := coin.(type) {
 	case *btc.Coin:
 		account := btc.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(), code, name,
-			getSigningConfiguration, backend.keystores, onEvent(code), backend.log)
+			getSigningConfiguration, backend.keystores, backend.config, backend.denylist, onEvent(code),
+			backend.log)
 		backend.accounts = append(backend.accounts, account)
 	default:
 		panic("unknown coin type")
@@ -225,6 +425,9 @@ O3nOxjgSfRAfKWQ2Ny1APKcn6I83P5PFLhtO5I12
 }
 
 func (backend *Backend) defaultServers(code string) []*rpc.ServerInfo {
+	if definition, ok := backend.customCoins[code]; ok {
+		return definition.ElectrumServers
+	}
 	if backend.arguments.DevMode() {
 		return defaultDevServers(code)
 	}
@@ -246,15 +449,24 @@ func (backend *Backend) Coin(code string) coin.Coin {
 		servers = []*rpc.ServerInfo{{"127.0.0.1:52001", false, ""}}
 		coin = btc.NewCoin("rbtc", "RBTC", &chaincfg.RegressionNetParams, dbFolder, servers, "", nil)
 	case "tbtc":
-		coin = btc.NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, dbFolder, servers, "https://testnet.blockchain.info/tx/", backend.ratesUpdater)
+		coin = btc.NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, dbFolder, servers, backend.explorerTxPrefix("tbtc"), backend.ratesUpdater)
 	case "btc":
-		coin = btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, dbFolder, servers, "https://blockchain.info/tx/", backend.ratesUpdater)
+		coin = btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, dbFolder, servers, backend.explorerTxPrefix("btc"), backend.ratesUpdater)
 	case "tltc":
-		coin = btc.NewCoin("tltc", "TLTC", &ltc.TestNet4Params, dbFolder, servers, "http://explorer.litecointools.com/tx/", backend.ratesUpdater)
+		coin = btc.NewCoin("tltc", "TLTC", &ltc.TestNet4Params, dbFolder, servers, backend.explorerTxPrefix("tltc"), backend.ratesUpdater)
 	case "ltc":
-		coin = btc.NewCoin("ltc", "LTC", &ltc.MainNetParams, dbFolder, servers, "https://insight.litecore.io/tx/", backend.ratesUpdater)
+		coin = btc.NewCoin("ltc", "LTC", &ltc.MainNetParams, dbFolder, servers, backend.explorerTxPrefix("ltc"), backend.ratesUpdater)
 	default:
-		panic(errp.Newf("unknown coin code %s", code))
+		coin = backend.newCustomCoin(code, dbFolder, servers)
+		if coin == nil {
+			panic(errp.Newf("unknown coin code %s", code))
+		}
+	}
+	if backend.demoFixture != nil {
+		backend.initDemoBlockchain(coin.(*btc.Coin), code)
+	} else {
+		coin.(*btc.Coin).SetBlockchain(electrum.NewRecordedElectrumConnection(
+			servers, backend.electrumRecorder, logging.Get().WithGroup("coin").WithField("name", code)))
 	}
 	coin.Init()
 	coin.Observe(func(event observable.Event) { backend.events <- event })
@@ -268,6 +480,8 @@ func (backend *Backend) initAccounts() {
 	defer backend.accountsLock.Lock()()
 
 	backend.accounts = []*btc.Account{}
+	backend.accountKeypaths = []signing.AbsoluteKeypath{}
+	backend.loadCustomCoins()
 	if backend.arguments.Testing() {
 		if backend.arguments.Regtest() {
 			RBTC := backend.Coin("rbtc")
@@ -293,9 +507,12 @@ func (backend *Backend) initAccounts() {
 		backend.addAccount(LTC, "ltc-p2wpkh-p2sh", "Litecoin", "m/49'/2'/0'", signing.ScriptTypeP2WPKHP2SH)
 		backend.addAccount(LTC, "ltc-p2wpkh", "Litecoin: bech32", "m/84'/2'/0'", signing.ScriptTypeP2WPKH)
 	}
+	backend.addCustomAccounts()
+	backend.prefetchExtendedPublicKeys()
 	for _, account := range backend.accounts {
 		backend.onAccountInit(account)
 	}
+	go backend.startAccounts(append([]*btc.Account{}, backend.accounts...))
 }
 
 // AccountsStatus returns whether the accounts have been initialized.
@@ -311,9 +528,11 @@ func (backend *Backend) Testing() bool {
 	return backend.arguments.Testing()
 }
 
-// Accounts returns the supported accounts.
+// Accounts returns the supported accounts. The slice is a copy, so it can be used without holding
+// the accounts lock.
 func (backend *Backend) Accounts() []*btc.Account {
-	return backend.accounts
+	defer backend.accountsLock.RLock()()
+	return append([]*btc.Account{}, backend.accounts...)
 }
 
 // UserLanguage returns the language the UI should be presented in to the user.
@@ -322,15 +541,28 @@ func (backend *Backend) UserLanguage() language.Tag {
 	if err != nil {
 		return language.English
 	}
-	languages := []language.Tag{
-		language.English,
-		language.German,
-	}
-	tag, _, _ := language.NewMatcher(languages).Match(language.Make(userLocale))
+	tag := i18n.Match(userLocale)
 	backend.log.WithField("user-language", tag).Debug("Detected user language")
 	return tag
 }
 
+// APITokens returns the store of read-only API tokens, which can be given to external dashboards.
+func (backend *Backend) APITokens() *apitokens.Store {
+	return backend.apiTokens
+}
+
+// Localizer returns the localizer for messages originating in the backend. The language is the one
+// chosen in the frontend, which stores it in the frontend config. Before the frontend has stored a
+// language, the system language is used.
+func (backend *Backend) Localizer() *i18n.Localizer {
+	if frontendConfig, ok := backend.config.Config().Frontend.(map[string]interface{}); ok {
+		if userLanguage, ok := frontendConfig["userLanguage"].(string); ok && userLanguage != "" {
+			return i18n.NewLocalizer(i18n.Match(userLanguage))
+		}
+	}
+	return i18n.NewLocalizer(backend.UserLanguage())
+}
+
 // OnAccountInit installs a callback to be called when an account is initialized.
 func (backend *Backend) OnAccountInit(f func(*btc.Account)) {
 	backend.onAccountInit = f
@@ -354,7 +586,21 @@ func (backend *Backend) OnDeviceUninit(f func(string)) {
 // Start starts the background services. It returns a channel of events to handle by the library
 // client.
 func (backend *Backend) Start() <-chan interface{} {
+	if backend.demoFixture != nil {
+		backend.startDemo()
+		return backend.events
+	}
 	go backend.listenHID()
+	backend.startDrivers()
+	go backend.refreshCoinMetadata()
+	go backend.refreshFirmwareReleases()
+	go backend.refreshFeatures()
+	backend.startRemoteSigner()
+	if bridgeConfig := backend.config.Config().Backend.Bridge; bridgeConfig.Enabled {
+		if err := backend.bridge.Start(bridgeConfig.Port); err != nil {
+			backend.log.WithError(err).Error("Failed to start the bridge")
+		}
+	}
 	go func() {
 		err := backend.checkForUpdate()
 		if err != nil {
@@ -386,6 +632,7 @@ func (backend *Backend) uninitAccounts() {
 		account.Close()
 	}
 	backend.accounts = nil
+	backend.clearExtendedPublicKeys()
 }
 
 // Keystores returns the keystores registered at this backend.
@@ -418,31 +665,19 @@ func (backend *Backend) DeregisterKeystore() {
 func (backend *Backend) Register(theDevice device.Interface) error {
 	backend.devices[theDevice.Identifier()] = theDevice
 	backend.onDeviceInit(theDevice)
+	if bitboxDevice, ok := theDevice.(*bitbox.Device); ok {
+		bitboxDevice.SetRelayServer(relay.Server(backend.config.Config().Backend.RelayServer))
+	}
 	theDevice.Init(backend.Testing())
 
-	mainKeystore := len(backend.devices) == 1
 	theDevice.SetOnEvent(func(event device.Event, data interface{}) {
 		switch event {
 		case device.EventKeystoreGone:
-			backend.DeregisterKeystore()
+			backend.forgetDeviceFingerprint(theDevice.Identifier())
+			backend.onKeystoreGone(theDevice.Identifier())
 		case device.EventKeystoreAvailable:
-			// absoluteKeypath := signing.NewEmptyAbsoluteKeypath().Child(44, signing.Hardened)
-			// extendedPublicKey, err := backend.device.ExtendedPublicKey(absoluteKeypath)
-			// if err != nil {
-			// 	panic(err)
-			// }
-			// configuration := signing.NewConfiguration(absoluteKeypath,
-			// 	[]*hdkeychain.ExtendedKey{extendedPublicKey}, 1)
-			if backend.arguments.Multisig() {
-				backend.RegisterKeystore(
-					theDevice.KeystoreForConfiguration(nil, backend.keystores.Count()))
-			} else if mainKeystore {
-				// HACK: for device based, only one is supported at the moment.
-				backend.keystores = keystore.NewKeystores()
-
-				backend.RegisterKeystore(
-					theDevice.KeystoreForConfiguration(nil, backend.keystores.Count()))
-			}
+			backend.recordPairedDevice(theDevice)
+			backend.onKeystoreAvailable(theDevice)
 		}
 		backend.events <- deviceEvent{
 			DeviceID: theDevice.Identifier(),
@@ -458,21 +693,103 @@ func (backend *Backend) Register(theDevice device.Interface) error {
 	}:
 	default:
 	}
+	go backend.recordActivity(&activity.Entry{
+		Type:  activity.TypeDevice,
+		Event: "registered",
+		Data:  map[string]string{"deviceID": theDevice.Identifier(), "product": theDevice.ProductName()},
+	})
 	return nil
 }
 
 // Deregister deregisters the device with the given ID from this backend.
 func (backend *Backend) Deregister(deviceID string) {
-	if _, ok := backend.devices[deviceID]; ok {
+	if theDevice, ok := backend.devices[deviceID]; ok {
 		backend.onDeviceUninit(deviceID)
 		delete(backend.devices, deviceID)
-		backend.DeregisterKeystore()
+		backend.forgetDeviceFingerprint(deviceID)
+		backend.onKeystoreGone(deviceID)
 		backend.events <- backendEvent{Type: "devices", Data: "registeredChanged"}
+		go backend.recordActivity(&activity.Entry{
+			Type:  activity.TypeDevice,
+			Event: "deregistered",
+			Data:  map[string]string{"deviceID": deviceID, "product": theDevice.ProductName()},
+		})
 	}
 }
 
 func (backend *Backend) listenHID() {
-	usb.NewManager(backend.arguments.MainDirectoryPath(), backend.Register, backend.Deregister).ListenHID()
+	backend.usbManager.ListenHID()
+}
+
+// startDrivers starts the drivers registered with device.RegisterDriver(). Their devices are
+// registered like the ones found by the USB manager.
+func (backend *Backend) startDrivers() {
+	for _, driver := range device.Drivers() {
+		backend.log.WithField("driver", driver.Name()).Info("Starting the device driver")
+		if err := driver.Start(backend.Register, backend.Deregister); err != nil {
+			backend.log.WithError(err).WithField("driver", driver.Name()).
+				Error("Failed to start the device driver")
+		}
+	}
+}
+
+// USBPermissionDenied returns true if a device is plugged in, but can't be accessed due to missing
+// permissions.
+func (backend *Backend) USBPermissionDenied() bool {
+	return backend.usbManager.PermissionDenied()
+}
+
+// USBDiagnostics returns information about the USB layer for troubleshooting.
+func (backend *Backend) USBDiagnostics() *usb.Diagnostics {
+	return backend.usbManager.Diagnostics()
+}
+
+// USBHotplugEvents returns the most recent events about inserted, removed and rejected devices.
+func (backend *Backend) USBHotplugEvents() []*usb.HotplugEvent {
+	return backend.usbManager.HotplugEvents()
+}
+
+// USBCapture returns the state of the capture of the USB traffic.
+func (backend *Backend) USBCapture() *usb.CaptureStatus {
+	return backend.usbManager.Capture().Status()
+}
+
+// SetUSBCapture starts or stops capturing the USB traffic of all devices to a file in the config
+// dir. Encrypted payloads and passwords are redacted.
+func (backend *Backend) SetUSBCapture(enabled bool) error {
+	capture := backend.usbManager.Capture()
+	if !enabled {
+		backend.log.Info("Stopping the USB capture")
+		return capture.Stop()
+	}
+	backend.log.WithField("filename", capture.Status().Filename).Info("Starting the USB capture")
+	return capture.Start()
+}
+
+// ElectrumRecording returns the state of the recording of the Electrum traffic.
+func (backend *Backend) ElectrumRecording() *fixtures.RecorderStatus {
+	return backend.electrumRecorder.Status()
+}
+
+// SetElectrumRecording starts or stops recording the Electrum traffic of all coins. When it is
+// stopped, the sanitized traffic is written to a fixture file in the app dir, which can be replayed
+// in tests to reproduce sync issues.
+func (backend *Backend) SetElectrumRecording(enabled bool) error {
+	if !enabled {
+		backend.log.Info("Stopping the Electrum recording")
+		return backend.electrumRecorder.Stop()
+	}
+	backend.log.WithField("filename", backend.electrumRecorder.Status().Filename).
+		Info("Starting the Electrum recording")
+	return backend.electrumRecorder.Start()
+}
+
+// InstallUdevRules installs the udev rules needed to access the device on Linux. The user is
+// prompted for the administrator password.
+func (backend *Backend) InstallUdevRules() error {
+	backend.log.Info("Installing udev rules")
+	return system.WriteFileElevated(usb.UdevRulesFilename, []byte(usb.UdevRules),
+		"udevadm control --reload-rules", "udevadm trigger")
 }
 
 // Rates return the latest rates.
@@ -483,7 +800,7 @@ func (backend *Backend) Rates() map[string]map[string]float64 {
 // DownloadCert downloads the first element of the remote certificate chain.
 func (backend *Backend) DownloadCert(server string) (string, error) {
 	var pemCert []byte
-	conn, err := tls.Dial("tcp", server, &tls.Config{
+	conn, err := egress.DialTLS("tcp", server, &tls.Config{
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			if len(rawCerts) == 0 {
 				return errp.New("no remote certs")
@@ -498,7 +815,7 @@ func (backend *Backend) DownloadCert(server string) (string, error) {
 			return nil
 		},
 		InsecureSkipVerify: true,
-	})
+	}, egress.PurposeElectrum)
 	if err != nil {
 		return "", err
 	}
@@ -524,3 +841,9 @@ func (backend *Backend) CheckElectrumServer(server string, pemCert string) error
 	_, err = electrumClient.ServerVersion()
 	return err
 }
+
+// DataDirectoryReport returns the result of the data directory checks on startup, see package
+// doctor.
+func (backend *Backend) DataDirectoryReport() *doctor.Report {
+	return backend.dataDirectoryReport
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bookmarks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// BookmarkStatus is a bookmark together with the result of validating its signatures against the
+// connected keystores.
+type BookmarkStatus struct {
+	*bookmarks.Bookmark
+	Status bookmarks.Status
+}
+
+// bookmarkCoin returns the coin of the loaded accounts with the given code.
+func (backend *Backend) bookmarkCoin(coinCode string) (*btc.Coin, error) {
+	for _, account := range backend.Accounts() {
+		if account.Coin().Name() == coinCode {
+			return account.Coin(), nil
+		}
+	}
+	return nil, errp.WithStack(btc.TxValidationError("unknown coin"))
+}
+
+// AddBookmark signs the address with the connected keystores and stores it as a bookmark. Hardware
+// keystores ask the user to confirm signing, but do not display the address, see package
+// bookmarks.
+func (backend *Backend) AddBookmark(coinCode, address, label string) (*bookmarks.Bookmark, error) {
+	coin, err := backend.bookmarkCoin(coinCode)
+	if err != nil {
+		return nil, err
+	}
+	decodedAddress, err := btcutil.DecodeAddress(address, coin.Net())
+	if err != nil || !decodedAddress.IsForNet(coin.Net()) {
+		return nil, errp.WithStack(btc.TxValidationError("invalid address"))
+	}
+	bookmark, err := bookmarks.Sign(backend.keystores, coinCode, decodedAddress.EncodeAddress(), label)
+	if err != nil {
+		return nil, err
+	}
+	backend.log.WithField("coin", coinCode).Info("Adding address bookmark")
+	if err := backend.bookmarks.Add(bookmark); err != nil {
+		return nil, err
+	}
+	return bookmark, nil
+}
+
+// Bookmarks returns all bookmarks with their validation status.
+func (backend *Backend) Bookmarks() ([]*BookmarkStatus, error) {
+	result := []*BookmarkStatus{}
+	for _, bookmark := range backend.bookmarks.Bookmarks() {
+		status, err := bookmark.Validate(backend.keystores)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &BookmarkStatus{Bookmark: bookmark, Status: status})
+	}
+	return result, nil
+}
+
+// RemoveBookmark deletes the bookmark with the given ID.
+func (backend *Backend) RemoveBookmark(id string) error {
+	return backend.bookmarks.Remove(id)
+}
+
+// CheckBookmark validates the bookmark of the given recipient address before sending to it. It
+// returns nil if the address is not bookmarked. If the status is bookmarks.StatusInvalid, the user
+// should be warned, as the stored address might have been tampered with.
+func (backend *Backend) CheckBookmark(coinCode, address string) (*BookmarkStatus, error) {
+	bookmark := backend.bookmarks.Lookup(coinCode, address)
+	if bookmark == nil {
+		return nil, nil
+	}
+	status, err := bookmark.Validate(backend.keystores)
+	if err != nil {
+		return nil, err
+	}
+	if status == bookmarks.StatusInvalid {
+		backend.log.WithField("coin", coinCode).Warning("Bookmark signature does not validate")
+	}
+	return &BookmarkStatus{Bookmark: bookmark, Status: status}, nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bookmarks stores recipient addresses, e.g. exchange deposit addresses. Each bookmark is
+// signed by the keystore, so that a bookmark which was tampered with after it was created, e.g. by
+// malware replacing the address on disk, can be detected before sending to it. The keystore does
+// not display the address when signing, so the signature does not prove that the address was
+// correct when it was bookmarked. The user has to verify it with the recipient before bookmarking.
+package bookmarks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/attestation"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+// keypath is the keypath of the key signing the bookmarks. It is not used for any coin.
+const keypath = "m/20180'/1'"
+
+// Status is the result of validating the signature of a bookmark.
+type Status string
+
+const (
+	// StatusValid means the bookmark was signed by the connected keystores.
+	StatusValid Status = "valid"
+	// StatusInvalid means the signature does not validate for the connected keystores, so the
+	// bookmark was modified or created by a different wallet.
+	StatusInvalid Status = "invalid"
+	// StatusNoKeystore means no keystore is connected to validate the bookmark with.
+	StatusNoKeystore Status = "noKeystore"
+)
+
+// Bookmark is a recipient address signed by the keystores.
+type Bookmark struct {
+	ID       string    `json:"id"`
+	CoinCode string    `json:"coinCode"`
+	Address  string    `json:"address"`
+	Label    string    `json:"label"`
+	Created  time.Time `json:"created"`
+	// Attestation are the signatures of hash() by the keys at keypath.
+	attestation.Attestation
+}
+
+// hash returns the hash signed by the keystores, committing to the coin, the address and the label.
+func (bookmark *Bookmark) hash() []byte {
+	message, _ := json.Marshal([]string{
+		"BitBox address bookmark", bookmark.CoinCode, bookmark.Address, bookmark.Label})
+	return chainhash.DoubleHashB(message)
+}
+
+// Validate checks the signatures of the bookmark against the public keys of the keystores.
+func (bookmark *Bookmark) Validate(keystores keystore.Keystores) (Status, error) {
+	if keystores.Count() == 0 {
+		return StatusNoKeystore, nil
+	}
+	valid, err := bookmark.Verify(keystores, keypath, bookmark.hash())
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		return StatusInvalid, nil
+	}
+	return StatusValid, nil
+}
+
+// Sign creates a bookmark signed by the keystores. Hardware keystores ask the user to confirm
+// signing on the device, but do not display the address.
+func Sign(keystores keystore.Keystores, coinCode, address, label string) (*Bookmark, error) {
+	if keystores.Count() == 0 {
+		return nil, errp.New("no keystore to sign the bookmark")
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	bookmark := &Bookmark{
+		ID:       hex.EncodeToString(idBytes),
+		CoinCode: coinCode,
+		Address:  address,
+		Label:    label,
+		Created:  time.Now(),
+	}
+	signed, err := attestation.Sign(keystores, keypath, bookmark.hash())
+	if err != nil {
+		return nil, err
+	}
+	bookmark.Attestation = *signed
+	return bookmark, nil
+}
+
+// Store persists the bookmarks in a JSON file.
+type Store struct {
+	filename  string
+	bookmarks []*Bookmark
+	lock      locker.Locker
+}
+
+// NewStore creates a store persisted in the given file, loading the existing bookmarks if the file
+// exists. If the file can't be read, the store starts empty and the error is logged. A corrupt file
+// is moved aside, so that it is not overwritten when a bookmark is added.
+func NewStore(filename string, log *logrus.Entry) *Store {
+	store := &Store{filename: filename, bookmarks: []*Bookmark{}}
+	if err := store.load(); err != nil {
+		log.WithError(err).Error("Could not load the address bookmarks")
+	}
+	return store
+}
+
+func (store *Store) load() error {
+	jsonBytes, err := ioutil.ReadFile(store.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	bookmarks := []*Bookmark{}
+	if err := json.Unmarshal(jsonBytes, &bookmarks); err != nil {
+		if renameErr := os.Rename(store.filename, store.filename+".corrupt"); renameErr != nil {
+			return errp.WithStack(renameErr)
+		}
+		return errp.WithMessage(err, "The bookmarks file is corrupt and was moved aside")
+	}
+	store.bookmarks = bookmarks
+	return nil
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.MarshalIndent(store.bookmarks, "", "  ")
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// Add stores the bookmark, replacing an existing bookmark of the same address.
+func (store *Store) Add(bookmark *Bookmark) error {
+	defer store.lock.Lock()()
+	previous := store.bookmarks
+	bookmarks := []*Bookmark{}
+	for _, existing := range store.bookmarks {
+		if existing.CoinCode != bookmark.CoinCode || existing.Address != bookmark.Address {
+			bookmarks = append(bookmarks, existing)
+		}
+	}
+	store.bookmarks = append(bookmarks, bookmark)
+	if err := store.save(); err != nil {
+		store.bookmarks = previous
+		return err
+	}
+	return nil
+}
+
+// Bookmarks returns all bookmarks.
+func (store *Store) Bookmarks() []*Bookmark {
+	defer store.lock.RLock()()
+	return append([]*Bookmark{}, store.bookmarks...)
+}
+
+// Remove deletes the bookmark with the given ID.
+func (store *Store) Remove(id string) error {
+	defer store.lock.Lock()()
+	for index, bookmark := range store.bookmarks {
+		if bookmark.ID == id {
+			store.bookmarks = append(store.bookmarks[:index], store.bookmarks[index+1:]...)
+			return store.save()
+		}
+	}
+	return errp.Newf("unknown bookmark %s", id)
+}
+
+// Lookup returns the bookmark of the given address, or nil if there is none.
+func (store *Store) Lookup(coinCode, address string) *Bookmark {
+	defer store.lock.RLock()()
+	for _, bookmark := range store.bookmarks {
+		if bookmark.CoinCode == coinCode && bookmark.Address == address {
+			return bookmark
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bookmarks_test
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bookmarks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+const address = "mhQVSMAwKGLJgzZJGQp5xn7CfxX3G6yUmC"
+
+func TestSignValidate(t *testing.T) {
+	keystores := keystore.NewKeystores(software.NewKeystoreFromPIN(0, "1234"))
+	bookmark, err := bookmarks.Sign(keystores, "tbtc", address, "exchange")
+	require.NoError(t, err)
+
+	status, err := bookmark.Validate(keystores)
+	require.NoError(t, err)
+	require.Equal(t, bookmarks.StatusValid, status)
+
+	status, err = bookmark.Validate(keystore.NewKeystores())
+	require.NoError(t, err)
+	require.Equal(t, bookmarks.StatusNoKeystore, status)
+
+	// Signed by a different wallet.
+	status, err = bookmark.Validate(keystore.NewKeystores(software.NewKeystoreFromPIN(0, "4321")))
+	require.NoError(t, err)
+	require.Equal(t, bookmarks.StatusInvalid, status)
+
+	// The address was replaced.
+	tampered := *bookmark
+	tampered.Address = "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"
+	status, err = tampered.Validate(keystores)
+	require.NoError(t, err)
+	require.Equal(t, bookmarks.StatusInvalid, status)
+
+	tampered = *bookmark
+	tampered.Signatures = []string{"nothex"}
+	status, err = tampered.Validate(keystores)
+	require.NoError(t, err)
+	require.Equal(t, bookmarks.StatusInvalid, status)
+}
+
+func TestStore(t *testing.T) {
+	log := logging.Get().WithGroup("bookmarks_test")
+	filename := path.Join(test.TstTempDir("bookmarks-"), "address-bookmarks.json")
+	store := bookmarks.NewStore(filename, log)
+	require.Empty(t, store.Bookmarks())
+
+	keystores := keystore.NewKeystores(software.NewKeystoreFromPIN(0, "1234"))
+	bookmark, err := bookmarks.Sign(keystores, "tbtc", address, "exchange")
+	require.NoError(t, err)
+	require.NoError(t, store.Add(bookmark))
+	require.Equal(t, bookmark.ID, store.Lookup("tbtc", address).ID)
+	require.Nil(t, store.Lookup("btc", address))
+
+	// Adding the same address again replaces the bookmark.
+	renamed, err := bookmarks.Sign(keystores, "tbtc", address, "renamed")
+	require.NoError(t, err)
+	require.NoError(t, store.Add(renamed))
+	require.Len(t, store.Bookmarks(), 1)
+
+	// Bookmarks are persisted and still validate.
+	store = bookmarks.NewStore(filename, log)
+	loaded := store.Lookup("tbtc", address)
+	require.Equal(t, "renamed", loaded.Label)
+	status, err := loaded.Validate(keystores)
+	require.NoError(t, err)
+	require.Equal(t, bookmarks.StatusValid, status)
+
+	require.NoError(t, store.Remove(renamed.ID))
+	require.Nil(t, store.Lookup("tbtc", address))
+	require.Error(t, store.Remove(renamed.ID))
+}
+
+func TestStoreCorruptFile(t *testing.T) {
+	log := logging.Get().WithGroup("bookmarks_test")
+	filename := path.Join(test.TstTempDir("bookmarks-"), "address-bookmarks.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("{corrupt"), 0600))
+	store := bookmarks.NewStore(filename, log)
+	require.Empty(t, store.Bookmarks())
+
+	// The corrupt file is kept, so that it is not overwritten by new bookmarks.
+	corrupt, err := ioutil.ReadFile(filename + ".corrupt")
+	require.NoError(t, err)
+	require.Equal(t, "{corrupt", string(corrupt))
+	keystores := keystore.NewKeystores(software.NewKeystoreFromPIN(0, "1234"))
+	bookmark, err := bookmarks.Sign(keystores, "tbtc", address, "exchange")
+	require.NoError(t, err)
+	require.NoError(t, store.Add(bookmark))
+	require.Len(t, bookmarks.NewStore(filename, log).Bookmarks(), 1)
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bridge"
+)
+
+// BridgePairingStatus is a bridge pairing together with the result of validating its signatures
+// against the connected keystores.
+type BridgePairingStatus struct {
+	*bridge.Pairing
+	Status bridge.PairingStatus
+}
+
+// BridgeStatus returns whether the bridge for web wallets is running.
+func (backend *Backend) BridgeStatus() *bridge.Status {
+	return backend.bridge.Status()
+}
+
+// SetBridge starts or stops the bridge for web wallets and stores the setting in the config, so
+// that the bridge is started again with the app.
+func (backend *Backend) SetBridge(enabled bool) error {
+	appConfig := backend.config.Config()
+	appConfig.Backend.Bridge.Enabled = enabled
+	if err := backend.config.Set(appConfig); err != nil {
+		return err
+	}
+	if !enabled {
+		return backend.bridge.Stop()
+	}
+	return backend.bridge.Start(appConfig.Backend.Bridge.Port)
+}
+
+// BridgePairings returns the web origins approved to use the bridge with their validation status.
+func (backend *Backend) BridgePairings() ([]*BridgePairingStatus, error) {
+	result := []*BridgePairingStatus{}
+	for _, pairing := range backend.bridge.Pairings() {
+		status, err := pairing.Validate(backend.keystores)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &BridgePairingStatus{Pairing: pairing, Status: status})
+	}
+	return result, nil
+}
+
+// RemoveBridgePairing revokes the approval of the web origin.
+func (backend *Backend) RemoveBridgePairing(origin string) error {
+	return backend.bridge.RemovePairing(origin)
+}
+
+// ReviewBridgePSBT approves or rejects signing the PSBT a web wallet asked to sign, after the user
+// reviewed it.
+func (backend *Backend) ReviewBridgePSBT(id string, approved bool) error {
+	return backend.bridge.ReviewPSBT(id, approved)
+}
@@ -0,0 +1,467 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bridge exposes the keystores over a WebSocket on localhost, so that web wallets can
+// request extended public keys and signatures through the app, similar to WebUSB/WebHID. Each web
+// origin has to be approved on the device once, see Pairing, and each PSBT has to be reviewed in
+// the app before it is signed. Only pages served over HTTPS, or from localhost during development,
+// can connect.
+package bridge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
+)
+
+// DefaultPort is the port the bridge listens on if none is configured. Web wallets connect to
+// ws://127.0.0.1:DefaultPort/.
+const DefaultPort = 8178
+
+// maxMessageSize is the maximum size of a request, large enough for PSBTs with many inputs.
+const maxMessageSize = 1 << 20
+
+// reviewTimeout is how long a PSBT waits for the user to review it in the app, see ReviewPSBT().
+const reviewTimeout = 5 * time.Minute
+
+// Error codes of failed requests.
+const (
+	ErrorCodeInvalidRequest = "invalidRequest"
+	ErrorCodeNoKeystore     = "noKeystore"
+	ErrorCodeNotPaired      = "notPaired"
+	ErrorCodeRejected       = "rejected"
+	ErrorCodeFailed         = "failed"
+)
+
+// Wallet provides the keystores and the signing of the app to the bridge.
+type Wallet interface {
+	Keystores() keystore.Keystores
+	// SignPSBT signs the inputs of the base64 encoded PSBT belonging to the accounts.
+	SignPSBT(string) (string, error)
+}
+
+// PendingPSBT is a PSBT which a paired origin asks to sign, waiting for the user to review it in
+// the app, see Bridge.ReviewPSBT().
+type PendingPSBT struct {
+	// ID identifies the request, so that a decision is not applied to another PSBT submitted after
+	// the review timed out.
+	ID     string `json:"id"`
+	Origin string `json:"origin"`
+	// PSBT is the base64 encoded PSBT, which the app summarizes for the review.
+	PSBT string `json:"psbt"`
+}
+
+// Status describes the state of the bridge server.
+type Status struct {
+	Running bool   `json:"running"`
+	Address string `json:"address"`
+	// PendingOrigin is the origin waiting for approval on the device, so that the app can show
+	// which page asks to be paired. Empty if there is none.
+	PendingOrigin string `json:"pendingOrigin"`
+	// PendingPSBT is nil if no PSBT waits for review.
+	PendingPSBT *PendingPSBT `json:"pendingPSBT"`
+}
+
+type request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type responseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	ID     int            `json:"id"`
+	Result interface{}    `json:"result,omitempty"`
+	Error  *responseError `json:"error,omitempty"`
+}
+
+// Bridge serves the WebSocket of the web wallets. Observers are notified with the status when an
+// approval is requested on the device, and when it is finished.
+type Bridge struct {
+	observable.Implementation
+
+	wallet   Wallet
+	pairings *Store
+	upgrader websocket.Upgrader
+
+	server   *http.Server
+	listener net.Listener
+	// conns are the open WebSocket connections, which are not closed by server.Close().
+	conns map[*websocket.Conn]struct{}
+	lock  locker.Locker
+
+	// approvalLock makes sure only one origin at a time asks for approval on the device or for the
+	// review of a PSBT.
+	approvalLock  locker.Locker
+	pendingOrigin string
+	pendingPSBT   *PendingPSBT
+	// review receives the decision of the user on the pending PSBT.
+	review chan *reviewDecision
+
+	log *logrus.Entry
+}
+
+// NewBridge creates a bridge with the pairings persisted in the given file. It does not listen
+// until Start() is called.
+func NewBridge(wallet Wallet, pairingsFilename string, log *logrus.Entry) *Bridge {
+	bridge := &Bridge{
+		wallet:   wallet,
+		pairings: NewStore(pairingsFilename),
+		conns:    map[*websocket.Conn]struct{}{},
+		review:   make(chan *reviewDecision, 1),
+		log:      log,
+	}
+	bridge.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     func(r *http.Request) bool { return allowedOrigin(r.Header.Get("Origin")) },
+	}
+	return bridge
+}
+
+// allowedOrigin returns whether pages of the origin may connect. Browsers always send the origin
+// with WebSocket requests, so requests without one come from other programs and are rejected.
+func allowedOrigin(origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" || parsed.Path != "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		return isLoopback(parsed.Hostname())
+	}
+	return false
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Start listens on the loopback interface at the given port, or DefaultPort if it is 0.
+func (bridge *Bridge) Start(port int) error {
+	defer bridge.lock.Lock()()
+	if bridge.listener != nil {
+		return nil
+	}
+	if port == 0 {
+		port = DefaultPort
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	bridge.listener = listener
+	bridge.server = &http.Server{Handler: bridge}
+	go func() {
+		if err := bridge.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			bridge.log.WithError(err).Error("The bridge server stopped")
+		}
+	}()
+	bridge.log.WithField("address", listener.Addr().String()).Info("Started the bridge")
+	return nil
+}
+
+// Stop closes the listener and all connections.
+func (bridge *Bridge) Stop() error {
+	defer bridge.lock.Lock()()
+	if bridge.listener == nil {
+		return nil
+	}
+	err := bridge.server.Close()
+	if bridge.pendingPSBT != nil {
+		select {
+		case bridge.review <- &reviewDecision{id: bridge.pendingPSBT.ID}:
+		default:
+		}
+	}
+	for conn := range bridge.conns {
+		_ = conn.Close()
+	}
+	bridge.server = nil
+	bridge.listener = nil
+	bridge.log.Info("Stopped the bridge")
+	return errp.WithStack(err)
+}
+
+// Status returns whether the bridge is listening, and where.
+func (bridge *Bridge) Status() *Status {
+	defer bridge.lock.RLock()()
+	status := &Status{PendingOrigin: bridge.pendingOrigin, PendingPSBT: bridge.pendingPSBT}
+	if bridge.listener != nil {
+		status.Running = true
+		status.Address = bridge.listener.Addr().String()
+	}
+	return status
+}
+
+func (bridge *Bridge) notifyStatus() {
+	bridge.Notify(observable.Event{
+		Subject: "bridge",
+		Action:  action.Replace,
+		Object:  bridge.Status(),
+	})
+}
+
+func (bridge *Bridge) setPendingOrigin(origin string) {
+	func() {
+		defer bridge.lock.Lock()()
+		bridge.pendingOrigin = origin
+	}()
+	bridge.notifyStatus()
+}
+
+func (bridge *Bridge) setPendingPSBT(pendingPSBT *PendingPSBT) {
+	func() {
+		defer bridge.lock.Lock()()
+		bridge.pendingPSBT = pendingPSBT
+	}()
+	bridge.notifyStatus()
+}
+
+// reviewDecision is the decision of the user on the pending PSBT with the given ID.
+type reviewDecision struct {
+	id       string
+	approved bool
+}
+
+// ReviewPSBT approves or rejects signing the pending PSBT with the given ID, after the user
+// reviewed its summary in the app.
+func (bridge *Bridge) ReviewPSBT(id string, approved bool) error {
+	defer bridge.lock.RLock()()
+	if bridge.pendingPSBT == nil {
+		return errp.New("no PSBT to review")
+	}
+	if bridge.pendingPSBT.ID != id {
+		return errp.New("the reviewed PSBT is no longer pending")
+	}
+	select {
+	case bridge.review <- &reviewDecision{id: id, approved: approved}:
+		return nil
+	default:
+		return errp.New("the PSBT was already approved or rejected")
+	}
+}
+
+// Pairings returns the approved origins.
+func (bridge *Bridge) Pairings() []*Pairing {
+	return bridge.pairings.Pairings()
+}
+
+// RemovePairing revokes the approval of the origin. Open connections of the origin fail on their
+// next request.
+func (bridge *Bridge) RemovePairing(origin string) error {
+	return bridge.pairings.Remove(origin)
+}
+
+// ServeHTTP implements http.Handler. Requests to other hosts than localhost are rejected, so that
+// web pages can't reach the bridge via DNS rebinding.
+func (bridge *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil || !isLoopback(host) {
+		http.Error(w, "invalid host", http.StatusForbidden)
+		return
+	}
+	origin := r.Header.Get("Origin")
+	if !allowedOrigin(origin) {
+		bridge.log.WithField("origin", origin).Warning("Rejected bridge connection")
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	conn, err := bridge.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	func() {
+		defer bridge.lock.Lock()()
+		bridge.conns[conn] = struct{}{}
+	}()
+	defer func() {
+		defer bridge.lock.Lock()()
+		delete(bridge.conns, conn)
+		_ = conn.Close()
+	}()
+	conn.SetReadLimit(maxMessageSize)
+	log := bridge.log.WithField("origin", origin)
+	log.Info("Bridge connection opened")
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Info("Bridge connection closed")
+			return
+		}
+		if err := conn.WriteJSON(bridge.handle(origin, message, log)); err != nil {
+			return
+		}
+	}
+}
+
+func failed(id int, code string, err error) *response {
+	return &response{ID: id, Error: &responseError{Code: code, Message: err.Error()}}
+}
+
+// handle processes a request of the origin. Requests are processed one after the other per
+// connection, as the device can only show one confirmation at a time anyway.
+func (bridge *Bridge) handle(origin string, message []byte, log *logrus.Entry) *response {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return failed(0, ErrorCodeInvalidRequest, errp.WithStack(err))
+	}
+	keystores := bridge.wallet.Keystores()
+	if keystores.Count() == 0 {
+		return failed(req.ID, ErrorCodeNoKeystore, errp.New("no keystore connected"))
+	}
+	if req.Method == "pair" {
+		if err := bridge.pair(origin, keystores, log); err != nil {
+			return failed(req.ID, ErrorCodeFailed, err)
+		}
+		return &response{ID: req.ID, Result: map[string]bool{"paired": true}}
+	}
+	if status, err := bridge.status(origin, keystores); err != nil {
+		return failed(req.ID, ErrorCodeFailed, err)
+	} else if status != PairingStatusValid {
+		return failed(req.ID, ErrorCodeNotPaired, errp.Newf("%s is not paired", origin))
+	}
+	switch req.Method {
+	case "xpubs":
+		var params struct {
+			Keypath string `json:"keypath"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return failed(req.ID, ErrorCodeInvalidRequest, errp.WithStack(err))
+		}
+		absoluteKeypath, err := signing.NewAbsoluteKeypath(params.Keypath)
+		if err != nil {
+			return failed(req.ID, ErrorCodeInvalidRequest, err)
+		}
+		if !absoluteKeypath.IsAccount() {
+			return failed(req.ID, ErrorCodeInvalidRequest,
+				errp.Newf("%s is not the keypath of an account", params.Keypath))
+		}
+		extendedPublicKeys, err := keystores.ExtendedPublicKeys(absoluteKeypath)
+		if err != nil {
+			return failed(req.ID, ErrorCodeFailed, err)
+		}
+		xpubs := []string{}
+		for _, extendedPublicKey := range extendedPublicKeys {
+			xpubs = append(xpubs, extendedPublicKey.String())
+		}
+		return &response{ID: req.ID, Result: xpubs}
+	case "signPSBT":
+		var params struct {
+			PSBT string `json:"psbt"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return failed(req.ID, ErrorCodeInvalidRequest, errp.WithStack(err))
+		}
+		if err := bridge.awaitReview(origin, params.PSBT, log); err != nil {
+			return failed(req.ID, ErrorCodeRejected, err)
+		}
+		log.Info("Signing a PSBT for the bridge")
+		signed, err := bridge.wallet.SignPSBT(params.PSBT)
+		if err != nil {
+			return failed(req.ID, ErrorCodeFailed, err)
+		}
+		return &response{ID: req.ID, Result: map[string]string{"psbt": signed}}
+	}
+	return failed(req.ID, ErrorCodeInvalidRequest, errp.Newf("unknown method %q", req.Method))
+}
+
+// status validates the pairing of the origin against the keystores.
+func (bridge *Bridge) status(origin string, keystores keystore.Keystores) (PairingStatus, error) {
+	pairing := bridge.pairings.Lookup(origin)
+	if pairing == nil {
+		return PairingStatusInvalid, nil
+	}
+	return pairing.Validate(keystores)
+}
+
+// pair asks the user to approve the origin on the device, unless it is already paired with the
+// connected keystores.
+func (bridge *Bridge) pair(origin string, keystores keystore.Keystores, log *logrus.Entry) error {
+	defer bridge.approvalLock.Lock()()
+	status, err := bridge.status(origin, keystores)
+	if err != nil {
+		return err
+	}
+	if status == PairingStatusValid {
+		return nil
+	}
+	log.Info("Asking to approve the bridge pairing on the device")
+	bridge.setPendingOrigin(origin)
+	defer bridge.setPendingOrigin("")
+	pairing, err := approve(keystores, origin)
+	if err != nil {
+		return err
+	}
+	return bridge.pairings.Add(pairing)
+}
+
+// awaitReview asks the user to review the PSBT in the app, and returns an error unless the user
+// approved signing it. The PSBT is signed as it was shown, so the origin can't swap it afterwards.
+func (bridge *Bridge) awaitReview(origin string, encodedPSBT string, log *logrus.Entry) error {
+	defer bridge.approvalLock.Lock()()
+	// Drop a decision made while no PSBT was pending.
+	select {
+	case <-bridge.review:
+	default:
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return errp.WithStack(err)
+	}
+	pendingPSBT := &PendingPSBT{ID: hex.EncodeToString(id[:]), Origin: origin, PSBT: encodedPSBT}
+	log.Info("Asking to review a PSBT of the bridge")
+	bridge.setPendingPSBT(pendingPSBT)
+	defer bridge.setPendingPSBT(nil)
+	timeout := time.After(reviewTimeout)
+	for {
+		select {
+		case decision := <-bridge.review:
+			if decision.id != pendingPSBT.ID {
+				continue
+			}
+			if !decision.approved {
+				return errp.New("signing the PSBT was rejected")
+			}
+			return nil
+		case <-timeout:
+			return errp.New("the review of the PSBT timed out")
+		}
+	}
+}
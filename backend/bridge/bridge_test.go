@@ -0,0 +1,189 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+const origin = "https://wallet.example.com"
+
+type testWallet struct {
+	keystores keystore.Keystores
+}
+
+func (wallet *testWallet) Keystores() keystore.Keystores {
+	return wallet.keystores
+}
+
+func (wallet *testWallet) SignPSBT(encoded string) (string, error) {
+	return "signed:" + encoded, nil
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	for _, allowed := range []string{
+		"https://wallet.example.com",
+		"https://wallet.example.com:8443",
+		"http://localhost:3000",
+		"http://127.0.0.1:8080",
+	} {
+		require.True(t, allowedOrigin(allowed), allowed)
+	}
+	for _, rejected := range []string{
+		"",
+		"null",
+		"http://wallet.example.com",
+		"https://wallet.example.com/path",
+		"file://",
+		"chrome-extension://abcdef",
+	} {
+		require.False(t, allowedOrigin(rejected), rejected)
+	}
+}
+
+func TestPairing(t *testing.T) {
+	keystores := keystore.NewKeystores(software.NewKeystoreFromPIN(0, "1234"))
+	pairing, err := approve(keystores, origin)
+	require.NoError(t, err)
+
+	status, err := pairing.Validate(keystores)
+	require.NoError(t, err)
+	require.Equal(t, PairingStatusValid, status)
+
+	status, err = pairing.Validate(keystore.NewKeystores())
+	require.NoError(t, err)
+	require.Equal(t, PairingStatusNoKeystore, status)
+
+	// Approved with a different wallet.
+	status, err = pairing.Validate(keystore.NewKeystores(software.NewKeystoreFromPIN(0, "4321")))
+	require.NoError(t, err)
+	require.Equal(t, PairingStatusInvalid, status)
+
+	// The signature does not cover another origin.
+	tampered := *pairing
+	tampered.Origin = "https://evil.example.com"
+	status, err = tampered.Validate(keystores)
+	require.NoError(t, err)
+	require.Equal(t, PairingStatusInvalid, status)
+}
+
+func dial(server *httptest.Server, origin string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	return websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+}
+
+func call(t *testing.T, conn *websocket.Conn, req interface{}) *response {
+	require.NoError(t, conn.WriteJSON(req))
+	var resp response
+	require.NoError(t, conn.ReadJSON(&resp))
+	return &resp
+}
+
+// review sends the signPSBT request and approves or rejects the PSBT once it waits for review.
+func review(
+	t *testing.T, bridge *Bridge, conn *websocket.Conn, req interface{}, approved bool) *response {
+	require.NoError(t, conn.WriteJSON(req))
+	for bridge.Status().PendingPSBT == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pendingPSBT := bridge.Status().PendingPSBT
+	require.Equal(t, origin, pendingPSBT.Origin)
+	require.Equal(t, "cHNidP8=", pendingPSBT.PSBT)
+	require.Error(t, bridge.ReviewPSBT("other", approved))
+	require.NoError(t, bridge.ReviewPSBT(pendingPSBT.ID, approved))
+	var resp response
+	require.NoError(t, conn.ReadJSON(&resp))
+	return &resp
+}
+
+func TestBridge(t *testing.T) {
+	wallet := &testWallet{keystores: keystore.NewKeystores(software.NewKeystoreFromPIN(0, "1234"))}
+	filename := path.Join(test.TstTempDir("bridge-"), "bridge-pairings.json")
+	bridge := NewBridge(wallet, filename, logging.Get().WithGroup("bridge"))
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+
+	_, resp, err := dial(server, "http://wallet.example.com")
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	conn, _, err := dial(server, origin)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	xpubsRequest := map[string]interface{}{
+		"id":     1,
+		"method": "xpubs",
+		"params": map[string]string{"keypath": "m/84'/1'/0'"},
+	}
+	resp1 := call(t, conn, xpubsRequest)
+	require.NotNil(t, resp1.Error)
+	require.Equal(t, ErrorCodeNotPaired, resp1.Error.Code)
+
+	resp2 := call(t, conn, map[string]interface{}{"id": 2, "method": "pair"})
+	require.Nil(t, resp2.Error)
+	require.Equal(t, 2, resp2.ID)
+	require.Len(t, bridge.Pairings(), 1)
+	require.Empty(t, bridge.Status().PendingOrigin)
+
+	resp3 := call(t, conn, xpubsRequest)
+	require.Nil(t, resp3.Error)
+	require.Len(t, resp3.Result, 1)
+
+	// Only account keys are handed out.
+	respKeypath := call(t, conn, map[string]interface{}{
+		"id":     3,
+		"method": "xpubs",
+		"params": map[string]string{"keypath": "m/20180'/2'"},
+	})
+	require.Equal(t, ErrorCodeInvalidRequest, respKeypath.Error.Code)
+
+	signRequest := map[string]interface{}{
+		"id":     4,
+		"method": "signPSBT",
+		"params": map[string]string{"psbt": "cHNidP8="},
+	}
+	resp4 := review(t, bridge, conn, signRequest, true)
+	require.Nil(t, resp4.Error)
+	require.Equal(t, map[string]interface{}{"psbt": "signed:cHNidP8="}, resp4.Result)
+	require.Nil(t, bridge.Status().PendingPSBT)
+
+	respRejected := review(t, bridge, conn, signRequest, false)
+	require.Equal(t, ErrorCodeRejected, respRejected.Error.Code)
+	require.Error(t, bridge.ReviewPSBT("", true))
+
+	resp5 := call(t, conn, map[string]interface{}{"id": 5, "method": "unknown"})
+	require.Equal(t, ErrorCodeInvalidRequest, resp5.Error.Code)
+
+	// Pairings are persisted.
+	require.NotNil(t, NewStore(filename).Lookup(origin))
+
+	require.NoError(t, bridge.RemovePairing(origin))
+	resp6 := call(t, conn, xpubsRequest)
+	require.Equal(t, ErrorCodeNotPaired, resp6.Error.Code)
+}
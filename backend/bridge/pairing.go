@@ -0,0 +1,167 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/attestation"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// keypath is the keypath of the key signing the pairings. It is not used for any coin.
+const keypath = "m/20180'/2'"
+
+// PairingStatus is the result of validating the signature of a pairing.
+type PairingStatus string
+
+const (
+	// PairingStatusValid means the origin was approved on the connected keystores.
+	PairingStatusValid PairingStatus = "valid"
+	// PairingStatusInvalid means the signature does not validate for the connected keystores, e.g.
+	// because the origin was approved with a different wallet.
+	PairingStatusInvalid PairingStatus = "invalid"
+	// PairingStatusNoKeystore means no keystore is connected to validate the pairing with.
+	PairingStatusNoKeystore PairingStatus = "noKeystore"
+)
+
+// Pairing is a web origin which the user approved on the keystore to use the bridge.
+type Pairing struct {
+	Origin  string    `json:"origin"`
+	Created time.Time `json:"created"`
+	// Attestation are the signatures of hash() by the keys at keypath.
+	attestation.Attestation
+}
+
+// hash returns the hash signed by the keystores, committing to the origin.
+func (pairing *Pairing) hash() []byte {
+	message, _ := json.Marshal([]string{"BitBox bridge pairing", pairing.Origin})
+	return chainhash.DoubleHashB(message)
+}
+
+// Validate checks the signatures of the pairing against the public keys of the keystores.
+func (pairing *Pairing) Validate(keystores keystore.Keystores) (PairingStatus, error) {
+	if keystores.Count() == 0 {
+		return PairingStatusNoKeystore, nil
+	}
+	valid, err := pairing.Verify(keystores, keypath, pairing.hash())
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		return PairingStatusInvalid, nil
+	}
+	return PairingStatusValid, nil
+}
+
+// approve creates a pairing of the origin signed by the keystores. Hardware keystores ask the user
+// to confirm on the device.
+func approve(keystores keystore.Keystores, origin string) (*Pairing, error) {
+	pairing := &Pairing{Origin: origin, Created: time.Now()}
+	signed, err := attestation.Sign(keystores, keypath, pairing.hash())
+	if err != nil {
+		return nil, err
+	}
+	pairing.Attestation = *signed
+	return pairing, nil
+}
+
+// Store persists the pairings in a JSON file.
+type Store struct {
+	filename string
+	pairings []*Pairing
+	lock     locker.Locker
+}
+
+// NewStore creates a store persisted in the given file, loading the existing pairings if the file
+// exists.
+func NewStore(filename string) *Store {
+	store := &Store{filename: filename, pairings: []*Pairing{}}
+	store.load()
+	return store
+}
+
+func (store *Store) load() {
+	jsonBytes, err := ioutil.ReadFile(store.filename)
+	if err != nil {
+		return
+	}
+	pairings := []*Pairing{}
+	if err := json.Unmarshal(jsonBytes, &pairings); err != nil {
+		return
+	}
+	store.pairings = pairings
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.MarshalIndent(store.pairings, "", "  ")
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// Add stores the pairing, replacing an existing pairing of the same origin.
+func (store *Store) Add(pairing *Pairing) error {
+	defer store.lock.Lock()()
+	previous := store.pairings
+	pairings := []*Pairing{}
+	for _, existing := range store.pairings {
+		if existing.Origin != pairing.Origin {
+			pairings = append(pairings, existing)
+		}
+	}
+	store.pairings = append(pairings, pairing)
+	if err := store.save(); err != nil {
+		store.pairings = previous
+		return err
+	}
+	return nil
+}
+
+// Pairings returns all pairings.
+func (store *Store) Pairings() []*Pairing {
+	defer store.lock.RLock()()
+	return append([]*Pairing{}, store.pairings...)
+}
+
+// Remove deletes the pairing of the given origin.
+func (store *Store) Remove(origin string) error {
+	defer store.lock.Lock()()
+	for index, pairing := range store.pairings {
+		if pairing.Origin == origin {
+			store.pairings = append(store.pairings[:index:index], store.pairings[index+1:]...)
+			return store.save()
+		}
+	}
+	return errp.Newf("unknown origin %s", origin)
+}
+
+// Lookup returns the pairing of the given origin, or nil if there is none.
+func (store *Store) Lookup(origin string) *Pairing {
+	defer store.lock.RLock()()
+	for _, pairing := range store.pairings {
+		if pairing.Origin == origin {
+			return pairing
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chart computes value time series of accounts and of the whole portfolio for display in
+// charts. Series are computed per day and downsampled to coarser granularities.
+package chart
+
+import (
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Granularity is the time between two points of a series.
+type Granularity string
+
+const (
+	// GranularityDay has one point per day.
+	GranularityDay Granularity = "day"
+	// GranularityWeek has one point per week, starting on Monday.
+	GranularityWeek Granularity = "week"
+	// GranularityMonth has one point per month.
+	GranularityMonth Granularity = "month"
+	// GranularityYear has one point per year.
+	GranularityYear Granularity = "year"
+)
+
+// ParseGranularity parses a granularity. An empty string is parsed as GranularityDay.
+func ParseGranularity(granularity string) (Granularity, error) {
+	switch Granularity(granularity) {
+	case "", GranularityDay:
+		return GranularityDay, nil
+	case GranularityWeek, GranularityMonth, GranularityYear:
+		return Granularity(granularity), nil
+	default:
+		return "", errp.Newf("unknown granularity %s", granularity)
+	}
+}
+
+// Point is the value at the end of a period.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// BalanceChange is a change of the balance of an account at a point in time, in the smallest unit
+// of the coin.
+type BalanceChange struct {
+	Time  time.Time
+	Delta int64
+}
+
+// Day truncates the time to the start of the day in UTC.
+func Day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// DailyBalances returns the balance at the end of each day from the day of the first change until
+// the day of `until`. Points are timestamped with the start of the day.
+func DailyBalances(changes []BalanceChange, until time.Time) []Point {
+	if len(changes) == 0 {
+		return []Point{}
+	}
+	changes = append([]BalanceChange{}, changes...)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+	points := []Point{}
+	balance := int64(0)
+	index := 0
+	for day := Day(changes[0].Time); !day.After(Day(until)); day = day.AddDate(0, 0, 1) {
+		nextDay := day.AddDate(0, 0, 1)
+		for index < len(changes) && changes[index].Time.Before(nextDay) {
+			balance += changes[index].Delta
+			index++
+		}
+		points = append(points, Point{Time: day, Value: float64(balance)})
+	}
+	return points
+}
+
+// FiatValues converts daily balances into fiat values. dailyRates maps the start of a day to the
+// exchange rate of that day. Days without a rate use the rate of the previous day. unitsPerCoin is
+// the number of smallest units per coin, e.g. 1e8 for Bitcoin.
+func FiatValues(balances []Point, dailyRates map[time.Time]float64, unitsPerCoin float64) []Point {
+	values := make([]Point, len(balances))
+	rate := 0.0
+	for index, balance := range balances {
+		if dailyRate, ok := dailyRates[balance.Time]; ok {
+			rate = dailyRate
+		}
+		values[index] = Point{Time: balance.Time, Value: balance.Value / unitsPerCoin * rate}
+	}
+	return values
+}
+
+// Sum adds up the given daily series. A series without a point for a day contributes its last
+// value before that day, or 0 if it has not started yet.
+func Sum(series ...[]Point) []Point {
+	days := map[time.Time]struct{}{}
+	for _, points := range series {
+		for _, point := range points {
+			days[point.Time] = struct{}{}
+		}
+	}
+	sortedDays := make([]time.Time, 0, len(days))
+	for day := range days {
+		sortedDays = append(sortedDays, day)
+	}
+	sort.Slice(sortedDays, func(i, j int) bool { return sortedDays[i].Before(sortedDays[j]) })
+
+	result := make([]Point, len(sortedDays))
+	for index, day := range sortedDays {
+		result[index].Time = day
+	}
+	for _, points := range series {
+		pointIndex := 0
+		value := 0.0
+		for index, day := range sortedDays {
+			for pointIndex < len(points) && !points[pointIndex].Time.After(day) {
+				value = points[pointIndex].Value
+				pointIndex++
+			}
+			result[index].Value += value
+		}
+	}
+	return result
+}
+
+// periodStart returns the start of the period of the given granularity the day belongs to.
+func periodStart(day time.Time, granularity Granularity) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		daysSinceMonday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -daysSinceMonday)
+	case GranularityMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case GranularityYear:
+		return time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// Downsample reduces a daily series to one point per period, the value of the last day in the
+// period. Points are timestamped with the start of the period.
+func Downsample(points []Point, granularity Granularity) []Point {
+	result := []Point{}
+	for _, point := range points {
+		start := periodStart(point.Time, granularity)
+		if len(result) != 0 && result[len(result)-1].Time.Equal(start) {
+			result[len(result)-1].Value = point.Value
+			continue
+		}
+		result = append(result, Point{Time: start, Value: point.Value})
+	}
+	return result
+}
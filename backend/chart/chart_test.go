@@ -0,0 +1,88 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/chart"
+	"github.com/stretchr/testify/require"
+)
+
+func day(month time.Month, day int) time.Time {
+	return time.Date(2018, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestDailyBalances(t *testing.T) {
+	require.Equal(t, []chart.Point{}, chart.DailyBalances(nil, day(time.May, 1)))
+
+	changes := []chart.BalanceChange{
+		{Time: day(time.May, 3).Add(time.Hour), Delta: -30},
+		{Time: day(time.May, 1).Add(5 * time.Hour), Delta: 100},
+		{Time: day(time.May, 1).Add(6 * time.Hour), Delta: 20},
+	}
+	require.Equal(t, []chart.Point{
+		{Time: day(time.May, 1), Value: 120},
+		{Time: day(time.May, 2), Value: 120},
+		{Time: day(time.May, 3), Value: 90},
+		{Time: day(time.May, 4), Value: 90},
+	}, chart.DailyBalances(changes, day(time.May, 4).Add(time.Hour)))
+}
+
+func TestFiatValues(t *testing.T) {
+	balances := []chart.Point{
+		{Time: day(time.May, 1), Value: 1e8},
+		{Time: day(time.May, 2), Value: 2e8},
+		{Time: day(time.May, 3), Value: 2e8},
+	}
+	rates := map[time.Time]float64{day(time.May, 1): 10, day(time.May, 3): 20}
+	require.Equal(t, []chart.Point{
+		{Time: day(time.May, 1), Value: 10},
+		{Time: day(time.May, 2), Value: 20},
+		{Time: day(time.May, 3), Value: 40},
+	}, chart.FiatValues(balances, rates, 1e8))
+}
+
+func TestSum(t *testing.T) {
+	series1 := []chart.Point{{Time: day(time.May, 1), Value: 1}, {Time: day(time.May, 2), Value: 2}}
+	series2 := []chart.Point{{Time: day(time.May, 2), Value: 10}, {Time: day(time.May, 3), Value: 20}}
+	require.Equal(t, []chart.Point{
+		{Time: day(time.May, 1), Value: 1},
+		{Time: day(time.May, 2), Value: 12},
+		{Time: day(time.May, 3), Value: 22},
+	}, chart.Sum(series1, series2))
+}
+
+func TestDownsample(t *testing.T) {
+	points := []chart.Point{}
+	// 2018-04-29 is a Sunday.
+	for d := day(time.April, 29); d.Before(day(time.May, 8)); d = d.AddDate(0, 0, 1) {
+		points = append(points, chart.Point{Time: d, Value: float64(d.Day())})
+	}
+	require.Equal(t, []chart.Point{
+		{Time: day(time.April, 23), Value: 29},
+		{Time: day(time.April, 30), Value: 6},
+		{Time: day(time.May, 7), Value: 7},
+	}, chart.Downsample(points, chart.GranularityWeek))
+	require.Equal(t, []chart.Point{
+		{Time: day(time.April, 1), Value: 30},
+		{Time: day(time.May, 1), Value: 7},
+	}, chart.Downsample(points, chart.GranularityMonth))
+	require.Equal(t, []chart.Point{
+		{Time: day(time.January, 1), Value: 7},
+	}, chart.Downsample(points, chart.GranularityYear))
+	require.Equal(t, points, chart.Downsample(points, chart.GranularityDay))
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/chart"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// chartMaxAge is how long precomputed chart data is served before it is recomputed, so that it
+// picks up new exchange rates.
+const chartMaxAge = time.Hour
+
+// chartData contains the daily fiat value series of all accounts and of the whole portfolio in one
+// fiat currency.
+type chartData struct {
+	accounts  map[string][]chart.Point
+	portfolio []chart.Point
+	computed  time.Time
+}
+
+// accountBalanceChanges returns the balance changes caused by the transactions of the account.
+// Unconfirmed transactions are counted as of now.
+func accountBalanceChanges(account *btc.Account, now time.Time) []chart.BalanceChange {
+	changes := []chart.BalanceChange{}
+	for _, txInfo := range account.Transactions() {
+		changeTime := now
+		if txInfo.Timestamp != nil {
+			changeTime = *txInfo.Timestamp
+		}
+		fee := int64(0)
+		if txInfo.Fee != nil {
+			fee = int64(*txInfo.Fee)
+		}
+		var delta int64
+		switch txInfo.Type {
+		case transactions.TxTypeReceive:
+			delta = int64(txInfo.Amount)
+		case transactions.TxTypeSend:
+			delta = -int64(txInfo.Amount) - fee
+		case transactions.TxTypeSendSelf:
+			delta = -fee
+		}
+		changes = append(changes, chart.BalanceChange{Time: changeTime, Delta: delta})
+	}
+	return changes
+}
+
+// computeChart computes the chart data of all synced accounts in the given fiat currency. No lock
+// is held, as the exchange rates might have to be downloaded. Accounts whose rates can't be
+// fetched are left out.
+func (backend *Backend) computeChart(fiat string) *chartData {
+	now := time.Now()
+	data := &chartData{accounts: map[string][]chart.Point{}, computed: now}
+	series := [][]chart.Point{}
+	for _, account := range backend.Accounts() {
+		if !account.InitialSyncDone() {
+			continue
+		}
+		rates, err := backend.rateHistory.DailyRates(account.Coin().RatesUnit(), fiat)
+		if err != nil {
+			backend.log.WithError(err).WithFields(logrus.Fields{
+				"code": account.Code(), "fiat": fiat}).Error("Failed to fetch the rates for the chart")
+			continue
+		}
+		balances := chart.DailyBalances(accountBalanceChanges(account, now), now)
+		values := chart.FiatValues(balances, rates, btcutil.SatoshiPerBitcoin)
+		data.accounts[account.Code()] = values
+		series = append(series, values)
+	}
+	data.portfolio = chart.Sum(series...)
+	return data
+}
+
+// updateCharts recomputes the chart data of all fiat currencies which have been requested before.
+// It is called when an account has synced, so that charts are ready when they are requested.
+func (backend *Backend) updateCharts() {
+	fiats := []string{}
+	func() {
+		defer backend.chartsLock.RLock()()
+		for fiat := range backend.charts {
+			fiats = append(fiats, fiat)
+		}
+	}()
+	for _, fiat := range fiats {
+		data := backend.computeChart(fiat)
+		func() {
+			defer backend.chartsLock.Lock()()
+			backend.charts[fiat] = data
+		}()
+	}
+}
+
+// Chart returns the value of the account with the given code in the fiat currency over time, one
+// point per period of the given granularity. If accountCode is empty, the value of the whole
+// portfolio is returned.
+func (backend *Backend) Chart(accountCode string, fiat string, granularity chart.Granularity) (
+	[]chart.Point, error) {
+	var data *chartData
+	var ok bool
+	func() {
+		defer backend.chartsLock.RLock()()
+		data, ok = backend.charts[fiat]
+	}()
+	if !ok || time.Since(data.computed) > chartMaxAge {
+		data = backend.computeChart(fiat)
+		func() {
+			defer backend.chartsLock.Lock()()
+			backend.charts[fiat] = data
+		}()
+	}
+	points := data.portfolio
+	if accountCode != "" {
+		points, ok = data.accounts[accountCode]
+		if !ok {
+			return nil, errp.Newf("no chart data for account %s", accountCode)
+		}
+	}
+	return chart.Downsample(points, granularity), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/customcoin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coinmetadata"
+)
+
+// explorerTxPrefix returns the block explorer URL to which transaction IDs of the coin are
+// appended, or "" if the coin has no block explorer.
+func (backend *Backend) explorerTxPrefix(code string) string {
+	coinMetadata, ok := backend.coinMetadata.Coin(code)
+	if !ok {
+		return ""
+	}
+	return coinMetadata.TxURLPrefix()
+}
+
+// customCoinMetadata derives the display metadata of a custom coin from its definition.
+func customCoinMetadata(definition *customcoin.Definition) *coinmetadata.Coin {
+	coinMetadata := &coinmetadata.Coin{
+		Code:     definition.Code,
+		Name:     definition.Name,
+		Unit:     definition.Unit,
+		Decimals: 8,
+	}
+	if definition.BlockExplorerTxPrefix != "" {
+		coinMetadata.ExplorerTxURL = definition.BlockExplorerTxPrefix + coinmetadata.TxIDPlaceholder
+	}
+	return coinMetadata
+}
+
+// CoinMetadata returns the display metadata of the built in coins and of the loaded custom coins,
+// sorted by code.
+func (backend *Backend) CoinMetadata() []*coinmetadata.Coin {
+	coins := backend.coinMetadata.Coins()
+	func() {
+		defer backend.coinsLock.RLock()()
+		for _, definition := range backend.customCoins {
+			coins = append(coins, customCoinMetadata(definition))
+		}
+	}()
+	sort.Slice(coins, func(i, j int) bool { return coins[i].Code < coins[j].Code })
+	return coins
+}
+
+// refreshCoinMetadata fetches the latest coin metadata and notifies the frontend if it changed.
+// Coins which were already created keep their block explorer until the app is restarted.
+func (backend *Backend) refreshCoinMetadata() {
+	updated, err := backend.coinMetadata.Refresh()
+	if err != nil {
+		backend.log.WithError(err).Error("Refreshing the coin metadata failed")
+		return
+	}
+	if updated {
+		backend.events <- backendEvent{Type: "backend", Data: "coinMetadataChanged"}
+	}
+}
@@ -17,8 +17,10 @@ package btc
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"path"
 	"sort"
+	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 
@@ -31,11 +33,18 @@ import (
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/forksweep"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/invoices"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/offlinetx"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/synchronizer"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/vault"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/watchtower"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/transactionsdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/denylist"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
@@ -59,16 +68,48 @@ type Interface interface {
 	Close()
 	Transactions() []*transactions.TxInfo
 	Balance() *transactions.Balance
-	SendTx(string, SendAmount, FeeTargetCode, map[wire.OutPoint]struct{}) error
+	ExplainBalance() *BalanceExplanation
+	BalanceSnapshots(transactions.SnapshotPeriod) ([]*transactions.BalanceSnapshot, error)
+	SendTx(string, SendAmount, FeeTargetCode, map[wire.OutPoint]struct{}, string, string, bool, bool) error
+	SignTxOffline(string, SendAmount, FeeTargetCode, btcutil.Amount, map[wire.OutPoint]struct{}, string, string, bool, bool) (
+		*OfflineTx, error)
+	BroadcastTx(string) (string, error)
+	CancelOfflineTx(string) error
+	SetTxNote(string, string) error
+	SetAddressNote(string, string) error
+	AddressNotes() (map[string]string, error)
 	FeeTargets() ([]*FeeTarget, FeeTargetCode)
-	TxProposal(string, SendAmount, FeeTargetCode, map[wire.OutPoint]struct{}) (
-		btcutil.Amount, btcutil.Amount, btcutil.Amount, error)
+	SetNickname(config.AccountNickname) error
+	TxProposal(string, SendAmount, FeeTargetCode, map[wire.OutPoint]struct{}, bool, bool, string) (
+		btcutil.Amount, *FeeDetails, btcutil.Amount, []TxWarning, error)
+	CheckRecipient(string) error
 	GetUnusedReceiveAddresses() []*addresses.AccountAddress
 	VerifyAddress(blockchain.ScriptHashHex) (bool, error)
+	VerifyAddresses(int) ([]*AddressVerification, error)
+	AddressVerification(blockchain.ScriptHashHex) *AddressVerification
 	ConvertToLegacyAddress(blockchain.ScriptHashHex) (btcutil.Address, error)
 	Keystores() keystore.Keystores
 	HeadersStatus() (*headers.Status, error)
 	SpendableOutputs() []*SpendableOutput
+	FeeBumpSuggestions() []*FeeBumpSuggestion
+	TxAncestry(string, FeeTargetCode) (*TxAncestry, error)
+	FeeBumpTxProposal(string, FeeTargetCode, string) (btcutil.Amount, *FeeDetails, btcutil.Amount, error)
+	SendFeeBump(string, FeeTargetCode) error
+	ExportMultisig(MultisigExportFormat) (string, error)
+	ExportHistory(io.Writer, *HistoryExportProfile) error
+	VaultDeposits() ([]*vault.Deposit, bool)
+	AddVaultDeposit(unvaultTxHex string, witnessScriptHex string) error
+	Unvault(wire.OutPoint) error
+	WatchedTxs() []*watchtower.WatchedTx
+	WatchTx(string) error
+	UnwatchTx(string) error
+	Invoices() []*invoices.Invoice
+	AddInvoice(btcutil.Amount, string, time.Duration) (*invoices.Invoice, error)
+	RemoveInvoice(string) error
+	ForkSweepProposal(*ForkSweepRequest) (*forksweep.Sweep, error)
+	SendForkSweep(*ForkSweepRequest, btcutil.Amount) (string, error)
+	PaymentCode() (*PaymentCodeInfo, bool)
+	SignMessage(string, string) (string, error)
 }
 
 // Account is a account whose addresses are derived from an xpub.
@@ -83,6 +124,8 @@ type Account struct {
 	getSigningConfiguration func() (*signing.Configuration, error)
 	signingConfiguration    *signing.Configuration
 	keystores               keystore.Keystores
+	config                  *config.Config
+	denylist                *denylist.Store
 	blockchain              blockchain.Interface
 
 	receiveAddresses *addresses.AddressChain
@@ -95,6 +138,33 @@ type Account struct {
 
 	feeTargets []*FeeTarget
 
+	// unconfirmedSince maps unconfirmed outgoing transactions to the chain tip height at the time
+	// they were first seen, to suggest fee bumps for transactions which are stuck.
+	unconfirmedSince     map[chainhash.Hash]int
+	unconfirmedSinceLock locker.Locker
+
+	// addressVerifications holds the result of the latest verification of each receive address
+	// verified with VerifyAddresses().
+	addressVerifications     map[blockchain.ScriptHashHex]*AddressVerification
+	addressVerificationsLock locker.Locker
+
+	// vault is nil if the account is not configured as a vault account. Guarded by the account
+	// lock, see getVault().
+	vault *vault.Vault
+
+	// watchtower watches signed transactions which were exported instead of broadcast.
+	watchtower *watchtower.Watchtower
+
+	// invoices are the receive requests bound to an expected amount.
+	invoices *invoices.Store
+
+	// paymentCode is nil if the account does not receive to a BIP47 payment code.
+	paymentCode *paymentCodeReceiver
+
+	// offlineTxs persists the time of the last sync and the coins reserved by transactions signed
+	// offline.
+	offlineTxs *offlinetx.Store
+
 	initialSyncDone bool
 	offline         bool
 	onEvent         func(Event)
@@ -103,15 +173,22 @@ type Account struct {
 
 // MarshalJSON implements json.Marshaler.
 func (account *Account) MarshalJSON() ([]byte, error) {
+	nickname := account.Nickname()
 	return json.Marshal(struct {
 		CoinCode              string `json:"coinCode"`
 		Code                  string `json:"code"`
 		Name                  string `json:"name"`
+		DisplayName           string `json:"displayName"`
+		Nickname              string `json:"nickname"`
+		Emoji                 string `json:"emoji"`
 		BlockExplorerTxPrefix string `json:"blockExplorerTxPrefix"`
 	}{
-		CoinCode: account.coin.Name(),
-		Code:     account.code,
-		Name:     account.name,
+		CoinCode:              account.coin.Name(),
+		Code:                  account.code,
+		Name:                  account.name,
+		DisplayName:           account.DisplayName(),
+		Nickname:              nickname.Name,
+		Emoji:                 nickname.Emoji,
 		BlockExplorerTxPrefix: account.coin.blockExplorerTxPrefix,
 	})
 }
@@ -141,6 +218,8 @@ func NewAccount(
 	name string,
 	getSigningConfiguration func() (*signing.Configuration, error),
 	keystores keystore.Keystores,
+	config *config.Config,
+	denylist *denylist.Store,
 	onEvent func(Event),
 	log *logrus.Entry,
 ) *Account {
@@ -156,6 +235,8 @@ func NewAccount(
 		getSigningConfiguration: getSigningConfiguration,
 		signingConfiguration:    nil,
 		keystores:               keystores,
+		config:                  config,
+		denylist:                denylist,
 
 		// feeTargets must be sorted by ascending priority.
 		feeTargets: []*FeeTarget{
@@ -164,6 +245,8 @@ func NewAccount(
 			{Blocks: 6, Code: FeeTargetCodeNormal},
 			{Blocks: 2, Code: FeeTargetCodeHigh},
 		},
+		unconfirmedSince:     map[chainhash.Hash]int{},
+		addressVerifications: map[blockchain.ScriptHashHex]*AddressVerification{},
 		// initializing to false, to prevent flashing of offline notification in the frontend
 		offline:         false,
 		initialSyncDone: false,
@@ -177,13 +260,32 @@ func NewAccount(
 				account.initialSyncDone = true
 				onEvent(EventStatusChanged)
 			}
+			account.markSynced()
 			onEvent(EventSyncDone)
+			go account.snapshotBalance()
+			go account.checkWatchtower()
+			go account.releaseSpentReservations()
+			go account.checkInvoices()
+			go account.checkVault()
 		},
 		log,
 	)
 	return account
 }
 
+// snapshotBalance stores the current balance as the snapshot of today.
+func (account *Account) snapshotBalance() {
+	if err := account.transactions.SnapshotBalance(time.Now()); err != nil {
+		account.log.WithError(err).Error("Failed to store balance snapshot")
+	}
+}
+
+// BalanceSnapshots returns the balance snapshots of the account, one per day or month.
+func (account *Account) BalanceSnapshots(
+	period transactions.SnapshotPeriod) ([]*transactions.BalanceSnapshot, error) {
+	return account.transactions.BalanceSnapshots(period)
+}
+
 // String returns a representation of the account for logging.
 func (account *Account) String() string {
 	return fmt.Sprintf("%s-%s", account.Coin().String(), account.code)
@@ -194,6 +296,11 @@ func (account *Account) Code() string {
 	return account.code
 }
 
+// Name returns the name of the account.
+func (account *Account) Name() string {
+	return account.name
+}
+
 // Coin returns the coin of the account.
 func (account *Account) Coin() *Coin {
 	return account.coin
@@ -221,14 +328,12 @@ func (account *Account) Init() error {
 		account.log.Debug("Account has already been initialized")
 		return nil
 	}
-	dbName := fmt.Sprintf("account-%s-%s.db", account.signingConfiguration.Hash(), account.code)
-	account.log.Debugf("Opening the database '%s' to persist the transactions.", dbName)
-	db, err := transactionsdb.NewDB(path.Join(account.dbFolder, dbName))
-	if err != nil {
+	if err := account.initStorage(); err != nil {
+		// Roll back, so that the account is not left half-initialized and the next call to Init
+		// starts from scratch, e.g. after the device was reconnected.
+		account.rollbackInit()
 		return err
 	}
-	account.db = db
-	account.log.Debugf("Opened the database '%s' to persist the transactions.", dbName)
 
 	onConnectionStatusChanged := func(status blockchain.Status) {
 		if status == blockchain.DISCONNECTED {
@@ -280,10 +385,79 @@ func (account *Account) Init() error {
 	account.changeAddresses = addresses.NewAddressChain(
 		account.signingConfiguration, account.coin.Net(), fixChangeGapLimit, 1, account.log)
 	account.ensureAddresses()
+	if err := account.initPaymentCode(); err != nil {
+		account.log.WithError(err).Error("Failed to receive to the payment code")
+	}
 	account.blockchain.HeadersSubscribe(func() func() { return func() {} }, account.onNewHeader)
 	return nil
 }
 
+// transactionsDBName is the name of the database file persisting the transactions of the account
+// with the given signing configuration.
+func (account *Account) transactionsDBName(signingConfiguration *signing.Configuration) string {
+	return fmt.Sprintf("account-%s-%s.db", signingConfiguration.Hash(), account.code)
+}
+
+// initStorage opens the files persisting the account state.
+func (account *Account) initStorage() error {
+	dbName := account.transactionsDBName(account.signingConfiguration)
+	account.log.Debugf("Opening the database '%s' to persist the transactions.", dbName)
+	db, err := transactionsdb.NewDB(path.Join(account.dbFolder, dbName))
+	if err != nil {
+		return err
+	}
+	account.db = db
+	account.log.Debugf("Opened the database '%s' to persist the transactions.", dbName)
+
+	if vaultConfig, ok := account.config.Config().Backend.VaultAccounts[account.code]; ok {
+		vaultName := fmt.Sprintf("vault-%s-%s.json", account.signingConfiguration.Hash(), account.code)
+		accountVault, err := vault.NewVault(
+			path.Join(account.dbFolder, vaultName), vaultConfig.DelayBlocks, account.log)
+		if err != nil {
+			return err
+		}
+		func() {
+			defer account.Lock()()
+			account.vault = accountVault
+		}()
+	}
+
+	watchtowerName := fmt.Sprintf("watchtower-%s-%s.json", account.signingConfiguration.Hash(), account.code)
+	account.watchtower, err = watchtower.NewWatchtower(
+		path.Join(account.dbFolder, watchtowerName), account.log)
+	if err != nil {
+		return err
+	}
+
+	invoicesName := fmt.Sprintf("invoices-%s-%s.json", account.signingConfiguration.Hash(), account.code)
+	account.invoices, err = invoices.NewStore(path.Join(account.dbFolder, invoicesName), account.log)
+	if err != nil {
+		return err
+	}
+
+	offlineTxsName := fmt.Sprintf("offlinetx-%s-%s.json", account.signingConfiguration.Hash(), account.code)
+	account.offlineTxs, err = offlinetx.NewStore(path.Join(account.dbFolder, offlineTxsName), account.log)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// rollbackInit undoes a failed Init.
+func (account *Account) rollbackInit() {
+	if account.db != nil {
+		if err := account.db.Close(); err != nil {
+			account.log.WithError(err).Error("couldn't close db")
+		}
+	}
+	defer account.Lock()()
+	account.db = nil
+	account.vault = nil
+	account.watchtower = nil
+	account.invoices = nil
+	account.signingConfiguration = nil
+}
+
 // Info holds account information.
 type Info struct {
 	SigningConfiguration *signing.Configuration `json:"signingConfiguration"`
@@ -346,6 +520,15 @@ func (account *Account) onNewHeader(header *blockchain.Header) error {
 	account.log.WithField("block-height", header.BlockHeight).Debug("Received new header")
 	// Fee estimates change with each block.
 	account.updateFeeTargets()
+	// Take a balance snapshot at least once per block, so there is one for each day the app runs,
+	// even if the wallet does not change.
+	go account.snapshotBalance()
+	go account.checkWatchtower()
+	// Invoices expire over time.
+	go account.checkInvoices()
+	if account.config.Config().Backend.FeeBumpPolicy.Enabled {
+		go account.checkFeeBumps()
+	}
 	return nil
 }
 
@@ -548,13 +731,28 @@ func (account *Account) Transactions() []*transactions.TxInfo {
 		})
 }
 
-// GetUnusedReceiveAddresses returns a number of unused addresses.
+// GetUnusedReceiveAddresses returns a number of unused addresses. Addresses bound to invoices are
+// skipped, so that other payments are not mistaken for payments of an invoice.
 func (account *Account) GetUnusedReceiveAddresses() []*addresses.AccountAddress {
 	account.synchronizer.WaitSynchronized()
+	var bound map[blockchain.ScriptHashHex]struct{}
+	if account.invoices != nil {
+		bound = account.invoices.Bound()
+	}
 	defer account.RLock()()
 	account.log.Debug("Get unused receive address")
-	// Limit to `gapLimit` receive addresses, even if the actual limit is higher when scanning.
-	return account.receiveAddresses.GetUnused()[:gapLimit]
+	unused := []*addresses.AccountAddress{}
+	for _, address := range account.receiveAddresses.GetUnused() {
+		if _, ok := bound[address.PubkeyScriptHashHex()]; ok {
+			continue
+		}
+		unused = append(unused, address)
+		// Limit to `gapLimit` receive addresses, even if the actual limit is higher when scanning.
+		if len(unused) == gapLimit {
+			break
+		}
+	}
+	return unused
 }
 
 // VerifyAddress verifies a receive address on a keystore. Returns false, nil if no secure output
@@ -616,6 +814,7 @@ func (p *byValue) Swap(i, j int) { p.outputs[i], p.outputs[j] = p.outputs[j], p.
 type SpendableOutput struct {
 	*transactions.SpendableOutput
 	OutPoint wire.OutPoint
+	Privacy  *transactions.OutputPrivacy
 }
 
 // SpendableOutputs returns the utxo set, sorted by the value descending.
@@ -623,8 +822,24 @@ func (account *Account) SpendableOutputs() []*SpendableOutput {
 	account.synchronizer.WaitSynchronized()
 	defer account.RLock()()
 	result := []*SpendableOutput{}
+	privacy := account.transactions.OutputsPrivacy()
+	reserved := account.offlineTxs.Reserved()
 	for outPoint, txOut := range account.transactions.SpendableOutputs() {
-		result = append(result, &SpendableOutput{OutPoint: outPoint, SpendableOutput: txOut})
+		// Vaulted coins can only be spent by unvaulting them first.
+		if account.vault != nil && account.vault.IsVaulted(outPoint) {
+			continue
+		}
+		if account.isPaymentCodeOutput(txOut.ScriptHashHex()) {
+			continue
+		}
+		if _, ok := reserved[outPoint]; ok {
+			continue
+		}
+		result = append(result, &SpendableOutput{
+			OutPoint:        outPoint,
+			SpendableOutput: txOut,
+			Privacy:         privacy[outPoint],
+		})
 	}
 	sort.Sort(sort.Reverse(&byValue{result}))
 	return result
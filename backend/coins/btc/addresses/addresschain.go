@@ -100,6 +100,17 @@ func (addresses *AddressChain) unusedTailCount() int {
 	return count
 }
 
+// Used returns the addresses which have a transaction history.
+func (addresses *AddressChain) Used() []*AccountAddress {
+	used := []*AccountAddress{}
+	for _, address := range addresses.addresses {
+		if address.isUsed() {
+			used = append(used, address)
+		}
+	}
+	return used
+}
+
 // LookupByScriptHashHex returns the address which matches the provided scriptHashHex. Returns nil
 // if not found.
 func (addresses *AddressChain) LookupByScriptHashHex(hashHex blockchain.ScriptHashHex) *AccountAddress {
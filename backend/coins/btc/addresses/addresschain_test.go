@@ -127,3 +127,13 @@ func (s *addressChainTestSuite) TestEnsureAddresses() {
 	newAddresses[s.gapLimit-1].HistoryStatus = "used"
 	require.Len(s.T(), s.addresses.EnsureAddresses(), s.gapLimit)
 }
+
+func (s *addressChainTestSuite) TestUsed() {
+	require.Empty(s.T(), s.addresses.Used())
+	newAddresses := s.addresses.EnsureAddresses()
+	require.Empty(s.T(), s.addresses.Used())
+	newAddresses[1].HistoryStatus = blockchain.TxHistory{tx1}.Status()
+	newAddresses[3].HistoryStatus = blockchain.TxHistory{tx1}.Status()
+	require.Equal(s.T(),
+		[]*addresses.AccountAddress{newAddresses[1], newAddresses[3]}, s.addresses.Used())
+}
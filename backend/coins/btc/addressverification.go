@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// AddressVerificationStatus is the outcome of displaying a receive address on the keystore.
+type AddressVerificationStatus string
+
+const (
+	// AddressVerified means the address was displayed on the keystore for the user to compare.
+	AddressVerified AddressVerificationStatus = "verified"
+	// AddressVerificationFailed means the address could not be displayed on the keystore, or the user
+	// aborted.
+	AddressVerificationFailed AddressVerificationStatus = "failed"
+	// AddressVerificationSkipped means the address was not displayed because the verification of a
+	// previous address in the same batch failed.
+	AddressVerificationSkipped AddressVerificationStatus = "skipped"
+)
+
+// maxAddressVerificationBatch is the maximum number of addresses which can be verified in one
+// batch, which is the number of unused receive addresses handed out.
+const maxAddressVerificationBatch = gapLimit
+
+// AddressVerification is the result of verifying a receive address on the keystore.
+type AddressVerification struct {
+	Address *addresses.AccountAddress
+	Status  AddressVerificationStatus
+	// Err is the reason the verification failed, if the status is AddressVerificationFailed.
+	Err  error
+	Time time.Time
+}
+
+// VerifyAddresses displays the next count unused receive addresses one after another on the
+// keystore, e.g. to hand out several invoice addresses at once. The result per address is returned
+// and recorded, see AddressVerification(). If the user aborts or the keystore fails to display an
+// address, the remaining addresses are skipped.
+func (account *Account) VerifyAddresses(count int) ([]*AddressVerification, error) {
+	if count < 1 || count > maxAddressVerificationBatch {
+		return nil, errp.WithStack(TxValidationError("invalid number of addresses"))
+	}
+	if !account.Keystores().HaveSecureOutput() {
+		return nil, errp.New("no keystore with a secure output to verify addresses on")
+	}
+	// Not holding the account lock while waiting for the user to confirm on the device.
+	receiveAddresses := account.GetUnusedReceiveAddresses()
+	if count < len(receiveAddresses) {
+		receiveAddresses = receiveAddresses[:count]
+	}
+	results := make([]*AddressVerification, len(receiveAddresses))
+	stop := false
+	for index, address := range receiveAddresses {
+		result := &AddressVerification{
+			Address: address,
+			Status:  AddressVerificationSkipped,
+			Time:    time.Now(),
+		}
+		results[index] = result
+		if stop {
+			continue
+		}
+		if err := account.Keystores().OutputAddress(address.Configuration, account.Coin()); err != nil {
+			result.Status = AddressVerificationFailed
+			result.Err = err
+			stop = true
+		} else {
+			result.Status = AddressVerified
+		}
+		account.log.WithFields(logrus.Fields{
+			"index": index, "status": result.Status}).Info("Verified receive address")
+	}
+	defer account.addressVerificationsLock.Lock()()
+	for _, result := range results {
+		if result.Status != AddressVerificationSkipped {
+			account.addressVerifications[result.Address.PubkeyScriptHashHex()] = result
+		}
+	}
+	return results, nil
+}
+
+// AddressVerification returns the latest recorded result of verifying the given receive address
+// with VerifyAddresses(), or nil if it was not verified yet.
+func (account *Account) AddressVerification(scriptHashHex blockchain.ScriptHashHex) *AddressVerification {
+	defer account.addressVerificationsLock.RLock()()
+	return account.addressVerifications[scriptHashHex]
+}
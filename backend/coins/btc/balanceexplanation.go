@@ -0,0 +1,104 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/watchtower"
+)
+
+// BalanceExplanation decomposes the balance of the account, so that the UI can explain why the
+// available balance is not what the user expects. Every unspent output is counted in exactly one
+// of the amounts, so they add up to the available plus the incoming balance.
+type BalanceExplanation struct {
+	// Confirmed is the value of confirmed outputs.
+	Confirmed btcutil.Amount
+	// UnconfirmedIncoming is the value of unconfirmed outputs received from others. It is not
+	// available until the tx confirms.
+	UnconfirmedIncoming btcutil.Amount
+	// UnconfirmedChange is the value of unconfirmed outputs of txs which only spend coins of the
+	// wallet, like change. It is available right away.
+	UnconfirmedChange btcutil.Amount
+	// Dust is the value of spendable outputs which cost more in fees to spend than they are worth
+	// at DustFeeRatePerKb.
+	Dust btcutil.Amount
+	// DustFeeRatePerKb is the lowest estimated fee rate, or nil if no fee rate is known, in which
+	// case no outputs are counted as dust.
+	DustFeeRatePerKb *btcutil.Amount
+	// Reserved is the value of outputs spent by watched txs which have not been broadcast yet, see
+	// WatchedTxs.
+	Reserved btcutil.Amount
+}
+
+// reservedOutputs returns the outputs spent by watched txs which are waiting to be broadcast.
+func (account *Account) reservedOutputs() map[wire.OutPoint]struct{} {
+	reserved := map[wire.OutPoint]struct{}{}
+	if account.watchtower == nil {
+		return reserved
+	}
+	for _, watchedTx := range account.watchtower.WatchedTxs() {
+		if watchedTx.State != watchtower.StatePending && watchedTx.State != watchtower.StateBroadcastable {
+			continue
+		}
+		for _, txIn := range watchedTx.Tx.TxIn {
+			reserved[txIn.PreviousOutPoint] = struct{}{}
+		}
+	}
+	return reserved
+}
+
+// lowestFeeRate returns the lowest estimated fee rate, or nil if there is none.
+func (account *Account) lowestFeeRate() *btcutil.Amount {
+	feeTargets, _ := account.FeeTargets()
+	var lowest *btcutil.Amount
+	for _, feeTarget := range feeTargets {
+		if lowest == nil || *feeTarget.FeeRatePerKb < *lowest {
+			lowest = feeTarget.FeeRatePerKb
+		}
+	}
+	return lowest
+}
+
+// ExplainBalance decomposes the balance of the account. See BalanceExplanation. Reserved outputs
+// take precedence over dust, which takes precedence over the confirmation status. The app has no
+// way to freeze coins, so dust is the only kind of quarantined output.
+func (account *Account) ExplainBalance() *BalanceExplanation {
+	explanation := &BalanceExplanation{DustFeeRatePerKb: account.lowestFeeRate()}
+	var dustLimit btcutil.Amount
+	if explanation.DustFeeRatePerKb != nil {
+		dustLimit = maketx.InputFee(account.signingConfiguration, *explanation.DustFeeRatePerKb)
+	}
+	reserved := account.reservedOutputs()
+	for _, output := range account.transactions.UnspentOutputs() {
+		value := btcutil.Amount(output.TxOut.Value)
+		spendable := output.Confirmed || output.OwnInputs
+		_, isReserved := reserved[output.OutPoint]
+		switch {
+		case isReserved:
+			explanation.Reserved += value
+		case spendable && value <= dustLimit:
+			explanation.Dust += value
+		case output.Confirmed:
+			explanation.Confirmed += value
+		case output.OwnInputs:
+			explanation.UnconfirmedChange += value
+		default:
+			explanation.UnconfirmedIncoming += value
+		}
+	}
+	return explanation
+}
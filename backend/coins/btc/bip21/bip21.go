@@ -0,0 +1,103 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bip21 encodes and decodes payment URIs as specified in BIP21, e.g.
+// "bitcoin:<address>?amount=0.1&label=Shop".
+package bip21
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// URI is a payment request. Amount, Label and Message are optional.
+type URI struct {
+	Scheme  string
+	Address string
+	Amount  btcutil.Amount
+	Label   string
+	Message string
+}
+
+// escape percent-encodes the value. Spaces are encoded as "%20", as some wallets don't decode "+".
+func escape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+// String returns the URI, e.g. "bitcoin:<address>?amount=0.1".
+func (uri *URI) String() string {
+	params := []string{}
+	if uri.Amount > 0 {
+		params = append(params, "amount="+strconv.FormatFloat(uri.Amount.ToBTC(), 'f', -1, 64))
+	}
+	if uri.Label != "" {
+		params = append(params, "label="+escape(uri.Label))
+	}
+	if uri.Message != "" {
+		params = append(params, "message="+escape(uri.Message))
+	}
+	encoded := uri.Scheme + ":" + uri.Address
+	if len(params) > 0 {
+		encoded += "?" + strings.Join(params, "&")
+	}
+	return encoded
+}
+
+// Parse decodes a payment URI with the given scheme. Unknown parameters prefixed with "req-" are
+// rejected, as required by BIP21.
+func Parse(scheme string, encoded string) (*URI, error) {
+	prefix := scheme + ":"
+	if len(encoded) < len(prefix) || !strings.EqualFold(encoded[:len(prefix)], prefix) {
+		return nil, errp.Newf("the URI must start with %s", prefix)
+	}
+	rest := encoded[len(prefix):]
+	uri := &URI{Scheme: scheme, Address: rest}
+	query := ""
+	if index := strings.Index(rest, "?"); index >= 0 {
+		uri.Address, query = rest[:index], rest[index+1:]
+	}
+	if uri.Address == "" {
+		return nil, errp.New("the URI contains no address")
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	for key, value := range values {
+		switch key {
+		case "amount":
+			amount, err := strconv.ParseFloat(value[0], 64)
+			if err != nil {
+				return nil, errp.Newf("invalid amount %s", value[0])
+			}
+			if uri.Amount, err = btcutil.NewAmount(amount); err != nil || uri.Amount <= 0 {
+				return nil, errp.Newf("invalid amount %s", value[0])
+			}
+		case "label":
+			uri.Label = value[0]
+		case "message":
+			uri.Message = value[0]
+		default:
+			if strings.HasPrefix(key, "req-") {
+				return nil, errp.Newf("unsupported required parameter %s", key)
+			}
+		}
+	}
+	return uri, nil
+}
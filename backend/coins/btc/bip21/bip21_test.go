@@ -0,0 +1,64 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bip21_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/bip21"
+)
+
+const address = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+
+func TestString(t *testing.T) {
+	uri := &bip21.URI{Scheme: "bitcoin", Address: address}
+	require.Equal(t, "bitcoin:"+address, uri.String())
+
+	uri.Amount = btcutil.Amount(2030000000)
+	uri.Label = "Luke Jr"
+	uri.Message = "Donation & thanks"
+	require.Equal(t,
+		"bitcoin:"+address+"?amount=20.3&label=Luke%20Jr&message=Donation%20%26%20thanks",
+		uri.String())
+}
+
+func TestParse(t *testing.T) {
+	uri, err := bip21.Parse("bitcoin", "BITCOIN:"+address+"?amount=50&label=Luke-Jr&message=Donation%20for%20project%20xyz")
+	require.NoError(t, err)
+	require.Equal(t, address, uri.Address)
+	require.Equal(t, btcutil.Amount(5000000000), uri.Amount)
+	require.Equal(t, "Luke-Jr", uri.Label)
+	require.Equal(t, "Donation for project xyz", uri.Message)
+
+	encoded := (&bip21.URI{Scheme: "litecoin", Address: address, Amount: 1, Label: "a b&c"}).String()
+	uri, err = bip21.Parse("litecoin", encoded)
+	require.NoError(t, err)
+	require.Equal(t, "a b&c", uri.Label)
+	require.Equal(t, btcutil.Amount(1), uri.Amount)
+
+	_, err = bip21.Parse("bitcoin", "litecoin:"+address)
+	require.Error(t, err)
+	_, err = bip21.Parse("bitcoin", "bitcoin:?amount=1")
+	require.Error(t, err)
+	_, err = bip21.Parse("bitcoin", "bitcoin:"+address+"?amount=abc")
+	require.Error(t, err)
+	_, err = bip21.Parse("bitcoin", "bitcoin:"+address+"?req-somethingyoudontunderstand=50")
+	require.Error(t, err)
+	_, err = bip21.Parse("bitcoin", "bitcoin:"+address+"?somethingyoudontunderstand=50")
+	require.NoError(t, err)
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bip47 implements receiving payments to reusable payment codes, see
+// https://github.com/bitcoin/bips/blob/master/bip-0047.mediawiki. A payment code is a static
+// identifier which can be shared publicly. A sender first announces itself to the owner of the
+// payment code with a notification tx. Afterwards, both can derive a sequence of addresses which
+// only they know about, so that the addresses are not reused.
+package bip47
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// version is the supported version of payment codes.
+	version = 1
+	// payloadSize is the size of the binary payment code.
+	payloadSize = 80
+	// base58Version is the version byte of encoded payment codes, which makes them start with
+	// "PM8T".
+	base58Version = 0x47
+)
+
+// ErrInvalidIndex is returned by ReceivePublicKey() if the shared secret does not yield a valid
+// key. The index is skipped by both the sender and the recipient.
+var ErrInvalidIndex = errors.New("the shared secret does not yield a valid key")
+
+// PaymentCode is a version 1 payment code, the public key and the chain code of the extended key
+// at m/47'/coin'/account'.
+type PaymentCode struct {
+	publicKey *btcec.PublicKey
+	chainCode []byte
+}
+
+// New creates the payment code of the given extended public key at m/47'/coin'/account'.
+func New(extendedKey *hdkeychain.ExtendedKey) (*PaymentCode, error) {
+	publicKey, err := extendedKey.ECPubKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	// The serialized key is version (4), depth (1), parent fingerprint (4), child number (4),
+	// chain code (32) and key (33), followed by the checksum (4).
+	serialized := base58.Decode(extendedKey.String())
+	if len(serialized) != 82 {
+		return nil, errp.New("invalid extended key")
+	}
+	return &PaymentCode{publicKey: publicKey, chainCode: append([]byte{}, serialized[13:45]...)}, nil
+}
+
+// Parse decodes a payment code encoded with String().
+func Parse(encoded string) (*PaymentCode, error) {
+	payload, versionByte, err := base58.CheckDecode(encoded)
+	if err != nil || versionByte != base58Version {
+		return nil, errp.New("invalid payment code")
+	}
+	return parsePayload(payload)
+}
+
+func parsePayload(payload []byte) (*PaymentCode, error) {
+	if len(payload) != payloadSize {
+		return nil, errp.New("invalid payment code")
+	}
+	if payload[0] != version {
+		return nil, errp.Newf("unsupported payment code version %d", payload[0])
+	}
+	publicKey, err := btcec.ParsePubKey(payload[2:35], btcec.S256())
+	if err != nil {
+		return nil, errp.Wrap(err, "invalid payment code public key")
+	}
+	return &PaymentCode{publicKey: publicKey, chainCode: append([]byte{}, payload[35:67]...)}, nil
+}
+
+// payload returns the binary payment code. No features are signalled.
+func (paymentCode *PaymentCode) payload() []byte {
+	payload := make([]byte, payloadSize)
+	payload[0] = version
+	copy(payload[2:35], paymentCode.publicKey.SerializeCompressed())
+	copy(payload[35:67], paymentCode.chainCode)
+	return payload
+}
+
+// String encodes the payment code, e.g. "PM8TJS2JxQ5z...".
+func (paymentCode *PaymentCode) String() string {
+	return base58.CheckEncode(paymentCode.payload(), base58Version)
+}
+
+// ChildPublicKey returns the public key of the child with the given non-hardened index.
+func (paymentCode *PaymentCode) ChildPublicKey(index uint32) (*btcec.PublicKey, error) {
+	// The version is irrelevant for the derivation.
+	extendedKey := hdkeychain.NewExtendedKey(chaincfg.MainNetParams.HDPublicKeyID[:],
+		paymentCode.publicKey.SerializeCompressed(), paymentCode.chainCode, []byte{0, 0, 0, 0}, 3, 0, false)
+	child, err := extendedKey.Child(index)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	publicKey, err := child.ECPubKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return publicKey, nil
+}
+
+// NotificationAddress returns the address which senders pay with the notification tx, the P2PKH
+// address of child 0.
+func (paymentCode *PaymentCode) NotificationAddress(net *chaincfg.Params) (btcutil.Address, error) {
+	publicKey, err := paymentCode.ChildPublicKey(0)
+	if err != nil {
+		return nil, err
+	}
+	address, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(publicKey.SerializeCompressed()), net)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return address, nil
+}
+
+// ReceivePublicKey returns the public key at which a sender pays the owner of the child with the
+// given public key. The shared secret is the one of the child private key and the public key of
+// child 0 of the sender's payment code. ErrInvalidIndex is returned if the index of the child must
+// be skipped.
+func ReceivePublicKey(childPublicKey *btcec.PublicKey, sharedSecret []byte) (*btcec.PublicKey, error) {
+	curve := btcec.S256()
+	hash := sha256.Sum256(sharedSecret)
+	if new(big.Int).SetBytes(hash[:]).Cmp(curve.N) >= 0 {
+		return nil, errp.WithStack(ErrInvalidIndex)
+	}
+	x, y := curve.ScalarBaseMult(hash[:])
+	x, y = curve.Add(childPublicKey.X, childPublicKey.Y, x, y)
+	return &btcec.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// ReceiveAddresses returns the addresses of the given receive public key. Senders pay to the P2PKH
+// address, and some wallets to the segwit addresses of the same key.
+func ReceiveAddresses(publicKey *btcec.PublicKey, net *chaincfg.Params) ([]btcutil.Address, error) {
+	publicKeyHash := btcutil.Hash160(publicKey.SerializeCompressed())
+	p2pkh, err := btcutil.NewAddressPubKeyHash(publicKeyHash, net)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(publicKeyHash, net)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	redeemScript, err := txscript.PayToAddrScript(p2wpkh)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	p2wpkhP2SH, err := btcutil.NewAddressScriptHash(redeemScript, net)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return []btcutil.Address{p2pkh, p2wpkhP2SH, p2wpkh}, nil
+}
+
+// Notification is the notification of a sender, see ParseNotification().
+type Notification struct {
+	// OutPoint is the output spent by the designated input, the first input exposing a public key.
+	OutPoint wire.OutPoint
+	// PublicKey is the public key of the designated input.
+	PublicKey *btcec.PublicKey
+	// blindedPayload is the payment code of the sender, blinded with the shared secret of
+	// PublicKey and the key of the notification address.
+	blindedPayload []byte
+}
+
+// ParseNotification returns the notification contained in the tx if it pays to the notification
+// address with the given pkScript, and has an OP_RETURN output with a blinded payment code.
+func ParseNotification(tx *wire.MsgTx, notificationPkScript []byte) (*Notification, bool) {
+	notification := &Notification{}
+	paysNotificationAddress := false
+	for _, txOut := range tx.TxOut {
+		if bytes.Equal(txOut.PkScript, notificationPkScript) {
+			paysNotificationAddress = true
+			continue
+		}
+		if txscript.GetScriptClass(txOut.PkScript) != txscript.NullDataTy {
+			continue
+		}
+		pushes, err := txscript.PushedData(txOut.PkScript)
+		if err != nil || len(pushes) != 1 || len(pushes[0]) != payloadSize || pushes[0][0] != version {
+			continue
+		}
+		notification.blindedPayload = pushes[0]
+	}
+	if !paysNotificationAddress || notification.blindedPayload == nil {
+		return nil, false
+	}
+	for _, txIn := range tx.TxIn {
+		if publicKey := inputPublicKey(txIn); publicKey != nil {
+			notification.OutPoint = txIn.PreviousOutPoint
+			notification.PublicKey = publicKey
+			return notification, true
+		}
+	}
+	return nil, false
+}
+
+// inputPublicKey returns the public key exposed by a P2PKH, P2WPKH or P2SH-P2WPKH input, or nil.
+func inputPublicKey(txIn *wire.TxIn) *btcec.PublicKey {
+	var candidate []byte
+	if len(txIn.Witness) == 2 {
+		candidate = txIn.Witness[1]
+	} else if pushes, err := txscript.PushedData(txIn.SignatureScript); err == nil && len(pushes) == 2 {
+		candidate = pushes[1]
+	}
+	if len(candidate) != 33 && len(candidate) != 65 {
+		return nil
+	}
+	publicKey, err := btcec.ParsePubKey(candidate, btcec.S256())
+	if err != nil {
+		return nil
+	}
+	return publicKey
+}
+
+// PaymentCode unblinds the payment code of the sender. The shared secret is the one of the public
+// key of the designated input and the private key of the notification address.
+func (notification *Notification) PaymentCode(sharedSecret []byte) (*PaymentCode, error) {
+	outPoint := make([]byte, 36)
+	copy(outPoint, notification.OutPoint.Hash[:])
+	binary.LittleEndian.PutUint32(outPoint[32:], notification.OutPoint.Index)
+	mac := hmac.New(sha512.New, outPoint)
+	_, _ = mac.Write(sharedSecret)
+	mask := mac.Sum(nil)
+	payload := append([]byte{}, notification.blindedPayload...)
+	for i := 0; i < 32; i++ {
+		payload[3+i] ^= mask[i]
+		payload[35+i] ^= mask[32+i]
+	}
+	return parsePayload(payload)
+}
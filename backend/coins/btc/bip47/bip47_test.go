@@ -0,0 +1,157 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bip47_test
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/bip47"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+)
+
+// The test vectors of Alice and Bob are from
+// https://gist.github.com/SamouraiDev/6aad669604c5930864bd.
+const (
+	alicePaymentCode = "PM8TJTLJbPRGxSbc8EJi42Wrr6QbNSaSSVJ5Y3E4pbCYiTHUskHg13935Ubb7q8tx9GVbh2UuRnBc3WSyJHhUrw8KhprKnn9eDznYGieTzFcwQRya4GA"
+	bobPaymentCode   = "PM8TJS2JxQ5ztXUpBBRnpTbcUXbUHy2T1abfrb3KkAAtMEGNbey4oumH7Hc578WgQJhPjBxteQ5GHHToTYHE3A1w6p7tU6KSoFmWBVbFGjKPisZDbP97"
+	bobMnemonic      = "reward upper indicate eight swift arch injury crystal super wrestle already dentist"
+)
+
+func mustKeypath(t *testing.T, keypath string) signing.AbsoluteKeypath {
+	absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+	require.NoError(t, err)
+	return absoluteKeypath
+}
+
+// bob returns a keystore with the seed of Bob and his payment code.
+func bob(t *testing.T) (*software.Keystore, *bip47.PaymentCode) {
+	seed := pbkdf2.Key([]byte(bobMnemonic), []byte("mnemonic"), 2048, 64, sha512.New)
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	keystore := software.NewKeystore(0, master)
+	extendedKey, err := keystore.ExtendedPublicKey(mustKeypath(t, "m/47'/0'/0'"))
+	require.NoError(t, err)
+	paymentCode, err := bip47.New(extendedKey)
+	require.NoError(t, err)
+	return keystore, paymentCode
+}
+
+func TestPaymentCode(t *testing.T) {
+	_, paymentCode := bob(t)
+	require.Equal(t, bobPaymentCode, paymentCode.String())
+	notificationAddress, err := paymentCode.NotificationAddress(&chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Equal(t, "1ChvUUvht2hUQufHBXF8NgLhW8SwE2ecGV", notificationAddress.EncodeAddress())
+
+	parsed, err := bip47.Parse(bobPaymentCode)
+	require.NoError(t, err)
+	require.Equal(t, bobPaymentCode, parsed.String())
+	_, err = bip47.Parse(bobPaymentCode[:len(bobPaymentCode)-1] + "8")
+	require.Error(t, err)
+	_, err = bip47.Parse("1ChvUUvht2hUQufHBXF8NgLhW8SwE2ecGV")
+	require.Error(t, err)
+}
+
+func TestNotification(t *testing.T) {
+	keystore, paymentCode := bob(t)
+	notificationAddress, err := paymentCode.NotificationAddress(&chaincfg.MainNetParams)
+	require.NoError(t, err)
+	notificationPkScript, err := txscript.PayToAddrScript(notificationAddress)
+	require.NoError(t, err)
+
+	designatedPublicKey, err := hex.DecodeString(
+		"0272d83d8a1fa323feab1c085157a0791b46eba34afb8bfbfaeb3a3fcc3f2c9ad8")
+	require.NoError(t, err)
+	blindedPayload, err := hex.DecodeString("010002063e4eb95e62791b06c50e1a3a942e1ecaaa9afbbeb324d16ae6821e091611fa96c0cf048f607fe51a0327f5e2528979311c78cb2de0d682c61e1180fc3d543b00000000000000000000000000")
+	require.NoError(t, err)
+	opReturn, err := txscript.NullDataScript(blindedPayload)
+	require.NoError(t, err)
+	signatureScript, err := txscript.NewScriptBuilder().
+		AddData([]byte{0x30, 0x01}).AddData(designatedPublicKey).Script()
+	require.NoError(t, err)
+	outPointHash, err := chainhash.NewHashFromStr(
+		"9c6000d597c5008f7bfc2618aed5e4a6ae57677aab95078aae708e1cab11f486")
+	require.NoError(t, err)
+	outPoint := wire.OutPoint{Hash: *outPointHash, Index: 1}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&outPoint, signatureScript, nil))
+	tx.AddTxOut(wire.NewTxOut(10000, notificationPkScript))
+	tx.AddTxOut(wire.NewTxOut(0, opReturn))
+
+	notification, ok := bip47.ParseNotification(tx, notificationPkScript)
+	require.True(t, ok)
+	require.Equal(t, outPoint, notification.OutPoint)
+	require.Equal(t, designatedPublicKey, notification.PublicKey.SerializeCompressed())
+	sharedSecret, err := keystore.SharedSecret(mustKeypath(t, "m/47'/0'/0'/0"), notification.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, "736a25d9250238ad64ed5da03450c6a3f4f8f4dcdf0b58d1ed69029d76ead48d",
+		hex.EncodeToString(sharedSecret))
+	sender, err := notification.PaymentCode(sharedSecret)
+	require.NoError(t, err)
+	require.Equal(t, alicePaymentCode, sender.String())
+
+	// The designated input can also be a segwit input.
+	tx.TxIn[0].SignatureScript = nil
+	tx.TxIn[0].Witness = wire.TxWitness{[]byte{0x30, 0x01}, designatedPublicKey}
+	notification, ok = bip47.ParseNotification(tx, notificationPkScript)
+	require.True(t, ok)
+	require.Equal(t, designatedPublicKey, notification.PublicKey.SerializeCompressed())
+
+	// Txs which don't pay to the notification address are no notifications.
+	_, ok = bip47.ParseNotification(tx, []byte{txscript.OP_TRUE})
+	require.False(t, ok)
+}
+
+func TestReceiveAddresses(t *testing.T) {
+	keystore, paymentCode := bob(t)
+	alice, err := bip47.Parse(alicePaymentCode)
+	require.NoError(t, err)
+	aliceNotificationPublicKey, err := alice.ChildPublicKey(0)
+	require.NoError(t, err)
+	expected := []string{
+		"141fi7TY3h936vRUKh1qfUZr8rSBuYbVBK",
+		"12u3Uued2fuko2nY4SoSFGCoGLCBUGPkk6",
+		"1FsBVhT5dQutGwaPePTYMe5qvYqqjxyftc",
+	}
+	for index, expectedAddress := range expected {
+		sharedSecret, err := keystore.SharedSecret(
+			mustKeypath(t, "m/47'/0'/0'").Child(uint32(index), signing.NonHardened),
+			aliceNotificationPublicKey)
+		require.NoError(t, err)
+		childPublicKey, err := paymentCode.ChildPublicKey(uint32(index))
+		require.NoError(t, err)
+		publicKey, err := bip47.ReceivePublicKey(childPublicKey, sharedSecret)
+		require.NoError(t, err)
+		addresses, err := bip47.ReceiveAddresses(publicKey, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		require.Len(t, addresses, 3)
+		require.Equal(t, expectedAddress, addresses[0].EncodeAddress())
+		publicKeyHash := btcutil.Hash160(publicKey.SerializeCompressed())
+		require.Equal(t, publicKeyHash, addresses[2].(*btcutil.AddressWitnessPubKeyHash).WitnessProgram())
+	}
+}
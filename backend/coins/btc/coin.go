@@ -27,6 +27,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
 	coinpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/headersdb"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
@@ -80,7 +81,9 @@ func NewCoin(
 // Init initializes the coin - blockchain and headers.
 func (coin *Coin) Init() {
 	// Init blockchain
-	coin.blockchain = electrum.NewElectrumConnection(coin.servers, coin.log)
+	if coin.blockchain == nil {
+		coin.blockchain = electrum.NewElectrumConnection(coin.servers, coin.log)
+	}
 
 	// Init Headers
 	db, err := headersdb.NewDB(
@@ -113,6 +116,12 @@ func (coin *Coin) Init() {
 	}
 }
 
+// SetBlockchain makes the coin use the given blockchain backend instead of connecting to the
+// configured servers. It must be called before Init().
+func (coin *Coin) SetBlockchain(blockchain blockchain.Interface) {
+	coin.blockchain = blockchain
+}
+
 // Name returns the coin's name.
 func (coin *Coin) Name() string {
 	return coin.name
@@ -123,6 +132,17 @@ func (coin *Coin) Net() *chaincfg.Params {
 	return coin.net
 }
 
+// URIScheme returns the scheme of BIP21 payment URIs for this coin, e.g. "bitcoin".
+func (coin *Coin) URIScheme() string {
+	switch coin.name {
+	case "btc", "tbtc", "rbtc":
+		return "bitcoin"
+	case "ltc", "tltc":
+		return "litecoin"
+	}
+	return strings.ToLower(coin.name)
+}
+
 // Unit implements coin.Coin.
 func (coin *Coin) Unit() string {
 	return coin.unit
@@ -168,6 +188,42 @@ func (coin *Coin) FormatAmountAsJSON(amount int64) coinpkg.FormattedAmount {
 	}
 }
 
+// FiatRate returns the latest exchange rate of the coin to the given fiat currency. false is
+// returned if no exchange rate is available.
+func (coin *Coin) FiatRate(fiatUnit string) (float64, bool) {
+	if coin.ratesUpdater == nil || fiatUnit == "" {
+		return 0, false
+	}
+	rate, ok := coin.ratesUpdater.Last()[coin.RatesUnit()][fiatUnit]
+	return rate, ok
+}
+
+// RatesUnit returns the unit under which exchange rates of the coin are listed. Testnet coins use
+// the rates of the mainnet coin.
+func (coin *Coin) RatesUnit() string {
+	unit := coin.unit
+	if len(unit) == 4 && strings.HasPrefix(unit, "T") {
+		unit = unit[1:]
+	}
+	return unit
+}
+
+// fiatConversion converts the amount to the given fiat currency using the latest exchange rates.
+// nil is returned if no exchange rate is available.
+func (coin *Coin) fiatConversion(amount btcutil.Amount, fiatUnit string) *maketx.FiatConversion {
+	rate, ok := coin.FiatRate(fiatUnit)
+	if !ok {
+		return nil
+	}
+	return &maketx.FiatConversion{
+		Unit:          fiatUnit,
+		Amount:        formatAsCurrency(amount.ToUnit(btcutil.AmountBTC) * rate),
+		Rate:          rate,
+		RateTimestamp: coin.ratesUpdater.LastUpdate(),
+		RateSource:    coin.ratesUpdater.Source(),
+	}
+}
+
 // RatesUpdater returns current exchange rates.
 func (coin *Coin) RatesUpdater() coinpkg.RatesUpdater {
 	return coin.ratesUpdater
@@ -16,8 +16,13 @@ package btc
 
 import (
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcutil"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 )
 
 func TestFormatAsCurrency(t *testing.T) {
@@ -26,3 +31,48 @@ func TestFormatAsCurrency(t *testing.T) {
 	assert.Equal(t, "1'234.56", formatAsCurrency(1234.555))
 	assert.Equal(t, "12'345'678.90", formatAsCurrency(12345678.9))
 }
+
+type ratesUpdaterMock struct {
+	observable.Implementation
+	lastUpdate time.Time
+}
+
+func (updater *ratesUpdaterMock) Last() map[string]map[string]float64 {
+	return map[string]map[string]float64{"BTC": {"USD": 6500}}
+}
+
+func (updater *ratesUpdaterMock) LastUpdate() time.Time {
+	return updater.lastUpdate
+}
+
+func (updater *ratesUpdaterMock) Source() string {
+	return "mock"
+}
+
+func TestFiatConversion(t *testing.T) {
+	lastUpdate := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	coin := &Coin{unit: "TBTC", ratesUpdater: &ratesUpdaterMock{lastUpdate: lastUpdate}}
+	fiat := coin.fiatConversion(btcutil.Amount(50000000), "USD")
+	assert.Equal(t, "USD", fiat.Unit)
+	assert.Equal(t, "3'250.00", fiat.Amount)
+	assert.Equal(t, 6500.0, fiat.Rate)
+	assert.Equal(t, lastUpdate, fiat.RateTimestamp)
+	assert.Equal(t, "mock", fiat.RateSource)
+
+	assert.Nil(t, coin.fiatConversion(btcutil.Amount(50000000), "CHF"))
+	assert.Nil(t, coin.fiatConversion(btcutil.Amount(50000000), ""))
+}
+
+func TestFeeDetails(t *testing.T) {
+	lastUpdate := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	account := &Account{coin: &Coin{unit: "BTC", ratesUpdater: &ratesUpdaterMock{lastUpdate: lastUpdate}}}
+	txProposal := &maketx.TxProposal{Fee: btcutil.Amount(2000), VSize: 400}
+	fee := account.feeDetails(txProposal, "USD")
+	assert.Equal(t, btcutil.Amount(2000), fee.Amount)
+	assert.Equal(t, int64(400), fee.VSize)
+	assert.Equal(t, 5.0, fee.SatPerVByte)
+	assert.Equal(t, "0.13", fee.Fiat.Amount)
+	assert.Equal(t, lastUpdate, fee.Fiat.RateTimestamp)
+
+	assert.Nil(t, account.feeDetails(txProposal, "").Fiat)
+}
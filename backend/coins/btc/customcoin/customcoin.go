@@ -0,0 +1,212 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package customcoin supports UTXO coins compatible with Bitcoin which are not built into the app,
+// but declared in the config through a coin definition signed by the app developers. Like on the
+// built in testnets, the proof of work of the headers of custom coins is not verified.
+//
+// The coin definitions are signed with the key returned by signeddata.TrustedPublicKey(). Builds
+// without it do not support custom coins.
+package customcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// builtinCodes are the codes of the coins built into the app, which custom coins can not replace.
+var builtinCodes = map[string]bool{"btc": true, "tbtc": true, "rbtc": true, "ltc": true, "tltc": true}
+
+var codeRegexp = regexp.MustCompile(`^[a-z][a-z0-9]{1,9}$`)
+
+// purposes are the BIP44 purposes of the script types, see AccountKeypath().
+var purposes = map[signing.ScriptType]uint32{
+	signing.ScriptTypeP2PKH:      44,
+	signing.ScriptTypeP2WPKHP2SH: 49,
+	signing.ScriptTypeP2WPKH:     84,
+}
+
+// Definition declares a custom coin.
+type Definition struct {
+	// Code identifies the coin, e.g. "tdoge". It prefixes the codes of its accounts.
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Unit is the unit of amounts, e.g. "TDOGE".
+	Unit string `json:"unit"`
+	// Testnet coins are only available in testnet mode. Only testnet coins are supported, as the
+	// headers of custom coins are not verified against their proof of work, like on the built in
+	// testnets.
+	Testnet bool `json:"testnet"`
+
+	// NetMagic are the magic bytes of the network messages.
+	NetMagic         uint32 `json:"netMagic"`
+	PubKeyHashAddrID byte   `json:"pubKeyHashAddrID"`
+	ScriptHashAddrID byte   `json:"scriptHashAddrID"`
+	// Bech32HRPSegwit is the human readable part of native segwit addresses. It is required for
+	// p2wpkh accounts.
+	Bech32HRPSegwit string `json:"bech32HRPSegwit"`
+	// HDCoinType is the BIP44 coin type used in the keypaths of the accounts.
+	HDCoinType uint32 `json:"hdCoinType"`
+	// GenesisHeader is the serialized header of the genesis block in hex format.
+	GenesisHeader string `json:"genesisHeader"`
+
+	ElectrumServers       []*rpc.ServerInfo    `json:"electrumServers"`
+	BlockExplorerTxPrefix string               `json:"blockExplorerTxPrefix"`
+	ScriptTypes           []signing.ScriptType `json:"scriptTypes"`
+
+	params *chaincfg.Params
+}
+
+// Verify checks the signature of the signed coin definition against the given public key and
+// returns the validated definition.
+func Verify(signed config.SignedCoinDefinition, publicKey *btcec.PublicKey) (*Definition, error) {
+	if err := signeddata.Verify(signeddata.DomainCoinDefinition, []byte(signed.Definition), signed.Signature, publicKey); err != nil {
+		return nil, errp.WithMessage(err, "Failed to verify the coin definition")
+	}
+	definition := &Definition{}
+	if err := json.Unmarshal([]byte(signed.Definition), definition); err != nil {
+		return nil, errp.Wrap(err, "Failed to decode the coin definition")
+	}
+	if err := definition.validate(); err != nil {
+		return nil, errp.WithMessage(err, fmt.Sprintf("invalid coin definition %s", definition.Code))
+	}
+	return definition, nil
+}
+
+func (definition *Definition) validate() error {
+	if !codeRegexp.MatchString(definition.Code) {
+		return errp.New("the code must be 2 to 10 lowercase letters or digits")
+	}
+	if builtinCodes[definition.Code] {
+		return errp.New("the code is taken by a built in coin")
+	}
+	if definition.Name == "" || definition.Unit == "" {
+		return errp.New("the name and the unit are required")
+	}
+	if !definition.Testnet {
+		return errp.New("only testnet coins are supported")
+	}
+	if len(definition.ElectrumServers) == 0 {
+		return errp.New("at least one Electrum server is required")
+	}
+	if len(definition.ScriptTypes) == 0 {
+		return errp.New("at least one script type is required")
+	}
+	for _, scriptType := range definition.ScriptTypes {
+		if _, ok := purposes[scriptType]; !ok {
+			return errp.Newf("unsupported script type %s", scriptType)
+		}
+		if scriptType == signing.ScriptTypeP2WPKH && definition.Bech32HRPSegwit == "" {
+			return errp.New("p2wpkh accounts require a bech32 prefix")
+		}
+	}
+	params, err := definition.newParams()
+	if err != nil {
+		return err
+	}
+	definition.params = params
+	return nil
+}
+
+func (definition *Definition) newParams() (*chaincfg.Params, error) {
+	headerBytes, err := hex.DecodeString(definition.GenesisHeader)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	genesisHeader := wire.BlockHeader{}
+	if err := genesisHeader.Deserialize(bytes.NewReader(headerBytes)); err != nil {
+		return nil, errp.Wrap(err, "invalid genesis header")
+	}
+	genesisHash := genesisHeader.BlockHash()
+	// The keys are derived like on the built in testnet.
+	hdParams := &chaincfg.TestNet3Params
+	return &chaincfg.Params{
+		Name:         definition.Code,
+		Net:          wire.BitcoinNet(definition.NetMagic),
+		GenesisBlock: &wire.MsgBlock{Header: genesisHeader},
+		GenesisHash:  &genesisHash,
+		PowLimit:     hdParams.PowLimit,
+		PowLimitBits: hdParams.PowLimitBits,
+		// The headers are checked against the last checkpoint, which is the genesis block here.
+		Checkpoints:      []chaincfg.Checkpoint{{Height: 0, Hash: &genesisHash}},
+		PubKeyHashAddrID: definition.PubKeyHashAddrID,
+		ScriptHashAddrID: definition.ScriptHashAddrID,
+		PrivateKeyID:     hdParams.PrivateKeyID,
+		Bech32HRPSegwit:  definition.Bech32HRPSegwit,
+		HDPrivateKeyID:   hdParams.HDPrivateKeyID,
+		HDPublicKeyID:    hdParams.HDPublicKeyID,
+		HDCoinType:       definition.HDCoinType,
+	}, nil
+}
+
+var (
+	registeredNets     = map[wire.BitcoinNet]string{}
+	registeredNetsLock sync.Mutex
+)
+
+// Params returns the network parameters of the coin. They are registered with chaincfg, so that
+// addresses of the coin can be decoded.
+func (definition *Definition) Params() (*chaincfg.Params, error) {
+	registeredNetsLock.Lock()
+	defer registeredNetsLock.Unlock()
+	if code, ok := registeredNets[definition.params.Net]; ok {
+		if code != definition.Code {
+			return nil, errp.Newf("the network magic of %s is taken by %s", definition.Code, code)
+		}
+		return definition.params, nil
+	}
+	if err := chaincfg.Register(definition.params); err != nil {
+		return nil, errp.WithMessage(err, fmt.Sprintf("could not register the network of %s", definition.Code))
+	}
+	registeredNets[definition.params.Net] = definition.Code
+	return definition.params, nil
+}
+
+// AccountCode returns the code of the account of the coin with the given script type.
+func (definition *Definition) AccountCode(scriptType signing.ScriptType) string {
+	return fmt.Sprintf("%s-%s", definition.Code, scriptType)
+}
+
+// AccountName returns the name of the account of the coin with the given script type, named like
+// the accounts of the built in coins.
+func (definition *Definition) AccountName(scriptType signing.ScriptType) string {
+	switch scriptType {
+	case signing.ScriptTypeP2WPKH:
+		return definition.Name + ": bech32"
+	case signing.ScriptTypeP2PKH:
+		return definition.Name + " Legacy"
+	default:
+		return definition.Name
+	}
+}
+
+// AccountKeypath returns the keypath of the account of the coin with the given script type,
+// according to BIP44, BIP49 and BIP84.
+func (definition *Definition) AccountKeypath(scriptType signing.ScriptType) string {
+	return fmt.Sprintf("m/%d'/%d'/0'", purposes[scriptType], definition.HDCoinType)
+}
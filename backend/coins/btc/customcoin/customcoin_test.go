@@ -0,0 +1,142 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package customcoin_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/customcoin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+func genesisHeaderHex(t *testing.T) string {
+	var buf bytes.Buffer
+	require.NoError(t, chaincfg.TestNet3Params.GenesisBlock.Header.Serialize(&buf))
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func testDefinition(t *testing.T) map[string]interface{} {
+	return map[string]interface{}{
+		"code":             "tcst",
+		"name":             "Custom Testnet",
+		"unit":             "TCST",
+		"testnet":          true,
+		"netMagic":         0xdeadbeef,
+		"pubKeyHashAddrID": 0x6f,
+		"scriptHashAddrID": 0xc4,
+		"bech32HRPSegwit":  "tcst",
+		"hdCoinType":       1,
+		"genesisHeader":    genesisHeaderHex(t),
+		"electrumServers": []*rpc.ServerInfo{
+			{Server: "electrum.example.com:50002", TLS: true},
+		},
+		"blockExplorerTxPrefix": "https://explorer.example.com/tx/",
+		"scriptTypes":           []signing.ScriptType{signing.ScriptTypeP2WPKHP2SH, signing.ScriptTypeP2WPKH},
+	}
+}
+
+func sign(t *testing.T, privateKey *btcec.PrivateKey, definition map[string]interface{}) config.SignedCoinDefinition {
+	definitionBytes, err := json.Marshal(definition)
+	require.NoError(t, err)
+	signature, err := privateKey.Sign(signeddata.Hash(signeddata.DomainCoinDefinition, definitionBytes))
+	require.NoError(t, err)
+	return config.SignedCoinDefinition{
+		Definition: string(definitionBytes),
+		Signature:  hex.EncodeToString(signature.Serialize()),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	publicKey := privateKey.PubKey()
+
+	definition, err := customcoin.Verify(sign(t, privateKey, testDefinition(t)), publicKey)
+	require.NoError(t, err)
+	require.Equal(t, "tcst", definition.Code)
+	require.Equal(t, "tcst-p2wpkh", definition.AccountCode(signing.ScriptTypeP2WPKH))
+	require.Equal(t, "Custom Testnet: bech32", definition.AccountName(signing.ScriptTypeP2WPKH))
+	require.Equal(t, "Custom Testnet", definition.AccountName(signing.ScriptTypeP2WPKHP2SH))
+	require.Equal(t, "m/49'/1'/0'", definition.AccountKeypath(signing.ScriptTypeP2WPKHP2SH))
+
+	params, err := definition.Params()
+	require.NoError(t, err)
+	require.Equal(t, chaincfg.TestNet3Params.GenesisHash, params.GenesisHash)
+	require.Equal(t, params.GenesisHash, params.Checkpoints[0].Hash)
+	require.Equal(t, chaincfg.TestNet3Params.HDPublicKeyID, params.HDPublicKeyID)
+	// Registering twice is fine.
+	params2, err := definition.Params()
+	require.NoError(t, err)
+	require.Equal(t, params, params2)
+
+	// Addresses of the coin can be decoded.
+	address, err := btcutil.NewAddressWitnessPubKeyHash(make([]byte, 20), params)
+	require.NoError(t, err)
+	decoded, err := btcutil.DecodeAddress(address.EncodeAddress(), params)
+	require.NoError(t, err)
+	require.True(t, decoded.IsForNet(params))
+
+	// Tampered definition.
+	signed := sign(t, privateKey, testDefinition(t))
+	signed.Definition = signed.Definition[:len(signed.Definition)-1] + " }"
+	_, err = customcoin.Verify(signed, publicKey)
+	require.Error(t, err)
+
+	// Other key.
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	_, err = customcoin.Verify(sign(t, otherKey, testDefinition(t)), publicKey)
+	require.Error(t, err)
+
+	invalid := []func(map[string]interface{}){
+		func(definition map[string]interface{}) { definition["code"] = "tbtc" },
+		func(definition map[string]interface{}) { definition["code"] = "T-CST" },
+		func(definition map[string]interface{}) { definition["testnet"] = false },
+		func(definition map[string]interface{}) { definition["bech32HRPSegwit"] = "" },
+		func(definition map[string]interface{}) { definition["genesisHeader"] = "00" },
+		func(definition map[string]interface{}) { definition["electrumServers"] = []*rpc.ServerInfo{} },
+		func(definition map[string]interface{}) { definition["scriptTypes"] = []string{"p2tr"} },
+	}
+	for _, modify := range invalid {
+		definition := testDefinition(t)
+		modify(definition)
+		_, err := customcoin.Verify(sign(t, privateKey, definition), publicKey)
+		require.Error(t, err)
+	}
+}
+
+func TestParamsNetCollision(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	definition := testDefinition(t)
+	definition["code"] = "tcstx"
+	// The magic of the Bitcoin testnet.
+	definition["netMagic"] = uint32(chaincfg.TestNet3Params.Net)
+	verified, err := customcoin.Verify(sign(t, privateKey, definition), privateKey.PubKey())
+	require.NoError(t, err)
+	_, err = verified.Params()
+	require.Error(t, err)
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// DenylistedAddressError is returned if the recipient address is on one of the denylists.
+type DenylistedAddressError struct {
+	Address string
+	// Sources are the names of the lists containing the address.
+	Sources []string
+}
+
+func (err *DenylistedAddressError) Error() string {
+	return fmt.Sprintf("the address %s is listed in: %s", err.Address, strings.Join(err.Sources, ", "))
+}
+
+// CheckRecipient validates the recipient address and, if enabled in the config, checks it against
+// the denylists. A *DenylistedAddressError is returned if the address is listed, so that the user
+// can be warned before a tx proposal is created.
+func (account *Account) CheckRecipient(recipientAddress string) error {
+	address, err := account.decodeRecipientAddress(recipientAddress)
+	if err != nil {
+		return err
+	}
+	if account.denylist == nil || !account.config.Config().Backend.AddressDenylist {
+		return nil
+	}
+	sources := account.denylist.Lookup(address.EncodeAddress())
+	if len(sources) == 0 {
+		return nil
+	}
+	account.log.WithField("sources", sources).Warning("Recipient address is denylisted")
+	return errp.WithStack(&DenylistedAddressError{Address: address.EncodeAddress(), Sources: sources})
+}
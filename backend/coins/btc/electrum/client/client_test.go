@@ -19,6 +19,11 @@ import (
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/fixtures"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,3 +52,36 @@ func TestStatus(t *testing.T) {
 		"9783fa8a2f1c89652022e0bb435f302ee8b856961dd979ee083435c65384f314",
 		history.Status())
 }
+
+// TestReplay syncs a script hash against a fixture recorded from an Electrum server, see
+// electrum.NewFixtureRecorder().
+func TestReplay(t *testing.T) {
+	fixture, err := fixtures.LoadFixture("testdata/history.json")
+	require.NoError(t, err)
+	log := logging.Get().WithGroup("client_test")
+	electrumClient := client.NewElectrumClient(
+		jsonrpc.NewRPCClient([]rpc.Backend{fixtures.NewReplayBackend(fixture)}, log), log)
+	scriptHash := blockchain.ScriptHashHex("8b01df4e368ea28f8dc0423bcf7a4923e3a12d307c875e47a0cfbf90b5c39161")
+
+	statuses := make(chan string, 2)
+	electrumClient.ScriptHashSubscribe(nil, scriptHash, func(status string) error {
+		statuses <- status
+		return nil
+	})
+	require.Equal(t, "", <-statuses)
+	require.Equal(t, "a0d6c3a6d6b4e9f6c1f4b0d3ab2f6b8e2cc84ef2cdb5a7a8e7a4bd3b0a2e65c1", <-statuses)
+
+	histories := make(chan blockchain.TxHistory)
+	electrumClient.ScriptHashGetHistory(scriptHash, func(history blockchain.TxHistory) error {
+		histories <- history
+		return nil
+	}, func() {})
+	history := <-histories
+	require.Len(t, history, 2)
+	require.Equal(t, 200004, history[0].Height)
+	require.Equal(t,
+		"acc3758bd2a26f869fcc67d48ff30b96464d476bca82c1cd6656e7d506816412",
+		history[0].TXHash.Hash().String())
+	require.Equal(t, 0, history[1].Height)
+	require.Equal(t, int64(24310), *history[1].Fee)
+}
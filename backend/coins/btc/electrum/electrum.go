@@ -23,8 +23,10 @@ import (
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/fixtures"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
 	"github.com/sirupsen/logrus"
 )
@@ -73,7 +75,7 @@ func newTLSConnection(address string, rootCert string) (*tls.Conn, error) {
 	if ok := caCertPool.AppendCertsFromPEM([]byte(rootCert)); !ok {
 		return nil, errp.New("Failed to append CA cert as trusted cert")
 	}
-	conn, err := tls.Dial("tcp", address, &tls.Config{
+	conn, err := egress.DialTLS("tcp", address, &tls.Config{
 		RootCAs:            caCertPool,
 		InsecureSkipVerify: true, // Not actually skipping, we check the cert in VerifyPeerCertificate
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
@@ -108,7 +110,7 @@ func newTLSConnection(address string, rootCert string) (*tls.Conn, error) {
 			_, err := certs[0].Verify(opts)
 			return err
 		},
-	})
+	}, egress.PurposeElectrum)
 	if err != nil {
 		return nil, errp.WithStack(err)
 	}
@@ -116,7 +118,7 @@ func newTLSConnection(address string, rootCert string) (*tls.Conn, error) {
 }
 
 func newTCPConnection(address string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", address)
+	conn, err := egress.Dial("tcp", address, egress.PurposeElectrum)
 	if err != nil {
 		return nil, errp.WithStack(err)
 	}
@@ -126,6 +128,24 @@ func newTCPConnection(address string) (net.Conn, error) {
 // NewElectrumConnection connects to an Electrum server and returns a ElectrumClient instance to
 // communicate with it.
 func NewElectrumConnection(servers []*rpc.ServerInfo, log *logrus.Entry) blockchain.Interface {
+	return NewElectrumClient(servers, log)
+}
+
+// NewElectrumClient is like NewElectrumConnection, but returns the client with all its RPC calls,
+// including the ones which are not part of blockchain.Interface.
+func NewElectrumClient(servers []*rpc.ServerInfo, log *logrus.Entry) *client.ElectrumClient {
+	return newElectrumClient(servers, nil, log)
+}
+
+// NewRecordedElectrumConnection is like NewElectrumConnection, but the traffic is recorded by the
+// recorder while it is started, see NewFixtureRecorder().
+func NewRecordedElectrumConnection(
+	servers []*rpc.ServerInfo, recorder *fixtures.Recorder, log *logrus.Entry) blockchain.Interface {
+	return newElectrumClient(servers, recorder, log)
+}
+
+func newElectrumClient(
+	servers []*rpc.ServerInfo, recorder *fixtures.Recorder, log *logrus.Entry) *client.ElectrumClient {
 	var serverList string
 	for _, serverInfo := range servers {
 		if serverList != "" {
@@ -138,7 +158,11 @@ func NewElectrumConnection(servers []*rpc.ServerInfo, log *logrus.Entry) blockch
 
 	backends := []rpc.Backend{}
 	for _, serverInfo := range servers {
-		backends = append(backends, &Electrum{log, serverInfo})
+		var backend rpc.Backend = &Electrum{log, serverInfo}
+		if recorder != nil {
+			backend = recorder.Backend(backend)
+		}
+		backends = append(backends, backend)
 	}
 	jsonrpcClient := jsonrpc.NewRPCClient(backends, log)
 	return client.NewElectrumClient(jsonrpcClient, log)
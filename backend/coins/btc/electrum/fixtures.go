@@ -0,0 +1,63 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package electrum
+
+import (
+	"encoding/json"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/fixtures"
+)
+
+// redactedServerMethods are the calls which only describe the server. They are not recorded.
+var redactedServerMethods = map[string]bool{
+	"server.banner":           true,
+	"server.donation_address": true,
+	"server.peers.subscribe":  true,
+}
+
+// NewFixtureRecorder creates a recorder of the Electrum traffic, see NewRecordedElectrumConnection().
+// The fixture contains the script hashes and transactions of the wallet, but nothing which
+// identifies the servers: their software, hosts and peers are removed.
+func NewFixtureRecorder(filename string) *fixtures.Recorder {
+	return fixtures.NewRecorder(filename, sanitizeFixtureEntry)
+}
+
+func sanitizeFixtureEntry(entry *fixtures.Entry) bool {
+	if redactedServerMethods[entry.Method] {
+		return false
+	}
+	if len(entry.Result) == 0 {
+		return true
+	}
+	switch entry.Method {
+	case "server.version":
+		// The result is the software of the server and the negotiated protocol version.
+		var version []string
+		if err := json.Unmarshal(entry.Result, &version); err != nil || len(version) != 2 {
+			return false
+		}
+		entry.Result, _ = json.Marshal([]string{"redacted", version[1]})
+	case "server.features":
+		// Only the genesis hash is used, see client.ServerFeatures().
+		var features struct {
+			GenesisHash string `json:"genesis_hash"`
+		}
+		if err := json.Unmarshal(entry.Result, &features); err != nil {
+			return false
+		}
+		entry.Result, _ = json.Marshal(&features)
+	}
+	return true
+}
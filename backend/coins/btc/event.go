@@ -34,4 +34,25 @@ const (
 
 	// EventFeeTargetsChanged is fired when the fee targets change.
 	EventFeeTargetsChanged Event = "feeTargetsChanged"
+
+	// EventFeeBumpSuggested is fired when an unconfirmed outgoing transaction should be replaced by
+	// one paying a higher fee, according to the fee bump policy. Check the suggestions using
+	// FeeBumpSuggestions().
+	EventFeeBumpSuggested Event = "feeBumpSuggested"
+
+	// EventVaultUnvaultDetected is fired when a vaulted output of a vault account was spent without
+	// the user initiating the unvaulting. Check the deposits using VaultDeposits().
+	EventVaultUnvaultDetected Event = "vaultUnvaultDetected"
+
+	// EventWatchedTxAlert is fired when a watched tx which was signed but not broadcast can now be
+	// broadcast, or was invalidated by a conflicting tx. Check the txs using WatchedTxs().
+	EventWatchedTxAlert Event = "watchedTxAlert"
+
+	// EventInvoiceStateChanged is fired when an invoice was paid in full, underpaid, overpaid or
+	// expired. Check the invoices using Invoices().
+	EventInvoiceStateChanged Event = "invoiceStateChanged"
+
+	// EventPaymentCodeNotification is fired when a new sender announced itself to the payment code
+	// of the account. Check the senders using PaymentCode().
+	EventPaymentCodeNotification Event = "paymentCodeNotification"
 )
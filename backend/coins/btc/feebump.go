@@ -0,0 +1,229 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// FeeBumpSuggestion describes an unconfirmed outgoing tx which should be replaced by one paying a
+// higher fee.
+type FeeBumpSuggestion struct {
+	TxID string
+	// FeeRatePerKb is the fee rate paid by the tx.
+	FeeRatePerKb btcutil.Amount
+	// RequiredFeeRatePerKb is the currently estimated fee rate to confirm within the target.
+	RequiredFeeRatePerKb btcutil.Amount
+	// FeeTargetCode is the fee target to use for the replacement.
+	FeeTargetCode FeeTargetCode
+	// PendingBlocks is the number of blocks the tx has been unconfirmed for.
+	PendingBlocks int
+}
+
+// feeTargetForBlocks returns the fee target with the lowest priority that still confirms within
+// the given number of blocks. If there is none, the highest priority fee target is returned. nil is
+// returned if no fee rates have been estimated yet.
+func (account *Account) feeTargetForBlocks(blocks int) *FeeTarget {
+	defer account.RLock()()
+	var result *FeeTarget
+	for _, feeTarget := range account.feeTargets {
+		if feeTarget.FeeRatePerKb == nil {
+			continue
+		}
+		result = feeTarget
+		if feeTarget.Blocks <= blocks {
+			break
+		}
+	}
+	return result
+}
+
+// FeeBumpSuggestions returns the unconfirmed outgoing transactions which, according to the fee
+// bump policy, should be replaced by one paying a higher fee. Only transactions signaling
+// replaceability are considered.
+func (account *Account) FeeBumpSuggestions() []*FeeBumpSuggestion {
+	suggestions := []*FeeBumpSuggestion{}
+	policy := account.config.Config().Backend.FeeBumpPolicy
+	if !policy.Enabled {
+		return suggestions
+	}
+	feeTarget := account.feeTargetForBlocks(policy.TargetBlocks)
+	tipHeight := account.headers.TipHeight()
+	txs := account.Transactions()
+
+	defer account.unconfirmedSinceLock.Lock()()
+	unconfirmed := map[chainhash.Hash]struct{}{}
+	for _, txInfo := range txs {
+		if txInfo.Height > 0 || txInfo.Type == transactions.TxTypeReceive ||
+			!maketx.SignalsRBF(txInfo.Tx) {
+			continue
+		}
+		txHash := txInfo.Tx.TxHash()
+		unconfirmed[txHash] = struct{}{}
+		since, ok := account.unconfirmedSince[txHash]
+		if !ok {
+			since = tipHeight
+			account.unconfirmedSince[txHash] = since
+		}
+		pendingBlocks := tipHeight - since
+		if feeTarget == nil || pendingBlocks < policy.TargetBlocks {
+			continue
+		}
+		feeRatePerKb := *txInfo.FeeRatePerKb()
+		requiredFeeRatePerKb := *feeTarget.FeeRatePerKb
+		if int64(requiredFeeRatePerKb)*100 <= int64(feeRatePerKb)*int64(100+policy.ThresholdPercent) {
+			continue
+		}
+		suggestions = append(suggestions, &FeeBumpSuggestion{
+			TxID:                 txHash.String(),
+			FeeRatePerKb:         feeRatePerKb,
+			RequiredFeeRatePerKb: requiredFeeRatePerKb,
+			FeeTargetCode:        feeTarget.Code,
+			PendingBlocks:        pendingBlocks,
+		})
+	}
+	// Forget about transactions which have been confirmed or replaced in the meantime.
+	for txHash := range account.unconfirmedSince {
+		if _, ok := unconfirmed[txHash]; !ok {
+			delete(account.unconfirmedSince, txHash)
+		}
+	}
+	return suggestions
+}
+
+// checkFeeBumps fires EventFeeBumpSuggested if there are transactions which should be bumped.
+func (account *Account) checkFeeBumps() {
+	if suggestions := account.FeeBumpSuggestions(); len(suggestions) != 0 {
+		account.log.WithField("count", len(suggestions)).Info("Suggesting fee bumps")
+		account.onEvent(EventFeeBumpSuggested)
+	}
+}
+
+// transactionByID returns the tx of the account with the given ID, or nil if there is none.
+func (account *Account) transactionByID(txID string) *transactions.TxInfo {
+	for _, txInfo := range account.Transactions() {
+		if txInfo.Tx.TxHash().String() == txID {
+			return txInfo
+		}
+	}
+	return nil
+}
+
+// feeBumpRecipientOutputs returns the outputs of the replaced tx which must be kept unchanged in
+// the replacement, i.e. all outputs except for the change.
+func feeBumpRecipientOutputs(tx *wire.MsgTx, changeAddress *addresses.AccountAddress) []*wire.TxOut {
+	recipientOutputs := []*wire.TxOut{}
+	foundChange := false
+	for _, txOut := range tx.TxOut {
+		if !foundChange && changeAddress != nil && bytes.Equal(txOut.PkScript, changeAddress.PubkeyScript()) {
+			foundChange = true
+			continue
+		}
+		recipientOutputs = append(recipientOutputs, txOut)
+	}
+	return recipientOutputs
+}
+
+// newFeeBumpTx creates a replacement for the unconfirmed tx with the given ID, paying the fee rate
+// of the given fee target. It also returns the outputs spent by the tx, needed to sign it.
+func (account *Account) newFeeBumpTx(txID string, feeTargetCode FeeTargetCode) (
+	map[wire.OutPoint]*transactions.SpendableOutput, *maketx.TxProposal, error) {
+	txInfo := account.transactionByID(txID)
+	if txInfo == nil {
+		return nil, nil, errp.WithStack(TxValidationError("unknown transaction"))
+	}
+	if txInfo.Height > 0 {
+		return nil, nil, errp.WithStack(TxValidationError("transaction already confirmed"))
+	}
+	if txInfo.Type == transactions.TxTypeReceive || txInfo.Fee == nil || !maketx.SignalsRBF(txInfo.Tx) {
+		return nil, nil, errp.WithStack(maketx.ErrFeeBumpNotPossible)
+	}
+	feeTarget := account.feeTarget(feeTargetCode)
+	if feeTarget == nil || feeTarget.FeeRatePerKb == nil {
+		return nil, nil, errp.New("Fee could not be estimated")
+	}
+	previousOutputs, err := account.transactions.PreviousOutputs(txInfo.Tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var changeAddress *addresses.AccountAddress
+	for _, txOut := range txInfo.Tx.TxOut {
+		scriptHashHex := (&transactions.SpendableOutput{TxOut: txOut}).ScriptHashHex()
+		if address := account.changeAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+			changeAddress = address
+			break
+		}
+	}
+	txProposal, err := maketx.NewTxBumpFee(
+		account.coin,
+		account.signingConfiguration,
+		txInfo.Tx,
+		txInfo.VSize,
+		*txInfo.Fee,
+		*feeTarget.FeeRatePerKb,
+		changeAddress,
+		account.log,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	txProposal.AccountName = account.DisplayName()
+	return previousOutputs, txProposal, nil
+}
+
+// FeeBumpTxProposal creates a replacement for the unconfirmed tx with the given ID, and returns the
+// output amount, fee and total for display in the UI. The fee is converted to the given fiat
+// currency, if not empty.
+func (account *Account) FeeBumpTxProposal(txID string, feeTargetCode FeeTargetCode, fiatUnit string) (
+	btcutil.Amount, *FeeDetails, btcutil.Amount, error) {
+	_, txProposal, err := account.newFeeBumpTx(txID, feeTargetCode)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return txProposal.Amount, account.feeDetails(txProposal, fiatUnit), txProposal.Total(), nil
+}
+
+// SendFeeBump creates, signs and sends a replacement for the unconfirmed tx with the given ID,
+// paying a higher fee.
+func (account *Account) SendFeeBump(txID string, feeTargetCode FeeTargetCode) error {
+	account.log.WithField("txid", txID).Info("Bumping fee")
+	previousOutputs, txProposal, err := account.newFeeBumpTx(txID, feeTargetCode)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to create fee bump transaction")
+	}
+	replacedTxInfo := account.transactionByID(txID)
+	if replacedTxInfo == nil {
+		return errp.WithStack(TxValidationError("unknown transaction"))
+	}
+	recipientOutputs := feeBumpRecipientOutputs(replacedTxInfo.Tx, txProposal.ChangeAddress)
+	if err := account.checkTxPolicy(txProposal, recipientOutputs, previousOutputs, false); err != nil {
+		return err
+	}
+	if err := SignTransaction(
+		account.keystores, txProposal, previousOutputs, account.getAddress, account.log); err != nil {
+		return errp.WithMessage(err, "Failed to sign transaction")
+	}
+	account.log.Info("Signed fee bump transaction is broadcasted")
+	return account.blockchain.TransactionBroadcast(txProposal.Transaction)
+}
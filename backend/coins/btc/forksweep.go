@@ -0,0 +1,162 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/forksweep"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// ForkSweepRequest specifies a sweep of the coins of a forked chain, see ForkSweepProposal().
+type ForkSweepRequest struct {
+	// Fork is the code of the forked chain, see forksweep.Forks().
+	Fork string
+	// Servers are the Electrum servers of the forked chain, chosen by the user.
+	Servers []*rpc.ServerInfo
+	// RecipientAddress is an address on the forked chain.
+	RecipientAddress string
+	// FeeRatePerKb is the fee rate in the smallest unit of the forked chain. The default rate of
+	// the fork is used if zero.
+	FeeRatePerKb btcutil.Amount
+}
+
+// newForkSweep looks up the outputs on the forked chain paying to the used addresses of the
+// account, and creates a transaction sweeping them. The returned client must be closed by the
+// caller.
+func (account *Account) newForkSweep(request *ForkSweepRequest) (
+	*forksweep.Sweep, *client.ElectrumClient, error) {
+	// The forked chains split off Bitcoin mainnet. Only singlesig accounts are supported, as
+	// every keystore would have to sign every input separately.
+	if account.coin.Net() != &chaincfg.MainNetParams || account.Keystores().Count() != 1 {
+		return nil, nil, errp.New("sweeping forked chains is only supported for Bitcoin singlesig accounts")
+	}
+	fork, err := forksweep.ForkByCode(request.Fork)
+	if err != nil {
+		return nil, nil, errp.WithStack(TxValidationError(err.Error()))
+	}
+	if len(request.Servers) == 0 {
+		return nil, nil, errp.WithStack(TxValidationError("no server of the forked chain given"))
+	}
+	recipientPkScript, err := fork.PkScript(request.RecipientAddress)
+	if err != nil {
+		return nil, nil, errp.WithStack(TxValidationError("invalid address"))
+	}
+	feeRatePerKb := request.FeeRatePerKb
+	if feeRatePerKb == 0 {
+		feeRatePerKb = fork.DefaultFeeRatePerKb
+	}
+	if feeRatePerKb < 0 {
+		return nil, nil, errp.WithStack(TxValidationError("invalid fee rate"))
+	}
+
+	account.synchronizer.WaitSynchronized()
+	usedAddresses := func() []*forksweep.Input {
+		defer account.RLock()()
+		inputs := []*forksweep.Input{}
+		for _, change := range []bool{false, true} {
+			for _, address := range account.addresses(change).Used() {
+				inputs = append(inputs, &forksweep.Input{Address: address})
+			}
+		}
+		return inputs
+	}()
+
+	forkClient := electrum.NewElectrumClient(request.Servers, account.log.WithField("fork", fork.Code))
+	inputs := []*forksweep.Input{}
+	for _, usedAddress := range usedAddresses {
+		address := usedAddress.Address
+		if !fork.Supports(address.Configuration) {
+			continue
+		}
+		utxos, err := forkClient.ScriptHashListUnspent(string(address.PubkeyScriptHashHex()))
+		if err != nil {
+			forkClient.Close()
+			return nil, nil, errp.WithMessage(err, "Failed to list the outputs on the forked chain")
+		}
+		for _, utxo := range utxos {
+			txHash, err := chainhash.NewHashFromStr(utxo.TXHash)
+			if err != nil {
+				forkClient.Close()
+				return nil, nil, errp.WithStack(err)
+			}
+			// The server can not make us sign for wrong values, as the value is committed to in the
+			// signature hash. The transaction would be invalid.
+			inputs = append(inputs, &forksweep.Input{
+				OutPoint: *wire.NewOutPoint(txHash, uint32(utxo.TXPos)),
+				Value:    btcutil.Amount(utxo.Value),
+				Address:  address,
+			})
+		}
+	}
+	sweep, err := forksweep.NewSweep(fork, inputs, recipientPkScript, feeRatePerKb)
+	if err != nil {
+		forkClient.Close()
+		return nil, nil, errp.WithStack(TxValidationError(err.Error()))
+	}
+	return sweep, forkClient, nil
+}
+
+// ForkSweepProposal returns the sweep of the coins of a forked chain specified by the request,
+// without signing it.
+func (account *Account) ForkSweepProposal(request *ForkSweepRequest) (*forksweep.Sweep, error) {
+	sweep, forkClient, err := account.newForkSweep(request)
+	if err != nil {
+		return nil, err
+	}
+	forkClient.Close()
+	return sweep, nil
+}
+
+// SendForkSweep signs the sweep of the coins of a forked chain specified by the request, and
+// broadcasts it to the servers of the forked chain. As a guard against the servers changing the
+// outputs after the proposal, the sweep is aborted if the swept amount is not the expected one,
+// i.e. the amount of the proposal the user agreed to. Returns the transaction ID.
+func (account *Account) SendForkSweep(
+	request *ForkSweepRequest, expectedAmount btcutil.Amount) (string, error) {
+	sweep, forkClient, err := account.newForkSweep(request)
+	if err != nil {
+		return "", err
+	}
+	defer forkClient.Close()
+	if sweep.Amount != expectedAmount {
+		return "", errp.WithStack(TxValidationError("the coins to sweep changed, please retry"))
+	}
+	account.log.WithField("fork", sweep.Fork.Code).
+		WithField("inputs", len(sweep.Inputs)).Info("Signing fork sweep")
+	signHash := func(keypath signing.AbsoluteKeypath, hash []byte) (*btcec.Signature, error) {
+		signatures, err := account.Keystores().SignHash(keypath, hash)
+		if err != nil {
+			return nil, err
+		}
+		return signatures[0], nil
+	}
+	if err := sweep.Sign(signHash); err != nil {
+		return "", err
+	}
+	if err := forkClient.TransactionBroadcast(sweep.Transaction); err != nil {
+		return "", err
+	}
+	return sweep.Transaction.TxHash().String(), nil
+}
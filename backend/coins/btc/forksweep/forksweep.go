@@ -0,0 +1,266 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forksweep builds transactions which sweep the coins of chains which forked off Bitcoin,
+// like Bitcoin Cash, from the addresses of a Bitcoin account to an address on the forked chain.
+//
+// The forked chains kept the UTXO set of Bitcoin at the time of the fork, so the coins are
+// controlled by the same keys as the Bitcoin balance at the time. The sweep signs with the
+// replay protected signature hash of the forked chain (BIP143 with SIGHASH_FORKID), so that the
+// signatures are not valid on Bitcoin.
+package forksweep
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// sigHashForkID is the flag of the signature hash type marking replay protected signatures.
+const sigHashForkID txscript.SigHashType = 0x40
+
+// Fork describes a chain which forked off Bitcoin.
+type Fork struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+	// ForkID is committed to in the signature hash type for replay protection.
+	ForkID uint32 `json:"-"`
+	// Segwit is false if the forked chain does not support segwit. Segwit outputs can not be swept
+	// on these chains.
+	Segwit bool `json:"segwit"`
+	// PubKeyHashAddrID and ScriptHashAddrID are the version bytes of the base58 addresses on the
+	// forked chain.
+	PubKeyHashAddrID byte `json:"-"`
+	ScriptHashAddrID byte `json:"-"`
+	// DefaultFeeRatePerKb is used if no fee rate is given.
+	DefaultFeeRatePerKb btcutil.Amount `json:"-"`
+}
+
+var forks = []*Fork{
+	{
+		Code:                "bch",
+		Name:                "Bitcoin Cash",
+		Unit:                "BCH",
+		ForkID:              0,
+		Segwit:              false,
+		PubKeyHashAddrID:    0x00,
+		ScriptHashAddrID:    0x05,
+		DefaultFeeRatePerKb: 1000,
+	},
+	{
+		Code:                "btg",
+		Name:                "Bitcoin Gold",
+		Unit:                "BTG",
+		ForkID:              79,
+		Segwit:              true,
+		PubKeyHashAddrID:    38,
+		ScriptHashAddrID:    23,
+		DefaultFeeRatePerKb: 1000,
+	},
+}
+
+// Forks returns the supported forked chains.
+func Forks() []*Fork {
+	return forks
+}
+
+// ForkByCode returns the forked chain with the given code.
+func ForkByCode(code string) (*Fork, error) {
+	for _, fork := range forks {
+		if fork.Code == code {
+			return fork, nil
+		}
+	}
+	return nil, errp.Newf("unknown fork %s", code)
+}
+
+// SigHashType returns the signature hash type of the forked chain.
+func (fork *Fork) SigHashType() txscript.SigHashType {
+	return txscript.SigHashAll | sigHashForkID | txscript.SigHashType(fork.ForkID<<8)
+}
+
+// Supports returns whether outputs of the given address can be swept on the forked chain.
+func (fork *Fork) Supports(configuration *signing.Configuration) bool {
+	if configuration.Multisig() {
+		return false
+	}
+	return configuration.ScriptType() == signing.ScriptTypeP2PKH || fork.Segwit
+}
+
+// PkScript returns the pubkey script of the given base58 address of the forked chain. Other
+// address formats, like the CashAddr format of Bitcoin Cash, are not supported.
+func (fork *Fork) PkScript(address string) ([]byte, error) {
+	hash, version, err := base58.CheckDecode(address)
+	if err != nil || len(hash) != 20 {
+		return nil, errp.Newf("invalid %s address, only base58 addresses are supported", fork.Name)
+	}
+	switch version {
+	case fork.PubKeyHashAddrID:
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+			AddData(hash).AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	case fork.ScriptHashAddrID:
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).AddData(hash).
+			AddOp(txscript.OP_EQUAL).Script()
+	default:
+		return nil, errp.Newf("the address is not a %s address", fork.Name)
+	}
+}
+
+// Input is an unspent output on the forked chain paying to an address of the account.
+type Input struct {
+	OutPoint wire.OutPoint
+	Value    btcutil.Amount
+	Address  *addresses.AccountAddress
+}
+
+// Sweep is a transaction on the forked chain spending all inputs to one recipient.
+type Sweep struct {
+	Fork        *Fork
+	Transaction *wire.MsgTx
+	// Inputs are the spent outputs, in the order of the transaction inputs.
+	Inputs []*Input
+	Amount btcutil.Amount
+	Fee    btcutil.Amount
+}
+
+// NewSweep creates an unsigned transaction spending the given inputs to the given pubkey script at
+// the given fee rate. Inputs which can not be swept on the forked chain are skipped.
+func NewSweep(
+	fork *Fork,
+	inputs []*Input,
+	recipientPkScript []byte,
+	feeRatePerKb btcutil.Amount,
+) (*Sweep, error) {
+	sweep := &Sweep{
+		Fork:        fork,
+		Transaction: wire.NewMsgTx(wire.TxVersion),
+		Inputs:      []*Input{},
+	}
+	var total btcutil.Amount
+	for _, input := range inputs {
+		if !fork.Supports(input.Address.Configuration) {
+			continue
+		}
+		sweep.Transaction.AddTxIn(wire.NewTxIn(&input.OutPoint, nil, nil))
+		sweep.Inputs = append(sweep.Inputs, input)
+		total += input.Value
+	}
+	if len(sweep.Inputs) == 0 {
+		return nil, errp.Newf("no %s coins to sweep", fork.Name)
+	}
+	sweep.Transaction.AddTxOut(wire.NewTxOut(0, recipientPkScript))
+	sweep.Fee = feeRatePerKb * btcutil.Amount(sweep.estimateSize()) / 1000
+	sweep.Amount = total - sweep.Fee
+	if sweep.Amount <= 0 {
+		return nil, errp.Newf("the %s coins do not cover the fee", fork.Name)
+	}
+	sweep.Transaction.TxOut[0].Value = int64(sweep.Amount)
+	return sweep, nil
+}
+
+// estimateSize returns the virtual size of the transaction once signed.
+func (sweep *Sweep) estimateSize() int {
+	const (
+		signatureSize = 73 // including the signature hash type
+		publicKeySize = 33
+	)
+	dummySignature := make([]byte, signatureSize)
+	dummyPublicKey := make([]byte, publicKeySize)
+	transaction := sweep.Transaction.Copy()
+	for index, input := range sweep.Inputs {
+		signatureScript, witness := scripts(input.Address, dummySignature, dummyPublicKey)
+		transaction.TxIn[index].SignatureScript = signatureScript
+		transaction.TxIn[index].Witness = witness
+	}
+	weight := transaction.SerializeSizeStripped()*3 + transaction.SerializeSize()
+	return (weight + 3) / 4
+}
+
+// scripts returns the signature script and witness spending from the given address.
+func scripts(
+	address *addresses.AccountAddress, signature []byte, publicKey []byte,
+) ([]byte, wire.TxWitness) {
+	switch address.Configuration.ScriptType() {
+	case signing.ScriptTypeP2PKH:
+		signatureScript, err := txscript.NewScriptBuilder().AddData(signature).AddData(publicKey).Script()
+		if err != nil {
+			panic(err)
+		}
+		return signatureScript, nil
+	case signing.ScriptTypeP2WPKHP2SH:
+		_, redeemScript := address.ScriptForHashToSign()
+		signatureScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+		if err != nil {
+			panic(err)
+		}
+		return signatureScript, wire.TxWitness{signature, publicKey}
+	case signing.ScriptTypeP2WPKH:
+		return nil, wire.TxWitness{signature, publicKey}
+	default:
+		panic("unsupported script type")
+	}
+}
+
+// signatureHashes returns the replay protected signature hashes of all inputs.
+func (sweep *Sweep) signatureHashes() ([][]byte, error) {
+	sigHashes := txscript.NewTxSigHashes(sweep.Transaction)
+	signatureHashes := make([][]byte, len(sweep.Inputs))
+	for index, input := range sweep.Inputs {
+		// Like BIP143, the signature hash of p2pkh inputs commits to the pubkey script, and the
+		// one of p2wpkh inputs to the corresponding p2pkh script.
+		_, subScript := input.Address.ScriptForHashToSign()
+		signatureHash, err := txscript.CalcWitnessSigHash(subScript, sigHashes,
+			sweep.Fork.SigHashType(), sweep.Transaction, index, int64(input.Value))
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		signatureHashes[index] = signatureHash
+	}
+	return signatureHashes, nil
+}
+
+// Sign signs all inputs with signHash, which signs a hash with the key at the given keypath.
+func (sweep *Sweep) Sign(
+	signHash func(signing.AbsoluteKeypath, []byte) (*btcec.Signature, error),
+) error {
+	signatureHashes, err := sweep.signatureHashes()
+	if err != nil {
+		return err
+	}
+	for index, input := range sweep.Inputs {
+		configuration := input.Address.Configuration
+		signature, err := signHash(configuration.AbsoluteKeypath(), signatureHashes[index])
+		if err != nil {
+			return err
+		}
+		publicKey := configuration.PublicKeys()[0]
+		// The signatures can not be checked with a script engine, which does not know the
+		// signature hash of the forked chain.
+		if !signature.Verify(signatureHashes[index], publicKey) {
+			return errp.New("The keystore returned an invalid signature")
+		}
+		signatureBytes := append(signature.Serialize(), byte(sweep.Fork.SigHashType()))
+		txIn := sweep.Transaction.TxIn[index]
+		txIn.SignatureScript, txIn.Witness = scripts(
+			input.Address, signatureBytes, publicKey.SerializeCompressed())
+	}
+	return nil
+}
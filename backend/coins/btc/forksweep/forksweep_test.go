@@ -0,0 +1,172 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forksweep_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/forksweep"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+func newAddress(t *testing.T, scriptType signing.ScriptType) (*addresses.AccountAddress, *btcec.PrivateKey) {
+	seed := chainhash.HashB([]byte(scriptType))
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	xpub, err := master.Neuter()
+	require.NoError(t, err)
+	privateKey, err := master.ECPrivKey()
+	require.NoError(t, err)
+	configuration := signing.NewSinglesigConfiguration(
+		scriptType, signing.NewEmptyAbsoluteKeypath(), xpub)
+	return addresses.NewAccountAddress(
+		configuration, &chaincfg.MainNetParams, logging.Get().WithGroup("forksweep_test")), privateKey
+}
+
+func newInput(address *addresses.AccountAddress, index uint32, value btcutil.Amount) *forksweep.Input {
+	return &forksweep.Input{
+		OutPoint: *wire.NewOutPoint(&chainhash.Hash{1}, index),
+		Value:    value,
+		Address:  address,
+	}
+}
+
+func TestSigHashType(t *testing.T) {
+	bch, err := forksweep.ForkByCode("bch")
+	require.NoError(t, err)
+	require.Equal(t, txscript.SigHashType(0x41), bch.SigHashType())
+	btg, err := forksweep.ForkByCode("btg")
+	require.NoError(t, err)
+	require.Equal(t, txscript.SigHashType(0x4f41), btg.SigHashType())
+	_, err = forksweep.ForkByCode("btc")
+	require.Error(t, err)
+}
+
+func TestPkScript(t *testing.T) {
+	bch, err := forksweep.ForkByCode("bch")
+	require.NoError(t, err)
+	btg, err := forksweep.ForkByCode("btg")
+	require.NoError(t, err)
+
+	hash := btcutil.Hash160([]byte("key"))
+	pkScript, err := bch.PkScript(base58.CheckEncode(hash, 0x00))
+	require.NoError(t, err)
+	require.Equal(t, txscript.PubKeyHashTy, txscript.GetScriptClass(pkScript))
+	pkScript, err = btg.PkScript(base58.CheckEncode(hash, 23))
+	require.NoError(t, err)
+	require.Equal(t, txscript.ScriptHashTy, txscript.GetScriptClass(pkScript))
+
+	// Addresses of other chains.
+	_, err = btg.PkScript(base58.CheckEncode(hash, 0x00))
+	require.Error(t, err)
+	_, err = bch.PkScript("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4")
+	require.Error(t, err)
+}
+
+func TestNewSweep(t *testing.T) {
+	bch, err := forksweep.ForkByCode("bch")
+	require.NoError(t, err)
+	p2pkhAddress, _ := newAddress(t, signing.ScriptTypeP2PKH)
+	p2wpkhAddress, _ := newAddress(t, signing.ScriptTypeP2WPKH)
+	recipient := p2pkhAddress.PubkeyScript()
+
+	// Bitcoin Cash does not support segwit, so the p2wpkh input is skipped.
+	sweep, err := forksweep.NewSweep(bch, []*forksweep.Input{
+		newInput(p2pkhAddress, 0, 100000),
+		newInput(p2wpkhAddress, 1, 200000),
+		newInput(p2pkhAddress, 2, 50000),
+	}, recipient, 1000)
+	require.NoError(t, err)
+	require.Len(t, sweep.Inputs, 2)
+	require.Len(t, sweep.Transaction.TxIn, 2)
+	require.Len(t, sweep.Transaction.TxOut, 1)
+	// 2 p2pkh inputs and 1 p2pkh output.
+	require.Equal(t, btcutil.Amount(342), sweep.Fee)
+	require.Equal(t, btcutil.Amount(150000)-sweep.Fee, sweep.Amount)
+	require.Equal(t, int64(sweep.Amount), sweep.Transaction.TxOut[0].Value)
+
+	_, err = forksweep.NewSweep(bch, []*forksweep.Input{newInput(p2wpkhAddress, 0, 100000)}, recipient, 1000)
+	require.Error(t, err)
+	_, err = forksweep.NewSweep(bch, []*forksweep.Input{newInput(p2pkhAddress, 0, 100)}, recipient, 1000)
+	require.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	for _, test := range []struct {
+		fork       string
+		scriptType signing.ScriptType
+	}{
+		{"bch", signing.ScriptTypeP2PKH},
+		{"btg", signing.ScriptTypeP2PKH},
+		{"btg", signing.ScriptTypeP2WPKHP2SH},
+		{"btg", signing.ScriptTypeP2WPKH},
+	} {
+		fork, err := forksweep.ForkByCode(test.fork)
+		require.NoError(t, err)
+		address, privateKey := newAddress(t, test.scriptType)
+		input := newInput(address, 0, 100000)
+		sweep, err := forksweep.NewSweep(
+			fork, []*forksweep.Input{input}, address.PubkeyScript(), 1000)
+		require.NoError(t, err)
+		require.NoError(t, sweep.Sign(
+			func(keypath signing.AbsoluteKeypath, hash []byte) (*btcec.Signature, error) {
+				return privateKey.Sign(hash)
+			}))
+		txIn := sweep.Transaction.TxIn[0]
+		var signature []byte
+		if test.scriptType == signing.ScriptTypeP2PKH {
+			pushes, err := txscript.PushedData(txIn.SignatureScript)
+			require.NoError(t, err)
+			require.Len(t, pushes, 2)
+			signature = pushes[0]
+		} else {
+			require.Len(t, txIn.Witness, 2)
+			signature = txIn.Witness[0]
+		}
+		require.Equal(t, byte(fork.SigHashType()), signature[len(signature)-1])
+
+		// Replay protection: the transaction is not valid on Bitcoin.
+		engine, err := txscript.NewEngine(address.PubkeyScript(), sweep.Transaction, 0,
+			txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(sweep.Transaction),
+			int64(input.Value))
+		require.NoError(t, err)
+		require.Error(t, engine.Execute())
+	}
+
+	// Invalid signatures are rejected.
+	bch, err := forksweep.ForkByCode("bch")
+	require.NoError(t, err)
+	address, _ := newAddress(t, signing.ScriptTypeP2PKH)
+	_, otherKey := newAddress(t, signing.ScriptTypeP2WPKH)
+	sweep, err := forksweep.NewSweep(
+		bch, []*forksweep.Input{newInput(address, 0, 100000)}, address.PubkeyScript(), 1000)
+	require.NoError(t, err)
+	require.Error(t, sweep.Sign(
+		func(keypath signing.AbsoluteKeypath, hash []byte) (*btcec.Signature, error) {
+			return otherKey.Sign(hash)
+		}))
+}
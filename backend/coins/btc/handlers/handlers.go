@@ -15,19 +15,27 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/bip21"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/forksweep"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/invoices"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/util"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/qr"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
 
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
@@ -52,13 +60,43 @@ func NewHandlers(
 	handleFunc("/info", handlers.ensureAccountInitialized(handlers.getAccountInfo)).Methods("GET")
 	handleFunc("/utxos", handlers.ensureAccountInitialized(handlers.getUTXOs)).Methods("GET")
 	handleFunc("/balance", handlers.ensureAccountInitialized(handlers.getAccountBalance)).Methods("GET")
+	handleFunc("/balance-explanation", handlers.ensureAccountInitialized(handlers.getBalanceExplanation)).Methods("GET")
+	handleFunc("/balance-snapshots", handlers.ensureAccountInitialized(handlers.getBalanceSnapshots)).Methods("GET")
 	handleFunc("/sendtx", handlers.ensureAccountInitialized(handlers.postAccountSendTx)).Methods("POST")
 	handleFunc("/fee-targets", handlers.ensureAccountInitialized(handlers.getAccountFeeTargets)).Methods("GET")
+	handleFunc("/nickname", handlers.ensureAccountInitialized(handlers.postNickname)).Methods("POST")
 	handleFunc("/tx-proposal", handlers.ensureAccountInitialized(handlers.getAccountTxProposal)).Methods("POST")
+	handleFunc("/offline-tx", handlers.ensureAccountInitialized(handlers.postOfflineTx)).Methods("POST")
+	handleFunc("/broadcast-tx", handlers.ensureAccountInitialized(handlers.postBroadcastTx)).Methods("POST")
+	handleFunc("/offline-tx/cancel", handlers.ensureAccountInitialized(handlers.postCancelOfflineTx)).Methods("POST")
+	handleFunc("/tx-note", handlers.ensureAccountInitialized(handlers.postTxNote)).Methods("POST")
+	handleFunc("/address-note", handlers.ensureAccountInitialized(handlers.postAddressNote)).Methods("POST")
 	handleFunc("/headers/status", handlers.ensureAccountInitialized(handlers.getHeadersStatus)).Methods("GET")
 	handleFunc("/receive-addresses", handlers.ensureAccountInitialized(handlers.getReceiveAddresses)).Methods("GET")
+	handleFunc("/payment-request", handlers.ensureAccountInitialized(handlers.getPaymentRequest)).Methods("GET")
 	handleFunc("/verify-address", handlers.ensureAccountInitialized(handlers.postVerifyAddress)).Methods("POST")
+	handleFunc("/verify-addresses", handlers.ensureAccountInitialized(handlers.postVerifyAddresses)).Methods("POST")
 	handleFunc("/convert-to-legacy-address", handlers.ensureAccountInitialized(handlers.postConvertToLegacyAddress)).Methods("POST")
+	handleFunc("/tx-ancestry", handlers.ensureAccountInitialized(handlers.getTxAncestry)).Methods("GET")
+	handleFunc("/fee-bump-suggestions", handlers.ensureAccountInitialized(handlers.getFeeBumpSuggestions)).Methods("GET")
+	handleFunc("/fee-bump-proposal", handlers.ensureAccountInitialized(handlers.postFeeBumpProposal)).Methods("POST")
+	handleFunc("/fee-bump", handlers.ensureAccountInitialized(handlers.postFeeBump)).Methods("POST")
+	handleFunc("/multisig-export", handlers.ensureAccountInitialized(handlers.getMultisigExport)).Methods("GET")
+	handleFunc("/history-export-profiles", handlers.ensureAccountInitialized(handlers.getHistoryExportProfiles)).Methods("GET")
+	handleFunc("/history-export", handlers.ensureAccountInitialized(handlers.getHistoryExport)).Methods("GET")
+	handleFunc("/vault", handlers.ensureAccountInitialized(handlers.getVault)).Methods("GET")
+	handleFunc("/vault/deposit", handlers.ensureAccountInitialized(handlers.postVaultDeposit)).Methods("POST")
+	handleFunc("/vault/unvault", handlers.ensureAccountInitialized(handlers.postUnvault)).Methods("POST")
+	handleFunc("/watched-txs", handlers.ensureAccountInitialized(handlers.getWatchedTxs)).Methods("GET")
+	handleFunc("/watched-txs", handlers.ensureAccountInitialized(handlers.postWatchTx)).Methods("POST")
+	handleFunc("/watched-txs/remove", handlers.ensureAccountInitialized(handlers.postUnwatchTx)).Methods("POST")
+	handleFunc("/invoices", handlers.ensureAccountInitialized(handlers.getInvoices)).Methods("GET")
+	handleFunc("/invoices", handlers.ensureAccountInitialized(handlers.postInvoice)).Methods("POST")
+	handleFunc("/invoices/remove", handlers.ensureAccountInitialized(handlers.postRemoveInvoice)).Methods("POST")
+	handleFunc("/fork-sweep-proposal", handlers.ensureAccountInitialized(handlers.postForkSweepProposal)).Methods("POST")
+	handleFunc("/fork-sweep", handlers.ensureAccountInitialized(handlers.postForkSweep)).Methods("POST")
+	handleFunc("/payment-code", handlers.ensureAccountInitialized(handlers.getPaymentCode)).Methods("GET")
+	handleFunc("/sign-message", handlers.ensureAccountInitialized(handlers.postSignMessage)).Methods("POST")
 	return handlers
 }
 
@@ -87,6 +125,10 @@ type Transaction struct {
 	FeeRatePerKb     coin.FormattedAmount `json:"feeRatePerKb"`
 	Time             *string              `json:"time"`
 	Addresses        []string             `json:"addresses"`
+	Coinjoin         bool                 `json:"coinjoin"`
+	Note             string               `json:"note"`
+	// AddressNotes are the notes of the addresses received on, by address.
+	AddressNotes map[string]string `json:"addressNotes"`
 }
 
 func (handlers *Handlers) ensureAccountInitialized(h func(*http.Request) (interface{}, error)) func(*http.Request) (interface{}, error) {
@@ -98,14 +140,16 @@ func (handlers *Handlers) ensureAccountInitialized(h func(*http.Request) (interf
 	}
 }
 
-func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{}, error) {
+// NewTransactions returns the transactions of the account as returned by the /transactions
+// endpoint.
+func NewTransactions(account btc.Interface) []Transaction {
 	result := []Transaction{}
-	txs := handlers.account.Transactions()
+	txs := account.Transactions()
 	for _, txInfo := range txs {
 		var feeString, feeRatePerKb coin.FormattedAmount
 		if txInfo.Fee != nil {
-			feeString = handlers.account.Coin().FormatAmountAsJSON(int64(*txInfo.Fee))
-			feeRatePerKb = handlers.account.Coin().FormatAmountAsJSON(int64(*txInfo.FeeRatePerKb()))
+			feeString = account.Coin().FormatAmountAsJSON(int64(*txInfo.Fee))
+			feeRatePerKb = account.Coin().FormatAmountAsJSON(int64(*txInfo.FeeRatePerKb()))
 		}
 		var formattedTime *string
 		if txInfo.Timestamp != nil {
@@ -124,14 +168,21 @@ func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{},
 				transactions.TxTypeSend:     "send",
 				transactions.TxTypeSendSelf: "send_to_self",
 			}[txInfo.Type],
-			Amount:       handlers.account.Coin().FormatAmountAsJSON(int64(txInfo.Amount)),
+			Amount:       account.Coin().FormatAmountAsJSON(int64(txInfo.Amount)),
 			Fee:          feeString,
 			FeeRatePerKb: feeRatePerKb,
 			Time:         formattedTime,
 			Addresses:    txInfo.Addresses,
+			Coinjoin:     txInfo.Coinjoin,
+			Note:         txInfo.Note,
+			AddressNotes: txInfo.AddressNotes,
 		})
 	}
-	return result, nil
+	return result
+}
+
+func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{}, error) {
+	return NewTransactions(handlers.account), nil
 }
 
 func (handlers *Handlers) getAccountInfo(_ *http.Request) (interface{}, error) {
@@ -141,31 +192,92 @@ func (handlers *Handlers) getAccountInfo(_ *http.Request) (interface{}, error) {
 func (handlers *Handlers) getUTXOs(_ *http.Request) (interface{}, error) {
 	result := []map[string]interface{}{}
 	for _, output := range handlers.account.SpendableOutputs() {
-		result = append(result,
-			map[string]interface{}{
-				"outPoint": output.OutPoint.String(),
-				"amount":   handlers.account.Coin().FormatAmountAsJSON(output.TxOut.Value),
-				"address":  output.Address,
-			})
+		utxo := map[string]interface{}{
+			"outPoint": output.OutPoint.String(),
+			"amount":   handlers.account.Coin().FormatAmountAsJSON(output.TxOut.Value),
+			"address":  output.Address,
+		}
+		if output.Privacy != nil {
+			utxo["numConfirmations"] = output.Privacy.NumConfirmations
+			utxo["addressReuses"] = output.Privacy.AddressReuses
+			utxo["linkedAddresses"] = output.Privacy.LinkedAddresses
+			utxo["privacyScore"] = output.Privacy.Score
+			utxo["coinjoin"] = output.Privacy.Coinjoin
+		}
+		result = append(result, utxo)
 	}
 	return result, nil
 }
 
-func (handlers *Handlers) getAccountBalance(_ *http.Request) (interface{}, error) {
-	balance := handlers.account.Balance()
+// NewBalance returns the balance of the account as returned by the /balance endpoint.
+func NewBalance(account btc.Interface) map[string]interface{} {
+	balance := account.Balance()
 	return map[string]interface{}{
-		"available":   handlers.account.Coin().FormatAmountAsJSON(int64(balance.Available)),
-		"incoming":    handlers.account.Coin().FormatAmountAsJSON(int64(balance.Incoming)),
+		"available":   account.Coin().FormatAmountAsJSON(int64(balance.Available)),
+		"incoming":    account.Coin().FormatAmountAsJSON(int64(balance.Incoming)),
 		"hasIncoming": balance.Incoming != 0,
+	}
+}
+
+func (handlers *Handlers) getAccountBalance(_ *http.Request) (interface{}, error) {
+	return NewBalance(handlers.account), nil
+}
+
+func (handlers *Handlers) getBalanceExplanation(_ *http.Request) (interface{}, error) {
+	explanation := handlers.account.ExplainBalance()
+	formatAmount := func(amount btcutil.Amount) interface{} {
+		return handlers.account.Coin().FormatAmountAsJSON(int64(amount))
+	}
+	var dustFeeRatePerKb interface{}
+	if explanation.DustFeeRatePerKb != nil {
+		dustFeeRatePerKb = formatAmount(*explanation.DustFeeRatePerKb)
+	}
+	return map[string]interface{}{
+		"confirmed":           formatAmount(explanation.Confirmed),
+		"unconfirmedIncoming": formatAmount(explanation.UnconfirmedIncoming),
+		"unconfirmedChange":   formatAmount(explanation.UnconfirmedChange),
+		"dust":                formatAmount(explanation.Dust),
+		"dustFeeRatePerKb":    dustFeeRatePerKb,
+		"reserved":            formatAmount(explanation.Reserved),
 	}, nil
 }
 
+func (handlers *Handlers) getBalanceSnapshots(r *http.Request) (interface{}, error) {
+	period := transactions.SnapshotPeriod(r.URL.Query().Get("period"))
+	if period == "" {
+		period = transactions.SnapshotPeriodDaily
+	}
+	if period != transactions.SnapshotPeriodDaily && period != transactions.SnapshotPeriodMonthly {
+		return nil, errp.Newf("unknown snapshot period %s", period)
+	}
+	snapshots, err := handlers.account.BalanceSnapshots(period)
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for _, snapshot := range snapshots {
+		result = append(result, map[string]interface{}{
+			"time":      snapshot.Time.Format(time.RFC3339),
+			"available": handlers.account.Coin().FormatAmountAsJSON(int64(snapshot.Available)),
+			"incoming":  handlers.account.Coin().FormatAmountAsJSON(int64(snapshot.Incoming)),
+		})
+	}
+	return result, nil
+}
+
 type sendTxInput struct {
-	address       string
-	sendAmount    btc.SendAmount
-	feeTargetCode btc.FeeTargetCode
-	selectedUTXOs map[wire.OutPoint]struct{}
-	log           *logrus.Entry
+	address        string
+	sendAmount     btc.SendAmount
+	feeTargetCode  btc.FeeTargetCode
+	feeRatePerKb   btcutil.Amount
+	selectedUTXOs  map[wire.OutPoint]struct{}
+	memo           string
+	fiatUnit       string
+	overrideFeeCap bool
+	// overrideDenylist confirms that the recipient is intended even if it is denylisted.
+	overrideDenylist bool
+	disableRBF       bool
+	log              *logrus.Entry
 }
 
 func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
@@ -175,11 +287,26 @@ func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
 		FeeTarget     string   `json:"feeTarget"`
 		Amount        string   `json:"amount"`
 		SelectedUTXOS []string `json:"selectedUTXOS"`
+		Memo          string   `json:"memo"`
+		FiatUnit      string   `json:"fiatUnit"`
+		// OverrideFeeCap confirms that a fee above the configured cap is intended.
+		OverrideFeeCap bool `json:"overrideFeeCap"`
+		// OverrideDenylist confirms that the recipient is intended even if it is denylisted.
+		OverrideDenylist bool `json:"overrideDenylist"`
+		// DisableRBF creates a tx which does not signal replaceability (BIP125).
+		DisableRBF bool `json:"disableRBF"`
+		// FeeRatePerKb optionally overrides the fee target when signing offline.
+		FeeRatePerKb string `json:"feeRatePerKb"`
 	}{}
 	if err := json.Unmarshal(jsonBytes, &jsonBody); err != nil {
 		return errp.WithStack(err)
 	}
 	input.address = jsonBody.Address
+	input.memo = jsonBody.Memo
+	input.fiatUnit = jsonBody.FiatUnit
+	input.overrideFeeCap = jsonBody.OverrideFeeCap
+	input.overrideDenylist = jsonBody.OverrideDenylist
+	input.disableRBF = jsonBody.DisableRBF
 	var err error
 	input.feeTargetCode, err = btc.NewFeeTargetCode(jsonBody.FeeTarget, input.log)
 	if err != nil {
@@ -201,6 +328,16 @@ func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
 			return errp.WithStack(btc.TxValidationError("invalid amount"))
 		}
 	}
+	if jsonBody.FeeRatePerKb != "" {
+		feeRatePerKb, err := strconv.ParseFloat(jsonBody.FeeRatePerKb, 64)
+		if err != nil {
+			return errp.WithStack(btc.TxValidationError("invalid fee rate"))
+		}
+		input.feeRatePerKb, err = btcutil.NewAmount(feeRatePerKb)
+		if err != nil || input.feeRatePerKb < 0 {
+			return errp.WithStack(btc.TxValidationError("invalid fee rate"))
+		}
+	}
 	input.selectedUTXOs = map[wire.OutPoint]struct{}{}
 	for _, outPointString := range jsonBody.SelectedUTXOS {
 		outPoint, err := util.ParseOutPoint([]byte(outPointString))
@@ -217,10 +354,21 @@ func (handlers *Handlers) postAccountSendTx(r *http.Request) (interface{}, error
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return nil, errp.WithStack(err)
 	}
+	if response := handlers.checkRecipient(input); response != nil {
+		return response, nil
+	}
 
-	err := handlers.account.SendTx(input.address, input.sendAmount, input.feeTargetCode, input.selectedUTXOs)
+	err := handlers.account.SendTx(
+		input.address, input.sendAmount, input.feeTargetCode, input.selectedUTXOs,
+		input.memo, input.fiatUnit, input.overrideFeeCap, input.disableRBF)
 	if bitbox.IsErrorAbort(err) {
-		return map[string]interface{}{"success": false}, nil
+		return apierror.Wrap(apierror.CodeAborted, err).Response(), nil
+	}
+	if errp.Cause(err) == maketx.ErrFeeCapExceeded {
+		return apierror.New(apierror.CodeFeeCapExceeded, "fee cap exceeded").Response(), nil
+	}
+	if _, ok := errp.Cause(err).(maketx.PolicyViolationError); ok {
+		return apierror.Wrap(apierror.CodePolicyViolation, err).Response(), nil
 	}
 	if err != nil {
 		return nil, errp.WithMessage(err, "Failed to send transaction")
@@ -228,18 +376,189 @@ func (handlers *Handlers) postAccountSendTx(r *http.Request) (interface{}, error
 	return map[string]interface{}{"success": true}, nil
 }
 
+// PrepareTx decodes a request in the format of the /sendtx endpoint and prepares the tx of the
+// account without signing it, so that it can be signed together with other transactions.
+func PrepareTx(account *btc.Account, request json.RawMessage, log *logrus.Entry) (*btc.PreparedTx, error) {
+	input := &sendTxInput{log: log}
+	if err := json.Unmarshal(request, &input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if !input.overrideDenylist {
+		err := account.CheckRecipient(input.address)
+		if _, ok := errp.Cause(err).(*btc.DenylistedAddressError); ok {
+			return nil, err
+		}
+	}
+	return account.PrepareTx(
+		input.address, input.sendAmount, input.feeTargetCode, input.selectedUTXOs,
+		input.memo, input.fiatUnit, input.overrideFeeCap, input.disableRBF)
+}
+
+func (handlers *Handlers) postOfflineTx(r *http.Request) (interface{}, error) {
+	input := &sendTxInput{log: handlers.log}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return txProposalError(errp.WithStack(err))
+	}
+	if response := handlers.checkRecipient(input); response != nil {
+		return response, nil
+	}
+	offlineTx, err := handlers.account.SignTxOffline(
+		input.address, input.sendAmount, input.feeTargetCode, input.feeRatePerKb,
+		input.selectedUTXOs, input.memo, input.fiatUnit, input.overrideFeeCap, input.disableRBF)
+	if bitbox.IsErrorAbort(err) {
+		return apierror.Wrap(apierror.CodeAborted, err).Response(), nil
+	}
+	if _, ok := errp.Cause(err).(maketx.PolicyViolationError); ok {
+		return apierror.Wrap(apierror.CodePolicyViolation, err).Response(), nil
+	}
+	if err != nil {
+		return txProposalError(err)
+	}
+	var lastSync *string
+	if offlineTx.LastSync != nil {
+		formatted := offlineTx.LastSync.Format(time.RFC3339)
+		lastSync = &formatted
+	}
+	return map[string]interface{}{
+		"success":    true,
+		"txID":       offlineTx.TxID,
+		"rawTx":      offlineTx.RawTx,
+		"amount":     handlers.account.Coin().FormatAmountAsJSON(int64(offlineTx.Amount)),
+		"fee":        handlers.account.Coin().FormatAmountAsJSON(int64(offlineTx.Fee.Amount)),
+		"feeDetails": formatFeeDetails(offlineTx.Fee),
+		"lastSync":   lastSync,
+		"warnings":   offlineTx.Warnings,
+	}, nil
+}
+
+func (handlers *Handlers) postBroadcastTx(r *http.Request) (interface{}, error) {
+	var rawTx string
+	if err := json.NewDecoder(r.Body).Decode(&rawTx); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	txID, err := handlers.account.BroadcastTx(rawTx)
+	if _, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to broadcast transaction")
+	}
+	return map[string]interface{}{"success": true, "txID": txID}, nil
+}
+
+func (handlers *Handlers) postCancelOfflineTx(r *http.Request) (interface{}, error) {
+	var txID string
+	if err := json.NewDecoder(r.Body).Decode(&txID); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.account.CancelOfflineTx(txID)
+	if _, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postTxNote(r *http.Request) (interface{}, error) {
+	var input struct {
+		TxID string `json:"txID"`
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.account.SetTxNote(input.TxID, input.Note)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+// postAddressNote stores a note for an address, e.g. who it was given to.
+func (handlers *Handlers) postAddressNote(r *http.Request) (interface{}, error) {
+	var input struct {
+		Address string `json:"address"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.account.SetAddressNote(input.Address, input.Note)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+// txValidationError maps the validation error to an API error, so the frontend can show it next to
+// the affected input field.
+func txValidationError(err btc.TxValidationError) *apierror.Error {
+	switch err {
+	case "invalid address":
+		return apierror.New(apierror.CodeInvalidAddress, err.Error())
+	case "invalid amount":
+		return apierror.New(apierror.CodeInvalidAmount, err.Error())
+	default:
+		return apierror.New(apierror.CodeInvalidInput, err.Error())
+	}
+}
+
+// checkRecipient returns the error response if the recipient is denylisted and the input does not
+// override the denylist, or nil otherwise. Invalid addresses are reported by the tx proposal.
+func (handlers *Handlers) checkRecipient(input *sendTxInput) map[string]interface{} {
+	if input.overrideDenylist {
+		return nil
+	}
+	denylisted, ok := errp.Cause(handlers.account.CheckRecipient(input.address)).(*btc.DenylistedAddressError)
+	if !ok {
+		return nil
+	}
+	result := apierror.Wrap(apierror.CodeAddressDenylisted, denylisted).Response()
+	result["denylistSources"] = denylisted.Sources
+	return result
+}
+
+// formatFeeDetails formats the fee in sats, as fee rate in sat/vB and in fiat, so that the frontend
+// does not have to derive them from the formatted fee.
+func formatFeeDetails(fee *btc.FeeDetails) map[string]interface{} {
+	var fiat map[string]interface{}
+	if fee.Fiat != nil {
+		fiat = map[string]interface{}{
+			"unit":          fee.Fiat.Unit,
+			"amount":        fee.Fiat.Amount,
+			"rate":          fee.Fiat.Rate,
+			"rateTimestamp": fee.Fiat.RateTimestamp.UTC().Format(time.RFC3339),
+			"rateSource":    fee.Fiat.RateSource,
+		}
+	}
+	return map[string]interface{}{
+		"sats":        int64(fee.Amount),
+		"vsize":       fee.VSize,
+		"satPerVByte": fee.SatPerVByte,
+		"fiat":        fiat,
+	}
+}
+
 func txProposalError(err error) (interface{}, error) {
 	if errp.Cause(err) == maketx.ErrInsufficientFunds {
-		return map[string]interface{}{
-			"success": false,
-			"errMsg":  "insufficient funds",
-		}, nil
+		return apierror.New(apierror.CodeInsufficientFunds, "insufficient funds").Response(), nil
+	}
+	if errp.Cause(err) == maketx.ErrFeeCapExceeded {
+		return apierror.New(apierror.CodeFeeCapExceeded, "fee cap exceeded").Response(), nil
+	}
+	if errp.Cause(err) == maketx.ErrFeeBumpNotPossible {
+		return apierror.New(apierror.CodeFeeBumpNotPossible, "fee bump not possible").Response(), nil
 	}
 	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
-		return map[string]interface{}{
-			"success": false,
-			"errMsg":  validationErr.Error(),
-		}, nil
+		return txValidationError(validationErr).Response(), nil
 	}
 	return nil, errp.WithMessage(err, "Failed to create transaction proposal")
 }
@@ -249,20 +568,28 @@ func (handlers *Handlers) getAccountTxProposal(r *http.Request) (interface{}, er
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return txProposalError(errp.WithStack(err))
 	}
-	outputAmount, fee, total, err := handlers.account.TxProposal(
+	if response := handlers.checkRecipient(input); response != nil {
+		return response, nil
+	}
+	outputAmount, fee, total, warnings, err := handlers.account.TxProposal(
 		input.address,
 		input.sendAmount,
 		input.feeTargetCode,
 		input.selectedUTXOs,
+		input.overrideFeeCap,
+		input.disableRBF,
+		input.fiatUnit,
 	)
 	if err != nil {
 		return txProposalError(err)
 	}
 	return map[string]interface{}{
-		"success": true,
-		"amount":  handlers.account.Coin().FormatAmountAsJSON(int64(outputAmount)),
-		"fee":     handlers.account.Coin().FormatAmountAsJSON(int64(fee)),
-		"total":   handlers.account.Coin().FormatAmountAsJSON(int64(total)),
+		"success":    true,
+		"amount":     handlers.account.Coin().FormatAmountAsJSON(int64(outputAmount)),
+		"fee":        handlers.account.Coin().FormatAmountAsJSON(int64(fee.Amount)),
+		"feeDetails": formatFeeDetails(fee),
+		"total":      handlers.account.Coin().FormatAmountAsJSON(int64(total)),
+		"warnings":   warnings,
 	}, nil
 }
 
@@ -270,6 +597,25 @@ func (handlers *Handlers) getHeadersStatus(r *http.Request) (interface{}, error)
 	return handlers.account.HeadersStatus()
 }
 
+// postNickname sets the nickname and emoji of the account. Empty values remove the nickname.
+func (handlers *Handlers) postNickname(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Name  string `json:"name"`
+		Emoji string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	nickname, err := btc.NewAccountNickname(jsonBody.Name, jsonBody.Emoji)
+	if err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	if err := handlers.account.SetNickname(nickname); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
 func (handlers *Handlers) getAccountFeeTargets(_ *http.Request) (interface{}, error) {
 	feeTargets, defaultFeeTarget := handlers.account.FeeTargets()
 	result := []map[string]interface{}{}
@@ -313,20 +659,101 @@ func (handlers *Handlers) getAccountStatus(_ *http.Request) (interface{}, error)
 	return status, nil
 }
 
+// addressVerification is the result of verifying a receive address, as returned by the
+// /verify-addresses endpoint.
+type addressVerification struct {
+	Address       string `json:"address"`
+	ScriptHashHex string `json:"scriptHashHex"`
+	// Status is one of "verified", "aborted", "failed" and "skipped".
+	Status string  `json:"status"`
+	Time   *string `json:"time"`
+}
+
+func newAddressVerification(result *btc.AddressVerification) addressVerification {
+	status := string(result.Status)
+	if result.Status == btc.AddressVerificationFailed && bitbox.IsErrorAbort(result.Err) {
+		status = "aborted"
+	}
+	var formattedTime *string
+	if result.Status != btc.AddressVerificationSkipped {
+		t := result.Time.Format(time.RFC3339)
+		formattedTime = &t
+	}
+	return addressVerification{
+		Address:       result.Address.EncodeAddress(),
+		ScriptHashHex: string(result.Address.PubkeyScriptHashHex()),
+		Status:        status,
+		Time:          formattedTime,
+	}
+}
+
 func (handlers *Handlers) getReceiveAddresses(_ *http.Request) (interface{}, error) {
+	notes, err := handlers.account.AddressNotes()
+	if err != nil {
+		return nil, err
+	}
 	addresses := []interface{}{}
 	for _, address := range handlers.account.GetUnusedReceiveAddresses() {
+		var verification *addressVerification
+		if result := handlers.account.AddressVerification(address.PubkeyScriptHashHex()); result != nil {
+			v := newAddressVerification(result)
+			verification = &v
+		}
 		addresses = append(addresses, struct {
-			Address       string `json:"address"`
-			ScriptHashHex string `json:"scriptHashHex"`
+			Address       string               `json:"address"`
+			ScriptHashHex string               `json:"scriptHashHex"`
+			Verification  *addressVerification `json:"verification"`
+			Note          string               `json:"note"`
 		}{
 			Address:       address.EncodeAddress(),
 			ScriptHashHex: string(address.PubkeyScriptHashHex()),
+			Verification:  verification,
+			Note:          notes[address.EncodeAddress()],
 		})
 	}
 	return addresses, nil
 }
 
+// getPaymentRequest returns the BIP21 payment URI for the given address of the account, amount,
+// label and message, and its QR code as a PNG data URL, or as an SVG image if "image" is "svg".
+func (handlers *Handlers) getPaymentRequest(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	accountCoin := handlers.account.Coin()
+	address, err := btcutil.DecodeAddress(query.Get("address"), accountCoin.Net())
+	if err != nil || !address.IsForNet(accountCoin.Net()) {
+		return apierror.New(apierror.CodeInvalidInput, "invalid address").Response(), nil
+	}
+	uri := &bip21.URI{
+		Scheme:  accountCoin.URIScheme(),
+		Address: address.EncodeAddress(),
+		Label:   query.Get("label"),
+		Message: query.Get("message"),
+	}
+	if amountString := query.Get("amount"); amountString != "" {
+		amount, err := strconv.ParseFloat(amountString, 64)
+		if err != nil {
+			return txValidationError("invalid amount").Response(), nil
+		}
+		uri.Amount, err = btcutil.NewAmount(amount)
+		if err != nil || uri.Amount <= 0 {
+			return txValidationError("invalid amount").Response(), nil
+		}
+	}
+	var image string
+	if query.Get("image") == "svg" {
+		image, err = qr.SVG(uri.String())
+	} else {
+		image, err = qr.PNGDataURL(uri.String(), 256)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"uri":   uri.String(),
+		"image": image,
+	}, nil
+}
+
 func (handlers *Handlers) postVerifyAddress(r *http.Request) (interface{}, error) {
 	var scriptHashHex string
 	if err := json.NewDecoder(r.Body).Decode(&scriptHashHex); err != nil {
@@ -335,6 +762,25 @@ func (handlers *Handlers) postVerifyAddress(r *http.Request) (interface{}, error
 	return handlers.account.VerifyAddress(blockchain.ScriptHashHex(scriptHashHex))
 }
 
+func (handlers *Handlers) postVerifyAddresses(r *http.Request) (interface{}, error) {
+	var count int
+	if err := json.NewDecoder(r.Body).Decode(&count); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	results, err := handlers.account.VerifyAddresses(count)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	verifications := []addressVerification{}
+	for _, result := range results {
+		verifications = append(verifications, newAddressVerification(result))
+	}
+	return verifications, nil
+}
+
 func (handlers *Handlers) postConvertToLegacyAddress(r *http.Request) (interface{}, error) {
 	var scriptHashHex string
 	if err := json.NewDecoder(r.Body).Decode(&scriptHashHex); err != nil {
@@ -346,3 +792,448 @@ func (handlers *Handlers) postConvertToLegacyAddress(r *http.Request) (interface
 	}
 	return address.EncodeAddress(), nil
 }
+
+func (handlers *Handlers) getFeeBumpSuggestions(_ *http.Request) (interface{}, error) {
+	result := []map[string]interface{}{}
+	for _, suggestion := range handlers.account.FeeBumpSuggestions() {
+		result = append(result, map[string]interface{}{
+			"txID":                 suggestion.TxID,
+			"feeRatePerKb":         handlers.account.Coin().FormatAmountAsJSON(int64(suggestion.FeeRatePerKb)),
+			"requiredFeeRatePerKb": handlers.account.Coin().FormatAmountAsJSON(int64(suggestion.RequiredFeeRatePerKb)),
+			"feeTarget":            suggestion.FeeTargetCode,
+			"pendingBlocks":        suggestion.PendingBlocks,
+		})
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) formatTxPackage(entries []*btc.TxPackageEntry) []map[string]interface{} {
+	result := []map[string]interface{}{}
+	for _, entry := range entries {
+		result = append(result, map[string]interface{}{
+			"txID":  entry.TxID,
+			"vsize": entry.VSize,
+			"fee":   handlers.account.Coin().FormatAmountAsJSON(int64(entry.Fee)),
+			"depth": entry.Depth,
+			"ours":  entry.Ours,
+		})
+	}
+	return result
+}
+
+// getTxAncestry returns the unconfirmed ancestors and descendants of the tx given by "txID", and
+// why it is not confirming with the fee rate of the fee target given by "feeTarget".
+func (handlers *Handlers) getTxAncestry(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	feeTargetCode, err := btc.NewFeeTargetCode(query.Get("feeTarget"), handlers.log)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to retrieve fee target code")
+	}
+	ancestry, err := handlers.account.TxAncestry(query.Get("txID"), feeTargetCode)
+	if err != nil {
+		return nil, err
+	}
+	accountCoin := handlers.account.Coin()
+	var requiredFeeRatePerKb *coin.FormattedAmount
+	if ancestry.RequiredFeeRatePerKb != nil {
+		formatted := accountCoin.FormatAmountAsJSON(int64(*ancestry.RequiredFeeRatePerKb))
+		requiredFeeRatePerKb = &formatted
+	}
+	var cpfpOutPoint *string
+	if ancestry.CPFPOutPoint != nil {
+		outPoint := ancestry.CPFPOutPoint.String()
+		cpfpOutPoint = &outPoint
+	}
+	return map[string]interface{}{
+		"txID":                   ancestry.TxID,
+		"vsize":                  ancestry.VSize,
+		"fee":                    accountCoin.FormatAmountAsJSON(int64(ancestry.Fee)),
+		"confirmed":              ancestry.Confirmed,
+		"ancestors":              handlers.formatTxPackage(ancestry.Ancestors),
+		"descendants":            handlers.formatTxPackage(ancestry.Descendants),
+		"depth":                  ancestry.Depth,
+		"feeRatePerKb":           accountCoin.FormatAmountAsJSON(int64(ancestry.FeeRatePerKb)),
+		"ancestorFeeRatePerKb":   accountCoin.FormatAmountAsJSON(int64(ancestry.AncestorFeeRatePerKb)),
+		"descendantFeeRatePerKb": accountCoin.FormatAmountAsJSON(int64(ancestry.DescendantFeeRatePerKb)),
+		"requiredFeeRatePerKb":   requiredFeeRatePerKb,
+		"reason":                 ancestry.Reason,
+		"cpfpOutPoint":           cpfpOutPoint,
+		"cpfpFee":                accountCoin.FormatAmountAsJSON(int64(ancestry.CPFPFee)),
+		"cpfpPossible":           ancestry.CPFPPossible,
+	}, nil
+}
+
+type feeBumpInput struct {
+	txID          string
+	feeTargetCode btc.FeeTargetCode
+	fiatUnit      string
+}
+
+func (handlers *Handlers) decodeFeeBumpInput(r *http.Request) (*feeBumpInput, error) {
+	jsonBody := struct {
+		TxID      string `json:"txID"`
+		FeeTarget string `json:"feeTarget"`
+		FiatUnit  string `json:"fiatUnit"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	feeTargetCode, err := btc.NewFeeTargetCode(jsonBody.FeeTarget, handlers.log)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to retrieve fee target code")
+	}
+	return &feeBumpInput{
+		txID: jsonBody.TxID, feeTargetCode: feeTargetCode, fiatUnit: jsonBody.FiatUnit}, nil
+}
+
+func (handlers *Handlers) postFeeBumpProposal(r *http.Request) (interface{}, error) {
+	input, err := handlers.decodeFeeBumpInput(r)
+	if err != nil {
+		return txProposalError(err)
+	}
+	outputAmount, fee, total, err := handlers.account.FeeBumpTxProposal(
+		input.txID, input.feeTargetCode, input.fiatUnit)
+	if err != nil {
+		return txProposalError(err)
+	}
+	return map[string]interface{}{
+		"success":    true,
+		"amount":     handlers.account.Coin().FormatAmountAsJSON(int64(outputAmount)),
+		"fee":        handlers.account.Coin().FormatAmountAsJSON(int64(fee.Amount)),
+		"feeDetails": formatFeeDetails(fee),
+		"total":      handlers.account.Coin().FormatAmountAsJSON(int64(total)),
+	}, nil
+}
+
+func (handlers *Handlers) postFeeBump(r *http.Request) (interface{}, error) {
+	input, err := handlers.decodeFeeBumpInput(r)
+	if err != nil {
+		return nil, err
+	}
+	err = handlers.account.SendFeeBump(input.txID, input.feeTargetCode)
+	if bitbox.IsErrorAbort(err) {
+		return apierror.Wrap(apierror.CodeAborted, err).Response(), nil
+	}
+	if _, ok := errp.Cause(err).(maketx.PolicyViolationError); ok {
+		return apierror.Wrap(apierror.CodePolicyViolation, err).Response(), nil
+	}
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to bump fee")
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) getMultisigExport(r *http.Request) (interface{}, error) {
+	format := btc.MultisigExportFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = btc.MultisigExportFormatText
+	}
+	return handlers.account.ExportMultisig(format)
+}
+
+func (handlers *Handlers) getHistoryExportProfiles(_ *http.Request) (interface{}, error) {
+	return btc.HistoryExportProfiles, nil
+}
+
+func (handlers *Handlers) getHistoryExport(r *http.Request) (interface{}, error) {
+	profile, err := btc.LookupHistoryExportProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		return nil, err
+	}
+	content := new(bytes.Buffer)
+	if err := handlers.account.ExportHistory(content, profile); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"filename": handlers.account.Code() + "-transactions-" + profile.Name + ".csv",
+		"content":  content.String(),
+	}, nil
+}
+
+func (handlers *Handlers) getVault(_ *http.Request) (interface{}, error) {
+	deposits, enabled := handlers.account.VaultDeposits()
+	result := []map[string]interface{}{}
+	for _, deposit := range deposits {
+		var spentBy *string
+		if deposit.SpentBy != nil {
+			txID := deposit.SpentBy.String()
+			spentBy = &txID
+		}
+		result = append(result, map[string]interface{}{
+			"outPoint":    deposit.OutPoint.String(),
+			"unvaultTxID": deposit.UnvaultTx.TxHash().String(),
+			"delayBlocks": deposit.DelayBlocks,
+			"initiated":   deposit.Initiated,
+			"spentBy":     spentBy,
+			"unexpected":  deposit.Unexpected(),
+		})
+	}
+	return map[string]interface{}{
+		"enabled":  enabled,
+		"deposits": result,
+	}, nil
+}
+
+func (handlers *Handlers) postVaultDeposit(r *http.Request) (interface{}, error) {
+	var input struct {
+		UnvaultTx     string `json:"unvaultTx"`
+		WitnessScript string `json:"witnessScript"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.account.AddVaultDeposit(input.UnvaultTx, input.WitnessScript)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postUnvault(r *http.Request) (interface{}, error) {
+	var outPointString string
+	if err := json.NewDecoder(r.Body).Decode(&outPointString); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	outPoint, err := util.ParseOutPoint([]byte(outPointString))
+	if err != nil {
+		return nil, err
+	}
+	if err := handlers.account.Unvault(*outPoint); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) getWatchedTxs(_ *http.Request) (interface{}, error) {
+	result := []map[string]interface{}{}
+	for _, watchedTx := range handlers.account.WatchedTxs() {
+		var rawTx bytes.Buffer
+		if err := watchedTx.Tx.Serialize(&rawTx); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		var conflictingTx *string
+		if watchedTx.ConflictingTx != nil {
+			txID := watchedTx.ConflictingTx.String()
+			conflictingTx = &txID
+		}
+		result = append(result, map[string]interface{}{
+			"txID":          watchedTx.Tx.TxHash().String(),
+			"rawTx":         hex.EncodeToString(rawTx.Bytes()),
+			"lockTime":      watchedTx.Tx.LockTime,
+			"added":         watchedTx.Added.Format(time.RFC3339),
+			"state":         watchedTx.State,
+			"conflictingTx": conflictingTx,
+		})
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) postWatchTx(r *http.Request) (interface{}, error) {
+	var rawTx string
+	if err := json.NewDecoder(r.Body).Decode(&rawTx); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.account.WatchTx(rawTx)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postUnwatchTx(r *http.Request) (interface{}, error) {
+	var txID string
+	if err := json.NewDecoder(r.Body).Decode(&txID); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.account.UnwatchTx(txID); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) formatInvoice(invoice *invoices.Invoice) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          invoice.ID,
+		"address":     invoice.Address,
+		"amount":      handlers.account.Coin().FormatAmountAsJSON(int64(invoice.Amount)),
+		"description": invoice.Description,
+		"created":     invoice.Created.Format(time.RFC3339),
+		"expires":     invoice.Expires.Format(time.RFC3339),
+		"received":    handlers.account.Coin().FormatAmountAsJSON(int64(invoice.Received)),
+		"state":       invoice.State,
+	}
+}
+
+func (handlers *Handlers) getInvoices(_ *http.Request) (interface{}, error) {
+	result := []map[string]interface{}{}
+	for _, invoice := range handlers.account.Invoices() {
+		result = append(result, handlers.formatInvoice(invoice))
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) postInvoice(r *http.Request) (interface{}, error) {
+	var input struct {
+		Amount      string `json:"amount"`
+		Description string `json:"description"`
+		// ExpiresIn is the validity of the invoice in seconds.
+		ExpiresIn int64 `json:"expiresIn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	amount, err := strconv.ParseFloat(input.Amount, 64)
+	if err != nil {
+		return txValidationError("invalid amount").Response(), nil
+	}
+	btcAmount, err := btcutil.NewAmount(amount)
+	if err != nil {
+		return txValidationError("invalid amount").Response(), nil
+	}
+	invoice, err := handlers.account.AddInvoice(
+		btcAmount, input.Description, time.Duration(input.ExpiresIn)*time.Second)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "invoice": handlers.formatInvoice(invoice)}, nil
+}
+
+func (handlers *Handlers) postRemoveInvoice(r *http.Request) (interface{}, error) {
+	var id string
+	if err := json.NewDecoder(r.Body).Decode(&id); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.account.RemoveInvoice(id); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+// forkSweepInput is the input of the fork sweep endpoints. The amounts are in the unit of the
+// forked chain.
+type forkSweepInput struct {
+	Fork             string            `json:"fork"`
+	Servers          []*rpc.ServerInfo `json:"servers"`
+	RecipientAddress string            `json:"recipientAddress"`
+	FeeRatePerKb     string            `json:"feeRatePerKb"`
+	// Amount is the amount of the proposal the user agreed to. Only used by postForkSweep.
+	Amount string `json:"amount"`
+}
+
+func parseForkAmount(amountString string) (btcutil.Amount, error) {
+	amount, err := strconv.ParseFloat(amountString, 64)
+	if err != nil {
+		return 0, errp.WithStack(err)
+	}
+	return btcutil.NewAmount(amount)
+}
+
+func (input *forkSweepInput) request() (*btc.ForkSweepRequest, error) {
+	request := &btc.ForkSweepRequest{
+		Fork:             input.Fork,
+		Servers:          input.Servers,
+		RecipientAddress: input.RecipientAddress,
+	}
+	if input.FeeRatePerKb != "" {
+		feeRatePerKb, err := parseForkAmount(input.FeeRatePerKb)
+		if err != nil || feeRatePerKb < 0 {
+			return nil, errp.WithStack(btc.TxValidationError("invalid fee rate"))
+		}
+		request.FeeRatePerKb = feeRatePerKb
+	}
+	return request, nil
+}
+
+func formatForkAmount(fork *forksweep.Fork, amount btcutil.Amount) coin.FormattedAmount {
+	return coin.FormattedAmount{
+		Amount: strconv.FormatFloat(amount.ToUnit(btcutil.AmountBTC), 'f', -int(btcutil.AmountBTC+8), 64),
+		Unit:   fork.Unit,
+	}
+}
+
+func forkSweepError(err error) (interface{}, error) {
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if bitbox.IsErrorAbort(err) {
+		return apierror.Wrap(apierror.CodeAborted, err).Response(), nil
+	}
+	return nil, errp.WithMessage(err, "Failed to sweep the forked chain")
+}
+
+func (handlers *Handlers) postForkSweepProposal(r *http.Request) (interface{}, error) {
+	var input forkSweepInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	request, err := input.request()
+	if err != nil {
+		return forkSweepError(err)
+	}
+	sweep, err := handlers.account.ForkSweepProposal(request)
+	if err != nil {
+		return forkSweepError(err)
+	}
+	return map[string]interface{}{
+		"success": true,
+		"fork":    sweep.Fork,
+		"inputs":  len(sweep.Inputs),
+		"amount":  formatForkAmount(sweep.Fork, sweep.Amount),
+		"fee":     formatForkAmount(sweep.Fork, sweep.Fee),
+	}, nil
+}
+
+func (handlers *Handlers) postForkSweep(r *http.Request) (interface{}, error) {
+	var input forkSweepInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	request, err := input.request()
+	if err != nil {
+		return forkSweepError(err)
+	}
+	expectedAmount, err := parseForkAmount(input.Amount)
+	if err != nil {
+		return forkSweepError(errp.WithStack(btc.TxValidationError("invalid amount")))
+	}
+	txID, err := handlers.account.SendForkSweep(request, expectedAmount)
+	if err != nil {
+		return forkSweepError(err)
+	}
+	return map[string]interface{}{"success": true, "txID": txID}, nil
+}
+
+func (handlers *Handlers) getPaymentCode(_ *http.Request) (interface{}, error) {
+	info, enabled := handlers.account.PaymentCode()
+	return map[string]interface{}{
+		"enabled":     enabled,
+		"paymentCode": info,
+	}, nil
+}
+
+func (handlers *Handlers) postSignMessage(r *http.Request) (interface{}, error) {
+	var input struct {
+		Address string `json:"address"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	signature, err := handlers.account.SignMessage(input.Address, input.Message)
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return txValidationError(validationErr).Response(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "signature": signature}, nil
+}
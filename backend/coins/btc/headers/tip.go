@@ -0,0 +1,120 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// medianTimeBlocks is the number of blocks of which the median timestamp is the minimum time of
+// the next block, see BIP113.
+const medianTimeBlocks = 11
+
+// Tip is the tip of the verified header chain.
+type Tip struct {
+	Height  int               `json:"height"`
+	HashHex blockchain.TXHash `json:"hashHex"`
+	// Timestamp is the timestamp of the tip header.
+	Timestamp time.Time `json:"timestamp"`
+	// MedianTime is the median timestamp of the last 11 headers, against which time based
+	// timelocks are evaluated (BIP113).
+	MedianTime time.Time `json:"medianTime"`
+	// Synced is false while headers up to the tip height reported by the server are downloaded.
+	Synced bool `json:"synced"`
+	// TargetTimePerBlock is the expected average time between blocks.
+	TargetTimePerBlock time.Duration `json:"-"`
+}
+
+// HeightEstimate is the estimated time at which a block height is reached.
+type HeightEstimate struct {
+	Height int `json:"height"`
+	// Reached is true if the tip is at or above the height.
+	Reached bool `json:"reached"`
+	// Blocks is the number of blocks until the height is reached, or 0 if it was reached already.
+	Blocks int `json:"blocks"`
+	// Time is the estimated time of the block at the height. It is zero if the height was reached
+	// already, see HeaderByHeight() for its timestamp.
+	Time time.Time `json:"time"`
+}
+
+// VerifiedTip returns the tip of the header chain, which was verified by the proof of work. It
+// returns an error if no headers were downloaded yet.
+func (headers *Headers) VerifiedTip() (*Tip, error) {
+	defer headers.lock.RLock()()
+	dbTx, err := headers.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	tip, err := dbTx.Tip()
+	if err != nil {
+		return nil, err
+	}
+	timestamps := []time.Time{}
+	result := &Tip{
+		Height:             tip,
+		Synced:             tip >= headers.targetHeight,
+		TargetTimePerBlock: headers.net.TargetTimePerBlock,
+	}
+	for height := tip; height >= 0 && height > tip-medianTimeBlocks; height-- {
+		header, err := dbTx.HeaderByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		if header == nil {
+			break
+		}
+		if height == tip {
+			result.HashHex = blockchain.TXHash(header.BlockHash())
+			result.Timestamp = header.Timestamp
+		}
+		timestamps = append(timestamps, header.Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return nil, errp.New("no headers available yet")
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i].Before(timestamps[j]) })
+	result.MedianTime = timestamps[len(timestamps)/2]
+	return result, nil
+}
+
+// EstimateHeight estimates when the given height will be reached, assuming that blocks are found
+// every TargetTimePerBlock on average. As finding a block does not depend on the time elapsed since
+// the previous one, the time is counted from now instead of the timestamp of the tip.
+func (tip *Tip) EstimateHeight(height int, now time.Time) *HeightEstimate {
+	if height <= tip.Height {
+		return &HeightEstimate{Height: height, Reached: true}
+	}
+	blocks := height - tip.Height
+	return &HeightEstimate{
+		Height: height,
+		Blocks: blocks,
+		Time:   now.Add(time.Duration(blocks) * tip.TargetTimePerBlock),
+	}
+}
+
+// EstimateTime estimates the height of the first block at or after the given time, see
+// EstimateHeight().
+func (tip *Tip) EstimateTime(target time.Time, now time.Time) *HeightEstimate {
+	if !target.After(now) {
+		return &HeightEstimate{Height: tip.Height, Reached: true}
+	}
+	blocks := int((target.Sub(now) + tip.TargetTimePerBlock - 1) / tip.TargetTimePerBlock)
+	return tip.EstimateHeight(tip.Height+blocks, now)
+}
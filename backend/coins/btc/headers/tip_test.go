@@ -0,0 +1,44 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+)
+
+func TestTipEstimate(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	tip := &headers.Tip{Height: 1000, TargetTimePerBlock: 10 * time.Minute}
+
+	require.Equal(t, &headers.HeightEstimate{Height: 999, Reached: true}, tip.EstimateHeight(999, now))
+	require.Equal(t, &headers.HeightEstimate{Height: 1000, Reached: true}, tip.EstimateHeight(1000, now))
+	require.Equal(t,
+		&headers.HeightEstimate{Height: 1006, Blocks: 6, Time: now.Add(time.Hour)},
+		tip.EstimateHeight(1006, now))
+
+	require.Equal(t, &headers.HeightEstimate{Height: 1000, Reached: true}, tip.EstimateTime(now, now))
+	// The first block after the time.
+	require.Equal(t,
+		&headers.HeightEstimate{Height: 1002, Blocks: 2, Time: now.Add(20 * time.Minute)},
+		tip.EstimateTime(now.Add(15*time.Minute), now))
+	require.Equal(t,
+		&headers.HeightEstimate{Height: 1144, Blocks: 144, Time: now.Add(24 * time.Hour)},
+		tip.EstimateTime(now.Add(24*time.Hour), now))
+}
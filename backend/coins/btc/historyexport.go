@@ -0,0 +1,322 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// HistoryExportColumn is the value written in a column of the exported transaction history.
+type HistoryExportColumn string
+
+const (
+	// HistoryExportColumnTime is the confirmation time, formatted with the date format of the
+	// profile. It is empty for unconfirmed transactions.
+	HistoryExportColumnTime HistoryExportColumn = "time"
+	// HistoryExportColumnType is the type of the transaction, labeled as in the profile.
+	HistoryExportColumnType HistoryExportColumn = "type"
+	// HistoryExportColumnAmount is the amount of the transaction, negative if it was sent.
+	HistoryExportColumnAmount HistoryExportColumn = "amount"
+	// HistoryExportColumnSentAmount is the amount sent out of the wallet, or empty.
+	HistoryExportColumnSentAmount HistoryExportColumn = "sentAmount"
+	// HistoryExportColumnReceivedAmount is the amount received by the wallet, or empty.
+	HistoryExportColumnReceivedAmount HistoryExportColumn = "receivedAmount"
+	// HistoryExportColumnUnit is the unit of the coin, e.g. BTC.
+	HistoryExportColumnUnit HistoryExportColumn = "unit"
+	// HistoryExportColumnSentUnit is the unit of the coin if the transaction has a sent amount.
+	HistoryExportColumnSentUnit HistoryExportColumn = "sentUnit"
+	// HistoryExportColumnReceivedUnit is the unit of the coin if the transaction has a received
+	// amount.
+	HistoryExportColumnReceivedUnit HistoryExportColumn = "receivedUnit"
+	// HistoryExportColumnFee is the fee paid by the wallet, or empty.
+	HistoryExportColumnFee HistoryExportColumn = "fee"
+	// HistoryExportColumnFeeUnit is the unit of the coin if the transaction has a fee.
+	HistoryExportColumnFeeUnit HistoryExportColumn = "feeUnit"
+	// HistoryExportColumnAddresses are the addresses sent to or received on, separated by spaces.
+	HistoryExportColumnAddresses HistoryExportColumn = "addresses"
+	// HistoryExportColumnTxID is the transaction ID.
+	HistoryExportColumnTxID HistoryExportColumn = "txID"
+	// HistoryExportColumnNote is the user note of the transaction.
+	HistoryExportColumnNote HistoryExportColumn = "note"
+	// HistoryExportColumnAddressNotes are the user notes of the addresses, separated by "; ".
+	HistoryExportColumnAddressNotes HistoryExportColumn = "addressNotes"
+	// HistoryExportColumnStatus is the confirmation status of the transaction.
+	HistoryExportColumnStatus HistoryExportColumn = "status"
+	// HistoryExportColumnAccount is the name of the account.
+	HistoryExportColumnAccount HistoryExportColumn = "account"
+	// HistoryExportColumnEmpty is an empty column required by the importing software.
+	HistoryExportColumnEmpty HistoryExportColumn = ""
+)
+
+// HistoryExportField is a column of the exported file.
+type HistoryExportField struct {
+	Header string              `json:"header"`
+	Column HistoryExportColumn `json:"column"`
+}
+
+// HistoryExportProfile describes the format of an exported transaction history, so that the file
+// can be imported into a spreadsheet application or tax tool.
+type HistoryExportProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Delimiter separates the fields.
+	Delimiter rune `json:"-"`
+	// DecimalSeparator replaces the decimal point in amounts.
+	DecimalSeparator string `json:"decimalSeparator"`
+	// DateFormat is the time layout, see time.Format().
+	DateFormat string `json:"dateFormat"`
+	// UTC formats the times in UTC instead of the local time zone.
+	UTC bool `json:"utc"`
+	// ConfirmedOnly skips the unconfirmed transactions, which do not have a time yet.
+	ConfirmedOnly bool                           `json:"confirmedOnly"`
+	TypeLabels    map[transactions.TxType]string `json:"-"`
+	Fields        []*HistoryExportField          `json:"fields"`
+}
+
+var (
+	historyExportDefaultTypeLabels = map[transactions.TxType]string{
+		transactions.TxTypeReceive:  "receive",
+		transactions.TxTypeSend:     "send",
+		transactions.TxTypeSendSelf: "send_to_self",
+	}
+	historyExportDefaultFields = []*HistoryExportField{
+		{"Time", HistoryExportColumnTime},
+		{"Type", HistoryExportColumnType},
+		{"Amount", HistoryExportColumnAmount},
+		{"Unit", HistoryExportColumnUnit},
+		{"Fee", HistoryExportColumnFee},
+		{"Fee Unit", HistoryExportColumnFeeUnit},
+		{"Address", HistoryExportColumnAddresses},
+		{"Transaction ID", HistoryExportColumnTxID},
+		{"Note", HistoryExportColumnNote},
+		{"Address Note", HistoryExportColumnAddressNotes},
+		{"Status", HistoryExportColumnStatus},
+	}
+)
+
+// HistoryExportProfiles are the supported export profiles. The first one is the default.
+var HistoryExportProfiles = []*HistoryExportProfile{
+	{
+		Name:             "default",
+		Description:      "Comma separated, decimal point, ISO 8601 times",
+		Delimiter:        ',',
+		DecimalSeparator: ".",
+		DateFormat:       "2006-01-02T15:04:05Z07:00",
+		TypeLabels:       historyExportDefaultTypeLabels,
+		Fields:           historyExportDefaultFields,
+	},
+	{
+		// Spreadsheet applications in locales using a decimal comma, e.g. German, expect semicolons.
+		Name:             "decimal-comma",
+		Description:      "Semicolon separated, decimal comma, day.month.year times",
+		Delimiter:        ';',
+		DecimalSeparator: ",",
+		DateFormat:       "02.01.2006 15:04:05",
+		TypeLabels:       historyExportDefaultTypeLabels,
+		Fields:           historyExportDefaultFields,
+	},
+	{
+		// https://help.koinly.io/en/articles/3662999-how-to-create-a-custom-csv-file-with-your-data
+		Name:             "koinly",
+		Description:      "Koinly universal format",
+		Delimiter:        ',',
+		DecimalSeparator: ".",
+		DateFormat:       "2006-01-02 15:04:05 UTC",
+		UTC:              true,
+		ConfirmedOnly:    true,
+		TypeLabels:       historyExportDefaultTypeLabels,
+		Fields: []*HistoryExportField{
+			{"Date", HistoryExportColumnTime},
+			{"Sent Amount", HistoryExportColumnSentAmount},
+			{"Sent Currency", HistoryExportColumnSentUnit},
+			{"Received Amount", HistoryExportColumnReceivedAmount},
+			{"Received Currency", HistoryExportColumnReceivedUnit},
+			{"Fee Amount", HistoryExportColumnFee},
+			{"Fee Currency", HistoryExportColumnFeeUnit},
+			{"Net Worth Amount", HistoryExportColumnEmpty},
+			{"Net Worth Currency", HistoryExportColumnEmpty},
+			{"Label", HistoryExportColumnEmpty},
+			{"Description", HistoryExportColumnNote},
+			{"TxHash", HistoryExportColumnTxID},
+		},
+	},
+	{
+		// https://cointracking.info/import/import_csv/
+		Name:             "cointracking",
+		Description:      "CoinTracking CSV import",
+		Delimiter:        ',',
+		DecimalSeparator: ".",
+		DateFormat:       "02.01.2006 15:04:05",
+		UTC:              true,
+		ConfirmedOnly:    true,
+		TypeLabels: map[transactions.TxType]string{
+			transactions.TxTypeReceive:  "Deposit",
+			transactions.TxTypeSend:     "Withdrawal",
+			transactions.TxTypeSendSelf: "Other Fee",
+		},
+		Fields: []*HistoryExportField{
+			{"Type", HistoryExportColumnType},
+			{"Buy Amount", HistoryExportColumnReceivedAmount},
+			{"Buy Currency", HistoryExportColumnReceivedUnit},
+			{"Sell Amount", HistoryExportColumnSentAmount},
+			{"Sell Currency", HistoryExportColumnSentUnit},
+			{"Fee", HistoryExportColumnFee},
+			{"Fee Currency", HistoryExportColumnFeeUnit},
+			{"Exchange", HistoryExportColumnAccount},
+			{"Trade-Group", HistoryExportColumnEmpty},
+			{"Comment", HistoryExportColumnNote},
+			{"Date", HistoryExportColumnTime},
+			{"Tx-ID", HistoryExportColumnTxID},
+		},
+	},
+}
+
+// LookupHistoryExportProfile returns the export profile with the given name. The default profile
+// is returned for an empty name.
+func LookupHistoryExportProfile(name string) (*HistoryExportProfile, error) {
+	if name == "" {
+		return HistoryExportProfiles[0], nil
+	}
+	for _, profile := range HistoryExportProfiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return nil, errp.Newf("unknown export profile %s", name)
+}
+
+// formatAmount formats the amount in the unit of the coin with the decimal separator of the
+// profile.
+func (profile *HistoryExportProfile) formatAmount(amount btcutil.Amount) string {
+	formatted := strconv.FormatFloat(amount.ToBTC(), 'f', 8, 64)
+	return strings.Replace(formatted, ".", profile.DecimalSeparator, 1)
+}
+
+// value returns the value of the column for the transaction of the account with the given name and
+// coin unit.
+func (profile *HistoryExportProfile) value(
+	txInfo *transactions.TxInfo, column HistoryExportColumn, unit string, accountName string) string {
+	sent := txInfo.Type == transactions.TxTypeSend
+	received := txInfo.Type == transactions.TxTypeReceive
+	switch column {
+	case HistoryExportColumnTime:
+		if txInfo.Timestamp == nil {
+			return ""
+		}
+		timestamp := txInfo.Timestamp.Local()
+		if profile.UTC {
+			timestamp = timestamp.UTC()
+		}
+		return timestamp.Format(profile.DateFormat)
+	case HistoryExportColumnType:
+		return profile.TypeLabels[txInfo.Type]
+	case HistoryExportColumnAmount:
+		if sent {
+			return profile.formatAmount(-txInfo.Amount)
+		}
+		return profile.formatAmount(txInfo.Amount)
+	case HistoryExportColumnSentAmount:
+		if sent {
+			return profile.formatAmount(txInfo.Amount)
+		}
+	case HistoryExportColumnReceivedAmount:
+		if received {
+			return profile.formatAmount(txInfo.Amount)
+		}
+	case HistoryExportColumnUnit:
+		return unit
+	case HistoryExportColumnSentUnit:
+		if sent {
+			return unit
+		}
+	case HistoryExportColumnReceivedUnit:
+		if received {
+			return unit
+		}
+	case HistoryExportColumnFee:
+		if txInfo.Fee != nil {
+			return profile.formatAmount(*txInfo.Fee)
+		}
+	case HistoryExportColumnFeeUnit:
+		if txInfo.Fee != nil {
+			return unit
+		}
+	case HistoryExportColumnAddresses:
+		return strings.Join(txInfo.Addresses, " ")
+	case HistoryExportColumnTxID:
+		return txInfo.Tx.TxHash().String()
+	case HistoryExportColumnNote:
+		return txInfo.Note
+	case HistoryExportColumnAddressNotes:
+		notes := []string{}
+		for _, address := range txInfo.Addresses {
+			if note, ok := txInfo.AddressNotes[address]; ok {
+				notes = append(notes, note)
+			}
+		}
+		return strings.Join(notes, "; ")
+	case HistoryExportColumnStatus:
+		return string(txInfo.Status())
+	case HistoryExportColumnAccount:
+		return accountName
+	}
+	return ""
+}
+
+// ExportHistory writes the transactions of the account as CSV in the format of the given profile.
+// A send-to-self transaction is exported with its fee only in profiles with separate sent and
+// received amounts.
+func (account *Account) ExportHistory(writer io.Writer, profile *HistoryExportProfile) error {
+	return writeHistory(writer, profile, account.Transactions(), account.coin.Unit(), account.Name())
+}
+
+func writeHistory(
+	writer io.Writer,
+	profile *HistoryExportProfile,
+	txs []*transactions.TxInfo,
+	unit string,
+	accountName string,
+) error {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Comma = profile.Delimiter
+	record := make([]string, len(profile.Fields))
+	for index, field := range profile.Fields {
+		record[index] = field.Header
+	}
+	if err := csvWriter.Write(record); err != nil {
+		return errp.WithStack(err)
+	}
+	for _, txInfo := range txs {
+		if profile.ConfirmedOnly && txInfo.Timestamp == nil {
+			continue
+		}
+		for index, field := range profile.Fields {
+			record[index] = profile.value(txInfo, field.Column, unit, accountName)
+		}
+		if err := csvWriter.Write(record); err != nil {
+			return errp.WithStack(err)
+		}
+	}
+	csvWriter.Flush()
+	return errp.WithStack(csvWriter.Error())
+}
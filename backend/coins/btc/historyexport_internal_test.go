@@ -0,0 +1,95 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+)
+
+func historyExportTestTxs() []*transactions.TxInfo {
+	timestamp := time.Date(2018, 3, 14, 9, 26, 53, 0, time.UTC)
+	fee := btcutil.Amount(1500)
+	return []*transactions.TxInfo{
+		{
+			Tx:               wire.NewMsgTx(1),
+			Height:           100,
+			NumConfirmations: 10,
+			Type:             transactions.TxTypeReceive,
+			Amount:           btcutil.Amount(123456789),
+			Timestamp:        &timestamp,
+			Addresses:        []string{"addr1"},
+			Note:             "salary, March",
+			AddressNotes:     map[string]string{"addr1": "employer"},
+		},
+		{
+			Tx:        wire.NewMsgTx(2),
+			Type:      transactions.TxTypeSend,
+			Amount:    btcutil.Amount(50000),
+			Fee:       &fee,
+			Addresses: []string{"addr2", "addr3"},
+		},
+	}
+}
+
+func exportHistory(t *testing.T, profileName string) string {
+	profile, err := LookupHistoryExportProfile(profileName)
+	require.NoError(t, err)
+	buffer := new(bytes.Buffer)
+	require.NoError(t, writeHistory(buffer, profile, historyExportTestTxs(), "BTC", "My account"))
+	return buffer.String()
+}
+
+func TestExportHistory(t *testing.T) {
+	receiveID := wire.NewMsgTx(1).TxHash().String()
+	sendID := wire.NewMsgTx(2).TxHash().String()
+	timestamp := time.Date(2018, 3, 14, 9, 26, 53, 0, time.UTC).Local()
+
+	require.Equal(t,
+		"Time,Type,Amount,Unit,Fee,Fee Unit,Address,Transaction ID,Note,Address Note,Status\n"+
+			timestamp.Format(time.RFC3339)+",receive,1.23456789,BTC,,,addr1,"+receiveID+
+			",\"salary, March\",employer,final\n"+
+			",send,-0.00050000,BTC,0.00001500,BTC,addr2 addr3,"+sendID+",,,pending\n",
+		exportHistory(t, ""))
+
+	require.Equal(t,
+		"Time;Type;Amount;Unit;Fee;Fee Unit;Address;Transaction ID;Note;Address Note;Status\n"+
+			timestamp.Format("02.01.2006 15:04:05")+";receive;1,23456789;BTC;;;addr1;"+receiveID+
+			";salary, March;employer;final\n"+
+			";send;-0,00050000;BTC;0,00001500;BTC;addr2 addr3;"+sendID+";;;pending\n",
+		exportHistory(t, "decimal-comma"))
+
+	// Unconfirmed transactions are skipped by the tax tool profiles.
+	require.Equal(t,
+		"Date,Sent Amount,Sent Currency,Received Amount,Received Currency,Fee Amount,Fee Currency,"+
+			"Net Worth Amount,Net Worth Currency,Label,Description,TxHash\n"+
+			"2018-03-14 09:26:53 UTC,,,1.23456789,BTC,,,,,,\"salary, March\","+receiveID+"\n",
+		exportHistory(t, "koinly"))
+	require.Equal(t,
+		"Type,Buy Amount,Buy Currency,Sell Amount,Sell Currency,Fee,Fee Currency,Exchange,"+
+			"Trade-Group,Comment,Date,Tx-ID\n"+
+			"Deposit,1.23456789,BTC,,,,,My account,,\"salary, March\",14.03.2018 09:26:53,"+receiveID+"\n",
+		exportHistory(t, "cointracking"))
+
+	_, err := LookupHistoryExportProfile("unknown")
+	require.Error(t, err)
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/invoices"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Invoices returns the receive requests bound to an expected amount, the newest first.
+func (account *Account) Invoices() []*invoices.Invoice {
+	return account.invoices.Invoices()
+}
+
+// AddInvoice creates a receive request for the given amount, expiring after the given duration.
+// The invoice gets its own unused receive address, which is monitored until the invoice is
+// removed.
+func (account *Account) AddInvoice(
+	amount btcutil.Amount, description string, expiresIn time.Duration) (*invoices.Invoice, error) {
+	if amount <= 0 {
+		return nil, errp.WithStack(TxValidationError("invalid amount"))
+	}
+	if expiresIn <= 0 {
+		return nil, errp.WithStack(TxValidationError("invalid expiry"))
+	}
+	unused := account.GetUnusedReceiveAddresses()
+	// Keep one unbound address for regular payments.
+	if len(unused) < 2 {
+		return nil, errp.New("Too many unused receive addresses are bound to invoices")
+	}
+	address := unused[0]
+	now := time.Now()
+	invoice, err := account.invoices.Add(address.EncodeAddress(), address.PubkeyScriptHashHex(),
+		amount, description, now, now.Add(expiresIn))
+	if err != nil {
+		return nil, err
+	}
+	go account.checkInvoices()
+	return invoice, nil
+}
+
+// RemoveInvoice stops monitoring the invoice with the given ID.
+func (account *Account) RemoveInvoice(id string) error {
+	return account.invoices.Remove(id)
+}
+
+// checkInvoices fires EventInvoiceStateChanged if an invoice was paid, underpaid, overpaid or
+// expired. Expiry is detected when the account is synced or a new block arrives.
+func (account *Account) checkInvoices() {
+	if account.invoices == nil || account.transactions == nil {
+		return
+	}
+	received := map[blockchain.ScriptHashHex]btcutil.Amount{}
+	for _, txInfo := range account.Transactions() {
+		for _, txOut := range txInfo.Tx.TxOut {
+			scriptHashHex := (&transactions.SpendableOutput{TxOut: txOut}).ScriptHashHex()
+			received[scriptHashHex] += btcutil.Amount(txOut.Value)
+		}
+	}
+	changed, err := account.invoices.Check(time.Now(),
+		func(scriptHashHex blockchain.ScriptHashHex) btcutil.Amount {
+			return received[scriptHashHex]
+		})
+	if err != nil {
+		account.log.WithError(err).Error("Could not persist the invoices")
+	}
+	if len(changed) != 0 {
+		account.onEvent(EventInvoiceStateChanged)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package invoices monitors receive requests bound to an expected amount. Each invoice has its
+// own receive address, so all payments to the address are payments of the invoice, and the
+// invoice is paid in full, underpaid or overpaid depending on the total received.
+package invoices
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// State is the payment state of an invoice.
+type State string
+
+const (
+	// StatePending means that nothing was received yet and the invoice did not expire.
+	StatePending State = "pending"
+	// StatePaidInFull means that exactly the expected amount was received.
+	StatePaidInFull State = "paidInFull"
+	// StateUnderpaid means that less than the expected amount was received.
+	StateUnderpaid State = "underpaid"
+	// StateOverpaid means that more than the expected amount was received.
+	StateOverpaid State = "overpaid"
+	// StateExpired means that nothing was received before the invoice expired.
+	StateExpired State = "expired"
+)
+
+// Invoice is a receive request for an expected amount.
+type Invoice struct {
+	ID          string         `json:"id"`
+	Address     string         `json:"address"`
+	Amount      btcutil.Amount `json:"amount"`
+	Description string         `json:"description"`
+	Created     time.Time      `json:"created"`
+	Expires     time.Time      `json:"expires"`
+	// Received is the total received on the address, including unconfirmed payments.
+	Received btcutil.Amount `json:"received"`
+	State    State          `json:"state"`
+
+	ScriptHashHex blockchain.ScriptHashHex `json:"scriptHashHex"`
+}
+
+// state returns the state of the invoice given the amount received.
+func (invoice *Invoice) state(received btcutil.Amount, now time.Time) State {
+	switch {
+	case received == 0 && now.After(invoice.Expires):
+		return StateExpired
+	case received == 0:
+		return StatePending
+	case received < invoice.Amount:
+		return StateUnderpaid
+	case received > invoice.Amount:
+		return StateOverpaid
+	default:
+		return StatePaidInFull
+	}
+}
+
+// Store holds the invoices of an account and persists them in a file.
+type Store struct {
+	lock     locker.Locker
+	filename string
+	invoices map[string]*Invoice
+	log      *logrus.Entry
+}
+
+// NewStore creates a new Store, stored in the given file.
+func NewStore(filename string, log *logrus.Entry) (*Store, error) {
+	store := &Store{
+		filename: filename,
+		invoices: map[string]*Invoice{},
+		log:      log.WithField("group", "invoices"),
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	invoices := []*Invoice{}
+	if err := json.Unmarshal(jsonBytes, &invoices); err != nil {
+		return nil, errp.WithMessage(err, "Could not read the invoices file")
+	}
+	for _, invoice := range invoices {
+		store.invoices[invoice.ID] = invoice
+	}
+	return store, nil
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.Marshal(store.invoicesList())
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// invoicesList returns the invoices, the newest first.
+func (store *Store) invoicesList() []*Invoice {
+	invoices := []*Invoice{}
+	for _, invoice := range store.invoices {
+		invoices = append(invoices, invoice)
+	}
+	sort.Slice(invoices, func(i, j int) bool {
+		return invoices[i].Created.After(invoices[j].Created)
+	})
+	return invoices
+}
+
+// Add creates an invoice for the given amount, to be paid to the given address before the given
+// expiry. The address must not be bound to another invoice, see Bound().
+func (store *Store) Add(
+	address string,
+	scriptHashHex blockchain.ScriptHashHex,
+	amount btcutil.Amount,
+	description string,
+	now time.Time,
+	expires time.Time,
+) (*Invoice, error) {
+	if amount <= 0 {
+		return nil, errp.New("The amount must be positive")
+	}
+	if !expires.After(now) {
+		return nil, errp.New("The invoice must expire in the future")
+	}
+	defer store.lock.Lock()()
+	for _, invoice := range store.invoices {
+		if invoice.ScriptHashHex == scriptHashHex {
+			return nil, errp.New("The address is bound to another invoice")
+		}
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	invoice := &Invoice{
+		ID:            hex.EncodeToString(id[:]),
+		Address:       address,
+		ScriptHashHex: scriptHashHex,
+		Amount:        amount,
+		Description:   description,
+		Created:       now,
+		Expires:       expires,
+		State:         StatePending,
+	}
+	store.invoices[invoice.ID] = invoice
+	store.log.WithField("id", invoice.ID).Info("Added invoice")
+	return invoice, store.save()
+}
+
+// Remove removes the invoice with the given ID.
+func (store *Store) Remove(id string) error {
+	defer store.lock.Lock()()
+	if _, ok := store.invoices[id]; !ok {
+		return errp.New("The invoice does not exist")
+	}
+	delete(store.invoices, id)
+	return store.save()
+}
+
+// Invoices returns all invoices, the newest first.
+func (store *Store) Invoices() []*Invoice {
+	defer store.lock.RLock()()
+	return store.invoicesList()
+}
+
+// Bound returns the script hashes of the addresses bound to invoices. Addresses stay bound after
+// the invoice was paid or expired, as late payments would otherwise be mistaken for payments of a
+// new invoice, until the invoice is removed.
+func (store *Store) Bound() map[blockchain.ScriptHashHex]struct{} {
+	defer store.lock.RLock()()
+	bound := map[blockchain.ScriptHashHex]struct{}{}
+	for _, invoice := range store.invoices {
+		bound[invoice.ScriptHashHex] = struct{}{}
+	}
+	return bound
+}
+
+// Check updates the received amounts and states of all invoices. received returns the total
+// received on the address with the given script hash. It returns the invoices whose state changed
+// since the previous check.
+func (store *Store) Check(
+	now time.Time,
+	received func(blockchain.ScriptHashHex) btcutil.Amount,
+) ([]*Invoice, error) {
+	defer store.lock.Lock()()
+	changed := []*Invoice{}
+	save := false
+	for _, invoice := range store.invoices {
+		invoiceReceived := received(invoice.ScriptHashHex)
+		state := invoice.state(invoiceReceived, now)
+		if invoiceReceived != invoice.Received {
+			invoice.Received = invoiceReceived
+			save = true
+		}
+		if state == invoice.State {
+			continue
+		}
+		store.log.WithFields(logrus.Fields{
+			"id": invoice.ID, "state": state, "previous-state": invoice.State}).Info("Invoice changed")
+		invoice.State = state
+		changed = append(changed, invoice)
+		save = true
+	}
+	if !save {
+		return changed, nil
+	}
+	return changed, store.save()
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoices_test
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/invoices"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	filename := path.Join(test.TstTempDir("invoices"), "invoices.json")
+	log := logging.Get().WithGroup("invoices_test")
+	store, err := invoices.NewStore(filename, log)
+	require.NoError(t, err)
+	require.Empty(t, store.Invoices())
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	_, err = store.Add("address0", "hash0", 0, "", now, expires)
+	require.Error(t, err)
+	_, err = store.Add("address0", "hash0", 1000, "", now, now)
+	require.Error(t, err)
+
+	paid, err := store.Add("address1", "hash1", 1000, "paid", now, expires)
+	require.NoError(t, err)
+	require.Equal(t, invoices.StatePending, paid.State)
+	underpaid, err := store.Add("address2", "hash2", 1000, "underpaid", now.Add(time.Second), expires)
+	require.NoError(t, err)
+	overpaid, err := store.Add("address3", "hash3", 1000, "overpaid", now.Add(2*time.Second), expires)
+	require.NoError(t, err)
+	expired, err := store.Add("address4", "hash4", 1000, "expired", now.Add(3*time.Second), expires)
+	require.NoError(t, err)
+	// One invoice per address.
+	_, err = store.Add("address1", "hash1", 1000, "", now, expires)
+	require.Error(t, err)
+	require.Len(t, store.Bound(), 4)
+	require.Equal(t, []*invoices.Invoice{expired, overpaid, underpaid, paid}, store.Invoices())
+
+	received := map[blockchain.ScriptHashHex]btcutil.Amount{}
+	receivedFunc := func(scriptHashHex blockchain.ScriptHashHex) btcutil.Amount {
+		return received[scriptHashHex]
+	}
+	changed, err := store.Check(now, receivedFunc)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+
+	received["hash1"] = 1000
+	received["hash2"] = 400
+	received["hash3"] = 1500
+	changed, err = store.Check(now, receivedFunc)
+	require.NoError(t, err)
+	require.Len(t, changed, 3)
+	require.Equal(t, invoices.StatePaidInFull, paid.State)
+	require.Equal(t, invoices.StateUnderpaid, underpaid.State)
+	require.Equal(t, btcutil.Amount(400), underpaid.Received)
+	require.Equal(t, invoices.StateOverpaid, overpaid.State)
+	require.Equal(t, invoices.StatePending, expired.State)
+
+	// A second payment completes the underpaid invoice.
+	received["hash2"] = 1000
+	changed, err = store.Check(expires.Add(time.Second), receivedFunc)
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	require.Contains(t, changed, underpaid)
+	require.Contains(t, changed, expired)
+	require.Equal(t, invoices.StatePaidInFull, underpaid.State)
+	require.Equal(t, invoices.StateExpired, expired.State)
+
+	// Reload from the file.
+	store, err = invoices.NewStore(filename, log)
+	require.NoError(t, err)
+	reloaded := store.Invoices()
+	require.Len(t, reloaded, 4)
+	require.Equal(t, expired.ID, reloaded[0].ID)
+	require.Equal(t, invoices.StateExpired, reloaded[0].State)
+	require.Equal(t, btcutil.Amount(1500), reloaded[1].Received)
+
+	require.NoError(t, store.Remove(paid.ID))
+	require.Error(t, store.Remove(paid.ID))
+	require.Len(t, store.Invoices(), 3)
+	require.Len(t, store.Bound(), 3)
+}
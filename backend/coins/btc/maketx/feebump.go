@@ -0,0 +1,119 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/txsort"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// RBFSequence is the input sequence number used to signal opt-in replace-by-fee (BIP125).
+const RBFSequence = wire.MaxTxInSequenceNum - 2
+
+// incrementalRelayFeePerKb is the minimum fee rate by which a replacement has to increase the
+// absolute fee of the replaced tx, see BIP125 rule 4.
+const incrementalRelayFeePerKb = btcutil.Amount(1000)
+
+// ErrFeeBumpNotPossible is returned when a tx can't be replaced by one paying a higher fee, for
+// example because it has no change output to deduct the additional fee from.
+var ErrFeeBumpNotPossible = errors.New("fee bump not possible")
+
+// SignalsRBF returns true if the tx signals opt-in replace-by-fee (BIP125).
+func SignalsRBF(tx *wire.MsgTx) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRBF makes all inputs of the tx signal opt-in replace-by-fee (BIP125).
+func SetRBF(tx *wire.MsgTx) {
+	for _, txIn := range tx.TxIn {
+		txIn.Sequence = RBFSequence
+	}
+}
+
+// NewTxBumpFee creates an unsigned replacement of the given tx which pays a fee according to
+// feePerKb. The inputs and the recipient outputs are kept, the additional fee is deducted from the
+// change output. vsize is the virtual size of the signed tx, previousFee the fee it pays.
+func NewTxBumpFee(
+	coin coin.Coin,
+	inputConfiguration *signing.Configuration,
+	tx *wire.MsgTx,
+	vsize int64,
+	previousFee btcutil.Amount,
+	feePerKb btcutil.Amount,
+	changeAddress *addresses.AccountAddress,
+	log *logrus.Entry,
+) (*TxProposal, error) {
+	if changeAddress == nil {
+		return nil, errp.WithStack(ErrFeeBumpNotPossible)
+	}
+	fee := feeForSerializeSize(feePerKb, int(vsize), log)
+	minFee := previousFee + feeForSerializeSize(incrementalRelayFeePerKb, int(vsize), log)
+	if fee < minFee {
+		fee = minFee
+	}
+	additionalFee := fee - previousFee
+
+	unsignedTransaction := tx.Copy()
+	for _, txIn := range unsignedTransaction.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+	}
+	SetRBF(unsignedTransaction)
+
+	changePKScript := changeAddress.PubkeyScript()
+	amount := btcutil.Amount(0)
+	foundChange := false
+	for _, txOut := range unsignedTransaction.TxOut {
+		if foundChange || !bytes.Equal(txOut.PkScript, changePKScript) {
+			amount += btcutil.Amount(txOut.Value)
+			continue
+		}
+		foundChange = true
+		changeAmount := btcutil.Amount(txOut.Value) - additionalFee
+		if changeAmount <= 0 || isDustAmount(
+			changeAmount, len(changePKScript), changeAddress.Configuration, feePerKb) {
+			return nil, errp.WithStack(ErrFeeBumpNotPossible)
+		}
+		txOut.Value = int64(changeAmount)
+	}
+	if !foundChange {
+		return nil, errp.WithStack(ErrFeeBumpNotPossible)
+	}
+	txsort.InPlaceSort(unsignedTransaction)
+	log.WithFields(logrus.Fields{"previous-fee": previousFee, "fee": fee}).Debug("Preparing fee bump")
+	return &TxProposal{
+		Coin:                 coin,
+		AccountConfiguration: inputConfiguration,
+		Amount:               amount,
+		Fee:                  fee,
+		VSize:                vsize,
+		Transaction:          unsignedTransaction,
+		ChangeAddress:        changeAddress,
+	}, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ErrFeeCapExceeded is returned when the fee of a tx is above the configured cap. The user has to
+// explicitly confirm such a fee.
+var ErrFeeCapExceeded = errors.New("fee cap exceeded")
+
+// FeeCap limits the fee a tx may pay without explicit confirmation by the user.
+type FeeCap struct {
+	// MaxFee is the maximum absolute fee. 0 means no limit.
+	MaxFee btcutil.Amount
+	// MaxFeePercent is the maximum fee in percent of the amount sent. 0 means no limit.
+	MaxFeePercent int
+}
+
+// Check returns ErrFeeCapExceeded if the fee of the tx proposal exceeds the cap.
+func (feeCap FeeCap) Check(txProposal *TxProposal) error {
+	if feeCap.MaxFee > 0 && txProposal.Fee > feeCap.MaxFee {
+		return errp.WithStack(ErrFeeCapExceeded)
+	}
+	if feeCap.MaxFeePercent > 0 &&
+		txProposal.Fee*100 > txProposal.Amount*btcutil.Amount(feeCap.MaxFeePercent) {
+		return errp.WithStack(ErrFeeCapExceeded)
+	}
+	return nil
+}
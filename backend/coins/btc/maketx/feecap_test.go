@@ -0,0 +1,37 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeCap(t *testing.T) {
+	proposal := func(amount, fee btcutil.Amount) *maketx.TxProposal {
+		return &maketx.TxProposal{Amount: amount, Fee: fee}
+	}
+	feeCap := maketx.FeeCap{MaxFee: 10000, MaxFeePercent: 10}
+	require.NoError(t, feeCap.Check(proposal(100000, 10000)))
+	require.Equal(t, maketx.ErrFeeCapExceeded, errp.Cause(feeCap.Check(proposal(1000000, 10001))))
+	require.Equal(t, maketx.ErrFeeCapExceeded, errp.Cause(feeCap.Check(proposal(99999, 10000))))
+
+	// No limits.
+	require.NoError(t, maketx.FeeCap{}.Check(proposal(1, 1000000)))
+}
@@ -17,6 +17,7 @@ package maketx
 import (
 	"errors"
 	"sort"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
@@ -41,10 +42,36 @@ type TxProposal struct {
 	// Amount is the amount that is sent out. The fee is not included and is deducted on top.
 	Amount btcutil.Amount
 	// Fee is the mining fee used.
-	Fee         btcutil.Amount
+	Fee btcutil.Amount
+	// VSize is the virtual size of the signed tx the fee was computed for. It is the worst case
+	// estimate of estimateTxSize(), except for fee bumps, where the size of the signed tx is known.
+	VSize       int64
 	Transaction *wire.MsgTx
 	// ChangeAddress is the address of the wallet to which the change of the transaction is sent.
 	ChangeAddress *addresses.AccountAddress
+	// Memo is an optional short note by the sender, shown during confirmation by keystores which
+	// support it.
+	Memo string
+	// Fiat is the optional fiat value of the amount, shown during confirmation by keystores which
+	// support it.
+	Fiat *FiatConversion
+	// AccountName is the display name of the sending account, e.g. "💰 Savings", shown during
+	// confirmation by keystores which support it instead of the keypath.
+	AccountName string
+}
+
+// FiatConversion is the value of an amount in a fiat currency.
+type FiatConversion struct {
+	// Unit is the fiat currency, e.g. "USD".
+	Unit string
+	// Amount is the formatted fiat amount.
+	Amount string
+	// Rate is the exchange rate used for the conversion.
+	Rate float64
+	// RateTimestamp is the time the exchange rate was fetched.
+	RateTimestamp time.Time
+	// RateSource is the name of the service the exchange rate was fetched from.
+	RateSource string
 }
 
 // Total is amount+fee.
@@ -52,6 +79,15 @@ func (txProposal *TxProposal) Total() btcutil.Amount {
 	return txProposal.Amount + txProposal.Fee
 }
 
+// FeeRate returns the fee rate in sat/vB. It can be slightly lower than the fee target, as the size
+// of the tx is estimated for the worst case.
+func (txProposal *TxProposal) FeeRate() float64 {
+	if txProposal.VSize == 0 {
+		return 0
+	}
+	return float64(txProposal.Fee) / float64(txProposal.VSize)
+}
+
 type byValue struct {
 	outPoints []wire.OutPoint
 	outputs   map[wire.OutPoint]*wire.TxOut
@@ -131,6 +167,7 @@ func NewTxSpendAll(
 		AccountConfiguration: inputConfiguration,
 		Amount:               btcutil.Amount(output.Value),
 		Fee:                  maxRequiredFee,
+		VSize:                int64(txSize),
 		Transaction:          unsignedTransaction,
 	}, nil
 }
@@ -203,6 +240,7 @@ func NewTx(
 			AccountConfiguration: inputConfiguration,
 			Amount:               targetAmount,
 			Fee:                  finalFee,
+			VSize:                int64(txSize),
 			Transaction:          unsignedTransaction,
 			ChangeAddress:        changeAddress,
 		}, nil
@@ -177,12 +177,13 @@ func (s *newTxSuite) check(
 	txFee := btcutil.Amount(inputSum-output.Value) - expectedChange
 	// At the moment, the fee is based on the assumption of the tx having two outputs always, even
 	// if the change output is not there.
-	expectedFee := maketx.TstFeeForSerializeSize(
-		feePerKb,
-		maketx.TstEstimateTxSize(len(tx.TxIn), s.inputConfiguration, len(output.PkScript), len(s.changeAddress.PubkeyScript())),
-		s.log) + expectedDustDonation
+	expectedSize := maketx.TstEstimateTxSize(
+		len(tx.TxIn), s.inputConfiguration, len(output.PkScript), len(s.changeAddress.PubkeyScript()))
+	expectedFee := maketx.TstFeeForSerializeSize(feePerKb, expectedSize, s.log) + expectedDustDonation
 	require.Equal(s.T(), expectedFee, txFee)
 	require.Equal(s.T(), expectedFee, txProposal.Fee)
+	require.Equal(s.T(), int64(expectedSize), txProposal.VSize)
+	require.Equal(s.T(), float64(expectedFee)/float64(expectedSize), txProposal.FeeRate())
 	require.Equal(s.T(), expectedAmount, txProposal.Amount)
 
 	// Check the coin selection related results.
@@ -290,3 +291,81 @@ func (s *newTxSuite) TestNewTxCoinSelection() {
 	// coins: .5, .3, .1, .1, .9, .8, .6. select .5+.3+.1+.1 to get 1BTC, take .9 to cover the fees.
 	s.check(amount, feePerKb, s.buildUTXO(500*mBTC, 300*mBTC, 100*mBTC, 100*mBTC, 90*mBTC, 80*mBTC, 70*mBTC), s.change(90*mBTC-txSizeFiveInputs), noDust, s.selectCoins(0, 1, 2, 3, 4))
 }
+
+func (s *newTxSuite) TestNewTxBumpFee() {
+	feePerKb := btcutil.Amount(1000)
+	txProposal, err := s.newTx(btcutil.Amount(100000), feePerKb, s.buildUTXO(200000))
+	require.NoError(s.T(), err)
+	require.False(s.T(), maketx.SignalsRBF(txProposal.Transaction))
+
+	const vsize = txSizeOneInput
+	bumped, err := maketx.NewTxBumpFee(
+		tbtc,
+		s.inputConfiguration,
+		txProposal.Transaction,
+		vsize,
+		txProposal.Fee,
+		5*feePerKb,
+		txProposal.ChangeAddress,
+		s.log,
+	)
+	require.NoError(s.T(), err)
+	require.True(s.T(), maketx.SignalsRBF(bumped.Transaction))
+	require.Equal(s.T(), btcutil.Amount(100000), bumped.Amount)
+	require.Equal(s.T(), btcutil.Amount(5*vsize), bumped.Fee)
+	require.Equal(s.T(), int64(vsize), bumped.VSize)
+	require.Equal(s.T(), 5.0, bumped.FeeRate())
+	require.Len(s.T(), bumped.Transaction.TxIn, 1)
+	require.Equal(s.T(), txProposal.Transaction.TxIn[0].PreviousOutPoint,
+		bumped.Transaction.TxIn[0].PreviousOutPoint)
+	outputSum := int64(0)
+	for _, txOut := range bumped.Transaction.TxOut {
+		outputSum += txOut.Value
+	}
+	require.Equal(s.T(), int64(200000)-int64(bumped.Fee), outputSum)
+
+	// The replacement must increase the fee by at least the incremental relay fee, even if the
+	// fee rate did not rise.
+	bumped, err = maketx.NewTxBumpFee(
+		tbtc, s.inputConfiguration, txProposal.Transaction, vsize, txProposal.Fee, feePerKb,
+		txProposal.ChangeAddress, s.log)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), txProposal.Fee+vsize, bumped.Fee)
+
+	// Without change, the fee can't be bumped.
+	_, err = maketx.NewTxBumpFee(
+		tbtc, s.inputConfiguration, txProposal.Transaction, vsize, txProposal.Fee, feePerKb,
+		nil, s.log)
+	require.Equal(s.T(), maketx.ErrFeeBumpNotPossible, errp.Cause(err))
+
+	// Not enough change left to cover the additional fee.
+	_, err = maketx.NewTxBumpFee(
+		tbtc, s.inputConfiguration, txProposal.Transaction, vsize, txProposal.Fee, 1000*feePerKb,
+		txProposal.ChangeAddress, s.log)
+	require.Equal(s.T(), maketx.ErrFeeBumpNotPossible, errp.Cause(err))
+}
+
+func (s *newTxSuite) TestMaxSpendable() {
+	feePerKb := btcutil.Amount(1000)
+	inputFee := maketx.InputFee(s.inputConfiguration, feePerKb)
+	utxo := s.buildUTXO(1e8, 2e8, int64(inputFee))
+	maxSpendable := maketx.NewMaxSpendable(s.inputConfiguration, utxo, len(s.outputPkScript), feePerKb, s.log)
+	require.Equal(s.T(), 2, maxSpendable.Inputs)
+	require.Equal(s.T(), inputFee, maxSpendable.Uneconomic)
+	// The amount is the one of the tx spending the economic outputs.
+	delete(utxo, s.coin(2))
+	txProposal, err := maketx.NewTxSpendAll(
+		tbtc, s.inputConfiguration, utxo, s.outputPkScript, feePerKb, s.log)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), txProposal.Amount, maxSpendable.Amount)
+	require.Equal(s.T(), txProposal.Fee, maxSpendable.Fee)
+
+	// Outputs which don't cover the fee of the tx.
+	require.Equal(s.T(),
+		&maketx.MaxSpendable{Uneconomic: inputFee + 1},
+		maketx.NewMaxSpendable(
+			s.inputConfiguration, s.buildUTXO(int64(inputFee)+1), len(s.outputPkScript), feePerKb, s.log))
+	require.Equal(s.T(),
+		&maketx.MaxSpendable{},
+		maketx.NewMaxSpendable(s.inputConfiguration, s.buildUTXO(), len(s.outputPkScript), feePerKb, s.log))
+}
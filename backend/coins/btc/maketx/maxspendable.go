@@ -0,0 +1,76 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+)
+
+// MaxSpendable is the most that can be sent from a set of outputs in one tx at a fee rate, see
+// NewMaxSpendable().
+type MaxSpendable struct {
+	// Amount is the value of the single output of the tx spending the outputs, or 0 if they don't
+	// cover its fee.
+	Amount btcutil.Amount
+	// Fee is the fee of the tx, i.e. the cost of consolidating the outputs.
+	Fee btcutil.Amount
+	// Inputs is the number of outputs spent by the tx.
+	Inputs int
+	// Uneconomic is the value of the outputs which are left out because they cost more in fees to
+	// spend than they are worth.
+	Uneconomic btcutil.Amount
+}
+
+// NewMaxSpendable computes the amount of a tx spending all the given outputs which are worth more
+// than the fee to spend them to one output with a pkScript of the given size, like
+// NewTxSpendAll().
+func NewMaxSpendable(
+	inputConfiguration *signing.Configuration,
+	spendableOutputs map[wire.OutPoint]*wire.TxOut,
+	outputPkScriptSize int,
+	feePerKb btcutil.Amount,
+	log *logrus.Entry,
+) *MaxSpendable {
+	result := &MaxSpendable{}
+	inputFee := InputFee(inputConfiguration, feePerKb)
+	outputsSum := btcutil.Amount(0)
+	for _, output := range spendableOutputs {
+		value := btcutil.Amount(output.Value)
+		if value <= inputFee {
+			result.Uneconomic += value
+			continue
+		}
+		outputsSum += value
+		result.Inputs++
+	}
+	if result.Inputs == 0 {
+		return result
+	}
+	txSize := estimateTxSize(result.Inputs, inputConfiguration, outputPkScriptSize, 0)
+	fee := feeForSerializeSize(feePerKb, txSize, log)
+	amount := outputsSum - fee
+	if amount <= 0 || isDustAmount(amount, outputPkScriptSize, inputConfiguration, feePerKb) {
+		result.Uneconomic += outputsSum
+		result.Inputs = 0
+		return result
+	}
+	result.Amount = amount
+	result.Fee = fee
+	return result
+}
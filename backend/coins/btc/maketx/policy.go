@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// policyMaxFeePercent is the maximum fee in percent of the amount sent accepted by CheckPolicy.
+const policyMaxFeePercent = 100
+
+// PolicyViolationError is returned by CheckPolicy if a tx proposal breaks one of the rules.
+type PolicyViolationError string
+
+func (err PolicyViolationError) Error() string {
+	return "transaction rejected by policy: " + string(err)
+}
+
+// CheckPolicy re-checks a fully constructed tx proposal before it is signed, using rules which are
+// independent of how the tx was built. It is a second line of defense against bugs in the tx
+// construction. The tx must:
+//
+//   - spend only the given previous outputs, each at most once,
+//   - pay exactly the expected recipient outputs and at most one change output, which must pay to
+//     the change address of the proposal and be recognized as ours by isOwnChange,
+//   - match the amount and fee of the proposal,
+//   - not pay a fee higher than the amount sent, unless allowHighFee is true. It must be true if
+//     the user confirmed a fee above the fee cap, see FeeCap, as such a fee can exceed the amount.
+func CheckPolicy(
+	txProposal *TxProposal,
+	recipientOutputs []*wire.TxOut,
+	previousOutputs map[wire.OutPoint]*wire.TxOut,
+	isOwnChange func(pkScript []byte) bool,
+	allowHighFee bool,
+) error {
+	tx := txProposal.Transaction
+	inputSum := btcutil.Amount(0)
+	spent := map[wire.OutPoint]struct{}{}
+	for _, txIn := range tx.TxIn {
+		if _, ok := spent[txIn.PreviousOutPoint]; ok {
+			return errp.WithStack(PolicyViolationError("output spent twice"))
+		}
+		spent[txIn.PreviousOutPoint] = struct{}{}
+		previousOutput, ok := previousOutputs[txIn.PreviousOutPoint]
+		if !ok {
+			return errp.WithStack(PolicyViolationError("spends an unknown output"))
+		}
+		inputSum += btcutil.Amount(previousOutput.Value)
+	}
+
+	expected := append([]*wire.TxOut{}, recipientOutputs...)
+	outputSum := btcutil.Amount(0)
+	amount := btcutil.Amount(0)
+	changeOutputs := 0
+outputs:
+	for _, txOut := range tx.TxOut {
+		outputSum += btcutil.Amount(txOut.Value)
+		for index, expectedOutput := range expected {
+			if expectedOutput.Value == txOut.Value && bytes.Equal(expectedOutput.PkScript, txOut.PkScript) {
+				expected = append(expected[:index], expected[index+1:]...)
+				amount += btcutil.Amount(txOut.Value)
+				continue outputs
+			}
+		}
+		if txProposal.ChangeAddress == nil ||
+			!bytes.Equal(txOut.PkScript, txProposal.ChangeAddress.PubkeyScript()) ||
+			!isOwnChange(txOut.PkScript) {
+			return errp.WithStack(PolicyViolationError("unexpected output"))
+		}
+		changeOutputs++
+	}
+	if len(expected) != 0 {
+		return errp.WithStack(PolicyViolationError("missing recipient output"))
+	}
+	if changeOutputs > 1 {
+		return errp.WithStack(PolicyViolationError("more than one change output"))
+	}
+	if amount != txProposal.Amount {
+		return errp.WithStack(PolicyViolationError("amount does not match"))
+	}
+	fee := inputSum - outputSum
+	if fee != txProposal.Fee {
+		return errp.WithStack(PolicyViolationError("fee does not match"))
+	}
+	if !allowHighFee && fee*100 > amount*policyMaxFeePercent {
+		return errp.WithStack(PolicyViolationError("fee too high compared to the amount"))
+	}
+	return nil
+}
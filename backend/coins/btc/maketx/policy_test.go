@@ -0,0 +1,97 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx_test
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func (s *newTxSuite) isOwnChange(pkScript []byte) bool {
+	return bytes.Equal(pkScript, s.changeAddress.PubkeyScript())
+}
+
+func (s *newTxSuite) TestCheckPolicy() {
+	utxo := s.buildUTXO(1e8)
+	recipientOutputs := []*wire.TxOut{s.output(btcutil.Amount(5e7))}
+	newProposal := func() *maketx.TxProposal {
+		txProposal, err := s.newTx(btcutil.Amount(5e7), 1000, utxo)
+		s.Require().NoError(err)
+		return txProposal
+	}
+	requireViolation := func(txProposal *maketx.TxProposal, reason string) {
+		err := maketx.CheckPolicy(txProposal, recipientOutputs, utxo, s.isOwnChange, false)
+		s.Require().Equal(maketx.PolicyViolationError(reason), errp.Cause(err))
+	}
+
+	s.Require().NoError(maketx.CheckPolicy(newProposal(), recipientOutputs, utxo, s.isOwnChange, false))
+
+	// Change not recognized as ours.
+	err := maketx.CheckPolicy(
+		newProposal(), recipientOutputs, utxo, func([]byte) bool { return false }, false)
+	s.Require().Equal(maketx.PolicyViolationError("unexpected output"), errp.Cause(err))
+
+	// Additional output.
+	txProposal := newProposal()
+	txProposal.Transaction.AddTxOut(wire.NewTxOut(1000, s.someAddresses[1].PubkeyScript()))
+	requireViolation(txProposal, "unexpected output")
+
+	// Recipient changed.
+	txProposal = newProposal()
+	for _, txOut := range txProposal.Transaction.TxOut {
+		if bytes.Equal(txOut.PkScript, s.outputPkScript) {
+			txOut.PkScript = s.someAddresses[1].PubkeyScript()
+		}
+	}
+	requireViolation(txProposal, "unexpected output")
+
+	// Spending an output which is not ours.
+	txProposal = newProposal()
+	txProposal.Transaction.TxIn[0].PreviousOutPoint = s.coin(1)
+	requireViolation(txProposal, "spends an unknown output")
+
+	// Fee differs from the proposal.
+	txProposal = newProposal()
+	txProposal.Fee++
+	requireViolation(txProposal, "fee does not match")
+
+	// Fee higher than the amount.
+	txProposal, err = s.newTx(btcutil.Amount(1e7), 1000, utxo)
+	s.Require().NoError(err)
+	for _, txOut := range txProposal.Transaction.TxOut {
+		if !bytes.Equal(txOut.PkScript, s.outputPkScript) {
+			txProposal.Fee += btcutil.Amount(txOut.Value - 1000)
+			txOut.Value = 1000
+		}
+	}
+	highFeeRecipientOutputs := []*wire.TxOut{s.output(btcutil.Amount(1e7))}
+	err = maketx.CheckPolicy(txProposal, highFeeRecipientOutputs, utxo, s.isOwnChange, false)
+	s.Require().Equal(maketx.PolicyViolationError("fee too high compared to the amount"), errp.Cause(err))
+
+	// The same tx exceeds the fee cap. If the user confirmed the fee by overriding the cap, the
+	// policy accepts it as well.
+	feeCap := maketx.FeeCap{MaxFeePercent: 10}
+	s.Require().Equal(maketx.ErrFeeCapExceeded, errp.Cause(feeCap.Check(txProposal)))
+	s.Require().NoError(
+		maketx.CheckPolicy(txProposal, highFeeRecipientOutputs, utxo, s.isOwnChange, true))
+	// The override only affects the fee rule.
+	txProposal.Fee++
+	err = maketx.CheckPolicy(txProposal, highFeeRecipientOutputs, utxo, s.isOwnChange, true)
+	s.Require().Equal(maketx.PolicyViolationError("fee does not match"), errp.Cause(err))
+}
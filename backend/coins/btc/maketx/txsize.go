@@ -16,6 +16,7 @@ package maketx
 
 import (
 	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 )
@@ -77,3 +78,16 @@ func estimateTxSize(
 	}
 	return txWeight/4 + 1
 }
+
+// InputFee returns the fee needed to spend one more input of the given structure at the given fee
+// rate. Outputs worth less than that cost more to spend than they are worth.
+func InputFee(inputConfiguration *signing.Configuration, feeRatePerKb btcutil.Amount) btcutil.Amount {
+	inputSize := estimateTxSize(2, inputConfiguration, 0, 0) - estimateTxSize(1, inputConfiguration, 0, 0)
+	return feeRatePerKb * btcutil.Amount(inputSize) / 1000
+}
+
+// ChildTxSize returns the estimated size of a tx spending one input of the given structure to one
+// output, e.g. a child tx paying for its unconfirmed parent.
+func ChildTxSize(inputConfiguration *signing.Configuration, outputPkScriptSize int) int {
+	return estimateTxSize(1, inputConfiguration, outputPkScriptSize, 0)
+}
@@ -103,3 +103,14 @@ func TestEstimateTxSize(t *testing.T) {
 		}
 	}
 }
+
+func TestInputFee(t *testing.T) {
+	p2pkh := addressesTest.GetAddress(signing.ScriptTypeP2PKH).Configuration
+	p2wpkh := addressesTest.GetAddress(signing.ScriptTypeP2WPKH).Configuration
+	require.Equal(t, btcutil.Amount(0), InputFee(p2wpkh, 0))
+	// Segwit inputs are cheaper to spend.
+	require.True(t, InputFee(p2wpkh, 1000) < InputFee(p2pkh, 1000))
+	require.Equal(t,
+		btcutil.Amount(estimateTxSize(2, p2wpkh, 0, 0)-estimateTxSize(1, p2wpkh, 0, 0))*10,
+		InputFee(p2wpkh, 10000))
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// MaxSpendable returns the most the account can send in one tx at the given fee rate. All coins
+// which are worth more than the fee to spend them are consolidated into one output of the type of
+// the account's addresses. Vaulted coins are not included, as they are spent by unvaulting them, nor
+// coins received to the payment code, which can't be spent yet, nor coins reserved by transactions
+// signed offline.
+func (account *Account) MaxSpendable(feeRatePerKb btcutil.Amount) (*maketx.MaxSpendable, error) {
+	if account.Offline() || !account.InitialSyncDone() {
+		return nil, errp.Newf("The account %s is not synced", account.Code())
+	}
+	wireUTXO := map[wire.OutPoint]*wire.TxOut{}
+	reserved := account.offlineTxs.Reserved()
+	for outPoint, txOut := range account.transactions.SpendableOutputs() {
+		if _, ok := reserved[outPoint]; ok {
+			continue
+		}
+		if account.isVaulted(outPoint) || account.isPaymentCodeOutput(txOut.ScriptHashHex()) {
+			continue
+		}
+		wireUTXO[outPoint] = txOut.TxOut
+	}
+	outputPkScript := account.changeAddresses.GetUnused()[0].PubkeyScript()
+	return maketx.NewMaxSpendable(
+		account.signingConfiguration, wireUTXO, len(outputPkScript), feeRatePerKb, account.log), nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// MultisigExportFormat is the file format of an exported multisig wallet configuration.
+type MultisigExportFormat string
+
+const (
+	// MultisigExportFormatText is the multisig setup text file introduced by Coldcard, which can be
+	// imported by Keystone, SeedSigner and Sparrow.
+	MultisigExportFormatText MultisigExportFormat = "text"
+
+	// MultisigExportFormatJSON is a JSON file containing a label and the output script descriptor
+	// of the wallet, which can be imported by Sparrow, Specter and SeedSigner (as a descriptor QR
+	// code).
+	MultisigExportFormatJSON MultisigExportFormat = "json"
+)
+
+// multisigExportMaxNameLength is the maximum length of the wallet name supported by hardware
+// wallets importing the text format.
+const multisigExportMaxNameLength = 20
+
+// multisigCosigner is a cosigner of the multisig configuration, in the format expected by other
+// wallets.
+type multisigCosigner struct {
+	fingerprint string
+	xpub        string
+	publicKey   []byte
+}
+
+// multisigCosigners returns the cosigners of the account, sorted by public key as they appear in
+// the multisig script.
+func (account *Account) multisigCosigners() ([]*multisigCosigner, error) {
+	if !account.signingConfiguration.Multisig() {
+		return nil, errp.New("The account is not a multisig account.")
+	}
+	fingerprints, err := account.keystores.RootFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	extendedPublicKeys := account.signingConfiguration.ExtendedPublicKeys()
+	if len(fingerprints) != len(extendedPublicKeys) {
+		return nil, errp.New("The keystores do not match the signing configuration.")
+	}
+	cosigners := make([]*multisigCosigner, len(extendedPublicKeys))
+	for index, extendedPublicKey := range extendedPublicKeys {
+		// Other wallets expect the xpub version of the network (xpub, tpub).
+		xpub, err := hdkeychain.NewKeyFromString(extendedPublicKey.String())
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		xpub.SetNet(account.coin.Net())
+		publicKey, err := xpub.ECPubKey()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		cosigners[index] = &multisigCosigner{
+			fingerprint: hex.EncodeToString(fingerprints[index]),
+			xpub:        xpub.String(),
+			publicKey:   publicKey.SerializeCompressed(),
+		}
+	}
+	// Sort like the public keys in the redeem script, see signing.Configuration.SortedPublicKeys().
+	sort.Slice(cosigners, func(i, j int) bool {
+		return bytes.Compare(cosigners[i].publicKey, cosigners[j].publicKey) < 0
+	})
+	return cosigners, nil
+}
+
+// multisigDescriptor returns the output script descriptor of the receive addresses of the
+// account, including the checksum.
+func (account *Account) multisigDescriptor(cosigners []*multisigCosigner) (string, error) {
+	keypath := strings.TrimPrefix(account.signingConfiguration.AbsoluteKeypath().Encode(), "m")
+	keys := make([]string, len(cosigners))
+	for index, cosigner := range cosigners {
+		keys[index] = fmt.Sprintf("[%s%s]%s/0/*", cosigner.fingerprint, keypath, cosigner.xpub)
+	}
+	descriptor := fmt.Sprintf("sh(sortedmulti(%d,%s))",
+		account.signingConfiguration.SigningThreshold(), strings.Join(keys, ","))
+	checksum, err := signing.DescriptorChecksum(descriptor)
+	if err != nil {
+		return "", err
+	}
+	return descriptor + "#" + checksum, nil
+}
+
+// ExportMultisig exports the multisig configuration of the account in the given format, so that
+// it can be imported by the cosigners' wallets.
+func (account *Account) ExportMultisig(format MultisigExportFormat) (string, error) {
+	cosigners, err := account.multisigCosigners()
+	if err != nil {
+		return "", err
+	}
+	name := account.name
+	if len(name) > multisigExportMaxNameLength {
+		name = name[:multisigExportMaxNameLength]
+	}
+	switch format {
+	case MultisigExportFormatText:
+		var text bytes.Buffer
+		fmt.Fprintf(&text, "# BitBox multisig setup file\n#\n")
+		fmt.Fprintf(&text, "Name: %s\n", name)
+		fmt.Fprintf(&text, "Policy: %d of %d\n",
+			account.signingConfiguration.SigningThreshold(), len(cosigners))
+		fmt.Fprintf(&text, "Derivation: %s\n", account.signingConfiguration.AbsoluteKeypath().Encode())
+		fmt.Fprintf(&text, "Format: P2SH\n\n")
+		for _, cosigner := range cosigners {
+			fmt.Fprintf(&text, "%s: %s\n", strings.ToUpper(cosigner.fingerprint), cosigner.xpub)
+		}
+		return text.String(), nil
+	case MultisigExportFormatJSON:
+		descriptor, err := account.multisigDescriptor(cosigners)
+		if err != nil {
+			return "", err
+		}
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"label":       name,
+			"blockheight": 0,
+			"descriptor":  descriptor,
+		}, "", "  ")
+		if err != nil {
+			return "", errp.WithStack(err)
+		}
+		return string(result), nil
+	default:
+		return "", errp.Newf("Unknown multisig export format %s", format)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// maxNicknameLength is the maximum number of characters of a nickname, so that it fits on the
+	// confirmation screen of the mobile.
+	maxNicknameLength = 32
+	// maxEmojiLength is the maximum number of code points of the emoji. Emojis can be made up of
+	// several code points, e.g. with skin tone modifiers.
+	maxEmojiLength = 8
+)
+
+// NewAccountNickname validates the nickname and the emoji entered by the user. The emoji can be
+// empty, and must not contain letters, digits or spaces otherwise. An empty name and emoji remove
+// the nickname.
+func NewAccountNickname(name string, emoji string) (config.AccountNickname, error) {
+	nickname := config.AccountNickname{Name: strings.TrimSpace(name), Emoji: strings.TrimSpace(emoji)}
+	if !utf8.ValidString(nickname.Name) || utf8.RuneCountInString(nickname.Name) > maxNicknameLength {
+		return nickname, errp.Newf("the nickname must be at most %d characters long", maxNicknameLength)
+	}
+	for _, r := range nickname.Name {
+		if unicode.IsControl(r) {
+			return nickname, errp.New("the nickname must not contain control characters")
+		}
+	}
+	if !utf8.ValidString(nickname.Emoji) || utf8.RuneCountInString(nickname.Emoji) > maxEmojiLength {
+		return nickname, errp.New("invalid emoji")
+	}
+	for _, r := range nickname.Emoji {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return nickname, errp.New("invalid emoji")
+		}
+	}
+	return nickname, nil
+}
+
+// Nickname returns the nickname the user gave the account, which is empty if there is none.
+func (account *Account) Nickname() config.AccountNickname {
+	if account.config == nil {
+		return config.AccountNickname{}
+	}
+	return account.config.Config().Backend.AccountNicknames[account.code]
+}
+
+// DisplayName returns the name the account is shown with: the nickname if set, or the account name,
+// prefixed by the emoji if set.
+func (account *Account) DisplayName() string {
+	nickname := account.Nickname()
+	name := account.name
+	if nickname.Name != "" {
+		name = nickname.Name
+	}
+	if nickname.Emoji != "" {
+		return nickname.Emoji + " " + name
+	}
+	return name
+}
+
+// SetNickname persists the nickname of the account, see NewAccountNickname(). It is used for new
+// transactions from then on.
+func (account *Account) SetNickname(nickname config.AccountNickname) error {
+	appConfig := account.config.Config()
+	nicknames := map[string]config.AccountNickname{}
+	for code, existing := range appConfig.Backend.AccountNicknames {
+		nicknames[code] = existing
+	}
+	if nickname.Name == "" && nickname.Emoji == "" {
+		delete(nicknames, account.code)
+	} else {
+		nicknames[account.code] = nickname
+	}
+	appConfig.Backend.AccountNicknames = nicknames
+	return account.config.Set(appConfig)
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+func TestNewAccountNickname(t *testing.T) {
+	nickname, err := NewAccountNickname("  Savings ", "💰")
+	require.NoError(t, err)
+	require.Equal(t, config.AccountNickname{Name: "Savings", Emoji: "💰"}, nickname)
+
+	// Emojis made up of several code points.
+	_, err = NewAccountNickname("Family", "👨‍👩‍👧")
+	require.NoError(t, err)
+	_, err = NewAccountNickname("", "")
+	require.NoError(t, err)
+
+	_, err = NewAccountNickname(strings.Repeat("a", maxNicknameLength+1), "")
+	require.Error(t, err)
+	_, err = NewAccountNickname("Savings\n", "")
+	require.NoError(t, err)
+	_, err = NewAccountNickname("Sav\x00ings", "")
+	require.Error(t, err)
+	_, err = NewAccountNickname("Savings", "S")
+	require.Error(t, err)
+	_, err = NewAccountNickname("Savings", "💰 💰")
+	require.Error(t, err)
+}
+
+func TestDisplayName(t *testing.T) {
+	dbFolder := test.TstTempDir("btc_nickname_test")
+	appConfig := config.NewConfig(path.Join(dbFolder, "config.json"))
+	coin := NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, dbFolder, nil, "", nil)
+	account := NewAccount(coin, dbFolder, "tbtc-p2wpkh", "Bitcoin Testnet: bech32",
+		nil, nil, appConfig, nil, func(Event) {}, logging.Get().WithGroup("btc_test"))
+	require.Equal(t, "Bitcoin Testnet: bech32", account.DisplayName())
+
+	require.NoError(t, account.SetNickname(config.AccountNickname{Name: "Savings", Emoji: "💰"}))
+	require.Equal(t, "💰 Savings", account.DisplayName())
+	require.Equal(t, "💰 Savings", NewAccount(coin, dbFolder, "tbtc-p2wpkh", "Bitcoin Testnet: bech32",
+		nil, nil, config.NewConfig(path.Join(dbFolder, "config.json")), nil, func(Event) {},
+		logging.Get().WithGroup("btc_test")).DisplayName())
+
+	require.NoError(t, account.SetNickname(config.AccountNickname{Emoji: "💰"}))
+	require.Equal(t, "💰 Bitcoin Testnet: bech32", account.DisplayName())
+
+	require.NoError(t, account.SetNickname(config.AccountNickname{}))
+	require.Equal(t, "Bitcoin Testnet: bech32", account.DisplayName())
+	require.Empty(t, appConfig.Config().Backend.AccountNicknames)
+}
@@ -0,0 +1,217 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// maxSyncAge is the time after the last sync after which the coins and fee rates known to the
+// account are considered stale.
+const maxSyncAge = time.Hour
+
+// TxWarningStaleData means that the tx was created without being connected to the blockchain, or
+// long after the last sync. The spent coins might not be available anymore, and the fee rate might
+// not reflect the current fee market.
+const TxWarningStaleData TxWarning = "staleData"
+
+// OfflineTx is a signed tx which was not broadcast, to be broadcast later or from another device.
+type OfflineTx struct {
+	TxID string
+	// RawTx is the hex encoded serialized tx.
+	RawTx  string
+	Amount btcutil.Amount
+	Fee    *FeeDetails
+	// LastSync is the time the account was last synced, nil if it was not synced since the app
+	// started.
+	LastSync *time.Time
+	Warnings []TxWarning
+}
+
+// markSynced records the time of the last sync. It is persisted, so that the staleness of the data
+// is known when the app is started offline.
+func (account *Account) markSynced() {
+	if account.offlineTxs == nil {
+		return
+	}
+	if err := account.offlineTxs.SetLastSync(time.Now()); err != nil {
+		account.log.WithError(err).Error("Could not persist the time of the last sync")
+	}
+}
+
+// staleData returns the time of the last sync (nil if there was none), and whether the coins and
+// fee rates known to the account are stale.
+func (account *Account) staleData() (*time.Time, bool) {
+	lastSync := account.offlineTxs.LastSync()
+	if lastSync.IsZero() {
+		return nil, true
+	}
+	defer account.RLock()()
+	return &lastSync, account.offline || time.Since(lastSync) > maxSyncAge
+}
+
+// releaseSpentReservations releases the coins reserved by offline transactions which were
+// broadcast, possibly from another device, or invalidated by a conflicting tx.
+func (account *Account) releaseSpentReservations() {
+	if account.offlineTxs == nil || account.transactions == nil {
+		return
+	}
+	spendable := account.transactions.SpendableOutputs()
+	err := account.offlineTxs.Prune(func(outPoint wire.OutPoint) bool {
+		_, ok := spendable[outPoint]
+		return ok
+	})
+	if err != nil {
+		account.log.WithError(err).Error("Could not persist the reserved coins")
+	}
+}
+
+// SignTxOffline creates and signs a tx like SendTx, but does not broadcast it. It works without a
+// connection to the blockchain, using the coins known from the last sync. If feeRatePerKb is not
+// zero, it is used instead of the estimate of the fee target, as there are no estimates when the
+// app was started offline. The result contains a warning if the data used might be stale. The
+// spent coins are reserved until the tx is broadcast or canceled with CancelOfflineTx().
+func (account *Account) SignTxOffline(
+	recipientAddress string,
+	amount SendAmount,
+	feeTargetCode FeeTargetCode,
+	feeRatePerKb btcutil.Amount,
+	selectedUTXOs map[wire.OutPoint]struct{},
+	memo string,
+	fiatUnit string,
+	overrideFeeCap bool,
+	disableRBF bool,
+) (*OfflineTx, error) {
+	account.log.Info("Signing transaction offline")
+	if err := validateTxNote(memo); err != nil {
+		return nil, err
+	}
+	if feeRatePerKb < 0 {
+		return nil, errp.WithStack(TxValidationError("invalid fee rate"))
+	}
+	address, err := account.decodeRecipientAddress(recipientAddress)
+	if err != nil {
+		return nil, err
+	}
+	if feeRatePerKb == 0 {
+		feeTarget := account.feeTarget(feeTargetCode)
+		if feeTarget == nil || feeTarget.FeeRatePerKb == nil {
+			return nil, errp.New("Fee could not be estimated")
+		}
+		feeRatePerKb = *feeTarget.FeeRatePerKb
+	}
+	utxo, txProposal, err := account.newTxWithFeeRate(
+		address, amount, feeRatePerKb, selectedUTXOs, overrideFeeCap, disableRBF)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to create transaction")
+	}
+	txProposal.Memo = memo
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	recipientAmount := amount.amount
+	if amount.sendAll {
+		recipientAmount = txProposal.Amount
+	}
+	recipientOutputs := []*wire.TxOut{wire.NewTxOut(int64(recipientAmount), pkScript)}
+	if err := account.checkTxPolicy(txProposal, recipientOutputs, utxo, overrideFeeCap); err != nil {
+		return nil, err
+	}
+	if err := SignTransaction(account.keystores, txProposal, utxo, account.getAddress, account.log); err != nil {
+		return nil, errp.WithMessage(err, "Failed to sign transaction")
+	}
+	var rawTx bytes.Buffer
+	if err := txProposal.Transaction.Serialize(&rawTx); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	txHash := txProposal.Transaction.TxHash()
+	if err := account.offlineTxs.Reserve(txProposal.Transaction); err != nil {
+		return nil, err
+	}
+	if memo != "" {
+		if err := account.transactions.SetTxNote(txHash, memo); err != nil {
+			account.log.WithError(err).Error("Failed to store the memo as tx note")
+		}
+	}
+	// Alert the user when the tx can be broadcast, or when it is invalidated by another tx.
+	if _, err := account.watchtower.Watch(txProposal.Transaction); err != nil {
+		account.log.WithError(err).Error("Failed to watch the offline tx")
+	}
+	warnings := account.txWarnings(txProposal)
+	lastSync, stale := account.staleData()
+	if stale {
+		warnings = append(warnings, TxWarningStaleData)
+	}
+	return &OfflineTx{
+		TxID:     txHash.String(),
+		RawTx:    hex.EncodeToString(rawTx.Bytes()),
+		Amount:   txProposal.Amount,
+		Fee:      account.feeDetails(txProposal, fiatUnit),
+		LastSync: lastSync,
+		Warnings: warnings,
+	}, nil
+}
+
+// BroadcastTx broadcasts a hex encoded signed tx, e.g. one created with SignTxOffline(). The coins
+// reserved by the tx are released once it was broadcast.
+func (account *Account) BroadcastTx(rawTxHex string) (string, error) {
+	rawTx, err := hex.DecodeString(rawTxHex)
+	if err != nil {
+		return "", errp.WithStack(TxValidationError("invalid transaction"))
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return "", errp.WithStack(TxValidationError("invalid transaction"))
+	}
+	account.log.WithField("txid", tx.TxHash().String()).Info("Broadcasting transaction")
+	if err := account.blockchain.TransactionBroadcast(tx); err != nil {
+		return "", err
+	}
+	if _, err := account.offlineTxs.Release(tx.TxHash()); err != nil {
+		account.log.WithError(err).Error("Could not release the reserved coins")
+	}
+	return tx.TxHash().String(), nil
+}
+
+// CancelOfflineTx releases the coins reserved by the tx with the given ID, signed with
+// SignTxOffline(), and stops watching it. The tx must not be broadcast afterwards, as the coins can
+// be spent by new transactions.
+func (account *Account) CancelOfflineTx(txID string) error {
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return errp.WithStack(TxValidationError("invalid transaction"))
+	}
+	released, err := account.offlineTxs.Release(*txHash)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return errp.WithStack(TxValidationError("unknown transaction"))
+	}
+	if err := account.watchtower.Unwatch(*txHash); err != nil {
+		account.log.WithError(err).Warning("Could not stop watching the canceled tx")
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package offlinetx persists the state needed to sign transactions while offline: the time of the
+// last sync, and the coins spent by signed transactions which were not broadcast yet. These coins
+// are reserved, so that they are not spent a second time by another tx, until the signed tx is
+// broadcast or canceled.
+package offlinetx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+type storeEncoding struct {
+	LastSync time.Time `json:"lastSync"`
+	// Reserved are the hex encoded signed transactions whose inputs are reserved.
+	Reserved []string `json:"reserved"`
+}
+
+// Store persists the time of the last sync and the reservations in a file.
+type Store struct {
+	lock     locker.Locker
+	filename string
+	lastSync time.Time
+	reserved map[chainhash.Hash]*wire.MsgTx
+	log      *logrus.Entry
+}
+
+// NewStore loads the time of the last sync and the transactions signed offline whose inputs are
+// reserved from the given file. A missing file yields an empty store.
+func NewStore(filename string, log *logrus.Entry) (*Store, error) {
+	store := &Store{
+		filename: filename,
+		reserved: map[chainhash.Hash]*wire.MsgTx{},
+		log:      log.WithField("group", "offlinetx"),
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	var encoding storeEncoding
+	if err := json.Unmarshal(jsonBytes, &encoding); err != nil {
+		return nil, errp.WithMessage(err, "Could not read the offline tx file")
+	}
+	store.lastSync = encoding.LastSync
+	for _, txHex := range encoding.Reserved {
+		txBytes, err := hex.DecodeString(txHex)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		tx := wire.NewMsgTx(wire.TxVersion)
+		if err := tx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		store.reserved[tx.TxHash()] = tx
+	}
+	return store, nil
+}
+
+func (store *Store) save() error {
+	encoding := storeEncoding{LastSync: store.lastSync, Reserved: []string{}}
+	for _, tx := range store.reserved {
+		var txBytes bytes.Buffer
+		if err := tx.Serialize(&txBytes); err != nil {
+			return errp.WithStack(err)
+		}
+		encoding.Reserved = append(encoding.Reserved, hex.EncodeToString(txBytes.Bytes()))
+	}
+	jsonBytes, err := json.Marshal(encoding)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// LastSync returns the time the last sync finished, zero if there was none yet.
+func (store *Store) LastSync() time.Time {
+	defer store.lock.RLock()()
+	return store.lastSync
+}
+
+// SetLastSync records the time the last sync finished.
+func (store *Store) SetLastSync(lastSync time.Time) error {
+	defer store.lock.Lock()()
+	store.lastSync = lastSync
+	return store.save()
+}
+
+// Reserve reserves the inputs of the given signed tx until it is released. Reserving a tx which is
+// already reserved does nothing.
+func (store *Store) Reserve(tx *wire.MsgTx) error {
+	defer store.lock.Lock()()
+	txHash := tx.TxHash()
+	if _, ok := store.reserved[txHash]; ok {
+		return nil
+	}
+	store.reserved[txHash] = tx
+	store.log.WithField("txid", txHash).Info("Reserving the inputs of the tx")
+	return store.save()
+}
+
+// Release releases the inputs reserved by the tx with the given hash. It returns false if the tx
+// did not reserve any inputs.
+func (store *Store) Release(txHash chainhash.Hash) (bool, error) {
+	defer store.lock.Lock()()
+	if _, ok := store.reserved[txHash]; !ok {
+		return false, nil
+	}
+	delete(store.reserved, txHash)
+	store.log.WithField("txid", txHash).Info("Releasing the inputs of the tx")
+	return true, store.save()
+}
+
+// Reserved returns the outpoints reserved by signed transactions, mapped to the hash of the
+// reserving tx.
+func (store *Store) Reserved() map[wire.OutPoint]chainhash.Hash {
+	defer store.lock.RLock()()
+	reserved := map[wire.OutPoint]chainhash.Hash{}
+	for txHash, tx := range store.reserved {
+		for _, txIn := range tx.TxIn {
+			reserved[txIn.PreviousOutPoint] = txHash
+		}
+	}
+	return reserved
+}
+
+// Prune releases the reservations of all transactions of which an input is not unspent anymore,
+// i.e. which were broadcast, or invalidated by a conflicting tx.
+func (store *Store) Prune(isUnspent func(wire.OutPoint) bool) error {
+	defer store.lock.Lock()()
+	pruned := false
+	for txHash, tx := range store.reserved {
+		for _, txIn := range tx.TxIn {
+			if !isUnspent(txIn.PreviousOutPoint) {
+				delete(store.reserved, txHash)
+				store.log.WithField("txid", txHash).Info("Releasing the inputs of the spent tx")
+				pruned = true
+				break
+			}
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return store.save()
+}
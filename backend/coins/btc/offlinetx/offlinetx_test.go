@@ -0,0 +1,89 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package offlinetx_test
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/offlinetx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func newTx(outPoints ...wire.OutPoint) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for _, outPoint := range outPoints {
+		outPoint := outPoint
+		tx.AddTxIn(wire.NewTxIn(&outPoint, []byte{0x01}, nil))
+	}
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	return tx
+}
+
+func TestStore(t *testing.T) {
+	filename := path.Join(test.TstTempDir("offlinetx"), "offlinetx.json")
+	log := logging.Get().WithGroup("offlinetx_test")
+	store, err := offlinetx.NewStore(filename, log)
+	require.NoError(t, err)
+	require.True(t, store.LastSync().IsZero())
+	require.Empty(t, store.Reserved())
+
+	outPoint1 := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	outPoint2 := wire.OutPoint{Hash: chainhash.Hash{2}, Index: 1}
+	outPoint3 := wire.OutPoint{Hash: chainhash.Hash{3}, Index: 2}
+	tx1 := newTx(outPoint1, outPoint2)
+	tx2 := newTx(outPoint3)
+	require.NoError(t, store.Reserve(tx1))
+	require.NoError(t, store.Reserve(tx1))
+	require.NoError(t, store.Reserve(tx2))
+	require.Equal(t,
+		map[wire.OutPoint]chainhash.Hash{
+			outPoint1: tx1.TxHash(),
+			outPoint2: tx1.TxHash(),
+			outPoint3: tx2.TxHash(),
+		},
+		store.Reserved())
+
+	lastSync := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, store.SetLastSync(lastSync))
+
+	// The state survives a restart.
+	store, err = offlinetx.NewStore(filename, log)
+	require.NoError(t, err)
+	require.True(t, lastSync.Equal(store.LastSync()))
+	require.Len(t, store.Reserved(), 3)
+
+	released, err := store.Release(tx2.TxHash())
+	require.NoError(t, err)
+	require.True(t, released)
+	released, err = store.Release(tx2.TxHash())
+	require.NoError(t, err)
+	require.False(t, released)
+	require.Len(t, store.Reserved(), 2)
+
+	// tx1 is released as soon as one of its inputs is spent.
+	require.NoError(t, store.Prune(func(outPoint wire.OutPoint) bool {
+		return outPoint != outPoint2
+	}))
+	require.Empty(t, store.Reserved())
+	store, err = offlinetx.NewStore(filename, log)
+	require.NoError(t, err)
+	require.Empty(t, store.Reserved())
+}
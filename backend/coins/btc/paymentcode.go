@@ -0,0 +1,398 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/bip47"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// paymentCodeGapLimit is the number of unused addresses of each sender which are watched after the
+// last used one.
+const paymentCodeGapLimit = 10
+
+// PaymentCodeInfo describes the BIP47 payment code of an account, see PaymentCode().
+type PaymentCodeInfo struct {
+	PaymentCode string `json:"paymentCode"`
+	// NotificationAddress is the address senders pay with their notification tx.
+	NotificationAddress string `json:"notificationAddress"`
+	// Senders are the payment codes of the senders which announced themselves, sorted.
+	Senders []string `json:"senders"`
+}
+
+// paymentCodeSender is a sender which announced itself with a notification tx.
+type paymentCodeSender struct {
+	code *bip47.PaymentCode
+	// notificationPublicKey is the public key of child 0 of the sender's payment code.
+	notificationPublicKey *btcec.PublicKey
+	// nextIndex is the index of the next address to derive.
+	nextIndex uint32
+	// used is the number of indices up to and including the last used one.
+	used uint32
+}
+
+// paymentCodeAddress is an address at which a sender pays.
+type paymentCodeAddress struct {
+	sender        *paymentCodeSender
+	index         uint32
+	scriptHashHex blockchain.ScriptHashHex
+	historyStatus string
+}
+
+// paymentCodeReceiver watches the notification address of the payment code of the account, and
+// the addresses of the senders which announced themselves.
+type paymentCodeReceiver struct {
+	code *bip47.PaymentCode
+	// keypath is the keypath of the payment code, m/47'/coin'/0'.
+	keypath              signing.AbsoluteKeypath
+	notificationAddress  string
+	notificationPkScript []byte
+	notificationStatus   string
+	// notifications are the processed txs of the notification address.
+	notifications map[chainhash.Hash]struct{}
+	// senders are keyed by their encoded payment code.
+	senders   map[string]*paymentCodeSender
+	addresses map[blockchain.ScriptHashHex]*paymentCodeAddress
+	lock      locker.Locker
+}
+
+// paymentCodeKeypath returns the keypath of the payment code of the account with the given keypath,
+// m/47'/coin'/0'. There is one payment code per coin.
+func paymentCodeKeypath(accountKeypath signing.AbsoluteKeypath) (signing.AbsoluteKeypath, error) {
+	elements := strings.Split(accountKeypath.Encode(), "/")
+	if len(elements) < 3 {
+		return nil, errp.Newf("the keypath %s has no coin type", accountKeypath.Encode())
+	}
+	return signing.NewAbsoluteKeypath("m/47'/" + elements[2] + "/0'")
+}
+
+// initPaymentCode starts receiving to the payment code if it is enabled for the account, see
+// config.Backend.PaymentCodeAccounts. Only single sig accounts whose keystore can derive shared
+// secrets can receive to a payment code.
+func (account *Account) initPaymentCode() error {
+	if code, ok := account.config.Config().Backend.PaymentCodeAccounts[account.coin.Name()]; !ok ||
+		code != account.code {
+		return nil
+	}
+	if !account.signingConfiguration.Singlesig() || !account.keystores.CanDeriveSharedSecrets() {
+		account.log.Warning("The keystore of the account can't receive to a payment code")
+		return nil
+	}
+	keypath, err := paymentCodeKeypath(account.signingConfiguration.AbsoluteKeypath())
+	if err != nil {
+		return err
+	}
+	extendedKeys, err := account.keystores.ExtendedPublicKeys(keypath)
+	if err != nil {
+		return err
+	}
+	code, err := bip47.New(extendedKeys[0])
+	if err != nil {
+		return err
+	}
+	notificationAddress, err := code.NotificationAddress(account.coin.Net())
+	if err != nil {
+		return err
+	}
+	notificationPkScript, err := txscript.PayToAddrScript(notificationAddress)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	receiver := &paymentCodeReceiver{
+		code:                 code,
+		keypath:              keypath,
+		notificationAddress:  notificationAddress.EncodeAddress(),
+		notificationPkScript: notificationPkScript,
+		notifications:        map[chainhash.Hash]struct{}{},
+		senders:              map[string]*paymentCodeSender{},
+		addresses:            map[blockchain.ScriptHashHex]*paymentCodeAddress{},
+	}
+	func() {
+		defer account.Lock()()
+		account.paymentCode = receiver
+	}()
+	account.log.WithField("notification-address", receiver.notificationAddress).
+		Info("Receiving to the payment code")
+	account.blockchain.ScriptHashSubscribe(
+		account.synchronizer.IncRequestsCounter,
+		blockchain.ScriptHashHex(chainhash.HashH(notificationPkScript).String()),
+		func(status string) error { account.onNotificationStatus(status); return nil },
+	)
+	return nil
+}
+
+// PaymentCode returns the payment code of the account. false is returned if the account does not
+// receive to a payment code.
+func (account *Account) PaymentCode() (*PaymentCodeInfo, bool) {
+	defer account.RLock()()
+	receiver := account.paymentCode
+	if receiver == nil {
+		return nil, false
+	}
+	defer receiver.lock.RLock()()
+	senders := []string{}
+	for sender := range receiver.senders {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+	return &PaymentCodeInfo{
+		PaymentCode:         receiver.code.String(),
+		NotificationAddress: receiver.notificationAddress,
+		Senders:             senders,
+	}, true
+}
+
+// isPaymentCodeOutput returns whether the output was received at an address of the payment code.
+// These coins can't be spent yet, as the keystores can only sign with keys derived by BIP32.
+func (account *Account) isPaymentCodeOutput(scriptHashHex blockchain.ScriptHashHex) bool {
+	receiver := account.paymentCode
+	if receiver == nil {
+		return false
+	}
+	defer receiver.lock.RLock()()
+	_, ok := receiver.addresses[scriptHashHex]
+	return ok
+}
+
+// onNotificationStatus processes the txs of the notification address which were not processed
+// yet when its status changed.
+func (account *Account) onNotificationStatus(status string) {
+	receiver := account.paymentCode
+	unchanged := func() bool {
+		defer receiver.lock.RLock()()
+		return status == receiver.notificationStatus
+	}()
+	if unchanged {
+		return
+	}
+	done := account.synchronizer.IncRequestsCounter()
+	account.blockchain.ScriptHashGetHistory(
+		blockchain.ScriptHashHex(chainhash.HashH(receiver.notificationPkScript).String()),
+		func(history blockchain.TxHistory) error {
+			newTxs := []chainhash.Hash{}
+			func() {
+				defer receiver.lock.Lock()()
+				receiver.notificationStatus = history.Status()
+				for _, txInfo := range history {
+					txHash := txInfo.TXHash.Hash()
+					if _, ok := receiver.notifications[txHash]; ok {
+						continue
+					}
+					receiver.notifications[txHash] = struct{}{}
+					newTxs = append(newTxs, txHash)
+				}
+			}()
+			for _, txHash := range newTxs {
+				done := account.synchronizer.IncRequestsCounter()
+				account.blockchain.TransactionGet(txHash,
+					func(tx *wire.MsgTx) error {
+						account.processNotification(tx)
+						return nil
+					},
+					func() { done() },
+				)
+			}
+			return nil
+		},
+		func() { done() },
+	)
+}
+
+// processNotification adds the sender announced by the tx, if it is a notification tx.
+func (account *Account) processNotification(tx *wire.MsgTx) {
+	receiver := account.paymentCode
+	notification, ok := bip47.ParseNotification(tx, receiver.notificationPkScript)
+	if !ok {
+		return
+	}
+	sharedSecret, err := account.keystores.SharedSecret(
+		receiver.keypath.Child(0, signing.NonHardened), notification.PublicKey)
+	if err != nil {
+		account.log.WithError(err).Error("Failed to derive the shared secret of a notification")
+		return
+	}
+	code, err := notification.PaymentCode(sharedSecret)
+	if err != nil {
+		account.log.WithError(err).Warning("Ignoring an invalid notification")
+		return
+	}
+	notificationPublicKey, err := code.ChildPublicKey(0)
+	if err != nil {
+		account.log.WithError(err).Warning("Ignoring an invalid notification")
+		return
+	}
+	added := func() bool {
+		defer receiver.lock.Lock()()
+		if _, ok := receiver.senders[code.String()]; ok {
+			return false
+		}
+		receiver.senders[code.String()] = &paymentCodeSender{
+			code:                  code,
+			notificationPublicKey: notificationPublicKey,
+		}
+		return true
+	}()
+	if !added {
+		return
+	}
+	account.log.WithField("tx", tx.TxHash()).Info("A sender announced itself to the payment code")
+	account.onEvent(EventPaymentCodeNotification)
+	account.ensurePaymentCodeAddresses()
+}
+
+// ensurePaymentCodeAddresses derives and subscribes to the addresses of all senders, so that there
+// are paymentCodeGapLimit unused indices after the last used one of each sender.
+func (account *Account) ensurePaymentCodeAddresses() {
+	defer account.synchronizer.IncRequestsCounter()()
+	for _, address := range account.derivePaymentCodeAddresses() {
+		address := address
+		account.blockchain.ScriptHashSubscribe(
+			account.synchronizer.IncRequestsCounter,
+			address.scriptHashHex,
+			func(status string) error { account.onPaymentCodeAddressStatus(address, status); return nil },
+		)
+	}
+}
+
+// derivePaymentCodeAddresses derives the missing addresses of all senders and returns them.
+func (account *Account) derivePaymentCodeAddresses() []*paymentCodeAddress {
+	receiver := account.paymentCode
+	defer receiver.lock.Lock()()
+	dbTx, err := account.db.Begin()
+	if err != nil {
+		account.log.WithError(err).Error("Failed to begin transaction")
+		return nil
+	}
+	defer dbTx.Rollback()
+	newAddresses := []*paymentCodeAddress{}
+	for senderCode, sender := range receiver.senders {
+		for sender.nextIndex < sender.used+paymentCodeGapLimit {
+			addresses, err := account.newPaymentCodeAddresses(dbTx, sender)
+			if err != nil {
+				account.log.WithError(err).WithField("sender", senderCode).
+					Error("Failed to derive the addresses of a sender")
+				break
+			}
+			for _, address := range addresses {
+				receiver.addresses[address.scriptHashHex] = address
+			}
+			newAddresses = append(newAddresses, addresses...)
+			sender.nextIndex++
+		}
+	}
+	return newAddresses
+}
+
+// newPaymentCodeAddresses returns the addresses of the sender at its next index, with the history
+// status known from the database. No addresses are returned if the index must be skipped.
+func (account *Account) newPaymentCodeAddresses(
+	dbTx transactions.DBTxInterface, sender *paymentCodeSender) ([]*paymentCodeAddress, error) {
+	receiver := account.paymentCode
+	index := sender.nextIndex
+	sharedSecret, err := account.keystores.SharedSecret(
+		receiver.keypath.Child(index, signing.NonHardened), sender.notificationPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	childPublicKey, err := receiver.code.ChildPublicKey(index)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := bip47.ReceivePublicKey(childPublicKey, sharedSecret)
+	if errp.Cause(err) == bip47.ErrInvalidIndex {
+		account.log.WithField("index", index).Info("Skipping an invalid payment code index")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	receiveAddresses, err := bip47.ReceiveAddresses(publicKey, account.coin.Net())
+	if err != nil {
+		return nil, err
+	}
+	result := []*paymentCodeAddress{}
+	for _, receiveAddress := range receiveAddresses {
+		pkScript, err := txscript.PayToAddrScript(receiveAddress)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		address := &paymentCodeAddress{
+			sender:        sender,
+			index:         index,
+			scriptHashHex: blockchain.ScriptHashHex(chainhash.HashH(pkScript).String()),
+		}
+		addressHistory, err := dbTx.AddressHistory(address.scriptHashHex)
+		if err != nil {
+			return nil, err
+		}
+		address.historyStatus = addressHistory.Status()
+		address.markUsed()
+		result = append(result, address)
+	}
+	return result, nil
+}
+
+// markUsed extends the used indices of the sender if the address has a history. The receiver lock
+// must be held.
+func (address *paymentCodeAddress) markUsed() {
+	if address.historyStatus != "" && address.index >= address.sender.used {
+		address.sender.used = address.index + 1
+	}
+}
+
+// onPaymentCodeAddressStatus indexes the tx history of the address if its status changed, and
+// derives more addresses of the sender if it was used.
+func (account *Account) onPaymentCodeAddressStatus(address *paymentCodeAddress, status string) {
+	receiver := account.paymentCode
+	unchanged := func() bool {
+		defer receiver.lock.RLock()()
+		return status == address.historyStatus
+	}()
+	if unchanged {
+		return
+	}
+	account.log.WithField("index", address.index).
+		Debug("Payment code address status changed, fetching history.")
+	done := account.synchronizer.IncRequestsCounter()
+	account.blockchain.ScriptHashGetHistory(
+		address.scriptHashHex,
+		func(history blockchain.TxHistory) error {
+			func() {
+				defer account.Lock()()
+				account.transactions.UpdateAddressHistory(address.scriptHashHex, history)
+			}()
+			func() {
+				defer receiver.lock.Lock()()
+				address.historyStatus = history.Status()
+				address.markUsed()
+			}()
+			account.ensurePaymentCodeAddresses()
+			return nil
+		},
+		func() { done() },
+	)
+}
@@ -0,0 +1,185 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// addressOfScript returns the receive or change address of the account with the given pubkey
+// script, or nil if it does not belong to the account.
+func (account *Account) addressOfScript(pkScript []byte) *addresses.AccountAddress {
+	defer account.RLock()()
+	scriptHashHex := (&transactions.SpendableOutput{TxOut: wire.NewTxOut(0, pkScript)}).ScriptHashHex()
+	if address := account.receiveAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+		return address
+	}
+	return account.changeAddresses.LookupByScriptHashHex(scriptHashHex)
+}
+
+// OwnsScript returns true if the pubkey script pays to an address of the account.
+func (account *Account) OwnsScript(pkScript []byte) bool {
+	return account.addressOfScript(pkScript) != nil
+}
+
+// OwnsAddress returns true if the encoded address is a receive or change address of the account.
+// Only addresses derived so far, i.e. up to the gap limit after the last used one, are known.
+func (account *Account) OwnsAddress(encodedAddress string) bool {
+	address, err := account.decodeRecipientAddress(encodedAddress)
+	if err != nil {
+		return false
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return false
+	}
+	return account.OwnsScript(pkScript)
+}
+
+// PSBTOwnInputs returns the spent outputs of the inputs of the PSBT which belong to the account, by
+// input index. Inputs whose spent output is not included in the PSBT are not recognized. The
+// signature of a non-segwit input does not commit to the spent amount, so for these inputs the
+// previous tx is required, as the amount could otherwise be faked to hide the fee.
+func (account *Account) PSBTOwnInputs(packet *psbt.Packet) (map[int]*wire.TxOut, error) {
+	ownInputs := map[int]*wire.TxOut{}
+	for index := range packet.UnsignedTx.TxIn {
+		previousOutput, err := packet.PreviousOutput(index)
+		if err != nil {
+			continue
+		}
+		address := account.addressOfScript(previousOutput.PkScript)
+		if address == nil {
+			continue
+		}
+		if isSegwit, _ := address.ScriptForHashToSign(); !isSegwit &&
+			packet.Inputs[index].NonWitnessUTXO == nil {
+			return nil, errp.WithStack(TxValidationError(fmt.Sprintf(
+				"input %d: the previous transaction is required for non-segwit inputs", index)))
+		}
+		ownInputs[index] = previousOutput
+	}
+	return ownInputs, nil
+}
+
+// signatureHash computes the hash signed by the keystores for the given input, see
+// keystore.Keystore.SignTransaction().
+func signatureHash(
+	tx *wire.MsgTx,
+	index int,
+	previousOutput *wire.TxOut,
+	address *addresses.AccountAddress,
+	sigHashes *txscript.TxSigHashes,
+) ([]byte, error) {
+	isSegwit, subScript := address.ScriptForHashToSign()
+	if isSegwit {
+		hash, err := txscript.CalcWitnessSigHash(subScript, sigHashes, txscript.SigHashAll, tx, index,
+			previousOutput.Value)
+		return hash, errp.WithStack(err)
+	}
+	hash, err := txscript.CalcSignatureHash(subScript, txscript.SigHashAll, tx, index)
+	return hash, errp.WithStack(err)
+}
+
+// SignPSBT signs the inputs of the PSBT which belong to the account with the keystores and adds
+// the signatures to the PSBT. The other inputs are not touched, so that the app can act as one of
+// the signers of a transaction coordinated by another wallet.
+func (account *Account) SignPSBT(packet *psbt.Packet) error {
+	ownInputs, err := account.PSBTOwnInputs(packet)
+	if err != nil {
+		return err
+	}
+	if len(ownInputs) == 0 {
+		return errp.WithStack(TxValidationError("no input belongs to the account"))
+	}
+	tx := packet.UnsignedTx.Copy()
+	previousOutputs := map[wire.OutPoint]*transactions.SpendableOutput{}
+	skipInputs := map[int]struct{}{}
+	for index, txIn := range tx.TxIn {
+		previousOutput, ok := ownInputs[index]
+		if !ok {
+			skipInputs[index] = struct{}{}
+			continue
+		}
+		if sighashType := packet.Inputs[index].SighashType; sighashType != 0 &&
+			sighashType != uint32(txscript.SigHashAll) {
+			return errp.WithStack(TxValidationError("unsupported sighash type"))
+		}
+		previousOutputs[txIn.PreviousOutPoint] = &transactions.SpendableOutput{TxOut: previousOutput}
+	}
+	var changeAddress *addresses.AccountAddress
+	func() {
+		defer account.RLock()()
+		for _, txOut := range tx.TxOut {
+			scriptHashHex := (&transactions.SpendableOutput{TxOut: txOut}).ScriptHashHex()
+			if address := account.changeAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+				changeAddress = address
+				break
+			}
+		}
+	}()
+	proposedTransaction := &ProposedTransaction{
+		TXProposal: &maketx.TxProposal{
+			Coin:                 account.coin,
+			AccountConfiguration: account.signingConfiguration,
+			Transaction:          tx,
+			ChangeAddress:        changeAddress,
+			AccountName:          account.DisplayName(),
+		},
+		PreviousOutputs: previousOutputs,
+		GetAddress:      account.getAddress,
+		Signatures:      make([][]*btcec.Signature, len(tx.TxIn)),
+		SigHashes:       txscript.NewTxSigHashes(tx),
+		SkipInputs:      skipInputs,
+	}
+	for index := range proposedTransaction.Signatures {
+		proposedTransaction.Signatures[index] = make([]*btcec.Signature, account.keystores.Count())
+	}
+	account.log.WithField("inputs", len(ownInputs)).Info("Signing PSBT")
+	if err := account.keystores.SignTransaction(proposedTransaction); err != nil {
+		return err
+	}
+	// The keystores modify the tx for their own purposes, so the hashes are computed on a fresh copy.
+	unsignedTx := packet.UnsignedTx.Copy()
+	sigHashes := txscript.NewTxSigHashes(unsignedTx)
+	for index, previousOutput := range ownInputs {
+		address := account.addressOfScript(previousOutput.PkScript)
+		hash, err := signatureHash(unsignedTx, index, previousOutput, address, sigHashes)
+		if err != nil {
+			return err
+		}
+		publicKeys := address.Configuration.PublicKeys()
+		for cosignerIndex, signature := range proposedTransaction.Signatures[index] {
+			if signature == nil {
+				continue
+			}
+			if !signature.Verify(hash, publicKeys[cosignerIndex]) {
+				return errp.New("the keystore returned an invalid signature")
+			}
+			packet.AddPartialSig(index, publicKeys[cosignerIndex].SerializeCompressed(),
+				append(signature.Serialize(), byte(txscript.SigHashAll)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package psbt implements the partially signed bitcoin transaction format (BIP174), so that the app
+// can act as a signer of transactions created by other wallets. Only the fields needed for signing
+// are parsed, all other fields are kept unchanged.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// magic is the prefix of a serialized PSBT.
+var magic = []byte{'p', 's', 'b', 't', 0xff}
+
+const (
+	globalUnsignedTx = 0x00
+
+	inputNonWitnessUTXO = 0x00
+	inputWitnessUTXO    = 0x01
+	inputPartialSig     = 0x02
+	inputSighashType    = 0x03
+
+	// maxValueSize limits the size of a single key or value.
+	maxValueSize = 4000000
+)
+
+// KeyValue is a key-value pair of a PSBT map which is not interpreted by this package.
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// PartialSig is a signature of an input by one of its keys.
+type PartialSig struct {
+	PubKey []byte
+	// Signature is DER encoded, followed by the sighash type byte.
+	Signature []byte
+}
+
+// Input contains the data needed to sign an input of the unsigned tx.
+type Input struct {
+	// NonWitnessUTXO is the tx containing the spent output.
+	NonWitnessUTXO *wire.MsgTx
+	// WitnessUTXO is the spent output, only set for segwit inputs.
+	WitnessUTXO *wire.TxOut
+	PartialSigs []*PartialSig
+	// SighashType is the requested sighash type, 0 if not set.
+	SighashType uint32
+	Unknowns    []*KeyValue
+}
+
+// Output contains additional data about an output of the unsigned tx.
+type Output struct {
+	Unknowns []*KeyValue
+}
+
+// Packet is a partially signed transaction.
+type Packet struct {
+	UnsignedTx *wire.MsgTx
+	Unknowns   []*KeyValue
+	Inputs     []*Input
+	Outputs    []*Output
+}
+
+// PreviousOutput returns the output spent by the input at the given index. An error is returned if
+// the PSBT does not contain it.
+func (packet *Packet) PreviousOutput(index int) (*wire.TxOut, error) {
+	input := packet.Inputs[index]
+	outPoint := packet.UnsignedTx.TxIn[index].PreviousOutPoint
+	if input.NonWitnessUTXO != nil {
+		if input.NonWitnessUTXO.TxHash() != outPoint.Hash ||
+			int(outPoint.Index) >= len(input.NonWitnessUTXO.TxOut) {
+			return nil, errp.Newf("input %d: utxo does not match the outpoint", index)
+		}
+		return input.NonWitnessUTXO.TxOut[outPoint.Index], nil
+	}
+	if input.WitnessUTXO != nil {
+		return input.WitnessUTXO, nil
+	}
+	return nil, errp.Newf("input %d: the spent output is missing", index)
+}
+
+// AddPartialSig adds a signature of the input at the given index, replacing an existing signature
+// by the same key.
+func (packet *Packet) AddPartialSig(index int, pubKey []byte, signature []byte) {
+	input := packet.Inputs[index]
+	for _, partialSig := range input.PartialSigs {
+		if bytes.Equal(partialSig.PubKey, pubKey) {
+			partialSig.Signature = signature
+			return
+		}
+	}
+	input.PartialSigs = append(input.PartialSigs, &PartialSig{PubKey: pubKey, Signature: signature})
+}
+
+func readKeyValue(reader io.Reader) (*KeyValue, error) {
+	key, err := wire.ReadVarBytes(reader, 0, maxValueSize, "key")
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if len(key) == 0 {
+		// Separator at the end of a map.
+		return nil, nil
+	}
+	value, err := wire.ReadVarBytes(reader, 0, maxValueSize, "value")
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return &KeyValue{Key: key, Value: value}, nil
+}
+
+// readMap reads the key-value pairs of a map up to the separator. Duplicate keys are rejected.
+func readMap(reader io.Reader) ([]*KeyValue, error) {
+	keyValues := []*KeyValue{}
+	seen := map[string]struct{}{}
+	for {
+		keyValue, err := readKeyValue(reader)
+		if err != nil {
+			return nil, err
+		}
+		if keyValue == nil {
+			return keyValues, nil
+		}
+		if _, ok := seen[string(keyValue.Key)]; ok {
+			return nil, errp.New("duplicate key")
+		}
+		seen[string(keyValue.Key)] = struct{}{}
+		keyValues = append(keyValues, keyValue)
+	}
+}
+
+func parseInput(keyValues []*KeyValue) (*Input, error) {
+	input := &Input{PartialSigs: []*PartialSig{}, Unknowns: []*KeyValue{}}
+	for _, keyValue := range keyValues {
+		keyType, keyData := keyValue.Key[0], keyValue.Key[1:]
+		switch {
+		case keyType == inputNonWitnessUTXO && len(keyData) == 0:
+			tx := &wire.MsgTx{}
+			if err := tx.Deserialize(bytes.NewReader(keyValue.Value)); err != nil {
+				return nil, errp.WithStack(err)
+			}
+			input.NonWitnessUTXO = tx
+		case keyType == inputWitnessUTXO && len(keyData) == 0:
+			reader := bytes.NewReader(keyValue.Value)
+			var value int64
+			if err := binary.Read(reader, binary.LittleEndian, &value); err != nil {
+				return nil, errp.WithStack(err)
+			}
+			pkScript, err := wire.ReadVarBytes(reader, 0, maxValueSize, "pkScript")
+			if err != nil {
+				return nil, errp.WithStack(err)
+			}
+			input.WitnessUTXO = wire.NewTxOut(value, pkScript)
+		case keyType == inputPartialSig && (len(keyData) == 33 || len(keyData) == 65):
+			input.PartialSigs = append(input.PartialSigs,
+				&PartialSig{PubKey: keyData, Signature: keyValue.Value})
+		case keyType == inputSighashType && len(keyData) == 0:
+			if len(keyValue.Value) != 4 {
+				return nil, errp.New("invalid sighash type")
+			}
+			input.SighashType = binary.LittleEndian.Uint32(keyValue.Value)
+		default:
+			input.Unknowns = append(input.Unknowns, keyValue)
+		}
+	}
+	return input, nil
+}
+
+// Decode parses a serialized PSBT.
+func Decode(serialized []byte) (*Packet, error) {
+	if !bytes.HasPrefix(serialized, magic) {
+		return nil, errp.New("not a PSBT")
+	}
+	reader := bytes.NewReader(serialized[len(magic):])
+	globals, err := readMap(reader)
+	if err != nil {
+		return nil, err
+	}
+	packet := &Packet{Unknowns: []*KeyValue{}}
+	for _, keyValue := range globals {
+		if len(keyValue.Key) == 1 && keyValue.Key[0] == globalUnsignedTx {
+			tx := &wire.MsgTx{}
+			if err := tx.DeserializeNoWitness(bytes.NewReader(keyValue.Value)); err != nil {
+				return nil, errp.WithStack(err)
+			}
+			packet.UnsignedTx = tx
+			continue
+		}
+		packet.Unknowns = append(packet.Unknowns, keyValue)
+	}
+	if packet.UnsignedTx == nil {
+		return nil, errp.New("the unsigned tx is missing")
+	}
+	for _, txIn := range packet.UnsignedTx.TxIn {
+		if len(txIn.SignatureScript) != 0 || len(txIn.Witness) != 0 {
+			return nil, errp.New("the unsigned tx must not contain signatures")
+		}
+		keyValues, err := readMap(reader)
+		if err != nil {
+			return nil, err
+		}
+		input, err := parseInput(keyValues)
+		if err != nil {
+			return nil, err
+		}
+		packet.Inputs = append(packet.Inputs, input)
+	}
+	for range packet.UnsignedTx.TxOut {
+		keyValues, err := readMap(reader)
+		if err != nil {
+			return nil, err
+		}
+		packet.Outputs = append(packet.Outputs, &Output{Unknowns: keyValues})
+	}
+	if reader.Len() != 0 {
+		return nil, errp.New("unexpected data after the PSBT")
+	}
+	return packet, nil
+}
+
+// DecodeBase64 parses a base64 encoded PSBT, the usual format of PSBT files and RPC calls.
+func DecodeBase64(encoded string) (*Packet, error) {
+	serialized, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace([]byte(encoded))))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return Decode(serialized)
+}
+
+func writeKeyValue(writer io.Writer, key []byte, value []byte) error {
+	if err := wire.WriteVarBytes(writer, 0, key); err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(wire.WriteVarBytes(writer, 0, value))
+}
+
+func writeMap(writer io.Writer, keyValues []*KeyValue) error {
+	for _, keyValue := range keyValues {
+		if err := writeKeyValue(writer, keyValue.Key, keyValue.Value); err != nil {
+			return err
+		}
+	}
+	_, err := writer.Write([]byte{0})
+	return errp.WithStack(err)
+}
+
+func (input *Input) keyValues() ([]*KeyValue, error) {
+	keyValues := []*KeyValue{}
+	if input.NonWitnessUTXO != nil {
+		buffer := new(bytes.Buffer)
+		if err := input.NonWitnessUTXO.Serialize(buffer); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		keyValues = append(keyValues, &KeyValue{Key: []byte{inputNonWitnessUTXO}, Value: buffer.Bytes()})
+	}
+	if input.WitnessUTXO != nil {
+		buffer := new(bytes.Buffer)
+		if err := binary.Write(buffer, binary.LittleEndian, input.WitnessUTXO.Value); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if err := wire.WriteVarBytes(buffer, 0, input.WitnessUTXO.PkScript); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		keyValues = append(keyValues, &KeyValue{Key: []byte{inputWitnessUTXO}, Value: buffer.Bytes()})
+	}
+	for _, partialSig := range input.PartialSigs {
+		keyValues = append(keyValues, &KeyValue{
+			Key:   append([]byte{inputPartialSig}, partialSig.PubKey...),
+			Value: partialSig.Signature,
+		})
+	}
+	if input.SighashType != 0 {
+		value := make([]byte, 4)
+		binary.LittleEndian.PutUint32(value, input.SighashType)
+		keyValues = append(keyValues, &KeyValue{Key: []byte{inputSighashType}, Value: value})
+	}
+	return append(keyValues, input.Unknowns...), nil
+}
+
+// Encode serializes the PSBT.
+func (packet *Packet) Encode() ([]byte, error) {
+	buffer := bytes.NewBuffer(append([]byte{}, magic...))
+	unsignedTx := new(bytes.Buffer)
+	if err := packet.UnsignedTx.SerializeNoWitness(unsignedTx); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	globals := append(
+		[]*KeyValue{{Key: []byte{globalUnsignedTx}, Value: unsignedTx.Bytes()}}, packet.Unknowns...)
+	if err := writeMap(buffer, globals); err != nil {
+		return nil, err
+	}
+	for _, input := range packet.Inputs {
+		keyValues, err := input.keyValues()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeMap(buffer, keyValues); err != nil {
+			return nil, err
+		}
+	}
+	for _, output := range packet.Outputs {
+		if err := writeMap(buffer, output.Unknowns); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeBase64 serializes the PSBT and encodes it in base64.
+func (packet *Packet) EncodeBase64() (string, error) {
+	serialized, err := packet.Encode()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(serialized), nil
+}
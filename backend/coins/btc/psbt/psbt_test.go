@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psbt_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/stretchr/testify/require"
+)
+
+// bip174Vector is the first valid PSBT of the BIP174 test vectors: one P2PKH input with the full
+// previous tx.
+const bip174Vector = "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmPopXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZQ6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4sAAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3HyppolwuAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSrZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+
+func TestBIP174Vector(t *testing.T) {
+	packet, err := psbt.DecodeBase64(bip174Vector)
+	require.NoError(t, err)
+	require.Len(t, packet.Inputs, 1)
+	require.Len(t, packet.Outputs, 2)
+	require.NotNil(t, packet.Inputs[0].NonWitnessUTXO)
+	previousOutput, err := packet.PreviousOutput(0)
+	require.NoError(t, err)
+	require.Equal(t, int64(200000000), previousOutput.Value)
+	encoded, err := packet.EncodeBase64()
+	require.NoError(t, err)
+	require.Equal(t, bip174Vector, encoded)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	previousTx := wire.NewMsgTx(wire.TxVersion)
+	previousTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.HashH([]byte("a"))}, nil, nil))
+	previousTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: previousTx.TxHash(), Index: 0}, nil, nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.HashH([]byte("b")), Index: 3}, nil, nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.HashH([]byte("c")), Index: 0}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(900, []byte{0x52}))
+
+	packet := &psbt.Packet{
+		UnsignedTx: tx,
+		Unknowns:   []*psbt.KeyValue{{Key: []byte{0xf0, 1}, Value: []byte{2}}},
+		Inputs: []*psbt.Input{
+			{NonWitnessUTXO: previousTx},
+			{WitnessUTXO: wire.NewTxOut(2000, []byte{0x00, 0x14})},
+			{Unknowns: []*psbt.KeyValue{{Key: []byte{0x06, 3}, Value: []byte{4}}}},
+		},
+		Outputs: []*psbt.Output{{Unknowns: []*psbt.KeyValue{}}},
+	}
+	pubKey := make([]byte, 33)
+	packet.AddPartialSig(1, pubKey, []byte{1})
+	packet.AddPartialSig(1, pubKey, []byte{2})
+	require.Len(t, packet.Inputs[1].PartialSigs, 1)
+
+	encoded, err := packet.EncodeBase64()
+	require.NoError(t, err)
+	decoded, err := psbt.DecodeBase64(encoded)
+	require.NoError(t, err)
+	require.Equal(t, tx.TxHash(), decoded.UnsignedTx.TxHash())
+	require.Equal(t, packet.Unknowns, decoded.Unknowns)
+	require.Equal(t, []byte{2}, decoded.Inputs[1].PartialSigs[0].Signature)
+	require.Equal(t, packet.Inputs[2].Unknowns, decoded.Inputs[2].Unknowns)
+
+	previousOutput, err := decoded.PreviousOutput(0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), previousOutput.Value)
+	previousOutput, err = decoded.PreviousOutput(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2000), previousOutput.Value)
+	_, err = decoded.PreviousOutput(2)
+	require.Error(t, err)
+
+	// The previous tx must match the outpoint.
+	packet.UnsignedTx.TxIn[0].PreviousOutPoint.Index = 1
+	_, err = packet.PreviousOutput(0)
+	require.Error(t, err)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	_, err := psbt.DecodeBase64("not base64")
+	require.Error(t, err)
+	_, err = psbt.Decode([]byte("psbu\xff\x00"))
+	require.Error(t, err)
+	// No unsigned tx.
+	_, err = psbt.Decode([]byte("psbt\xff\x00"))
+	require.Error(t, err)
+	// Duplicate key.
+	_, err = psbt.Decode([]byte("psbt\xff\x02\xf0\x01\x00\x02\xf0\x01\x00\x00"))
+	require.Error(t, err)
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+
+	addressesTest "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses/test"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+)
+
+func TestPSBTOwnInputsNonSegwit(t *testing.T) {
+	_, receiveAddresses := addressesTest.NewAddressChain()
+	_, changeAddresses := addressesTest.NewAddressChain()
+	account := &Account{receiveAddresses: receiveAddresses, changeAddresses: changeAddresses}
+	pkScript := receiveAddresses.EnsureAddresses()[0].PubkeyScript()
+
+	previousTx := wire.NewMsgTx(wire.TxVersion)
+	previousTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.HashH([]byte("a"))}, nil, nil))
+	previousTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: previousTx.TxHash()}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(900, []byte{0x51}))
+
+	// The amount of a spent P2PKH output is only trusted if the previous tx is included.
+	packet := &psbt.Packet{
+		UnsignedTx: tx,
+		Inputs:     []*psbt.Input{{WitnessUTXO: wire.NewTxOut(100000, pkScript)}},
+		Outputs:    []*psbt.Output{{}},
+	}
+	_, err := account.PSBTOwnInputs(packet)
+	require.Error(t, err)
+	require.Error(t, account.SignPSBT(packet))
+
+	packet.Inputs[0].NonWitnessUTXO = previousTx
+	ownInputs, err := account.PSBTOwnInputs(packet)
+	require.NoError(t, err)
+	require.Len(t, ownInputs, 1)
+	require.Equal(t, int64(1000), ownInputs[0].Value)
+
+	// Foreign inputs are ignored.
+	packet.Inputs[0] = &psbt.Input{WitnessUTXO: wire.NewTxOut(1000, []byte{0x51})}
+	ownInputs, err = account.PSBTOwnInputs(packet)
+	require.NoError(t, err)
+	require.Empty(t, ownInputs)
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// historyURL returns the daily closing rates of the last `limit` days.
+const historyURL = "https://" + RatesHost + "/data/histoday?fsym=%s&tsym=%s&limit=%d"
+
+// historyDays is the number of days fetched, the maximum supported by the API.
+const historyDays = 2000
+
+// historyMaxAge is how long fetched historical rates are reused before they are fetched again.
+const historyMaxAge = time.Hour
+
+type rateHistoryEntry struct {
+	rates   map[time.Time]float64
+	fetched time.Time
+}
+
+// RateHistory fetches and caches daily historical exchange rates.
+type RateHistory struct {
+	// fetch returns the daily rates of a coin unit in a fiat currency, see DailyRates().
+	fetch     func(unit string, fiat string) (map[time.Time]float64, error)
+	cache     map[string]*rateHistoryEntry
+	cacheLock locker.Locker
+	log       *logrus.Entry
+}
+
+// NewRateHistory creates a new RateHistory.
+func NewRateHistory() *RateHistory {
+	return NewRateHistoryFromSource(fetchDailyRates)
+}
+
+// NewRateHistoryFromSource creates a new RateHistory which gets the daily rates from the given
+// function instead of fetching them from the rates service.
+func NewRateHistoryFromSource(
+	fetch func(unit string, fiat string) (map[time.Time]float64, error)) *RateHistory {
+	return &RateHistory{
+		fetch: fetch,
+		cache: map[string]*rateHistoryEntry{},
+		log:   logging.Get().WithGroup("ratehistory"),
+	}
+}
+
+// DailyRates returns the closing exchange rates of the coin unit (e.g. "BTC") to the fiat
+// currency, keyed by the start of the day in UTC.
+func (history *RateHistory) DailyRates(unit string, fiat string) (map[time.Time]float64, error) {
+	key := unit + "/" + fiat
+	defer history.cacheLock.Lock()()
+	if entry, ok := history.cache[key]; ok && time.Since(entry.fetched) < historyMaxAge {
+		return entry.rates, nil
+	}
+	rates, err := history.fetch(unit, fiat)
+	if err != nil {
+		return nil, err
+	}
+	history.log.WithFields(logrus.Fields{"unit": unit, "fiat": fiat, "days": len(rates)}).
+		Debug("Fetched historical exchange rates")
+	history.cache[key] = &rateHistoryEntry{rates: rates, fetched: time.Now()}
+	return rates, nil
+}
+
+func fetchDailyRates(unit string, fiat string) (map[time.Time]float64, error) {
+	response, err := egress.Get(fmt.Sprintf(historyURL, unit, fiat, historyDays), egress.PurposeRates)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	var result struct {
+		Response string `json:"Response"`
+		Message  string `json:"Message"`
+		Data     []struct {
+			Time  int64   `json:"time"`
+			Close float64 `json:"close"`
+		} `json:"Data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if result.Response != "Success" {
+		return nil, errp.Newf("could not fetch historical rates: %s", result.Message)
+	}
+	rates := make(map[time.Time]float64, len(result.Data))
+	for _, entry := range result.Data {
+		rates[time.Unix(entry.Time, 0).UTC()] = entry.Close
+	}
+	return rates, nil
+}
@@ -17,7 +17,6 @@ package btc
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"reflect"
 	"strings"
 	"time"
@@ -25,6 +24,8 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/sirupsen/logrus"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
@@ -34,37 +35,65 @@ var coins = []string{"BTC", "LTC"}
 var fiats = []string{"USD", "EUR", "CHF", "GBP", "JPY", "KRW", "CNY", "RUB"}
 
 const interval = time.Minute
-const url = "https://min-api.cryptocompare.com/data/pricemulti?fsyms=%s&tsyms=%s"
+
+// RatesHost is the host serving the exchange rates and their history.
+const RatesHost = "min-api.cryptocompare.com"
+
+const url = "https://" + RatesHost + "/data/pricemulti?fsyms=%s&tsyms=%s"
+const source = "cryptocompare.com"
 
 // RatesUpdater implements coin.RatesUpdater.
 type RatesUpdater struct {
 	observable.Implementation
-	last map[string]map[string]float64
+	// last is replaced, never modified, so that the maps returned by Last() can be used without
+	// holding the lock.
+	last       map[string]map[string]float64
+	lastUpdate time.Time
+	// lastStreamUpdate is the time the last price update was streamed, see streamRates().
+	lastStreamUpdate time.Time
+	// lock guards last, lastUpdate and lastStreamUpdate.
+	lock locker.Locker
 	log  *logrus.Entry
 }
 
-// NewRatesUpdater returns a new rates updater.
-func NewRatesUpdater() *RatesUpdater {
+// NewRatesUpdater returns a new rates updater, which polls the rates. If a streaming API key is
+// given, the rates are also streamed, and polled less often while the stream is live.
+func NewRatesUpdater(streamAPIKey string) *RatesUpdater {
 	updater := &RatesUpdater{
 		last: map[string]map[string]float64{},
 		log:  logging.Get().WithGroup("rates"),
 	}
 	go updater.start()
+	if streamAPIKey != "" {
+		go updater.stream(ratesStreamURL + "?api_key=" + streamAPIKey)
+	}
 	return updater
 }
 
 // Last returns the last rates for a given coin and fiat or nil if not available.
 func (updater *RatesUpdater) Last() map[string]map[string]float64 {
+	defer updater.lock.RLock()()
 	return updater.last
 }
 
+// LastUpdate implements coin.RatesUpdater.
+func (updater *RatesUpdater) LastUpdate() time.Time {
+	defer updater.lock.RLock()()
+	return updater.lastUpdate
+}
+
+// Source implements coin.RatesUpdater.
+func (updater *RatesUpdater) Source() string {
+	return source
+}
+
 func (updater *RatesUpdater) update() {
-	response, err := http.Get(fmt.Sprintf(url,
+	response, err := egress.Get(fmt.Sprintf(url,
 		strings.Join(coins, ","),
 		strings.Join(fiats, ","),
-	))
+	), egress.PurposeRates)
 	if err != nil {
-		updater.last = nil
+		updater.replace(nil)
 		return
 	}
 	defer func() {
@@ -74,15 +103,27 @@ func (updater *RatesUpdater) update() {
 	var rates map[string]map[string]float64
 	err = json.NewDecoder(response.Body).Decode(&rates)
 	if err != nil {
-		updater.last = nil
+		updater.replace(nil)
 		return
 	}
+	updater.replace(rates)
+}
 
+// replace sets the rates and notifies the observers if they changed.
+func (updater *RatesUpdater) replace(rates map[string]map[string]float64) {
+	unlock := updater.lock.Lock()
+	if rates == nil {
+		updater.last = nil
+		unlock()
+		return
+	}
+	updater.lastUpdate = time.Now()
 	if reflect.DeepEqual(rates, updater.last) {
+		unlock()
 		return
 	}
-
 	updater.last = rates
+	unlock()
 	updater.log.WithField("data", spew.Sprintf("%v", rates)).Debug("Exchange rates changed.")
 	updater.Notify(observable.Event{
 		Subject: "coins/rates",
@@ -91,9 +132,19 @@ func (updater *RatesUpdater) update() {
 	})
 }
 
+// streaming returns true if a price update was streamed recently.
+func (updater *RatesUpdater) streaming() bool {
+	defer updater.lock.RLock()()
+	return time.Since(updater.lastStreamUpdate) < interval
+}
+
 func (updater *RatesUpdater) start() {
 	for {
 		updater.update()
 		time.Sleep(interval)
+		// The streamed prices are as fresh, only poll now and then for the pairs not streamed.
+		for i := time.Duration(0); i < streamingPollInterval/interval-1 && updater.streaming(); i++ {
+			time.Sleep(interval)
+		}
 	}
 }
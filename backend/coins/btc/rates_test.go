@@ -0,0 +1,51 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// TestRatesLastUpdate checks that the update time is set with the rates and can be read while the
+// rates are updated. Run with -race.
+func TestRatesLastUpdate(t *testing.T) {
+	updater := &RatesUpdater{log: logging.Get().WithGroup("rates")}
+	require.True(t, updater.LastUpdate().IsZero())
+
+	before := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			updater.replace(map[string]map[string]float64{"BTC": {"USD": float64(i)}})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = updater.LastUpdate()
+		}
+	}()
+	wg.Wait()
+	require.False(t, updater.LastUpdate().Before(before))
+	require.Equal(t, 99.0, updater.Last()["BTC"]["USD"])
+}
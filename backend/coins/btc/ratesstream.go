@@ -0,0 +1,156 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
+)
+
+// RatesStreamHost is the host streaming the exchange rates.
+const RatesStreamHost = "streamer.cryptocompare.com"
+
+const (
+	ratesStreamURL = "wss://" + RatesStreamHost + "/v2"
+	// streamingPollInterval is how often the rates are polled while they are streamed.
+	streamingPollInterval = 10 * time.Minute
+	// minStreamBackoff and maxStreamBackoff bound the delay before reconnecting a failed stream.
+	minStreamBackoff = 5 * time.Second
+	maxStreamBackoff = 30 * time.Minute
+	// streamReadTimeout is how long the stream may be silent. The provider sends heartbeats.
+	streamReadTimeout = 2 * time.Minute
+)
+
+// streamMessage is a message of the stream. Price updates have the type "5", errors a type
+// starting with "4" or "5" followed by more digits, e.g. "401".
+type streamMessage struct {
+	Type       string   `json:"TYPE"`
+	FromSymbol string   `json:"FROMSYMBOL"`
+	ToSymbol   string   `json:"TOSYMBOL"`
+	Price      *float64 `json:"PRICE"`
+	Message    string   `json:"MESSAGE"`
+}
+
+// streamSubscriptions returns the subscriptions to the aggregated prices of all coins and fiats.
+func streamSubscriptions() []string {
+	subscriptions := []string{}
+	for _, coin := range coins {
+		for _, fiat := range fiats {
+			subscriptions = append(subscriptions, "5~CCCAGG~"+coin+"~"+fiat)
+		}
+	}
+	return subscriptions
+}
+
+// applyStreamUpdate sets the streamed price of one pair and notifies the observers. Until the
+// rates have been polled once, the streamed prices are dropped, as a partial set of rates would
+// be shown as complete.
+func (updater *RatesUpdater) applyStreamUpdate(coin, fiat string, price float64) {
+	unlock := updater.lock.Lock()
+	updater.lastStreamUpdate = time.Now()
+	if len(updater.last) == 0 || updater.last[coin][fiat] == price {
+		unlock()
+		return
+	}
+	rates := make(map[string]map[string]float64, len(updater.last))
+	for lastCoin, lastRates := range updater.last {
+		rates[lastCoin] = lastRates
+	}
+	coinRates := make(map[string]float64, len(rates[coin])+1)
+	for lastFiat, rate := range rates[coin] {
+		coinRates[lastFiat] = rate
+	}
+	coinRates[fiat] = price
+	rates[coin] = coinRates
+	updater.last = rates
+	updater.lastUpdate = time.Now()
+	unlock()
+	updater.Notify(observable.Event{
+		Subject: "coins/rates",
+		Action:  action.Replace,
+		Object:  rates,
+	})
+}
+
+// streamOnce connects to the stream and applies the price updates until the connection fails.
+func (updater *RatesUpdater) streamOnce(url string) error {
+	dialer := websocket.Dialer{
+		NetDial: func(network, address string) (net.Conn, error) {
+			return egress.Dial(network, address, egress.PurposeRates)
+		},
+		TLSClientConfig:  &tls.Config{},
+		HandshakeTimeout: 30 * time.Second,
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.WriteJSON(map[string]interface{}{
+		"action": "SubAdd",
+		"subs":   streamSubscriptions(),
+	}); err != nil {
+		return errp.WithStack(err)
+	}
+	updater.log.Info("Streaming exchange rates")
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(streamReadTimeout)); err != nil {
+			return errp.WithStack(err)
+		}
+		var message streamMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return errp.WithStack(err)
+		}
+		switch {
+		case message.Type == "5":
+			if message.Price != nil {
+				updater.applyStreamUpdate(message.FromSymbol, message.ToSymbol, *message.Price)
+			}
+		case len(message.Type) == 3 && strings.ContainsAny(message.Type[:1], "45"):
+			return errp.Newf("rates stream error %s: %s", message.Type, message.Message)
+		}
+	}
+}
+
+// stream streams the rates forever, reconnecting with an exponential backoff. In the meantime, the
+// rates are polled as usual.
+func (updater *RatesUpdater) stream(url string) {
+	backoff := minStreamBackoff
+	for {
+		start := time.Now()
+		err := updater.streamOnce(url)
+		if time.Since(start) > interval {
+			// The stream was live for a while, so the failure is not persistent.
+			backoff = minStreamBackoff
+		}
+		updater.log.WithError(err).WithFields(logrus.Fields{"retry-in": backoff}).Warning(
+			"Exchange rates stream failed, falling back to polling")
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
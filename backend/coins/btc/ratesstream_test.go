@@ -0,0 +1,86 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+)
+
+func TestStreamRates(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		var subscription struct {
+			Action string   `json:"action"`
+			Subs   []string `json:"subs"`
+		}
+		require.NoError(t, conn.ReadJSON(&subscription))
+		require.Equal(t, "SubAdd", subscription.Action)
+		require.Contains(t, subscription.Subs, "5~CCCAGG~BTC~USD")
+		for _, message := range []string{
+			`{"TYPE":"20","MESSAGE":"STREAMERWELCOME"}`,
+			`{"TYPE":"5","FROMSYMBOL":"BTC","TOSYMBOL":"USD","PRICE":6500.5}`,
+			// Updates without a price change have no price.
+			`{"TYPE":"5","FROMSYMBOL":"BTC","TOSYMBOL":"EUR","VOLUMEDAY":1}`,
+			`{"TYPE":"999","MESSAGE":"HEARTBEAT"}`,
+			`{"TYPE":"5","FROMSYMBOL":"LTC","TOSYMBOL":"CHF","PRICE":50}`,
+			`{"TYPE":"401","MESSAGE":"UNAUTHORIZED"}`,
+		} {
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(message)))
+		}
+	}))
+	defer server.Close()
+
+	updater := &RatesUpdater{
+		last: map[string]map[string]float64{
+			"BTC": {"USD": 6400, "EUR": 5500},
+			"LTC": {"USD": 55},
+		},
+		log: logging.Get().WithGroup("rates"),
+	}
+	previous := updater.Last()
+	events := 0
+	updater.Observe(func(event observable.Event) {
+		require.Equal(t, "coins/rates", event.Subject)
+		events++
+	})
+	err := updater.streamOnce("ws" + strings.TrimPrefix(server.URL, "http"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "UNAUTHORIZED")
+	require.Equal(t, 2, events)
+	require.Equal(t, map[string]map[string]float64{
+		"BTC": {"USD": 6500.5, "EUR": 5500},
+		"LTC": {"USD": 55, "CHF": 50},
+	}, updater.Last())
+	// The maps returned earlier are not modified.
+	require.Equal(t, 6400.0, previous["BTC"]["USD"])
+	require.True(t, updater.streaming())
+
+	// Streamed prices are dropped until the rates were polled.
+	updater = &RatesUpdater{log: logging.Get().WithGroup("rates")}
+	updater.applyStreamUpdate("BTC", "USD", 1)
+	require.Empty(t, updater.Last())
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"os"
+	"path"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Reset closes the account and deletes its transactions database, which holds the transaction
+// history, the UTXOs, the address histories and the balance snapshots. The vault, the watched
+// transactions and the invoices are kept, as they can not be recovered from the blockchain.
+//
+// It returns a new, uninitialized account with the same configuration, which rebuilds the data from
+// the blockchain when it is initialized. The new account is returned even if deleting the database
+// failed, as the closed account can not be used anymore.
+func (account *Account) Reset() (*Account, error) {
+	signingConfiguration := func() *signing.Configuration {
+		defer account.RLock()()
+		return account.signingConfiguration
+	}()
+	account.Close()
+	fresh := NewAccount(account.coin, account.dbFolder, account.code, account.name,
+		account.getSigningConfiguration, account.keystores, account.config, account.denylist,
+		account.onEvent, account.log)
+	if signingConfiguration == nil {
+		// The account was not initialized, so the name of the database is not known yet.
+		var err error
+		signingConfiguration, err = account.getSigningConfiguration()
+		if err != nil {
+			return fresh, err
+		}
+	}
+	dbFilename := path.Join(account.dbFolder, account.transactionsDBName(signingConfiguration))
+	if err := os.Remove(dbFilename); err != nil && !os.IsNotExist(err) {
+		return fresh, errp.WithStack(err)
+	}
+	account.log.Info("Deleted the transactions database")
+	return fresh, nil
+}
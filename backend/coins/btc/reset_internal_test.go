@@ -0,0 +1,66 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+func TestReset(t *testing.T) {
+	dbFolder, err := ioutil.TempDir("", "btc_reset_test")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbFolder) }()
+
+	xpub, err := hdkeychain.NewKeyFromString("tpubDEXZPZzoVxHQdZg6ndWKoDXwsPtfTKpYsF6SDCm2dHxydcNvoKM58RmA7FDj3hXqy8BrxfwoTNaV5SzWgCzurTaQmDNywHVvv5tPSj6Evgr")
+	require.NoError(t, err)
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/1'/0'")
+	require.NoError(t, err)
+	signingConfiguration := signing.NewSinglesigConfiguration(signing.ScriptTypeP2WPKH, keypath, xpub)
+	getSigningConfiguration := func() (*signing.Configuration, error) {
+		return signingConfiguration, nil
+	}
+	coin := NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, dbFolder, nil, "", nil)
+	account := NewAccount(coin, dbFolder, "tbtc-p2wpkh", "Bitcoin Testnet: bech32",
+		getSigningConfiguration, nil, nil, nil, func(Event) {}, logging.Get().WithGroup("btc_test"))
+
+	dbFilename := path.Join(dbFolder, account.transactionsDBName(signingConfiguration))
+	require.NoError(t, ioutil.WriteFile(dbFilename, []byte("corrupt"), 0600))
+	otherFilename := path.Join(dbFolder, "account-other.db")
+	require.NoError(t, ioutil.WriteFile(otherFilename, []byte("other"), 0600))
+
+	fresh, err := account.Reset()
+	require.NoError(t, err)
+	require.False(t, account == fresh)
+	require.Equal(t, account.Code(), fresh.Code())
+	require.Nil(t, fresh.signingConfiguration)
+	_, err = os.Stat(dbFilename)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(otherFilename)
+	require.NoError(t, err)
+
+	// Resetting an account without data succeeds.
+	_, err = fresh.Reset()
+	require.NoError(t, err)
+}
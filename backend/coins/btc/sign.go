@@ -37,17 +37,18 @@ type ProposedTransaction struct {
 	// Signatures collects the signatures (signatures[transactionInput][cosignerIndex]).
 	Signatures [][]*btcec.Signature
 	SigHashes  *txscript.TxSigHashes
+	// SkipInputs contains the indices of the inputs which are not signed, because they don't belong
+	// to the wallet, e.g. when cosigning an external PSBT. They are not in PreviousOutputs.
+	SkipInputs map[int]struct{}
 }
 
-// SignTransaction signs all inputs. It assumes all outputs spent belong to this
-// wallet. previousOutputs must contain all outputs which are spent by the transaction.
-func SignTransaction(
+// newProposedTransaction prepares the transaction for signing by the keystores.
+func newProposedTransaction(
 	keystores keystore.Keystores,
 	txProposal *maketx.TxProposal,
 	previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
 	getAddress func(blockchain.ScriptHashHex) *addresses.AccountAddress,
-	log *logrus.Entry,
-) error {
+) *ProposedTransaction {
 	proposedTransaction := &ProposedTransaction{
 		TXProposal:      txProposal,
 		PreviousOutputs: previousOutputs,
@@ -59,24 +60,40 @@ func SignTransaction(
 	for i := range proposedTransaction.Signatures {
 		proposedTransaction.Signatures[i] = make([]*btcec.Signature, keystores.Count()) // TODO: Replace count with configuration.NumberOfSigners()
 	}
+	return proposedTransaction
+}
 
-	if err := keystores.SignTransaction(proposedTransaction); err != nil {
-		return err
-	}
-
+// finalize adds the signatures collected from the keystores to the inputs.
+func (proposedTransaction *ProposedTransaction) finalize(log *logrus.Entry) {
+	txProposal := proposedTransaction.TXProposal
 	for index, input := range txProposal.Transaction.TxIn {
-		spentOutput := previousOutputs[input.PreviousOutPoint]
+		spentOutput := proposedTransaction.PreviousOutputs[input.PreviousOutPoint]
 		address := proposedTransaction.GetAddress(spentOutput.ScriptHashHex())
 		input.SignatureScript, input.Witness = address.SignatureScript(
 			proposedTransaction.Signatures[index])
 	}
 
 	// Sanity check: see if the created transaction is valid.
-	if err := txValidityCheck(txProposal.Transaction, previousOutputs,
+	if err := txValidityCheck(txProposal.Transaction, proposedTransaction.PreviousOutputs,
 		proposedTransaction.SigHashes); err != nil {
 		log.WithError(err).Panic("Failed to pass transaction validity check.")
 	}
+}
 
+// SignTransaction signs all inputs. It assumes all outputs spent belong to this
+// wallet. previousOutputs must contain all outputs which are spent by the transaction.
+func SignTransaction(
+	keystores keystore.Keystores,
+	txProposal *maketx.TxProposal,
+	previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
+	getAddress func(blockchain.ScriptHashHex) *addresses.AccountAddress,
+	log *logrus.Entry,
+) error {
+	proposedTransaction := newProposedTransaction(keystores, txProposal, previousOutputs, getAddress)
+	if err := keystores.SignTransaction(proposedTransaction); err != nil {
+		return err
+	}
+	proposedTransaction.finalize(log)
 	return nil
 }
 
@@ -0,0 +1,114 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	bitcoinMessageMagic  = "Bitcoin Signed Message:\n"
+	litecoinMessageMagic = "Litecoin Signed Message:\n"
+
+	// compactSignatureHeader is the first byte of a compact signature of a compressed key for
+	// recovery ID 0, as returned by btcec.SignCompact().
+	compactSignatureHeader = 31
+)
+
+// messageSignatureHeaders are the first bytes of BIP137 signatures for recovery ID 0, by script
+// type.
+var messageSignatureHeaders = map[signing.ScriptType]byte{
+	signing.ScriptTypeP2PKH:      31,
+	signing.ScriptTypeP2WPKHP2SH: 35,
+	signing.ScriptTypeP2WPKH:     39,
+}
+
+// SignedMessageHash returns the hash which is signed to sign the message with a key of the coin,
+// the double SHA256 hash of the magic of the coin and the message.
+func SignedMessageHash(coin *Coin, message []byte) []byte {
+	magic := bitcoinMessageMagic
+	if coin.Net().Net == ltc.MainNetParams.Net || coin.Net().Net == ltc.TestNet4Params.Net {
+		magic = litecoinMessageMagic
+	}
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, magic)
+	_ = wire.WriteVarBytes(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// SetMessageSignatureHeader turns a compact signature of a compressed key, as returned by
+// btcec.SignCompact(), into a BIP137 signature for an address of the given script type.
+func SetMessageSignatureHeader(signature []byte, scriptType signing.ScriptType) error {
+	header, ok := messageSignatureHeaders[scriptType]
+	if !ok {
+		return errp.Newf("messages can't be signed for %s addresses", scriptType)
+	}
+	if len(signature) != 65 || signature[0] < compactSignatureHeader ||
+		signature[0] > compactSignatureHeader+3 {
+		return errp.New("invalid compact signature")
+	}
+	signature[0] = header + signature[0] - compactSignatureHeader
+	return nil
+}
+
+// SignMessage signs the message with the key of the given address of the account, e.g. to prove
+// the ownership of the address. The signature is base64 encoded, see BIP137.
+func (account *Account) SignMessage(address string, message string) (string, error) {
+	if !account.keystores.CanSignMessages() {
+		return "", errp.New("The keystore can't sign messages")
+	}
+	decodedAddress, err := btcutil.DecodeAddress(address, account.coin.Net())
+	if err != nil || !decodedAddress.IsForNet(account.coin.Net()) {
+		return "", errp.WithStack(TxValidationError("invalid address"))
+	}
+	pkScript, err := txscript.PayToAddrScript(decodedAddress)
+	if err != nil {
+		return "", errp.WithStack(TxValidationError("invalid address"))
+	}
+	scriptHashHex := (&transactions.SpendableOutput{TxOut: wire.NewTxOut(0, pkScript)}).ScriptHashHex()
+	var accountAddress *addresses.AccountAddress
+	func() {
+		defer account.RLock()()
+		accountAddress = account.receiveAddresses.LookupByScriptHashHex(scriptHashHex)
+		if accountAddress == nil {
+			accountAddress = account.changeAddresses.LookupByScriptHashHex(scriptHashHex)
+		}
+	}()
+	if accountAddress == nil {
+		return "", errp.WithStack(TxValidationError("the address does not belong to the account"))
+	}
+	configuration := accountAddress.Configuration
+	if configuration.Multisig() {
+		return "", errp.New("Messages can't be signed with multisig addresses")
+	}
+	signature, err := account.keystores.SignMessage(configuration.AbsoluteKeypath(),
+		configuration.ScriptType(), account.coin, []byte(message))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+)
+
+func TestSignMessage(t *testing.T) {
+	master, err := hdkeychain.NewMaster(make([]byte, 32), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	keystore := software.NewKeystore(0, master)
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/0'/0'/0/0")
+	require.NoError(t, err)
+	extendedKey, err := keystore.ExtendedPublicKey(keypath)
+	require.NoError(t, err)
+	publicKey, err := extendedKey.ECPubKey()
+	require.NoError(t, err)
+
+	coin := btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, ".", nil, "", nil)
+	message := []byte("This is an example of a signed message.")
+	for scriptType, headers := range map[signing.ScriptType][2]byte{
+		signing.ScriptTypeP2PKH:      {31, 34},
+		signing.ScriptTypeP2WPKHP2SH: {35, 38},
+		signing.ScriptTypeP2WPKH:     {39, 42},
+	} {
+		signature, err := keystore.SignMessage(keypath, scriptType, coin, message)
+		require.NoError(t, err)
+		require.Len(t, signature, 65)
+		require.True(t, signature[0] >= headers[0] && signature[0] <= headers[1])
+
+		// The key can be recovered from the signature with the recovery ID.
+		compact := append([]byte{27 + 4 + (signature[0] - headers[0])}, signature[1:]...)
+		recovered, compressed, err := btcec.RecoverCompact(
+			btcec.S256(), compact, btc.SignedMessageHash(coin, message))
+		require.NoError(t, err)
+		require.True(t, compressed)
+		require.True(t, recovered.IsEqual(publicKey))
+	}
+	_, err = keystore.SignMessage(keypath, signing.ScriptType("p2wsh"), coin, message)
+	require.Error(t, err)
+
+	// Litecoin uses its own magic, so that a message signed for one coin is not valid for the other.
+	ltcCoin := btc.NewCoin("ltc", "LTC", &ltc.MainNetParams, ".", nil, "", nil)
+	require.NotEqual(t,
+		btc.SignedMessageHash(coin, message), btc.SignedMessageHash(ltcCoin, message))
+}
@@ -15,17 +15,26 @@
 package btc
 
 import (
+	"unicode/utf8"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	coinpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 )
 
+// maxTxNoteLength is the maximum number of characters of a tx note or memo.
+const maxTxNoteLength = 64
+
 // TxValidationError represents errors in the tx proposal input data.
 type TxValidationError string
 
@@ -33,6 +42,15 @@ func (err TxValidationError) Error() string {
 	return string(err)
 }
 
+// TxWarning is a warning about a tx proposal which the user should confirm before sending.
+type TxWarning string
+
+const (
+	// TxWarningCoinjoinMerge means that the tx spends mixed coinjoin outputs together with
+	// non-mixed ones, undoing the privacy gained by the coinjoin.
+	TxWarningCoinjoinMerge TxWarning = "coinjoinMerge"
+)
+
 // SendAmount is either a concrete amount, or "all"/"max".
 type SendAmount struct {
 	amount  btcutil.Amount
@@ -52,44 +70,77 @@ func NewSendAmountAll() SendAmount {
 	return SendAmount{amount: 0, sendAll: true}
 }
 
+func (account *Account) feeTarget(feeTargetCode FeeTargetCode) *FeeTarget {
+	for _, target := range account.feeTargets {
+		if target.Code == feeTargetCode {
+			return target
+		}
+	}
+	return nil
+}
+
 // newTx creates a new tx to the given recipient address. It also returns a set of used account
 // outputs, which contains all outputs that spent in the tx. Those are needed to be able to sign the
 // transaction. selectedUTXOs restricts the available coins; if empty, no restriction is applied and
-// all unspent coins can be used.
+// all unspent coins can be used. maketx.ErrFeeCapExceeded is returned if the fee is above the
+// configured cap, unless overrideFeeCap is true. The tx signals replaceability (BIP125) unless
+// disableRBF is true.
 func (account *Account) newTx(
 	recipientAddress string,
 	amount SendAmount,
 	feeTargetCode FeeTargetCode,
 	selectedUTXOs map[wire.OutPoint]struct{},
+	overrideFeeCap bool,
+	disableRBF bool,
 ) (
 	map[wire.OutPoint]*transactions.SpendableOutput, *maketx.TxProposal, error) {
 
 	account.log.Debug("Prepare new transaction")
 
-	address, err := btcutil.DecodeAddress(recipientAddress, account.coin.Net())
+	address, err := account.decodeRecipientAddress(recipientAddress)
 	if err != nil {
-		return nil, nil, errp.WithStack(TxValidationError("invalid address"))
-	}
-	if !address.IsForNet(account.coin.Net()) {
-		return nil, nil, errp.WithStack(TxValidationError("invalid address"))
+		return nil, nil, err
 	}
 
-	var feeTarget *FeeTarget
-	for _, target := range account.feeTargets {
-		if target.Code == feeTargetCode {
-			feeTarget = target
-			break
-		}
-	}
+	feeTarget := account.feeTarget(feeTargetCode)
 	if feeTarget == nil || feeTarget.FeeRatePerKb == nil {
 		return nil, nil, errp.New("Fee could not be estimated")
 	}
+	return account.newTxWithFeeRate(
+		address, amount, *feeTarget.FeeRatePerKb, selectedUTXOs, overrideFeeCap, disableRBF)
+}
+
+// decodeRecipientAddress decodes the address and checks that it belongs to the network of the
+// account's coin.
+func (account *Account) decodeRecipientAddress(recipientAddress string) (btcutil.Address, error) {
+	address, err := btcutil.DecodeAddress(recipientAddress, account.coin.Net())
+	if err != nil {
+		return nil, errp.WithStack(TxValidationError("invalid address"))
+	}
+	if !address.IsForNet(account.coin.Net()) {
+		return nil, errp.WithStack(TxValidationError("invalid address"))
+	}
+	return address, nil
+}
+
+// newTxWithFeeRate is like newTx, but uses the given fee rate instead of the estimate of a fee
+// target.
+func (account *Account) newTxWithFeeRate(
+	address btcutil.Address,
+	amount SendAmount,
+	feeRatePerKb btcutil.Amount,
+	selectedUTXOs map[wire.OutPoint]struct{},
+	overrideFeeCap bool,
+	disableRBF bool,
+) (
+	map[wire.OutPoint]*transactions.SpendableOutput, *maketx.TxProposal, error) {
 
 	pkScript, err := txscript.PayToAddrScript(address)
 	if err != nil {
 		return nil, nil, errp.WithStack(err)
 	}
 	utxo := account.transactions.SpendableOutputs()
+	reserved := account.offlineTxs.Reserved()
 	wireUTXO := make(map[wire.OutPoint]*wire.TxOut, len(utxo))
 	for outPoint, txOut := range utxo {
 		// Apply coin control.
@@ -98,6 +149,18 @@ func (account *Account) newTx(
 				continue
 			}
 		}
+		// Vaulted coins can only be spent by unvaulting them first.
+		if account.isVaulted(outPoint) {
+			continue
+		}
+		// Coins received to the payment code can't be signed for yet.
+		if account.isPaymentCodeOutput(txOut.ScriptHashHex()) {
+			continue
+		}
+		// Coins spent by a tx signed offline are reserved until it is broadcast or canceled.
+		if _, ok := reserved[outPoint]; ok {
+			continue
+		}
 		wireUTXO[outPoint] = txOut.TxOut
 	}
 	var txProposal *maketx.TxProposal
@@ -107,7 +170,7 @@ func (account *Account) newTx(
 			account.signingConfiguration,
 			wireUTXO,
 			pkScript,
-			*feeTarget.FeeRatePerKb,
+			feeRatePerKb,
 			account.log,
 		)
 		if err != nil {
@@ -119,7 +182,7 @@ func (account *Account) newTx(
 			account.signingConfiguration,
 			wireUTXO,
 			wire.NewTxOut(int64(amount.amount), pkScript),
-			*feeTarget.FeeRatePerKb,
+			feeRatePerKb,
 			func() *addresses.AccountAddress {
 				return account.changeAddresses.GetUnused()[0]
 			},
@@ -129,52 +192,317 @@ func (account *Account) newTx(
 			return nil, nil, err
 		}
 	}
+	if !overrideFeeCap {
+		feeCap := account.config.Config().Backend.FeeCap
+		err := maketx.FeeCap{
+			MaxFee:        btcutil.Amount(feeCap.MaxFee),
+			MaxFeePercent: feeCap.MaxFeePercent,
+		}.Check(txProposal)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !disableRBF {
+		// Signal replaceability, so the fee can be bumped if the tx gets stuck.
+		maketx.SetRBF(txProposal.Transaction)
+	}
+	txProposal.AccountName = account.DisplayName()
 	account.log.Debugf("creating tx with %d inputs, %d outputs", len(txProposal.Transaction.TxIn), len(txProposal.Transaction.TxOut))
 	return utxo, txProposal, nil
 }
 
-// SendTx creates, signs and sends tx which sends `amount` to the recipient.
+// getAddress returns the account address with the given script hash. It panics if the address
+// does not belong to the account.
+func (account *Account) getAddress(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
+	if address := account.receiveAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+		return address
+	}
+	if address := account.changeAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+		return address
+	}
+	panic("address must be present")
+}
+
+// checkTxPolicy checks the tx proposal against the rules of maketx.CheckPolicy before it is
+// signed. overrideFeeCap is true if the user confirmed a fee above the fee cap.
+func (account *Account) checkTxPolicy(
+	txProposal *maketx.TxProposal,
+	recipientOutputs []*wire.TxOut,
+	previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
+	overrideFeeCap bool,
+) error {
+	wirePreviousOutputs := make(map[wire.OutPoint]*wire.TxOut, len(previousOutputs))
+	for outPoint, output := range previousOutputs {
+		wirePreviousOutputs[outPoint] = output.TxOut
+	}
+	isOwnChange := func(pkScript []byte) bool {
+		scriptHashHex := (&transactions.SpendableOutput{TxOut: wire.NewTxOut(0, pkScript)}).ScriptHashHex()
+		return account.changeAddresses.LookupByScriptHashHex(scriptHashHex) != nil
+	}
+	if err := maketx.CheckPolicy(
+		txProposal, recipientOutputs, wirePreviousOutputs, isOwnChange, overrideFeeCap); err != nil {
+		account.log.WithError(err).Error("Transaction rejected before signing")
+		return err
+	}
+	return nil
+}
+
+// validateTxNote checks that the note is short enough to be displayed during confirmation.
+func validateTxNote(note string) error {
+	if utf8.RuneCountInString(note) > maxTxNoteLength {
+		return errp.WithStack(TxValidationError("memo too long"))
+	}
+	return nil
+}
+
+// SendTx creates, signs and sends tx which sends `amount` to the recipient. The optional memo is
+// shown during confirmation on keystores supporting it, and stored as the note of the tx. If
+// fiatUnit is not empty, the amount converted to this fiat currency is shown during confirmation
+// as well. overrideFeeCap must be true to send a tx with a fee above the configured cap. If
+// disableRBF is true, the tx does not signal replaceability, as some merchants treat such
+// unconfirmed payments differently. Its fee can't be bumped then.
 func (account *Account) SendTx(
 	recipientAddress string,
 	amount SendAmount,
 	feeTargetCode FeeTargetCode,
 	selectedUTXOs map[wire.OutPoint]struct{},
+	memo string,
+	fiatUnit string,
+	overrideFeeCap bool,
+	disableRBF bool,
 ) error {
 	account.log.Info("Sending transaction")
+	preparedTx, err := account.PrepareTx(recipientAddress, amount, feeTargetCode, selectedUTXOs,
+		memo, fiatUnit, overrideFeeCap, disableRBF)
+	if err != nil {
+		return err
+	}
+	if err := account.keystores.SignTransaction(preparedTx.proposedTransaction); err != nil {
+		return errp.WithMessage(err, "Failed to sign transaction")
+	}
+	return preparedTx.Broadcast()
+}
+
+// PreparedTx is a validated, unsigned transaction. See PrepareTx().
+type PreparedTx struct {
+	account             *Account
+	proposedTransaction *ProposedTransaction
+	memo                string
+}
+
+// Account returns the account spending the transaction.
+func (preparedTx *PreparedTx) Account() *Account {
+	return preparedTx.account
+}
+
+// TxProposal returns the unsigned transaction.
+func (preparedTx *PreparedTx) TxProposal() *maketx.TxProposal {
+	return preparedTx.proposedTransaction.TXProposal
+}
+
+// PrepareTx creates and validates the tx, but does not sign it, so that it can be signed together
+// with the transactions of other accounts, see SignTransactions(). The arguments are the same as
+// for SendTx().
+func (account *Account) PrepareTx(
+	recipientAddress string,
+	amount SendAmount,
+	feeTargetCode FeeTargetCode,
+	selectedUTXOs map[wire.OutPoint]struct{},
+	memo string,
+	fiatUnit string,
+	overrideFeeCap bool,
+	disableRBF bool,
+) (*PreparedTx, error) {
+	if err := validateTxNote(memo); err != nil {
+		return nil, err
+	}
 	utxo, txProposal, err := account.newTx(
 		recipientAddress,
 		amount,
 		feeTargetCode,
 		selectedUTXOs,
+		overrideFeeCap,
+		disableRBF,
 	)
 	if err != nil {
-		return errp.WithMessage(err, "Failed to create transaction")
+		return nil, errp.WithMessage(err, "Failed to create transaction")
 	}
-	getAddress := func(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
-		if address := account.receiveAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
-			return address
-		}
-		if address := account.changeAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
-			return address
+	txProposal.Memo = memo
+	txProposal.Fiat = account.coin.fiatConversion(txProposal.Amount, fiatUnit)
+	address, err := btcutil.DecodeAddress(recipientAddress, account.coin.Net())
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	recipientAmount := amount.amount
+	if amount.sendAll {
+		recipientAmount = txProposal.Amount
+	}
+	recipientOutputs := []*wire.TxOut{wire.NewTxOut(int64(recipientAmount), pkScript)}
+	if err := account.checkTxPolicy(txProposal, recipientOutputs, utxo, overrideFeeCap); err != nil {
+		return nil, err
+	}
+	return &PreparedTx{
+		account:             account,
+		proposedTransaction: newProposedTransaction(account.keystores, txProposal, utxo, account.getAddress),
+		memo:                memo,
+	}, nil
+}
+
+// SignTransactions signs the prepared transactions of accounts sharing the same keystores. Hardware
+// keystores sign them in one session, with a confirmation for each transaction.
+func SignTransactions(keystores keystore.Keystores, preparedTxs []*PreparedTx) error {
+	proposedTransactions := make([]coinpkg.ProposedTransaction, len(preparedTxs))
+	for index, preparedTx := range preparedTxs {
+		if preparedTx.account.keystores != keystores {
+			return errp.New("The transactions must be spent by accounts of the same keystores")
 		}
-		panic("address must be present")
+		proposedTransactions[index] = preparedTx.proposedTransaction
 	}
-	if err := SignTransaction(account.keystores, txProposal, utxo, getAddress, account.log); err != nil {
-		return errp.WithMessage(err, "Failed to sign transaction")
+	if err := keystores.SignTransactions(proposedTransactions); err != nil {
+		return errp.WithMessage(err, "Failed to sign transactions")
+	}
+	return nil
+}
+
+// Broadcast finalizes the signed transaction, broadcasts it and stores its memo.
+func (preparedTx *PreparedTx) Broadcast() error {
+	account := preparedTx.account
+	preparedTx.proposedTransaction.finalize(account.log)
+	txProposal := preparedTx.proposedTransaction.TXProposal
+	if fiat := txProposal.Fiat; fiat != nil {
+		account.log.WithFields(logrus.Fields{
+			"group":          "audit",
+			"txid":           txProposal.Transaction.TxHash().String(),
+			"amount":         txProposal.Amount,
+			"fiat-amount":    fiat.Amount,
+			"fiat-unit":      fiat.Unit,
+			"rate":           fiat.Rate,
+			"rate-source":    fiat.RateSource,
+			"rate-timestamp": fiat.RateTimestamp,
+		}).Info("Signed transaction with fiat amount shown for confirmation")
 	}
 	account.log.Info("Signed transaction is broadcasted")
-	return account.blockchain.TransactionBroadcast(txProposal.Transaction)
+	if err := account.blockchain.TransactionBroadcast(txProposal.Transaction); err != nil {
+		return err
+	}
+	if preparedTx.memo != "" {
+		if err := account.transactions.SetTxNote(txProposal.Transaction.TxHash(), preparedTx.memo); err != nil {
+			account.log.WithError(err).Error("Failed to store the memo as tx note")
+		}
+	}
+	return nil
+}
+
+// SetTxNote stores a note for the tx with the given ID. An empty note deletes it.
+func (account *Account) SetTxNote(txID string, note string) error {
+	if err := validateTxNote(note); err != nil {
+		return err
+	}
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return account.transactions.SetTxNote(*txHash, note)
+}
+
+// TxNotes returns all notes of the account by tx ID.
+func (account *Account) TxNotes() (map[string]string, error) {
+	notes, err := account.transactions.TxNotes()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	for txHash, note := range notes {
+		result[txHash.String()] = note
+	}
+	return result, nil
+}
+
+// SetAddressNote stores a note for the address, e.g. who it was given to, so that incoming
+// payments can be attributed. The address does not have to be derived yet, so that notes can be
+// restored from a backup before the account is synced. An empty note deletes it.
+func (account *Account) SetAddressNote(address string, note string) error {
+	if err := validateTxNote(note); err != nil {
+		return err
+	}
+	decodedAddress, err := account.decodeRecipientAddress(address)
+	if err != nil {
+		return err
+	}
+	return account.transactions.SetAddressNote(decodedAddress.EncodeAddress(), note)
+}
+
+// AddressNotes returns all address notes of the account by address.
+func (account *Account) AddressNotes() (map[string]string, error) {
+	return account.transactions.AddressNotes()
+}
+
+// txWarnings returns the warnings which apply to the tx proposal.
+func (account *Account) txWarnings(txProposal *maketx.TxProposal) []TxWarning {
+	warnings := []TxWarning{}
+	privacy := account.transactions.OutputsPrivacy()
+	spendsCoinjoin, spendsNonCoinjoin := false, false
+	for _, txIn := range txProposal.Transaction.TxIn {
+		outputPrivacy, ok := privacy[txIn.PreviousOutPoint]
+		if !ok {
+			continue
+		}
+		if outputPrivacy.Coinjoin {
+			spendsCoinjoin = true
+		} else {
+			spendsNonCoinjoin = true
+		}
+	}
+	if spendsCoinjoin && spendsNonCoinjoin {
+		warnings = append(warnings, TxWarningCoinjoinMerge)
+	}
+	return warnings
+}
+
+// FeeDetails is the fee of a proposed tx in all the units shown to the user. It is computed in the
+// backend, so that all surfaces show the same values.
+type FeeDetails struct {
+	// Amount is the absolute fee.
+	Amount btcutil.Amount
+	// VSize is the virtual size of the tx the fee was computed for, see maketx.TxProposal.VSize.
+	VSize int64
+	// SatPerVByte is the fee rate.
+	SatPerVByte float64
+	// Fiat is the value of the fee in the requested fiat currency, including the time of the
+	// exchange rate. It is nil if no fiat currency was requested or no exchange rate is available.
+	Fiat *maketx.FiatConversion
+}
+
+// feeDetails returns the fee of the tx proposal, converted to the given fiat currency.
+func (account *Account) feeDetails(txProposal *maketx.TxProposal, fiatUnit string) *FeeDetails {
+	return &FeeDetails{
+		Amount:      txProposal.Fee,
+		VSize:       txProposal.VSize,
+		SatPerVByte: txProposal.FeeRate(),
+		Fiat:        account.coin.fiatConversion(txProposal.Fee, fiatUnit),
+	}
 }
 
 // TxProposal creates a tx from the relevant input and returns information about it for display in
-// the UI (the output amount and the fee). At the same time, it validates the input.
+// the UI (the output amount, the fee and warnings the user should be made aware of). At the same
+// time, it validates the input. maketx.ErrFeeCapExceeded is returned if the fee is above the
+// configured cap, unless overrideFeeCap is true. disableRBF is the same as for SendTx(), so that the
+// proposal matches the tx which is sent. The fee is converted to the given fiat currency, if not
+// empty.
 func (account *Account) TxProposal(
 	recipientAddress string,
 	amount SendAmount,
 	feeTargetCode FeeTargetCode,
 	selectedUTXOs map[wire.OutPoint]struct{},
+	overrideFeeCap bool,
+	disableRBF bool,
+	fiatUnit string,
 ) (
-	btcutil.Amount, btcutil.Amount, btcutil.Amount, error) {
+	btcutil.Amount, *FeeDetails, btcutil.Amount, []TxWarning, error) {
 
 	account.log.Debug("Proposing transaction")
 	_, txProposal, err := account.newTx(
@@ -182,11 +510,14 @@ func (account *Account) TxProposal(
 		amount,
 		feeTargetCode,
 		selectedUTXOs,
+		overrideFeeCap,
+		disableRBF,
 	)
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, nil, 0, nil, err
 	}
 
 	account.log.WithField("fee", txProposal.Fee).Debug("Returning fee")
-	return txProposal.Amount, txProposal.Fee, txProposal.Total(), nil
+	return txProposal.Amount, account.feeDetails(txProposal, fiatUnit), txProposal.Total(),
+		account.txWarnings(txProposal), nil
 }
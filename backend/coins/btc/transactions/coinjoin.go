@@ -0,0 +1,51 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"github.com/btcsuite/btcd/wire"
+)
+
+// minCoinjoinEqualOutputs is the minimum number of equal value outputs for a tx to be considered a
+// coinjoin. Whirlpool uses five, JoinMarket and Wasabi rounds typically have more than three.
+const minCoinjoinEqualOutputs = 3
+
+// IsCoinjoinOutput returns true if the output at the given index looks like a mixed coinjoin
+// output: it is one of at least minCoinjoinEqualOutputs outputs of the same value, in a tx with at
+// least as many inputs, so that the outputs can't be linked to the inputs by their value.
+func IsCoinjoinOutput(tx *wire.MsgTx, index int) bool {
+	if index < 0 || index >= len(tx.TxOut) {
+		return false
+	}
+	value := tx.TxOut[index].Value
+	equalOutputs := 0
+	for _, txOut := range tx.TxOut {
+		if txOut.Value == value {
+			equalOutputs++
+		}
+	}
+	return equalOutputs >= minCoinjoinEqualOutputs && len(tx.TxIn) >= equalOutputs
+}
+
+// IsCoinjoin returns true if the tx has at least one output which looks like a mixed coinjoin
+// output.
+func IsCoinjoin(tx *wire.MsgTx) bool {
+	for index := range tx.TxOut {
+		if IsCoinjoinOutput(tx, index) {
+			return true
+		}
+	}
+	return false
+}
@@ -81,6 +81,32 @@ type DBTxInterface interface {
 
 	// AddressHistory retrieves an address history. If not found, returns an empty history.
 	AddressHistory(blockchain.ScriptHashHex) (blockchain.TxHistory, error)
+
+	// PutTxNote stores a user note for a transaction. The note is kept even if the transaction is
+	// deleted. An empty note deletes it.
+	PutTxNote(chainhash.Hash, string) error
+
+	// TxNote retrieves the user note of a transaction. "" is returned if not found.
+	TxNote(chainhash.Hash) (string, error)
+
+	// TxNotes retrieves all user notes, including those of transactions which are not stored.
+	TxNotes() (map[chainhash.Hash]string, error)
+
+	// PutAddressNote stores a user note for an address, e.g. who it was given to. An empty note
+	// deletes it.
+	PutAddressNote(address string, note string) error
+
+	// AddressNote retrieves the user note of an address. "" is returned if not found.
+	AddressNote(address string) (string, error)
+
+	// AddressNotes retrieves all user notes of addresses by address.
+	AddressNotes() (map[string]string, error)
+
+	// PutBalanceSnapshot stores a balance snapshot under the given date, replacing an existing one.
+	PutBalanceSnapshot(date string, snapshot *BalanceSnapshot) error
+
+	// BalanceSnapshots retrieves all balance snapshots, sorted by date.
+	BalanceSnapshots() ([]*BalanceSnapshot, error)
 }
 
 // DBInterface can be implemented by database backends to open database transactions.
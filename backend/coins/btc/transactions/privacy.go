@@ -0,0 +1,189 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+)
+
+const (
+	privacyPenaltyPerReuse    = 20
+	privacyMaxReusePenalty    = 60
+	privacyPenaltyPerLink     = 5
+	privacyMaxLinkPenalty     = 30
+	privacyUnconfirmedPenalty = 10
+)
+
+// OutputPrivacy contains privacy related annotations of an unspent output, computed locally from
+// the wallet history.
+type OutputPrivacy struct {
+	// NumConfirmations is the age of the output in blocks. 0 for unconfirmed outputs.
+	NumConfirmations int
+	// AddressReuses is the number of other outputs which were received on the same address.
+	AddressReuses int
+	// LinkedAddresses is the number of other wallet addresses known to belong to the same owner as
+	// the address of this output to a blockchain observer, because they were spent together in one
+	// tx (common-input-ownership heuristic) or received the change of such a tx.
+	LinkedAddresses int
+	// Coinjoin is true if the output looks like a mixed coinjoin output, see IsCoinjoinOutput().
+	Coinjoin bool
+	// Score is a simple privacy score from 0 (bad) to 100 (good), derived from the values above.
+	Score int
+}
+
+func (privacy *OutputPrivacy) computeScore() {
+	reusePenalty := privacy.AddressReuses * privacyPenaltyPerReuse
+	if reusePenalty > privacyMaxReusePenalty {
+		reusePenalty = privacyMaxReusePenalty
+	}
+	linkPenalty := privacy.LinkedAddresses * privacyPenaltyPerLink
+	if linkPenalty > privacyMaxLinkPenalty {
+		linkPenalty = privacyMaxLinkPenalty
+	}
+	privacy.Score = 100 - reusePenalty - linkPenalty
+	if privacy.NumConfirmations == 0 {
+		privacy.Score -= privacyUnconfirmedPenalty
+	}
+}
+
+// addressClusters is a union-find structure grouping addresses which are linked together.
+type addressClusters map[blockchain.ScriptHashHex]blockchain.ScriptHashHex
+
+func (clusters addressClusters) find(address blockchain.ScriptHashHex) blockchain.ScriptHashHex {
+	parent, ok := clusters[address]
+	if !ok {
+		clusters[address] = address
+		return address
+	}
+	if parent == address {
+		return address
+	}
+	root := clusters.find(parent)
+	clusters[address] = root
+	return root
+}
+
+func (clusters addressClusters) union(address1, address2 blockchain.ScriptHashHex) {
+	root1, root2 := clusters.find(address1), clusters.find(address2)
+	if root1 != root2 {
+		clusters[root1] = root2
+	}
+}
+
+// OutputsPrivacy returns the privacy annotations of all unspent outputs of the wallet. Computing
+// them walks the whole history, so the result is cached until the history or the chain tip changes.
+// The returned map must not be modified.
+func (transactions *Transactions) OutputsPrivacy() map[wire.OutPoint]*OutputPrivacy {
+	transactions.synchronizer.WaitSynchronized()
+	defer transactions.RLock()()
+	defer transactions.outputsPrivacyLock.Lock()()
+	if transactions.outputsPrivacy == nil {
+		transactions.outputsPrivacy = transactions.computeOutputsPrivacy()
+	}
+	return transactions.outputsPrivacy
+}
+
+// invalidateOutputsPrivacy clears the cache of OutputsPrivacy(). Must be called after the history
+// or the chain tip changed, while holding the transactions write lock.
+func (transactions *Transactions) invalidateOutputsPrivacy() {
+	defer transactions.outputsPrivacyLock.Lock()()
+	transactions.outputsPrivacy = nil
+}
+
+// requires transactions lock
+func (transactions *Transactions) computeOutputsPrivacy() map[wire.OutPoint]*OutputPrivacy {
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+
+	outputs, err := dbTx.Outputs()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve outputs")
+	}
+	receivedCount := map[blockchain.ScriptHashHex]int{}
+	for _, txOut := range outputs {
+		receivedCount[getScriptHashHex(txOut)]++
+	}
+
+	clusters := addressClusters{}
+	txHashes, err := dbTx.Transactions()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve transactions")
+	}
+	for _, txHash := range txHashes {
+		tx, _, _, _, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		linked := []blockchain.ScriptHashHex{}
+		for _, txIn := range tx.TxIn {
+			spentOut, err := dbTx.Output(txIn.PreviousOutPoint)
+			if err != nil {
+				transactions.log.WithError(err).Panic("Failed to retrieve output")
+			}
+			if spentOut != nil {
+				linked = append(linked, getScriptHashHex(spentOut))
+			}
+		}
+		if len(linked) == 0 {
+			// Not spent by us, so it does not link any of our addresses.
+			continue
+		}
+		for index := range tx.TxOut {
+			output, err := dbTx.Output(wire.OutPoint{Hash: txHash, Index: uint32(index)})
+			if err != nil {
+				transactions.log.WithError(err).Panic("Failed to retrieve output")
+			}
+			if output != nil {
+				linked = append(linked, getScriptHashHex(output))
+			}
+		}
+		for _, address := range linked[1:] {
+			clusters.union(linked[0], address)
+		}
+	}
+	clusterSizes := map[blockchain.ScriptHashHex]int{}
+	for address := range clusters {
+		clusterSizes[clusters.find(address)]++
+	}
+
+	result := map[wire.OutPoint]*OutputPrivacy{}
+	for outPoint, txOut := range outputs {
+		if transactions.isInputSpent(dbTx, outPoint) {
+			continue
+		}
+		tx, _, height, _, err := dbTx.TxInfo(outPoint.Hash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		scriptHashHex := getScriptHashHex(txOut)
+		privacy := &OutputPrivacy{
+			AddressReuses: receivedCount[scriptHashHex] - 1,
+			Coinjoin:      IsCoinjoinOutput(tx, int(outPoint.Index)),
+		}
+		if height > 0 && transactions.headersTipHeight > 0 {
+			privacy.NumConfirmations = transactions.headersTipHeight - height + 1
+		}
+		if _, ok := clusters[scriptHashHex]; ok {
+			privacy.LinkedAddresses = clusterSizes[clusters.find(scriptHashHex)] - 1
+		}
+		privacy.computeScore()
+		result[outPoint] = privacy
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// SnapshotPeriod is the granularity of balance snapshots.
+type SnapshotPeriod string
+
+const (
+	// SnapshotPeriodDaily returns one snapshot per day.
+	SnapshotPeriodDaily SnapshotPeriod = "daily"
+	// SnapshotPeriodMonthly returns one snapshot per month, the last one taken in the month.
+	SnapshotPeriodMonthly SnapshotPeriod = "monthly"
+)
+
+// snapshotDateFormat is the format of the date a snapshot is stored under. One snapshot is kept per
+// day, later snapshots of the same day replace earlier ones.
+const snapshotDateFormat = "2006-01-02"
+
+// BalanceSnapshot is the balance of the wallet at a point in time.
+type BalanceSnapshot struct {
+	Time      time.Time      `json:"time"`
+	Available btcutil.Amount `json:"available"`
+	Incoming  btcutil.Amount `json:"incoming"`
+}
+
+// SnapshotBalance stores the current balance as the snapshot of the day of the given time (UTC).
+func (transactions *Transactions) SnapshotBalance(now time.Time) error {
+	balance := transactions.Balance()
+	defer transactions.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+	snapshot := &BalanceSnapshot{
+		Time:      now.UTC(),
+		Available: balance.Available,
+		Incoming:  balance.Incoming,
+	}
+	if err := dbTx.PutBalanceSnapshot(snapshot.Time.Format(snapshotDateFormat), snapshot); err != nil {
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// BalanceSnapshots returns the stored balance snapshots in chronological order. Days or months
+// without a snapshot had the balance of the previous snapshot, as the balance only changes when
+// the wallet syncs, and each sync takes a snapshot.
+func (transactions *Transactions) BalanceSnapshots(period SnapshotPeriod) ([]*BalanceSnapshot, error) {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	snapshots, err := dbTx.BalanceSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	if period != SnapshotPeriodMonthly {
+		return snapshots, nil
+	}
+	monthly := []*BalanceSnapshot{}
+	for _, snapshot := range snapshots {
+		if len(monthly) != 0 {
+			last := monthly[len(monthly)-1]
+			if last.Time.Year() == snapshot.Time.Year() && last.Time.Month() == snapshot.Time.Month() {
+				monthly[len(monthly)-1] = snapshot
+				continue
+			}
+		}
+		monthly = append(monthly, snapshot)
+	}
+	return monthly, nil
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/synchronizer"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/sirupsen/logrus"
 )
@@ -63,6 +64,10 @@ type Transactions struct {
 
 	unsubscribeHeadersEvent func()
 
+	// outputsPrivacy caches the result of OutputsPrivacy(), see invalidateOutputsPrivacy().
+	outputsPrivacy     map[wire.OutPoint]*OutputPrivacy
+	outputsPrivacyLock locker.Locker
+
 	synchronizer *synchronizer.Synchronizer
 	blockchain   blockchain.Interface
 	log          *logrus.Entry
@@ -231,6 +236,33 @@ func (transactions *Transactions) SpendableOutputs() map[wire.OutPoint]*Spendabl
 	return result
 }
 
+// PreviousOutputs returns the outputs of the wallet spent by the given tx. An error is returned if
+// not all inputs spend outputs of the wallet.
+func (transactions *Transactions) PreviousOutputs(
+	tx *wire.MsgTx) (map[wire.OutPoint]*SpendableOutput, error) {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	result := map[wire.OutPoint]*SpendableOutput{}
+	for _, txIn := range tx.TxIn {
+		txOut, err := dbTx.Output(txIn.PreviousOutPoint)
+		if err != nil {
+			return nil, err
+		}
+		if txOut == nil {
+			return nil, errp.Newf("output %s does not belong to the wallet", txIn.PreviousOutPoint)
+		}
+		result[txIn.PreviousOutPoint] = &SpendableOutput{
+			TxOut:   txOut,
+			Address: transactions.outputToAddress(txOut.PkScript),
+		}
+	}
+	return result, nil
+}
+
 func (transactions *Transactions) isInputSpent(dbTx DBTxInterface, outPoint wire.OutPoint) bool {
 	input, err := dbTx.Input(outPoint)
 	if err != nil {
@@ -311,6 +343,7 @@ func (transactions *Transactions) UpdateAddressHistory(scriptHashHex blockchain.
 	if err := dbTx.PutAddressHistory(scriptHashHex, txs); err != nil {
 		transactions.log.WithError(err).Panic("Failed to store address history")
 	}
+	defer transactions.invalidateOutputsPrivacy()
 
 	for _, txInfo := range txs {
 		func(txHash chainhash.Hash, height int) {
@@ -361,6 +394,7 @@ func (transactions *Transactions) doForTransaction(
 				callback(dbTx, tx)
 			}
 			delete(transactions.requestedTXs, txHash)
+			defer transactions.invalidateOutputsPrivacy()
 			return dbTx.Commit()
 		},
 		func() { done() },
@@ -379,6 +413,33 @@ type Balance struct {
 
 // Balance computes the confirmed and unconfirmed balance of the wallet.
 func (transactions *Transactions) Balance() *Balance {
+	var available, incoming int64
+	for _, output := range transactions.UnspentOutputs() {
+		if output.Confirmed || output.OwnInputs {
+			available += output.TxOut.Value
+		} else {
+			incoming += output.TxOut.Value
+		}
+	}
+	return &Balance{
+		Available: btcutil.Amount(available),
+		Incoming:  btcutil.Amount(incoming),
+	}
+}
+
+// UnspentOutput is an output of the wallet which is not spent by any tx.
+type UnspentOutput struct {
+	OutPoint wire.OutPoint
+	TxOut    *wire.TxOut
+	// Confirmed is true if the tx creating the output is confirmed.
+	Confirmed bool
+	// OwnInputs is true if all inputs of the tx creating the output spend outputs of the wallet,
+	// e.g. if the output is change.
+	OwnInputs bool
+}
+
+// UnspentOutputs returns all outputs of the wallet which are not spent by any tx, confirmed or not.
+func (transactions *Transactions) UnspentOutputs() []*UnspentOutput {
 	transactions.synchronizer.WaitSynchronized()
 	defer transactions.RLock()()
 	dbTx, err := transactions.db.Begin()
@@ -390,9 +451,8 @@ func (transactions *Transactions) Balance() *Balance {
 		transactions.log.WithError(err).Panic("Failed to retrieve outputs")
 	}
 	defer dbTx.Rollback()
-	var available, incoming int64
+	result := []*UnspentOutput{}
 	for outPoint, txOut := range outputs {
-		// What is spent can not be available nor incoming.
 		if spent := transactions.isInputSpent(dbTx, outPoint); spent {
 			continue
 		}
@@ -400,17 +460,14 @@ func (transactions *Transactions) Balance() *Balance {
 		if err != nil {
 			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
 		}
-		confirmed := height > 0
-		if confirmed || transactions.allInputsOurs(dbTx, tx) {
-			available += txOut.Value
-		} else {
-			incoming += txOut.Value
-		}
-	}
-	return &Balance{
-		Available: btcutil.Amount(available),
-		Incoming:  btcutil.Amount(incoming),
+		result = append(result, &UnspentOutput{
+			OutPoint:  outPoint,
+			TxOut:     txOut,
+			Confirmed: height > 0,
+			OwnInputs: transactions.allInputsOurs(dbTx, tx),
+		})
 	}
+	return result
 }
 
 // byHeight defines the methods needed to satisify sort.Interface to sort transactions by their
@@ -465,6 +522,41 @@ type TxInfo struct {
 	Timestamp *time.Time
 	// Addresses money was sent to / received on (without change addresses).
 	Addresses []string
+	// Coinjoin is true if the tx looks like a coinjoin, see IsCoinjoin().
+	Coinjoin bool
+	// Note is the user note attached to the tx, e.g. the memo entered when sending it.
+	Note string
+	// AddressNotes are the user notes of the addresses in Addresses which have one, by address.
+	AddressNotes map[string]string
+}
+
+// FinalConfirmations is the number of confirmations after which a tx is considered final, i.e.
+// very unlikely to be reverted by a reorg.
+const FinalConfirmations = 6
+
+// TxStatus is the confirmation status of a transaction.
+type TxStatus string
+
+const (
+	// TxStatusPending means the tx is not yet included in a block.
+	TxStatusPending TxStatus = "pending"
+	// TxStatusConfirmed means the tx is included in a block, but has fewer than
+	// FinalConfirmations confirmations.
+	TxStatusConfirmed TxStatus = "confirmed"
+	// TxStatusFinal means the tx has at least FinalConfirmations confirmations.
+	TxStatusFinal TxStatus = "final"
+)
+
+// Status returns the confirmation status of the tx.
+func (txInfo *TxInfo) Status() TxStatus {
+	switch {
+	case txInfo.Height <= 0 || txInfo.NumConfirmations <= 0:
+		return TxStatusPending
+	case txInfo.NumConfirmations < FinalConfirmations:
+		return TxStatusConfirmed
+	default:
+		return TxStatusFinal
+	}
 }
 
 // FeeRatePerKb returns the fee rate of the tx (fee / tx size).
@@ -562,6 +654,22 @@ func (transactions *Transactions) txInfo(
 	if height > 0 && transactions.headersTipHeight > 0 {
 		numConfirmations = transactions.headersTipHeight - height + 1
 	}
+	note, err := dbTx.TxNote(tx.TxHash())
+	if err != nil {
+		// TODO
+		panic(err)
+	}
+	addressNotes := map[string]string{}
+	for _, address := range addresses {
+		addressNote, err := dbTx.AddressNote(address)
+		if err != nil {
+			// TODO
+			panic(err)
+		}
+		if addressNote != "" {
+			addressNotes[address] = addressNote
+		}
+	}
 	btcutilTx := btcutil.NewTx(tx)
 	return &TxInfo{
 		Tx:               tx,
@@ -575,6 +683,9 @@ func (transactions *Transactions) txInfo(
 		Fee:              feeP,
 		Timestamp:        timestamp,
 		Addresses:        addresses,
+		Coinjoin:         IsCoinjoin(tx),
+		Note:             note,
+		AddressNotes:     addressNotes,
 	}
 }
 
@@ -606,3 +717,55 @@ func (transactions *Transactions) Transactions(
 	sort.Sort(sort.Reverse(byHeight(txs)))
 	return txs
 }
+
+// SetTxNote stores a user note for the tx with the given hash. The tx does not have to be known
+// yet, so the note can be stored right after broadcasting. An empty note deletes it.
+func (transactions *Transactions) SetTxNote(txHash chainhash.Hash, note string) error {
+	defer transactions.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+	if err := dbTx.PutTxNote(txHash, note); err != nil {
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// SetAddressNote stores a user note for the address, e.g. who it was given to. The address does
+// not have to be used yet. An empty note deletes it.
+func (transactions *Transactions) SetAddressNote(address string, note string) error {
+	defer transactions.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+	if err := dbTx.PutAddressNote(address, note); err != nil {
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// AddressNotes returns all user notes of addresses by address.
+func (transactions *Transactions) AddressNotes() (map[string]string, error) {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	return dbTx.AddressNotes()
+}
+
+// TxNotes returns all user notes by tx hash.
+func (transactions *Transactions) TxNotes() (map[chainhash.Hash]string, error) {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	return dbTx.TxNotes()
+}
@@ -16,6 +16,7 @@ package transactions_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -232,6 +233,59 @@ func (s *transactionsSuite) TestUpdateAddressHistorySingleTxReceive() {
 	require.Equal(s.T(), expectedHeight, transactions[0].Height)
 }
 
+// TestTxNote checks that a note can be stored before the tx is known, and that it can be deleted.
+func (s *transactionsSuite) TestTxNote() {
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 123)
+	require.NoError(s.T(), s.transactions.SetTxNote(tx1.TxHash(), "invoice 42"))
+	s.blockchainMock.RegisterTxs(tx1)
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchain.TxInfo{
+		{TXHash: blockchain.TXHash(tx1.TxHash()), Height: 10},
+	})
+	isChange := func(blockchain.ScriptHashHex) bool { return false }
+	transactions := s.transactions.Transactions(isChange)
+	require.Len(s.T(), transactions, 1)
+	require.Equal(s.T(), "invoice 42", transactions[0].Note)
+	notes, err := s.transactions.TxNotes()
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), map[chainhash.Hash]string{tx1.TxHash(): "invoice 42"}, notes)
+
+	require.NoError(s.T(), s.transactions.SetTxNote(tx1.TxHash(), ""))
+	require.Equal(s.T(), "", s.transactions.Transactions(isChange)[0].Note)
+	notes, err = s.transactions.TxNotes()
+	require.NoError(s.T(), err)
+	require.Empty(s.T(), notes)
+}
+
+// TestAddressNote checks that the notes of the addresses received on are attached to the tx.
+func (s *transactionsSuite) TestAddressNote() {
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	require.NoError(s.T(), s.transactions.SetAddressNote(address.EncodeAddress(), "given to alice"))
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 123)
+	s.blockchainMock.RegisterTxs(tx1)
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchain.TxInfo{
+		{TXHash: blockchain.TXHash(tx1.TxHash()), Height: 10},
+	})
+	isChange := func(blockchain.ScriptHashHex) bool { return false }
+	transactions := s.transactions.Transactions(isChange)
+	require.Len(s.T(), transactions, 1)
+	require.Equal(s.T(),
+		map[string]string{address.EncodeAddress(): "given to alice"}, transactions[0].AddressNotes)
+	notes, err := s.transactions.AddressNotes()
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), map[string]string{address.EncodeAddress(): "given to alice"}, notes)
+
+	require.NoError(s.T(), s.transactions.SetAddressNote(address.EncodeAddress(), ""))
+	require.Empty(s.T(), s.transactions.Transactions(isChange)[0].AddressNotes)
+	notes, err = s.transactions.AddressNotes()
+	require.NoError(s.T(), err)
+	require.Empty(s.T(), notes)
+}
+
 // TestUpdateAddressHistoryOppositeOrder checks that a spend is correctly recognized even if the
 // transactions in the history of an address are processed in the wrong order. If the spending tx is
 // processed before the funding tx, the output is unknown when processing the funds, but after the
@@ -444,3 +498,131 @@ func (s *transactionsSuite) TestRemoveTransactionPendingDownload() {
 	require.Empty(s.T(),
 		s.transactions.Transactions(func(blockchain.ScriptHashHex) bool { return false }))
 }
+
+// TestOutputsPrivacy checks the address reuse and linked address annotations of the utxo set.
+func (s *transactionsSuite) TestOutputsPrivacy() {
+	require.Empty(s.T(), s.transactions.OutputsPrivacy())
+	addresses := s.addressChain.EnsureAddresses()
+	address1 := addresses[0]
+	address2 := addresses[1]
+	address3 := addresses[2]
+	// Two payments to address1 (reuse), one to address2.
+	tx1 := newTx(chainhash.HashH(nil), 0, address1, 1000)
+	tx2 := newTx(chainhash.HashH(nil), 1, address1, 2000)
+	tx3 := newTx(chainhash.HashH(nil), 2, address2, 3000)
+	s.blockchainMock.RegisterTxs(tx1, tx2, tx3)
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil)
+	s.updateAddressHistory(address1, []*blockchain.TxInfo{
+		{TXHash: blockchain.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchain.TXHash(tx2.TxHash()), Height: 10},
+	})
+	s.updateAddressHistory(address2, []*blockchain.TxInfo{
+		{TXHash: blockchain.TXHash(tx3.TxHash()), Height: 10},
+	})
+	privacy := s.transactions.OutputsPrivacy()
+	require.Len(s.T(), privacy, 3)
+	outPoint1 := wire.OutPoint{Hash: tx1.TxHash(), Index: 0}
+	outPoint3 := wire.OutPoint{Hash: tx3.TxHash(), Index: 0}
+	require.Equal(s.T(), 1, privacy[outPoint1].AddressReuses)
+	require.Equal(s.T(), 0, privacy[outPoint1].LinkedAddresses)
+	require.Equal(s.T(), 6, privacy[outPoint1].NumConfirmations)
+	require.Equal(s.T(), 80, privacy[outPoint1].Score)
+	require.Equal(s.T(), 0, privacy[outPoint3].AddressReuses)
+	require.Equal(s.T(), 100, privacy[outPoint3].Score)
+	// The result is cached until the history changes.
+	require.True(s.T(), privacy[outPoint1] == s.transactions.OutputsPrivacy()[outPoint1])
+
+	// Spend the outputs of address1 and address2 together to address3. All three addresses are
+	// linked now.
+	spend := &wire.MsgTx{
+		Version: wire.TxVersion,
+		TxIn: []*wire.TxIn{
+			wire.NewTxIn(&wire.OutPoint{Hash: tx1.TxHash(), Index: 0}, nil, nil),
+			wire.NewTxIn(&wire.OutPoint{Hash: tx3.TxHash(), Index: 0}, nil, nil),
+		},
+		TxOut: []*wire.TxOut{wire.NewTxOut(3500, address3.PubkeyScript())},
+	}
+	s.blockchainMock.RegisterTxs(spend)
+	s.updateAddressHistory(address3, []*blockchain.TxInfo{
+		{TXHash: blockchain.TXHash(spend.TxHash()), Height: 0},
+	})
+	privacy = s.transactions.OutputsPrivacy()
+	require.Len(s.T(), privacy, 2)
+	spendOutPoint := wire.OutPoint{Hash: spend.TxHash(), Index: 0}
+	require.Equal(s.T(), 2, privacy[spendOutPoint].LinkedAddresses)
+	require.Equal(s.T(), 0, privacy[spendOutPoint].NumConfirmations)
+	require.Equal(s.T(), 80, privacy[spendOutPoint].Score)
+	require.Equal(s.T(), 2, privacy[wire.OutPoint{Hash: tx2.TxHash(), Index: 0}].LinkedAddresses)
+}
+
+func TestIsCoinjoin(t *testing.T) {
+	newTx := func(numInputs int, outputValues ...int64) *wire.MsgTx {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		for i := 0; i < numInputs; i++ {
+			tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil, nil))
+		}
+		for _, value := range outputValues {
+			tx.AddTxOut(wire.NewTxOut(value, nil))
+		}
+		return tx
+	}
+	// Whirlpool-like: five inputs, five equal outputs.
+	tx := newTx(5, 5000000, 5000000, 5000000, 5000000, 5000000)
+	require.True(t, transactions.IsCoinjoin(tx))
+	require.True(t, transactions.IsCoinjoinOutput(tx, 0))
+	// Equal outputs plus change.
+	tx = newTx(4, 1000, 1000, 1000, 123)
+	require.True(t, transactions.IsCoinjoin(tx))
+	require.True(t, transactions.IsCoinjoinOutput(tx, 2))
+	require.False(t, transactions.IsCoinjoinOutput(tx, 3))
+	require.False(t, transactions.IsCoinjoinOutput(tx, 4))
+	// Batched payment from a single input.
+	require.False(t, transactions.IsCoinjoin(newTx(1, 1000, 1000, 1000)))
+	// Regular payment with change.
+	require.False(t, transactions.IsCoinjoin(newTx(3, 1000, 1000)))
+}
+
+// TestBalanceSnapshots checks that one snapshot is kept per day, and the last one per month for
+// monthly snapshots.
+func (s *transactionsSuite) TestBalanceSnapshots() {
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	day := func(month time.Month, day int, hour int) time.Time {
+		return time.Date(2018, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	require.NoError(s.T(), s.transactions.SnapshotBalance(day(time.May, 30, 10)))
+
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 123)
+	s.blockchainMock.RegisterTxs(tx1)
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchain.TxInfo{
+		{TXHash: blockchain.TXHash(tx1.TxHash()), Height: 10},
+	})
+	require.NoError(s.T(), s.transactions.SnapshotBalance(day(time.May, 31, 10)))
+	require.NoError(s.T(), s.transactions.SnapshotBalance(day(time.May, 31, 12)))
+	require.NoError(s.T(), s.transactions.SnapshotBalance(day(time.June, 1, 10)))
+
+	daily, err := s.transactions.BalanceSnapshots(transactions.SnapshotPeriodDaily)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), daily, 3)
+	require.Equal(s.T(), day(time.May, 30, 10), daily[0].Time)
+	require.Equal(s.T(), btcutil.Amount(0), daily[0].Available)
+	require.Equal(s.T(), day(time.May, 31, 12), daily[1].Time)
+	require.Equal(s.T(), btcutil.Amount(123), daily[1].Available)
+
+	monthly, err := s.transactions.BalanceSnapshots(transactions.SnapshotPeriodMonthly)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), monthly, 2)
+	require.Equal(s.T(), day(time.May, 31, 12), monthly[0].Time)
+	require.Equal(s.T(), day(time.June, 1, 10), monthly[1].Time)
+}
+
+func TestTxInfoStatus(t *testing.T) {
+	require.Equal(t, transactions.TxStatusPending, (&transactions.TxInfo{}).Status())
+	require.Equal(t, transactions.TxStatusPending,
+		(&transactions.TxInfo{Height: -1}).Status())
+	require.Equal(t, transactions.TxStatusConfirmed,
+		(&transactions.TxInfo{Height: 10, NumConfirmations: 1}).Status())
+	require.Equal(t, transactions.TxStatusFinal,
+		(&transactions.TxInfo{Height: 10, NumConfirmations: transactions.FinalConfirmations}).Status())
+}
@@ -29,7 +29,11 @@ func (transactions *Transactions) onHeadersEvent(event headers.Event) {
 		break
 	case headers.EventNewTip:
 		done := transactions.synchronizer.IncRequestsCounter()
-		transactions.headersTipHeight = transactions.headers.TipHeight()
+		func() {
+			defer transactions.Lock()()
+			transactions.headersTipHeight = transactions.headers.TipHeight()
+			transactions.invalidateOutputsPrivacy()
+		}()
 		done()
 		break
 	}
@@ -0,0 +1,462 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// maxTxAncestry is the maximum number of unconfirmed ancestors and descendants looked up. It
+	// matches the default mempool limits of Bitcoin Core, which does not accept longer chains.
+	maxTxAncestry = 25
+	// txAncestryRequestTimeout is how long to wait for each reply of the blockchain backend.
+	txAncestryRequestTimeout = 30 * time.Second
+)
+
+// TxAncestryReason explains why an unconfirmed tx is not confirming.
+type TxAncestryReason string
+
+const (
+	// TxAncestryReasonConfirmed means the tx is confirmed.
+	TxAncestryReasonConfirmed TxAncestryReason = "confirmed"
+	// TxAncestryReasonLowFeeRate means the fee rate of the tx itself is too low.
+	TxAncestryReasonLowFeeRate TxAncestryReason = "lowFeeRate"
+	// TxAncestryReasonLowAncestorFeeRate means the tx pays enough, but it can only be mined
+	// together with its unconfirmed ancestors, which pay too little.
+	TxAncestryReasonLowAncestorFeeRate TxAncestryReason = "lowAncestorFeeRate"
+	// TxAncestryReasonFeeRateSufficient means the tx and its ancestors pay the required fee rate,
+	// so the tx should confirm soon.
+	TxAncestryReasonFeeRateSufficient TxAncestryReason = "feeRateSufficient"
+	// TxAncestryReasonUnknown means no fee rate has been estimated yet.
+	TxAncestryReasonUnknown TxAncestryReason = "unknown"
+)
+
+// TxPackageEntry is an unconfirmed tx in the ancestry of another tx.
+type TxPackageEntry struct {
+	TxID  string
+	VSize int64
+	Fee   btcutil.Amount
+	// Depth is the distance to the inspected tx, 1 for its parents or children.
+	Depth int
+	// Ours is true if the tx is in the transactions of the account.
+	Ours bool
+}
+
+// TxAncestry describes the unconfirmed ancestors and descendants of an unconfirmed tx, see
+// Account.TxAncestry(). Miners select txs by the fee rate of the tx together with its unconfirmed
+// ancestors, so a tx with a high fee rate can be held back by its parents, and a child tx paying a
+// high fee can pull its parents into a block (child pays for parent, CPFP).
+type TxAncestry struct {
+	TxID  string
+	VSize int64
+	Fee   btcutil.Amount
+	// Confirmed is true if the tx is already confirmed, in which case it has no ancestry.
+	Confirmed   bool
+	Ancestors   []*TxPackageEntry
+	Descendants []*TxPackageEntry
+	// Depth is the length of the longest chain of unconfirmed ancestors, 0 if all parents are
+	// confirmed.
+	Depth int
+	// FeeRatePerKb is the fee rate of the tx alone.
+	FeeRatePerKb btcutil.Amount
+	// AncestorFeeRatePerKb is the fee rate of the tx together with its unconfirmed ancestors.
+	AncestorFeeRatePerKb btcutil.Amount
+	// DescendantFeeRatePerKb is the fee rate of the tx together with its unconfirmed descendants.
+	DescendantFeeRatePerKb btcutil.Amount
+	// RequiredFeeRatePerKb is the estimated fee rate of the fee target, or nil if it is not known.
+	RequiredFeeRatePerKb *btcutil.Amount
+	Reason               TxAncestryReason
+	// CPFPOutPoint is an unspent output of the tx owned by the account, which can be spent by a
+	// child tx paying for the tx and its ancestors. nil if there is none.
+	CPFPOutPoint *wire.OutPoint
+	// CPFPFee is the fee the child tx has to pay so that the package reaches the required fee rate.
+	// 0 if no child is needed or no fee rate is known.
+	CPFPFee btcutil.Amount
+	// CPFPPossible is true if the child tx is needed and the output is worth more than its fee.
+	CPFPPossible bool
+}
+
+// ancestryTx is a tx looked up with the blockchain backend.
+type ancestryTx struct {
+	tx        *wire.MsgTx
+	vsize     int64
+	fee       btcutil.Amount
+	confirmed bool
+	ours      bool
+}
+
+// txAncestryLookup looks up txs, their confirmation status and fees with the blockchain backend.
+// The results are cached for the duration of one Account.TxAncestry() call.
+type txAncestryLookup struct {
+	blockchain blockchain.Interface
+	// known are the txs of the account, which don't have to be downloaded.
+	known     map[chainhash.Hash]*wire.MsgTx
+	txs       map[chainhash.Hash]*wire.MsgTx
+	histories map[blockchain.ScriptHashHex]blockchain.TxHistory
+	entries   map[chainhash.Hash]*ancestryTx
+}
+
+// history returns the history of the script hash, blocking until the backend replied.
+func (lookup *txAncestryLookup) history(scriptHash blockchain.ScriptHashHex) (blockchain.TxHistory, error) {
+	if history, ok := lookup.histories[scriptHash]; ok {
+		return history, nil
+	}
+	result := make(chan blockchain.TxHistory, 1)
+	done := make(chan struct{})
+	lookup.blockchain.ScriptHashGetHistory(scriptHash,
+		func(history blockchain.TxHistory) error {
+			result <- history
+			return nil
+		},
+		func() { close(done) },
+	)
+	select {
+	case <-done:
+	case <-time.After(txAncestryRequestTimeout):
+		return nil, errp.New("Timed out getting the address history")
+	}
+	select {
+	case history := <-result:
+		lookup.histories[scriptHash] = history
+		return history, nil
+	default:
+		return nil, errp.New("Failed to get the address history")
+	}
+}
+
+// tx returns the tx with the given hash, blocking until the backend replied.
+func (lookup *txAncestryLookup) tx(txHash chainhash.Hash) (*wire.MsgTx, error) {
+	if tx, ok := lookup.known[txHash]; ok {
+		return tx, nil
+	}
+	if tx, ok := lookup.txs[txHash]; ok {
+		return tx, nil
+	}
+	result := make(chan *wire.MsgTx, 1)
+	done := make(chan struct{})
+	lookup.blockchain.TransactionGet(txHash,
+		func(tx *wire.MsgTx) error {
+			result <- tx
+			return nil
+		},
+		func() { close(done) },
+	)
+	select {
+	case <-done:
+	case <-time.After(txAncestryRequestTimeout):
+		return nil, errp.Newf("Timed out getting the transaction %s", txHash)
+	}
+	select {
+	case tx := <-result:
+		lookup.txs[txHash] = tx
+		return tx, nil
+	default:
+		return nil, errp.Newf("Failed to get the transaction %s", txHash)
+	}
+}
+
+// entry returns the tx with its confirmation status and fee. The status is found in the history of
+// the scripts of its outputs, and the fee is the one reported by the backend, or else computed
+// from the spent outputs.
+func (lookup *txAncestryLookup) entry(txHash chainhash.Hash) (*ancestryTx, error) {
+	if entry, ok := lookup.entries[txHash]; ok {
+		return entry, nil
+	}
+	tx, err := lookup.tx(txHash)
+	if err != nil {
+		return nil, err
+	}
+	_, ours := lookup.known[txHash]
+	entry := &ancestryTx{
+		tx:    tx,
+		vsize: mempool.GetTxVirtualSize(btcutil.NewTx(tx)),
+		ours:  ours,
+	}
+	var historyEntry *blockchain.TxInfo
+outputs:
+	for _, txOut := range tx.TxOut {
+		if txscript.GetScriptClass(txOut.PkScript) == txscript.NullDataTy {
+			continue
+		}
+		history, err := lookup.history((&transactions.SpendableOutput{TxOut: txOut}).ScriptHashHex())
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range history {
+			if info.TXHash.Hash() == txHash {
+				historyEntry = info
+				break outputs
+			}
+		}
+	}
+	if historyEntry == nil {
+		return nil, errp.Newf("The transaction %s is unknown to the server", txHash)
+	}
+	entry.confirmed = historyEntry.Height > 0
+	if !entry.confirmed {
+		if historyEntry.Fee != nil {
+			entry.fee = btcutil.Amount(*historyEntry.Fee)
+		} else if entry.fee, err = lookup.fee(tx); err != nil {
+			return nil, err
+		}
+	}
+	lookup.entries[txHash] = entry
+	return entry, nil
+}
+
+// fee computes the fee of the tx from the values of the outputs it spends.
+func (lookup *txAncestryLookup) fee(tx *wire.MsgTx) (btcutil.Amount, error) {
+	var fee btcutil.Amount
+	for _, txIn := range tx.TxIn {
+		parent, err := lookup.tx(txIn.PreviousOutPoint.Hash)
+		if err != nil {
+			return 0, err
+		}
+		if int(txIn.PreviousOutPoint.Index) >= len(parent.TxOut) {
+			return 0, errp.New("The transaction spends a nonexistent output")
+		}
+		fee += btcutil.Amount(parent.TxOut[txIn.PreviousOutPoint.Index].Value)
+	}
+	for _, txOut := range tx.TxOut {
+		fee -= btcutil.Amount(txOut.Value)
+	}
+	return fee, nil
+}
+
+// ancestors returns the unconfirmed ancestors of the tx, breadth first.
+func (lookup *txAncestryLookup) ancestors(tx *wire.MsgTx) ([]*TxPackageEntry, error) {
+	result := []*TxPackageEntry{}
+	visited := map[chainhash.Hash]bool{}
+	type queued struct {
+		tx    *wire.MsgTx
+		depth int
+	}
+	queue := []queued{{tx: tx}}
+	for len(queue) != 0 && len(result) < maxTxAncestry {
+		current := queue[0]
+		queue = queue[1:]
+		for _, txIn := range current.tx.TxIn {
+			parentHash := txIn.PreviousOutPoint.Hash
+			if visited[parentHash] {
+				continue
+			}
+			visited[parentHash] = true
+			parent, err := lookup.entry(parentHash)
+			if err != nil {
+				return nil, err
+			}
+			if parent.confirmed {
+				continue
+			}
+			result = append(result, &TxPackageEntry{
+				TxID:  parentHash.String(),
+				VSize: parent.vsize,
+				Fee:   parent.fee,
+				Depth: current.depth + 1,
+				Ours:  parent.ours,
+			})
+			queue = append(queue, queued{tx: parent.tx, depth: current.depth + 1})
+		}
+	}
+	return result, nil
+}
+
+// descendants returns the unconfirmed descendants of the tx, breadth first. The txs spending an
+// output are found in the history of the script of the output.
+func (lookup *txAncestryLookup) descendants(tx *wire.MsgTx) ([]*TxPackageEntry, error) {
+	result := []*TxPackageEntry{}
+	visited := map[chainhash.Hash]bool{tx.TxHash(): true}
+	type queued struct {
+		tx    *wire.MsgTx
+		depth int
+	}
+	queue := []queued{{tx: tx}}
+	for len(queue) != 0 && len(result) < maxTxAncestry {
+		current := queue[0]
+		queue = queue[1:]
+		currentHash := current.tx.TxHash()
+		for _, txOut := range current.tx.TxOut {
+			if txscript.GetScriptClass(txOut.PkScript) == txscript.NullDataTy {
+				continue
+			}
+			history, err := lookup.history((&transactions.SpendableOutput{TxOut: txOut}).ScriptHashHex())
+			if err != nil {
+				return nil, err
+			}
+			for _, info := range history {
+				childHash := info.TXHash.Hash()
+				if info.Height > 0 || visited[childHash] {
+					continue
+				}
+				child, err := lookup.entry(childHash)
+				if err != nil {
+					return nil, err
+				}
+				spendsCurrent := false
+				for _, txIn := range child.tx.TxIn {
+					if txIn.PreviousOutPoint.Hash == currentHash {
+						spendsCurrent = true
+						break
+					}
+				}
+				if !spendsCurrent {
+					continue
+				}
+				visited[childHash] = true
+				result = append(result, &TxPackageEntry{
+					TxID:  childHash.String(),
+					VSize: child.vsize,
+					Fee:   child.fee,
+					Depth: current.depth + 1,
+					Ours:  child.ours,
+				})
+				queue = append(queue, queued{tx: child.tx, depth: current.depth + 1})
+			}
+		}
+	}
+	return result, nil
+}
+
+// packageFeeRatePerKb returns the fee rate of the tx together with the given txs.
+func packageFeeRatePerKb(vsize int64, fee btcutil.Amount, entries []*TxPackageEntry) btcutil.Amount {
+	for _, entry := range entries {
+		vsize += entry.VSize
+		fee += entry.Fee
+	}
+	if vsize == 0 {
+		return 0
+	}
+	return fee * 1000 / btcutil.Amount(vsize)
+}
+
+// explain fills in the fee rates, the reason and the child pays for parent estimation of the
+// ancestry, given the size of a child tx and the value of the output it would spend.
+func (ancestry *TxAncestry) explain(childVSize int64, cpfpValue btcutil.Amount) {
+	ancestry.FeeRatePerKb = packageFeeRatePerKb(ancestry.VSize, ancestry.Fee, nil)
+	ancestry.AncestorFeeRatePerKb = packageFeeRatePerKb(ancestry.VSize, ancestry.Fee, ancestry.Ancestors)
+	ancestry.DescendantFeeRatePerKb = packageFeeRatePerKb(ancestry.VSize, ancestry.Fee, ancestry.Descendants)
+	for _, ancestor := range ancestry.Ancestors {
+		if ancestor.Depth > ancestry.Depth {
+			ancestry.Depth = ancestor.Depth
+		}
+	}
+	if ancestry.RequiredFeeRatePerKb == nil {
+		ancestry.Reason = TxAncestryReasonUnknown
+		return
+	}
+	required := *ancestry.RequiredFeeRatePerKb
+	switch {
+	case ancestry.FeeRatePerKb < required:
+		ancestry.Reason = TxAncestryReasonLowFeeRate
+	case ancestry.AncestorFeeRatePerKb < required:
+		ancestry.Reason = TxAncestryReasonLowAncestorFeeRate
+	default:
+		ancestry.Reason = TxAncestryReasonFeeRateSufficient
+		return
+	}
+	packageVSize := ancestry.VSize + childVSize
+	packageFee := ancestry.Fee
+	for _, ancestor := range ancestry.Ancestors {
+		packageVSize += ancestor.VSize
+		packageFee += ancestor.Fee
+	}
+	ancestry.CPFPFee = required*btcutil.Amount(packageVSize)/1000 - packageFee
+	if ancestry.CPFPFee < 0 {
+		ancestry.CPFPFee = 0
+	}
+	ancestry.CPFPPossible = ancestry.CPFPOutPoint != nil && cpfpValue > ancestry.CPFPFee
+}
+
+// feeRateOfTarget returns the estimated fee rate of the fee target, or nil if it is not known.
+func (account *Account) feeRateOfTarget(feeTargetCode FeeTargetCode) *btcutil.Amount {
+	defer account.RLock()()
+	for _, feeTarget := range account.feeTargets {
+		if feeTarget.Code == feeTargetCode {
+			return feeTarget.FeeRatePerKb
+		}
+	}
+	return nil
+}
+
+// TxAncestry looks up the unconfirmed ancestors and descendants of the tx of the account with the
+// given ID with the blockchain backend, and explains whether its fee rate suffices for the fee
+// target and whether a child tx can pay for it. See TxAncestry.
+func (account *Account) TxAncestry(txID string, feeTargetCode FeeTargetCode) (*TxAncestry, error) {
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	account.synchronizer.WaitSynchronized()
+	lookup := &txAncestryLookup{
+		blockchain: account.blockchain,
+		known:      map[chainhash.Hash]*wire.MsgTx{},
+		txs:        map[chainhash.Hash]*wire.MsgTx{},
+		histories:  map[blockchain.ScriptHashHex]blockchain.TxHistory{},
+		entries:    map[chainhash.Hash]*ancestryTx{},
+	}
+	for _, txInfo := range account.Transactions() {
+		lookup.known[txInfo.Tx.TxHash()] = txInfo.Tx
+	}
+	if _, ok := lookup.known[*txHash]; !ok {
+		return nil, errp.New("Transaction not found")
+	}
+	entry, err := lookup.entry(*txHash)
+	if err != nil {
+		return nil, err
+	}
+	ancestry := &TxAncestry{
+		TxID:      txID,
+		VSize:     entry.vsize,
+		Fee:       entry.fee,
+		Confirmed: entry.confirmed,
+	}
+	if entry.confirmed {
+		ancestry.Reason = TxAncestryReasonConfirmed
+		return ancestry, nil
+	}
+	if ancestry.Ancestors, err = lookup.ancestors(entry.tx); err != nil {
+		return nil, err
+	}
+	if ancestry.Descendants, err = lookup.descendants(entry.tx); err != nil {
+		return nil, err
+	}
+	ancestry.RequiredFeeRatePerKb = account.feeRateOfTarget(feeTargetCode)
+
+	var childVSize int64
+	var cpfpValue btcutil.Amount
+	for _, output := range account.SpendableOutputs() {
+		if output.OutPoint.Hash != *txHash || btcutil.Amount(output.Value) <= cpfpValue {
+			continue
+		}
+		outPoint := output.OutPoint
+		ancestry.CPFPOutPoint = &outPoint
+		cpfpValue = btcutil.Amount(output.Value)
+		childVSize = int64(maketx.ChildTxSize(account.signingConfiguration, len(output.PkScript)))
+	}
+	ancestry.explain(childVSize, cpfpValue)
+	return ancestry, nil
+}
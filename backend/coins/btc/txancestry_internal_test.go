@@ -0,0 +1,147 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	blockchainMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+)
+
+func newAncestryTestTx(spends []wire.OutPoint, pkScripts ...[]byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for i := range spends {
+		tx.AddTxIn(wire.NewTxIn(&spends[i], nil, nil))
+	}
+	for _, pkScript := range pkScripts {
+		tx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	}
+	return tx
+}
+
+func TestTxAncestryLookup(t *testing.T) {
+	grandparent := newAncestryTestTx(nil, []byte{0x51, 0x01})
+	parent := newAncestryTestTx([]wire.OutPoint{{Hash: grandparent.TxHash()}}, []byte{0x51, 0x02})
+	tx := newAncestryTestTx([]wire.OutPoint{{Hash: parent.TxHash()}}, []byte{0x51, 0x03}, []byte{0x51, 0x04})
+	child := newAncestryTestTx([]wire.OutPoint{{Hash: tx.TxHash(), Index: 1}}, []byte{0x51, 0x05})
+
+	historyEntry := func(tx *wire.MsgTx, height int, fee int64) *blockchain.TxInfo {
+		return &blockchain.TxInfo{Height: height, TXHash: blockchain.TXHash(tx.TxHash()), Fee: &fee}
+	}
+	histories := map[blockchain.ScriptHashHex]blockchain.TxHistory{}
+	addHistory := func(tx *wire.MsgTx, index int, history ...*blockchain.TxInfo) {
+		scriptHash := (&transactions.SpendableOutput{TxOut: tx.TxOut[index]}).ScriptHashHex()
+		histories[scriptHash] = history
+	}
+	addHistory(grandparent, 0, historyEntry(grandparent, 100, 0), historyEntry(parent, 0, 200))
+	addHistory(parent, 0, historyEntry(parent, 0, 200), historyEntry(tx, -1, 5000))
+	addHistory(tx, 0, historyEntry(tx, -1, 5000))
+	addHistory(tx, 1, historyEntry(tx, -1, 5000), historyEntry(child, -1, 300))
+	addHistory(child, 0, historyEntry(child, -1, 300))
+
+	mockBlockchain := &blockchainMock.Interface{}
+	mockBlockchain.On("ScriptHashGetHistory", mock.Anything, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) {
+			history := histories[args.Get(0).(blockchain.ScriptHashHex)]
+			require.NoError(t, args.Get(1).(func(blockchain.TxHistory) error)(history))
+			args.Get(2).(func())()
+		})
+	txs := map[chainhash.Hash]*wire.MsgTx{}
+	for _, tx := range []*wire.MsgTx{grandparent, parent, child} {
+		txs[tx.TxHash()] = tx
+	}
+	mockBlockchain.On("TransactionGet", mock.Anything, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) {
+			require.NoError(t, args.Get(1).(func(*wire.MsgTx) error)(txs[args.Get(0).(chainhash.Hash)]))
+			args.Get(2).(func())()
+		})
+
+	lookup := &txAncestryLookup{
+		blockchain: mockBlockchain,
+		known:      map[chainhash.Hash]*wire.MsgTx{tx.TxHash(): tx},
+		txs:        map[chainhash.Hash]*wire.MsgTx{},
+		histories:  map[blockchain.ScriptHashHex]blockchain.TxHistory{},
+		entries:    map[chainhash.Hash]*ancestryTx{},
+	}
+	entry, err := lookup.entry(tx.TxHash())
+	require.NoError(t, err)
+	require.False(t, entry.confirmed)
+	require.True(t, entry.ours)
+	require.Equal(t, btcutil.Amount(5000), entry.fee)
+
+	ancestors, err := lookup.ancestors(tx)
+	require.NoError(t, err)
+	require.Len(t, ancestors, 1)
+	require.Equal(t, parent.TxHash().String(), ancestors[0].TxID)
+	require.Equal(t, btcutil.Amount(200), ancestors[0].Fee)
+	require.Equal(t, 1, ancestors[0].Depth)
+	require.False(t, ancestors[0].Ours)
+
+	descendants, err := lookup.descendants(tx)
+	require.NoError(t, err)
+	require.Len(t, descendants, 1)
+	require.Equal(t, child.TxHash().String(), descendants[0].TxID)
+	require.Equal(t, btcutil.Amount(300), descendants[0].Fee)
+}
+
+func TestTxAncestryExplain(t *testing.T) {
+	newAncestry := func(requiredFeeRatePerKb *btcutil.Amount) *TxAncestry {
+		return &TxAncestry{
+			VSize:                200,
+			Fee:                  5000,
+			Ancestors:            []*TxPackageEntry{{VSize: 300, Fee: 300, Depth: 1}},
+			Descendants:          []*TxPackageEntry{{VSize: 100, Fee: 100, Depth: 1}},
+			RequiredFeeRatePerKb: requiredFeeRatePerKb,
+			CPFPOutPoint:         &wire.OutPoint{Index: 1},
+		}
+	}
+	feeRate := func(feeRatePerKb btcutil.Amount) *btcutil.Amount { return &feeRatePerKb }
+
+	ancestry := newAncestry(nil)
+	ancestry.explain(110, 10000)
+	require.Equal(t, TxAncestryReasonUnknown, ancestry.Reason)
+	require.Equal(t, btcutil.Amount(25000), ancestry.FeeRatePerKb)
+	require.Equal(t, btcutil.Amount(10600), ancestry.AncestorFeeRatePerKb)
+	require.Equal(t, btcutil.Amount(17000), ancestry.DescendantFeeRatePerKb)
+	require.Equal(t, 1, ancestry.Depth)
+	require.False(t, ancestry.CPFPPossible)
+
+	// The tx pays enough, but its parent does not.
+	ancestry = newAncestry(feeRate(20000))
+	ancestry.explain(110, 10000)
+	require.Equal(t, TxAncestryReasonLowAncestorFeeRate, ancestry.Reason)
+	require.Equal(t, btcutil.Amount(20000*610/1000-5300), ancestry.CPFPFee)
+	require.True(t, ancestry.CPFPPossible)
+
+	// The output is not worth the fee of the child.
+	ancestry = newAncestry(feeRate(100000))
+	ancestry.explain(110, 10000)
+	require.Equal(t, TxAncestryReasonLowFeeRate, ancestry.Reason)
+	require.False(t, ancestry.CPFPPossible)
+
+	ancestry = newAncestry(feeRate(10000))
+	ancestry.explain(110, 10000)
+	require.Equal(t, TxAncestryReasonFeeRateSufficient, ancestry.Reason)
+	require.Equal(t, btcutil.Amount(0), ancestry.CPFPFee)
+	require.False(t, ancestry.CPFPPossible)
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/vault"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// getVault returns the vault of the account, or nil if the account is not a vault account.
+func (account *Account) getVault() *vault.Vault {
+	defer account.RLock()()
+	return account.vault
+}
+
+// isVaulted returns whether the output is vaulted. Vaulted coins can only be spent by unvaulting
+// them first, so they are excluded from coin selection.
+func (account *Account) isVaulted(outPoint wire.OutPoint) bool {
+	accountVault := account.getVault()
+	return accountVault != nil && accountVault.IsVaulted(outPoint)
+}
+
+// VaultDeposits returns the vaulted outputs of the account. false is returned if the account is
+// not a vault account.
+func (account *Account) VaultDeposits() ([]*vault.Deposit, bool) {
+	accountVault := account.getVault()
+	if accountVault == nil {
+		return nil, false
+	}
+	return accountVault.Deposits(), true
+}
+
+// AddVaultDeposit adds a pre-signed unvaulting tx for an unspent output of the account. The
+// witness script is the script of the unvault output, containing the relative timelock.
+func (account *Account) AddVaultDeposit(unvaultTxHex string, witnessScriptHex string) error {
+	accountVault := account.getVault()
+	if accountVault == nil {
+		return errp.New("The account is not a vault account")
+	}
+	unvaultTx, err := vault.DecodeTx(unvaultTxHex)
+	if err != nil {
+		return errp.WithStack(TxValidationError("invalid unvaulting transaction"))
+	}
+	witnessScript, err := hex.DecodeString(witnessScriptHex)
+	if err != nil {
+		return errp.WithStack(TxValidationError("invalid witness script"))
+	}
+	if len(unvaultTx.TxIn) != 0 {
+		if _, ok := account.transactions.SpendableOutputs()[unvaultTx.TxIn[0].PreviousOutPoint]; !ok {
+			return errp.WithStack(TxValidationError("the unvaulting transaction does not spend an unspent output of the account"))
+		}
+	}
+	_, err = accountVault.AddDeposit(unvaultTx, witnessScript)
+	return err
+}
+
+// Unvault broadcasts the pre-signed unvaulting tx of the given vaulted output.
+func (account *Account) Unvault(outPoint wire.OutPoint) error {
+	accountVault := account.getVault()
+	if accountVault == nil {
+		return errp.New("The account is not a vault account")
+	}
+	unvaultTx, err := accountVault.Initiate(outPoint)
+	if err != nil {
+		return err
+	}
+	account.log.WithField("outpoint", outPoint).Info("Unvaulting transaction is broadcasted")
+	return account.blockchain.TransactionBroadcast(unvaultTx)
+}
+
+// checkVault fires EventVaultUnvaultDetected if a vaulted output was spent without the user
+// initiating it.
+func (account *Account) checkVault() {
+	accountVault := account.getVault()
+	if accountVault == nil {
+		return
+	}
+	txs := []*wire.MsgTx{}
+	for _, txInfo := range account.Transactions() {
+		txs = append(txs, txInfo.Tx)
+	}
+	alerts, err := accountVault.CheckSpends(txs)
+	if err != nil {
+		account.log.WithError(err).Error("Could not persist the vault")
+	}
+	if len(alerts) != 0 {
+		account.onEvent(EventVaultUnvaultDetected)
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vault manages a set of pre-signed unvaulting transactions for a vault account. Coins in a
+// vault can only be spent by first broadcasting the pre-signed unvaulting tx of the deposit, which
+// moves the coins to an output that can only be spent after a relative timelock
+// (OP_CHECKSEQUENCEVERIFY), leaving time to react with the recovery path of that output if the
+// unvaulting was not initiated by the user. The pre-signed transactions are created by the vault
+// setup tool and imported here; the vault monitors the chain and raises alerts for unexpected
+// spends of vaulted outputs.
+package vault
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sequenceLockTimeDisabled = 1 << 31
+	sequenceLockTimeIsTime   = 1 << 22
+	sequenceLockTimeMask     = 0x0000ffff
+)
+
+// Deposit is a vaulted output together with its pre-signed unvaulting tx.
+type Deposit struct {
+	OutPoint wire.OutPoint
+	// UnvaultTx is the fully signed tx spending the vaulted output to the unvault output.
+	UnvaultTx *wire.MsgTx
+	// DelayBlocks is the relative timelock of the unvault output.
+	DelayBlocks int
+	// Initiated is true if the user broadcasted the unvaulting tx through the app.
+	Initiated bool
+	// SpentBy is the tx that spent the vaulted output, or nil if unspent.
+	SpentBy *chainhash.Hash
+	// Alerted is true if an alert has been raised because the output was spent without the user
+	// initiating it.
+	Alerted bool
+}
+
+// Unexpected returns true if the vaulted output was spent without the user initiating the
+// unvaulting, or by a tx other than the pre-signed unvaulting tx.
+func (deposit *Deposit) Unexpected() bool {
+	if deposit.SpentBy == nil {
+		return false
+	}
+	return !deposit.Initiated || *deposit.SpentBy != deposit.UnvaultTx.TxHash()
+}
+
+type depositEncoding struct {
+	OutPoint    string  `json:"outPoint"`
+	UnvaultTx   string  `json:"unvaultTx"`
+	DelayBlocks int     `json:"delayBlocks"`
+	Initiated   bool    `json:"initiated"`
+	SpentBy     *string `json:"spentBy"`
+	Alerted     bool    `json:"alerted"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (deposit *Deposit) MarshalJSON() ([]byte, error) {
+	var unvaultTx bytes.Buffer
+	if err := deposit.UnvaultTx.Serialize(&unvaultTx); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	encoding := &depositEncoding{
+		OutPoint:    deposit.OutPoint.String(),
+		UnvaultTx:   hex.EncodeToString(unvaultTx.Bytes()),
+		DelayBlocks: deposit.DelayBlocks,
+		Initiated:   deposit.Initiated,
+		Alerted:     deposit.Alerted,
+	}
+	if deposit.SpentBy != nil {
+		spentBy := deposit.SpentBy.String()
+		encoding.SpentBy = &spentBy
+	}
+	return json.Marshal(encoding)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (deposit *Deposit) UnmarshalJSON(jsonBytes []byte) error {
+	var encoding depositEncoding
+	if err := json.Unmarshal(jsonBytes, &encoding); err != nil {
+		return errp.WithStack(err)
+	}
+	unvaultTx, err := DecodeTx(encoding.UnvaultTx)
+	if err != nil {
+		return err
+	}
+	if len(unvaultTx.TxIn) != 1 {
+		return errp.New("The unvaulting tx must spend exactly one output")
+	}
+	deposit.UnvaultTx = unvaultTx
+	deposit.OutPoint = unvaultTx.TxIn[0].PreviousOutPoint
+	if deposit.OutPoint.String() != encoding.OutPoint {
+		return errp.New("outpoint does not match the unvaulting tx")
+	}
+	deposit.DelayBlocks = encoding.DelayBlocks
+	deposit.Initiated = encoding.Initiated
+	deposit.Alerted = encoding.Alerted
+	if encoding.SpentBy != nil {
+		spentBy, err := chainhash.NewHashFromStr(*encoding.SpentBy)
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		deposit.SpentBy = spentBy
+	}
+	return nil
+}
+
+// DecodeTx decodes a hex encoded serialized tx.
+func DecodeTx(txHex string) (*wire.MsgTx, error) {
+	txBytes, err := hex.DecodeString(strings.TrimSpace(txHex))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return tx, nil
+}
+
+// RelativeTimelock returns the relative timelock in blocks enforced by the first
+// OP_CHECKSEQUENCEVERIFY in the script. false is returned if the script does not contain a block
+// based relative timelock.
+func RelativeTimelock(script []byte) (int, bool) {
+	var lastNumber *int64
+	for index := 0; index < len(script); {
+		opcode := script[index]
+		index++
+		switch {
+		case opcode >= txscript.OP_1 && opcode <= txscript.OP_16:
+			number := int64(opcode - txscript.OP_1 + 1)
+			lastNumber = &number
+		case opcode >= txscript.OP_DATA_1 && opcode <= txscript.OP_DATA_75:
+			length := int(opcode)
+			if index+length > len(script) {
+				return 0, false
+			}
+			lastNumber = decodeScriptNum(script[index : index+length])
+			index += length
+		case opcode == txscript.OP_PUSHDATA1 || opcode == txscript.OP_PUSHDATA2 ||
+			opcode == txscript.OP_PUSHDATA4:
+			// Too large for a sequence number.
+			return 0, false
+		case opcode == txscript.OP_CHECKSEQUENCEVERIFY:
+			if lastNumber == nil || *lastNumber < 0 ||
+				*lastNumber&(sequenceLockTimeDisabled|sequenceLockTimeIsTime) != 0 {
+				return 0, false
+			}
+			return int(*lastNumber & sequenceLockTimeMask), true
+		default:
+			lastNumber = nil
+		}
+	}
+	return 0, false
+}
+
+// decodeScriptNum decodes a little endian script number with sign bit. nil is returned if the data
+// is too long to be a number.
+func decodeScriptNum(data []byte) *int64 {
+	if len(data) > 5 {
+		return nil
+	}
+	var result int64
+	for index, b := range data {
+		result |= int64(b) << uint(8*index)
+	}
+	if len(data) > 0 && data[len(data)-1]&0x80 != 0 {
+		result &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		result = -result
+	}
+	return &result
+}
+
+// Vault manages the pre-signed unvaulting transactions of a vault account and persists them in a
+// file.
+type Vault struct {
+	lock        locker.Locker
+	filename    string
+	delayBlocks int
+	deposits    map[wire.OutPoint]*Deposit
+	log         *logrus.Entry
+}
+
+// NewVault creates a new Vault, stored in the given file. Unvaulting transactions must lock the
+// coins for at least delayBlocks blocks.
+func NewVault(filename string, delayBlocks int, log *logrus.Entry) (*Vault, error) {
+	vault := &Vault{
+		filename:    filename,
+		delayBlocks: delayBlocks,
+		deposits:    map[wire.OutPoint]*Deposit{},
+		log:         log.WithField("group", "vault"),
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return vault, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	deposits := []*Deposit{}
+	if err := json.Unmarshal(jsonBytes, &deposits); err != nil {
+		return nil, errp.WithMessage(err, "Could not read the vault file")
+	}
+	for _, deposit := range deposits {
+		vault.deposits[deposit.OutPoint] = deposit
+	}
+	return vault, nil
+}
+
+func (vault *Vault) save() error {
+	jsonBytes, err := json.Marshal(vault.depositsList())
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(vault.filename, jsonBytes, 0600))
+}
+
+func (vault *Vault) depositsList() []*Deposit {
+	deposits := []*Deposit{}
+	for _, deposit := range vault.deposits {
+		deposits = append(deposits, deposit)
+	}
+	return deposits
+}
+
+// AddDeposit adds a vaulted output with its pre-signed unvaulting tx. The unvaulting tx must spend
+// only the vaulted output, and one of its outputs must pay to the given witness script, which has
+// to enforce a relative timelock of at least the configured delay.
+func (vault *Vault) AddDeposit(unvaultTx *wire.MsgTx, witnessScript []byte) (*Deposit, error) {
+	if len(unvaultTx.TxIn) != 1 {
+		return nil, errp.New("The unvaulting tx must spend exactly one output")
+	}
+	if len(unvaultTx.TxIn[0].Witness) == 0 && len(unvaultTx.TxIn[0].SignatureScript) == 0 {
+		return nil, errp.New("The unvaulting tx is not signed")
+	}
+	witnessScriptHash := chainhash.HashB(witnessScript)
+	unvaultPkScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(witnessScriptHash).Script()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	found := false
+	for _, txOut := range unvaultTx.TxOut {
+		if bytes.Equal(txOut.PkScript, unvaultPkScript) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errp.New("The unvaulting tx does not pay to the witness script")
+	}
+	delayBlocks, ok := RelativeTimelock(witnessScript)
+	if !ok {
+		return nil, errp.New("The witness script does not contain a relative timelock")
+	}
+	if delayBlocks < vault.delayBlocks {
+		return nil, errp.Newf("The timelock of %d blocks is shorter than the required %d blocks",
+			delayBlocks, vault.delayBlocks)
+	}
+	defer vault.lock.Lock()()
+	deposit := &Deposit{
+		OutPoint:    unvaultTx.TxIn[0].PreviousOutPoint,
+		UnvaultTx:   unvaultTx,
+		DelayBlocks: delayBlocks,
+	}
+	if _, ok := vault.deposits[deposit.OutPoint]; ok {
+		return nil, errp.New("The output is already vaulted")
+	}
+	vault.deposits[deposit.OutPoint] = deposit
+	vault.log.WithField("outpoint", deposit.OutPoint).Info("Added vault deposit")
+	return deposit, vault.save()
+}
+
+// Deposits returns all vaulted outputs.
+func (vault *Vault) Deposits() []*Deposit {
+	defer vault.lock.RLock()()
+	return vault.depositsList()
+}
+
+// IsVaulted returns true if the output is in the vault and has not been spent yet.
+func (vault *Vault) IsVaulted(outPoint wire.OutPoint) bool {
+	defer vault.lock.RLock()()
+	deposit, ok := vault.deposits[outPoint]
+	return ok && deposit.SpentBy == nil
+}
+
+// Initiate marks the unvaulting of the given output as initiated by the user, and returns the
+// unvaulting tx to broadcast.
+func (vault *Vault) Initiate(outPoint wire.OutPoint) (*wire.MsgTx, error) {
+	defer vault.lock.Lock()()
+	deposit, ok := vault.deposits[outPoint]
+	if !ok {
+		return nil, errp.New("The output is not vaulted")
+	}
+	deposit.Initiated = true
+	vault.log.WithField("outpoint", outPoint).Info("Initiated unvaulting")
+	return deposit.UnvaultTx, vault.save()
+}
+
+// CheckSpends records which vaulted outputs are spent by the given transactions. It returns the
+// deposits which were spent unexpectedly (see Deposit.Unexpected()) and for which no alert has been
+// raised yet. They are marked as alerted.
+func (vault *Vault) CheckSpends(txs []*wire.MsgTx) ([]*Deposit, error) {
+	defer vault.lock.Lock()()
+	alerts := []*Deposit{}
+	changed := false
+	for _, tx := range txs {
+		for _, txIn := range tx.TxIn {
+			deposit, ok := vault.deposits[txIn.PreviousOutPoint]
+			if !ok || deposit.SpentBy != nil {
+				continue
+			}
+			txHash := tx.TxHash()
+			deposit.SpentBy = &txHash
+			changed = true
+			if deposit.Unexpected() && !deposit.Alerted {
+				deposit.Alerted = true
+				alerts = append(alerts, deposit)
+				vault.log.WithFields(logrus.Fields{
+					"outpoint": deposit.OutPoint, "txid": txHash}).Warn("Unexpected unvaulting detected")
+			}
+		}
+	}
+	if !changed {
+		return alerts, nil
+	}
+	return alerts, vault.save()
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/vault"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+// witnessScript returns a script spendable by the recovery key immediately or by the hot key after
+// the delay.
+func witnessScript(t *testing.T, delay int64) []byte {
+	script, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_IF).
+		AddData(make([]byte, 33)).
+		AddOp(txscript.OP_ELSE).
+		AddInt64(delay).
+		AddOp(txscript.OP_CHECKSEQUENCEVERIFY).
+		AddOp(txscript.OP_DROP).
+		AddData(make([]byte, 33)).
+		AddOp(txscript.OP_ENDIF).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	require.NoError(t, err)
+	return script
+}
+
+func unvaultTx(t *testing.T, outPoint wire.OutPoint, script []byte) *wire.MsgTx {
+	pkScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(chainhash.HashB(script)).Script()
+	require.NoError(t, err)
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&outPoint, nil, [][]byte{{1}}))
+	tx.AddTxOut(wire.NewTxOut(100000, pkScript))
+	return tx
+}
+
+func TestRelativeTimelock(t *testing.T) {
+	for _, delay := range []int64{1, 16, 17, 144, 1000, 65535} {
+		timelock, ok := vault.RelativeTimelock(witnessScript(t, delay))
+		require.True(t, ok)
+		require.Equal(t, int(delay), timelock)
+	}
+	// Time based relative timelock.
+	_, ok := vault.RelativeTimelock(witnessScript(t, 1<<22|100))
+	require.False(t, ok)
+	_, ok = vault.RelativeTimelock([]byte{txscript.OP_CHECKSIG})
+	require.False(t, ok)
+}
+
+func TestVault(t *testing.T) {
+	filename := path.Join(test.TstTempDir("vault"), "vault.json")
+	log := logging.Get().WithGroup("vault_test")
+	theVault, err := vault.NewVault(filename, 144, log)
+	require.NoError(t, err)
+
+	outPoint1 := wire.OutPoint{Hash: chainhash.HashH([]byte("1")), Index: 0}
+	outPoint2 := wire.OutPoint{Hash: chainhash.HashH([]byte("2")), Index: 1}
+
+	// Timelock too short.
+	_, err = theVault.AddDeposit(unvaultTx(t, outPoint1, witnessScript(t, 10)), witnessScript(t, 10))
+	require.Error(t, err)
+	// Witness script does not match the output.
+	_, err = theVault.AddDeposit(unvaultTx(t, outPoint1, witnessScript(t, 144)), witnessScript(t, 145))
+	require.Error(t, err)
+
+	tx1 := unvaultTx(t, outPoint1, witnessScript(t, 144))
+	_, err = theVault.AddDeposit(tx1, witnessScript(t, 144))
+	require.NoError(t, err)
+	tx2 := unvaultTx(t, outPoint2, witnessScript(t, 200))
+	_, err = theVault.AddDeposit(tx2, witnessScript(t, 200))
+	require.NoError(t, err)
+	require.True(t, theVault.IsVaulted(outPoint1))
+
+	initiatedTx, err := theVault.Initiate(outPoint1)
+	require.NoError(t, err)
+	require.Equal(t, tx1.TxHash(), initiatedTx.TxHash())
+
+	// Reload from disk.
+	theVault, err = vault.NewVault(filename, 144, log)
+	require.NoError(t, err)
+	require.Len(t, theVault.Deposits(), 2)
+
+	// The initiated unvaulting is expected, the other one is not.
+	alerts, err := theVault.CheckSpends([]*wire.MsgTx{tx1, tx2})
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, outPoint2, alerts[0].OutPoint)
+	require.False(t, theVault.IsVaulted(outPoint1))
+
+	// No repeated alerts.
+	alerts, err = theVault.CheckSpends([]*wire.MsgTx{tx1, tx2})
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+}
+
+func TestDepositUnmarshalJSON(t *testing.T) {
+	outPoint := wire.OutPoint{Hash: chainhash.HashH([]byte("1")), Index: 0}
+	deposit := &vault.Deposit{
+		OutPoint:    outPoint,
+		UnvaultTx:   unvaultTx(t, outPoint, witnessScript(t, 144)),
+		DelayBlocks: 144,
+	}
+	jsonBytes, err := json.Marshal(deposit)
+	require.NoError(t, err)
+	var decoded vault.Deposit
+	require.NoError(t, json.Unmarshal(jsonBytes, &decoded))
+	require.Equal(t, outPoint, decoded.OutPoint)
+
+	// The unvaulting tx must spend exactly one output.
+	invalidTx := unvaultTx(t, outPoint, witnessScript(t, 144))
+	invalidTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, [][]byte{{1}}))
+	var rawTx bytes.Buffer
+	require.NoError(t, invalidTx.Serialize(&rawTx))
+	jsonBytes, err = json.Marshal(map[string]interface{}{
+		"outPoint":  outPoint.String(),
+		"unvaultTx": hex.EncodeToString(rawTx.Bytes()),
+	})
+	require.NoError(t, err)
+	require.Error(t, json.Unmarshal(jsonBytes, &decoded))
+}
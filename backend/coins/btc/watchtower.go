@@ -0,0 +1,85 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/vault"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/watchtower"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// WatchedTxs returns the signed transactions watched until they are broadcast.
+func (account *Account) WatchedTxs() []*watchtower.WatchedTx {
+	return account.watchtower.WatchedTxs()
+}
+
+// WatchTx watches a hex encoded signed tx spending coins of the account, e.g. a timelocked
+// recovery tx, to be alerted when it can be broadcast or when it is invalidated by a conflicting
+// tx.
+func (account *Account) WatchTx(txHex string) error {
+	tx, err := vault.DecodeTx(txHex)
+	if err != nil {
+		return errp.WithStack(TxValidationError("invalid transaction"))
+	}
+	spendable := account.transactions.SpendableOutputs()
+	for _, txIn := range tx.TxIn {
+		if _, ok := spendable[txIn.PreviousOutPoint]; !ok {
+			return errp.WithStack(TxValidationError("the transaction does not spend unspent outputs of the account"))
+		}
+	}
+	if _, err := account.watchtower.Watch(tx); err != nil {
+		return err
+	}
+	go account.checkWatchtower()
+	return nil
+}
+
+// UnwatchTx stops watching the tx with the given ID.
+func (account *Account) UnwatchTx(txID string) error {
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return account.watchtower.Unwatch(*txHash)
+}
+
+// checkWatchtower fires EventWatchedTxAlert if a watched tx can now be broadcast or was invalidated
+// by a conflicting tx.
+func (account *Account) checkWatchtower() {
+	if account.watchtower == nil || account.transactions == nil {
+		return
+	}
+	txs := []*wire.MsgTx{}
+	heights := map[chainhash.Hash]int{}
+	for _, txInfo := range account.Transactions() {
+		txs = append(txs, txInfo.Tx)
+		heights[txInfo.Tx.TxHash()] = txInfo.Height
+	}
+	inputHeight := func(outPoint wire.OutPoint) int {
+		return heights[outPoint.Hash]
+	}
+	alerts, err := account.watchtower.Check(account.headers.TipHeight(), time.Now(), txs, inputHeight)
+	if err != nil {
+		account.log.WithError(err).Error("Could not persist the watchtower")
+	}
+	if len(alerts) != 0 {
+		account.onEvent(EventWatchedTxAlert)
+	}
+}
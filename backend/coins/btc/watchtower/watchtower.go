@@ -0,0 +1,303 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watchtower monitors signed transactions which were exported instead of broadcast, e.g.
+// transactions signed offline or timelocked recovery transactions. It alerts when such a tx can be
+// broadcast, i.e. its timelocks have expired, or when one of its inputs is spent by a conflicting
+// tx, which makes it invalid.
+package watchtower
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+// medianTimePastLag is how far the median time past of the chain, which time based timelocks are
+// checked against, lags behind the current time.
+const medianTimePastLag = time.Hour
+
+// State is the state of a watched tx.
+type State string
+
+const (
+	// StatePending means that the tx can not be broadcast yet because of a timelock, or that it
+	// was not checked yet.
+	StatePending State = "pending"
+	// StateBroadcastable means that all timelocks of the tx have expired and none of its inputs
+	// are spent.
+	StateBroadcastable State = "broadcastable"
+	// StateBroadcast means that the tx was seen in the mempool or in the chain.
+	StateBroadcast State = "broadcast"
+	// StateConflicted means that an input of the tx was spent by another tx.
+	StateConflicted State = "conflicted"
+)
+
+// WatchedTx is a signed tx watched by the watchtower.
+type WatchedTx struct {
+	Tx    *wire.MsgTx
+	Added time.Time
+	State State
+	// ConflictingTx is the tx which spent an input of the watched tx, if the state is
+	// StateConflicted.
+	ConflictingTx *chainhash.Hash
+}
+
+type watchedTxEncoding struct {
+	Tx            string    `json:"tx"`
+	Added         time.Time `json:"added"`
+	State         State     `json:"state"`
+	ConflictingTx *string   `json:"conflictingTx"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (watchedTx *WatchedTx) MarshalJSON() ([]byte, error) {
+	var tx bytes.Buffer
+	if err := watchedTx.Tx.Serialize(&tx); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	encoding := &watchedTxEncoding{
+		Tx:    hex.EncodeToString(tx.Bytes()),
+		Added: watchedTx.Added,
+		State: watchedTx.State,
+	}
+	if watchedTx.ConflictingTx != nil {
+		conflictingTx := watchedTx.ConflictingTx.String()
+		encoding.ConflictingTx = &conflictingTx
+	}
+	return json.Marshal(encoding)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (watchedTx *WatchedTx) UnmarshalJSON(jsonBytes []byte) error {
+	var encoding watchedTxEncoding
+	if err := json.Unmarshal(jsonBytes, &encoding); err != nil {
+		return errp.WithStack(err)
+	}
+	txBytes, err := hex.DecodeString(encoding.Tx)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return errp.WithStack(err)
+	}
+	watchedTx.Tx = tx
+	watchedTx.Added = encoding.Added
+	watchedTx.State = encoding.State
+	if encoding.ConflictingTx != nil {
+		conflictingTx, err := chainhash.NewHashFromStr(*encoding.ConflictingTx)
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		watchedTx.ConflictingTx = conflictingTx
+	}
+	return nil
+}
+
+// TimelocksExpired returns true if the tx can be included in the next block as far as its absolute
+// (nLockTime) and block based relative (BIP68) timelocks are concerned. inputHeight returns the
+// height at which the output spent by an input was confirmed, or 0 if it is unconfirmed or unknown.
+// Time based relative timelocks are not checked.
+func TimelocksExpired(
+	tx *wire.MsgTx, tipHeight int, now time.Time, inputHeight func(wire.OutPoint) int) bool {
+	nextHeight := int64(tipHeight) + 1
+	finalSequences := true
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence != wire.MaxTxInSequenceNum {
+			finalSequences = false
+		}
+	}
+	if tx.LockTime != 0 && !finalSequences {
+		if tx.LockTime < txscript.LockTimeThreshold {
+			if int64(tx.LockTime) >= nextHeight {
+				return false
+			}
+		} else if int64(tx.LockTime) >= now.Add(-medianTimePastLag).Unix() {
+			return false
+		}
+	}
+	if tx.Version < 2 {
+		return true
+	}
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence&wire.SequenceLockTimeDisabled != 0 ||
+			txIn.Sequence&wire.SequenceLockTimeIsSeconds != 0 {
+			continue
+		}
+		relativeLockTime := int64(txIn.Sequence & wire.SequenceLockTimeMask)
+		if relativeLockTime == 0 {
+			continue
+		}
+		height := inputHeight(txIn.PreviousOutPoint)
+		if height <= 0 {
+			return false
+		}
+		if int64(height)+relativeLockTime > nextHeight {
+			return false
+		}
+	}
+	return true
+}
+
+// Watchtower watches exported signed transactions and persists them in a file.
+type Watchtower struct {
+	lock     locker.Locker
+	filename string
+	txs      map[chainhash.Hash]*WatchedTx
+	log      *logrus.Entry
+}
+
+// NewWatchtower creates a new Watchtower, stored in the given file.
+func NewWatchtower(filename string, log *logrus.Entry) (*Watchtower, error) {
+	watchtower := &Watchtower{
+		filename: filename,
+		txs:      map[chainhash.Hash]*WatchedTx{},
+		log:      log.WithField("group", "watchtower"),
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return watchtower, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	txs := []*WatchedTx{}
+	if err := json.Unmarshal(jsonBytes, &txs); err != nil {
+		return nil, errp.WithMessage(err, "Could not read the watchtower file")
+	}
+	for _, watchedTx := range txs {
+		watchtower.txs[watchedTx.Tx.TxHash()] = watchedTx
+	}
+	return watchtower, nil
+}
+
+func (watchtower *Watchtower) save() error {
+	jsonBytes, err := json.Marshal(watchtower.txsList())
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(watchtower.filename, jsonBytes, 0600))
+}
+
+func (watchtower *Watchtower) txsList() []*WatchedTx {
+	txs := []*WatchedTx{}
+	for _, watchedTx := range watchtower.txs {
+		txs = append(txs, watchedTx)
+	}
+	return txs
+}
+
+// Watch starts watching the given signed tx. Watching a tx which is already watched does nothing.
+func (watchtower *Watchtower) Watch(tx *wire.MsgTx) (*WatchedTx, error) {
+	if len(tx.TxIn) == 0 {
+		return nil, errp.New("The tx has no inputs")
+	}
+	for _, txIn := range tx.TxIn {
+		if len(txIn.Witness) == 0 && len(txIn.SignatureScript) == 0 {
+			return nil, errp.New("The tx is not signed")
+		}
+	}
+	defer watchtower.lock.Lock()()
+	txHash := tx.TxHash()
+	if watchedTx, ok := watchtower.txs[txHash]; ok {
+		return watchedTx, nil
+	}
+	watchedTx := &WatchedTx{Tx: tx, Added: time.Now(), State: StatePending}
+	watchtower.txs[txHash] = watchedTx
+	watchtower.log.WithField("txid", txHash).Info("Watching tx")
+	return watchedTx, watchtower.save()
+}
+
+// Unwatch stops watching the tx with the given hash.
+func (watchtower *Watchtower) Unwatch(txHash chainhash.Hash) error {
+	defer watchtower.lock.Lock()()
+	if _, ok := watchtower.txs[txHash]; !ok {
+		return errp.New("The tx is not watched")
+	}
+	delete(watchtower.txs, txHash)
+	return watchtower.save()
+}
+
+// WatchedTxs returns all watched transactions.
+func (watchtower *Watchtower) WatchedTxs() []*WatchedTx {
+	defer watchtower.lock.RLock()()
+	return watchtower.txsList()
+}
+
+// Check updates the state of all watched transactions given the current chain tip and the
+// transactions of the wallet (see TimelocksExpired() for inputHeight). It returns the watched
+// transactions which became broadcastable or conflicted since the previous check.
+func (watchtower *Watchtower) Check(
+	tipHeight int,
+	now time.Time,
+	txs []*wire.MsgTx,
+	inputHeight func(wire.OutPoint) int,
+) ([]*WatchedTx, error) {
+	defer watchtower.lock.Lock()()
+	known := map[chainhash.Hash]struct{}{}
+	spentBy := map[wire.OutPoint]chainhash.Hash{}
+	for _, tx := range txs {
+		txHash := tx.TxHash()
+		known[txHash] = struct{}{}
+		for _, txIn := range tx.TxIn {
+			spentBy[txIn.PreviousOutPoint] = txHash
+		}
+	}
+	alerts := []*WatchedTx{}
+	changed := false
+	for txHash, watchedTx := range watchtower.txs {
+		state := StatePending
+		var conflictingTx *chainhash.Hash
+		if _, ok := known[txHash]; ok {
+			state = StateBroadcast
+		} else {
+			for _, txIn := range watchedTx.Tx.TxIn {
+				if spendingTx, ok := spentBy[txIn.PreviousOutPoint]; ok {
+					state = StateConflicted
+					conflictingTx = &spendingTx
+					break
+				}
+			}
+			if state == StatePending && TimelocksExpired(watchedTx.Tx, tipHeight, now, inputHeight) {
+				state = StateBroadcastable
+			}
+		}
+		if state == watchedTx.State {
+			continue
+		}
+		watchtower.log.WithFields(logrus.Fields{
+			"txid": txHash, "state": state, "previous-state": watchedTx.State}).Info("Watched tx changed")
+		watchedTx.State = state
+		watchedTx.ConflictingTx = conflictingTx
+		changed = true
+		if state == StateBroadcastable || state == StateConflicted {
+			alerts = append(alerts, watchedTx)
+		}
+	}
+	if !changed {
+		return alerts, nil
+	}
+	return alerts, watchtower.save()
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watchtower_test
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/watchtower"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func signedTx(outPoint wire.OutPoint, sequence uint32, lockTime uint32) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	txIn := wire.NewTxIn(&outPoint, nil, [][]byte{{1}})
+	txIn.Sequence = sequence
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(100000, []byte{0x51}))
+	tx.LockTime = lockTime
+	return tx
+}
+
+func noInputHeight(wire.OutPoint) int { return 0 }
+
+func TestTimelocksExpired(t *testing.T) {
+	outPoint := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	now := time.Now()
+
+	// No timelocks.
+	tx := signedTx(outPoint, wire.MaxTxInSequenceNum, 0)
+	require.True(t, watchtower.TimelocksExpired(tx, 100, now, noInputHeight))
+
+	// Absolute height timelock.
+	tx = signedTx(outPoint, wire.MaxTxInSequenceNum-1, 101)
+	require.False(t, watchtower.TimelocksExpired(tx, 100, now, noInputHeight))
+	require.True(t, watchtower.TimelocksExpired(tx, 101, now, noInputHeight))
+
+	// The locktime is ignored if all sequences are final.
+	tx = signedTx(outPoint, wire.MaxTxInSequenceNum, 1000)
+	require.True(t, watchtower.TimelocksExpired(tx, 100, now, noInputHeight))
+
+	// Absolute time timelock.
+	tx = signedTx(outPoint, wire.MaxTxInSequenceNum-1, uint32(now.Add(time.Hour).Unix()))
+	require.False(t, watchtower.TimelocksExpired(tx, 100, now, noInputHeight))
+	require.True(t, watchtower.TimelocksExpired(tx, 100, now.Add(3*time.Hour), noInputHeight))
+
+	// Relative block timelock.
+	tx = signedTx(outPoint, 10, 0)
+	require.False(t, watchtower.TimelocksExpired(tx, 100, now, noInputHeight))
+	inputHeight := func(wire.OutPoint) int { return 95 }
+	require.False(t, watchtower.TimelocksExpired(tx, 100, now, inputHeight))
+	require.True(t, watchtower.TimelocksExpired(tx, 104, now, inputHeight))
+}
+
+func TestWatchtower(t *testing.T) {
+	filename := path.Join(test.TstTempDir("watchtower"), "watchtower.json")
+	log := logging.Get().WithGroup("watchtower_test")
+	wt, err := watchtower.NewWatchtower(filename, log)
+	require.NoError(t, err)
+
+	outPoint := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	tx := signedTx(outPoint, wire.MaxTxInSequenceNum-1, 101)
+	_, err = wt.Watch(tx)
+	require.NoError(t, err)
+
+	unsigned := signedTx(outPoint, 0, 0)
+	unsigned.TxIn[0].Witness = nil
+	_, err = wt.Watch(unsigned)
+	require.Error(t, err)
+
+	// Timelocked.
+	alerts, err := wt.Check(99, time.Now(), nil, noInputHeight)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+	require.Equal(t, watchtower.StatePending, wt.WatchedTxs()[0].State)
+
+	// Timelock expired.
+	alerts, err = wt.Check(101, time.Now(), nil, noInputHeight)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, watchtower.StateBroadcastable, alerts[0].State)
+
+	// No repeated alert.
+	alerts, err = wt.Check(102, time.Now(), nil, noInputHeight)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	// Conflicting spend, persisted.
+	conflict := signedTx(outPoint, wire.MaxTxInSequenceNum, 0)
+	conflict.TxOut[0].Value = 90000
+	alerts, err = wt.Check(102, time.Now(), []*wire.MsgTx{conflict}, noInputHeight)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, watchtower.StateConflicted, alerts[0].State)
+	conflictHash := conflict.TxHash()
+	require.Equal(t, &conflictHash, alerts[0].ConflictingTx)
+
+	wt, err = watchtower.NewWatchtower(filename, log)
+	require.NoError(t, err)
+	watchedTxs := wt.WatchedTxs()
+	require.Len(t, watchedTxs, 1)
+	require.Equal(t, tx.TxHash(), watchedTxs[0].Tx.TxHash())
+	require.Equal(t, watchtower.StateConflicted, watchedTxs[0].State)
+	require.Equal(t, &conflictHash, watchedTxs[0].ConflictingTx)
+
+	// Broadcast.
+	alerts, err = wt.Check(102, time.Now(), []*wire.MsgTx{tx}, noInputHeight)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+	require.Equal(t, watchtower.StateBroadcast, wt.WatchedTxs()[0].State)
+
+	require.NoError(t, wt.Unwatch(tx.TxHash()))
+	require.Empty(t, wt.WatchedTxs())
+	require.Error(t, wt.Unwatch(tx.TxHash()))
+}
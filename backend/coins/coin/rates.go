@@ -15,6 +15,8 @@
 package coin
 
 import (
+	"time"
+
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 )
 
@@ -22,4 +24,8 @@ import (
 type RatesUpdater interface {
 	observable.Interface
 	Last() map[string]map[string]float64
+	// LastUpdate returns the time the rates returned by Last() were fetched.
+	LastUpdate() time.Time
+	// Source returns the name of the service the rates are fetched from.
+	Source() string
 }
@@ -0,0 +1,86 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinmetadata
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// builtinVersion is the version of the bundled metadata. Published bundles must have a higher
+// version to replace it.
+const builtinVersion = 1
+
+// logo returns a round logo with the given color and letter as a data URI.
+func logo(color string, letter string) string {
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 32 32">`+
+		`<circle cx="16" cy="16" r="16" fill="%s"/>`+
+		`<text x="16" y="22" font-family="sans-serif" font-size="18" font-weight="bold" `+
+		`fill="#fff" text-anchor="middle">%s</text></svg>`, color, letter)
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg))
+}
+
+// builtinBundle returns the metadata bundled with the app, which is used offline and until a newer
+// bundle was fetched.
+func builtinBundle() *Bundle {
+	return &Bundle{
+		Version: builtinVersion,
+		Coins: []*Coin{
+			{
+				Code:               "btc",
+				Name:               "Bitcoin",
+				Unit:               "BTC",
+				Decimals:           8,
+				Logo:               logo("#f7931a", "B"),
+				ExplorerTxURL:      "https://blockchain.info/tx/" + TxIDPlaceholder,
+				ExplorerAddressURL: "https://blockchain.info/address/" + AddressPlaceholder,
+			},
+			{
+				Code:               "tbtc",
+				Name:               "Bitcoin Testnet",
+				Unit:               "TBTC",
+				Decimals:           8,
+				Logo:               logo("#9e9e9e", "B"),
+				ExplorerTxURL:      "https://testnet.blockchain.info/tx/" + TxIDPlaceholder,
+				ExplorerAddressURL: "https://testnet.blockchain.info/address/" + AddressPlaceholder,
+			},
+			{
+				Code:     "rbtc",
+				Name:     "Bitcoin Regtest",
+				Unit:     "RBTC",
+				Decimals: 8,
+				Logo:     logo("#9e9e9e", "B"),
+			},
+			{
+				Code:               "ltc",
+				Name:               "Litecoin",
+				Unit:               "LTC",
+				Decimals:           8,
+				Logo:               logo("#345d9d", "L"),
+				ExplorerTxURL:      "https://insight.litecore.io/tx/" + TxIDPlaceholder,
+				ExplorerAddressURL: "https://insight.litecore.io/address/" + AddressPlaceholder,
+			},
+			{
+				Code:               "tltc",
+				Name:               "Litecoin Testnet",
+				Unit:               "TLTC",
+				Decimals:           8,
+				Logo:               logo("#9e9e9e", "L"),
+				ExplorerTxURL:      "http://explorer.litecointools.com/tx/" + TxIDPlaceholder,
+				ExplorerAddressURL: "http://explorer.litecointools.com/address/" + AddressPlaceholder,
+			},
+		},
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coinmetadata provides how coins are displayed: their logos, the number of decimals and
+// the block explorer URLs. The metadata is bundled with the app, so that it is available offline,
+// and can be replaced by a newer bundle signed by the app developers, see Registry.Refresh().
+package coinmetadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// BundleURL is where the latest signed bundle is published.
+const BundleURL = "https://shiftcrypto.ch/updates/coins.json"
+
+const (
+	// TxIDPlaceholder is replaced by the transaction ID in Coin.ExplorerTxURL.
+	TxIDPlaceholder = "{txid}"
+	// AddressPlaceholder is replaced by the address in Coin.ExplorerAddressURL.
+	AddressPlaceholder = "{address}"
+
+	maxDecimals = 18
+)
+
+var codeRegexp = regexp.MustCompile(`^[a-z][a-z0-9]{1,9}$`)
+
+// Coin is the display metadata of a coin.
+type Coin struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+	// Decimals is the number of decimals shown for amounts.
+	Decimals int `json:"decimals"`
+	// Logo is a data URI of the logo image, so that it can be used without further requests.
+	Logo string `json:"logo"`
+	// ExplorerTxURL is the URL of a transaction in the block explorer, which ends with
+	// TxIDPlaceholder. It is empty if there is no block explorer.
+	ExplorerTxURL string `json:"explorerTxURL"`
+	// ExplorerAddressURL is the URL of an address in the block explorer, which contains
+	// AddressPlaceholder. It is optional.
+	ExplorerAddressURL string `json:"explorerAddressURL,omitempty"`
+}
+
+// TxURL returns the URL of the transaction in the block explorer, or "" if there is none.
+func (coin *Coin) TxURL(txID string) string {
+	if coin.ExplorerTxURL == "" {
+		return ""
+	}
+	return strings.Replace(coin.ExplorerTxURL, TxIDPlaceholder, url.PathEscape(txID), 1)
+}
+
+// TxURLPrefix returns the URL of a transaction without the transaction ID, to which the
+// transaction ID is appended.
+func (coin *Coin) TxURLPrefix() string {
+	return strings.TrimSuffix(coin.ExplorerTxURL, TxIDPlaceholder)
+}
+
+func (coin *Coin) validate() error {
+	if !codeRegexp.MatchString(coin.Code) {
+		return errp.Newf("invalid code %q", coin.Code)
+	}
+	if coin.Name == "" || coin.Unit == "" {
+		return errp.Newf("%s: the name and the unit are required", coin.Code)
+	}
+	if coin.Decimals < 0 || coin.Decimals > maxDecimals {
+		return errp.Newf("%s: the decimals must be between 0 and %d", coin.Code, maxDecimals)
+	}
+	if coin.Logo != "" && !strings.HasPrefix(coin.Logo, "data:image/") {
+		return errp.Newf("%s: the logo must be an image data URI", coin.Code)
+	}
+	if coin.ExplorerTxURL != "" {
+		if !strings.HasSuffix(coin.ExplorerTxURL, TxIDPlaceholder) {
+			return errp.Newf("%s: the transaction URL must end with %s", coin.Code, TxIDPlaceholder)
+		}
+		if err := validateExplorerURL(coin.ExplorerTxURL); err != nil {
+			return errp.WithMessage(err, coin.Code)
+		}
+	}
+	if coin.ExplorerAddressURL != "" {
+		if !strings.Contains(coin.ExplorerAddressURL, AddressPlaceholder) {
+			return errp.Newf("%s: the address URL must contain %s", coin.Code, AddressPlaceholder)
+		}
+		if err := validateExplorerURL(coin.ExplorerAddressURL); err != nil {
+			return errp.WithMessage(err, coin.Code)
+		}
+	}
+	return nil
+}
+
+func validateExplorerURL(template string) error {
+	parsed, err := url.Parse(template)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return errp.Newf("the explorer URL %s must be a web URL", template)
+	}
+	return nil
+}
+
+// Bundle is a versioned set of coin metadata.
+type Bundle struct {
+	// Version increases with every published bundle. Bundles with a lower or equal version than the
+	// current one are ignored.
+	Version uint32  `json:"version"`
+	Coins   []*Coin `json:"coins"`
+}
+
+func (bundle *Bundle) validate() error {
+	codes := map[string]bool{}
+	for _, coin := range bundle.Coins {
+		if err := coin.validate(); err != nil {
+			return err
+		}
+		if codes[coin.Code] {
+			return errp.Newf("duplicate coin %s", coin.Code)
+		}
+		codes[coin.Code] = true
+	}
+	return nil
+}
+
+// BundleVersion implements signeddata.Bundle.
+func (bundle *Bundle) BundleVersion() uint32 {
+	return bundle.Version
+}
+
+// SignedBundle is a bundle as it is published and cached.
+type SignedBundle = signeddata.SignedBundle
+
+// bundleKind describes the published coin metadata.
+var bundleKind = &signeddata.BundleKind{
+	Domain: signeddata.DomainCoinMetadata,
+	URL:    BundleURL,
+	Name:   "coin metadata",
+	Decode: func(bundleBytes []byte) (signeddata.Bundle, error) {
+		bundle := &Bundle{}
+		if err := json.Unmarshal(bundleBytes, bundle); err != nil {
+			return nil, errp.Wrap(err, "Failed to decode the coin metadata")
+		}
+		if err := bundle.validate(); err != nil {
+			return nil, errp.WithMessage(err, fmt.Sprintf("invalid coin metadata version %d", bundle.Version))
+		}
+		return bundle, nil
+	},
+}
+
+// Verify checks the signature of the signed bundle against the given public key and returns the
+// validated bundle.
+func Verify(signed *SignedBundle, publicKey *btcec.PublicKey) (*Bundle, error) {
+	bundle, err := bundleKind.Verify(signed, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.(*Bundle), nil
+}
+
+// Registry serves the metadata of the newest bundle known: the one bundled with the app, or a newer
+// verified one which was fetched and cached in a file.
+type Registry struct {
+	bundles *signeddata.BundleCache
+}
+
+// NewRegistry creates a registry which caches fetched bundles in the given file. The cached bundle
+// is used if it is valid and newer than the one bundled with the app. If publicKey is nil, only the
+// bundled metadata is used.
+func NewRegistry(cacheFilename string, publicKey *btcec.PublicKey, log *logrus.Entry) *Registry {
+	return &Registry{
+		bundles: signeddata.NewBundleCache(bundleKind, cacheFilename, publicKey, builtinBundle(), log),
+	}
+}
+
+func (registry *Registry) bundle() *Bundle {
+	return registry.bundles.Bundle().(*Bundle)
+}
+
+// Version returns the version of the bundle in use.
+func (registry *Registry) Version() uint32 {
+	return registry.bundle().Version
+}
+
+// Coin returns the metadata of the coin with the given code.
+func (registry *Registry) Coin(code string) (*Coin, bool) {
+	for _, coin := range registry.bundle().Coins {
+		if coin.Code == code {
+			return coin, true
+		}
+	}
+	return nil, false
+}
+
+// Coins returns the metadata of all coins, sorted by code.
+func (registry *Registry) Coins() []*Coin {
+	bundle := registry.bundle()
+	coins := make([]*Coin, len(bundle.Coins))
+	copy(coins, bundle.Coins)
+	sort.Slice(coins, func(i, j int) bool { return coins[i].Code < coins[j].Code })
+	return coins
+}
+
+// Update verifies the signed bundle and uses it if it is newer than the current one. The bundle is
+// then cached, so that it is used after a restart. Returns true if the bundle was applied.
+func (registry *Registry) Update(signed *SignedBundle) (bool, error) {
+	return registry.bundles.Update(signed)
+}
+
+// Refresh fetches the latest signed bundle from BundleURL and applies it if it is newer. Returns
+// true if the metadata changed.
+func (registry *Registry) Refresh() (bool, error) {
+	return registry.bundles.Refresh()
+}
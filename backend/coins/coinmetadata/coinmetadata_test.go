@@ -0,0 +1,128 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coinmetadata_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coinmetadata"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+func sign(t *testing.T, privateKey *btcec.PrivateKey, bundle *coinmetadata.Bundle) *coinmetadata.SignedBundle {
+	bundleBytes, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	signature, err := privateKey.Sign(signeddata.Hash(signeddata.DomainCoinMetadata, bundleBytes))
+	require.NoError(t, err)
+	return &coinmetadata.SignedBundle{
+		Bundle:    string(bundleBytes),
+		Signature: hex.EncodeToString(signature.Serialize()),
+	}
+}
+
+func testBundle(version uint32) *coinmetadata.Bundle {
+	return &coinmetadata.Bundle{
+		Version: version,
+		Coins: []*coinmetadata.Coin{
+			{
+				Code:          "btc",
+				Name:          "Bitcoin",
+				Unit:          "BTC",
+				Decimals:      8,
+				ExplorerTxURL: "https://explorer.example.com/btc/tx/{txid}",
+			},
+		},
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	registry := coinmetadata.NewRegistry("", nil, logging.Get().WithGroup("coinmetadata_test"))
+	coins := registry.Coins()
+	require.Len(t, coins, 5)
+	require.Equal(t, "btc", coins[0].Code)
+	btc, ok := registry.Coin("btc")
+	require.True(t, ok)
+	require.Equal(t, 8, btc.Decimals)
+	require.Contains(t, btc.Logo, "data:image/svg+xml;base64,")
+	require.Equal(t, "https://blockchain.info/tx/abcd", btc.TxURL("abcd"))
+	require.Equal(t, "https://blockchain.info/tx/", btc.TxURLPrefix())
+	rbtc, ok := registry.Coin("rbtc")
+	require.True(t, ok)
+	require.Equal(t, "", rbtc.TxURL("abcd"))
+	_, ok = registry.Coin("doge")
+	require.False(t, ok)
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coinmetadata_test")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	cacheFilename := path.Join(dir, "coin-metadata.json")
+	log := logging.Get().WithGroup("coinmetadata_test")
+
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+
+	registry := coinmetadata.NewRegistry(cacheFilename, privateKey.PubKey(), log)
+	initialVersion := registry.Version()
+
+	// Bundles signed by another key are rejected.
+	_, err = registry.Update(sign(t, otherKey, testBundle(initialVersion+1)))
+	require.Error(t, err)
+
+	// Invalid bundles are rejected.
+	invalid := testBundle(initialVersion + 1)
+	invalid.Coins[0].ExplorerTxURL = "https://explorer.example.com/tx/{txid}/details"
+	_, err = registry.Update(sign(t, privateKey, invalid))
+	require.Error(t, err)
+	invalid.Coins[0].ExplorerTxURL = "javascript:alert(1)//{txid}"
+	_, err = registry.Update(sign(t, privateKey, invalid))
+	require.Error(t, err)
+
+	// Older bundles are ignored.
+	updated, err := registry.Update(sign(t, privateKey, testBundle(initialVersion)))
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	updated, err = registry.Update(sign(t, privateKey, testBundle(initialVersion+1)))
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, initialVersion+1, registry.Version())
+	require.Len(t, registry.Coins(), 1)
+	btc, ok := registry.Coin("btc")
+	require.True(t, ok)
+	require.Equal(t, "https://explorer.example.com/btc/tx/abcd", btc.TxURL("abcd"))
+
+	// The updated bundle is cached, but only used with the right key.
+	registry = coinmetadata.NewRegistry(cacheFilename, privateKey.PubKey(), log)
+	require.Equal(t, initialVersion+1, registry.Version())
+	registry = coinmetadata.NewRegistry(cacheFilename, otherKey.PubKey(), log)
+	require.Equal(t, initialVersion, registry.Version())
+	registry = coinmetadata.NewRegistry(cacheFilename, nil, log)
+	require.Equal(t, initialVersion, registry.Version())
+	_, err = registry.Update(sign(t, privateKey, testBundle(initialVersion+2)))
+	require.Error(t, err)
+}
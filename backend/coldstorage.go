@@ -0,0 +1,203 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// eventColdStorageSweepSuggested is fired as an account event of the spending account when its
+// balance exceeds the threshold of a cold storage rule.
+const eventColdStorageSweepSuggested = "coldStorageSweepSuggested"
+
+// ColdStorageSuggestion suggests sweeping funds from a spending account to a savings account,
+// because the balance of the spending account exceeds the threshold of a cold storage rule.
+type ColdStorageSuggestion struct {
+	Rule            config.ColdStorageRule
+	SpendingAccount *btc.Account
+	// Balance is the available balance of the spending account.
+	Balance btcutil.Amount
+	// FiatBalance is the balance in the fiat currency of the rule.
+	FiatBalance float64
+	// SweepAmount is the part of the balance above the threshold.
+	SweepAmount btcutil.Amount
+}
+
+// ColdStorageSweep is a proposed tx sweeping funds from a spending account to a savings account.
+// It can be sent from the spending account with the regular send flow.
+type ColdStorageSweep struct {
+	SpendingAccount  *btc.Account
+	RecipientAddress string
+	Amount           btcutil.Amount
+	Fee              btcutil.Amount
+	Total            btcutil.Amount
+	FeeTargetCode    btc.FeeTargetCode
+}
+
+func (backend *Backend) account(code string) *btc.Account {
+	defer backend.accountsLock.RLock()()
+	for _, account := range backend.accounts {
+		if account.Code() == code {
+			return account
+		}
+	}
+	return nil
+}
+
+// coldStorageAccounts returns the accounts of the rule. An error is returned if one of them does
+// not exist or if they are not of the same coin.
+func (backend *Backend) coldStorageAccounts(rule config.ColdStorageRule) (
+	*btc.Account, *btc.Account, error) {
+	spending := backend.account(rule.SpendingAccount)
+	savings := backend.account(rule.SavingsAccount)
+	if spending == nil || savings == nil {
+		return nil, nil, errp.New("unknown account in cold storage rule")
+	}
+	if spending.Coin() != savings.Coin() {
+		return nil, nil, errp.New("the accounts of a cold storage rule must be of the same coin")
+	}
+	return spending, savings, nil
+}
+
+// coldStorageSuggestion returns a suggestion if the rule applies, nil otherwise.
+func (backend *Backend) coldStorageSuggestion(rule config.ColdStorageRule) (*ColdStorageSuggestion, error) {
+	spending, _, err := backend.coldStorageAccounts(rule)
+	if err != nil {
+		return nil, err
+	}
+	rate, ok := spending.Coin().FiatRate(rule.FiatUnit)
+	if !ok || rate == 0 {
+		return nil, nil
+	}
+	balance := spending.Balance().Available
+	fiatBalance := balance.ToBTC() * rate
+	if fiatBalance <= rule.Threshold {
+		return nil, nil
+	}
+	sweepAmount, err := btcutil.NewAmount((fiatBalance - rule.Threshold) / rate)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return &ColdStorageSuggestion{
+		Rule:            rule,
+		SpendingAccount: spending,
+		Balance:         balance,
+		FiatBalance:     fiatBalance,
+		SweepAmount:     sweepAmount,
+	}, nil
+}
+
+// ColdStorageSuggestions returns the sweeps suggested by the configured cold storage rules. Only
+// spending accounts which finished their initial sync are considered.
+func (backend *Backend) ColdStorageSuggestions() []*ColdStorageSuggestion {
+	suggestions := []*ColdStorageSuggestion{}
+	for _, rule := range backend.config.Config().Backend.ColdStorageRules {
+		if spending := backend.account(rule.SpendingAccount); spending == nil || !spending.InitialSyncDone() {
+			continue
+		}
+		suggestion, err := backend.coldStorageSuggestion(rule)
+		if err != nil {
+			backend.log.WithError(err).WithField("rule", rule).Error("Invalid cold storage rule")
+			continue
+		}
+		if suggestion != nil {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions
+}
+
+// checkColdStorage fires eventColdStorageSweepSuggested if a sweep from the given spending account
+// is suggested.
+func (backend *Backend) checkColdStorage(spendingAccountCode string) {
+	for _, suggestion := range backend.ColdStorageSuggestions() {
+		if suggestion.Rule.SpendingAccount != spendingAccountCode {
+			continue
+		}
+		backend.log.WithFields(logrus.Fields{
+			"spending-account": spendingAccountCode,
+			"savings-account":  suggestion.Rule.SavingsAccount,
+			"sweep-amount":     suggestion.SweepAmount,
+		}).Info("Suggesting cold storage sweep")
+		backend.events <- AccountEvent{
+			Type: "account", Code: spendingAccountCode, Data: eventColdStorageSweepSuggested}
+		return
+	}
+}
+
+// unusedReceiveAddresser is the part of btc.Interface needed by coldStorageRecipient().
+type unusedReceiveAddresser interface {
+	GetUnusedReceiveAddresses() []*addresses.AccountAddress
+}
+
+// coldStorageRecipient returns an unused receive address of the savings account. An error is
+// returned if there is none, e.g. because all of them are bound to invoices.
+func coldStorageRecipient(savings unusedReceiveAddresser) (string, error) {
+	unused := savings.GetUnusedReceiveAddresses()
+	if len(unused) == 0 {
+		return "", errp.New("the savings account has no unused receive address")
+	}
+	return unused[0].EncodeAddress(), nil
+}
+
+// ColdStorageSweepProposal creates a tx proposal sweeping the suggested amount from the given
+// spending account to an unused receive address of the savings account.
+func (backend *Backend) ColdStorageSweepProposal(spendingAccountCode string) (*ColdStorageSweep, error) {
+	var suggestion *ColdStorageSuggestion
+	for _, s := range backend.ColdStorageSuggestions() {
+		if s.Rule.SpendingAccount == spendingAccountCode {
+			suggestion = s
+			break
+		}
+	}
+	if suggestion == nil {
+		return nil, errp.New("no cold storage sweep suggested for this account")
+	}
+	spending, savings, err := backend.coldStorageAccounts(suggestion.Rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := savings.Init(); err != nil {
+		return nil, err
+	}
+	recipientAddress, err := coldStorageRecipient(savings)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := btc.NewSendAmount(suggestion.SweepAmount)
+	if err != nil {
+		return nil, err
+	}
+	_, feeTargetCode := spending.FeeTargets()
+	outputAmount, fee, total, _, err := spending.TxProposal(
+		recipientAddress, amount, feeTargetCode, nil, false, false, "")
+	if err != nil {
+		return nil, err
+	}
+	return &ColdStorageSweep{
+		SpendingAccount:  spending,
+		RecipientAddress: recipientAddress,
+		Amount:           outputAmount,
+		Fee:              fee.Amount,
+		Total:            total,
+		FeeTargetCode:    feeTargetCode,
+	}, nil
+}
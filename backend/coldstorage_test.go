@@ -0,0 +1,52 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	addressesTest "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses/test"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+)
+
+type unusedReceiveAddresserMock []*addresses.AccountAddress
+
+func (mock unusedReceiveAddresserMock) GetUnusedReceiveAddresses() []*addresses.AccountAddress {
+	return mock
+}
+
+func TestColdStorageRecipient(t *testing.T) {
+	_, err := coldStorageRecipient(unusedReceiveAddresserMock{})
+	require.Error(t, err)
+
+	address1 := addressesTest.GetAddress(signing.ScriptTypeP2WPKH)
+	address2 := addressesTest.GetMultisigAddress(1, 2)
+	recipient, err := coldStorageRecipient(unusedReceiveAddresserMock{address1, address2})
+	require.NoError(t, err)
+	require.Equal(t, address1.EncodeAddress(), recipient)
+}
+
+func TestColdStorageAccounts(t *testing.T) {
+	backend := &Backend{}
+	_, _, err := backend.coldStorageAccounts(config.ColdStorageRule{
+		SpendingAccount: "spending",
+		SavingsAccount:  "savings",
+	})
+	require.Error(t, err)
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
@@ -29,6 +30,153 @@ type CoinConfig struct {
 	ElectrumServers []*rpc.ServerInfo `json:"electrumServers"`
 }
 
+// FeeBumpPolicy configures when to suggest replacing an unconfirmed outgoing transaction with one
+// paying a higher fee (replace-by-fee).
+type FeeBumpPolicy struct {
+	// Enabled activates the policy. Only transactions signaling replaceability, which new
+	// transactions do unless disabled for a specific send, can be bumped.
+	Enabled bool `json:"enabled"`
+	// TargetBlocks is the number of blocks a transaction may stay unconfirmed before a fee bump is
+	// suggested.
+	TargetBlocks int `json:"targetBlocks"`
+	// ThresholdPercent is how much the required fee rate must have risen above the fee rate of
+	// the transaction before a fee bump is suggested.
+	ThresholdPercent int `json:"thresholdPercent"`
+}
+
+// FeeCap limits the fee of new transactions. A transaction paying more has to be confirmed
+// explicitly by the user.
+type FeeCap struct {
+	// MaxFee is the maximum absolute fee in the smallest unit of the coin (e.g. satoshi). 0 means no
+	// limit.
+	MaxFee int64 `json:"maxFee"`
+	// MaxFeePercent is the maximum fee in percent of the amount sent. 0 means no limit.
+	MaxFeePercent int `json:"maxFeePercent"`
+}
+
+// ColdStorageRule designates a spending account whose balance should be kept below a threshold by
+// sweeping the excess to a savings account of the same coin.
+type ColdStorageRule struct {
+	SpendingAccount string `json:"spendingAccount"`
+	SavingsAccount  string `json:"savingsAccount"`
+	// FiatUnit is the fiat currency of the threshold, e.g. "USD".
+	FiatUnit  string  `json:"fiatUnit"`
+	Threshold float64 `json:"threshold"`
+}
+
+// VaultAccount configures an account as a vault, see package coins/btc/vault.
+type VaultAccount struct {
+	// DelayBlocks is the minimum relative timelock of the unvaulting transactions.
+	DelayBlocks int `json:"delayBlocks"`
+}
+
+// MetadataBackup configures where the encrypted backup of the app metadata is stored, see package
+// metadatabackup.
+type MetadataBackup struct {
+	// Target is "webdav", "s3" or "http". Empty disables the backup.
+	Target string `json:"target"`
+	// URL is the URL of the backup file. For S3, it is the path-style URL of the object, e.g.
+	// https://s3.example.com/bucket/bitbox-metadata.
+	URL string `json:"url"`
+	// Username and Password are the WebDAV credentials, or the access key ID and the secret access
+	// key for S3. For "http", the password is sent as a bearer token.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Region is the S3 region, e.g. "us-east-1".
+	Region string `json:"region"`
+	// UseKeystoreKey encrypts new backups with a key derived from the connected wallet instead of a
+	// passphrase, so that restoring the seed is enough to decrypt them.
+	UseKeystoreKey bool `json:"useKeystoreKey"`
+}
+
+// Bridge configures the WebSocket bridge for web wallets, see package bridge.
+type Bridge struct {
+	Enabled bool `json:"enabled"`
+	// Port is the localhost port the bridge listens on. 0 uses the default port.
+	Port int `json:"port"`
+}
+
+// RemoteSigner configures the signer mode, in which the keystores are served to the apps paired
+// over the local network, see package remotesigner.
+type RemoteSigner struct {
+	Enabled bool `json:"enabled"`
+	// Port is the port the signer listens on. 0 uses the default port.
+	Port int `json:"port"`
+}
+
+// SignedCoinDefinition is the definition of an additional coin, see package coins/btc/customcoin.
+type SignedCoinDefinition struct {
+	// Definition is the JSON encoded definition. It is kept as a string so that the signed bytes
+	// are preserved exactly.
+	Definition string `json:"definition"`
+	// Signature is the hex encoded DER signature of Definition, see signeddata.Hash().
+	Signature string `json:"signature"`
+}
+
+// Egress configures which external hosts the backend may contact, see package util/egress.
+type Egress struct {
+	// Strict blocks connections to all hosts not expected by the backend (the Electrum servers, the
+	// rates, update and relay servers and the metadata backup target) or listed in AllowedHosts.
+	Strict bool `json:"strict"`
+	// AllowedHosts are additional host names which may be contacted in strict mode.
+	AllowedHosts []string `json:"allowedHosts"`
+}
+
+const (
+	// ResolverSystem resolves host names with the resolver of the operating system.
+	ResolverSystem = "system"
+	// ResolverDoH resolves host names with DNS queries over HTTPS.
+	ResolverDoH = "doh"
+	// ResolverTor resolves host names through the Tor network.
+	ResolverTor = "tor"
+)
+
+// DNS configures how the host names of outbound connections are resolved, see package
+// util/resolver.
+type DNS struct {
+	// Resolver is ResolverSystem, ResolverDoH or ResolverTor.
+	Resolver string `json:"resolver"`
+	// DoHURL is the URL of the DNS-over-HTTPS endpoint.
+	DoHURL string `json:"dohURL"`
+	// TorSOCKSAddress is the address of the SOCKS port of the Tor client.
+	TorSOCKSAddress string `json:"torSOCKSAddress"`
+}
+
+// Network configures the outbound connections, see egress.DialerConfig.
+type Network struct {
+	// SOCKSProxy is the address of a SOCKS5 proxy all connections go through, e.g. "127.0.0.1:9050"
+	// for Tor. The proxy also resolves the host names, so that the DNS config is not used. Empty
+	// connects directly.
+	SOCKSProxy string `json:"socksProxy"`
+	// IPPreference is "" (any), "ipv4", "ipv6", "preferIPv4" or "preferIPv6".
+	IPPreference string `json:"ipPreference"`
+	// TimeoutSeconds is the connect timeout. 0 means the default of 30 seconds.
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// HostTimeoutSeconds overrides the connect timeout for specific host names, e.g. slow onion
+	// services.
+	HostTimeoutSeconds map[string]int `json:"hostTimeoutSeconds"`
+}
+
+// AccountNickname is a name and an emoji the user gave an account, e.g. "Savings", shown instead
+// of the account name, also when confirming a transaction.
+type AccountNickname struct {
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+// PairedDevice is a device which was unlocked in the app before, see Backend.PairedDevices.
+type PairedDevice struct {
+	// Product is the product name of the device, e.g. "bitbox".
+	Product string `json:"product"`
+	// Nickname is the name the user gave the device in the app. It can be empty.
+	Nickname string `json:"nickname"`
+	// Trusted is true if the user trusts the device. The first device of a product is trusted
+	// automatically.
+	Trusted   bool      `json:"trusted"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
 // Backend holds the backend specific configuration.
 type Backend struct {
 	BitcoinP2PKHActive       bool `json:"bitcoinP2PKHActive"`
@@ -37,6 +185,65 @@ type Backend struct {
 	LitecoinP2WPKHP2SHActive bool `json:"litecoinP2WPKHP2SHActive"`
 	LitecoinP2WPKHActive     bool `json:"litecoinP2WPKHActive"`
 
+	FeeBumpPolicy FeeBumpPolicy `json:"feeBumpPolicy"`
+	FeeCap        FeeCap        `json:"feeCap"`
+
+	// ColdStorageRules are checked whenever a spending account has synced. See
+	// Backend.ColdStorageSuggestions().
+	ColdStorageRules []ColdStorageRule `json:"coldStorageRules"`
+
+	// VaultAccounts maps account codes to their vault configuration. Accounts not listed are
+	// regular accounts.
+	VaultAccounts map[string]VaultAccount `json:"vaultAccounts"`
+
+	// PaymentCodeAccounts maps coin codes to the account receiving to the BIP47 payment code of the
+	// coin. There is one payment code per coin, so at most one account can receive to it.
+	PaymentCodeAccounts map[string]string `json:"paymentCodeAccounts"`
+
+	// AccountNicknames maps account codes to the nicknames given by the user.
+	AccountNicknames map[string]AccountNickname `json:"accountNicknames"`
+
+	// PairedDevices maps the fingerprints of the devices unlocked in the app to what is known about
+	// them. The fingerprint is the device ID reported by the device.
+	PairedDevices map[string]PairedDevice `json:"pairedDevices"`
+
+	MetadataBackup MetadataBackup `json:"metadataBackup"`
+
+	// CustomCoinsEnabled is an advanced setting which adds accounts for the coins declared in
+	// CustomCoins.
+	CustomCoinsEnabled bool                   `json:"customCoinsEnabled"`
+	CustomCoins        []SignedCoinDefinition `json:"customCoins"`
+
+	// RatesStreamAPIKey is the API key of the exchange rates stream. If set, the rates are streamed
+	// in addition to being polled, so that fiat values update in near real time.
+	RatesStreamAPIKey string `json:"ratesStreamAPIKey"`
+
+	// RelayServer is the URL of the relay server used for new pairings with the mobile app, e.g. of
+	// a relayserver in the local network. If empty, the default relay server is used.
+	RelayServer string `json:"relayServer"`
+
+	// NoiseTransport makes the app encrypt the commands to the BitBox in a Noise channel if the
+	// firmware supports it, in addition to the encryption with the password.
+	NoiseTransport bool `json:"noiseTransport"`
+
+	// FeatureOverrides enable or disable features regardless of their rollout, see package
+	// config/features.
+	FeatureOverrides map[string]bool `json:"featureOverrides"`
+	// FeatureRolloutID is a random identifier of the installation, which determines whether it is
+	// in the percentage of the installations a feature is rolled out to. It is created on first use.
+	FeatureRolloutID string `json:"featureRolloutID"`
+
+	// AddressDenylist makes the app warn before creating a tx to an address on one of the bundled
+	// or imported denylists, see Backend.ImportDenylist().
+	AddressDenylist bool `json:"addressDenylist"`
+
+	Bridge       Bridge       `json:"bridge"`
+	RemoteSigner RemoteSigner `json:"remoteSigner"`
+
+	Egress  Egress  `json:"egress"`
+	DNS     DNS     `json:"dns"`
+	Network Network `json:"network"`
+
 	BTC  CoinConfig `json:"btc"`
 	TBTC CoinConfig `json:"tbtc"`
 	LTC  CoinConfig `json:"ltc"`
@@ -114,6 +321,34 @@ func NewDefaultConfig() AppConfig {
 			BitcoinP2WPKHActive:      false,
 			LitecoinP2WPKHP2SHActive: true,
 			LitecoinP2WPKHActive:     false,
+			FeeBumpPolicy: FeeBumpPolicy{
+				Enabled:          false,
+				TargetBlocks:     6,
+				ThresholdPercent: 20,
+			},
+			FeeCap: FeeCap{
+				MaxFee:        1000000,
+				MaxFeePercent: 25,
+			},
+			ColdStorageRules:    []ColdStorageRule{},
+			VaultAccounts:       map[string]VaultAccount{},
+			PaymentCodeAccounts: map[string]string{},
+			AccountNicknames:    map[string]AccountNickname{},
+			PairedDevices:       map[string]PairedDevice{},
+			FeatureOverrides:    map[string]bool{},
+			CustomCoins:         []SignedCoinDefinition{},
+			Egress: Egress{
+				Strict:       false,
+				AllowedHosts: []string{},
+			},
+			DNS: DNS{
+				Resolver:        ResolverSystem,
+				DoHURL:          "https://1.1.1.1/dns-query",
+				TorSOCKSAddress: "127.0.0.1:9050",
+			},
+			Network: Network{
+				HostTimeoutSeconds: map[string]int{},
+			},
 			BTC: CoinConfig{
 				ElectrumServers: []*rpc.ServerInfo{
 					{
@@ -215,6 +450,47 @@ func (config *Config) Set(appConfig AppConfig) error {
 	return config.save()
 }
 
+// Patch replaces the given keys of the backend and frontend sections of the config, keeping the
+// other keys, and persists the result. The patch is a JSON object like
+// {"backend": {"coinControl": true}}. Unlike reading the config and setting a modified copy, this is
+// atomic, so that concurrent changes by several frontends do not overwrite each other.
+func (config *Config) Patch(patchJSON []byte) (AppConfig, error) {
+	var patch map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(patchJSON, &patch); err != nil {
+		return AppConfig{}, errp.WithStack(err)
+	}
+	defer config.lock.Lock()()
+	currentJSON, err := json.Marshal(config.config)
+	if err != nil {
+		return AppConfig{}, errp.WithStack(err)
+	}
+	var current map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(currentJSON, &current); err != nil {
+		return AppConfig{}, errp.WithStack(err)
+	}
+	for section, values := range patch {
+		if section != "backend" && section != "frontend" {
+			return AppConfig{}, errp.Newf("unknown config section %s", section)
+		}
+		if current[section] == nil {
+			current[section] = map[string]json.RawMessage{}
+		}
+		for key, value := range values {
+			current[section][key] = value
+		}
+	}
+	patchedJSON, err := json.Marshal(current)
+	if err != nil {
+		return AppConfig{}, errp.WithStack(err)
+	}
+	patched := AppConfig{}
+	if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+		return AppConfig{}, errp.WithStack(err)
+	}
+	config.config = patched
+	return patched, config.save()
+}
+
 func (config *Config) save() error {
 	jsonBytes, err := json.Marshal(config.config)
 	if err != nil {
@@ -0,0 +1,50 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatch(t *testing.T) {
+	filename := path.Join(test.TstTempDir("config"), "config.json")
+	cfg := config.NewConfig(filename)
+	appConfig := cfg.Config()
+	appConfig.Frontend = map[string]interface{}{"coinControl": false, "language": "de"}
+	require.NoError(t, cfg.Set(appConfig))
+
+	// Two frontends changing different settings.
+	_, err := cfg.Patch([]byte(`{"backend": {"bitcoinP2PKHActive": true}}`))
+	require.NoError(t, err)
+	patched, err := cfg.Patch([]byte(`{"frontend": {"coinControl": true}}`))
+	require.NoError(t, err)
+	require.True(t, patched.Backend.BitcoinP2PKHActive)
+	require.True(t, patched.Backend.BitcoinP2WPKHP2SHActive)
+	require.Equal(t,
+		map[string]interface{}{"coinControl": true, "language": "de"}, patched.Frontend)
+	require.Equal(t, patched, cfg.Config())
+	// Persisted.
+	require.Equal(t, patched, config.NewConfig(filename).Config())
+
+	_, err = cfg.Patch([]byte(`{"other": {"key": 1}}`))
+	require.Error(t, err)
+	_, err = cfg.Patch([]byte(`invalid`))
+	require.Error(t, err)
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package features provides flags which enable subsystems of the app. New and risky subsystems can
+// ship disabled and be enabled later without a new build, per platform or for a percentage of the
+// installations, by rules signed by the app developers, see Flags.Refresh(). The rules can also
+// disable a subsystem which turns out to be broken. Users can override the flags in the config.
+package features
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
+)
+
+// BundleURL is where the latest signed rules are published.
+const BundleURL = "https://shiftcrypto.ch/updates/features.json"
+
+// Feature identifies a flag.
+type Feature string
+
+const (
+	// FeatureGraphQL enables the GraphQL endpoint of the API.
+	FeatureGraphQL Feature = "graphql"
+	// FeatureRemoteSigning enables signing with keystores attached to another app on the local
+	// network, see package remotesigner.
+	FeatureRemoteSigning Feature = "remoteSigning"
+)
+
+// defaults are the declared features, and whether they are enabled if no rule applies.
+var defaults = map[Feature]bool{
+	FeatureGraphQL:       true,
+	FeatureRemoteSigning: false,
+}
+
+const (
+	// SourceDefault means that the flag has its default value.
+	SourceDefault = "default"
+	// SourceRule means that the flag was set by a signed rule.
+	SourceRule = "rule"
+	// SourceOverride means that the flag was overridden in the config.
+	SourceOverride = "override"
+)
+
+// Rule enables or disables a feature on some installations.
+type Rule struct {
+	Enabled bool `json:"enabled"`
+	// Platforms are the platforms the rule applies to, as in runtime.GOOS, e.g. "android". If
+	// empty, it applies to all platforms.
+	Platforms []string `json:"platforms"`
+	// Percent is the percentage of the installations the rule applies to, from 0 to 100.
+	Percent int `json:"percent"`
+}
+
+// Bundle is a versioned set of rules.
+type Bundle struct {
+	// Version increases with every published bundle. Bundles with a lower or equal version than the
+	// current one are ignored.
+	Version uint32 `json:"version"`
+	// Rules are the rules by feature. The first rule which applies to the installation determines
+	// the flag. Rules of features unknown to this version of the app are ignored.
+	Rules map[Feature][]*Rule `json:"rules"`
+}
+
+func (bundle *Bundle) validate() error {
+	for feature, rules := range bundle.Rules {
+		for _, rule := range rules {
+			if rule.Percent < 0 || rule.Percent > 100 {
+				return errp.Newf("%s: the percentage must be between 0 and 100", feature)
+			}
+		}
+	}
+	return nil
+}
+
+// BundleVersion implements signeddata.Bundle.
+func (bundle *Bundle) BundleVersion() uint32 {
+	return bundle.Version
+}
+
+// SignedBundle is a bundle as it is published and cached.
+type SignedBundle = signeddata.SignedBundle
+
+// bundleKind describes the published feature rules.
+var bundleKind = &signeddata.BundleKind{
+	Domain: signeddata.DomainFeatureRules,
+	URL:    BundleURL,
+	Name:   "feature rules",
+	Decode: func(bundleBytes []byte) (signeddata.Bundle, error) {
+		bundle := &Bundle{}
+		if err := json.Unmarshal(bundleBytes, bundle); err != nil {
+			return nil, errp.Wrap(err, "Failed to decode the feature rules")
+		}
+		if err := bundle.validate(); err != nil {
+			return nil, errp.WithMessage(err, fmt.Sprintf("invalid feature rules version %d", bundle.Version))
+		}
+		return bundle, nil
+	},
+}
+
+// Verify checks the signature of the signed bundle against the given public key and returns the
+// validated bundle.
+func Verify(signed *SignedBundle, publicKey *btcec.PublicKey) (*Bundle, error) {
+	bundle, err := bundleKind.Verify(signed, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.(*Bundle), nil
+}
+
+// Status describes a flag, see Flags.Status().
+type Status struct {
+	Feature Feature `json:"feature"`
+	Enabled bool    `json:"enabled"`
+	// Source is SourceDefault, SourceRule or SourceOverride.
+	Source string `json:"source"`
+}
+
+// Flags evaluates the flags of the installation. The newest signed rules known are used, which are
+// fetched and cached in a file.
+type Flags struct {
+	config   *config.Config
+	platform string
+
+	// rules are the signed rules. If the public key is nil, only the defaults and the overrides
+	// are used.
+	rules *signeddata.BundleCache
+	lock  locker.Locker
+
+	log *logrus.Entry
+}
+
+// NewFlags creates the flags of the installation on the given platform, e.g. runtime.GOOS. Fetched
+// rules are cached in the given file, and the cached rules are used if they are valid.
+func NewFlags(
+	config *config.Config,
+	platform string,
+	cacheFilename string,
+	publicKey *btcec.PublicKey,
+	log *logrus.Entry) *Flags {
+	return &Flags{
+		config:   config,
+		platform: platform,
+		rules:    signeddata.NewBundleCache(bundleKind, cacheFilename, publicKey, nil, log),
+		log:      log,
+	}
+}
+
+// rolloutBucket returns the bucket from 0 to 99 of the installation for the feature. The buckets of
+// the features are independent, so that the same installations don't get all new features first.
+func (flags *Flags) rolloutBucket(feature Feature) int {
+	appConfig := flags.config.Config()
+	rolloutID := appConfig.Backend.FeatureRolloutID
+	if rolloutID == "" {
+		var err error
+		rolloutID, err = random.HexString(16)
+		if err != nil {
+			flags.log.WithError(err).Error("Failed to create the rollout ID")
+			return 99
+		}
+		appConfig.Backend.FeatureRolloutID = rolloutID
+		if err := flags.config.Set(appConfig); err != nil {
+			flags.log.WithError(err).Error("Failed to store the rollout ID")
+		}
+	}
+	hash := sha256.Sum256([]byte(rolloutID + "/" + string(feature)))
+	return int(binary.BigEndian.Uint32(hash[:4]) % 100)
+}
+
+// status evaluates the flag: the override in the config, or else the first rule which applies to
+// the installation, or else the default. It must be called with the lock held.
+func (flags *Flags) status(feature Feature) *Status {
+	if enabled, ok := flags.config.Config().Backend.FeatureOverrides[string(feature)]; ok {
+		return &Status{Feature: feature, Enabled: enabled, Source: SourceOverride}
+	}
+	if bundle, ok := flags.rules.Bundle().(*Bundle); ok {
+		for _, rule := range bundle.Rules[feature] {
+			if !rule.appliesTo(flags.platform) || flags.rolloutBucket(feature) >= rule.Percent {
+				continue
+			}
+			return &Status{Feature: feature, Enabled: rule.Enabled, Source: SourceRule}
+		}
+	}
+	return &Status{Feature: feature, Enabled: defaults[feature], Source: SourceDefault}
+}
+
+func (rule *Rule) appliesTo(platform string) bool {
+	if len(rule.Platforms) == 0 {
+		return true
+	}
+	for _, rulePlatform := range rule.Platforms {
+		if rulePlatform == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled returns whether the feature is enabled. Unknown features are disabled.
+func (flags *Flags) Enabled(feature Feature) bool {
+	if _, ok := defaults[feature]; !ok {
+		return false
+	}
+	defer flags.lock.Lock()()
+	return flags.status(feature).Enabled
+}
+
+// Status returns the flags of all features, sorted by feature.
+func (flags *Flags) Status() []*Status {
+	defer flags.lock.Lock()()
+	result := []*Status{}
+	for feature := range defaults {
+		result = append(result, flags.status(feature))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Feature < result[j].Feature })
+	return result
+}
+
+// SetOverride enables or disables the feature regardless of the rules. If enabled is nil, the
+// override is removed.
+func (flags *Flags) SetOverride(feature Feature, enabled *bool) error {
+	if _, ok := defaults[feature]; !ok {
+		return errp.Newf("unknown feature %s", feature)
+	}
+	defer flags.lock.Lock()()
+	appConfig := flags.config.Config()
+	overrides := map[string]bool{}
+	for name, overridden := range appConfig.Backend.FeatureOverrides {
+		overrides[name] = overridden
+	}
+	if enabled == nil {
+		delete(overrides, string(feature))
+	} else {
+		overrides[string(feature)] = *enabled
+	}
+	appConfig.Backend.FeatureOverrides = overrides
+	return flags.config.Set(appConfig)
+}
+
+// Update verifies the signed rules and uses them if they are newer than the current ones. They are
+// then cached, so that they are used after a restart. Returns true if the rules were applied.
+func (flags *Flags) Update(signed *SignedBundle) (bool, error) {
+	return flags.rules.Update(signed)
+}
+
+// Refresh fetches the latest signed rules from BundleURL and applies them if they are newer.
+// Returns true if the rules changed.
+func (flags *Flags) Refresh() (bool, error) {
+	return flags.rules.Refresh()
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package features_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+func sign(t *testing.T, privateKey *btcec.PrivateKey, bundle *features.Bundle) *features.SignedBundle {
+	bundleBytes, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	signature, err := privateKey.Sign(signeddata.Hash(signeddata.DomainFeatureRules, bundleBytes))
+	require.NoError(t, err)
+	return &features.SignedBundle{
+		Bundle:    string(bundleBytes),
+		Signature: hex.EncodeToString(signature.Serialize()),
+	}
+}
+
+func TestFlags(t *testing.T) {
+	dir := test.TstTempDir("features_test")
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	cfg := config.NewConfig(path.Join(dir, "config.json"))
+	newFlags := func(platform string) *features.Flags {
+		return features.NewFlags(cfg, platform, path.Join(dir, "features.json"),
+			privateKey.PubKey(), logging.Get().WithGroup("features_test"))
+	}
+	flags := newFlags("android")
+	require.True(t, flags.Enabled(features.FeatureGraphQL))
+	require.False(t, flags.Enabled("unknown"))
+	require.False(t, flags.Enabled(features.FeatureRemoteSigning))
+	require.Equal(t, []*features.Status{
+		{Feature: features.FeatureGraphQL, Enabled: true, Source: features.SourceDefault},
+		{Feature: features.FeatureRemoteSigning, Enabled: false, Source: features.SourceDefault},
+	}, flags.Status())
+
+	// The rule for no installations is skipped.
+	bundle := &features.Bundle{
+		Version: 1,
+		Rules: map[features.Feature][]*features.Rule{
+			features.FeatureGraphQL: {
+				{Enabled: true, Percent: 0},
+				{Enabled: false, Platforms: []string{"android", "ios"}, Percent: 100},
+			},
+		},
+	}
+	updated, err := flags.Update(sign(t, privateKey, bundle))
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.False(t, flags.Enabled(features.FeatureGraphQL))
+	require.Equal(t, features.SourceRule, flags.Status()[0].Source)
+	// The rollout ID is created on first use.
+	rolloutID := cfg.Config().Backend.FeatureRolloutID
+	require.Len(t, rolloutID, 32)
+
+	// Older rules are ignored.
+	bundle.Version = 0
+	updated, err = flags.Update(sign(t, privateKey, bundle))
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	// The cached rules are used after a restart. They don't apply to other platforms.
+	require.False(t, newFlags("android").Enabled(features.FeatureGraphQL))
+	require.True(t, newFlags("linux").Enabled(features.FeatureGraphQL))
+	require.Equal(t, rolloutID, cfg.Config().Backend.FeatureRolloutID)
+
+	// Overrides take precedence over the rules.
+	enabled := true
+	require.NoError(t, flags.SetOverride(features.FeatureGraphQL, &enabled))
+	require.Equal(t, &features.Status{
+		Feature: features.FeatureGraphQL, Enabled: true, Source: features.SourceOverride,
+	}, flags.Status()[0])
+	require.NoError(t, flags.SetOverride(features.FeatureGraphQL, nil))
+	require.False(t, flags.Enabled(features.FeatureGraphQL))
+	require.Error(t, flags.SetOverride("unknown", &enabled))
+}
+
+func TestVerify(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	bundle := &features.Bundle{
+		Version: 1,
+		Rules: map[features.Feature][]*features.Rule{
+			features.FeatureGraphQL: {{Enabled: false, Percent: 100}},
+		},
+	}
+	_, err = features.Verify(sign(t, privateKey, bundle), privateKey.PubKey())
+	require.NoError(t, err)
+	_, err = features.Verify(sign(t, otherKey, bundle), privateKey.PubKey())
+	require.Error(t, err)
+
+	bundle.Rules[features.FeatureGraphQL][0].Percent = 101
+	_, err = features.Verify(sign(t, privateKey, bundle), privateKey.PubKey())
+	require.Error(t, err)
+
+	flags := features.NewFlags(config.NewConfig(path.Join(test.TstTempDir("features_test"), "config.json")),
+		"linux", "", nil, logging.Get().WithGroup("features_test"))
+	_, err = flags.Update(sign(t, privateKey, bundle))
+	require.Error(t, err)
+}
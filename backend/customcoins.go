@@ -0,0 +1,96 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/customcoin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// loadCustomCoins verifies the coin definitions in the config if custom coins are enabled. Invalid
+// definitions and definitions of the other network (mainnet/testnet) are skipped.
+func (backend *Backend) loadCustomCoins() {
+	customCoins := map[string]*customcoin.Definition{}
+	defer func() {
+		defer backend.coinsLock.Lock()()
+		backend.customCoins = customCoins
+	}()
+	backendConfig := backend.config.Config().Backend
+	if !backendConfig.CustomCoinsEnabled || len(backendConfig.CustomCoins) == 0 ||
+		backend.arguments.Regtest() {
+		return
+	}
+	publicKey, err := signeddata.TrustedPublicKey()
+	if err != nil {
+		backend.log.WithError(err).Warning("Skipping custom coins")
+		return
+	}
+	for _, signed := range backendConfig.CustomCoins {
+		definition, err := customcoin.Verify(signed, publicKey)
+		if err != nil {
+			backend.log.WithError(err).Error("Skipping invalid custom coin")
+			continue
+		}
+		if definition.Testnet != backend.arguments.Testing() {
+			continue
+		}
+		if _, err := definition.Params(); err != nil {
+			backend.log.WithError(err).Error("Skipping custom coin")
+			continue
+		}
+		customCoins[definition.Code] = definition
+	}
+}
+
+// addCustomAccounts adds the accounts of the loaded custom coins. They are not subject to the
+// active account settings, as they are all disabled at once with the custom coins setting.
+func (backend *Backend) addCustomAccounts() {
+	definitions := []*customcoin.Definition{}
+	func() {
+		defer backend.coinsLock.RLock()()
+		for _, definition := range backend.customCoins {
+			definitions = append(definitions, definition)
+		}
+	}()
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].Code < definitions[j].Code })
+	for _, definition := range definitions {
+		customCoin := backend.Coin(definition.Code)
+		for _, scriptType := range definition.ScriptTypes {
+			backend.createAccount(customCoin, definition.AccountCode(scriptType),
+				definition.AccountName(scriptType), definition.AccountKeypath(scriptType), scriptType)
+		}
+	}
+}
+
+// newCustomCoin creates the coin of a loaded custom coin definition. It returns nil if there is
+// no loaded definition with the given code. Must be called with coinsLock held.
+func (backend *Backend) newCustomCoin(code string, dbFolder string, servers []*rpc.ServerInfo) coin.Coin {
+	definition, ok := backend.customCoins[code]
+	if !ok {
+		return nil
+	}
+	params, err := definition.Params()
+	if err != nil {
+		// The params were registered successfully when the definition was loaded.
+		panic(err)
+	}
+	return btc.NewCoin(code, definition.Unit, params, dbFolder, servers,
+		definition.BlockExplorerTxPrefix, nil)
+}
@@ -33,6 +33,9 @@ const (
 	bucketInputs                 = "inputs"
 	bucketOutputs                = "outputs"
 	bucketAddressHistories       = "addressHistories"
+	bucketTxNotes                = "txNotes"
+	bucketAddressNotes           = "addressNotes"
+	bucketBalanceSnapshots       = "balanceSnapshots"
 )
 
 // DB is a bbolt key/value database.
@@ -75,6 +78,18 @@ func (db *DB) Begin() (transactions.DBTxInterface, error) {
 	if err != nil {
 		return nil, err
 	}
+	bucketTxNotes, err := tx.CreateBucketIfNotExists([]byte(bucketTxNotes))
+	if err != nil {
+		return nil, err
+	}
+	bucketAddressNotes, err := tx.CreateBucketIfNotExists([]byte(bucketAddressNotes))
+	if err != nil {
+		return nil, err
+	}
+	bucketBalanceSnapshots, err := tx.CreateBucketIfNotExists([]byte(bucketBalanceSnapshots))
+	if err != nil {
+		return nil, err
+	}
 	return &Tx{
 		tx:                           tx,
 		bucketTransactions:           bucketTransactions,
@@ -82,6 +97,9 @@ func (db *DB) Begin() (transactions.DBTxInterface, error) {
 		bucketInputs:                 bucketInputs,
 		bucketOutputs:                bucketOutputs,
 		bucketAddressHistories:       bucketAddressHistories,
+		bucketTxNotes:                bucketTxNotes,
+		bucketAddressNotes:           bucketAddressNotes,
+		bucketBalanceSnapshots:       bucketBalanceSnapshots,
 	}, nil
 }
 
@@ -99,6 +117,9 @@ type Tx struct {
 	bucketInputs                 *bbolt.Bucket
 	bucketOutputs                *bbolt.Bucket
 	bucketAddressHistories       *bbolt.Bucket
+	bucketTxNotes                *bbolt.Bucket
+	bucketAddressNotes           *bbolt.Bucket
+	bucketBalanceSnapshots       *bbolt.Bucket
 }
 
 // Rollback implements transactions.DBTxInterface.
@@ -318,3 +339,73 @@ func (tx *Tx) AddressHistory(scriptHashHex blockchain.ScriptHashHex) (blockchain
 	_, err := readJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), &history)
 	return history, err
 }
+
+// PutTxNote implements transactions.DBTxInterface.
+func (tx *Tx) PutTxNote(txHash chainhash.Hash, note string) error {
+	if note == "" {
+		return errp.WithStack(tx.bucketTxNotes.Delete(txHash[:]))
+	}
+	return errp.WithStack(tx.bucketTxNotes.Put(txHash[:], []byte(note)))
+}
+
+// TxNote implements transactions.DBTxInterface.
+func (tx *Tx) TxNote(txHash chainhash.Hash) (string, error) {
+	return string(tx.bucketTxNotes.Get(txHash[:])), nil
+}
+
+// TxNotes implements transactions.DBTxInterface.
+func (tx *Tx) TxNotes() (map[chainhash.Hash]string, error) {
+	notes := map[chainhash.Hash]string{}
+	cursor := tx.bucketTxNotes.Cursor()
+	for txHashBytes, note := cursor.First(); txHashBytes != nil; txHashBytes, note = cursor.Next() {
+		var txHash chainhash.Hash
+		if err := txHash.SetBytes(txHashBytes); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		notes[txHash] = string(note)
+	}
+	return notes, nil
+}
+
+// PutAddressNote implements transactions.DBTxInterface.
+func (tx *Tx) PutAddressNote(address string, note string) error {
+	if note == "" {
+		return errp.WithStack(tx.bucketAddressNotes.Delete([]byte(address)))
+	}
+	return errp.WithStack(tx.bucketAddressNotes.Put([]byte(address), []byte(note)))
+}
+
+// AddressNote implements transactions.DBTxInterface.
+func (tx *Tx) AddressNote(address string) (string, error) {
+	return string(tx.bucketAddressNotes.Get([]byte(address))), nil
+}
+
+// AddressNotes implements transactions.DBTxInterface.
+func (tx *Tx) AddressNotes() (map[string]string, error) {
+	notes := map[string]string{}
+	cursor := tx.bucketAddressNotes.Cursor()
+	for address, note := cursor.First(); address != nil; address, note = cursor.Next() {
+		notes[string(address)] = string(note)
+	}
+	return notes, nil
+}
+
+// PutBalanceSnapshot implements transactions.DBTxInterface.
+func (tx *Tx) PutBalanceSnapshot(date string, snapshot *transactions.BalanceSnapshot) error {
+	return writeJSON(tx.bucketBalanceSnapshots, []byte(date), snapshot)
+}
+
+// BalanceSnapshots implements transactions.DBTxInterface. The dates are formatted so that the
+// byte order of the keys is chronological.
+func (tx *Tx) BalanceSnapshots() ([]*transactions.BalanceSnapshot, error) {
+	snapshots := []*transactions.BalanceSnapshot{}
+	cursor := tx.bucketBalanceSnapshots.Cursor()
+	for date, snapshotJSONBytes := cursor.First(); date != nil; date, snapshotJSONBytes = cursor.Next() {
+		snapshot := &transactions.BalanceSnapshot{}
+		if err := json.Unmarshal(snapshotJSONBytes, snapshot); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
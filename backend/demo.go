@@ -0,0 +1,50 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/demo"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+)
+
+// DemoMode returns whether the backend serves the fixture of the demo mode instead of real
+// accounts.
+func (backend *Backend) DemoMode() bool {
+	return backend.demoFixture != nil
+}
+
+// initDemoBlockchain makes the coin serve the transactions of the demo fixture instead of
+// connecting to servers.
+func (backend *Backend) initDemoBlockchain(coin *btc.Coin, code string) {
+	coinFixture, ok := backend.demoFixture.Coins[code]
+	if !ok {
+		coinFixture = &demo.CoinFixture{}
+	}
+	blockchain, err := demo.NewBlockchain(coin.Net(), coinFixture)
+	if err != nil {
+		backend.log.WithError(err).WithField("code", code).Panic("Invalid demo fixture")
+	}
+	coin.SetBlockchain(blockchain)
+}
+
+// startDemo registers a software keystore seeded by the PIN of the demo fixture, which stands in
+// for a device. Devices are not detected in demo mode, so that a plugged in device can not replace
+// the demo accounts, and the update check is skipped.
+func (backend *Backend) startDemo() {
+	backend.log.Info("Starting the demo mode")
+	backend.RegisterKeystore(software.NewKeystoreFromPIN(
+		backend.keystores.Count(), backend.demoFixture.PIN))
+}
@@ -0,0 +1,337 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// maxHeadersPerBatch is the maximum number of headers returned by Headers(), like Electrum servers.
+const maxHeadersPerBatch = 2016
+
+// blockInterval is the time between two made up blocks.
+const blockInterval = 10 * time.Minute
+
+type txEntry struct {
+	tx     *wire.MsgTx
+	height int
+}
+
+// Blockchain implements blockchain.Interface, serving the transactions of a fixture. The headers
+// are made up so that the transactions can be verified, which is why it is only supported on
+// networks without proof of work checks, i.e. testnet and regtest.
+type Blockchain struct {
+	txs []*txEntry
+	// txsByHash indexes txs by their hash.
+	txsByHash map[chainhash.Hash]*txEntry
+	// histories are the tx histories of all scripts the txs pay to.
+	histories map[blockchain.ScriptHashHex]blockchain.TxHistory
+	headers   []*wire.BlockHeader
+	// blocks are the hashes of the txs of each block, keyed by height.
+	blocks map[int][]chainhash.Hash
+
+	feeRatesPerKb map[int]btcutil.Amount
+	relayFeePerKb btcutil.Amount
+
+	log *logrus.Entry
+}
+
+// NewBlockchain creates a blockchain serving the transactions of the given coin fixture.
+func NewBlockchain(net *chaincfg.Params, fixture *CoinFixture) (*Blockchain, error) {
+	if len(net.Checkpoints) != 0 &&
+		fixture.TipHeight >= int(net.Checkpoints[len(net.Checkpoints)-1].Height) {
+		return nil, errp.Newf("the tip height must be below the last checkpoint of %s", net.Name)
+	}
+	chain := &Blockchain{
+		txsByHash:     map[chainhash.Hash]*txEntry{},
+		blocks:        map[int][]chainhash.Hash{},
+		feeRatesPerKb: fixture.FeeRatesPerKb,
+		relayFeePerKb: fixture.RelayFeePerKb,
+		log:           logging.Get().WithGroup("demo").WithField("net", net.Name),
+	}
+	for index, txFixture := range fixture.Transactions {
+		if txFixture.Height < 0 || txFixture.Height > fixture.TipHeight {
+			return nil, errp.Newf("tx %d: the height must be between 0 and the tip height", index)
+		}
+		tx, err := txFixture.tx(net, index)
+		if err != nil {
+			return nil, errp.WithMessage(err, fmt.Sprintf("tx %d", index))
+		}
+		entry := &txEntry{tx: tx, height: txFixture.Height}
+		chain.txs = append(chain.txs, entry)
+		chain.txsByHash[tx.TxHash()] = entry
+		if txFixture.Height > 0 {
+			chain.blocks[txFixture.Height] = append(chain.blocks[txFixture.Height], tx.TxHash())
+		}
+	}
+	chain.histories = chain.computeHistories()
+	chain.headers = chain.makeHeaders(net, fixture)
+	return chain, nil
+}
+
+// tx returns the fixture's transaction. The index makes the inputs of made up transactions unique.
+func (txFixture *TxFixture) tx(net *chaincfg.Params, index int) (*wire.MsgTx, error) {
+	if txFixture.RawTx != "" {
+		rawTx, err := hex.DecodeString(txFixture.RawTx)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		tx := &wire.MsgTx{}
+		if err := tx.BtcDecode(bytes.NewReader(rawTx), 0, wire.WitnessEncoding); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		return tx, nil
+	}
+	address, err := btcutil.DecodeAddress(txFixture.Address, net)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	// The made up input is not spendable, it only makes the tx hash unique.
+	tx.AddTxIn(wire.NewTxIn(
+		wire.NewOutPoint(&chainhash.Hash{}, uint32(index)), []byte{txscript.OP_TRUE}, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(txFixture.Amount), pkScript))
+	return tx, nil
+}
+
+func scriptHashHex(pkScript []byte) blockchain.ScriptHashHex {
+	return blockchain.ScriptHashHex(chainhash.HashH(pkScript).String())
+}
+
+// computeHistories finds the txs paying to and spending from each script. Like on Electrum
+// servers, confirmed txs are ordered by height and followed by the unconfirmed txs.
+func (chain *Blockchain) computeHistories() map[blockchain.ScriptHashHex]blockchain.TxHistory {
+	histories := map[blockchain.ScriptHashHex]blockchain.TxHistory{}
+	add := func(scriptHash blockchain.ScriptHashHex, entry *txEntry) {
+		history := histories[scriptHash]
+		txHash := entry.tx.TxHash()
+		if len(history) > 0 && history[len(history)-1].TXHash.Hash() == txHash {
+			return
+		}
+		histories[scriptHash] = append(history, &blockchain.TxInfo{
+			Height: entry.height,
+			TXHash: blockchain.TXHash(txHash),
+		})
+	}
+	for _, entry := range chain.txs {
+		for _, txIn := range entry.tx.TxIn {
+			if previousTx, ok := chain.txsByHash[txIn.PreviousOutPoint.Hash]; ok &&
+				int(txIn.PreviousOutPoint.Index) < len(previousTx.tx.TxOut) {
+				add(scriptHashHex(previousTx.tx.TxOut[txIn.PreviousOutPoint.Index].PkScript), entry)
+			}
+		}
+		for _, txOut := range entry.tx.TxOut {
+			add(scriptHashHex(txOut.PkScript), entry)
+		}
+	}
+	for _, history := range histories {
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Height != 0 && (history[j].Height == 0 || history[i].Height < history[j].Height)
+		})
+	}
+	return histories
+}
+
+// makeHeaders makes up a chain of headers from the genesis block up to the tip, with the merkle
+// roots of the txs in each block.
+func (chain *Blockchain) makeHeaders(net *chaincfg.Params, fixture *CoinFixture) []*wire.BlockHeader {
+	headers := []*wire.BlockHeader{&net.GenesisBlock.Header}
+	for height := 1; height <= fixture.TipHeight; height++ {
+		merkleRoot := chainhash.DoubleHashH([]byte(fmt.Sprintf("demo block %d", height)))
+		if txHashes, ok := chain.blocks[height]; ok {
+			merkleRoot, _ = merkleBranch(txHashes, 0)
+		}
+		headers = append(headers, &wire.BlockHeader{
+			Version:    1,
+			PrevBlock:  headers[height-1].BlockHash(),
+			MerkleRoot: merkleRoot,
+			Timestamp:  fixture.TipTime.Add(-time.Duration(fixture.TipHeight-height) * blockInterval),
+			Bits:       net.GenesisBlock.Header.Bits,
+		})
+	}
+	return headers
+}
+
+// merkleBranch returns the merkle root of the given tx hashes and the merkle branch of the tx at
+// the given position.
+func merkleBranch(txHashes []chainhash.Hash, pos int) (chainhash.Hash, []blockchain.TXHash) {
+	level := append([]chainhash.Hash{}, txHashes...)
+	branch := []blockchain.TXHash{}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, blockchain.TXHash(level[pos^1]))
+		nextLevel := make([]chainhash.Hash, len(level)/2)
+		for i := range nextLevel {
+			nextLevel[i] = chainhash.DoubleHashH(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = nextLevel
+		pos /= 2
+	}
+	return level[0], branch
+}
+
+// respond calls the success callback asynchronously, like the responses of a server, and cleans up
+// afterwards.
+func (chain *Blockchain) respond(success func() error, cleanup func()) {
+	go func() {
+		defer cleanup()
+		if err := success(); err != nil {
+			chain.log.WithError(err).Error("Failed to handle the demo response")
+		}
+	}()
+}
+
+// ScriptHashGetHistory implements blockchain.Interface.
+func (chain *Blockchain) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(),
+) {
+	history := chain.histories[scriptHashHex]
+	if history == nil {
+		history = blockchain.TxHistory{}
+	}
+	chain.respond(func() error { return success(history) }, cleanup)
+}
+
+// TransactionGet implements blockchain.Interface.
+func (chain *Blockchain) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(),
+) {
+	entry, ok := chain.txsByHash[txHash]
+	if !ok {
+		chain.log.WithField("tx", txHash).Error("Unknown transaction requested")
+		go cleanup()
+		return
+	}
+	chain.respond(func() error { return success(entry.tx) }, cleanup)
+}
+
+// ScriptHashSubscribe implements blockchain.Interface. The history never changes, so the callback
+// is called once with the current status.
+func (chain *Blockchain) ScriptHashSubscribe(
+	setupAndTeardown func() func(),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	status := chain.histories[scriptHashHex].Status()
+	chain.respond(func() error { return success(status) }, setupAndTeardown())
+}
+
+// HeadersSubscribe implements blockchain.Interface.
+func (chain *Blockchain) HeadersSubscribe(
+	setupAndTeardown func() func(),
+	success func(*blockchain.Header) error,
+) {
+	cleanup := func() {}
+	if setupAndTeardown != nil {
+		cleanup = setupAndTeardown()
+	}
+	tip := &blockchain.Header{BlockHeight: len(chain.headers) - 1}
+	chain.respond(func() error { return success(tip) }, cleanup)
+}
+
+// TransactionBroadcast implements blockchain.Interface.
+func (chain *Blockchain) TransactionBroadcast(*wire.MsgTx) error {
+	return errp.New("Transactions can not be broadcast in demo mode")
+}
+
+// RelayFee implements blockchain.Interface.
+func (chain *Blockchain) RelayFee(success func(btcutil.Amount) error, cleanup func()) {
+	chain.respond(func() error { return success(chain.relayFeePerKb) }, cleanup)
+}
+
+// EstimateFee implements blockchain.Interface.
+func (chain *Blockchain) EstimateFee(
+	number int,
+	success func(*btcutil.Amount) error,
+	cleanup func(),
+) {
+	var feeRatePerKb *btcutil.Amount
+	if fee, ok := chain.feeRatesPerKb[number]; ok {
+		feeRatePerKb = &fee
+	}
+	chain.respond(func() error { return success(feeRatePerKb) }, cleanup)
+}
+
+// Headers implements blockchain.Interface.
+func (chain *Blockchain) Headers(
+	startHeight int,
+	count int,
+	success func([]*wire.BlockHeader, int) error,
+	cleanup func(),
+) {
+	headers := []*wire.BlockHeader{}
+	for height := startHeight; height < len(chain.headers) && len(headers) < count &&
+		len(headers) < maxHeadersPerBatch; height++ {
+		headers = append(headers, chain.headers[height])
+	}
+	chain.respond(func() error { return success(headers, maxHeadersPerBatch) }, cleanup)
+}
+
+// GetMerkle implements blockchain.Interface.
+func (chain *Blockchain) GetMerkle(
+	txHash chainhash.Hash,
+	height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(),
+) {
+	for pos, blockTxHash := range chain.blocks[height] {
+		if blockTxHash == txHash {
+			_, branch := merkleBranch(chain.blocks[height], pos)
+			chain.respond(func() error { return success(branch, pos) }, cleanup)
+			return
+		}
+	}
+	chain.log.WithField("tx", txHash).Error("Merkle branch of unknown transaction requested")
+	go cleanup()
+}
+
+// Close implements blockchain.Interface.
+func (chain *Blockchain) Close() {
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (chain *Blockchain) ConnectionStatus() blockchain.Status {
+	return blockchain.CONNECTED
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface. The demo blockchain is
+// always connected.
+func (chain *Blockchain) RegisterOnConnectionStatusChangedEvent(func(blockchain.Status)) {
+}
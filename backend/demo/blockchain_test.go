@@ -0,0 +1,172 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"testing"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+)
+
+func newTestBlockchain(t *testing.T) *Blockchain {
+	fixture, err := LoadFixture("fixtures/demo.json")
+	require.NoError(t, err)
+	chain, err := NewBlockchain(&chaincfg.TestNet3Params, fixture.Coins["tbtc"])
+	require.NoError(t, err)
+	return chain
+}
+
+func TestMerkleBranch(t *testing.T) {
+	txs := []*btcutil.Tx{}
+	txHashes := []chainhash.Hash{}
+	for i := 0; i < 5; i++ {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.LockTime = uint32(i)
+		txs = append(txs, btcutil.NewTx(tx))
+		txHashes = append(txHashes, tx.TxHash())
+	}
+	merkles := btcdBlockchain.BuildMerkleTreeStore(txs, false)
+	expectedRoot := *merkles[len(merkles)-1]
+	for pos, txHash := range txHashes {
+		root, branch := merkleBranch(txHashes, pos)
+		require.Equal(t, expectedRoot, root)
+		// Walk up the branch like the tx verification does.
+		hash := txHash
+		for i, sibling := range branch {
+			if (pos>>uint(i))&1 == 0 {
+				hash = chainhash.DoubleHashH(append(hash[:], sibling[:]...))
+			} else {
+				hash = chainhash.DoubleHashH(append(sibling[:], hash[:]...))
+			}
+		}
+		require.Equal(t, expectedRoot, hash)
+	}
+}
+
+func TestNewBlockchain(t *testing.T) {
+	_, err := NewBlockchain(&chaincfg.TestNet3Params, &CoinFixture{TipHeight: 2000000})
+	require.Error(t, err)
+	_, err = NewBlockchain(&chaincfg.TestNet3Params, &CoinFixture{
+		TipHeight:    10,
+		Transactions: []*TxFixture{{Height: 11, Address: "2NBJ77tg9KT6sARs8CsuH1aJz8YPDkj4QXH"}},
+	})
+	require.Error(t, err)
+	_, err = NewBlockchain(&chaincfg.TestNet3Params, &CoinFixture{
+		TipHeight:    10,
+		Transactions: []*TxFixture{{Height: 1, Address: "invalid"}},
+	})
+	require.Error(t, err)
+}
+
+func TestScriptHashGetHistory(t *testing.T) {
+	chain := newTestBlockchain(t)
+	getHistory := func(scriptHashHex blockchain.ScriptHashHex) blockchain.TxHistory {
+		result := make(chan blockchain.TxHistory)
+		chain.ScriptHashGetHistory(scriptHashHex,
+			func(history blockchain.TxHistory) error {
+				result <- history
+				return nil
+			}, func() {})
+		return <-result
+	}
+	// The first receive address received a tx which was spent later.
+	history := getHistory("9c955eefa84cdd73a3b58c300364e4cffa76dd78012502f947fe8cb916f6b466")
+	require.Len(t, history, 2)
+	require.Equal(t, 1000, history[0].Height)
+	require.Equal(t, 1100, history[1].Height)
+	// The change of the spending tx.
+	history = getHistory("f4e5cb2afdeddb53c690635cfb3f26bae9bfc7d282f523dcbb0614a52cdd4d35")
+	require.Len(t, history, 1)
+	require.Equal(t, 1100, history[0].Height)
+	require.Equal(t, history.Status(), func() string {
+		result := make(chan string)
+		chain.ScriptHashSubscribe(func() func() { return func() {} },
+			"f4e5cb2afdeddb53c690635cfb3f26bae9bfc7d282f523dcbb0614a52cdd4d35",
+			func(status string) error {
+				result <- status
+				return nil
+			})
+		return <-result
+	}())
+	// An unconfirmed tx.
+	history = getHistory("5409dfdc94f95cfef220f1c4226d6487465eeebebb19da8c40ea2c2ad904869e")
+	require.Len(t, history, 1)
+	require.Equal(t, 0, history[0].Height)
+	require.Empty(t, getHistory("unused"))
+}
+
+func TestHeaders(t *testing.T) {
+	chain := newTestBlockchain(t)
+	type batch struct {
+		headers []*wire.BlockHeader
+		max     int
+	}
+	getHeaders := func(startHeight, count int) batch {
+		result := make(chan batch)
+		chain.Headers(startHeight, count, func(headers []*wire.BlockHeader, max int) error {
+			result <- batch{headers, max}
+			return nil
+		}, func() {})
+		return <-result
+	}
+	first := getHeaders(0, 10)
+	require.Len(t, first.headers, 10)
+	require.Equal(t, *chaincfg.TestNet3Params.GenesisHash, first.headers[0].BlockHash())
+	for i := 1; i < len(first.headers); i++ {
+		require.Equal(t, first.headers[i-1].BlockHash(), first.headers[i].PrevBlock)
+	}
+	last := getHeaders(1400, 100)
+	require.Len(t, last.headers, 41)
+	require.Equal(t, "2018-10-01T12:00:00Z", last.headers[40].Timestamp.UTC().Format("2006-01-02T15:04:05Z"))
+	require.Empty(t, getHeaders(1441, 10).headers)
+
+	// The txs can be verified against the headers.
+	for _, entry := range chain.txs {
+		if entry.height == 0 {
+			continue
+		}
+		txHash := entry.tx.TxHash()
+		result := make(chan []blockchain.TXHash)
+		chain.GetMerkle(txHash, entry.height, func(merkle []blockchain.TXHash, pos int) error {
+			require.Equal(t, 0, pos)
+			result <- merkle
+			return nil
+		}, func() {})
+		require.Empty(t, <-result)
+		require.Equal(t, txHash, chain.headers[entry.height].MerkleRoot)
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	chain := newTestBlockchain(t)
+	estimateFee := func(number int) *btcutil.Amount {
+		result := make(chan *btcutil.Amount)
+		chain.EstimateFee(number, func(feeRatePerKb *btcutil.Amount) error {
+			result <- feeRatePerKb
+			return nil
+		}, func() {})
+		return <-result
+	}
+	require.Equal(t, btcutil.Amount(20000), *estimateFee(6))
+	require.Nil(t, estimateFee(3))
+	require.Error(t, chain.TransactionBroadcast(wire.NewMsgTx(wire.TxVersion)))
+}
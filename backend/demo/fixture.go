@@ -0,0 +1,80 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package demo runs the backend without hardware and live servers, serving deterministic accounts,
+// transactions and exchange rates from a fixture file. It is used for QA, screenshots of the
+// documentation and frontend development.
+package demo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Fixture is the content of a demo fixture file.
+type Fixture struct {
+	// PIN seeds the software keystore standing in for the device. The same PIN always results in
+	// the same accounts and addresses.
+	PIN string `json:"pin"`
+	// Rates are the exchange rates, keyed by coin unit and fiat currency, e.g. "BTC" and "USD".
+	Rates map[string]map[string]float64 `json:"rates"`
+	// Coins are the blockchains, keyed by coin code, e.g. "tbtc". Coins without an entry have an
+	// empty blockchain.
+	Coins map[string]*CoinFixture `json:"coins"`
+}
+
+// CoinFixture describes the blockchain of a coin.
+type CoinFixture struct {
+	// TipHeight is the height of the last block.
+	TipHeight int `json:"tipHeight"`
+	// TipTime is the timestamp of the last block. Each block before it is ten minutes older.
+	TipTime time.Time `json:"tipTime"`
+	// FeeRatesPerKb are the fee estimates, keyed by the number of blocks to confirm within.
+	FeeRatesPerKb map[int]btcutil.Amount `json:"feeRatesPerKb"`
+	// RelayFeePerKb is the minimum relay fee, used if there is no fee estimate for a target.
+	RelayFeePerKb btcutil.Amount `json:"relayFeePerKb"`
+	// Transactions are the transactions of the blockchain, in order.
+	Transactions []*TxFixture `json:"transactions"`
+}
+
+// TxFixture describes a transaction. Either RawTx is set, or the transaction is made up to pay
+// Amount to Address.
+type TxFixture struct {
+	// Height is the height of the block containing the transaction, or 0 if it is unconfirmed.
+	Height int `json:"height"`
+	// RawTx is the serialized transaction in hex format.
+	RawTx string `json:"rawTx"`
+	// Address is the recipient of a made up transaction.
+	Address string `json:"address"`
+	// Amount is the value in satoshis paid to Address.
+	Amount btcutil.Amount `json:"amount"`
+}
+
+// LoadFixture reads the fixture file with the given name.
+func LoadFixture(filename string) (*Fixture, error) {
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	fixture := &Fixture{}
+	if err := json.Unmarshal(jsonBytes, fixture); err != nil {
+		return nil, errp.Wrap(err, "Failed to decode the demo fixture")
+	}
+	return fixture, nil
+}
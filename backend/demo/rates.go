@@ -0,0 +1,76 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+)
+
+// RatesUpdater implements coin.RatesUpdater with the constant exchange rates of a fixture.
+type RatesUpdater struct {
+	observable.Implementation
+	rates   map[string]map[string]float64
+	created time.Time
+}
+
+// NewRatesUpdater returns a rates updater which always returns the given rates.
+func NewRatesUpdater(rates map[string]map[string]float64) *RatesUpdater {
+	if rates == nil {
+		rates = map[string]map[string]float64{}
+	}
+	return &RatesUpdater{rates: rates, created: time.Now()}
+}
+
+// Last implements coin.RatesUpdater.
+func (updater *RatesUpdater) Last() map[string]map[string]float64 {
+	return updater.rates
+}
+
+// LastUpdate implements coin.RatesUpdater.
+func (updater *RatesUpdater) LastUpdate() time.Time {
+	return updater.created
+}
+
+// Source implements coin.RatesUpdater.
+func (updater *RatesUpdater) Source() string {
+	return "demo"
+}
+
+// DailyRates returns the current rate of the coin unit in the fiat currency as the closing rate of
+// each day since the first block of the fixture, keyed by the start of the day in UTC. It can be
+// used as the source of a btc.RateHistory.
+func (fixture *Fixture) DailyRates(unit string, fiat string) (map[time.Time]float64, error) {
+	rate, ok := fixture.Rates[unit][fiat]
+	if !ok {
+		return nil, errp.Newf("no demo rate for %s/%s", unit, fiat)
+	}
+	first := time.Now()
+	for _, coinFixture := range fixture.Coins {
+		genesis := coinFixture.TipTime.Add(-time.Duration(coinFixture.TipHeight) * blockInterval)
+		if genesis.Before(first) {
+			first = genesis
+		}
+	}
+	first = first.UTC()
+	rates := map[time.Time]float64{}
+	day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	for ; !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		rates[day] = rate
+	}
+	return rates, nil
+}
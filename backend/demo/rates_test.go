@@ -0,0 +1,40 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDailyRates(t *testing.T) {
+	fixture, err := LoadFixture("fixtures/demo.json")
+	require.NoError(t, err)
+	require.Equal(t, 6543.21, NewRatesUpdater(fixture.Rates).Last()["BTC"]["USD"])
+
+	rates, err := fixture.DailyRates("BTC", "USD")
+	require.NoError(t, err)
+	// The first tbtc block is 1440 blocks, i.e. ten days, before the tip.
+	require.Equal(t, 6543.21, rates[time.Date(2018, 9, 21, 0, 0, 0, 0, time.UTC)])
+	_, ok := rates[time.Date(2018, 9, 20, 0, 0, 0, 0, time.UTC)]
+	require.False(t, ok)
+	now := time.Now().UTC()
+	require.Equal(t, 6543.21, rates[time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)])
+
+	_, err = fixture.DailyRates("BTC", "XYZ")
+	require.Error(t, err)
+}
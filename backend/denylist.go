@@ -0,0 +1,47 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/denylist"
+)
+
+// Denylists returns the bundled and imported address denylists. The lists are only checked if
+// config.Backend.AddressDenylist is enabled.
+func (backend *Backend) Denylists() []*denylist.Summary {
+	return backend.denylist.Lists()
+}
+
+// ImportDenylist parses the list, one address per line, and stores it under the given source,
+// which is shown when a recipient matches. A previously imported list of the same source is
+// replaced. It returns the number of imported addresses.
+func (backend *Backend) ImportDenylist(source string, list string) (int, error) {
+	addresses, err := denylist.Parse(strings.NewReader(list))
+	if err != nil {
+		return 0, err
+	}
+	if err := backend.denylist.Import(source, addresses); err != nil {
+		return 0, err
+	}
+	backend.log.WithField("source", source).Infof("Imported %d denylisted addresses", len(addresses))
+	return len(addresses), nil
+}
+
+// RemoveDenylist deletes the imported denylist of the given source.
+func (backend *Backend) RemoveDenylist(source string) error {
+	return backend.denylist.Remove(source)
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package denylist stores lists of addresses the user should be warned about before sending to
+// them, e.g. sanctioned addresses or addresses of known scams. Lists are either imported by the
+// user or bundled, i.e. installed as text files next to the app data. Each match is attributed to
+// the lists containing the address.
+package denylist
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// bundledExtension is the extension of the bundled list files. The file name without the extension
+// is the source of the list.
+const bundledExtension = ".txt"
+
+// List is a named list of addresses.
+type List struct {
+	// Source names the list, e.g. "OFAC SDN", and is shown to the user when an address matches.
+	Source    string    `json:"source"`
+	Imported  time.Time `json:"imported"`
+	Addresses []string  `json:"addresses"`
+	// Bundled lists are loaded from the bundled directory and can't be removed.
+	Bundled bool `json:"-"`
+}
+
+// Summary describes a list without its addresses.
+type Summary struct {
+	Source   string
+	Imported time.Time
+	Count    int
+	Bundled  bool
+}
+
+// normalize returns the form in which addresses are compared. Bech32 addresses are case
+// insensitive, but must not mix cases, while base58 addresses practically always do.
+func normalize(address string) string {
+	address = strings.TrimSpace(address)
+	if upper := strings.ToUpper(address); upper == address {
+		return strings.ToLower(address)
+	}
+	return address
+}
+
+// Parse reads one address per line. Empty lines and lines starting with "#" are skipped, as is
+// everything after the first comma, so that the first column of a CSV file can be imported.
+func Parse(reader io.Reader) ([]string, error) {
+	addresses := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, ","); index >= 0 {
+			line = line[:index]
+		}
+		line = strings.Trim(strings.TrimSpace(line), `"`)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t") {
+			return nil, errp.Newf("invalid address %q", line)
+		}
+		addresses = append(addresses, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return addresses, nil
+}
+
+// Store persists the imported lists in a JSON file.
+type Store struct {
+	filename string
+	imported []*List
+	bundled  []*List
+	// index maps the normalized addresses to the sources of the lists containing them.
+	index map[string][]string
+	lock  locker.Locker
+}
+
+// NewStore creates a store persisted in the given file, loading the existing lists if the file
+// exists, and the bundled lists from the files in bundledDirectory.
+func NewStore(filename string, bundledDirectory string) *Store {
+	store := &Store{filename: filename, imported: []*List{}, bundled: loadBundled(bundledDirectory)}
+	store.load()
+	store.reindex()
+	return store
+}
+
+// loadBundled reads the list files in the directory. Files which can't be read are skipped.
+func loadBundled(directory string) []*List {
+	lists := []*List{}
+	filenames, err := filepath.Glob(filepath.Join(directory, "*"+bundledExtension))
+	if err != nil {
+		return lists
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			continue
+		}
+		addresses, err := Parse(file)
+		_ = file.Close()
+		if err != nil {
+			continue
+		}
+		var modified time.Time
+		if info, err := os.Stat(filename); err == nil {
+			modified = info.ModTime()
+		}
+		lists = append(lists, &List{
+			Source:    strings.TrimSuffix(filepath.Base(filename), bundledExtension),
+			Imported:  modified,
+			Addresses: addresses,
+			Bundled:   true,
+		})
+	}
+	return lists
+}
+
+func (store *Store) load() {
+	jsonBytes, err := ioutil.ReadFile(store.filename)
+	if err != nil {
+		return
+	}
+	lists := []*List{}
+	if err := json.Unmarshal(jsonBytes, &lists); err != nil {
+		return
+	}
+	store.imported = lists
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.Marshal(store.imported)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+func (store *Store) reindex() {
+	store.index = map[string][]string{}
+	for _, list := range append(append([]*List{}, store.bundled...), store.imported...) {
+		for _, address := range list.Addresses {
+			normalized := normalize(address)
+			sources := store.index[normalized]
+			if len(sources) == 0 || sources[len(sources)-1] != list.Source {
+				store.index[normalized] = append(sources, list.Source)
+			}
+		}
+	}
+}
+
+// Import stores the addresses as a list of the given source, replacing a previously imported list
+// of the same source. Bundled lists can't be replaced.
+func (store *Store) Import(source string, addresses []string) error {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return errp.New("the source of the list is required")
+	}
+	defer store.lock.Lock()()
+	for _, list := range store.bundled {
+		if list.Source == source {
+			return errp.Newf("the bundled list %s can't be replaced", source)
+		}
+	}
+	previous := store.imported
+	imported := []*List{}
+	for _, list := range store.imported {
+		if list.Source != source {
+			imported = append(imported, list)
+		}
+	}
+	store.imported = append(imported, &List{
+		Source:    source,
+		Imported:  time.Now(),
+		Addresses: addresses,
+	})
+	if err := store.save(); err != nil {
+		store.imported = previous
+		return err
+	}
+	store.reindex()
+	return nil
+}
+
+// Remove deletes the imported list of the given source.
+func (store *Store) Remove(source string) error {
+	defer store.lock.Lock()()
+	for index, list := range store.imported {
+		if list.Source == source {
+			store.imported = append(store.imported[:index:index], store.imported[index+1:]...)
+			if err := store.save(); err != nil {
+				return err
+			}
+			store.reindex()
+			return nil
+		}
+	}
+	return errp.Newf("unknown list %s", source)
+}
+
+// Lists returns the bundled and the imported lists.
+func (store *Store) Lists() []*Summary {
+	defer store.lock.RLock()()
+	result := []*Summary{}
+	for _, list := range append(append([]*List{}, store.bundled...), store.imported...) {
+		result = append(result, &Summary{
+			Source:   list.Source,
+			Imported: list.Imported,
+			Count:    len(list.Addresses),
+			Bundled:  list.Bundled,
+		})
+	}
+	return result
+}
+
+// Lookup returns the sources of the lists containing the address, or nil if it is not listed.
+func (store *Store) Lookup(address string) []string {
+	defer store.lock.RLock()()
+	sources := store.index[normalize(address)]
+	if len(sources) == 0 {
+		return nil
+	}
+	return append([]string{}, sources...)
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package denylist_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/denylist"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	legacyAddress = "mhQVSMAwKGLJgzZJGQp5xn7CfxX3G6yUmC"
+	bech32Address = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+)
+
+func TestParse(t *testing.T) {
+	addresses, err := denylist.Parse(strings.NewReader(
+		"# scam list\n\n" + legacyAddress + "\n\"" + bech32Address + "\",reported 2018-10-01\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{legacyAddress, bech32Address}, addresses)
+
+	_, err = denylist.Parse(strings.NewReader("not an address\n"))
+	require.Error(t, err)
+}
+
+func TestStore(t *testing.T) {
+	dir := test.TstTempDir("denylist-")
+	filename := path.Join(dir, "address-denylists.json")
+	store := denylist.NewStore(filename, path.Join(dir, "denylists"))
+	require.Empty(t, store.Lists())
+	require.Nil(t, store.Lookup(legacyAddress))
+
+	require.NoError(t, store.Import("scams", []string{legacyAddress, strings.ToUpper(bech32Address)}))
+	require.Equal(t, []string{"scams"}, store.Lookup(legacyAddress))
+	// Bech32 addresses match regardless of the case, base58 addresses don't.
+	require.Equal(t, []string{"scams"}, store.Lookup(bech32Address))
+	require.Nil(t, store.Lookup(strings.ToLower(legacyAddress)))
+	require.Error(t, store.Import(" ", []string{legacyAddress}))
+
+	// Importing the same source again replaces the list.
+	require.NoError(t, store.Import("scams", []string{bech32Address}))
+	require.Nil(t, store.Lookup(legacyAddress))
+	require.Len(t, store.Lists(), 1)
+
+	// Bundled lists are loaded from the directory, and can't be replaced or removed.
+	require.NoError(t, os.Mkdir(path.Join(dir, "denylists"), 0700))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "denylists", "OFAC SDN.txt"),
+		[]byte("# bundled\n"+bech32Address+"\n"), 0600))
+	store = denylist.NewStore(filename, path.Join(dir, "denylists"))
+	require.Equal(t, []string{"OFAC SDN", "scams"}, store.Lookup(bech32Address))
+	lists := store.Lists()
+	require.Len(t, lists, 2)
+	require.Equal(t, "OFAC SDN", lists[0].Source)
+	require.True(t, lists[0].Bundled)
+	require.Equal(t, 1, lists[0].Count)
+	require.Error(t, store.Import("OFAC SDN", []string{legacyAddress}))
+	require.Error(t, store.Remove("OFAC SDN"))
+
+	require.NoError(t, store.Remove("scams"))
+	require.Equal(t, []string{"OFAC SDN"}, store.Lookup(bech32Address))
+	require.Error(t, store.Remove("scams"))
+}
@@ -16,17 +16,26 @@ package bitbox
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"io"
 	"log"
 	"math"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
 )
 
 const (
+	// bootloaderCmdHash asks the bootloader for the double SHA256 hash of the firmware in flash,
+	// which is the hash the firmware signatures commit to.
+	bootloaderCmdHash = 'h'
+
 	bootloaderMaxChunkSize = 8 * 512
 	signaturesSize         = 64 * 7 // 7 signatures à 64 bytes
+	// bootloaderProgressStep is the minimum progress between two status change events while a
+	// chunk is uploaded.
+	bootloaderProgressStep = 0.01
 )
 
 // BootloaderStatus has all the info to handle the bootloader mode.
@@ -35,6 +44,14 @@ type BootloaderStatus struct {
 	Progress          float64 `json:"progress"`
 	UpgradeSuccessful bool    `json:"upgradeSuccessful"`
 	ErrMsg            string  `json:"errMsg"`
+
+	// Recovery is set if the device is in bootloader mode unexpectedly and the firmware needs to
+	// be re-flashed. See RecoveryReason.
+	Recovery       bool           `json:"recovery"`
+	RecoveryReason RecoveryReason `json:"recoveryReason,omitempty"`
+	// RecoveryVersion is the firmware version of the interrupted upgrade, if known.
+	RecoveryVersion  string `json:"recoveryVersion,omitempty"`
+	RecoveryAttempts int    `json:"recoveryAttempts"`
 }
 
 // BootloaderStatus returns the progress of a firmware upgrade. Returns an error if the device is
@@ -46,11 +63,14 @@ func (dbb *Device) BootloaderStatus() (*BootloaderStatus, error) {
 	return dbb.bootloaderStatus, nil
 }
 
-func (dbb *Device) bootloaderSendCmd(cmd rune, data []byte) error {
+// bootloaderSendCmd sends a command to the bootloader. onProgress is called while the command is
+// uploaded, see usb.Communication.SendBootloaderWithProgress(). It can be nil.
+func (dbb *Device) bootloaderSendCmd(cmd rune, data []byte, onProgress func(sent, total int)) error {
 	var buf bytes.Buffer
 	buf.WriteRune(cmd)
 	buf.Write(data)
-	reply, err := dbb.communication.SendBootloader(buf.Bytes())
+	reply, err := dbb.transport().SendBootloaderWithProgress(
+		dbb.requestContext(), buf.Bytes(), onProgress)
 	if err != nil {
 		return err
 	}
@@ -62,7 +82,45 @@ func (dbb *Device) bootloaderSendCmd(cmd rune, data []byte) error {
 	return nil
 }
 
-func (dbb *Device) bootloaderSendChunk(chunkNum byte, data []byte) error {
+// BootloaderVersion returns the version of the bootloader. Returns an error if the device is not in
+// bootloader mode.
+func (dbb *Device) BootloaderVersion() (*semver.SemVer, error) {
+	if dbb.bootloaderStatus == nil {
+		return nil, errp.New("device is not in bootloader mode")
+	}
+	return dbb.version, nil
+}
+
+// BootloaderFirmwareHash returns the hash of the installed firmware as computed by the bootloader.
+// The bootloader replies with the command byte, the status and the hex encoded hash. Returns an
+// error if the device is not in bootloader mode.
+func (dbb *Device) BootloaderFirmwareHash() ([]byte, error) {
+	if dbb.bootloaderStatus == nil {
+		return nil, errp.New("device is not in bootloader mode")
+	}
+	if dbb.bootloaderStatus.Upgrading {
+		return nil, errp.New("the firmware is being upgraded")
+	}
+	reply, err := dbb.transport().SendBootloader(
+		dbb.requestContext(), []byte(string(bootloaderCmdHash)))
+	if err != nil {
+		return nil, err
+	}
+	const hashHexSize = 2 * sha256.Size
+	if len(reply) < 2+hashHexSize || reply[0] != bootloaderCmdHash || reply[1] != '0' {
+		return nil, errp.WithContext(errp.New("Unexpected reply"), errp.Context{
+			"reply": reply,
+		})
+	}
+	hash, err := hex.DecodeString(string(reply[2 : 2+hashHexSize]))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return hash, nil
+}
+
+func (dbb *Device) bootloaderSendChunk(
+	chunkNum byte, data []byte, onProgress func(sent, total int)) error {
 	if len(data) > bootloaderMaxChunkSize {
 		dbb.log.Panic("Invalid length")
 		panic("invalid length")
@@ -71,7 +129,7 @@ func (dbb *Device) bootloaderSendChunk(chunkNum byte, data []byte) error {
 	buf.WriteByte(chunkNum)
 	buf.Write(data)
 	buf.Write(bytes.Repeat([]byte{0xFF}, bootloaderMaxChunkSize-len(data)))
-	err := dbb.bootloaderSendCmd('w', buf.Bytes())
+	err := dbb.bootloaderSendCmd('w', buf.Bytes(), onProgress)
 	return err
 }
 
@@ -83,7 +141,7 @@ func (dbb *Device) bootloaderSendSigs(sigs []byte) error {
 	var buf bytes.Buffer
 	buf.WriteRune('0')
 	buf.WriteString(hex.EncodeToString(sigs))
-	err := dbb.bootloaderSendCmd('s', buf.Bytes())
+	err := dbb.bootloaderSendCmd('s', buf.Bytes(), nil)
 	return err
 }
 
@@ -100,7 +158,17 @@ func (dbb *Device) bootloaderSendBin(bin []byte) error {
 		if err != nil {
 			return err
 		}
-		if err := dbb.bootloaderSendChunk(chunkNum, chunk[:readLen]); err != nil {
+		chunkIndex := chunkNum
+		// Report the progress within the chunk, as each chunk takes a moment to upload.
+		onProgress := func(sent, total int) {
+			progress := (float64(chunkIndex) + float64(sent)/float64(total)) / float64(totalChunks)
+			if progress-dbb.bootloaderStatus.Progress < bootloaderProgressStep {
+				return
+			}
+			dbb.bootloaderStatus.Progress = progress
+			dbb.fireEvent(EventBootloaderStatusChanged, nil)
+		}
+		if err := dbb.bootloaderSendChunk(chunkNum, chunk[:readLen], onProgress); err != nil {
 			return err
 		}
 		chunkNum++
@@ -115,23 +183,36 @@ func (dbb *Device) bootloaderSendBin(bin []byte) error {
 	return nil
 }
 
-// BootloaderUpgradeFirmware uploads a signed bitbox firmware release to the device. Returns an
-// error if the device is not in bootloader mode.
+// BootloaderUpgradeFirmware uploads a signed bitbox firmware release with the given version to the
+// device. Returns an error if the device is not in bootloader mode, and a *FirmwareDowngradeError
+// if the firmware is older than the one installed before entering bootloader mode.
 // See https://github.com/digitalbitbox/mcu/releases
-func (dbb *Device) BootloaderUpgradeFirmware(signedFirmware []byte) error {
+func (dbb *Device) BootloaderUpgradeFirmware(signedFirmware []byte, version *semver.SemVer) error {
 	if dbb.bootloaderStatus == nil {
 		return errp.New("device is not in bootloader mode")
 	}
 	if dbb.bootloaderStatus.Upgrading {
 		return errp.New("already in progress")
 	}
+	installedVersion := dbb.installedFirmwareVersion()
+	if err := checkFirmwareDowngrade(installedVersion, version); err != nil {
+		dbb.bootloaderStatus.ErrMsg = err.Error()
+		dbb.fireEvent(EventBootloaderStatusChanged, nil)
+		return err
+	}
 
 	dbb.bootloaderStatus.Progress = 0
 	dbb.bootloaderStatus.Upgrading = true
 	dbb.fireEvent(EventBootloaderStatusChanged, nil)
+	// Keep the journal until the upgrade completed, so an interrupted upgrade can be recovered.
+	journal := &upgradeJournal{Stage: upgradeStageFlashing, Version: version.String()}
+	if installedVersion != nil {
+		journal.FirmwareVersion = installedVersion.String()
+	}
+	dbb.writeUpgradeJournal(journal)
 	err := func() error {
 		// Erase the firmware (required).
-		if err := dbb.bootloaderSendCmd('e', nil); err != nil {
+		if err := dbb.bootloaderSendCmd('e', nil, nil); err != nil {
 			return err
 		}
 		sigs, firmware := signedFirmware[:signaturesSize], signedFirmware[signaturesSize:]
@@ -149,6 +230,7 @@ func (dbb *Device) BootloaderUpgradeFirmware(signedFirmware []byte) error {
 		dbb.fireEvent(EventBootloaderStatusChanged, nil)
 		return err
 	}
+	dbb.removeUpgradeJournal()
 	dbb.bootloaderStatus.Progress = 0
 	dbb.bootloaderStatus.UpgradeSuccessful = true
 	dbb.fireEvent(EventBootloaderStatusChanged, nil)
@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// deviceInfoMaxAge is how long the device info is cached. Unlike the xpubs, it can change without a
+// command of the app, e.g. when the SD card is inserted, so it is only cached briefly to answer
+// the queries of a single refresh.
+const deviceInfoMaxAge = 5 * time.Second
+
+// queryCache caches the replies of idempotent queries, so that e.g. refreshing the accounts does
+// not ask the device for the same xpubs again. The replies are only valid for the session they were
+// made in, identified by the PIN, as a different PIN (e.g. the hidden one) unlocks a different
+// wallet. The device calls invalidate() when a command changes the replies, e.g. a new seed.
+type queryCache struct {
+	mu sync.Mutex
+	// session is the PIN the cached replies were made with.
+	session        string
+	xpubs          map[string]*hdkeychain.ExtendedKey
+	deviceInfo     *DeviceInfo
+	deviceInfoTime time.Time
+}
+
+// forSession drops the cached replies if they were made in a different session. The lock must be
+// held.
+func (cache *queryCache) forSession(session string) {
+	if cache.session != session {
+		cache.session = session
+		cache.xpubs = nil
+		cache.deviceInfo = nil
+	}
+}
+
+// invalidate drops all cached replies.
+func (cache *queryCache) invalidate() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.xpubs = nil
+	cache.deviceInfo = nil
+}
+
+// invalidateDeviceInfo drops the cached device info, e.g. after the device name was changed.
+func (cache *queryCache) invalidateDeviceInfo() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.deviceInfo = nil
+}
+
+// xpub returns the cached xpub at the path, or nil.
+func (cache *queryCache) xpub(session, path string) *hdkeychain.ExtendedKey {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.forSession(session)
+	return cache.xpubs[path]
+}
+
+func (cache *queryCache) setXPub(session, path string, xpub *hdkeychain.ExtendedKey) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.forSession(session)
+	if cache.xpubs == nil {
+		cache.xpubs = map[string]*hdkeychain.ExtendedKey{}
+	}
+	cache.xpubs[path] = xpub
+}
+
+// getDeviceInfo returns a copy of the cached device info, or nil if it is not cached or older than
+// deviceInfoMaxAge.
+func (cache *queryCache) getDeviceInfo(session string) *DeviceInfo {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.forSession(session)
+	if cache.deviceInfo == nil || time.Since(cache.deviceInfoTime) > deviceInfoMaxAge {
+		return nil
+	}
+	deviceInfo := *cache.deviceInfo
+	return &deviceInfo
+}
+
+func (cache *queryCache) setDeviceInfo(session string, deviceInfo *DeviceInfo) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.forSession(session)
+	cached := *deviceInfo
+	cache.deviceInfo = &cached
+	cache.deviceInfoTime = time.Now()
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"encoding/json"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+)
+
+// This file contains the requests and replies of the device commands. Commands with a single string
+// parameter, like {"xpub": "m/0"}, are sent with sendKV() and have no request type. Replies are
+// decoded with decodeReply(), see there for the `schema` tags.
+
+type seedRequest struct {
+	Seed struct {
+		Source   string `json:"source"`
+		Key      string `json:"key"`
+		Filename string `json:"filename"`
+	} `json:"seed"`
+}
+
+type backupParams struct {
+	Key      string `json:"key,omitempty"`
+	Check    string `json:"check,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Erase    string `json:"erase,omitempty"`
+}
+
+type backupRequest struct {
+	Backup backupParams `json:"backup"`
+}
+
+type hiddenPasswordRequest struct {
+	HiddenPassword struct {
+		Key      string `json:"key"`
+		Password string `json:"password"`
+	} `json:"hidden_password"`
+}
+
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+type signData struct {
+	Hash    string `json:"hash"`
+	Keypath string `json:"keypath"`
+}
+
+type signCheckpub struct {
+	Pubkey  string `json:"pubkey"`
+	Keypath string `json:"keypath"`
+}
+
+// signRequest is the first of the two signing commands, which returns the echo for the mobile.
+type signRequest struct {
+	Sign struct {
+		Data []signData `json:"data"`
+		// Meta is the hash of the serialized transaction, shown on the mobile.
+		Meta     string         `json:"meta,omitempty"`
+		Checkpub []signCheckpub `json:"checkpub,omitempty"`
+	} `json:"sign"`
+}
+
+// signConfirmRequest is the second signing command, which returns the signatures after the user
+// confirmed. Sign is "" or {"pin": nonce} if the device is locked for 2FA.
+type signConfirmRequest struct {
+	Sign interface{} `json:"sign"`
+}
+
+type ecdhParams struct {
+	HashPubkey string `json:"hash_pubkey,omitempty"`
+	Pubkey     string `json:"pubkey,omitempty"`
+	Challenge  bool   `json:"challenge,omitempty"`
+}
+
+type ecdhRequest struct {
+	ECDH ecdhParams `json:"ecdh"`
+}
+
+type pingReply struct {
+	Ping string `json:"ping" schema:"optional"`
+}
+
+type passwordReply struct {
+	Password string `json:"password" schema:"const=success"`
+}
+
+// deviceInfoReply is the reply to {"device": "info"}.
+type deviceInfoReply struct {
+	Device struct {
+		DeviceInfo
+		// U2FHijack is only reported by firmware 2.2.0 and newer.
+		U2FHijack *bool `json:"U2F_hijack" schema:"optional"`
+	} `json:"device"`
+}
+
+type deviceLockReply struct {
+	Device struct {
+		Lock bool `json:"lock"`
+	} `json:"device"`
+}
+
+type seedReply struct {
+	Seed string `json:"seed" schema:"const=success"`
+}
+
+type backupReply struct {
+	Backup string `json:"backup" schema:"const=success"`
+}
+
+type backupListReply struct {
+	Backup []string `json:"backup"`
+}
+
+type nameReply struct {
+	Name string `json:"name"`
+}
+
+type hiddenPasswordReply struct {
+	HiddenPassword string `json:"hidden_password" schema:"const=success"`
+}
+
+type resetReply struct {
+	Reset string `json:"reset" schema:"const=success"`
+}
+
+type xpubReply struct {
+	XPub string `json:"xpub"`
+	// Echo is the encrypted xpub for the paired mobile.
+	Echo string `json:"echo" schema:"optional"`
+}
+
+type randomReply struct {
+	Random string `json:"random"`
+	// Echo is the encrypted random number for the paired mobile.
+	Echo string `json:"echo" schema:"optional"`
+}
+
+type bootloaderReply struct {
+	Bootloader string `json:"bootloader"`
+}
+
+type signEchoReply struct {
+	// Echo is the encrypted transaction for the paired mobile.
+	Echo string `json:"echo" schema:"optional"`
+}
+
+type signReply struct {
+	Sign []struct {
+		// Sig is the hex encoded 64 byte signature, R followed by S.
+		Sig string `json:"sig"`
+	} `json:"sign"`
+}
+
+// ecdhReply is the reply to the pairing commands, which is forwarded to the mobile as is.
+type ecdhReply struct {
+	ECDH interface{} `json:"ecdh"`
+}
+
+type ecdhChallengeReply struct {
+	ECDH string `json:"ecdh" schema:"const=success"`
+}
+
+// commandName returns the key of the command, which names it in errors.
+func commandName(jsonText []byte) string {
+	command := map[string]json.RawMessage{}
+	if err := json.Unmarshal(jsonText, &command); err != nil {
+		return ""
+	}
+	for name := range command {
+		return name
+	}
+	return ""
+}
+
+// sendPlain sends the unencrypted command {key: val} and decodes the reply into the response.
+func (dbb *Device) sendPlain(key, val string, response interface{}) error {
+	jsonText := jsonp.MustMarshal(map[string]string{key: val})
+	reply, err := dbb.transport().SendPlain(dbb.requestContext(), string(jsonText))
+	if err != nil {
+		return err
+	}
+	return decodeReply(key, reply, response)
+}
+
+// send sends the encrypted command and decodes the reply into the response. The response can be
+// nil if the reply is not needed.
+func (dbb *Device) send(request interface{}, pin string, response interface{}) error {
+	jsonText := jsonp.MustMarshal(request)
+	reply, err := dbb.transport().SendEncrypt(dbb.requestContext(), string(jsonText), pin)
+	if err != nil || response == nil {
+		return err
+	}
+	return decodeReply(commandName(jsonText), reply, response)
+}
+
+func (dbb *Device) sendKV(key, value, pin string, response interface{}) error {
+	return dbb.send(map[string]string{key: value}, pin, response)
+}
+
+// sendBatch sends the encrypted commands in one round trip and decodes the replies into the
+// responses, which must have the same length as the requests. See
+// CommunicationInterface.SendEncryptBatch() for the semantics if a command fails.
+func (dbb *Device) sendBatch(requests []interface{}, pin string, responses []interface{}) error {
+	msgs := make([]string, len(requests))
+	for i, request := range requests {
+		msgs[i] = string(jsonp.MustMarshal(request))
+	}
+	replies, err := dbb.transport().SendEncryptBatch(dbb.requestContext(), msgs, pin)
+	if err != nil {
+		return err
+	}
+	for i, reply := range replies {
+		if err := decodeReply(commandName([]byte(msgs[i])), reply, responses[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,14 +17,17 @@ package bitbox
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"path"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,10 +41,12 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/queue"
 	keystoreInterface "github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/journal"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
-	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
 )
@@ -70,6 +75,17 @@ const (
 	// (with one long-touch).
 	signatureBatchSize = 15
 
+	// xpubBatchSize is the amount of paths whose xpubs are queried in one batch of commands, see
+	// XPubs(). Each path is queried twice, and the replies of the batch have to fit into one reply
+	// of the device.
+	xpubBatchSize = 8
+
+	// journalFileName is the name of the file in the config dir holding the signing journal.
+	journalFileName = "keystore-journal.json"
+	// journalMaxAge is how long an interrupted signing operation can be resumed. Afterwards, the
+	// journaled signatures are discarded.
+	journalMaxAge = time.Hour
+
 	// ProductName is the name of the bitbox.
 	ProductName = "bitbox"
 
@@ -77,12 +93,18 @@ const (
 	backupDateFormat = "2006-01-02-15-04-05"
 )
 
-// CommunicationInterface contains functions needed to communicate with the device.
+// CommunicationInterface contains functions needed to communicate with the device. The replies
+// of SendPlain() and SendEncrypt() are the generic JSON values, as the transport has to inspect
+// them for errors and cipher text. The device decodes them into the typed replies in commands.go.
 //go:generate mockery -name CommunicationInterface
 type CommunicationInterface interface {
-	SendPlain(string) (map[string]interface{}, error)
-	SendEncrypt(string, string) (map[string]interface{}, error)
-	SendBootloader([]byte) ([]byte, error)
+	SendPlain(context.Context, string) (map[string]interface{}, error)
+	SendEncrypt(context.Context, string, string) (map[string]interface{}, error)
+	SendEncryptBatch(context.Context, []string, string) ([]map[string]interface{}, error)
+	SendBootloader(context.Context, []byte) ([]byte, error)
+	SendBootloaderWithProgress(context.Context, []byte, func(sent, total int)) ([]byte, error)
+	Health() *health.Stats
+	CommandQueue() *queue.Status
 	Close()
 }
 
@@ -119,6 +141,10 @@ type Device struct {
 	// If set, the user is "logged in".
 	pin string
 
+	// The device ID reported when the user logged in. Used to recognize the device when it is
+	// reconnected, see Resume().
+	sessionID string
+
 	// If set, the device contains a wallet.
 	seeded bool
 
@@ -136,10 +162,31 @@ type Device struct {
 	// If set, the channel can be used to communicate to the mobile.
 	// Channel readers should prefer accessing it using mobileChannel method.
 	channel *relay.Channel
+	// The relay server of new pairings, or empty for the default one. Set in SetRelayServer.
+	relayServer relay.Server
 	// Device state change callback. Set in SetOnEvent.
 	onEvent func(device.Event, interface{})
 	// Indicates whether Close was called.
 	closed bool
+	// Records the progress of signing operations, so they can be resumed after a disconnect.
+	journal *journal.Journal
+	// signMu serializes signing, so that the transactions of a batch are not interleaved with other
+	// signing requests. See SignBatch().
+	signMu sync.Mutex
+	// Set if the stored channel was paired with a different device. See verifyPairingIdentity.
+	pairingIdentityMismatch bool
+	// Set if the device presented a different Noise identity than before. See
+	// verifyNoiseIdentity.
+	noiseIdentityMismatch bool
+	// cache holds the replies of the xpub and device info queries of the session.
+	cache queryCache
+
+	// requestsContext is passed to all round trips to the device. It is canceled by
+	// CancelRequests() and Close(). requestsMu also guards communication, which is replaced when
+	// the device is reconnected.
+	requestsContext context.Context
+	cancelRequests  context.CancelFunc
+	requestsMu      sync.Mutex
 
 	log *logrus.Entry
 }
@@ -163,6 +210,7 @@ func NewDevice(
 	if bootloader {
 		bootloaderStatus = &BootloaderStatus{}
 	}
+	requestsContext, cancelRequests := context.WithCancel(context.Background())
 	device := &Device{
 		deviceID:         deviceID,
 		bootloaderStatus: bootloaderStatus,
@@ -171,7 +219,17 @@ func NewDevice(
 		closed:           false,
 		channel:          relay.NewChannelFromConfigFile(channelConfigDir),
 		channelConfigDir: channelConfigDir,
-		log:              log,
+		journal: journal.NewJournal(
+			path.Join(channelConfigDir, journalFileName), journalMaxAge, log),
+		requestsContext: requestsContext,
+		cancelRequests:  cancelRequests,
+		log:             log,
+	}
+
+	if bootloader {
+		device.detectRecovery()
+	} else {
+		device.listenForDeviceEvents(communication)
 	}
 
 	if device.channel != nil {
@@ -255,6 +313,9 @@ func (dbb *Device) onStatusChanged() {
 // Status returns the device state. See the Status* constants.
 func (dbb *Device) Status() Status {
 	if dbb.bootloaderStatus != nil {
+		if dbb.bootloaderStatus.Recovery {
+			return StatusBootloaderRecovery
+		}
 		return StatusBootloader
 	}
 	defer dbb.log.WithFields(logrus.Fields{"deviceID": dbb.deviceID, "seeded": dbb.seeded,
@@ -284,93 +345,86 @@ func (dbb *Device) Close() {
 	dbb.mu.Lock()
 	defer dbb.mu.Unlock()
 	dbb.log.WithFields(logrus.Fields{"deviceID": dbb.deviceID}).Debug("Close connection")
-	dbb.communication.Close()
+	dbb.CancelRequests()
+	dbb.transport().Close()
 	dbb.closed = true
 }
 
-func (dbb *Device) sendPlain(key, val string) (map[string]interface{}, error) {
-	jsonText, err := json.Marshal(map[string]string{key: val})
-	if err != nil {
-		return nil, err
-	}
-	return dbb.communication.SendPlain(string(jsonText))
+// CancelRequests cancels the round trips to the device in progress, e.g. when the user cancels a
+// confirmation in the UI or unplugs the device. Subsequent requests are sent as usual.
+func (dbb *Device) CancelRequests() {
+	dbb.requestsMu.Lock()
+	defer dbb.requestsMu.Unlock()
+	dbb.cancelRequests()
+	dbb.requestsContext, dbb.cancelRequests = context.WithCancel(context.Background())
 }
 
-func (dbb *Device) send(value interface{}, pin string) (map[string]interface{}, error) {
-	return dbb.communication.SendEncrypt(string(jsonp.MustMarshal(value)), pin)
+// requestContext returns the context of new round trips to the device, see CancelRequests().
+func (dbb *Device) requestContext() context.Context {
+	dbb.requestsMu.Lock()
+	defer dbb.requestsMu.Unlock()
+	return dbb.requestsContext
 }
 
-func (dbb *Device) sendKV(key, value, pin string) (map[string]interface{}, error) {
-	return dbb.send(map[string]string{key: value}, pin)
+// transport returns the communication to the device, see Resume().
+func (dbb *Device) transport() CommunicationInterface {
+	dbb.requestsMu.Lock()
+	defer dbb.requestsMu.Unlock()
+	return dbb.communication
 }
 
 func (dbb *Device) deviceInfo(pin string) (*DeviceInfo, error) {
 	if dbb.bootloaderStatus != nil {
 		return nil, errp.WithStack(errNoBootloader)
 	}
-	reply, err := dbb.sendKV("device", "info", pin)
-	if err != nil {
+	reply := &deviceInfoReply{}
+	if err := dbb.sendKV("device", "info", pin, reply); err != nil {
 		return nil, err
 	}
-	deviceInfo := &DeviceInfo{}
-
-	device, ok := reply["device"].(map[string]interface{})
-	if !ok {
-		return nil, errp.New("unexpected reply")
-	}
-	if deviceInfo.Serial, ok = device["serial"].(string); !ok {
-		dbb.log = dbb.log.WithField("serial", deviceInfo.Serial)
-		return nil, errp.New("no serial")
-	}
-	if deviceInfo.ID, ok = device["id"].(string); !ok {
-		dbb.log = dbb.log.WithField("id", deviceInfo.ID)
-		return nil, errp.New("no id")
-	}
-	if deviceInfo.TFA, ok = device["TFA"].(string); !ok {
-		dbb.log = dbb.log.WithField("TFA", deviceInfo.TFA)
-		return nil, errp.New("no TFA")
-	}
-	if deviceInfo.Bootlock, ok = device["bootlock"].(bool); !ok {
-		dbb.log = dbb.log.WithField("bootlock", deviceInfo.Bootlock)
-		return nil, errp.New("no bootlock")
-	}
-	if deviceInfo.Name, ok = device["name"].(string); !ok {
-		dbb.log = dbb.log.WithField("name", deviceInfo.Name)
-		return nil, errp.New("device name")
-	}
-	if deviceInfo.SDCard, ok = device["sdcard"].(bool); !ok {
-		dbb.log = dbb.log.WithField("sdcard", deviceInfo.SDCard)
-		return nil, errp.New("SD card")
-	}
-	if deviceInfo.Lock, ok = device["lock"].(bool); !ok {
-		dbb.log = dbb.log.WithField("lock", deviceInfo.Lock)
-		return nil, errp.New("lock")
-	}
-	if deviceInfo.U2F, ok = device["U2F"].(bool); !ok {
-		dbb.log = dbb.log.WithField("U2F", deviceInfo.U2F)
-		return nil, errp.New("U2F")
-	}
-	if dbb.version.AtLeast(semver.NewSemVer(2, 2, 0)) {
-		if deviceInfo.U2FHijack, ok = device["U2F_hijack"].(bool); !ok {
-			dbb.log = dbb.log.WithField("U2F_hijack", deviceInfo.U2FHijack)
-			return nil, errp.New("U2F_hijack")
-		}
-	}
-	if deviceInfo.Version, ok = device["version"].(string); !ok {
-		dbb.log = dbb.log.WithField("version", deviceInfo.Version)
-		return nil, errp.New("version")
-	}
-	if deviceInfo.Seeded, ok = device["seeded"].(bool); !ok {
-		dbb.log = dbb.log.WithField("seeded", deviceInfo.Seeded)
-		return nil, errp.New("version")
+	deviceInfo := &reply.Device.DeviceInfo
+	if reply.Device.U2FHijack != nil {
+		deviceInfo.U2FHijack = *reply.Device.U2FHijack
+	} else if dbb.version.AtLeast(semver.NewSemVer(2, 2, 0)) {
+		return nil, errp.WithStack(&ReplyError{Command: "device", Path: "device.U2F_hijack", Reason: "missing"})
 	}
 	dbb.log.Debug("Device info")
 	return deviceInfo, nil
 }
 
-// DeviceInfo gets device information.
+// DeviceInfo gets device information. The reply is cached for a few seconds, see deviceInfoMaxAge.
 func (dbb *Device) DeviceInfo() (*DeviceInfo, error) {
-	return dbb.deviceInfo(dbb.pin)
+	pin := dbb.pin
+	if deviceInfo := dbb.cache.getDeviceInfo(pin); deviceInfo != nil {
+		return deviceInfo, nil
+	}
+	deviceInfo, err := dbb.deviceInfo(pin)
+	if err != nil {
+		return nil, err
+	}
+	dbb.cache.setDeviceInfo(pin, deviceInfo)
+	return deviceInfo, nil
+}
+
+// Health returns the round trip latencies and error counts of the commands sent to the device.
+func (dbb *Device) Health() *health.Stats {
+	return dbb.transport().Health()
+}
+
+// checkConnection fires EventConnectionFlaky if the connection to the device looks unreliable. It
+// is called before signing, as signing takes many round trips and the user has to start over if
+// one of them fails.
+func (dbb *Device) checkConnection() {
+	stats := dbb.Health()
+	if stats == nil || stats.Assessment == nil || !stats.Assessment.Flaky {
+		return
+	}
+	dbb.log.WithField("reason", stats.Assessment.Reason).Warning("The connection to the device is flaky")
+	dbb.fireEvent(EventConnectionFlaky, stats.Assessment)
+}
+
+// CommandQueue returns the command in flight and the commands waiting for the device.
+func (dbb *Device) CommandQueue() *queue.Status {
+	return dbb.transport().CommandQueue()
 }
 
 // Ping returns true if the device is initialized, and false if it is not.
@@ -378,13 +432,12 @@ func (dbb *Device) Ping() (bool, error) {
 	if dbb.bootloaderStatus != nil {
 		return false, errp.WithStack(errNoBootloader)
 	}
-	reply, err := dbb.sendPlain("ping", "")
-	if err != nil {
+	reply := &pingReply{}
+	if err := dbb.sendPlain("ping", "", reply); err != nil {
 		return false, err
 	}
-	ping, ok := reply["ping"].(string)
-	initialized := ok && ping == "password"
-	dbb.log.WithField("ping", ping).Debug("Ping")
+	initialized := reply.Ping == "password"
+	dbb.log.WithField("ping", reply.Ping).Debug("Ping")
 	return initialized, nil
 }
 
@@ -401,13 +454,9 @@ func (dbb *Device) SetPassword(pin string) error {
 	if dbb.Status() != StatusUninitialized {
 		return errp.New("device has to be uninitialized")
 	}
-	reply, err := dbb.sendPlain("password", pin)
-	if err != nil {
+	if err := dbb.sendPlain("password", pin, &passwordReply{}); err != nil {
 		return errp.WithMessage(err, "Failed to set new pin")
 	}
-	if reply["password"] != "success" {
-		return errp.New("Unexpected reply")
-	}
 	dbb.log.Debug("Pin set")
 	dbb.pin = pin
 	dbb.onStatusChanged()
@@ -430,13 +479,9 @@ func (dbb *Device) ChangePassword(oldPIN string, newPIN string) error {
 	if dbb.pin != oldPIN {
 		return errp.New("Old PIN incorrect")
 	}
-	reply, err := dbb.sendKV("password", newPIN, oldPIN)
-	if err != nil {
+	if err := dbb.sendKV("password", newPIN, oldPIN, &passwordReply{}); err != nil {
 		return errp.WithMessage(err, "Failed to replace pin")
 	}
-	if reply["password"] != "success" {
-		return errp.New("Unexpected reply")
-	}
 	dbb.log.Debug("Pin replaced")
 	dbb.pin = newPIN
 	dbb.onStatusChanged()
@@ -472,6 +517,7 @@ func (dbb *Device) Login(pin string) (bool, string, error) {
 			// Check if the device was resetted after too many failed attempts.
 			initialized, pingErr := dbb.Ping()
 			if pingErr == nil && !initialized {
+				dbb.cache.invalidate()
 				dbb.initialized = false
 				dbb.seeded = false
 				dbb.pin = ""
@@ -482,8 +528,14 @@ func (dbb *Device) Login(pin string) (bool, string, error) {
 		return needsLongTouch, remainingAttempts, err
 	}
 	dbb.pin = pin
+	dbb.sessionID = deviceInfo.ID
 	dbb.seeded = deviceInfo.Seeded
+	// A new session starts, the wallet could have been changed by another app in the meantime.
+	dbb.cache.invalidate()
+	dbb.cache.setDeviceInfo(pin, deviceInfo)
 	dbb.onStatusChanged()
+	dbb.verifyNoiseIdentity(deviceInfo.ID)
+	dbb.verifyPairingIdentity(deviceInfo.ID)
 
 	dbb.log.Debug("Authentication successful")
 	if !deviceInfo.Bootlock {
@@ -527,38 +579,24 @@ func (dbb *Device) seed(pin, backupPassword, source, filename string) error {
 	}
 	dbb.log.WithFields(logrus.Fields{"source": source, "filename": filename}).Debug("Seed")
 	key := stretchKey(backupPassword)
-	reply, err := dbb.send(
-		map[string]interface{}{
-			"seed": map[string]string{
-				"source":   source,
-				"key":      key,
-				"filename": filename,
-			},
-		},
-		pin)
+	request := &seedRequest{}
+	request.Seed.Source = source
+	request.Seed.Key = key
+	request.Seed.Filename = filename
+	err := dbb.send(request, pin, &seedReply{})
+	// Even if it failed, the seed could have been replaced.
+	dbb.cache.invalidate()
 	if err != nil {
 		return errp.WithMessage(err, "Failed to create or backup wallet (seed)")
 	}
-	if reply["seed"] != "success" {
-		return errp.New("Unexpected result")
-	}
-	reply, err = dbb.send(
-		map[string]interface{}{
-			"backup": map[string]string{
-				"key":   key,
-				"check": filename,
-			},
-		},
-		dbb.pin)
-	if err != nil {
+	if err := dbb.send(
+		&backupRequest{Backup: backupParams{Key: key, Check: filename}},
+		dbb.pin,
+		&backupReply{},
+	); err != nil {
 		return errp.WithMessage(err, "There was an unexpected error during wallet creation or restoring. "+
 			"Please contact our support and do not use this wallet.")
 	}
-	backupCheck, ok := reply["backup"].(string)
-	if !ok || backupCheck != "success" {
-		return errp.New("There was an unexpected error during wallet creation or restoring." +
-			" Please contact our support and do not use this wallet.")
-	}
 	return nil
 }
 
@@ -570,24 +608,17 @@ func (dbb *Device) CheckBackup(backupPassword, filename string) (bool, error) {
 	}
 	dbb.log.WithFields(logrus.Fields{"filename": filename}).Debug("Check")
 	key := stretchKey(backupPassword)
-	reply, err := dbb.send(
-		map[string]interface{}{
-			"backup": map[string]string{
-				"key":   key,
-				"check": filename,
-			},
-		},
-		dbb.pin)
+	err := dbb.send(
+		&backupRequest{Backup: backupParams{Key: key, Check: filename}},
+		dbb.pin,
+		&backupReply{},
+	)
 	if dbbErr, ok := errp.Cause(err).(*Error); ok && dbbErr.Code == ErrSDNoMatch {
 		return false, nil
 	}
 	if err != nil {
 		return false, errp.WithMessage(err, "There was an unexpected error during the wallet check")
 	}
-	backupCheck, ok := reply["backup"].(string)
-	if !ok || backupCheck != "success" {
-		return false, errp.New("unexpected reply")
-	}
 	return true, nil
 }
 
@@ -604,17 +635,13 @@ func (dbb *Device) SetName(name string) error {
 		return errp.WithContext(errp.New("Invalid device name"),
 			errp.Context{"device-name": name})
 	}
-	reply, err := dbb.send(
-		map[string]interface{}{
-			"name": name,
-		},
-		dbb.pin)
-	if err != nil {
+	reply := &nameReply{}
+	defer dbb.cache.invalidateDeviceInfo()
+	if err := dbb.send(&nameRequest{Name: name}, dbb.pin, reply); err != nil {
 		return errp.WithMessage(err, "Failed to set name")
 	}
-	newName, ok := reply["name"].(string)
-	if !ok || len(newName) == 0 || newName != name {
-		return errp.New("unexpected result")
+	if reply.Name != name {
+		return errp.WithStack(&ReplyError{Command: "name", Path: "name", Reason: "the name was not set"})
 	}
 	return nil
 }
@@ -661,23 +688,17 @@ func (dbb *Device) SetHiddenPassword(hiddenPIN string, hiddenBackupPassword stri
 		return false, err
 	}
 	key := stretchKey(hiddenBackupPassword)
-	reply, err := dbb.send(
-		map[string]interface{}{
-			"hidden_password": map[string]string{
-				"key":      key,
-				"password": hiddenPIN,
-			},
-		},
-		dbb.pin)
+	request := &hiddenPasswordRequest{}
+	request.HiddenPassword.Key = key
+	request.HiddenPassword.Password = hiddenPIN
+	err := dbb.send(request, dbb.pin, &hiddenPasswordReply{})
+	dbb.cache.invalidate()
 	if IsErrorAbort(err) {
 		return false, nil
 	}
 	if err != nil {
 		return false, err
 	}
-	if reply["hidden_password"] != "success" {
-		return false, errp.New("Unexpected result")
-	}
 	return true, nil
 }
 
@@ -718,20 +739,16 @@ func (dbb *Device) CreateBackup(backupName string, recoveryPassword string) erro
 		return errp.WithStack(errNoBootloader)
 	}
 	dbb.log.WithField("backup-name", backupName).Info("Create backup")
-	reply, err := dbb.send(
-		map[string]interface{}{
-			"backup": map[string]string{
-				"key":      stretchKey(recoveryPassword),
-				"filename": backupFilename(backupName),
-			},
-		},
-		dbb.pin)
-	if err != nil {
+	if err := dbb.send(
+		&backupRequest{Backup: backupParams{
+			Key:      stretchKey(recoveryPassword),
+			Filename: backupFilename(backupName),
+		}},
+		dbb.pin,
+		&backupReply{},
+	); err != nil {
 		return errp.WithMessage(err, "Failed to create backup")
 	}
-	if reply["backup"] != "success" {
-		return errp.New("Unexpected result: backup != success")
-	}
 	return nil
 }
 
@@ -741,8 +758,7 @@ func (dbb *Device) Blink() error {
 		return errp.WithStack(errNoBootloader)
 	}
 	dbb.log.Info("Blink")
-	_, err := dbb.sendKV("led", "blink", dbb.pin)
-	return errp.WithMessage(err, "Failed to blink")
+	return errp.WithMessage(dbb.sendKV("led", "blink", dbb.pin, nil), "Failed to blink")
 }
 
 // Reset resets the device. Returns true if erased and false if aborted by the user. Only callable
@@ -758,16 +774,14 @@ func (dbb *Device) Reset(pin string) (bool, error) {
 	if dbb.pin != pin {
 		return false, errp.New("PIN incorrect")
 	}
-	reply, err := dbb.sendKV("reset", "__ERASE__", dbb.pin)
+	err := dbb.sendKV("reset", "__ERASE__", dbb.pin, &resetReply{})
+	dbb.cache.invalidate()
 	if IsErrorAbort(err) {
 		return false, nil
 	}
 	if err != nil {
 		return false, err
 	}
-	if reply["reset"] != "success" {
-		return false, errp.New("unexpected reply")
-	}
 	dbb.pin = ""
 	dbb.seeded = false
 	dbb.initialized = false
@@ -775,22 +789,84 @@ func (dbb *Device) Reset(pin string) (bool, error) {
 	return true, nil
 }
 
-// XPub returns the extended publickey at the path.
+// XPubs returns the extended public keys at the paths. Like XPub(), each xpub is queried twice, but
+// the queries are sent in batches of xpubBatchSize paths, so that e.g. the account discovery does
+// not need a round trip per query. The xpubs are cached for the session, only the missing ones are
+// queried.
+func (dbb *Device) XPubs(paths []string) ([]*hdkeychain.ExtendedKey, error) {
+	if dbb.bootloaderStatus != nil {
+		return nil, errp.WithStack(errNoBootloader)
+	}
+	pin := dbb.pin
+	xpubs := make([]*hdkeychain.ExtendedKey, len(paths))
+	missing := []string{}
+	for i, path := range paths {
+		xpubs[i] = dbb.cache.xpub(pin, path)
+		if xpubs[i] == nil {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) == 0 {
+		return xpubs, nil
+	}
+	dbb.log.WithField("paths", missing).Info("XPubs")
+	fetched := map[string]*hdkeychain.ExtendedKey{}
+	for start := 0; start < len(missing); start += xpubBatchSize {
+		end := start + xpubBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		requests := []interface{}{}
+		replies := []interface{}{}
+		for _, path := range missing[start:end] {
+			// Each path twice, to reduce the likelihood of a hardware error.
+			for i := 0; i < 2; i++ {
+				requests = append(requests, map[string]string{"xpub": path})
+				replies = append(replies, &xpubReply{})
+			}
+		}
+		if err := dbb.sendBatch(requests, pin, replies); err != nil {
+			return nil, err
+		}
+		for i, path := range missing[start:end] {
+			xpub1 := replies[2*i].(*xpubReply).XPub
+			xpub2 := replies[2*i+1].(*xpubReply).XPub
+			if xpub1 != xpub2 {
+				dbb.log.WithField("path", path).Error("The device returned inconsistent xpubs")
+				return nil, errp.WithStack(errp.New("Critical: the device returned inconsistent xpubs"))
+			}
+			xpub, err := hdkeychain.NewKeyFromString(xpub1)
+			if err != nil {
+				return nil, errp.WithStack(err)
+			}
+			dbb.cache.setXPub(pin, path, xpub)
+			fetched[path] = xpub
+		}
+	}
+	for i, path := range paths {
+		if xpubs[i] == nil {
+			xpubs[i] = fetched[path]
+		}
+	}
+	return xpubs, nil
+}
+
+// XPub returns the extended publickey at the path. The xpub is cached for the session.
 func (dbb *Device) XPub(path string) (*hdkeychain.ExtendedKey, error) {
 	if dbb.bootloaderStatus != nil {
 		return nil, errp.WithStack(errNoBootloader)
 	}
+	pin := dbb.pin
+	if xpub := dbb.cache.xpub(pin, path); xpub != nil {
+		return xpub, nil
+	}
 	dbb.log.WithField("path", path).Info("XPub")
 	getXPub := func() (*hdkeychain.ExtendedKey, error) {
-		reply, err := dbb.sendKV("xpub", path, dbb.pin)
-		if err != nil {
+		reply := &xpubReply{}
+		if err := dbb.sendKV("xpub", path, pin, reply); err != nil {
 			return nil, err
 		}
-		xpubStr, ok := reply["xpub"].(string)
-		if !ok {
-			return nil, errp.WithStack(errp.New("Unexpected reply"))
-		}
-		return hdkeychain.NewKeyFromString(xpubStr)
+		return hdkeychain.NewKeyFromString(reply.XPub)
 	}
 	// Call the device twice, to reduce the likelihood of a hardware error.
 	xpub1, err := getXPub()
@@ -805,6 +881,7 @@ func (dbb *Device) XPub(path string) (*hdkeychain.ExtendedKey, error) {
 		dbb.log.WithField("path", path).Error("The device returned inconsistent xpubs")
 		return nil, errp.WithStack(errp.New("Critical: the device returned inconsistent xpubs"))
 	}
+	dbb.cache.setXPub(pin, path, xpub1)
 	return xpub1, nil
 }
 
@@ -816,15 +893,11 @@ func (dbb *Device) Random(typ string) (string, error) {
 	if typ != "true" && typ != "pseudo" {
 		dbb.log.WithField("type", typ).Panic("Type must be 'true' or 'pseudo'")
 	}
-	reply, err := dbb.sendKV("random", typ, dbb.pin)
-	if err != nil {
+	reply := &randomReply{}
+	if err := dbb.sendKV("random", typ, dbb.pin, reply); err != nil {
 		return "", errp.WithMessage(err, "Failed to generate random")
 	}
-	rand, ok := reply["random"].(string)
-	if !ok {
-		dbb.log.Error("Unexpected reply: field 'random' is missing")
-		return "", errp.New("unexpected reply")
-	}
+	rand := reply.Random
 	dbb.log.WithField("random", rand).Debug("Generated random")
 	if len(rand) != 32 {
 		dbb.log.WithField("random-length", len(rand)).Error("Unexpected length: expected 32 bytes")
@@ -832,11 +905,10 @@ func (dbb *Device) Random(typ string) (string, error) {
 	}
 
 	if dbb.channel != nil {
-		echo, ok := reply["echo"].(string)
-		if !ok {
-			return "", errp.WithMessage(err, "The random number echo from the BitBox was invalid.")
+		if reply.Echo == "" {
+			return "", errp.New("The random number echo from the BitBox was invalid.")
 		}
-		if err = dbb.channel.SendRandomNumberEcho(echo); err != nil {
+		if err := dbb.channel.SendRandomNumberEcho(reply.Echo); err != nil {
 			return "", errp.WithMessage(err, "Could not send the random number echo to the mobile.")
 		}
 	}
@@ -849,26 +921,17 @@ func (dbb *Device) BackupList() ([]map[string]string, error) {
 	if dbb.bootloaderStatus != nil {
 		return nil, errp.WithStack(errNoBootloader)
 	}
-	reply, err := dbb.sendKV("backup", "list", dbb.pin)
+	reply := &backupListReply{}
+	err := dbb.sendKV("backup", "list", dbb.pin, reply)
 	if dbbErr, ok := errp.Cause(err).(*Error); ok && dbbErr.Code == errSDOpenDir {
 		return []map[string]string{}, nil
 	}
 	if err != nil {
 		return nil, errp.WithMessage(err, "Failed to retrieve list of backups")
 	}
-	filenames, ok := reply["backup"].([]interface{})
-	if !ok {
-		dbb.log.Error("Unexpected reply: field 'backup' is missing")
-		return nil, errp.New("unexpected reply")
-	}
 	filenamesAndDate := []map[string]string{}
-	for _, filename := range filenames {
+	for _, filenameString := range reply.Backup {
 		filenameAndDate := map[string]string{}
-		filenameString, ok := filename.(string)
-		if !ok {
-			dbb.log.Error("Unexpected reply: field 'backup' is not a string")
-			return nil, errp.New("unexpected reply")
-		}
 		filenameAndDate["id"] = filenameString
 		pattern := regexp.MustCompile("(.*)-(\\d{4}-\\d{2}-\\d{2}-\\d{2}-\\d{2}-\\d{2}).pdf")
 		if pattern.Match([]byte(filenameString)) {
@@ -910,37 +973,48 @@ func (dbb *Device) EraseBackup(filename string) error {
 		return errp.WithStack(errNoBootloader)
 	}
 	dbb.log.WithField("filename", filename).Info("Erase backup")
-	reply, err := dbb.send(
-		map[string]interface{}{
-			"backup": map[string]string{
-				"erase": filename,
-			},
-		},
-		dbb.pin)
-	if err != nil {
+	if err := dbb.send(
+		&backupRequest{Backup: backupParams{Erase: filename}}, dbb.pin, &backupReply{}); err != nil {
 		return errp.WithMessage(err, "Failed to erase backup")
 	}
-	if reply["backup"] != "success" {
-		return errp.New("Unexpected result: field 'backup' is missing")
-	}
 	return nil
 }
 
-// UnlockBootloader unlocks the bootloader. It returns true on success, and false on user abort.
+// UnlockBootloader unlocks the bootloader to install the bundled firmware. It returns true on
+// success, and false on user abort. A *FirmwareDowngradeError is returned if the bundled firmware
+// is older than the installed one.
 func (dbb *Device) UnlockBootloader() (bool, error) {
+	return dbb.UnlockBootloaderForFirmware(BundledFirmwareVersion())
+}
+
+// UnlockBootloaderForFirmware unlocks the bootloader to install the firmware with the given
+// version, which is recorded so that it is flashed once the device is in bootloader mode, see
+// PendingFirmwareVersion(). Like UnlockBootloader(), it refuses to downgrade the firmware.
+func (dbb *Device) UnlockBootloaderForFirmware(version *semver.SemVer) (bool, error) {
 	if dbb.bootloaderStatus != nil {
 		return false, errp.WithStack(errNoBootloader)
 	}
-	reply, err := dbb.sendKV("bootloader", "unlock", dbb.pin)
+	if err := checkFirmwareDowngrade(dbb.version, version); err != nil {
+		return false, err
+	}
+	reply := &bootloaderReply{}
+	err := dbb.sendKV("bootloader", "unlock", dbb.pin, reply)
+	dbb.cache.invalidateDeviceInfo()
 	if IsErrorAbort(err) {
 		return false, nil
 	}
 	if err != nil {
 		return false, errp.WithMessage(err, "Failed to unlock bootloader")
 	}
-	if val, ok := reply["bootloader"].(string); !ok || val != "unlock" {
-		return false, errp.New("unexpected reply")
+	if reply.Bootloader != "unlock" {
+		return false, errp.WithStack(&ReplyError{
+			Command: "bootloader", Path: "bootloader", Reason: "expected \"unlock\""})
 	}
+	dbb.writeUpgradeJournal(&upgradeJournal{
+		Stage:           upgradeStageUnlocked,
+		Version:         version.String(),
+		FirmwareVersion: dbb.version.String(),
+	})
 	return true, nil
 }
 
@@ -950,12 +1024,14 @@ func (dbb *Device) LockBootloader() error {
 		return errp.WithStack(errNoBootloader)
 	}
 	dbb.log.Info("Lock bootloader")
-	reply, err := dbb.sendKV("bootloader", "lock", dbb.pin)
-	if err != nil {
+	defer dbb.cache.invalidateDeviceInfo()
+	reply := &bootloaderReply{}
+	if err := dbb.sendKV("bootloader", "lock", dbb.pin, reply); err != nil {
 		return errp.WithMessage(err, "Failed to lock bootloader")
 	}
-	if val, ok := reply["bootloader"].(string); !ok || val != "lock" {
-		return errp.New("Unexpected reply: field 'bootloader' is missing")
+	if reply.Bootloader != "lock" {
+		return errp.WithStack(&ReplyError{
+			Command: "bootloader", Path: "bootloader", Reason: "expected \"lock\""})
 	}
 	return nil
 }
@@ -967,7 +1043,7 @@ func (dbb *Device) signBatch(
 	signatureHashes [][]byte,
 	keyPaths []string,
 	locked bool,
-) (map[string]interface{}, error) {
+) (*signReply, error) {
 	if len(signatureHashes) != len(keyPaths) {
 		dbb.log.WithFields(logrus.Fields{"signature-hashes-length": len(signatureHashes),
 			"keypath-lengths": len(keyPaths)}).Panic("Length of keyPaths must match length of signatureHashes")
@@ -980,20 +1056,15 @@ func (dbb *Device) signBatch(
 		panic(fmt.Sprintf("only up to %d signature hashes can be signed in one batch", signatureBatchSize))
 	}
 
-	data := []map[string]string{}
+	command := &signRequest{}
+	command.Sign.Data = []signData{}
 	for i, signatureHash := range signatureHashes {
-		data = append(data, map[string]string{
-			"hash":    hex.EncodeToString(signatureHash),
-			"keypath": keyPaths[i],
+		command.Sign.Data = append(command.Sign.Data, signData{
+			Hash:    hex.EncodeToString(signatureHash),
+			Keypath: keyPaths[i],
 		})
 	}
 
-	command := map[string]map[string]interface{}{
-		"sign": map[string]interface{}{
-			"data": data,
-		},
-	}
-
 	var transaction string
 	if txProposal != nil {
 		buffer := new(bytes.Buffer)
@@ -1001,40 +1072,48 @@ func (dbb *Device) signBatch(
 			return nil, errp.Wrap(err, "Could not serialize the transaction.")
 		}
 		transaction = hex.EncodeToString(buffer.Bytes())
-		command["sign"]["meta"] = hex.EncodeToString(chainhash.DoubleHashB([]byte(transaction)))
+		command.Sign.Meta = hex.EncodeToString(chainhash.DoubleHashB([]byte(transaction)))
 
 		if txProposal.ChangeAddress != nil {
 			configuration := txProposal.ChangeAddress.Configuration
 			if configuration.Singlesig() {
 				publicKey := configuration.PublicKeys()[0]
-				command["sign"]["checkpub"] = []map[string]interface{}{{
-					"pubkey":  hex.EncodeToString(publicKey.SerializeCompressed()),
-					"keypath": configuration.AbsoluteKeypath().Encode(),
+				command.Sign.Checkpub = []signCheckpub{{
+					Pubkey:  hex.EncodeToString(publicKey.SerializeCompressed()),
+					Keypath: configuration.AbsoluteKeypath().Encode(),
 				}}
 			}
 		}
 	}
 
 	// First call returns the echo.
-	echo, err := dbb.send(command, dbb.pin)
-	if err != nil {
+	echo := &signEchoReply{}
+	if err := dbb.send(command, dbb.pin, echo); err != nil {
 		return nil, errp.WithMessage(err, "Failed to sign batch (1)")
 	}
 
 	mobchan := dbb.mobileChannel()
 	if txProposal != nil && txProposal.AccountConfiguration.Singlesig() && mobchan != nil {
-		signingEcho, ok := echo["echo"].(string)
-		if !ok {
-			return nil, errp.WithMessage(err, "The signing echo from the BitBox was not a string.")
+		signingEcho := echo.Echo
+		if signingEcho == "" {
+			return nil, errp.New("The signing echo from the BitBox was not a string.")
 		}
 		typ := string(txProposal.AccountConfiguration.ScriptType())
-		if err := mobchan.SendSigningEcho(signingEcho, txProposal.Coin.Name(), typ, transaction); err != nil {
+		details := &relay.SigningDetails{Memo: txProposal.Memo, AccountName: txProposal.AccountName}
+		if fiat := txProposal.Fiat; fiat != nil {
+			details.FiatAmount = fiat.Amount
+			details.FiatUnit = fiat.Unit
+			details.FiatRateTimestamp = fiat.RateTimestamp
+		}
+		if err := mobchan.SendSigningEcho(
+			signingEcho, txProposal.Coin.Name(), typ, transaction, details); err != nil {
 			return nil, errp.WithMessage(err, "Could not send the signing echo to the mobile.")
 		}
 	}
 
 	// If the device is 2FA locked, wait for up to two minutes for the signing "PIN"/nonce.
 	var nonce string
+	var err error
 	if locked {
 		if txProposal == nil {
 			return nil, errp.New("No transaction was provided for the locked device to sign.")
@@ -1064,18 +1143,49 @@ func (dbb *Device) signBatch(
 	} else {
 		pin = ""
 	}
-	command2 := map[string]interface{}{
-		"sign": pin,
-	}
-	reply, err := dbb.send(command2, dbb.pin)
-	if err != nil {
+	reply := &signReply{}
+	if err := dbb.send(&signConfirmRequest{Sign: pin}, dbb.pin, reply); err != nil {
 		return nil, errp.WithMessage(err, "Failed to sign batch (2)")
 	}
 	return reply, nil
 }
 
+// signOperationID identifies the signing of the given hashes with the given device in the journal.
+func signOperationID(deviceID string, signatureHashes [][]byte, keyPaths []string) string {
+	hash := sha256.New()
+	hash.Write([]byte(deviceID))
+	for i, signatureHash := range signatureHashes {
+		hash.Write(signatureHash)
+		hash.Write([]byte(keyPaths[i]))
+	}
+	return "sign-" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func (dbb *Device) finishSignOperation(operationID string) {
+	if err := dbb.journal.Finish(operationID); err != nil {
+		dbb.log.WithError(err).Error("Failed to remove the signing operation from the journal")
+	}
+}
+
+// batchSignatures returns the hex encoded signatures in the reply to a signing batch.
+func batchSignatures(reply *signReply) ([]string, error) {
+	hexSigs := []string{}
+	for index, sig := range reply.Sign {
+		if len(sig.Sig) != 128 {
+			return nil, errp.WithStack(&ReplyError{
+				Command: "sign",
+				Path:    fmt.Sprintf("sign[%d].sig", index),
+				Reason:  "must be 128 characters long",
+			})
+		}
+		hexSigs = append(hexSigs, sig.Sig)
+	}
+	return hexSigs, nil
+}
+
 // Sign returns signatures for the provided hashes. The private keys used to sign them are derived
-// using the provided keyPaths.
+// using the provided keyPaths. If signing is interrupted, e.g. because the device was unplugged,
+// the batches signed so far are reused when the same hashes are signed again.
 func (dbb *Device) Sign(
 	txProposal *maketx.TxProposal,
 	signatureHashes [][]byte,
@@ -1095,12 +1205,27 @@ func (dbb *Device) Sign(
 		panic("non-empty list of signature hashes and keypaths expected")
 	}
 
+	dbb.signMu.Lock()
+	defer dbb.signMu.Unlock()
+	dbb.checkConnection()
 	deviceInfo, err := dbb.DeviceInfo()
 	if err != nil {
 		dbb.log.WithError(err).Error("Failed to load the device info for signing.")
 		return nil, errp.WithMessage(err, "Failed to load the device info for signing.")
 	}
+	return dbb.sign(deviceInfo, txProposal, signatureHashes, keyPaths)
+}
 
+// sign signs the hashes of one transaction, see Sign().
+func (dbb *Device) sign(
+	deviceInfo *DeviceInfo,
+	txProposal *maketx.TxProposal,
+	signatureHashes [][]byte,
+	keyPaths []string,
+) ([]btcec.Signature, error) {
+	// Completed batches are journaled, so that after a disconnect only the remaining batches need
+	// to be signed again.
+	operationID := signOperationID(deviceInfo.ID, signatureHashes, keyPaths)
 	signatures := []btcec.Signature{}
 	steps := len(signatureHashes) / signatureBatchSize
 	if len(signatureHashes)%signatureBatchSize != 0 {
@@ -1111,38 +1236,45 @@ func (dbb *Device) Sign(
 		if upper > len(signatureHashes) {
 			upper = len(signatureHashes)
 		}
-		dbb.fireEvent(EventSignProgress, struct {
-			Step  int `json:"step"`
-			Steps int `json:"steps"`
-		}{
-			Step:  i / signatureBatchSize,
-			Steps: steps,
-		})
-		reply, err := dbb.signBatch(
-			txProposal,
-			signatureHashes[i:upper],
-			keyPaths[i:upper],
-			deviceInfo.Lock,
-		)
+		step := strconv.Itoa(i / signatureBatchSize)
+		hexSigs := []string{}
+		resumed, err := dbb.journal.Step(operationID, step, &hexSigs)
 		if err != nil {
-			return nil, err
-		}
-		sigs, ok := reply["sign"].([]interface{})
-		if !ok {
-			return nil, errp.New("Unexpected reply: field 'sign' is missing")
+			dbb.log.WithError(err).Error("Failed to read the signing journal")
+			resumed = false
 		}
-		for _, sig := range sigs {
-			sigMap, ok := sig.(map[string]interface{})
-			if !ok {
-				return nil, errp.New("Unexpected reply: 'sign' must be a map")
+		if resumed && len(hexSigs) == upper-i {
+			dbb.log.WithField("step", step).Info("Resuming signing with journaled signatures")
+		} else {
+			dbb.fireEvent(EventSignProgress, struct {
+				Step  int `json:"step"`
+				Steps int `json:"steps"`
+			}{
+				Step:  i / signatureBatchSize,
+				Steps: steps,
+			})
+			reply, err := dbb.signBatch(
+				txProposal,
+				signatureHashes[i:upper],
+				keyPaths[i:upper],
+				deviceInfo.Lock,
+			)
+			if err != nil {
+				if IsErrorAbort(err) {
+					// The user aborted, so the operation is not resumed later.
+					dbb.finishSignOperation(operationID)
+				}
+				return nil, err
 			}
-			hexSig, ok := sigMap["sig"].(string)
-			if !ok {
-				return nil, errp.New("Unexpected reply: field 'sig' is missing in 'sign' map")
+			hexSigs, err = batchSignatures(reply)
+			if err != nil {
+				return nil, err
 			}
-			if len(hexSig) != 128 {
-				return nil, errp.New("Unexpected reply: field 'sig' must be 128 byte long")
+			if err := dbb.journal.Record(operationID, step, hexSigs); err != nil {
+				dbb.log.WithError(err).Error("Failed to journal the signatures")
 			}
+		}
+		for _, hexSig := range hexSigs {
 			sigR, ok := big.NewInt(0).SetString(hexSig[:64], 16)
 			if !ok {
 				return nil, errp.New("Unexpected reply: R in 'sig' must be a hex value")
@@ -1154,6 +1286,7 @@ func (dbb *Device) Sign(
 			signatures = append(signatures, btcec.Signature{R: sigR, S: sigS})
 		}
 	}
+	dbb.finishSignOperation(operationID)
 	return signatures, nil
 }
 
@@ -1166,17 +1299,16 @@ func (dbb *Device) DisplayAddress(keyPath string, typ string) error {
 		dbb.log.Debug("The address is not displayed because no pairing was found.")
 		return nil
 	}
-	reply, err := dbb.sendKV("xpub", keyPath, dbb.pin)
-	if err != nil {
+	reply := &xpubReply{}
+	if err := dbb.sendKV("xpub", keyPath, dbb.pin, reply); err != nil {
 		dbb.log.WithError(err).Error("Could not retrieve the xpub from the BitBox.")
 		return nil
 	}
-	xpubEcho, ok := reply["echo"].(string)
-	if !ok {
+	if reply.Echo == "" {
 		dbb.log.Error("The echo from the BitBox to display the address is not a string.")
 		return nil
 	}
-	if err := dbb.channel.SendXpubEcho(xpubEcho, typ); err != nil {
+	if err := dbb.channel.SendXpubEcho(reply.Echo, typ); err != nil {
 		dbb.log.WithError(err).Error("Sending the xpub echo to the mobile failed.")
 		return nil
 	}
@@ -1188,16 +1320,12 @@ func (dbb *Device) ECDHPKhash(mobileECDHPKhash string) (interface{}, error) {
 	if dbb.bootloaderStatus != nil {
 		return nil, errp.WithStack(errNoBootloader)
 	}
-	command := map[string]interface{}{
-		"ecdh": map[string]interface{}{
-			"hash_pubkey": mobileECDHPKhash,
-		},
-	}
-	reply, err := dbb.send(command, dbb.pin)
-	if err != nil {
+	reply := &ecdhReply{}
+	if err := dbb.send(
+		&ecdhRequest{ECDH: ecdhParams{HashPubkey: mobileECDHPKhash}}, dbb.pin, reply); err != nil {
 		return nil, err
 	}
-	return reply["ecdh"], nil
+	return reply.ECDH, nil
 }
 
 // ECDHPK passes the ECDH public key of the mobile to the device and returns its response.
@@ -1205,16 +1333,11 @@ func (dbb *Device) ECDHPK(mobileECDHPK string) (interface{}, error) {
 	if dbb.bootloaderStatus != nil {
 		return nil, errp.WithStack(errNoBootloader)
 	}
-	command := map[string]interface{}{
-		"ecdh": map[string]interface{}{
-			"pubkey": mobileECDHPK,
-		},
-	}
-	reply, err := dbb.send(command, dbb.pin)
-	if err != nil {
+	reply := &ecdhReply{}
+	if err := dbb.send(&ecdhRequest{ECDH: ecdhParams{Pubkey: mobileECDHPK}}, dbb.pin, reply); err != nil {
 		return nil, err
 	}
-	return reply["ecdh"], nil
+	return reply.ECDH, nil
 }
 
 // ECDHchallenge forwards a ecdh challenge command to the Bitbox
@@ -1222,19 +1345,8 @@ func (dbb *Device) ECDHchallenge() error {
 	if dbb.bootloaderStatus != nil {
 		return errp.WithStack(errNoBootloader)
 	}
-	command := map[string]interface{}{
-		"ecdh": map[string]interface{}{
-			"challenge": true,
-		},
-	}
-	reply, err := dbb.send(command, dbb.pin)
-	if err != nil {
-		return err
-	}
-	if reply["ecdh"] != "success" {
-		return errp.New("Unexpected response from bitbox")
-	}
-	return nil
+	return dbb.send(
+		&ecdhRequest{ECDH: ecdhParams{Challenge: true}}, dbb.pin, &ecdhChallengeReply{})
 }
 
 // StartPairing creates, stores and returns a new channel and finishes the pairing asynchronously.
@@ -1255,10 +1367,21 @@ func (dbb *Device) StartPairing() (*relay.Channel, error) {
 	}
 
 	channel := relay.NewChannelWithRandomKey()
+	dbb.mu.RLock()
+	channel.RelayServer = dbb.relayServer
+	dbb.mu.RUnlock()
 	go dbb.processPairing(channel)
 	return channel, nil
 }
 
+// SetRelayServer sets the relay server of new pairings, e.g. a relayserver in the local network. If
+// empty, the default relay server is used. Existing pairings keep their relay server.
+func (dbb *Device) SetRelayServer(server relay.Server) {
+	dbb.mu.Lock()
+	defer dbb.mu.Unlock()
+	dbb.relayServer = server
+}
+
 // Paired returns whether a channel to a mobile exists.
 func (dbb *Device) Paired() bool {
 	return dbb.mobileChannel() != nil
@@ -1317,6 +1440,12 @@ func (dbb *Device) Identifier() string {
 	return dbb.deviceID
 }
 
+// Fingerprint implements device.Fingerprinter. It is the device ID reported when the user logged
+// in, which is also used to recognize the device when it is reconnected.
+func (dbb *Device) Fingerprint() string {
+	return dbb.sessionID
+}
+
 // ExtendedPublicKey implements device.Interface.
 func (dbb *Device) ExtendedPublicKey(keypath signing.AbsoluteKeypath) (*hdkeychain.ExtendedKey, error) {
 	return dbb.XPub(keypath.Encode())
@@ -1340,19 +1469,18 @@ func (dbb *Device) KeystoreForConfiguration(
 
 // Lock locks the device for 2FA. Returns true if successful and false if aborted by the user.
 func (dbb *Device) Lock() (bool, error) {
-	reply, err := dbb.sendKV("device", "lock", dbb.pin)
+	reply := &deviceLockReply{}
+	err := dbb.sendKV("device", "lock", dbb.pin, reply)
+	dbb.cache.invalidateDeviceInfo()
 	if IsErrorAbort(err) {
 		return false, nil
 	}
 	if err != nil {
 		return false, errp.WithMessage(err, "Failed to lock the device")
 	}
-	replyDevice, ok := reply["device"].(map[string]interface{})
-	if !ok {
-		return false, errp.New("unexpected reply")
-	}
-	if replyLock, ok := replyDevice["lock"].(bool); !ok || !replyLock {
-		return false, errp.New("unexpected reply")
+	if !reply.Device.Lock {
+		return false, errp.WithStack(&ReplyError{
+			Command: "device", Path: "device.lock", Reason: "the device was not locked"})
 	}
 	return true, nil
 }
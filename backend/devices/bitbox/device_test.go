@@ -15,17 +15,25 @@
 package bitbox
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/mocks"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
@@ -51,6 +59,7 @@ type dbbTestSuite struct {
 	suite.Suite
 	mockCommunication *mocks.CommunicationInterface
 	mockCommClosed    bool
+	health            *health.Stats
 	configDir         string
 	dbb               *Device
 
@@ -61,13 +70,15 @@ func (s *dbbTestSuite) SetupTest() {
 	s.configDir = test.TstTempDir("dbb_device_test")
 	s.log = logging.Get().WithGroup("bitbox_test")
 	s.mockCommunication = new(mocks.CommunicationInterface)
-	s.mockCommunication.On("SendPlain", jsonArgumentMatcher(map[string]interface{}{"ping": ""})).
+	s.mockCommunication.On("SendPlain", mock.Anything, jsonArgumentMatcher(map[string]interface{}{"ping": ""})).
 		Return(map[string]interface{}{"ping": ""}, nil).
 		Once()
 	s.mockCommunication.On("Close").Run(func(mock.Arguments) {
 		s.mockCommClosed = true
 	})
 	s.mockCommClosed = false
+	s.health = &health.Stats{Assessment: &health.Assessment{}}
+	s.mockCommunication.On("Health").Return(func() *health.Stats { return s.health })
 	dbb, err := NewDevice(deviceID, false /* bootloader */, firmVer400, s.configDir, s.mockCommunication)
 	dbb.Init(true)
 	require.NoError(s.T(), err)
@@ -120,6 +131,7 @@ func (s *dbbTestSuite) TestSetPassword() {
 func (s *dbbTestSuite) login() error {
 	s.mockCommunication.On(
 		"SendPlain",
+		mock.Anything,
 		jsonArgumentMatcher(map[string]interface{}{"password": pin})).
 		Return(
 			map[string]interface{}{"password": "success"},
@@ -134,6 +146,7 @@ func (s *dbbTestSuite) TestCreateWallet() {
 	const dummyWalletName = "walletname"
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		mock.MatchedBy(func(msg string) bool {
 			cmd := map[string]string{}
 			if err := json.Unmarshal([]byte(msg), &cmd); err != nil {
@@ -148,6 +161,7 @@ func (s *dbbTestSuite) TestCreateWallet() {
 		Once()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		mock.MatchedBy(func(msg string) bool {
 			cmd := map[string]map[string]string{}
 			if err := json.Unmarshal([]byte(msg), &cmd); err != nil {
@@ -162,6 +176,7 @@ func (s *dbbTestSuite) TestCreateWallet() {
 		Once()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		mock.MatchedBy(func(msg string) bool {
 			cmd := map[string]map[string]string{}
 			if err := json.Unmarshal([]byte(msg), &cmd); err != nil {
@@ -195,6 +210,7 @@ func (s *dbbTestSuite) TestSignZero() {
 	s.mockDeviceInfo()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(sign),
 		pin,
 	).
@@ -216,6 +232,7 @@ func (s *dbbTestSuite) TestSignSingle() {
 	s.mockDeviceInfo()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(sign),
 		pin,
 	).
@@ -223,6 +240,7 @@ func (s *dbbTestSuite) TestSignSingle() {
 		Once()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(map[string]interface{}{"sign": ""}),
 		pin,
 	).
@@ -239,6 +257,7 @@ func (s *dbbTestSuite) mockDeviceInfo() {
 	_ = json.Unmarshal(jsonp.MustMarshal(&DeviceInfo{}), &deviceInfoMap)
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(map[string]interface{}{"device": "info"}),
 		pin,
 	).Return(map[string]interface{}{"device": deviceInfoMap}, nil).Once()
@@ -269,6 +288,7 @@ func (s *dbbTestSuite) TestSignFifteen() {
 	s.mockDeviceInfo()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(sign),
 		pin,
 	).
@@ -276,6 +296,7 @@ func (s *dbbTestSuite) TestSignFifteen() {
 		Once()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(map[string]interface{}{"sign": ""}),
 		pin,
 	).
@@ -318,6 +339,7 @@ func (s *dbbTestSuite) TestSignSixteen() {
 
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(sign1),
 		pin,
 	).
@@ -325,6 +347,7 @@ func (s *dbbTestSuite) TestSignSixteen() {
 		Once()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(map[string]interface{}{"sign": ""}),
 		pin,
 	).
@@ -333,6 +356,7 @@ func (s *dbbTestSuite) TestSignSixteen() {
 
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(sign2),
 		pin,
 	).
@@ -340,6 +364,7 @@ func (s *dbbTestSuite) TestSignSixteen() {
 		Once()
 	s.mockCommunication.On(
 		"SendEncrypt",
+		mock.Anything,
 		jsonArgumentMatcher(map[string]interface{}{"sign": ""}),
 		pin,
 	).
@@ -351,6 +376,89 @@ func (s *dbbTestSuite) TestSignSixteen() {
 	require.Len(s.T(), signatures, 16)
 }
 
+func (s *dbbTestSuite) TestSignResume() {
+	require.NoError(s.T(), s.login())
+
+	dataSlice := []interface{}{}
+	keypaths := []string{}
+	signatureHashes := [][]byte{}
+	responseSignatures := []interface{}{}
+	for i := 0; i < 16; i++ {
+		signatureHash := []byte{0, 0, 0, 0, byte(i)}
+		keyPath := "m/44'/0'/1'/" + strconv.Itoa(i)
+		signatureHashes = append(signatureHashes, signatureHash)
+		keypaths = append(keypaths, keyPath)
+		element := map[string]interface{}{"hash": hex.EncodeToString(signatureHash), "keypath": keyPath}
+		dataSlice = append(dataSlice, element)
+		responseSignature := make([]byte, 64)
+		responseSignature[63] = byte(i)
+		responseSignatures = append(responseSignatures, map[string]interface{}{"sig": hex.EncodeToString(responseSignature)})
+	}
+	sign1 := map[string]interface{}{"sign": map[string]interface{}{"data": dataSlice[:15]}}
+	sign2 := map[string]interface{}{"sign": map[string]interface{}{"data": dataSlice[15:]}}
+	signConfirm := jsonArgumentMatcher(map[string]interface{}{"sign": ""})
+
+	// The device is unplugged while signing the second batch.
+	s.mockDeviceInfo()
+	s.mockCommunication.On("SendEncrypt", mock.Anything, jsonArgumentMatcher(sign1), pin).Return(nil, nil).Once()
+	s.mockCommunication.On("SendEncrypt", mock.Anything, signConfirm, pin).
+		Return(map[string]interface{}{"sign": responseSignatures[:15]}, nil).Once()
+	s.mockCommunication.On("SendEncrypt", mock.Anything, jsonArgumentMatcher(sign2), pin).Return(nil, nil).Once()
+	s.mockCommunication.On("SendEncrypt", mock.Anything, signConfirm, pin).
+		Return(nil, errors.New("hidapi: unknown failure")).Once()
+	_, err := s.dbb.Sign(nil, signatureHashes, keypaths)
+	require.Error(s.T(), err)
+	require.Equal(s.T(), 1, s.dbb.journal.Pending())
+
+	// Signing again only signs the second batch.
+	s.mockDeviceInfo()
+	s.mockCommunication.On("SendEncrypt", mock.Anything, jsonArgumentMatcher(sign2), pin).Return(nil, nil).Once()
+	s.mockCommunication.On("SendEncrypt", mock.Anything, signConfirm, pin).
+		Return(map[string]interface{}{"sign": responseSignatures[15:]}, nil).Once()
+	signatures, err := s.dbb.Sign(nil, signatureHashes, keypaths)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), signatures, 16)
+	for i, signature := range signatures {
+		require.Equal(s.T(), int64(i), signature.S.Int64())
+	}
+	require.Equal(s.T(), 0, s.dbb.journal.Pending())
+}
+
+func (s *dbbTestSuite) TestSignConnectionFlaky() {
+	require.NoError(s.T(), s.login())
+	var assessments []interface{}
+	s.dbb.SetOnEvent(func(e device.Event, data interface{}) {
+		if e == EventConnectionFlaky {
+			assessments = append(assessments, data)
+		}
+	})
+	deviceInfoFailed := func() {
+		s.mockCommunication.On("SendEncrypt", mock.Anything,
+			jsonArgumentMatcher(map[string]interface{}{"device": "info"}), pin).
+			Return(nil, errors.New("hidapi: unknown failure")).Once()
+	}
+	signatureHashes := [][]byte{{0, 0, 0, 0, 0}}
+	keyPaths := []string{"m/44'/0'/1'/0"}
+
+	deviceInfoFailed()
+	_, err := s.dbb.Sign(nil, signatureHashes, keyPaths)
+	require.Error(s.T(), err)
+	require.Empty(s.T(), assessments)
+
+	// The user is warned before signing starts.
+	flaky := &health.Assessment{Flaky: true, RoundTrips: 5, Faults: 2, Reason: "2 of 5 round trips failed"}
+	s.health = &health.Stats{Assessment: flaky}
+	deviceInfoFailed()
+	_, err = s.dbb.Sign(nil, signatureHashes, keyPaths)
+	require.Error(s.T(), err)
+	require.Equal(s.T(), []interface{}{flaky}, assessments)
+
+	deviceInfoFailed()
+	_, err = s.dbb.SignBatch([]*SignRequest{{SignatureHashes: signatureHashes, KeyPaths: keyPaths}})
+	require.Error(s.T(), err)
+	require.Len(s.T(), assessments, 2)
+}
+
 func (s *dbbTestSuite) TestDeviceClose() {
 	require.False(s.T(), s.dbb.closed, "s.dbb.closed")
 	require.False(s.T(), s.mockCommClosed, "s.mockCommClosed")
@@ -373,6 +481,21 @@ func (s *dbbTestSuite) TestDeviceStatusEvent() {
 	require.True(s.T(), seen, "EventStatusChanged")
 }
 
+func (s *dbbTestSuite) TestDeviceEvents() {
+	var fired []device.Event
+	var firedData []interface{}
+	s.dbb.SetOnEvent(func(e device.Event, data interface{}) {
+		fired = append(fired, e)
+		firedData = append(firedData, data)
+	})
+	progress := map[string]interface{}{"progress": float64(50)}
+	s.dbb.onDeviceEvent(&DeviceEvent{Name: "button", Data: progress})
+	s.dbb.onDeviceEvent(&DeviceEvent{Name: "unknown"})
+	s.dbb.onDeviceEvent(&DeviceEvent{Name: "tamper", Data: map[string]interface{}{}})
+	require.Equal(s.T(), []device.Event{EventDeviceButton, EventTamperWarning}, fired)
+	require.Equal(s.T(), progress, firedData[0])
+}
+
 func TestNewDeviceReadsChannel(t *testing.T) {
 	configDir := test.TstTempDir("dbb_device_test")
 	defer os.RemoveAll(configDir)
@@ -383,7 +506,7 @@ func TestNewDeviceReadsChannel(t *testing.T) {
 
 	// TODO: Also run a relay server stub when available instead of hitting prod server; see TestPingMobile.
 	comm := new(mocks.CommunicationInterface)
-	comm.On("SendPlain", jsonArgumentMatcher(map[string]interface{}{"ping": ""})).
+	comm.On("SendPlain", mock.Anything, jsonArgumentMatcher(map[string]interface{}{"ping": ""})).
 		Return(map[string]interface{}{"ping": ""}, nil)
 	comm.On("Close")
 	dbb, err := NewDevice("test-device-id", false /* bootloader */, firmVer400, configDir, comm)
@@ -406,6 +529,85 @@ func (s *dbbTestSuite) TestListenForMobile() {
 }
 
 func (s *dbbTestSuite) TestPingMobile() {
-	// TODO: Need to be able to replace relay.DefaultServer URL in tests.
-	s.T().Skip("implement once relay server URL can be replaced in testing")
+	require.Error(s.T(), s.dbb.PingMobile())
+
+	mailbox := relay.NewMailbox(time.Second)
+	channel := relay.NewChannelWithRandomKey()
+	channel.SetTransport(mailbox)
+	s.dbb.mu.Lock()
+	s.dbb.channel = channel
+	s.dbb.mu.Unlock()
+	go func() {
+		// The mobile answers the ping.
+		if payload, err := mailbox.Pull(relay.Mobile, channel); err != nil || payload == "" {
+			return
+		}
+		pong, err := crypto.EncryptThenMAC(
+			[]byte(`{"action":"pong"}`), channel.EncryptionKey, channel.AuthenticationKey)
+		if err != nil {
+			panic(err)
+		}
+		_ = mailbox.Push(relay.Mobile, channel, base64.StdEncoding.EncodeToString(pong))
+	}()
+	require.NoError(s.T(), s.dbb.PingMobile())
+}
+
+func (s *dbbTestSuite) TestXPubs() {
+	require.NoError(s.T(), s.login())
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.MainNetParams)
+	require.NoError(s.T(), err)
+	xpub, err := master.Neuter()
+	require.NoError(s.T(), err)
+	paths := []string{}
+	for i := 0; i < xpubBatchSize+2; i++ {
+		paths = append(paths, "m/44'/0'/"+strconv.Itoa(i)+"'")
+	}
+	batches := [][]string{}
+	replyXPub := xpub.String()
+	s.mockCommunication.On("SendEncryptBatch", mock.Anything, mock.Anything, pin).
+		Return(func(_ context.Context, msgs []string, _ string) []map[string]interface{} {
+			batches = append(batches, msgs)
+			replies := []map[string]interface{}{}
+			for range msgs {
+				replies = append(replies, map[string]interface{}{"xpub": replyXPub})
+			}
+			return replies
+		}, nil).Times(2)
+	xpubs, err := s.dbb.XPubs(paths)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), xpubs, len(paths))
+	require.Equal(s.T(), xpub.String(), xpubs[0].String())
+	// Each path is queried twice, in batches of xpubBatchSize paths.
+	require.Len(s.T(), batches, 2)
+	require.Len(s.T(), batches[0], 2*xpubBatchSize)
+	require.Len(s.T(), batches[1], 4)
+	require.JSONEq(s.T(), `{"xpub": "m/44'/0'/0'"}`, batches[0][0])
+	require.JSONEq(s.T(), `{"xpub": "m/44'/0'/0'"}`, batches[0][1])
+	require.JSONEq(s.T(), `{"xpub": "m/44'/0'/9'"}`, batches[1][3])
+
+	// The xpubs are cached, only new paths are queried.
+	s.mockCommunication.On("SendEncryptBatch", mock.Anything, []string{`{"xpub":"m/1'"}`, `{"xpub":"m/1'"}`}, pin).
+		Return([]map[string]interface{}{{"xpub": xpub.String()}, {"xpub": xpub.String()}}, nil).Once()
+	xpubs, err = s.dbb.XPubs(append([]string{"m/1'"}, paths...))
+	require.NoError(s.T(), err)
+	require.Len(s.T(), xpubs, len(paths)+1)
+	require.Len(s.T(), batches, 2)
+	cached, err := s.dbb.XPub(paths[0])
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), xpub.String(), cached.String())
+
+	// Inconsistent replies are rejected.
+	s.mockCommunication.On("SendEncryptBatch", mock.Anything, mock.Anything, pin).
+		Return([]map[string]interface{}{{"xpub": xpub.String()}, {"xpub": master.String()}}, nil).Once()
+	_, err = s.dbb.XPubs([]string{"m/2'"})
+	require.Error(s.T(), err)
+
+	// Resetting the device invalidates the cache.
+	s.mockCommunication.On("SendEncrypt", mock.Anything,
+		jsonArgumentMatcher(map[string]interface{}{"reset": "__ERASE__"}), pin).
+		Return(map[string]interface{}{"reset": "success"}, nil).Once()
+	reset, err := s.dbb.Reset(pin)
+	require.NoError(s.T(), err)
+	require.True(s.T(), reset)
+	require.Nil(s.T(), s.dbb.cache.xpub(pin, paths[0]))
 }
@@ -0,0 +1,73 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EventDeviceButton is fired when the device reports the state of the touch button while the
+	// user is asked to confirm, e.g. how far a long touch has progressed.
+	EventDeviceButton device.Event = "deviceButton"
+
+	// EventTamperWarning is fired when the device reports a tamper warning.
+	EventTamperWarning device.Event = "tamperWarning"
+)
+
+// deviceEvents maps the names of the events sent by the device to the events fired by the Device.
+var deviceEvents = map[string]device.Event{
+	"button": EventDeviceButton,
+	"tamper": EventTamperWarning,
+}
+
+// DeviceEvent is a message the device sent on its own, i.e. not as the reply to a command. Events
+// are not encrypted, so they are only shown to the user and must not be trusted otherwise.
+type DeviceEvent struct {
+	// Name is the value of the "event" field of the message, e.g. "button".
+	Name string
+	// Data are the other fields of the message.
+	Data map[string]interface{}
+}
+
+// DeviceEventSource is implemented by communications which receive the events of the device.
+type DeviceEventSource interface {
+	// SetOnDeviceEvent installs a callback which is called for each event of the device, in order.
+	// Events received while the callback is still busy with many previous events are dropped.
+	SetOnDeviceEvent(func(*DeviceEvent))
+}
+
+// listenForDeviceEvents installs the handler of the device events if the communication receives
+// them.
+func (dbb *Device) listenForDeviceEvents(communication CommunicationInterface) {
+	if source, ok := communication.(DeviceEventSource); ok {
+		source.SetOnDeviceEvent(dbb.onDeviceEvent)
+	}
+}
+
+// onDeviceEvent fires the event corresponding to the event of the device. Unknown events, e.g. of
+// newer firmware, are ignored.
+func (dbb *Device) onDeviceEvent(event *DeviceEvent) {
+	deviceEvent, ok := deviceEvents[event.Name]
+	if !ok {
+		dbb.log.WithField("event", event.Name).Debug("Ignoring unknown device event")
+		return
+	}
+	if deviceEvent == EventTamperWarning {
+		dbb.log.WithFields(logrus.Fields{"data": event.Data}).Warning("The device reported a tamper warning")
+	}
+	dbb.fireEvent(deviceEvent, event.Data)
+}
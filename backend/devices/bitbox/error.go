@@ -18,6 +18,10 @@ const (
 	// ErrIONoPassword is returned when no password has been configured.
 	ErrIONoPassword = 101
 
+	// ErrIOInvalidCmd is returned when the command is not known, e.g. a batch of commands sent to
+	// firmware which does not support them.
+	ErrIOInvalidCmd = 104
+
 	// ErrTouchAbort is returned when the user short-touches the button.
 	ErrTouchAbort = 600
 
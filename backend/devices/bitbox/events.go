@@ -34,10 +34,35 @@ const (
 	// EventPairingSuccess is fired when the pairing successfully finished.
 	EventPairingSuccess device.Event = "pairingSuccess"
 
+	// EventPairingIdentityMismatch is fired when the stored mobile channel was paired with a
+	// different device. The user has to pair the device again.
+	EventPairingIdentityMismatch device.Event = "pairingIdentityMismatch"
+
+	// EventNoiseIdentityMismatch is fired when the device presented a different static key in the
+	// Noise handshake than on previous connections, or refused the Noise channel it established
+	// before. See Device.AcceptNoiseIdentity().
+	EventNoiseIdentityMismatch device.Event = "noiseIdentityMismatch"
+
 	// EventSignProgress is fired when starting to sign a new batch of hashes.
 	EventSignProgress device.Event = "signProgress"
 
+	// EventSignBatchProgress is fired when starting to sign the next transaction of a batch, see
+	// Device.SignBatch().
+	EventSignBatchProgress device.Event = "signBatchProgress"
+
 	// EventSignConfirmDevice is fired when the user is about to be prompted to sign the tx with the
 	// device.
 	EventSignConfirm device.Event = "signConfirm"
+
+	// EventConnectionLost is fired when the device disappeared, but the session is kept in case it
+	// is reconnected shortly.
+	EventConnectionLost device.Event = "connectionLost"
+
+	// EventConnectionResumed is fired when the session was resumed after the device reconnected.
+	EventConnectionResumed device.Event = "connectionResumed"
+
+	// EventConnectionFlaky is fired before signing if the connection to the device looks
+	// unreliable, e.g. because of a bad cable or USB port, so that the user can fix it before
+	// signing fails midway. The data is the *health.Assessment.
+	EventConnectionFlaky device.Event = "connectionFlaky"
 )
@@ -39,3 +39,24 @@ func BundledFirmware() []byte {
 	}
 	return binary
 }
+
+// FirmwareDowngradeError is returned when a firmware upgrade would install an older firmware than
+// the one on the device. The device would reject the firmware anyway, but only after it was erased.
+type FirmwareDowngradeError struct {
+	Installed *semver.SemVer
+	New       *semver.SemVer
+}
+
+// Error implements the error interface.
+func (err *FirmwareDowngradeError) Error() string {
+	return fmt.Sprintf("firmware %s is older than the installed firmware %s", err.New, err.Installed)
+}
+
+// checkFirmwareDowngrade returns a *FirmwareDowngradeError if newVersion is older than the installed
+// version. Re-installing the same version is allowed.
+func checkFirmwareDowngrade(installed, newVersion *semver.SemVer) error {
+	if installed != nil && !newVersion.AtLeast(installed) {
+		return &FirmwareDowngradeError{Installed: installed, New: newVersion}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+)
+
+// FirmwareReleasesURL is where the signed firmware release metadata is published. Like all
+// requests of the app, it is fetched through Tor if a SOCKS proxy is configured.
+const FirmwareReleasesURL = "https://shiftcrypto.ch/updates/bitbox-firmware.json"
+
+const (
+	firmwareReleasesFileName = "releases.json"
+	// maxFirmwareSize limits the download of a firmware binary, including the signatures.
+	maxFirmwareSize = 1 << 20
+)
+
+// firmwareSigningKeysHex are the comma separated compressed public keys, in hex format, which sign
+// the firmware release metadata. They are set at build time with
+// -ldflags "-X github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox.firmwareSigningKeysHex=<hex>,<hex>".
+var firmwareSigningKeysHex string
+
+// FirmwareSigningKeys returns the public keys trusted to sign the firmware release metadata, or an
+// error if the build does not support firmware updates.
+func FirmwareSigningKeys() ([]*btcec.PublicKey, error) {
+	if firmwareSigningKeysHex == "" {
+		return nil, errp.New("This build does not support firmware updates")
+	}
+	publicKeys := []*btcec.PublicKey{}
+	for _, publicKeyHex := range strings.Split(firmwareSigningKeysHex, ",") {
+		publicKeyBytes, err := hex.DecodeString(strings.TrimSpace(publicKeyHex))
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		publicKey, err := btcec.ParsePubKey(publicKeyBytes, btcec.S256())
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+// FirmwareRelease describes a signed firmware binary which can be downloaded.
+type FirmwareRelease struct {
+	Version *semver.SemVer `json:"version"`
+	// URL is where the signed binary is downloaded from. It must be an HTTPS URL.
+	URL string `json:"url"`
+	// SHA256 is the hex encoded hash of the signed binary. The signatures within the binary are
+	// only verified by the bootloader, so the hash protects against flashing a binary which is not
+	// the published one.
+	SHA256 string `json:"sha256"`
+	// FirmwareHash is the hex encoded hash of the firmware as reported by the bootloader, see
+	// Device.BootloaderFirmwareHash(). It is optional.
+	FirmwareHash string `json:"firmwareHash,omitempty"`
+	Description  string `json:"description"`
+}
+
+func (release *FirmwareRelease) validate() error {
+	if release.Version == nil {
+		return errp.New("the firmware version is required")
+	}
+	if !strings.HasPrefix(release.URL, "https://") {
+		return errp.Newf("firmware %s: the URL must be an HTTPS URL", release.Version)
+	}
+	if hash, err := hex.DecodeString(release.SHA256); err != nil || len(hash) != sha256.Size {
+		return errp.Newf("firmware %s: invalid hash", release.Version)
+	}
+	if release.FirmwareHash != "" {
+		if hash, err := hex.DecodeString(release.FirmwareHash); err != nil || len(hash) != sha256.Size {
+			return errp.Newf("firmware %s: invalid firmware hash", release.Version)
+		}
+	}
+	return nil
+}
+
+// FirmwareReleases is the published list of firmware releases.
+type FirmwareReleases struct {
+	// Sequence increases with every publication. Metadata with a lower sequence than the known one
+	// is rejected, so that an attacker can't hide new releases by replaying old metadata.
+	Sequence uint32             `json:"sequence"`
+	Releases []*FirmwareRelease `json:"releases"`
+}
+
+// Latest returns the release with the highest version, or nil if there are no releases.
+func (releases *FirmwareReleases) Latest() *FirmwareRelease {
+	var latest *FirmwareRelease
+	for _, release := range releases.Releases {
+		if latest == nil || !latest.Version.AtLeast(release.Version) {
+			latest = release
+		}
+	}
+	return latest
+}
+
+// Release returns the release with the given version, or nil if there is none.
+func (releases *FirmwareReleases) Release(version *semver.SemVer) *FirmwareRelease {
+	for _, release := range releases.Releases {
+		if release.Version.String() == version.String() {
+			return release
+		}
+	}
+	return nil
+}
+
+// FirmwareHashStatus is the result of checking the hash of the installed firmware against the
+// releases, see FirmwareChannel.CheckFirmwareHash().
+type FirmwareHashStatus string
+
+const (
+	// FirmwareHashGenuine means the hash matches a published release.
+	FirmwareHashGenuine FirmwareHashStatus = "genuine"
+	// FirmwareHashMismatch means the release of the installed version was published with a
+	// different hash, so the installed firmware is not the released one.
+	FirmwareHashMismatch FirmwareHashStatus = "mismatch"
+	// FirmwareHashUnknown means neither the hash nor the installed version is published with a
+	// hash, e.g. because the release metadata was not fetched yet.
+	FirmwareHashUnknown FirmwareHashStatus = "unknown"
+)
+
+// SignedFirmwareReleases is the published firmware release metadata with its signatures.
+type SignedFirmwareReleases struct {
+	// Releases is the JSON encoded FirmwareReleases. It is kept as a string so that the signed
+	// bytes are preserved exactly.
+	Releases string `json:"releases"`
+	// Signatures are hex encoded DER signatures of the SHA256 hash of Releases.
+	Signatures []string `json:"signatures"`
+}
+
+// requiredFirmwareSignatures returns the number of signing keys which have to sign the release
+// metadata, which is the majority of the keys.
+func requiredFirmwareSignatures(publicKeys []*btcec.PublicKey) int {
+	return len(publicKeys)/2 + 1
+}
+
+// VerifyFirmwareReleases checks that the majority of the public keys signed the release metadata
+// and returns the validated releases.
+func VerifyFirmwareReleases(
+	signed *SignedFirmwareReleases, publicKeys []*btcec.PublicKey) (*FirmwareReleases, error) {
+	hash := sha256.Sum256([]byte(signed.Releases))
+	signedBy := map[int]bool{}
+	for _, signatureHex := range signed.Signatures {
+		signatureBytes, err := hex.DecodeString(signatureHex)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		signature, err := btcec.ParseDERSignature(signatureBytes, btcec.S256())
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		for index, publicKey := range publicKeys {
+			if signature.Verify(hash[:], publicKey) {
+				signedBy[index] = true
+			}
+		}
+	}
+	if len(signedBy) < requiredFirmwareSignatures(publicKeys) {
+		return nil, errp.Newf("The firmware releases are signed by %d of the %d required keys",
+			len(signedBy), requiredFirmwareSignatures(publicKeys))
+	}
+	releases := &FirmwareReleases{}
+	if err := json.Unmarshal([]byte(signed.Releases), releases); err != nil {
+		return nil, errp.Wrap(err, "Failed to decode the firmware releases")
+	}
+	for _, release := range releases.Releases {
+		if err := release.validate(); err != nil {
+			return nil, errp.WithMessage(
+				err, fmt.Sprintf("invalid firmware releases sequence %d", releases.Sequence))
+		}
+	}
+	return releases, nil
+}
+
+// FirmwareChannel fetches the signed firmware release metadata and downloads the released
+// binaries, so that the device can be upgraded to firmware newer than the bundled one. The
+// metadata and the downloaded binaries are kept in a directory, and the binaries are verified
+// against the metadata every time they are used.
+type FirmwareChannel struct {
+	directory string
+	// publicKeys verify the fetched and cached metadata. If empty, only the bundled firmware is
+	// available.
+	publicKeys []*btcec.PublicKey
+
+	releases *FirmwareReleases
+	lock     locker.Locker
+
+	log *logrus.Entry
+}
+
+// NewFirmwareChannel creates a channel storing its files in the given directory, loading the
+// cached metadata if it exists.
+func NewFirmwareChannel(
+	directory string, publicKeys []*btcec.PublicKey, log *logrus.Entry) *FirmwareChannel {
+	channel := &FirmwareChannel{
+		directory:  directory,
+		publicKeys: publicKeys,
+		releases:   &FirmwareReleases{Releases: []*FirmwareRelease{}},
+		log:        log,
+	}
+	channel.loadCache()
+	return channel
+}
+
+func (channel *FirmwareChannel) loadCache() {
+	if len(channel.publicKeys) == 0 {
+		return
+	}
+	jsonBytes, err := ioutil.ReadFile(filepath.Join(channel.directory, firmwareReleasesFileName))
+	if err != nil {
+		return
+	}
+	signed := &SignedFirmwareReleases{}
+	if err := json.Unmarshal(jsonBytes, signed); err != nil {
+		channel.log.WithError(err).Error("Ignoring the cached firmware releases")
+		return
+	}
+	if _, err := channel.update(signed, false); err != nil {
+		channel.log.WithError(err).Error("Ignoring the cached firmware releases")
+	}
+}
+
+// Enabled returns whether the build supports downloading firmware.
+func (channel *FirmwareChannel) Enabled() bool {
+	return len(channel.publicKeys) != 0
+}
+
+// Latest returns the newest released firmware, or nil if no release is known.
+func (channel *FirmwareChannel) Latest() *FirmwareRelease {
+	defer channel.lock.RLock()()
+	return channel.releases.Latest()
+}
+
+// Update verifies the signed metadata and uses it if its sequence is higher than the current one.
+// The metadata is then cached, so that it is used after a restart. Returns true if the metadata
+// was applied.
+func (channel *FirmwareChannel) Update(signed *SignedFirmwareReleases) (bool, error) {
+	return channel.update(signed, true)
+}
+
+func (channel *FirmwareChannel) update(signed *SignedFirmwareReleases, cache bool) (bool, error) {
+	if !channel.Enabled() {
+		return false, errp.New("This build does not support firmware updates")
+	}
+	releases, err := VerifyFirmwareReleases(signed, channel.publicKeys)
+	if err != nil {
+		return false, err
+	}
+	defer channel.lock.Lock()()
+	if releases.Sequence <= channel.releases.Sequence {
+		return false, nil
+	}
+	if cache {
+		jsonBytes, err := json.Marshal(signed)
+		if err != nil {
+			return false, errp.WithStack(err)
+		}
+		if err := os.MkdirAll(channel.directory, 0700); err != nil {
+			return false, errp.WithStack(err)
+		}
+		if err := ioutil.WriteFile(
+			filepath.Join(channel.directory, firmwareReleasesFileName), jsonBytes, 0600); err != nil {
+			return false, errp.WithStack(err)
+		}
+	}
+	channel.releases = releases
+	channel.log.WithField("sequence", releases.Sequence).Info("Using updated firmware releases")
+	return true, nil
+}
+
+// Refresh fetches the latest signed metadata from FirmwareReleasesURL and applies it if it is
+// newer. Returns true if the metadata changed.
+func (channel *FirmwareChannel) Refresh() (bool, error) {
+	if !channel.Enabled() {
+		return false, nil
+	}
+	response, err := egress.Get(FirmwareReleasesURL, egress.PurposeUpdate)
+	if err != nil {
+		return false, errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return false, errp.Newf(
+			"fetching the firmware releases failed with status %d", response.StatusCode)
+	}
+	signed := &SignedFirmwareReleases{}
+	if err := json.NewDecoder(response.Body).Decode(signed); err != nil {
+		return false, errp.WithStack(err)
+	}
+	return channel.Update(signed)
+}
+
+func (channel *FirmwareChannel) binaryFilename(version *semver.SemVer) string {
+	return filepath.Join(channel.directory,
+		fmt.Sprintf("firmware.deterministic.%s.signed.bin", version))
+}
+
+// verifyFirmware checks the binary against the hash of the release.
+func verifyFirmware(release *FirmwareRelease, binary []byte) error {
+	hash := sha256.Sum256(binary)
+	if hex.EncodeToString(hash[:]) != strings.ToLower(release.SHA256) {
+		return errp.Newf("the firmware %s does not match the published hash", release.Version)
+	}
+	if len(binary) <= signaturesSize {
+		return errp.Newf("the firmware %s is too short", release.Version)
+	}
+	return nil
+}
+
+// Downloaded returns whether the binary of the release was downloaded and is intact.
+func (channel *FirmwareChannel) Downloaded(release *FirmwareRelease) bool {
+	binary, err := ioutil.ReadFile(channel.binaryFilename(release.Version))
+	return err == nil && verifyFirmware(release, binary) == nil
+}
+
+// Download fetches the binary of the release and stores it after verifying its hash. Nothing is
+// downloaded if the binary is already stored.
+func (channel *FirmwareChannel) Download(release *FirmwareRelease) error {
+	if channel.Downloaded(release) {
+		return nil
+	}
+	response, err := egress.Get(release.URL, egress.PurposeUpdate)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return errp.Newf("downloading the firmware %s failed with status %d",
+			release.Version, response.StatusCode)
+	}
+	var binary bytes.Buffer
+	if _, err := io.Copy(&binary, io.LimitReader(response.Body, maxFirmwareSize+1)); err != nil {
+		return errp.WithStack(err)
+	}
+	if binary.Len() > maxFirmwareSize {
+		return errp.Newf("the firmware %s is too big", release.Version)
+	}
+	if err := verifyFirmware(release, binary.Bytes()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(channel.directory, 0700); err != nil {
+		return errp.WithStack(err)
+	}
+	channel.log.WithField("version", release.Version).Info("Downloaded firmware")
+	return errp.WithStack(
+		ioutil.WriteFile(channel.binaryFilename(release.Version), binary.Bytes(), 0600))
+}
+
+// Firmware returns the signed binary of the given version, which is either the bundled firmware or
+// a downloaded release. Downloaded binaries are verified against the current metadata again.
+func (channel *FirmwareChannel) Firmware(version *semver.SemVer) ([]byte, error) {
+	if version.String() == BundledFirmwareVersion().String() {
+		return BundledFirmware(), nil
+	}
+	release := func() *FirmwareRelease {
+		defer channel.lock.RLock()()
+		return channel.releases.Release(version)
+	}()
+	if release == nil {
+		return nil, errp.Newf("unknown firmware %s", version)
+	}
+	binary, err := ioutil.ReadFile(channel.binaryFilename(version))
+	if err != nil {
+		return nil, errp.Newf("the firmware %s was not downloaded", version)
+	}
+	if err := verifyFirmware(release, binary); err != nil {
+		return nil, err
+	}
+	return binary, nil
+}
+
+// CheckFirmwareHash checks the hash reported by the bootloader against the hashes of the releases.
+// version is the installed version if known, and can be nil. The matching release is returned if
+// the firmware is genuine.
+func (channel *FirmwareChannel) CheckFirmwareHash(
+	hash []byte, version *semver.SemVer) (FirmwareHashStatus, *FirmwareRelease) {
+	defer channel.lock.RLock()()
+	hashHex := hex.EncodeToString(hash)
+	for _, release := range channel.releases.Releases {
+		if strings.ToLower(release.FirmwareHash) == hashHex {
+			return FirmwareHashGenuine, release
+		}
+	}
+	if version != nil {
+		if release := channel.releases.Release(version); release != nil && release.FirmwareHash != "" {
+			return FirmwareHashMismatch, nil
+		}
+	}
+	return FirmwareHashUnknown, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+func signReleases(
+	t *testing.T, releases *FirmwareReleases, privateKeys ...*btcec.PrivateKey) *SignedFirmwareReleases {
+	releasesBytes, err := json.Marshal(releases)
+	require.NoError(t, err)
+	hash := sha256.Sum256(releasesBytes)
+	signed := &SignedFirmwareReleases{Releases: string(releasesBytes), Signatures: []string{}}
+	for _, privateKey := range privateKeys {
+		signature, err := privateKey.Sign(hash[:])
+		require.NoError(t, err)
+		signed.Signatures = append(signed.Signatures, hex.EncodeToString(signature.Serialize()))
+	}
+	return signed
+}
+
+func testReleases(sequence uint32, binary []byte) *FirmwareReleases {
+	hash := sha256.Sum256(binary)
+	return &FirmwareReleases{
+		Sequence: sequence,
+		Releases: []*FirmwareRelease{
+			{
+				Version: semver.NewSemVer(4, 1, 0),
+				URL:     "https://example.com/firmware.4.1.0.signed.bin",
+				SHA256:  hex.EncodeToString(hash[:]),
+			},
+			{
+				Version: semver.NewSemVer(4, 0, 1),
+				URL:     "https://example.com/firmware.4.0.1.signed.bin",
+				SHA256:  hex.EncodeToString(hash[:]),
+			},
+		},
+	}
+}
+
+func TestFirmwareChannel(t *testing.T) {
+	directory := test.TstTempDir("firmware_channel_test")
+	defer func() { _ = os.RemoveAll(directory) }()
+	log := logging.Get().WithGroup("firmware_channel_test")
+
+	privateKeys := []*btcec.PrivateKey{}
+	publicKeys := []*btcec.PublicKey{}
+	for i := 0; i < 3; i++ {
+		privateKey, err := btcec.NewPrivateKey(btcec.S256())
+		require.NoError(t, err)
+		privateKeys = append(privateKeys, privateKey)
+		publicKeys = append(publicKeys, privateKey.PubKey())
+	}
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	binary := append(bytes.Repeat([]byte{0x01}, signaturesSize), 0x02, 0x03)
+
+	channel := NewFirmwareChannel(directory, publicKeys, log)
+	require.True(t, channel.Enabled())
+	require.Nil(t, channel.Latest())
+
+	// Two of the three keys have to sign, and signing twice with the same key does not count.
+	_, err = channel.Update(signReleases(t, testReleases(1, binary), privateKeys[0]))
+	require.Error(t, err)
+	_, err = channel.Update(signReleases(t, testReleases(1, binary), privateKeys[0], privateKeys[0]))
+	require.Error(t, err)
+	_, err = channel.Update(signReleases(t, testReleases(1, binary), privateKeys[0], otherKey))
+	require.Error(t, err)
+
+	// Releases must be downloaded over HTTPS.
+	invalid := testReleases(1, binary)
+	invalid.Releases[0].URL = "http://example.com/firmware.bin"
+	_, err = channel.Update(signReleases(t, invalid, privateKeys[0], privateKeys[2]))
+	require.Error(t, err)
+
+	updated, err := channel.Update(signReleases(t, testReleases(2, binary), privateKeys[0], privateKeys[2]))
+	require.NoError(t, err)
+	require.True(t, updated)
+	latest := channel.Latest()
+	require.NotNil(t, latest)
+	require.Equal(t, "4.1.0", latest.Version.String())
+
+	// Older metadata is ignored.
+	updated, err = channel.Update(signReleases(t, testReleases(1, binary), privateKeys[1], privateKeys[2]))
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	// The bundled firmware is always available.
+	bundled, err := channel.Firmware(BundledFirmwareVersion())
+	require.NoError(t, err)
+	require.Equal(t, BundledFirmware(), bundled)
+
+	_, err = channel.Firmware(latest.Version)
+	require.Error(t, err)
+	require.False(t, channel.Downloaded(latest))
+
+	// Binaries which don't match the published hash are rejected.
+	require.NoError(t, ioutil.WriteFile(channel.binaryFilename(latest.Version), binary[1:], 0600))
+	require.False(t, channel.Downloaded(latest))
+	_, err = channel.Firmware(latest.Version)
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(channel.binaryFilename(latest.Version), binary, 0600))
+	require.True(t, channel.Downloaded(latest))
+	firmware, err := channel.Firmware(latest.Version)
+	require.NoError(t, err)
+	require.Equal(t, binary, firmware)
+	_, err = channel.Firmware(semver.NewSemVer(5, 0, 0))
+	require.Error(t, err)
+
+	// The metadata is cached, but only used with the right keys.
+	channel = NewFirmwareChannel(directory, publicKeys, log)
+	require.Equal(t, "4.1.0", channel.Latest().Version.String())
+	channel = NewFirmwareChannel(directory, []*btcec.PublicKey{otherKey.PubKey()}, log)
+	require.Nil(t, channel.Latest())
+	channel = NewFirmwareChannel(directory, nil, log)
+	require.False(t, channel.Enabled())
+	require.Nil(t, channel.Latest())
+}
+
+func TestCheckFirmwareHash(t *testing.T) {
+	directory := test.TstTempDir("firmware_channel_test")
+	defer func() { _ = os.RemoveAll(directory) }()
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	channel := NewFirmwareChannel(
+		directory, []*btcec.PublicKey{privateKey.PubKey()}, logging.Get().WithGroup("firmware_channel_test"))
+
+	genuineHash := bytes.Repeat([]byte{0xab}, sha256.Size)
+	otherHash := bytes.Repeat([]byte{0xcd}, sha256.Size)
+	status, release := channel.CheckFirmwareHash(genuineHash, semver.NewSemVer(4, 1, 0))
+	require.Equal(t, FirmwareHashUnknown, status)
+	require.Nil(t, release)
+
+	releases := testReleases(1, []byte{})
+	releases.Releases[0].FirmwareHash = hex.EncodeToString(genuineHash)
+	_, err = channel.Update(signReleases(t, releases, privateKey))
+	require.NoError(t, err)
+
+	status, release = channel.CheckFirmwareHash(genuineHash, nil)
+	require.Equal(t, FirmwareHashGenuine, status)
+	require.Equal(t, "4.1.0", release.Version.String())
+	status, _ = channel.CheckFirmwareHash(otherHash, semver.NewSemVer(4, 1, 0))
+	require.Equal(t, FirmwareHashMismatch, status)
+	// The release of 4.0.1 has no firmware hash.
+	status, _ = channel.CheckFirmwareHash(otherHash, semver.NewSemVer(4, 0, 1))
+	require.Equal(t, FirmwareHashUnknown, status)
+	status, _ = channel.CheckFirmwareHash(otherHash, nil)
+	require.Equal(t, FirmwareHashUnknown, status)
+
+	releases = testReleases(2, []byte{})
+	releases.Releases[0].FirmwareHash = "abcd"
+	_, err = channel.Update(signReleases(t, releases, privateKey))
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers/requestid"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+)
+
+// firmwareStatus describes the installed firmware and the firmware available for an upgrade.
+type firmwareStatus struct {
+	// Installed is nil if the version is not known, e.g. in bootloader mode.
+	Installed *semver.SemVer `json:"installed"`
+	Bundled   *semver.SemVer `json:"bundled"`
+	// Latest is the newest release published in the firmware channel, if any.
+	Latest *bitbox.FirmwareRelease `json:"latest"`
+	// UpgradeAvailable is true if the bundled or the latest released firmware is newer than the
+	// installed one.
+	UpgradeAvailable bool `json:"upgradeAvailable"`
+	// Downloaded is true if the latest release was downloaded already.
+	Downloaded bool `json:"downloaded"`
+	// ChannelEnabled is false if the build does not support firmware updates besides the bundled
+	// firmware.
+	ChannelEnabled bool `json:"channelEnabled"`
+}
+
+// upgradeVersion returns the newest firmware available, and the release if it is not the bundled
+// firmware.
+func (handlers *Handlers) upgradeVersion() (*semver.SemVer, *bitbox.FirmwareRelease) {
+	latest := handlers.firmwareChannel.Latest()
+	if latest != nil && !bitbox.BundledFirmwareVersion().AtLeast(latest.Version) {
+		return latest.Version, latest
+	}
+	return bitbox.BundledFirmwareVersion(), nil
+}
+
+func (handlers *Handlers) firmwareStatus() *firmwareStatus {
+	status := &firmwareStatus{
+		Installed:      handlers.bitbox.FirmwareVersion(),
+		Bundled:        bitbox.BundledFirmwareVersion(),
+		Latest:         handlers.firmwareChannel.Latest(),
+		ChannelEnabled: handlers.firmwareChannel.Enabled(),
+	}
+	if status.Latest != nil {
+		status.Downloaded = handlers.firmwareChannel.Downloaded(status.Latest)
+	}
+	version, _ := handlers.upgradeVersion()
+	status.UpgradeAvailable = status.Installed != nil && !status.Installed.AtLeast(version)
+	return status
+}
+
+func (handlers *Handlers) getFirmwareHandler(_ *http.Request) (interface{}, error) {
+	return handlers.firmwareStatus(), nil
+}
+
+// postFirmwareCheckHandler fetches the latest firmware releases.
+func (handlers *Handlers) postFirmwareCheckHandler(r *http.Request) (interface{}, error) {
+	if _, err := handlers.firmwareChannel.Refresh(); err != nil {
+		requestid.Log(r, handlers.log).WithError(err).Error("Checking for firmware releases failed")
+		return apierror.Wrap(apierror.CodeFirmwareDownloadFailed, err).Response(), nil
+	}
+	return handlers.firmwareStatus(), nil
+}
+
+// postFirmwareUpgradeHandler downloads the newest firmware if it is not the bundled one, and
+// unlocks the bootloader to install it. The firmware is flashed with /bootloader/upgrade-firmware
+// once the device was replugged in bootloader mode.
+func (handlers *Handlers) postFirmwareUpgradeHandler(r *http.Request) (interface{}, error) {
+	version, release := handlers.upgradeVersion()
+	// Check before downloading, UnlockBootloaderForFirmware() refuses to downgrade as well.
+	if installed := handlers.bitbox.FirmwareVersion(); installed != nil && !version.AtLeast(installed) {
+		return apierror.Wrap(apierror.CodeFirmwareDowngrade, &bitbox.FirmwareDowngradeError{
+			Installed: installed, New: version}).Response(), nil
+	}
+	if release != nil {
+		if err := handlers.firmwareChannel.Download(release); err != nil {
+			requestid.Log(r, handlers.log).WithError(err).Error("Downloading the firmware failed")
+			return apierror.Wrap(apierror.CodeFirmwareDownloadFailed, err).Response(), nil
+		}
+	}
+	unlocked, err := handlers.bitbox.UnlockBootloaderForFirmware(version)
+	if _, ok := errp.Cause(err).(*bitbox.FirmwareDowngradeError); ok {
+		return apierror.Wrap(apierror.CodeFirmwareDowngrade, err).Response(), nil
+	}
+	if err != nil {
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
+	}
+	return map[string]interface{}{"success": true, "unlocked": unlocked}, nil
+}
+
+// bootloaderInfo describes the bootloader and the installed firmware of a device.
+type bootloaderInfo struct {
+	// Bootloader is true if the device is in bootloader mode. The other fields are only set then.
+	Bootloader bool           `json:"bootloader"`
+	Version    *semver.SemVer `json:"version,omitempty"`
+	// FirmwareHash is the hex encoded hash of the installed firmware.
+	FirmwareHash   string                    `json:"firmwareHash,omitempty"`
+	FirmwareStatus bitbox.FirmwareHashStatus `json:"firmwareStatus,omitempty"`
+	// FirmwareRelease is the release matching the firmware hash, if the firmware is genuine.
+	FirmwareRelease *bitbox.FirmwareRelease `json:"firmwareRelease,omitempty"`
+}
+
+// getBootloaderInfoHandler returns the bootloader version and checks the hash of the installed
+// firmware against the signed release metadata, so that the user can see whether the firmware is
+// genuine before flashing or running it.
+func (handlers *Handlers) getBootloaderInfoHandler(r *http.Request) (interface{}, error) {
+	version, err := handlers.bitbox.BootloaderVersion()
+	if err != nil {
+		return &bootloaderInfo{Bootloader: false}, nil
+	}
+	hash, err := handlers.bitbox.BootloaderFirmwareHash()
+	if err != nil {
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
+	}
+	status, release := handlers.firmwareChannel.CheckFirmwareHash(
+		hash, handlers.bitbox.FirmwareVersion())
+	return &bootloaderInfo{
+		Bootloader:      true,
+		Version:         version,
+		FirmwareHash:    hex.EncodeToString(hash),
+		FirmwareStatus:  status,
+		FirmwareRelease: release,
+	}, nil
+}
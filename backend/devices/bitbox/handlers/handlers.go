@@ -23,11 +23,17 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/queue"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers/requestid"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
 )
 
 // Bitbox models the API of a Bitbox.
@@ -35,6 +41,8 @@ type Bitbox interface {
 	device.Interface
 	Status() bitbox.Status
 	BootloaderStatus() (*bitbox.BootloaderStatus, error)
+	BootloaderVersion() (*semver.SemVer, error)
+	BootloaderFirmwareHash() ([]byte, error)
 	DeviceInfo() (*bitbox.DeviceInfo, error)
 	SetPassword(string) error
 	ChangePassword(string, string) error
@@ -47,39 +55,59 @@ type Bitbox interface {
 	XPub(path string) (*hdkeychain.ExtendedKey, error)
 	Sign(tx *maketx.TxProposal, hashes [][]byte, keyPaths []string) ([]btcec.Signature, error)
 	UnlockBootloader() (bool, error)
+	UnlockBootloaderForFirmware(*semver.SemVer) (bool, error)
+	FirmwareVersion() *semver.SemVer
+	PendingFirmwareVersion() *semver.SemVer
 	LockBootloader() error
 	EraseBackup(string) error
 	RestoreBackup(string, string) (bool, error)
 	CreateBackup(string, string) error
 	BackupList() ([]map[string]string, error)
-	BootloaderUpgradeFirmware([]byte) error
+	BootloaderUpgradeFirmware([]byte, *semver.SemVer) error
+	BootloaderRecover() error
+	BootloaderRecoverWithFirmware([]byte, *semver.SemVer) error
 	DisplayAddress(keyPath string, typ string) error
 	ECDHPKhash(string) (interface{}, error)
 	ECDHPK(string) (interface{}, error)
 	ECDHchallenge() error
 	StartPairing() (*relay.Channel, error)
 	Paired() bool
+	PairingIdentityMismatch() bool
+	NoiseIdentityMismatch() bool
+	AcceptNoiseIdentity() error
 	Lock() (bool, error)
 	CheckBackup(string, string) (bool, error)
+	Health() *health.Stats
+	CommandQueue() *queue.Status
+	CancelRequests()
 }
 
 // Handlers provides a web API to the Bitbox.
 type Handlers struct {
-	bitbox Bitbox
-	log    *logrus.Entry
+	bitbox          Bitbox
+	firmwareChannel *bitbox.FirmwareChannel
+	log             *logrus.Entry
 }
 
-// NewHandlers creates a new Handlers instance.
+// NewHandlers creates a new Handlers instance. The firmware channel provides the firmware releases
+// besides the bundled firmware.
 func NewHandlers(
 	handleFunc func(string, func(*http.Request) (interface{}, error)) *mux.Route,
+	firmwareChannel *bitbox.FirmwareChannel,
 	log *logrus.Entry,
 ) *Handlers {
-	handlers := &Handlers{log: log}
+	handlers := &Handlers{firmwareChannel: firmwareChannel, log: log}
 
 	handleFunc("/status", handlers.getDeviceStatusHandler).Methods("GET")
 	handleFunc("/bootloader-status", handlers.getBootloaderStatusHandler).Methods("GET")
+	handleFunc("/bootloader/info", handlers.getBootloaderInfoHandler).Methods("GET")
 	handleFunc("/info", handlers.getDeviceInfoHandler).Methods("GET")
+	handleFunc("/health", handlers.getHealthHandler).Methods("GET")
+	handleFunc("/command-queue", handlers.getCommandQueueHandler).Methods("GET")
 	handleFunc("/paired", handlers.getPairedHandler).Methods("GET")
+	handleFunc("/pairing/identity-mismatch",
+		handlers.getPairingIdentityMismatchHandler).Methods("GET")
+	handleFunc("/noise/identity-mismatch", handlers.getNoiseIdentityMismatchHandler).Methods("GET")
 	handleFunc("/bundled-firmware-version", handlers.getBundledFirmwareVersionHandler).Methods("GET")
 	handleFunc("/set-password", handlers.postSetPasswordHandler).Methods("POST")
 	handleFunc("/change-password", handlers.postChangePasswordHandler).Methods("POST")
@@ -97,9 +125,15 @@ func NewHandlers(
 	handleFunc("/backups/create", handlers.postBackupsCreateHandler).Methods("POST")
 	handleFunc("/backups/check", handlers.postBackupsCheckHandler).Methods("POST")
 	handleFunc("/pairing/start", handlers.postPairingStartHandler).Methods("POST")
+	handleFunc("/noise/identity/accept", handlers.postNoiseIdentityAcceptHandler).Methods("POST")
 	handleFunc("/bootloader/upgrade-firmware",
 		handlers.postBootloaderUpgradeFirmwareHandler).Methods("POST")
+	handleFunc("/bootloader/recover", handlers.postBootloaderRecoverHandler).Methods("POST")
+	handleFunc("/firmware", handlers.getFirmwareHandler).Methods("GET")
+	handleFunc("/firmware/check", handlers.postFirmwareCheckHandler).Methods("POST")
+	handleFunc("/firmware/upgrade", handlers.postFirmwareUpgradeHandler).Methods("POST")
 	handleFunc("/lock", handlers.postLockHandler).Methods("POST")
+	handleFunc("/cancel-requests", handlers.postCancelRequestsHandler).Methods("POST")
 	return handlers
 }
 
@@ -123,9 +157,9 @@ func (handlers *Handlers) postSetPasswordHandler(r *http.Request) (interface{},
 	}
 	password := jsonBody["password"]
 	if err := handlers.bitbox.SetPassword(password); err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
-	handlers.log.Debug("Set password on device")
+	requestid.Log(r, handlers.log).Debug("Set password on device")
 	return map[string]interface{}{"success": true}, nil
 }
 
@@ -137,9 +171,9 @@ func (handlers *Handlers) postChangePasswordHandler(r *http.Request) (interface{
 	newPIN := jsonBody["newPIN"]
 	oldPIN := jsonBody["oldPIN"]
 	if err := handlers.bitbox.ChangePassword(oldPIN, newPIN); err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
-	handlers.log.Debug("Change password on device")
+	requestid.Log(r, handlers.log).Debug("Change password on device")
 	return map[string]interface{}{"success": true}, nil
 }
 
@@ -152,18 +186,18 @@ func (handlers *Handlers) postSetHiddenPasswordHandler(r *http.Request) (interfa
 	backupPassword := jsonBody["backupPassword"]
 	success, err := handlers.bitbox.SetHiddenPassword(pin, backupPassword)
 	if err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
 	return map[string]interface{}{"success": true, "didCreate": success}, nil
 }
 
-func (handlers *Handlers) getBackupListHandler(_ *http.Request) (interface{}, error) {
+func (handlers *Handlers) getBackupListHandler(r *http.Request) (interface{}, error) {
 	backupList, err := handlers.bitbox.BackupList()
 	sdCardInserted := !bitbox.IsErrorSDCard(err)
 	if sdCardInserted && err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
-	handlers.log.WithFields(logrus.Fields{"sdCardInserted": sdCardInserted, "backupList": backupList}).
+	requestid.Log(r, handlers.log).WithFields(logrus.Fields{"sdCardInserted": sdCardInserted, "backupList": backupList}).
 		Debug("Get backup list")
 	return map[string]interface{}{
 		"success":        true,
@@ -184,27 +218,62 @@ func (handlers *Handlers) getDeviceInfoHandler(_ *http.Request) (interface{}, er
 	return handlers.bitbox.DeviceInfo()
 }
 
+func (handlers *Handlers) getHealthHandler(_ *http.Request) (interface{}, error) {
+	return handlers.bitbox.Health(), nil
+}
+
+func (handlers *Handlers) getCommandQueueHandler(_ *http.Request) (interface{}, error) {
+	return handlers.bitbox.CommandQueue(), nil
+}
+
 func (handlers *Handlers) getPairedHandler(_ *http.Request) (interface{}, error) {
 	return handlers.bitbox.Paired(), nil
 }
 
+func (handlers *Handlers) getPairingIdentityMismatchHandler(_ *http.Request) (interface{}, error) {
+	return handlers.bitbox.PairingIdentityMismatch(), nil
+}
+
+func (handlers *Handlers) getNoiseIdentityMismatchHandler(_ *http.Request) (interface{}, error) {
+	return handlers.bitbox.NoiseIdentityMismatch(), nil
+}
+
 func (handlers *Handlers) getBundledFirmwareVersionHandler(_ *http.Request) (interface{}, error) {
 	return "v" + bitbox.BundledFirmwareVersion().String(), nil
 }
 
+// dbbErrorCodes maps the error codes of the device to API error codes.
+var dbbErrorCodes = map[float64]apierror.Code{
+	bitbox.ErrTouchAbort:   apierror.CodeAborted,
+	bitbox.ErrTouchTimeout: apierror.CodeAborted,
+	bitbox.ErrSDCard:       apierror.CodeSDCard,
+	bitbox.ErrInitializing: apierror.CodeDeviceBusy,
+}
+
 func maybeDBBErr(err error, log *logrus.Entry) map[string]interface{} {
-	result := map[string]interface{}{"success": false, "errorMessage": err.Error()}
+	if _, ok := errp.Cause(err).(*usb.TimeoutErr); ok {
+		log.WithError(err).Warning("Bitbox is not responding")
+		return apierror.Wrap(apierror.CodeDeviceNotResponding, err).Response()
+	}
 	if _, ok := errp.Cause(err).(bitbox.PasswordValidationError); ok {
-		const errWrongPW = 102
-		result["code"] = errWrongPW
+		// The legacy code the frontend checks for invalid passwords.
+		const errInvalidPW = 102
+		result := apierror.Wrap(apierror.CodePasswordPolicy, err).Response()
+		result["code"] = errInvalidPW
 		return result
 	}
 
 	if dbbErr, ok := errp.Cause(err).(*bitbox.Error); ok {
+		code, ok := dbbErrorCodes[dbbErr.Code]
+		if !ok {
+			code = apierror.CodeDevice
+		}
+		result := apierror.Wrap(code, err).Response()
 		result["code"] = dbbErr.Code
 		log.WithField("bitbox-error", dbbErr.Code).Warning("Received an error from Bitbox")
+		return result
 	}
-	return result
+	return apierror.FromError(err).Response()
 }
 
 func (handlers *Handlers) postLoginHandler(r *http.Request) (interface{}, error) {
@@ -213,10 +282,10 @@ func (handlers *Handlers) postLoginHandler(r *http.Request) (interface{}, error)
 		return nil, errp.WithStack(err)
 	}
 	password := jsonBody["password"]
-	handlers.log.Debug("Login")
+	requestid.Log(r, handlers.log).Debug("Login")
 	needsLongTouch, remainingAttempts, err := handlers.bitbox.Login(password)
 	if err != nil {
-		result := maybeDBBErr(err, handlers.log)
+		result := maybeDBBErr(err, requestid.Log(r, handlers.log))
 		result["remainingAttempts"] = remainingAttempts
 		result["needsLongTouch"] = needsLongTouch
 		return result, nil
@@ -232,11 +301,11 @@ func (handlers *Handlers) postCreateWalletHandler(r *http.Request) (interface{},
 	walletName := jsonBody["walletName"]
 	backupPassword := jsonBody["backupPassword"]
 
-	handlers.log.WithField("walletName", walletName).Debug("Create wallet")
+	requestid.Log(r, handlers.log).WithField("walletName", walletName).Debug("Create wallet")
 	if err := handlers.bitbox.CreateWallet(walletName, backupPassword); err != nil {
-		handlers.log.WithFields(logrus.Fields{"walletName": walletName, "error": err}).
+		requestid.Log(r, handlers.log).WithFields(logrus.Fields{"walletName": walletName, "error": err}).
 			Error("Failed to create wallet")
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
 	return map[string]interface{}{"success": true}, nil
 }
@@ -246,7 +315,11 @@ func (handlers *Handlers) postLockBootloaderHandler(_ *http.Request) (interface{
 }
 
 func (handlers *Handlers) postUnlockBootloaderHandler(_ *http.Request) (interface{}, error) {
-	return handlers.bitbox.UnlockBootloader()
+	unlocked, err := handlers.bitbox.UnlockBootloader()
+	if _, ok := err.(*bitbox.FirmwareDowngradeError); ok {
+		return apierror.Wrap(apierror.CodeFirmwareDowngrade, err).Response(), nil
+	}
+	return unlocked, err
 }
 
 func (handlers *Handlers) postBackupsEraseHandler(r *http.Request) (interface{}, error) {
@@ -255,7 +328,7 @@ func (handlers *Handlers) postBackupsEraseHandler(r *http.Request) (interface{},
 		return nil, errp.WithStack(err)
 	}
 	filename := jsonBody["filename"]
-	handlers.log.WithField("filename", filename).Debug("Erase backup")
+	requestid.Log(r, handlers.log).WithField("filename", filename).Debug("Erase backup")
 	return nil, handlers.bitbox.EraseBackup(filename)
 }
 
@@ -265,12 +338,14 @@ func (handlers *Handlers) postBackupsRestoreHandler(r *http.Request) (interface{
 		return nil, errp.WithStack(err)
 	}
 	filename := jsonBody["filename"]
-	handlers.log.WithField("filename", filename).Debug("Restore backup")
+	requestid.Log(r, handlers.log).WithField("filename", filename).Debug("Restore backup")
 	didRestore, err := handlers.bitbox.RestoreBackup(jsonBody["password"], filename)
 	if err != nil {
-		handlers.log.WithFields(logrus.Fields{"walletName": filename, "error": err}).
+		requestid.Log(r, handlers.log).WithFields(logrus.Fields{"walletName": filename, "error": err}).
 			Error("Failed to restore wallet")
-		return map[string]interface{}{"didRestore": false, "errorMessage": err.Error()}, nil
+		result := maybeDBBErr(err, requestid.Log(r, handlers.log))
+		result["didRestore"] = false
+		return result, nil
 	}
 	return map[string]interface{}{"didRestore": didRestore}, nil
 }
@@ -281,10 +356,10 @@ func (handlers *Handlers) postBackupsCheckHandler(r *http.Request) (interface{},
 		return nil, errp.WithStack(err)
 	}
 	filename := jsonBody["filename"]
-	handlers.log.WithField("filename", filename).Debug("Check backup")
+	requestid.Log(r, handlers.log).WithField("filename", filename).Debug("Check backup")
 	matches, err := handlers.bitbox.CheckBackup(jsonBody["password"], filename)
 	if err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
 	return map[string]interface{}{"success": true, "matches": matches}, nil
 }
@@ -296,9 +371,9 @@ func (handlers *Handlers) postBackupsCreateHandler(r *http.Request) (interface{}
 	}
 	backupName := jsonBody["backupName"]
 	recoveryPassword := jsonBody["recoveryPassword"]
-	handlers.log.WithField("backupName", backupName).Debug("Create backup")
+	requestid.Log(r, handlers.log).WithField("backupName", backupName).Debug("Create backup")
 	if err := handlers.bitbox.CreateBackup(backupName, recoveryPassword); err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
 	return map[string]interface{}{"success": true}, nil
 }
@@ -307,31 +382,69 @@ func (handlers *Handlers) postPairingStartHandler(r *http.Request) (interface{},
 	return handlers.bitbox.StartPairing()
 }
 
-func (handlers *Handlers) postBlinkDeviceHandler(_ *http.Request) (interface{}, error) {
-	handlers.log.Debug("Blink")
+func (handlers *Handlers) postNoiseIdentityAcceptHandler(r *http.Request) (interface{}, error) {
+	requestid.Log(r, handlers.log).Info("Accept the Noise identity of the device")
+	return nil, handlers.bitbox.AcceptNoiseIdentity()
+}
+
+func (handlers *Handlers) postBlinkDeviceHandler(r *http.Request) (interface{}, error) {
+	requestid.Log(r, handlers.log).Debug("Blink")
 	return nil, handlers.bitbox.Blink()
 }
 
-func (handlers *Handlers) postGetRandomNumberHandler(_ *http.Request) (interface{}, error) {
-	handlers.log.Debug("Random Number")
+func (handlers *Handlers) postCancelRequestsHandler(r *http.Request) (interface{}, error) {
+	requestid.Log(r, handlers.log).Debug("Cancel requests")
+	handlers.bitbox.CancelRequests()
+	return nil, nil
+}
+
+func (handlers *Handlers) postGetRandomNumberHandler(r *http.Request) (interface{}, error) {
+	requestid.Log(r, handlers.log).Debug("Random Number")
 	return handlers.bitbox.Random("true")
 }
 
 func (handlers *Handlers) postResetDeviceHandler(r *http.Request) (interface{}, error) {
-	handlers.log.Debug("Reset")
+	requestid.Log(r, handlers.log).Debug("Reset")
 	jsonBody := map[string]string{}
 	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
 		return nil, errp.WithStack(err)
 	}
 	didReset, err := handlers.bitbox.Reset(jsonBody["pin"])
 	if err != nil {
-		return maybeDBBErr(err, handlers.log), nil
+		return maybeDBBErr(err, requestid.Log(r, handlers.log)), nil
 	}
 	return map[string]interface{}{"didReset": didReset}, nil
 }
 
+// postBootloaderUpgradeFirmwareHandler flashes the firmware chosen when the bootloader was
+// unlocked, which is the bundled firmware unless a downloaded release was chosen.
 func (handlers *Handlers) postBootloaderUpgradeFirmwareHandler(_ *http.Request) (interface{}, error) {
-	return nil, handlers.bitbox.BootloaderUpgradeFirmware(bitbox.BundledFirmware())
+	version := handlers.bitbox.PendingFirmwareVersion()
+	signedFirmware, err := handlers.firmwareChannel.Firmware(version)
+	if err != nil {
+		return nil, err
+	}
+	return nil, handlers.bitbox.BootloaderUpgradeFirmware(signedFirmware, version)
+}
+
+// postBootloaderRecoverHandler re-flashes the firmware of the interrupted upgrade if it is still
+// available, and the bundled firmware otherwise.
+func (handlers *Handlers) postBootloaderRecoverHandler(_ *http.Request) (interface{}, error) {
+	status, err := handlers.bitbox.BootloaderStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status.RecoveryVersion != "" {
+		version, err := semver.NewSemVerFromString(status.RecoveryVersion)
+		if err == nil {
+			signedFirmware, err := handlers.firmwareChannel.Firmware(version)
+			if err == nil {
+				return nil, handlers.bitbox.BootloaderRecoverWithFirmware(signedFirmware, version)
+			}
+			handlers.log.WithError(err).Warning("Recovering with the bundled firmware instead")
+		}
+	}
+	return nil, handlers.bitbox.BootloaderRecover()
 }
 
 func (handlers *Handlers) postLockHandler(_ *http.Request) (interface{}, error) {
@@ -17,10 +17,12 @@ package bitbox
 import (
 	"fmt"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/sirupsen/logrus"
@@ -66,23 +68,59 @@ func (keystore *keystore) OutputAddress(
 	return keystore.dbb.DisplayAddress(keyPath.Encode(), fmt.Sprintf("%s-%s", coin.Name(), string(scriptType)))
 }
 
+// OutputRootFingerprint implements keystore.RootFingerprintOutput. The paired mobile receives the
+// encrypted master public key and shows its fingerprint instead of an address.
+func (keystore *keystore) OutputRootFingerprint() error {
+	if !keystore.HasSecureOutput() {
+		panic("HasSecureOutput must be true")
+	}
+	return keystore.dbb.DisplayAddress(
+		signing.NewEmptyAbsoluteKeypath().Encode(), relay.OutputTypeRootFingerprint)
+}
+
 // ExtendedPublicKey implements keystore.Keystore.
 func (keystore *keystore) ExtendedPublicKey(
 	keyPath signing.AbsoluteKeypath) (*hdkeychain.ExtendedKey, error) {
 	return keystore.dbb.XPub(keyPath.Encode())
 }
 
-// SignTransaction implements keystore.Keystore.
-func (keystore *keystore) SignTransaction(proposedTx coin.ProposedTransaction) error {
-	btcProposedTx, ok := proposedTx.(*btc.ProposedTransaction)
-	if !ok {
-		panic("only btc")
+// ExtendedPublicKeys implements keystore.ExtendedPublicKeysBatcher.
+func (keystore *keystore) ExtendedPublicKeys(
+	keyPaths []signing.AbsoluteKeypath) ([]*hdkeychain.ExtendedKey, error) {
+	paths := make([]string, len(keyPaths))
+	for i, keyPath := range keyPaths {
+		paths[i] = keyPath.Encode()
 	}
-	keystore.log.Info("Sign transaction")
+	return keystore.dbb.XPubs(paths)
+}
+
+// SignHash implements keystore.Keystore.
+func (keystore *keystore) SignHash(keyPath signing.AbsoluteKeypath, hash []byte) (*btcec.Signature, error) {
+	keystore.log.Info("Sign hash")
+	// Without a tx, the paired mobile has nothing to verify, so the user only confirms by touch.
+	signatures, err := keystore.dbb.Sign(nil, [][]byte{hash}, []string{keyPath.Encode()})
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to sign hash")
+	}
+	if len(signatures) != 1 {
+		return nil, errp.New("Unexpected number of signatures")
+	}
+	return &signatures[0], nil
+}
+
+// signRequest computes the signature hashes of the inputs of the transaction. It returns the
+// request for the device and the indices of the inputs the signatures are for.
+func (keystore *keystore) signRequest(btcProposedTx *btc.ProposedTransaction) (*SignRequest, []int, error) {
 	signatureHashes := [][]byte{}
 	keyPaths := []string{}
 	transaction := btcProposedTx.TXProposal.Transaction
+	// signedInputs are the indices of the inputs the signatures are for.
+	signedInputs := []int{}
 	for index, txIn := range transaction.TxIn {
+		if _, ok := btcProposedTx.SkipInputs[index]; ok {
+			continue
+		}
+		signedInputs = append(signedInputs, index)
 		spentOutput, ok := btcProposedTx.PreviousOutputs[txIn.PreviousOutPoint]
 		if !ok {
 			keystore.log.Panic("There needs to be exactly one output being spent per input!")
@@ -95,7 +133,7 @@ func (keystore *keystore) SignTransaction(proposedTx coin.ProposedTransaction) e
 			signatureHash, err = txscript.CalcWitnessSigHash(subScript, btcProposedTx.SigHashes,
 				txscript.SigHashAll, transaction, index, spentOutput.Value)
 			if err != nil {
-				return errp.Wrap(err, "Failed to calculate SegWit signature hash")
+				return nil, nil, errp.Wrap(err, "Failed to calculate SegWit signature hash")
 			}
 			keystore.log.Debug("Calculated segwit signature hash")
 		} else {
@@ -103,7 +141,7 @@ func (keystore *keystore) SignTransaction(proposedTx coin.ProposedTransaction) e
 			signatureHash, err = txscript.CalcSignatureHash(
 				subScript, txscript.SigHashAll, transaction, index)
 			if err != nil {
-				return errp.Wrap(err, "Failed to calculate legacy signature hash")
+				return nil, nil, errp.Wrap(err, "Failed to calculate legacy signature hash")
 			}
 			keystore.log.Debug("Calculated legacy signature hash")
 		}
@@ -114,17 +152,68 @@ func (keystore *keystore) SignTransaction(proposedTx coin.ProposedTransaction) e
 		// Special serialization of the unsigned transaction for the mobile verification app.
 		txIn.SignatureScript = subScript
 	}
+	return &SignRequest{
+		TxProposal:      btcProposedTx.TXProposal,
+		SignatureHashes: signatureHashes,
+		KeyPaths:        keyPaths,
+	}, signedInputs, nil
+}
 
-	signatures, err := keystore.dbb.Sign(btcProposedTx.TXProposal, signatureHashes, keyPaths)
-	if err != nil {
-		return errp.WithMessage(err, "Failed to sign signature hash")
-	}
-	if len(signatures) != len(transaction.TxIn) {
+// applySignatures stores the signatures of the signed inputs in the proposed transaction.
+func (keystore *keystore) applySignatures(
+	btcProposedTx *btc.ProposedTransaction, signedInputs []int, signatures []btcec.Signature) {
+	if len(signatures) != len(signedInputs) {
 		panic("number of signatures doesn't match number of inputs")
 	}
 	for i, signature := range signatures {
 		signature := signature
-		btcProposedTx.Signatures[i][keystore.CosignerIndex()] = &signature
+		btcProposedTx.Signatures[signedInputs[i]][keystore.CosignerIndex()] = &signature
+	}
+}
+
+// SignTransaction implements keystore.Keystore.
+func (keystore *keystore) SignTransaction(proposedTx coin.ProposedTransaction) error {
+	btcProposedTx, ok := proposedTx.(*btc.ProposedTransaction)
+	if !ok {
+		panic("only btc")
+	}
+	keystore.log.Info("Sign transaction")
+	request, signedInputs, err := keystore.signRequest(btcProposedTx)
+	if err != nil {
+		return err
+	}
+	signatures, err := keystore.dbb.Sign(request.TxProposal, request.SignatureHashes, request.KeyPaths)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to sign signature hash")
+	}
+	keystore.applySignatures(btcProposedTx, signedInputs, signatures)
+	return nil
+}
+
+// SignTransactions implements keystore.BatchSigner.
+func (keystore *keystore) SignTransactions(proposedTxs []coin.ProposedTransaction) error {
+	keystore.log.WithField("transactions", len(proposedTxs)).Info("Sign transactions")
+	btcProposedTxs := make([]*btc.ProposedTransaction, len(proposedTxs))
+	requests := make([]*SignRequest, len(proposedTxs))
+	signedInputs := make([][]int, len(proposedTxs))
+	for index, proposedTx := range proposedTxs {
+		btcProposedTx, ok := proposedTx.(*btc.ProposedTransaction)
+		if !ok {
+			panic("only btc")
+		}
+		btcProposedTxs[index] = btcProposedTx
+		var err error
+		requests[index], signedInputs[index], err = keystore.signRequest(btcProposedTx)
+		if err != nil {
+			return err
+		}
+	}
+	signatures, err := keystore.dbb.SignBatch(requests)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to sign the transactions")
+	}
+	for index, transactionSignatures := range signatures {
+		keystore.applySignatures(btcProposedTxs[index], signedInputs[index], transactionSignatures)
 	}
 	return nil
 }
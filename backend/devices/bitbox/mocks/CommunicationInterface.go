@@ -1,7 +1,10 @@
 // Code generated by mockery v1.0.0. DO NOT EDIT.
 package mocks
 
+import context "context"
+import health "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
 import mock "github.com/stretchr/testify/mock"
+import queue "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/queue"
 
 // CommunicationInterface is an autogenerated mock type for the CommunicationInterface type
 type CommunicationInterface struct {
@@ -13,13 +16,45 @@ func (_m *CommunicationInterface) Close() {
 	_m.Called()
 }
 
-// SendBootloader provides a mock function with given fields: _a0
-func (_m *CommunicationInterface) SendBootloader(_a0 []byte) ([]byte, error) {
-	ret := _m.Called(_a0)
+// CommandQueue provides a mock function with given fields:
+func (_m *CommunicationInterface) CommandQueue() *queue.Status {
+	ret := _m.Called()
+
+	var r0 *queue.Status
+	if rf, ok := ret.Get(0).(func() *queue.Status); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*queue.Status)
+		}
+	}
+
+	return r0
+}
+
+// Health provides a mock function with given fields:
+func (_m *CommunicationInterface) Health() *health.Stats {
+	ret := _m.Called()
+
+	var r0 *health.Stats
+	if rf, ok := ret.Get(0).(func() *health.Stats); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*health.Stats)
+		}
+	}
+
+	return r0
+}
+
+// SendBootloader provides a mock function with given fields: _a0, _a1
+func (_m *CommunicationInterface) SendBootloader(_a0 context.Context, _a1 []byte) ([]byte, error) {
+	ret := _m.Called(_a0, _a1)
 
 	var r0 []byte
-	if rf, ok := ret.Get(0).(func([]byte) []byte); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, []byte) []byte); ok {
+		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]byte)
@@ -27,8 +62,8 @@ func (_m *CommunicationInterface) SendBootloader(_a0 []byte) ([]byte, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func([]byte) error); ok {
-		r1 = rf(_a0)
+	if rf, ok := ret.Get(1).(func(context.Context, []byte) error); ok {
+		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -36,13 +71,36 @@ func (_m *CommunicationInterface) SendBootloader(_a0 []byte) ([]byte, error) {
 	return r0, r1
 }
 
-// SendEncrypt provides a mock function with given fields: _a0, _a1
-func (_m *CommunicationInterface) SendEncrypt(_a0 string, _a1 string) (map[string]interface{}, error) {
-	ret := _m.Called(_a0, _a1)
+// SendBootloaderWithProgress provides a mock function with given fields: _a0, _a1, _a2
+func (_m *CommunicationInterface) SendBootloaderWithProgress(_a0 context.Context, _a1 []byte, _a2 func(int, int)) ([]byte, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func(context.Context, []byte, func(int, int)) []byte); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []byte, func(int, int)) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SendEncrypt provides a mock function with given fields: _a0, _a1, _a2
+func (_m *CommunicationInterface) SendEncrypt(_a0 context.Context, _a1 string, _a2 string) (map[string]interface{}, error) {
+	ret := _m.Called(_a0, _a1, _a2)
 
 	var r0 map[string]interface{}
-	if rf, ok := ret.Get(0).(func(string, string) map[string]interface{}); ok {
-		r0 = rf(_a0, _a1)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) map[string]interface{}); ok {
+		r0 = rf(_a0, _a1, _a2)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]interface{})
@@ -50,8 +108,8 @@ func (_m *CommunicationInterface) SendEncrypt(_a0 string, _a1 string) (map[strin
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(_a0, _a1)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -59,13 +117,36 @@ func (_m *CommunicationInterface) SendEncrypt(_a0 string, _a1 string) (map[strin
 	return r0, r1
 }
 
-// SendPlain provides a mock function with given fields: _a0
-func (_m *CommunicationInterface) SendPlain(_a0 string) (map[string]interface{}, error) {
-	ret := _m.Called(_a0)
+// SendEncryptBatch provides a mock function with given fields: _a0, _a1, _a2
+func (_m *CommunicationInterface) SendEncryptBatch(_a0 context.Context, _a1 []string, _a2 string) ([]map[string]interface{}, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 []map[string]interface{}
+	if rf, ok := ret.Get(0).(func(context.Context, []string, string) []map[string]interface{}); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]map[string]interface{})
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SendPlain provides a mock function with given fields: _a0, _a1
+func (_m *CommunicationInterface) SendPlain(_a0 context.Context, _a1 string) (map[string]interface{}, error) {
+	ret := _m.Called(_a0, _a1)
 
 	var r0 map[string]interface{}
-	if rf, ok := ret.Get(0).(func(string) map[string]interface{}); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, string) map[string]interface{}); ok {
+		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]interface{})
@@ -73,8 +154,8 @@ func (_m *CommunicationInterface) SendPlain(_a0 string) (map[string]interface{},
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(_a0)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,160 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"encoding/hex"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+const noiseIdentitiesFileName = "noise-identities.json"
+
+// NoiseChannel is implemented by communications which can encrypt the commands with a Noise
+// channel, see usb.Communication.
+type NoiseChannel interface {
+	// NoiseEnabled returns true if the commands go through a Noise channel if the device supports
+	// it.
+	NoiseEnabled() bool
+	// NoiseVersion returns the negotiated protocol version, and the static key of the device if the
+	// Noise channel is established.
+	NoiseVersion() (int, []byte)
+	// PinNoise makes the device identify with the given static key in all Noise handshakes from
+	// now on, and refuses the legacy encryption.
+	PinNoise(remoteStatic []byte)
+}
+
+// noiseIdentities maps device fingerprints to the hex encoded static key with which the device
+// identified in the Noise handshake. The fingerprint is the device ID reported in the device info.
+type noiseIdentities map[string]string
+
+func (dbb *Device) noiseIdentitiesFile() *config.File {
+	return config.NewFile(dbb.channelConfigDir, noiseIdentitiesFileName)
+}
+
+func (dbb *Device) readNoiseIdentities() noiseIdentities {
+	identities := noiseIdentities{}
+	file := dbb.noiseIdentitiesFile()
+	if !file.Exists() {
+		return identities
+	}
+	if err := file.ReadJSON(&identities); err != nil {
+		dbb.log.WithError(err).Error("Failed to read the Noise identities")
+		return noiseIdentities{}
+	}
+	return identities
+}
+
+// storeNoiseIdentity records the static key with which the device with the given fingerprint
+// identified in the Noise handshake. A nil key removes the record.
+func (dbb *Device) storeNoiseIdentity(fingerprint string, static []byte) error {
+	identities := dbb.readNoiseIdentities()
+	if static == nil {
+		delete(identities, fingerprint)
+	} else {
+		identities[fingerprint] = hex.EncodeToString(static)
+	}
+	return dbb.noiseIdentitiesFile().WriteJSON(identities)
+}
+
+// noiseStatic returns the static key with which the device identified in the Noise handshake, or
+// nil if the commands are only encrypted with the password. enabled is false if the communication
+// does not use the Noise channel at all.
+func (dbb *Device) noiseStatic() (static []byte, enabled bool) {
+	channel, ok := dbb.transport().(NoiseChannel)
+	if !ok || !channel.NoiseEnabled() {
+		return nil, false
+	}
+	_, static = channel.NoiseVersion()
+	return static, true
+}
+
+// verifyNoiseIdentity checks that the device with the given fingerprint identifies with the same
+// static key in the Noise handshake as on previous connections. The key is stored the first time
+// the device establishes the Noise channel. If the device presents a different key, or it falls
+// back to the legacy encryption although it established the Noise channel before, someone on the
+// USB path could impersonate the device. In that case, EventNoiseIdentityMismatch is fired until
+// the user accepts the new identity, see AcceptNoiseIdentity(). Nothing is checked if the Noise
+// channel is disabled.
+func (dbb *Device) verifyNoiseIdentity(fingerprint string) {
+	static, enabled := dbb.noiseStatic()
+	if !enabled || fingerprint == "" {
+		return
+	}
+	known, ok := dbb.readNoiseIdentities()[fingerprint]
+	switch {
+	case ok && known == hex.EncodeToString(static):
+		return
+	case !ok:
+		if static != nil {
+			dbb.log.Info("Storing the Noise identity of the device")
+			if err := dbb.storeNoiseIdentity(fingerprint, static); err != nil {
+				dbb.log.WithError(err).Error("Failed to store the Noise identity")
+			}
+		}
+		return
+	}
+	dbb.log.WithFields(logrus.Fields{"device": fingerprint, "legacy": static == nil}).Warning(
+		"The device presented a different Noise identity than before")
+	dbb.mu.Lock()
+	dbb.noiseIdentityMismatch = true
+	dbb.mu.Unlock()
+	dbb.fireEvent(EventNoiseIdentityMismatch, nil)
+}
+
+// pinNoiseIdentity makes the reconnected device identify with the same static key in the Noise
+// handshake as before it was disconnected, see Resume().
+func pinNoiseIdentity(previous, communication CommunicationInterface) {
+	previousChannel, ok := previous.(NoiseChannel)
+	if !ok {
+		return
+	}
+	channel, ok := communication.(NoiseChannel)
+	if !ok {
+		return
+	}
+	if _, static := previousChannel.NoiseVersion(); static != nil {
+		channel.PinNoise(static)
+	}
+}
+
+// NoiseIdentityMismatch returns true if the device presented a different Noise identity than on
+// previous connections, see verifyNoiseIdentity().
+func (dbb *Device) NoiseIdentityMismatch() bool {
+	dbb.mu.RLock()
+	defer dbb.mu.RUnlock()
+	return dbb.noiseIdentityMismatch
+}
+
+// AcceptNoiseIdentity stores the Noise identity the device presented in the current session, e.g.
+// after the user verified that the device was not replaced, and clears the mismatch.
+func (dbb *Device) AcceptNoiseIdentity() error {
+	if dbb.sessionID == "" {
+		return errp.New("The device is not unlocked")
+	}
+	static, enabled := dbb.noiseStatic()
+	if !enabled {
+		return errp.New("The Noise channel is disabled")
+	}
+	if err := dbb.storeNoiseIdentity(dbb.sessionID, static); err != nil {
+		return errp.WithMessage(err, "Failed to store the Noise identity")
+	}
+	dbb.mu.Lock()
+	dbb.noiseIdentityMismatch = false
+	dbb.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// noiseCommunication implements NoiseChannel with a fixed static key of the device.
+type noiseCommunication struct {
+	CommunicationInterface
+	static []byte
+	pinned []byte
+}
+
+func (communication *noiseCommunication) NoiseEnabled() bool {
+	return true
+}
+
+func (communication *noiseCommunication) NoiseVersion() (int, []byte) {
+	if communication.static == nil {
+		return 0, nil
+	}
+	return 1, communication.static
+}
+
+func (communication *noiseCommunication) PinNoise(remoteStatic []byte) {
+	communication.pinned = remoteStatic
+}
+
+func TestVerifyNoiseIdentity(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "dbb_device_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+
+	newDevice := func(static []byte) (*Device, *[]device.Event) {
+		events := []device.Event{}
+		dbb := &Device{
+			channelConfigDir: configDir,
+			communication:    &noiseCommunication{static: static},
+			sessionID:        "device-a",
+			log:              logging.Get().WithGroup("noise_identity_test"),
+		}
+		dbb.onEvent = func(e device.Event, data interface{}) {
+			events = append(events, e)
+		}
+		return dbb, &events
+	}
+
+	// A device which never established the Noise channel is not recorded.
+	dbb, events := newDevice(nil)
+	dbb.verifyNoiseIdentity("device-a")
+	assert.Empty(t, *events)
+	assert.Empty(t, dbb.readNoiseIdentities())
+
+	// The first key is recorded and accepted again.
+	dbb, events = newDevice([]byte("key-a"))
+	dbb.verifyNoiseIdentity("device-a")
+	dbb.verifyNoiseIdentity("device-a")
+	assert.Empty(t, *events)
+	assert.False(t, dbb.NoiseIdentityMismatch())
+
+	// A different key or the legacy encryption is reported.
+	for _, static := range [][]byte{[]byte("key-b"), nil} {
+		dbb, events = newDevice(static)
+		dbb.verifyNoiseIdentity("device-a")
+		assert.True(t, dbb.NoiseIdentityMismatch())
+		assert.Equal(t, []device.Event{EventNoiseIdentityMismatch}, *events)
+	}
+
+	// The user accepts the new key.
+	dbb, _ = newDevice([]byte("key-b"))
+	dbb.verifyNoiseIdentity("device-a")
+	assert.NoError(t, dbb.AcceptNoiseIdentity())
+	assert.False(t, dbb.NoiseIdentityMismatch())
+	dbb, events = newDevice([]byte("key-b"))
+	dbb.verifyNoiseIdentity("device-a")
+	assert.Empty(t, *events)
+
+	// The reconnected device has to present the key of the session.
+	reconnected := &noiseCommunication{}
+	pinNoiseIdentity(dbb.communication, reconnected)
+	assert.Equal(t, []byte("key-b"), reconnected.pinned)
+}
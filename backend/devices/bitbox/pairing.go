@@ -21,8 +21,9 @@ import (
 )
 
 // finishPairing finalizes the persistence of the pairing configuration, actively listens on the
-// mobile channel and fires an event to indicate pairing success or failure.
-func (device *Device) finishPairing(channel *relay.Channel) {
+// mobile channel and fires an event to indicate pairing success or failure. The channel is bound to
+// the device with the given fingerprint and its Noise identity, see verifyPairingIdentity().
+func (device *Device) finishPairing(channel *relay.Channel, fingerprint string) {
 	device.mu.Lock()
 	if err := channel.StoreToConfigFile(device.channelConfigDir); err != nil {
 		device.mu.Unlock() // fireEvent below needs read-lock
@@ -31,9 +32,11 @@ func (device *Device) finishPairing(channel *relay.Channel) {
 		return
 	}
 	device.channel = channel
+	device.pairingIdentityMismatch = false
 	// Release lock early to let the next calls proceed without being blocked.
 	device.mu.Unlock()
 
+	device.storePairingIdentity(fingerprint, channel.ChannelID)
 	go device.listenForMobile()
 	device.fireEvent(EventPairingSuccess, nil)
 }
@@ -53,7 +56,7 @@ func (device *Device) processPairing(channel *relay.Channel) {
 	}
 	if deviceInfo.Lock {
 		device.log.Debug("Device is locked. Only establishing connection to mobile app without repairing.")
-		device.finishPairing(channel)
+		device.finishPairing(channel, deviceInfo.ID)
 		return
 	}
 	device.fireEvent(EventPairingStarted, nil)
@@ -115,7 +118,7 @@ func (device *Device) processPairing(channel *relay.Channel) {
 	}
 	device.log.Debug("Finished pairing")
 	if challenge == "finish" {
-		device.finishPairing(channel)
+		device.finishPairing(channel, deviceInfo.ID)
 	} else {
 		device.fireEvent(EventPairingAborted, nil)
 	}
@@ -93,7 +93,7 @@ func TestFinishPairing(t *testing.T) {
 				event = e
 			}
 			newChan := relay.NewChannelWithRandomKey()
-			dbb.finishPairing(newChan)
+			dbb.finishPairing(newChan, "fingerprint")
 			if event != test.wantEvent {
 				t.Errorf("event = %q; want %q", event, test.wantEvent)
 			}
@@ -114,3 +114,73 @@ func TestFinishPairing(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyPairingIdentity(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "dbb_device_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+
+	newDevice := func(channel *relay.Channel) (*Device, *[]device.Event) {
+		events := []device.Event{}
+		dbb := &Device{
+			closed:           true, // don't run listenForMobile
+			channel:          channel,
+			channelConfigDir: configDir,
+			log:              logging.Get().WithGroup("pairing_identity_test"),
+		}
+		dbb.onEvent = func(e device.Event, data interface{}) {
+			events = append(events, e)
+		}
+		return dbb, &events
+	}
+
+	// A channel paired before identities were recorded is bound to the first device using it.
+	legacyChan := relay.NewChannelWithRandomKey()
+	dbb, events := newDevice(legacyChan)
+	dbb.verifyPairingIdentity("device-a")
+	assert.True(t, dbb.Paired())
+	assert.Empty(t, *events)
+	assert.Equal(t, "device-a", dbb.readPairingIdentities().owner(legacyChan.ChannelID))
+
+	// The same device is accepted again.
+	dbb, events = newDevice(legacyChan)
+	dbb.verifyPairingIdentity("device-a")
+	assert.True(t, dbb.Paired())
+	assert.Empty(t, *events)
+
+	// Another device presenting the channel is rejected.
+	dbb, events = newDevice(legacyChan)
+	dbb.verifyPairingIdentity("device-b")
+	assert.False(t, dbb.Paired())
+	assert.True(t, dbb.PairingIdentityMismatch())
+	assert.Equal(t, []device.Event{EventPairingIdentityMismatch}, *events)
+
+	// A known device presenting an unknown channel is rejected.
+	dbb, events = newDevice(relay.NewChannelWithRandomKey())
+	dbb.verifyPairingIdentity("device-a")
+	assert.False(t, dbb.Paired())
+	assert.Equal(t, []device.Event{EventPairingIdentityMismatch}, *events)
+
+	// Pairing again clears the mismatch.
+	newChan := relay.NewChannelWithRandomKey()
+	dbb.finishPairing(newChan, "device-a")
+	assert.True(t, dbb.Paired())
+	assert.False(t, dbb.PairingIdentityMismatch())
+	assert.Equal(t, "device-a", dbb.readPairingIdentities().owner(newChan.ChannelID))
+
+	// The Noise identity of the device is recorded on first use.
+	dbb, events = newDevice(newChan)
+	dbb.communication = &noiseCommunication{static: []byte("key-a")}
+	dbb.verifyPairingIdentity("device-a")
+	assert.True(t, dbb.Paired())
+	assert.Empty(t, *events)
+
+	// Another device claiming the fingerprint is rejected.
+	dbb, events = newDevice(newChan)
+	dbb.communication = &noiseCommunication{static: []byte("key-b")}
+	dbb.verifyPairingIdentity("device-a")
+	assert.False(t, dbb.Paired())
+	assert.Equal(t, []device.Event{EventPairingIdentityMismatch}, *events)
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/sirupsen/logrus"
+)
+
+const pairingIdentitiesFileName = "pairing-identities.json"
+
+// pairingIdentity records which mobile channel a device was paired with.
+type pairingIdentity struct {
+	ChannelID string `json:"channelID"`
+	// DeviceStatic is the hex encoded static key with which the device identified in the Noise
+	// handshake, or empty if the Noise channel was not established. See verifyDeviceStatic().
+	DeviceStatic string    `json:"deviceStatic,omitempty"`
+	PairedAt     time.Time `json:"pairedAt"`
+}
+
+// pairingIdentities maps device fingerprints to the channel the device was paired with. The
+// fingerprint is the device ID reported in the device info.
+type pairingIdentities map[string]*pairingIdentity
+
+// owner returns the fingerprint of the device the channel was paired with, or "" if unknown.
+func (identities pairingIdentities) owner(channelID string) string {
+	for fingerprint, identity := range identities {
+		if identity.ChannelID == channelID {
+			return fingerprint
+		}
+	}
+	return ""
+}
+
+func (dbb *Device) pairingIdentitiesFile() *config.File {
+	return config.NewFile(dbb.channelConfigDir, pairingIdentitiesFileName)
+}
+
+func (dbb *Device) readPairingIdentities() pairingIdentities {
+	identities := pairingIdentities{}
+	file := dbb.pairingIdentitiesFile()
+	if !file.Exists() {
+		return identities
+	}
+	if err := file.ReadJSON(&identities); err != nil {
+		dbb.log.WithError(err).Error("Failed to read the pairing identities")
+		return pairingIdentities{}
+	}
+	return identities
+}
+
+// storePairingIdentity binds the channel to the device with the given fingerprint.
+func (dbb *Device) storePairingIdentity(fingerprint, channelID string) {
+	if fingerprint == "" {
+		return
+	}
+	identities := dbb.readPairingIdentities()
+	static, _ := dbb.noiseStatic()
+	identities[fingerprint] = &pairingIdentity{
+		ChannelID:    channelID,
+		DeviceStatic: hex.EncodeToString(static),
+		PairedAt:     time.Now(),
+	}
+	if err := dbb.pairingIdentitiesFile().WriteJSON(identities); err != nil {
+		dbb.log.WithError(err).Error("Failed to store the pairing identity")
+	}
+}
+
+// verifyDeviceStatic returns true if the device identifies with the static key in the Noise
+// handshake which was recorded with its pairing. The fingerprint is reported by the device itself,
+// so any device can claim it, but only the paired device can identify with its key. Pairings
+// recorded without a key, e.g. while the Noise channel was disabled, record the current key. If
+// the Noise channel is disabled now, only the fingerprint is compared.
+func (dbb *Device) verifyDeviceStatic(identities pairingIdentities, fingerprint string) bool {
+	static, enabled := dbb.noiseStatic()
+	if !enabled {
+		return true
+	}
+	identity := identities[fingerprint]
+	if identity.DeviceStatic == "" {
+		if static != nil {
+			identity.DeviceStatic = hex.EncodeToString(static)
+			if err := dbb.pairingIdentitiesFile().WriteJSON(identities); err != nil {
+				dbb.log.WithError(err).Error("Failed to store the pairing identity")
+			}
+		}
+		return true
+	}
+	return identity.DeviceStatic == hex.EncodeToString(static)
+}
+
+// verifyPairingIdentity checks that the stored mobile channel was paired with the device with the
+// given fingerprint, and that the device identifies with the same Noise static key as when it was
+// paired, see verifyDeviceStatic(). If it was paired with a different device, or the device was
+// paired with a different channel, the channel could have been swapped by an attacker or the
+// device was replaced. In that case, the channel is not used anymore until the device is paired
+// again, and EventPairingIdentityMismatch is fired. Channels paired before identities were
+// recorded are bound to the first device which uses them.
+func (dbb *Device) verifyPairingIdentity(fingerprint string) {
+	channel := dbb.mobileChannel()
+	if channel == nil || fingerprint == "" {
+		return
+	}
+	identities := dbb.readPairingIdentities()
+	owner := identities.owner(channel.ChannelID)
+	_, known := identities[fingerprint]
+	switch {
+	case owner == fingerprint:
+		if dbb.verifyDeviceStatic(identities, fingerprint) {
+			return
+		}
+		dbb.log.WithField("device", fingerprint).Warning(
+			"The device presented a different Noise identity than when it was paired")
+	case owner == "" && !known:
+		dbb.log.Info("Binding the mobile channel to the device")
+		dbb.storePairingIdentity(fingerprint, channel.ChannelID)
+		return
+	default:
+		dbb.log.WithFields(logrus.Fields{"paired-device": owner, "device": fingerprint}).Warning(
+			"The mobile channel was paired with a different device")
+	}
+	dbb.mu.Lock()
+	dbb.channel = nil
+	dbb.pairingIdentityMismatch = true
+	dbb.mu.Unlock()
+	dbb.fireEvent(EventPairingIdentityMismatch, nil)
+}
+
+// PairingIdentityMismatch returns true if the stored mobile channel was paired with a different
+// device. The device has to be paired again before the mobile can be used.
+func (dbb *Device) PairingIdentityMismatch() bool {
+	dbb.mu.RLock()
+	defer dbb.mu.RUnlock()
+	return dbb.pairingIdentityMismatch
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// Resumable returns true if the session with the device can be resumed after the device was
+// disconnected, i.e. if the user is logged in to a device running the firmware.
+func (dbb *Device) Resumable() bool {
+	dbb.mu.RLock()
+	closed := dbb.closed
+	dbb.mu.RUnlock()
+	return !closed && dbb.bootloaderStatus == nil && dbb.pin != ""
+}
+
+// Suspend is called when the device disappeared, but is expected to be reconnected shortly, e.g.
+// after the computer resumed from sleep. The requests in progress are canceled and
+// EventConnectionLost is fired. The session is kept until the device is resumed or closed.
+func (dbb *Device) Suspend() {
+	dbb.log.Info("Connection lost, waiting for the device to reconnect")
+	dbb.CancelRequests()
+	dbb.fireEvent(EventConnectionLost, nil)
+}
+
+// Resume continues the session using the given communication to the reconnected device. The device
+// has to accept the PIN of the session and report the same device ID as when the user logged in,
+// and identify with the same key in the Noise handshake, otherwise a different device was plugged
+// in and an error is returned. In that case, the
+// communication is not used and the caller has to close the device. On success, the pairing with
+// the mobile is verified again and EventConnectionResumed is fired.
+func (dbb *Device) Resume(communication CommunicationInterface) error {
+	if !dbb.Resumable() {
+		return errp.New("the session can't be resumed")
+	}
+	dbb.requestsMu.Lock()
+	previous := dbb.communication
+	dbb.communication = communication
+	dbb.requestsMu.Unlock()
+	pinNoiseIdentity(previous, communication)
+
+	restore := func() {
+		dbb.requestsMu.Lock()
+		dbb.communication = previous
+		dbb.requestsMu.Unlock()
+	}
+	deviceInfo, err := dbb.deviceInfo(dbb.pin)
+	if err != nil {
+		restore()
+		return errp.WithMessage(err, "Failed to unlock the reconnected device")
+	}
+	if dbb.sessionID != "" && deviceInfo.ID != dbb.sessionID {
+		restore()
+		dbb.log.WithFields(logrus.Fields{"device": deviceInfo.ID, "session-device": dbb.sessionID}).
+			Warning("A different device was connected")
+		return errp.New("a different device was connected")
+	}
+	previous.Close()
+	dbb.listenForDeviceEvents(communication)
+	dbb.cache.setDeviceInfo(dbb.pin, deviceInfo)
+	dbb.sessionID = deviceInfo.ID
+	dbb.seeded = deviceInfo.Seeded
+	dbb.verifyNoiseIdentity(deviceInfo.ID)
+	dbb.verifyPairingIdentity(deviceInfo.ID)
+	dbb.log.Info("Resumed the session with the reconnected device")
+	dbb.fireEvent(EventConnectionResumed, nil)
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"encoding/json"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// reconnectedCommunication returns a communication to a device which reports the given device ID.
+func reconnectedCommunication(id string) *mocks.CommunicationInterface {
+	deviceInfoMap := map[string]interface{}{}
+	_ = json.Unmarshal(jsonp.MustMarshal(&DeviceInfo{ID: id, Bootlock: true}), &deviceInfoMap)
+	communication := new(mocks.CommunicationInterface)
+	communication.On(
+		"SendEncrypt",
+		mock.Anything,
+		jsonArgumentMatcher(map[string]interface{}{"device": "info"}),
+		pin,
+	).Return(map[string]interface{}{"device": deviceInfoMap}, nil).Once()
+	return communication
+}
+
+func (s *dbbTestSuite) TestResume() {
+	require.False(s.T(), s.dbb.Resumable())
+	require.Error(s.T(), s.dbb.Resume(reconnectedCommunication("session id")))
+
+	require.NoError(s.T(), s.login())
+	s.dbb.sessionID = "session id"
+	require.True(s.T(), s.dbb.Resumable())
+
+	events := []device.Event{}
+	s.dbb.SetOnEvent(func(event device.Event, data interface{}) {
+		events = append(events, event)
+	})
+	s.dbb.Suspend()
+
+	// A different device was plugged in.
+	require.Error(s.T(), s.dbb.Resume(reconnectedCommunication("other id")))
+	require.False(s.T(), s.mockCommClosed)
+	require.Equal(s.T(), s.mockCommunication, s.dbb.transport())
+
+	communication := reconnectedCommunication("session id")
+	communication.On("Close")
+	require.NoError(s.T(), s.dbb.Resume(communication))
+	require.True(s.T(), s.mockCommClosed)
+	require.Equal(s.T(), communication, s.dbb.transport())
+	require.Equal(s.T(), StatusLoggedIn, s.dbb.Status())
+	require.Equal(s.T(), []device.Event{EventConnectionLost, EventConnectionResumed}, events)
+	communication.AssertNumberOfCalls(s.T(), "SendEncrypt", 1)
+}
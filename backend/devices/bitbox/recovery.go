@@ -0,0 +1,210 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+)
+
+const (
+	upgradeJournalFileName = "firmware-upgrade.json"
+
+	// maxRecoveryAttempts is the number of times BootloaderRecover tries to flash the firmware.
+	maxRecoveryAttempts = 3
+)
+
+// upgradeStage is the stage of a firmware upgrade as recorded in the upgrade journal.
+type upgradeStage string
+
+const (
+	// upgradeStageUnlocked means the bootloader was unlocked so the user can enter bootloader mode.
+	upgradeStageUnlocked upgradeStage = "unlocked"
+	// upgradeStageFlashing means the firmware was erased and the new firmware is being written.
+	upgradeStageFlashing upgradeStage = "flashing"
+)
+
+// RecoveryReason describes why a device in bootloader mode needs to be recovered.
+type RecoveryReason string
+
+const (
+	// RecoveryReasonInterrupted means a firmware upgrade was started but never completed, so the
+	// device has no valid firmware. The firmware is re-flashed automatically.
+	RecoveryReasonInterrupted RecoveryReason = "interrupted"
+	// RecoveryReasonUnexpected means the device entered bootloader mode without the app having
+	// unlocked the bootloader, e.g. after an upgrade failed in a previous session or another app.
+	// The user has to confirm the re-flash.
+	RecoveryReasonUnexpected RecoveryReason = "unexpected"
+)
+
+// upgradeJournal records the progress of a firmware upgrade in the app config dir, so that a
+// device which shows up in bootloader mode can be recognized as expected or as the result of an
+// interrupted upgrade. Only one device is tracked at a time, as the device identifier changes when
+// the device switches between firmware and bootloader mode.
+type upgradeJournal struct {
+	Stage upgradeStage `json:"stage"`
+	// Version is the version of the firmware being installed.
+	Version string `json:"version"`
+	// FirmwareVersion is the version of the firmware which was installed before the upgrade, if
+	// known. In bootloader mode, the device only reports the bootloader version.
+	FirmwareVersion string    `json:"firmwareVersion,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+func (dbb *Device) upgradeJournalFile() *config.File {
+	return config.NewFile(dbb.channelConfigDir, upgradeJournalFileName)
+}
+
+// readUpgradeJournal returns the stored upgrade journal, or nil if there is none.
+func (dbb *Device) readUpgradeJournal() *upgradeJournal {
+	file := dbb.upgradeJournalFile()
+	if !file.Exists() {
+		return nil
+	}
+	var journal upgradeJournal
+	if err := file.ReadJSON(&journal); err != nil {
+		dbb.log.WithError(err).Error("Failed to read the firmware upgrade journal")
+		return nil
+	}
+	return &journal
+}
+
+func (dbb *Device) writeUpgradeJournal(journal *upgradeJournal) {
+	journal.Time = time.Now()
+	if err := dbb.upgradeJournalFile().WriteJSON(journal); err != nil {
+		dbb.log.WithError(err).Error("Failed to write the firmware upgrade journal")
+	}
+}
+
+func (dbb *Device) removeUpgradeJournal() {
+	file := dbb.upgradeJournalFile()
+	if !file.Exists() {
+		return
+	}
+	if err := file.Remove(); err != nil {
+		dbb.log.WithError(err).Error("Failed to remove the firmware upgrade journal")
+	}
+}
+
+// detectRecovery checks whether the device entering bootloader mode was expected, and marks the
+// bootloader status as requiring recovery otherwise.
+func (dbb *Device) detectRecovery() {
+	journal := dbb.readUpgradeJournal()
+	switch {
+	case journal == nil:
+		dbb.bootloaderStatus.Recovery = true
+		dbb.bootloaderStatus.RecoveryReason = RecoveryReasonUnexpected
+	case journal.Stage == upgradeStageFlashing:
+		dbb.bootloaderStatus.Recovery = true
+		dbb.bootloaderStatus.RecoveryReason = RecoveryReasonInterrupted
+		dbb.bootloaderStatus.RecoveryVersion = journal.Version
+	default:
+		return
+	}
+	dbb.log.WithField("reason", dbb.bootloaderStatus.RecoveryReason).Warning(
+		"Device is in bootloader mode unexpectedly")
+}
+
+// RecoveryPending returns true if the device is in bootloader mode after an interrupted firmware
+// upgrade and the firmware has not been re-flashed yet.
+func (dbb *Device) RecoveryPending() bool {
+	return dbb.bootloaderStatus != nil &&
+		dbb.bootloaderStatus.RecoveryReason == RecoveryReasonInterrupted &&
+		!dbb.bootloaderStatus.UpgradeSuccessful
+}
+
+// installedFirmwareVersion returns the firmware version recorded when the bootloader was unlocked,
+// or nil if it is not known.
+func (dbb *Device) installedFirmwareVersion() *semver.SemVer {
+	journal := dbb.readUpgradeJournal()
+	if journal == nil || journal.FirmwareVersion == "" {
+		return nil
+	}
+	version, err := semver.NewSemVerFromString(journal.FirmwareVersion)
+	if err != nil {
+		dbb.log.WithError(err).Error("Invalid firmware version in the upgrade journal")
+		return nil
+	}
+	return version
+}
+
+// FirmwareVersion returns the version of the installed firmware. In bootloader mode, it is the
+// version recorded when the bootloader was unlocked, or nil if it is not known.
+func (dbb *Device) FirmwareVersion() *semver.SemVer {
+	if dbb.bootloaderStatus != nil {
+		return dbb.installedFirmwareVersion()
+	}
+	return dbb.version
+}
+
+// PendingFirmwareVersion returns the version of the firmware to install in bootloader mode, which
+// was chosen when the bootloader was unlocked, see UnlockBootloaderForFirmware(). The bundled
+// version is returned if none was recorded.
+func (dbb *Device) PendingFirmwareVersion() *semver.SemVer {
+	journal := dbb.readUpgradeJournal()
+	if journal == nil {
+		return BundledFirmwareVersion()
+	}
+	version, err := semver.NewSemVerFromString(journal.Version)
+	if err != nil {
+		dbb.log.WithError(err).Error("Invalid firmware version in the upgrade journal")
+		return BundledFirmwareVersion()
+	}
+	return version
+}
+
+// BootloaderRecover re-flashes the bundled firmware onto a device which is in bootloader mode
+// unexpectedly. Flashing is retried up to maxRecoveryAttempts times.
+func (dbb *Device) BootloaderRecover() error {
+	return dbb.BootloaderRecoverWithFirmware(BundledFirmware(), BundledFirmwareVersion())
+}
+
+// BootloaderRecoverWithFirmware is like BootloaderRecover(), but re-flashes the given firmware,
+// e.g. the downloaded firmware of the interrupted upgrade.
+func (dbb *Device) BootloaderRecoverWithFirmware(signedFirmware []byte, version *semver.SemVer) error {
+	if dbb.bootloaderStatus == nil {
+		return errp.New("device is not in bootloader mode")
+	}
+	if !dbb.bootloaderStatus.Recovery {
+		return errp.New("device does not need to be recovered")
+	}
+	if dbb.bootloaderStatus.RecoveryVersion != "" &&
+		dbb.bootloaderStatus.RecoveryVersion != version.String() {
+		dbb.log.WithField("interrupted-version", dbb.bootloaderStatus.RecoveryVersion).Warning(
+			"The interrupted upgrade used a different firmware version")
+	}
+	if dbb.bootloaderStatus.Upgrading {
+		return errp.New("already in progress")
+	}
+	var err error
+	for attempt := 0; attempt < maxRecoveryAttempts; attempt++ {
+		dbb.bootloaderStatus.RecoveryAttempts++
+		dbb.bootloaderStatus.ErrMsg = ""
+		dbb.log.WithField("attempt", dbb.bootloaderStatus.RecoveryAttempts).Info(
+			"Re-flashing the firmware")
+		err = dbb.BootloaderUpgradeFirmware(signedFirmware, version)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*FirmwareDowngradeError); ok {
+			return err
+		}
+		dbb.log.WithError(err).Error("Failed to re-flash the firmware")
+	}
+	return errp.WithMessage(err, "Giving up recovering the firmware")
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newBootloaderDevice(t *testing.T, configDir string) (*Device, *mocks.CommunicationInterface) {
+	comm := new(mocks.CommunicationInterface)
+	comm.On("Close")
+	dbb, err := NewDevice("test-device-id", true /* bootloader */, firmVer400, configDir, comm)
+	require.NoError(t, err)
+	return dbb, comm
+}
+
+func TestBootloaderRecoveryDetection(t *testing.T) {
+	configDir := test.TstTempDir("dbb_recovery_test")
+	defer os.RemoveAll(configDir)
+
+	// No journal: the bootloader was not unlocked by the app.
+	dbb, _ := newBootloaderDevice(t, configDir)
+	require.Equal(t, StatusBootloaderRecovery, dbb.Status())
+	status, err := dbb.BootloaderStatus()
+	require.NoError(t, err)
+	require.Equal(t, RecoveryReasonUnexpected, status.RecoveryReason)
+	require.False(t, dbb.RecoveryPending())
+
+	// The bootloader was unlocked by the app: regular upgrade.
+	dbb.writeUpgradeJournal(&upgradeJournal{Stage: upgradeStageUnlocked})
+	dbb, _ = newBootloaderDevice(t, configDir)
+	require.Equal(t, StatusBootloader, dbb.Status())
+	require.False(t, dbb.RecoveryPending())
+	require.Error(t, dbb.BootloaderRecoverWithFirmware(nil, firmVer400))
+
+	// The previous upgrade was interrupted.
+	dbb.writeUpgradeJournal(&upgradeJournal{
+		Stage:   upgradeStageFlashing,
+		Version: BundledFirmwareVersion().String(),
+	})
+	dbb, _ = newBootloaderDevice(t, configDir)
+	require.Equal(t, StatusBootloaderRecovery, dbb.Status())
+	require.True(t, dbb.RecoveryPending())
+	status, err = dbb.BootloaderStatus()
+	require.NoError(t, err)
+	require.Equal(t, RecoveryReasonInterrupted, status.RecoveryReason)
+	require.Equal(t, BundledFirmwareVersion().String(), status.RecoveryVersion)
+}
+
+func TestBootloaderRecover(t *testing.T) {
+	configDir := test.TstTempDir("dbb_recovery_test")
+	defer os.RemoveAll(configDir)
+
+	dbb, _ := newBootloaderDevice(t, configDir)
+	dbb.writeUpgradeJournal(&upgradeJournal{Stage: upgradeStageFlashing})
+	dbb, comm := newBootloaderDevice(t, configDir)
+
+	// The first attempt fails when erasing, the second one succeeds.
+	comm.On("SendBootloaderWithProgress", mock.Anything, []byte("e"), mock.Anything).Return(
+		[]byte("e1"), nil).Once()
+	comm.On("SendBootloaderWithProgress", mock.Anything, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, msg []byte, _ func(int, int)) []byte { return []byte{msg[0], '0'} }, nil)
+
+	signedFirmware := make([]byte, signaturesSize+2*bootloaderMaxChunkSize)
+	require.NoError(t, dbb.BootloaderRecoverWithFirmware(signedFirmware, firmVer400))
+	status, err := dbb.BootloaderStatus()
+	require.NoError(t, err)
+	require.True(t, status.UpgradeSuccessful)
+	require.Equal(t, 2, status.RecoveryAttempts)
+	require.False(t, dbb.RecoveryPending())
+	require.Nil(t, dbb.readUpgradeJournal())
+}
+
+func TestBootloaderUpgradeProgress(t *testing.T) {
+	configDir := test.TstTempDir("dbb_recovery_test")
+	defer os.RemoveAll(configDir)
+
+	dbb, comm := newBootloaderDevice(t, configDir)
+	comm.On("SendBootloaderWithProgress", mock.Anything, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, msg []byte, onProgress func(int, int)) []byte {
+			if onProgress != nil {
+				for sent := 1024; sent <= 4096; sent += 1024 {
+					onProgress(sent, 4096)
+				}
+			}
+			return []byte{msg[0], '0'}
+		}, nil)
+	progress := []float64{}
+	dbb.SetOnEvent(func(event device.Event, _ interface{}) {
+		if event == EventBootloaderStatusChanged {
+			progress = append(progress, dbb.bootloaderStatus.Progress)
+		}
+	})
+
+	signedFirmware := make([]byte, signaturesSize+2*bootloaderMaxChunkSize)
+	require.NoError(t, dbb.BootloaderUpgradeFirmware(signedFirmware, firmVer400))
+	// The upgrade starts and succeeds with a progress of 0, in between the progress is reported
+	// for each quarter of the two chunks, and again when each chunk is done.
+	require.Equal(t, []float64{0, 0.125, 0.25, 0.375, 0.5, 0.5, 0.625, 0.75, 0.875, 1, 1, 0}, progress)
+}
+
+func TestCheckFirmwareDowngrade(t *testing.T) {
+	require.NoError(t, checkFirmwareDowngrade(nil, firmVer400))
+	require.NoError(t, checkFirmwareDowngrade(firmVer400, firmVer400))
+	require.NoError(t, checkFirmwareDowngrade(semver.NewSemVer(3, 0, 0), firmVer400))
+	err := checkFirmwareDowngrade(semver.NewSemVer(4, 1, 0), firmVer400)
+	require.IsType(t, &FirmwareDowngradeError{}, err)
+}
+
+func TestBootloaderUpgradeFirmwareDowngrade(t *testing.T) {
+	configDir := test.TstTempDir("dbb_recovery_test")
+	defer os.RemoveAll(configDir)
+
+	dbb, _ := newBootloaderDevice(t, configDir)
+	dbb.writeUpgradeJournal(&upgradeJournal{
+		Stage:           upgradeStageFlashing,
+		FirmwareVersion: "4.1.0",
+	})
+	dbb, comm := newBootloaderDevice(t, configDir)
+
+	signedFirmware := make([]byte, signaturesSize+bootloaderMaxChunkSize)
+	err := dbb.BootloaderRecoverWithFirmware(signedFirmware, firmVer400)
+	require.IsType(t, &FirmwareDowngradeError{}, err)
+	comm.AssertNotCalled(t, "SendBootloaderWithProgress", mock.Anything, mock.Anything, mock.Anything)
+	status, err := dbb.BootloaderStatus()
+	require.NoError(t, err)
+	require.False(t, status.Upgrading)
+	require.Equal(t, 1, status.RecoveryAttempts)
+}
+
+func TestBootloaderFirmwareHash(t *testing.T) {
+	configDir := test.TstTempDir("dbb_recovery_test")
+	defer os.RemoveAll(configDir)
+
+	dbb, comm := newBootloaderDevice(t, configDir)
+	version, err := dbb.BootloaderVersion()
+	require.NoError(t, err)
+	require.Equal(t, firmVer400, version)
+
+	hash := bytes.Repeat([]byte{0xab}, sha256.Size)
+	comm.On("SendBootloader", mock.Anything, []byte("h")).Return(
+		append([]byte("h0"), []byte(hex.EncodeToString(hash))...), nil).Once()
+	firmwareHash, err := dbb.BootloaderFirmwareHash()
+	require.NoError(t, err)
+	require.Equal(t, hash, firmwareHash)
+
+	comm.On("SendBootloader", mock.Anything, []byte("h")).Return([]byte("h1"), nil).Once()
+	_, err = dbb.BootloaderFirmwareHash()
+	require.Error(t, err)
+}
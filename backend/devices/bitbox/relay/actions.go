@@ -19,11 +19,10 @@ import (
 	"encoding/json"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
-	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 )
 
-// PushMessage pushes the encryption of the given data as JSON to the given server.
-func PushMessage(server Server, channel *Channel, data interface{}) error {
+// PushMessage pushes the encryption of the given data as JSON with the given transport.
+func PushMessage(transport Transport, channel *Channel, data interface{}) error {
 	if channel == nil {
 		panic("The channel may not be nil.")
 	}
@@ -36,53 +35,26 @@ func PushMessage(server Server, channel *Channel, data interface{}) error {
 	if err != nil {
 		return err
 	}
-	content := base64.StdEncoding.EncodeToString(encrypted)
-
-	request := &request{
-		server:  server,
-		command: PushMessageCommand,
-		sender:  Desktop,
-		channel: channel,
-		content: &content,
-	}
-
-	response, err := request.send()
-	if err != nil {
-		return err
-	}
-
-	return response.getErrorIfNok()
+	return transport.Push(Desktop, channel, base64.StdEncoding.EncodeToString(encrypted))
 }
 
-// PullOldestMessage pulls the oldest message on the given channel from the given server.
-// If no message is available for ten seconds, then this function returns nil.
-func PullOldestMessage(server Server, channel *Channel) ([]byte, error) {
+// PullOldestMessage pulls the oldest message on the given channel with the given transport.
+// If no message is available within the polling interval of the transport, then this function
+// returns nil.
+func PullOldestMessage(transport Transport, channel *Channel) ([]byte, error) {
 	if channel == nil {
 		panic("The channel may not be nil.")
 	}
 
-	request := &request{
-		server:  server,
-		command: PullOldestMessageCommand,
-		sender:  Desktop,
-		channel: channel,
+	payload, err := transport.Pull(Desktop, channel)
+	if err != nil || payload == "" {
+		return nil, err
 	}
-
-	response, err := request.send()
+	decodedPayload, err := decodePayload(payload)
 	if err != nil {
 		return nil, err
 	}
-
-	if response.Status == "ok" && response.Data != nil && len(response.Data) > 0 {
-		decodedPayload, err := base64.StdEncoding.DecodeString(response.Data[0].Payload)
-		if err != nil {
-			return nil, errp.WithStack(err)
-		}
-		return crypto.MACThenDecrypt(decodedPayload, channel.EncryptionKey,
-			channel.AuthenticationKey)
-	}
-
-	return nil, response.getErrorIfNok()
+	return crypto.MACThenDecrypt(decodedPayload, channel.EncryptionKey, channel.AuthenticationKey)
 }
 
 // DeleteAllMessages deletes all messages in all channels which expired on the given server.
@@ -45,6 +45,13 @@ type Channel struct {
 	// AuthenticationKey is used to authenticate messages between the desktop and the mobile.
 	AuthenticationKey []byte `json:"mac"`
 
+	// RelayServer is the relay server used by both parties if not the default server. It is part of
+	// the pairing data, so that the mobile uses the same server.
+	RelayServer Server `json:"server,omitempty"`
+
+	// transport overrides the relay server, see SetTransport().
+	transport Transport
+
 	// messageBuffer buffers the messages that were not expected by the caller of waitForValue.
 	messageBuffer [][]byte
 
@@ -102,12 +109,29 @@ func (channel *Channel) RemoveConfigFile(configDir string) error {
 	return config.NewFile(configDir, configFileName).Remove()
 }
 
-// relayServer returns the configured relay server.
-// The server is hardcoded for now but can be loaded from the settings in the future.
+// relayServer returns the default relay server.
 func relayServer() Server {
 	return DefaultServer
 }
 
+// SetTransport sets the transport delivering the messages of the channel instead of the relay
+// server, e.g. a Mailbox. It must be called before the channel is used.
+func (channel *Channel) SetTransport(transport Transport) {
+	channel.transport = transport
+}
+
+// relayTransport returns the transport of the channel: the transport set with SetTransport(), or
+// the relay server of the channel.
+func (channel *Channel) relayTransport() Transport {
+	if channel.transport != nil {
+		return channel.transport
+	}
+	if channel.RelayServer != "" {
+		return channel.RelayServer
+	}
+	return relayServer()
+}
+
 // getValueFromMessage returns the value of the field in the message and true, if found, or
 // an empty string and false, if not found.
 func (channel *Channel) getValueFromMessage(message []byte, name string) (string, bool) {
@@ -148,7 +172,7 @@ func (channel *Channel) waitForValue(duration time.Duration, name string) (strin
 			unlock()
 		} else {
 			unlock()
-			message, err := PullOldestMessage(relayServer(), channel)
+			message, err := PullOldestMessage(channel.relayTransport(), channel)
 			if err != nil {
 				return "", err
 			}
@@ -204,21 +228,21 @@ func (channel *Channel) WaitForCommand(duration time.Duration) (string, error) {
 
 // SendHashPubKey sends the hash of the public key from the BitBox to the mobile to finish pairing.
 func (channel *Channel) SendHashPubKey(verifyPass interface{}) error {
-	return PushMessage(relayServer(), channel, map[string]interface{}{
+	return PushMessage(channel.relayTransport(), channel, map[string]interface{}{
 		"ecdh": verifyPass,
 	})
 }
 
 // SendPubKey sends the ECDH public key from the BitBox to the paired mobile to finish pairing.
 func (channel *Channel) SendPubKey(verifyPass interface{}) error {
-	return PushMessage(relayServer(), channel, map[string]interface{}{
+	return PushMessage(channel.relayTransport(), channel, map[string]interface{}{
 		"ecdh": verifyPass,
 	})
 }
 
 // SendPairingTest sends the encrypted test string from the BitBox to the paired mobile.
 func (channel *Channel) SendPairingTest(tfaTestString string) error {
-	return PushMessage(relayServer(), channel, map[string]string{
+	return PushMessage(channel.relayTransport(), channel, map[string]string{
 		"tfa": tfaTestString,
 	})
 }
@@ -230,7 +254,7 @@ type action struct {
 
 // SendPing sends a 'ping' to the paired mobile to which it automatically responds with 'pong'.
 func (channel *Channel) SendPing() error {
-	return PushMessage(relayServer(), channel, &action{"ping"})
+	return PushMessage(channel.relayTransport(), channel, &action{"ping"})
 }
 
 // WaitForPong waits for the given duration for the 'pong' from the mobile after sending 'ping'.
@@ -247,30 +271,63 @@ func (channel *Channel) WaitForPong(duration time.Duration) error {
 
 // SendClear clears the screen of the paired mobile.
 func (channel *Channel) SendClear() error {
-	return PushMessage(relayServer(), channel, &action{"clear"})
+	return PushMessage(channel.relayTransport(), channel, &action{"clear"})
 }
 
-// SendXpubEcho sends the encrypted xpub echo from the BitBox to the paired mobile.
+// OutputTypeRootFingerprint is the type of an xpub echo of the master public key, for which the
+// mobile shows the root fingerprint (see BIP32) instead of an address.
+const OutputTypeRootFingerprint = "rootFingerprint"
+
+// SendXpubEcho sends the encrypted xpub echo from the BitBox to the paired mobile. The type tells
+// the mobile what to display, e.g. "Bitcoin-p2wpkh" for an address or OutputTypeRootFingerprint.
 func (channel *Channel) SendXpubEcho(xpubEcho string, typ string) error {
-	return PushMessage(relayServer(), channel, map[string]string{
+	return PushMessage(channel.relayTransport(), channel, map[string]string{
 		"echo": xpubEcho,
 		"type": typ,
 	})
 }
 
-// SendSigningEcho sends the encrypted signing echo from the BitBox to the paired mobile.
+// SigningDetails are optional details about a transaction which the mobile displays during
+// confirmation. Mobile apps not supporting them ignore them.
+type SigningDetails struct {
+	// Memo is a short note by the sender.
+	Memo string
+	// FiatAmount is the formatted fiat value of the sent amount in FiatUnit.
+	FiatAmount string
+	FiatUnit   string
+	// FiatRateTimestamp is the time the exchange rate was fetched.
+	FiatRateTimestamp time.Time
+	// AccountName is the display name of the sending account.
+	AccountName string
+}
+
+// SendSigningEcho sends the encrypted signing echo from the BitBox to the paired mobile, together
+// with the details to display during confirmation.
 func (channel *Channel) SendSigningEcho(
 	signingEcho string,
 	coin string,
 	scriptType string,
 	transaction string,
+	details *SigningDetails,
 ) error {
-	return PushMessage(relayServer(), channel, map[string]string{
+	message := map[string]string{
 		"echo":               signingEcho,
 		"coin":               coin,
 		"inputAndChangeType": scriptType,
 		"tx":                 transaction,
-	})
+	}
+	if details != nil && details.Memo != "" {
+		message["memo"] = details.Memo
+	}
+	if details != nil && details.AccountName != "" {
+		message["account"] = details.AccountName
+	}
+	if details != nil && details.FiatUnit != "" {
+		message["fiatAmount"] = details.FiatAmount
+		message["fiatUnit"] = details.FiatUnit
+		message["fiatRateTimestamp"] = details.FiatRateTimestamp.UTC().Format(time.RFC3339)
+	}
+	return PushMessage(channel.relayTransport(), channel, message)
 }
 
 // WaitForSigningPin waits for the given duration for the 2FA signing PIN from the mobile.
@@ -282,7 +339,7 @@ func (channel *Channel) WaitForSigningPin(duration time.Duration) (string, error
 
 // SendRandomNumberEcho sends the encrypted random number echo from the BitBox to the paired mobile.
 func (channel *Channel) SendRandomNumberEcho(randomNumberEcho string) error {
-	return PushMessage(relayServer(), channel, map[string]string{
+	return PushMessage(channel.relayTransport(), channel, map[string]string{
 		"echo": randomNumberEcho,
 	})
 }
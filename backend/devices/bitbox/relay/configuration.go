@@ -18,6 +18,7 @@ type configuration struct {
 	ChannelID         string `json:"channel"`
 	EncryptionKey     []byte `json:"encryption"`
 	AuthenticationKey []byte `json:"authentication"`
+	RelayServer       Server `json:"server,omitempty"`
 }
 
 func newConfiguration(channel *Channel) *configuration {
@@ -25,9 +26,12 @@ func newConfiguration(channel *Channel) *configuration {
 		ChannelID:         channel.ChannelID,
 		EncryptionKey:     channel.EncryptionKey,
 		AuthenticationKey: channel.AuthenticationKey,
+		RelayServer:       channel.RelayServer,
 	}
 }
 
 func (config *configuration) channel() *Channel {
-	return NewChannel(config.ChannelID, config.EncryptionKey, config.AuthenticationKey)
+	channel := NewChannel(config.ChannelID, config.EncryptionKey, config.AuthenticationKey)
+	channel.RelayServer = config.RelayServer
+	return channel
 }
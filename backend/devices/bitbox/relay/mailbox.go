@@ -0,0 +1,175 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// mailboxKey identifies the messages of a channel to one party.
+type mailboxKey struct {
+	channelID string
+	receiver  Party
+}
+
+// Mailbox relays the messages of channels in memory. It is a local alternative to the relay server:
+// the desktop uses it as its Transport, and the mobile reaches it with the relay protocol, see
+// ServeHTTP(), e.g. if both are in the same network.
+type Mailbox struct {
+	// messages are the payloads waiting to be pulled, oldest first.
+	messages map[mailboxKey][]string
+	// arrived is closed and replaced whenever a message arrives, waking up waiting pulls.
+	arrived chan struct{}
+	lock    locker.Locker
+
+	// pollTimeout is how long a pull waits for a message.
+	pollTimeout time.Duration
+}
+
+// NewMailbox creates a new mailbox whose pulls wait up to pollTimeout for a message.
+func NewMailbox(pollTimeout time.Duration) *Mailbox {
+	return &Mailbox{
+		messages:    map[mailboxKey][]string{},
+		arrived:     make(chan struct{}),
+		pollTimeout: pollTimeout,
+	}
+}
+
+// otherParty returns the party receiving the messages of the given party.
+func otherParty(party Party) Party {
+	if party == Desktop {
+		return Mobile
+	}
+	return Desktop
+}
+
+// Push implements Transport.
+func (mailbox *Mailbox) Push(sender Party, channel *Channel, payload string) error {
+	defer mailbox.lock.Lock()()
+	key := mailboxKey{channelID: channel.ChannelID, receiver: otherParty(sender)}
+	mailbox.messages[key] = append(mailbox.messages[key], payload)
+	close(mailbox.arrived)
+	mailbox.arrived = make(chan struct{})
+	return nil
+}
+
+// pop returns the oldest message to the receiver, or false if there is none. If there is none, the
+// returned channel is closed when the next message arrives.
+func (mailbox *Mailbox) pop(key mailboxKey) (string, bool, <-chan struct{}) {
+	defer mailbox.lock.Lock()()
+	messages := mailbox.messages[key]
+	if len(messages) == 0 {
+		return "", false, mailbox.arrived
+	}
+	if len(messages) == 1 {
+		delete(mailbox.messages, key)
+	} else {
+		mailbox.messages[key] = messages[1:]
+	}
+	return messages[0], true, nil
+}
+
+// Pull implements Transport.
+func (mailbox *Mailbox) Pull(receiver Party, channel *Channel) (string, error) {
+	key := mailboxKey{channelID: channel.ChannelID, receiver: receiver}
+	timeout := time.After(mailbox.pollTimeout)
+	for {
+		payload, ok, arrived := mailbox.pop(key)
+		if ok {
+			return payload, nil
+		}
+		select {
+		case <-arrived:
+		case <-timeout:
+			return "", nil
+		}
+	}
+}
+
+// decodeRequest decodes the parameters of a request encoded by request.encode(). The values are not
+// unescaped, as the relay protocol does not escape them.
+func decodeRequest(body string) map[string]string {
+	params := map[string]string{}
+	for _, param := range strings.Split(body, "&") {
+		if parts := strings.SplitN(param, "=", 2); len(parts) == 2 {
+			params[parts[0]] = parts[1]
+		}
+	}
+	return params
+}
+
+// handle executes the relay command of the request.
+func (mailbox *Mailbox) handle(params map[string]string) (*response, error) {
+	var party Party
+	switch params["dt"] {
+	case Desktop.Encode():
+		party = Desktop
+	case Mobile.Encode():
+		party = Mobile
+	default:
+		return nil, errp.New("invalid party")
+	}
+	channel := &Channel{ChannelID: params["uuid"]}
+	if Command(params["c"]) != DeleteAllMessagesCommand && channel.ChannelID == "" {
+		return nil, errp.New("missing channel")
+	}
+	switch Command(params["c"]) {
+	case PushMessageCommand:
+		if err := mailbox.Push(party, channel, params["pl"]); err != nil {
+			return nil, err
+		}
+		return &response{Status: "ok"}, nil
+	case PullOldestMessageCommand:
+		payload, err := mailbox.Pull(party, channel)
+		if err != nil {
+			return nil, err
+		}
+		if payload == "" {
+			return &response{Status: "ok"}, nil
+		}
+		return &response{Status: "ok", Data: []data{{Payload: payload}}}, nil
+	case DeleteAllMessagesCommand:
+		// Messages are kept in memory until they are pulled.
+		return &response{Status: "ok"}, nil
+	}
+	return nil, errp.New("unknown command")
+}
+
+// ServeHTTP serves the relay protocol of the relay server to the mobile.
+func (mailbox *Mailbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serverResponse, err := mailbox.handle(decodeRequest(string(body)))
+	if err != nil {
+		message := err.Error()
+		serverResponse = &response{Status: "nok", Error: &message}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(serverResponse)
+}
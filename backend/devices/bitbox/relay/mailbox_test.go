@@ -0,0 +1,99 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+// pushAsMobile pushes the encrypted JSON data from the mobile.
+func pushAsMobile(t *testing.T, transport Transport, channel *Channel, data interface{}) {
+	bytes, err := json.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := crypto.EncryptThenMAC(bytes, channel.EncryptionKey, channel.AuthenticationKey)
+	require.NoError(t, err)
+	require.NoError(t, transport.Push(Mobile, channel, base64.StdEncoding.EncodeToString(encrypted)))
+}
+
+func TestMailbox(t *testing.T) {
+	mailbox := NewMailbox(50 * time.Millisecond)
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+	// The mobile reaches the mailbox of the desktop with the relay protocol.
+	mobile := Server(server.URL)
+
+	channel := NewChannelWithRandomKey()
+	channel.SetTransport(mailbox)
+	otherChannel := NewChannelWithRandomKey()
+	otherChannel.SetTransport(mailbox)
+
+	require.NoError(t, channel.SendPing())
+	// Messages are delivered to the other party of the same channel only.
+	payload, err := mailbox.Pull(Desktop, channel)
+	require.NoError(t, err)
+	require.Empty(t, payload)
+	payload, err = mobile.Pull(Mobile, otherChannel)
+	require.NoError(t, err)
+	require.Empty(t, payload)
+	payload, err = mobile.Pull(Mobile, channel)
+	require.NoError(t, err)
+	decoded, err := decodePayload(payload)
+	require.NoError(t, err)
+	message, err := crypto.MACThenDecrypt(decoded, channel.EncryptionKey, channel.AuthenticationKey)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"action":"ping"}`, string(message))
+
+	// A waiting pull returns as soon as the message arrives.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pushAsMobile(t, mobile, channel, map[string]string{"action": "pong"})
+	}()
+	require.NoError(t, channel.WaitForPong(time.Second))
+
+	pushAsMobile(t, mobile, channel, map[string]string{"id": "success"})
+	pushAsMobile(t, mobile, channel, map[string]string{"ecdh": "challenge"})
+	// Messages are buffered until they are expected.
+	command, err := channel.WaitForCommand(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "challenge", command)
+	require.NoError(t, channel.WaitForScanningSuccess(time.Second))
+
+	_, err = mailbox.handle(map[string]string{"c": "unknown", "dt": "0", "uuid": "x"})
+	require.Error(t, err)
+	_, err = mailbox.handle(map[string]string{"c": string(PushMessageCommand), "dt": "2", "uuid": "x"})
+	require.Error(t, err)
+}
+
+func TestChannelRelayServer(t *testing.T) {
+	channel := NewChannelWithRandomKey()
+	require.Equal(t, DefaultServer, channel.relayTransport())
+	channel.RelayServer = "http://192.168.1.2:8083"
+	require.Equal(t, channel.RelayServer, channel.relayTransport())
+
+	configDir := test.TstTempDir("relay_test")
+	defer func() { _ = os.RemoveAll(configDir) }()
+	require.NoError(t, channel.StoreToConfigFile(configDir))
+	require.Equal(t, channel.RelayServer, NewChannelFromConfigFile(configDir).RelayServer)
+}
@@ -18,8 +18,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
 )
 
 // request models a request to the relay server.
@@ -67,10 +68,11 @@ func (request *request) encode() string {
 
 // send sends the request to the relay server and returns its response.
 func (request *request) send() (*response, error) {
-	httpResponse, err := http.Post(
+	httpResponse, err := egress.Post(
 		string(request.server),
 		"application/x-www-form-urlencoded",
 		strings.NewReader(request.encode()),
+		egress.PurposeRelay,
 	)
 	if err != nil {
 		return nil, err
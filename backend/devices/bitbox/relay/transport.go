@@ -0,0 +1,75 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/base64"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Transport delivers the encrypted messages of a channel between the desktop and the mobile. The
+// default transport is the relay server, see Server.
+type Transport interface {
+	// Push sends the encrypted payload from the sender to the other party of the channel.
+	Push(sender Party, channel *Channel, payload string) error
+
+	// Pull returns the oldest encrypted payload on the channel which was sent to the receiver, or
+	// an empty string if none arrived within the polling interval of the transport.
+	Pull(receiver Party, channel *Channel) (string, error)
+}
+
+// Push implements Transport.
+func (server Server) Push(sender Party, channel *Channel, payload string) error {
+	request := &request{
+		server:  server,
+		command: PushMessageCommand,
+		sender:  sender,
+		channel: channel,
+		content: &payload,
+	}
+	response, err := request.send()
+	if err != nil {
+		return err
+	}
+	return response.getErrorIfNok()
+}
+
+// Pull implements Transport. The relay server waits up to 10 seconds for a message.
+func (server Server) Pull(receiver Party, channel *Channel) (string, error) {
+	request := &request{
+		server:  server,
+		command: PullOldestMessageCommand,
+		sender:  receiver,
+		channel: channel,
+	}
+	response, err := request.send()
+	if err != nil {
+		return "", err
+	}
+	if response.Status == "ok" && len(response.Data) > 0 {
+		return response.Data[0].Payload, nil
+	}
+	return "", response.getErrorIfNok()
+}
+
+// decodePayload decodes a payload pulled from a transport.
+func decodePayload(payload string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return decoded, nil
+}
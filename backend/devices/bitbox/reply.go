@@ -0,0 +1,217 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ReplyError is returned if a reply of the device does not match the schema of the command, e.g.
+// because a field is missing or has the wrong type.
+type ReplyError struct {
+	Command string
+	// Path locates the offending value in the reply, e.g. "sign[2].sig".
+	Path   string
+	Reason string
+}
+
+// Error implements the error interface.
+func (err *ReplyError) Error() string {
+	if err.Path == "" {
+		return fmt.Sprintf("unexpected reply to %s: %s", err.Command, err.Reason)
+	}
+	return fmt.Sprintf("unexpected reply to %s: %s: %s", err.Command, err.Path, err.Reason)
+}
+
+// replyField is a field of a reply struct with its schema.
+type replyField struct {
+	name     string
+	index    []int
+	optional bool
+	// constant is the value a string field must have, if not empty.
+	constant string
+}
+
+// replyFields returns the fields of the struct type by their JSON name. Fields of embedded structs
+// are included, unless shadowed by a field of the same name in the outer struct, like with
+// encoding/json. The schema of a field is given by the `schema` tag:
+//
+//	schema:"optional"      the field may be missing in the reply
+//	schema:"const=success" the field must be the string "success"
+func replyFields(typ reflect.Type) []*replyField {
+	fields := []*replyField{}
+	names := map[string]struct{}{}
+	embedded := []*replyField{}
+	for index := 0; index < typ.NumField(); index++ {
+		structField := typ.Field(index)
+		if structField.Anonymous && structField.Type.Kind() == reflect.Struct {
+			for _, field := range replyFields(structField.Type) {
+				field.index = append([]int{index}, field.index...)
+				embedded = append(embedded, field)
+			}
+			continue
+		}
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "-" || structField.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+		field := &replyField{name: name, index: []int{index}}
+		for _, option := range strings.Split(structField.Tag.Get("schema"), ",") {
+			switch {
+			case option == "optional":
+				field.optional = true
+			case strings.HasPrefix(option, "const="):
+				field.constant = strings.TrimPrefix(option, "const=")
+			}
+		}
+		names[name] = struct{}{}
+		fields = append(fields, field)
+	}
+	for _, field := range embedded {
+		if _, ok := names[field.name]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// decodeReply decodes the reply of the device to the command into the response, which must be a
+// pointer to a struct. Unlike json.Unmarshal, all fields are required unless tagged as optional,
+// and values of the wrong type are rejected, so that a malformed reply results in a *ReplyError
+// instead of zero values. Fields of the reply which are not in the response are ignored.
+func decodeReply(command string, reply map[string]interface{}, response interface{}) error {
+	if reply == nil {
+		reply = map[string]interface{}{}
+	}
+	target := reflect.ValueOf(response)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		panic("the response must be a pointer to a struct")
+	}
+	return decodeValue(command, "", reply, target.Elem())
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func decodeValue(command, path string, value interface{}, target reflect.Value) error {
+	mismatch := func(expected string) error {
+		return &ReplyError{
+			Command: command,
+			Path:    path,
+			Reason:  fmt.Sprintf("expected %s, got %s", expected, typeName(value)),
+		}
+	}
+	switch target.Kind() {
+	case reflect.Interface:
+		if value != nil {
+			target.Set(reflect.ValueOf(value))
+		}
+	case reflect.Ptr:
+		if value == nil {
+			return nil
+		}
+		target.Set(reflect.New(target.Type().Elem()))
+		return decodeValue(command, path, value, target.Elem())
+	case reflect.String:
+		str, ok := value.(string)
+		if !ok {
+			return mismatch("string")
+		}
+		target.SetString(str)
+	case reflect.Bool:
+		boolean, ok := value.(bool)
+		if !ok {
+			return mismatch("boolean")
+		}
+		target.SetBool(boolean)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) || target.OverflowInt(int64(number)) {
+			return mismatch("integer")
+		}
+		target.SetInt(int64(number))
+	case reflect.Float32, reflect.Float64:
+		number, ok := value.(float64)
+		if !ok {
+			return mismatch("number")
+		}
+		target.SetFloat(number)
+	case reflect.Slice:
+		elements, ok := value.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		slice := reflect.MakeSlice(target.Type(), len(elements), len(elements))
+		for index, element := range elements {
+			if err := decodeValue(
+				command, fmt.Sprintf("%s[%d]", path, index), element, slice.Index(index)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch("object")
+		}
+		for _, field := range replyFields(target.Type()) {
+			fieldPath := field.name
+			if path != "" {
+				fieldPath = path + "." + field.name
+			}
+			fieldValue, ok := object[field.name]
+			if !ok {
+				if field.optional {
+					continue
+				}
+				return &ReplyError{Command: command, Path: fieldPath, Reason: "missing"}
+			}
+			if field.constant != "" && fieldValue != field.constant {
+				return &ReplyError{
+					Command: command,
+					Path:    fieldPath,
+					Reason:  fmt.Sprintf("expected %q, got %v", field.constant, fieldValue),
+				}
+			}
+			if err := decodeValue(command, fieldPath, fieldValue, target.FieldByIndex(field.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unsupported reply type %s", target.Type()))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+)
+
+func parseReply(t *testing.T, reply string) map[string]interface{} {
+	result := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(reply), &result))
+	return result
+}
+
+func TestDecodeReply(t *testing.T) {
+	signature := jsonp.MustMarshal(string(make([]byte, 128)))
+
+	reply := &signReply{}
+	require.NoError(t, decodeReply("sign",
+		parseReply(t, `{"sign": [{"sig": `+string(signature)+`, "recid": "00"}]}`), reply))
+	require.Len(t, reply.Sign, 1)
+
+	tests := []struct {
+		reply string
+		path  string
+	}{
+		{`{}`, "sign"},
+		{`{"sign": "success"}`, "sign"},
+		{`{"sign": [{"sig": "ab"}, {}]}`, "sign[1].sig"},
+		{`{"sign": [{"sig": 1}]}`, "sign[0].sig"},
+	}
+	for _, test := range tests {
+		err := decodeReply("sign", parseReply(t, test.reply), &signReply{})
+		require.IsType(t, &ReplyError{}, err, test.reply)
+		require.Equal(t, test.path, err.(*ReplyError).Path, test.reply)
+	}
+}
+
+func TestDecodeReplySchema(t *testing.T) {
+	require.NoError(t, decodeReply("reset", parseReply(t, `{"reset": "success"}`), &resetReply{}))
+	err := decodeReply("reset", parseReply(t, `{"reset": "failed"}`), &resetReply{})
+	require.EqualError(t, err, `unexpected reply to reset: reset: expected "success", got failed`)
+
+	// Optional fields may be missing, and a missing reply is an empty object.
+	echo := &signEchoReply{}
+	require.NoError(t, decodeReply("sign", nil, echo))
+	require.Empty(t, echo.Echo)
+	require.NoError(t, decodeReply("sign", parseReply(t, `{"echo": "abc"}`), echo))
+	require.Equal(t, "abc", echo.Echo)
+}
+
+func TestDecodeDeviceInfoReply(t *testing.T) {
+	deviceInfo := `{"version": "v4.0.0", "serial": "s", "id": "i", "TFA": "", "bootlock": true,
+"name": "n", "sdcard": false, "lock": false, "U2F": true, "seeded": true`
+
+	reply := &deviceInfoReply{}
+	require.NoError(t, decodeReply("device", parseReply(t, `{"device": `+deviceInfo+`}}`), reply))
+	require.Equal(t, "v4.0.0", reply.Device.Version)
+	require.True(t, reply.Device.Bootlock)
+	require.Nil(t, reply.Device.U2FHijack)
+
+	reply = &deviceInfoReply{}
+	require.NoError(t, decodeReply("device",
+		parseReply(t, `{"device": `+deviceInfo+`, "U2F_hijack": true}}`), reply))
+	require.True(t, *reply.Device.U2FHijack)
+
+	err := decodeReply("device",
+		parseReply(t, `{"device": {"version": "v4.0.0", "serial": "s"}}`), &deviceInfoReply{})
+	require.EqualError(t, err, "unexpected reply to device: device.id: missing")
+}
+
+func TestCommandName(t *testing.T) {
+	require.Equal(t, "backup", commandName(jsonp.MustMarshal(&backupRequest{})))
+	require.Equal(t, "ecdh", commandName(jsonp.MustMarshal(&ecdhRequest{})))
+	require.Equal(t, "", commandName([]byte("invalid")))
+}
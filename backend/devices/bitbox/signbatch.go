@@ -0,0 +1,70 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// SignRequest contains the signature hashes of one transaction, see SignBatch().
+type SignRequest struct {
+	TxProposal      *maketx.TxProposal
+	SignatureHashes [][]byte
+	KeyPaths        []string
+}
+
+// SignBatch signs multiple transactions in one session. The device info is loaded once and no
+// other signing request is handled in between, but the user confirms each transaction on the
+// device. EventSignBatchProgress is fired before each transaction. Signing stops at the first
+// error, e.g. if the user aborts a transaction, and the signatures of the transactions signed so
+// far are returned with the error.
+func (dbb *Device) SignBatch(requests []*SignRequest) ([][]btcec.Signature, error) {
+	if dbb.bootloaderStatus != nil {
+		return nil, errp.WithStack(errNoBootloader)
+	}
+	for _, request := range requests {
+		if len(request.SignatureHashes) == 0 || len(request.SignatureHashes) != len(request.KeyPaths) {
+			return nil, errp.New("Each transaction needs matching signature hashes and keypaths")
+		}
+	}
+	dbb.signMu.Lock()
+	defer dbb.signMu.Unlock()
+	dbb.checkConnection()
+	deviceInfo, err := dbb.DeviceInfo()
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to load the device info for signing.")
+	}
+	dbb.log.WithField("transactions", len(requests)).Info("Sign batch")
+	signatures := [][]btcec.Signature{}
+	for index, request := range requests {
+		dbb.fireEvent(EventSignBatchProgress, struct {
+			Transaction  int `json:"transaction"`
+			Transactions int `json:"transactions"`
+		}{
+			Transaction:  index,
+			Transactions: len(requests),
+		})
+		transactionSignatures, err := dbb.sign(
+			deviceInfo, request.TxProposal, request.SignatureHashes, request.KeyPaths)
+		if err != nil {
+			return signatures, err
+		}
+		signatures = append(signatures, transactionSignatures)
+	}
+	return signatures, nil
+}
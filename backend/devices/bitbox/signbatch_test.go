@@ -0,0 +1,65 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitbox
+
+import (
+	"encoding/hex"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *dbbTestSuite) TestSignBatch() {
+	require.NoError(s.T(), s.login())
+	_, err := s.dbb.SignBatch([]*SignRequest{{}})
+	require.Error(s.T(), err)
+
+	requests := []*SignRequest{}
+	for _, keyPath := range []string{"m/44'/0'/0'/0/0", "m/44'/0'/1'/0/0"} {
+		signatureHash := []byte{0, 0, 0, 0, 0}
+		element := map[string]interface{}{"hash": hex.EncodeToString(signatureHash), "keypath": keyPath}
+		sign := map[string]interface{}{"sign": map[string]interface{}{"data": []interface{}{element}}}
+		s.mockCommunication.On("SendEncrypt", mock.Anything, jsonArgumentMatcher(sign), pin).
+			Return(nil, nil).Once()
+		requests = append(requests, &SignRequest{
+			SignatureHashes: [][]byte{signatureHash},
+			KeyPaths:        []string{keyPath},
+		})
+	}
+	responseSignature := hex.EncodeToString(make([]byte, 64))
+	s.mockCommunication.On(
+		"SendEncrypt",
+		mock.Anything,
+		jsonArgumentMatcher(map[string]interface{}{"sign": ""}),
+		pin,
+	).
+		Return(map[string]interface{}{"sign": []interface{}{map[string]interface{}{"sig": responseSignature}}}, nil).
+		Twice()
+	// The device info is loaded once for the whole batch.
+	s.mockDeviceInfo()
+
+	progress := []interface{}{}
+	s.dbb.SetOnEvent(func(event device.Event, data interface{}) {
+		if event == EventSignBatchProgress {
+			progress = append(progress, data)
+		}
+	})
+	signatures, err := s.dbb.SignBatch(requests)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), signatures, 2)
+	require.Len(s.T(), signatures[1], 1)
+	require.Len(s.T(), progress, 2)
+}
@@ -22,6 +22,10 @@ const (
 	// used.
 	StatusBootloader Status = "bootloader"
 
+	// StatusBootloaderRecovery means that the device is in bootloader mode unexpectedly, e.g. after
+	// an interrupted firmware upgrade. Use BootloaderRecover() to re-flash the firmware.
+	StatusBootloaderRecovery Status = "bootloader_recovery"
+
 	// StatusUninitialized is the uninitialized device, i.e. unseeded and no password set.
 	// Use SetPassword() to proceed to StatusLoggedIn.
 	StatusUninitialized Status = "uninitialized"
@@ -0,0 +1,44 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ble
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+)
+
+// NewDevice creates a device communicating over the given transport. The device is registered
+// with the backend by the platform, like the devices found by the usb.Manager. Firmware upgrades
+// are not supported over BLE, as the bootloader is only reachable over USB.
+func NewDevice(
+	deviceID string,
+	firmwareVersion *semver.SemVer,
+	channelConfigDir string,
+	transport *Transport,
+) (*bitbox.Device, error) {
+	device, err := bitbox.NewDevice(
+		deviceID,
+		false,
+		firmwareVersion,
+		channelConfigDir,
+		usb.NewTransportCommunication(transport),
+	)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to establish communication to device")
+	}
+	return device, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ble implements the communication with hardware wallets over Bluetooth Low Energy. The
+// connection itself is managed by the BLE stack of the platform, e.g. by the mobile app.
+package ble
+
+import (
+	"io"
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// attHeaderSize is the size of the ATT header of a characteristic value, which is not part of
+	// the payload.
+	attHeaderSize = 3
+	// minMTU is the smallest ATT MTU, which every BLE device supports.
+	minMTU = 23
+	// notificationBufferSize is the number of notifications buffered until they are read.
+	notificationBufferSize = 64
+)
+
+// Peripheral is a connected BLE hardware wallet. It is implemented by the BLE stack of the
+// platform. Requests are written to a write characteristic, and the device replies with
+// notifications of a notify characteristic, which the platform passes to Transport.Notify().
+type Peripheral interface {
+	// Write writes the value to the write characteristic, with response.
+	Write(value []byte) error
+	// MTU returns the negotiated ATT MTU.
+	MTU() int
+	// Disconnect closes the connection.
+	Disconnect() error
+}
+
+// link adapts the characteristics of a peripheral to the reports read and written by the
+// usb.ReportTransport.
+type link struct {
+	peripheral    Peripheral
+	notifications chan []byte
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// Write implements io.Writer.
+func (link *link) Write(report []byte) (int, error) {
+	select {
+	case <-link.closed:
+		return 0, errp.New("BLE connection closed")
+	default:
+	}
+	if err := link.peripheral.Write(report); err != nil {
+		return 0, errp.WithMessage(err, "Failed to write the characteristic")
+	}
+	return len(report), nil
+}
+
+// Read implements io.Reader. It blocks until the next notification.
+func (link *link) Read(report []byte) (int, error) {
+	select {
+	case value := <-link.notifications:
+		return copy(report, value), nil
+	case <-link.closed:
+		return 0, io.EOF
+	}
+}
+
+// Close implements io.Closer.
+func (link *link) Close() error {
+	link.closeOnce.Do(func() { close(link.closed) })
+	return link.peripheral.Disconnect()
+}
+
+// Transport implements usb.Transport over BLE. The U2FHID frames are split into characteristic
+// values of the size of the ATT payload, the same way they are split into USB HID reports.
+type Transport struct {
+	link   *link
+	frames *usb.ReportTransport
+}
+
+// NewTransport creates a new transport to the connected peripheral.
+func NewTransport(peripheral Peripheral) (*Transport, error) {
+	mtu := peripheral.MTU()
+	if mtu < minMTU {
+		return nil, errp.Newf("invalid ATT MTU %d", mtu)
+	}
+	link := &link{
+		peripheral:    peripheral,
+		notifications: make(chan []byte, notificationBufferSize),
+		closed:        make(chan struct{}),
+	}
+	reportSize := mtu - attHeaderSize
+	return &Transport{
+		link:   link,
+		frames: usb.NewReportTransport(link, reportSize, reportSize),
+	}, nil
+}
+
+// Notify passes a value notified by the device to the transport. It must be called by the BLE stack
+// for each notification, in order. Notifications after the transport was closed are dropped.
+func (transport *Transport) Notify(value []byte) {
+	value = append([]byte(nil), value...)
+	select {
+	case transport.link.notifications <- value:
+	case <-transport.link.closed:
+	}
+}
+
+// SendFrame implements usb.Transport.
+func (transport *Transport) SendFrame(cid uint32, cmd byte, msg []byte) error {
+	return transport.frames.SendFrame(cid, cmd, msg)
+}
+
+// ReadFrame implements usb.Transport.
+func (transport *Transport) ReadFrame(cid uint32, cmd byte) ([]byte, error) {
+	return transport.frames.ReadFrame(cid, cmd)
+}
+
+// Close implements usb.Transport.
+func (transport *Transport) Close() error {
+	return transport.frames.Close()
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ble
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+)
+
+// echoPeripheral notifies every written value back, so that each command is answered with itself.
+type echoPeripheral struct {
+	transport    *Transport
+	values       [][]byte
+	disconnected bool
+}
+
+func (peripheral *echoPeripheral) Write(value []byte) error {
+	peripheral.values = append(peripheral.values, value)
+	peripheral.transport.Notify(value)
+	return nil
+}
+
+func (peripheral *echoPeripheral) MTU() int {
+	return minMTU
+}
+
+func (peripheral *echoPeripheral) Disconnect() error {
+	peripheral.disconnected = true
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	_, err := NewTransport(&mtuPeripheral{mtu: minMTU - 1})
+	require.Error(t, err)
+
+	peripheral := &echoPeripheral{}
+	transport, err := NewTransport(peripheral)
+	require.NoError(t, err)
+	peripheral.transport = transport
+	communication := usb.NewTransportCommunication(transport)
+	communication.DisableChannelAllocation()
+
+	const msg = `{"ping":"a message spanning several characteristic values"}`
+	reply, err := communication.SendPlain(context.Background(), msg)
+	require.NoError(t, err)
+	require.Equal(t, "a message spanning several characteristic values", reply["ping"])
+	// One init frame with 13 bytes of the message, and continuation frames with 15 bytes each.
+	require.Len(t, peripheral.values, 5)
+	for _, value := range peripheral.values {
+		require.Len(t, value, minMTU-attHeaderSize)
+	}
+
+	communication.Close()
+	require.True(t, peripheral.disconnected)
+	_, err = communication.SendPlain(context.Background(), msg)
+	require.Error(t, err)
+	_, err = communication.SendBootloader(context.Background(), []byte("v"))
+	require.Error(t, err)
+}
+
+type mtuPeripheral struct {
+	echoPeripheral
+	mtu int
+}
+
+func (peripheral *mtuPeripheral) MTU() int {
+	return peripheral.mtu
+}
@@ -33,6 +33,14 @@ const (
 	EventKeystoreGone Event = "keystoreGone"
 )
 
+// Fingerprinter is implemented by devices which can identify themselves, e.g. to recognize them
+// when they are connected again.
+type Fingerprinter interface {
+	// Fingerprint returns an identifier of the device which does not change across connections, or
+	// an empty string if it is not known, e.g. because the device is locked.
+	Fingerprint() string
+}
+
 // Interface represents a hardware wallet device.
 type Interface interface {
 	Init(testing bool)
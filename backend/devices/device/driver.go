@@ -0,0 +1,63 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import (
+	"sort"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// Driver detects the devices of one kind of hardware wallet. Devices other than the BitBox, which
+// is detected by the USB manager, are integrated by registering a driver with RegisterDriver(),
+// usually in the init function of the driver package. The backend then handles their devices like
+// the BitBox: the keystores of unlocked devices are used for the accounts, see
+// Interface.KeystoreForConfiguration().
+type Driver interface {
+	// Name identifies the driver, e.g. the product name of its devices.
+	Name() string
+
+	// Start starts detecting devices. register must be called with each device which is
+	// connected, and deregister with the identifier of each device which is disconnected.
+	Start(register func(Interface) error, deregister func(deviceID string)) error
+}
+
+var (
+	drivers     = map[string]Driver{}
+	driversLock locker.Locker
+)
+
+// RegisterDriver makes the driver available to the backend, which starts it when the backend is
+// started. An error is returned if a driver with the same name was registered before.
+func RegisterDriver(driver Driver) error {
+	defer driversLock.Lock()()
+	if _, ok := drivers[driver.Name()]; ok {
+		return errp.Newf("a device driver named %s is already registered", driver.Name())
+	}
+	drivers[driver.Name()] = driver
+	return nil
+}
+
+// Drivers returns the registered drivers, sorted by name.
+func Drivers() []Driver {
+	defer driversLock.RLock()()
+	result := []Driver{}
+	for _, driver := range drivers {
+		result = append(result, driver)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// batchCommand is the name of the command packing several encrypted commands, see
+// SendEncryptBatch().
+const batchCommand = "batch"
+
+// batchUnsupported returns true if the device rejected a batch before, in which case the commands
+// of a batch are sent one by one.
+func (communication *Communication) batchUnsupported() bool {
+	return atomic.LoadInt32(&communication.noBatch) == 1
+}
+
+// batchMessage packs the commands into {"batch": [command, ...]}.
+func batchMessage(msgs []string) (string, error) {
+	commands := make([]json.RawMessage, len(msgs))
+	for i, msg := range msgs {
+		if !json.Valid([]byte(msg)) {
+			return "", errp.Newf("Invalid JSON in command %d of the batch", i)
+		}
+		commands[i] = json.RawMessage(msg)
+	}
+	batch, err := json.Marshal(map[string][]json.RawMessage{batchCommand: commands})
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	return string(batch), nil
+}
+
+// batchReplies unpacks the reply {"batch": [reply, ...]} to a batch of count commands. If one of
+// the replies contains an error field, it is returned as a DBBErr.
+func batchReplies(jsonResult map[string]interface{}, count int) ([]map[string]interface{}, error) {
+	replies, ok := jsonResult[batchCommand].([]interface{})
+	if !ok || len(replies) != count {
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "unexpected batch reply"})
+	}
+	result := make([]map[string]interface{}, count)
+	for i, reply := range replies {
+		replyMap, ok := reply.(map[string]interface{})
+		if !ok {
+			return nil, errp.WithStack(&CorruptReplyErr{Reason: "unexpected batch reply"})
+		}
+		if err := maybeDBBErr(replyMap); err != nil {
+			return nil, err
+		}
+		result[i] = replyMap
+	}
+	return result, nil
+}
+
+// SendEncryptBatch sends several encrypted commands in one round trip and returns their replies in
+// the same order. The device executes the commands of a batch all or nothing: if one of them fails,
+// the device replies with its error as a DBBErr and none of them takes effect.
+//
+// Firmware which does not support batches rejects them as an invalid command. The commands are then
+// sent one by one with SendEncrypt(), stopping at the first error, so the commands sent before that
+// did take effect. Callers relying on the atomicity must only batch commands without side effects.
+func (communication *Communication) SendEncryptBatch(
+	ctx context.Context, msgs []string, password string) ([]map[string]interface{}, error) {
+	if len(msgs) == 0 {
+		return []map[string]interface{}{}, nil
+	}
+	if !communication.batchUnsupported() {
+		replies, err := communication.sendEncryptBatch(ctx, msgs, password)
+		if dbbErr, ok := errp.Cause(err).(*bitbox.Error); !ok || dbbErr.Code != bitbox.ErrIOInvalidCmd {
+			return replies, err
+		}
+		communication.log.Info("The device does not support batches, sending the commands one by one")
+		atomic.StoreInt32(&communication.noBatch, 1)
+	}
+	replies := make([]map[string]interface{}, len(msgs))
+	for i, msg := range msgs {
+		reply, err := communication.SendEncrypt(ctx, msg, password)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (communication *Communication) sendEncryptBatch(
+	ctx context.Context, msgs []string, password string) ([]map[string]interface{}, error) {
+	start := time.Now()
+	msg, err := batchMessage(msgs)
+	if err != nil {
+		return nil, err
+	}
+	// A corrupt reply of the batch is only retransmitted if all of its commands can be.
+	idempotent := true
+	for _, msg := range msgs {
+		idempotent = idempotent && idempotentCommands[commandName(msg)]
+	}
+	var replies []map[string]interface{}
+	err = communication.withTimeout(ctx, batchCommand, func(ctx context.Context) error {
+		return communication.retransmitIf(ctx, batchCommand, idempotent, func() error {
+			jsonResult, err := communication.sendEncrypt(ctx, msg, password)
+			if err != nil {
+				return err
+			}
+			replies, err = batchReplies(jsonResult, len(msgs))
+			return err
+		})
+	})
+	communication.recordRoundTrip(batchCommand, start, err)
+	return replies, err
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+)
+
+// batchDevice implements Transport and replies to each encrypted command with the command itself.
+// Batches are answered with the batch of the commands, or rejected as an invalid command if
+// supportsBatch is false. Commands named "fail" are answered with an error.
+type batchDevice struct {
+	t             *testing.T
+	supportsBatch bool
+	commands      []map[string]interface{}
+	reply         []byte
+}
+
+func (device *batchDevice) SendFrame(cid uint32, cmd byte, msg []byte) error {
+	secret := chainhash.DoubleHashB([]byte("password"))
+	cipherText, err := base64.StdEncoding.DecodeString(string(msg))
+	require.NoError(device.t, err)
+	plainText, err := crypto.Decrypt(cipherText, secret)
+	require.NoError(device.t, err)
+	command := map[string]interface{}{}
+	require.NoError(device.t, json.Unmarshal(plainText, &command))
+	delete(command, nonceKey)
+	device.commands = append(device.commands, command)
+	errorReply := map[string]interface{}{"error": map[string]interface{}{"message": "failed", "code": 108}}
+	var reply interface{} = command
+	if batch, ok := command[batchCommand]; ok {
+		if !device.supportsBatch {
+			reply = map[string]interface{}{"error": map[string]interface{}{
+				"message": "Invalid command", "code": bitbox.ErrIOInvalidCmd}}
+		} else {
+			for _, batchCmd := range batch.([]interface{}) {
+				if _, ok := batchCmd.(map[string]interface{})["fail"]; ok {
+					reply = errorReply
+				}
+			}
+		}
+	} else if _, ok := command["fail"]; ok {
+		reply = errorReply
+	}
+	cipherText, err = crypto.Encrypt(jsonp.MustMarshal(reply), secret)
+	require.NoError(device.t, err)
+	device.reply = jsonp.MustMarshal(map[string]string{
+		"ciphertext": base64.StdEncoding.EncodeToString(cipherText),
+	})
+	return nil
+}
+
+func (device *batchDevice) ReadFrame(cid uint32, cmd byte) ([]byte, error) {
+	return device.reply, nil
+}
+
+func (device *batchDevice) Close() error {
+	return nil
+}
+
+func TestSendEncryptBatch(t *testing.T) {
+	device := &batchDevice{t: t, supportsBatch: true}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	msgs := []string{`{"xpub":"m/0"}`, `{"xpub":"m/1"}`}
+	replies, err := communication.SendEncryptBatch(context.Background(), msgs, "password")
+	require.NoError(t, err)
+	require.Equal(t, []map[string]interface{}{{"xpub": "m/0"}, {"xpub": "m/1"}}, replies)
+	// One round trip for both commands.
+	require.Len(t, device.commands, 1)
+
+	// If one command fails, the whole batch fails.
+	_, err = communication.SendEncryptBatch(
+		context.Background(), []string{`{"xpub":"m/0"}`, `{"fail":""}`}, "password")
+	dbbErr, ok := errp.Cause(err).(*bitbox.Error)
+	require.True(t, ok)
+	require.Equal(t, float64(108), dbbErr.Code)
+
+	replies, err = communication.SendEncryptBatch(context.Background(), []string{}, "password")
+	require.NoError(t, err)
+	require.Empty(t, replies)
+}
+
+func TestSendEncryptBatchUnsupported(t *testing.T) {
+	device := &batchDevice{t: t}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	msgs := []string{`{"xpub":"m/0"}`, `{"xpub":"m/1"}`}
+	replies, err := communication.SendEncryptBatch(context.Background(), msgs, "password")
+	require.NoError(t, err)
+	require.Equal(t, []map[string]interface{}{{"xpub": "m/0"}, {"xpub": "m/1"}}, replies)
+	// The rejected batch, followed by the commands one by one.
+	require.Len(t, device.commands, 3)
+
+	// The batch is not tried again.
+	_, err = communication.SendEncryptBatch(context.Background(), msgs, "password")
+	require.NoError(t, err)
+	require.Len(t, device.commands, 5)
+}
@@ -0,0 +1,250 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+const (
+	// pcapLinkTypeUser0 is the first of the link types reserved for private use. Each packet is a
+	// captureDirection byte, the channel ID (4 bytes, big endian), the command byte and the payload.
+	pcapLinkTypeUser0 = 147
+	// pcapSnapLen is the maximum packet size declared in the pcap header.
+	pcapSnapLen = 65535
+
+	// captureOut marks the frames sent to the device.
+	captureOut byte = 0
+	// captureIn marks the frames received from the device.
+	captureIn byte = 1
+
+	// captureBootloaderCmd is the command byte of the captured bootloader messages, which are not
+	// framed. It is not a valid U2FHID command.
+	captureBootloaderCmd = 0
+	// captureBootloaderPrefix is the number of bytes of each bootloader message which are
+	// captured. The rest is firmware data, and padding.
+	captureBootloaderPrefix = 16
+)
+
+// pcapMagic identifies pcap files with microsecond timestamps.
+const pcapMagic = 0xa1b2c3d4
+
+// pcapHeader is the global header at the start of a pcap file.
+type pcapHeader struct {
+	Magic        uint32
+	VersionMajor uint16
+	VersionMinor uint16
+	// TimezoneOffset and Accuracy are always 0.
+	TimezoneOffset int32
+	Accuracy       uint32
+	SnapLen        uint32
+	LinkType       uint32
+}
+
+// pcapRecordHeader precedes each packet in a pcap file.
+type pcapRecordHeader struct {
+	Seconds        uint32
+	Microseconds   uint32
+	CapturedLength uint32
+	Length         uint32
+}
+
+// captureRedactedKeys are the keys in the JSON messages whose values are not captured.
+var captureRedactedKeys = []string{"ciphertext", "password"}
+
+// Capture records the frames exchanged with the devices in a pcap file, for debugging. Encrypted
+// payloads and passwords are redacted. When the file exceeds the maximum size, it is moved to the
+// same name with the suffix ".1", replacing the previous one, and a new file is started. It is safe
+// for concurrent use.
+type Capture struct {
+	filename string
+	maxSize  int64
+
+	// file is the open capture file, or nil if capturing is stopped.
+	file   *os.File
+	size   int64
+	frames int
+	lock   locker.Locker
+}
+
+// CaptureStatus describes the state of a Capture.
+type CaptureStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Filename string `json:"filename"`
+	// Frames is the number of frames captured since capturing was started.
+	Frames int `json:"frames"`
+}
+
+// NewCapture creates a stopped capture which writes to the given file, rotating it when it would
+// exceed maxSize bytes.
+func NewCapture(filename string, maxSize int64) *Capture {
+	return &Capture{filename: filename, maxSize: maxSize}
+}
+
+// create starts a new capture file. Must be called with the lock held.
+func (capture *Capture) create() error {
+	file, err := os.OpenFile(capture.filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	header := new(bytes.Buffer)
+	_ = binary.Write(header, binary.LittleEndian, &pcapHeader{
+		Magic:        pcapMagic,
+		VersionMajor: 2,
+		VersionMinor: 4,
+		SnapLen:      pcapSnapLen,
+		LinkType:     pcapLinkTypeUser0,
+	})
+	if _, err := file.Write(header.Bytes()); err != nil {
+		_ = file.Close()
+		return errp.WithStack(err)
+	}
+	capture.file = file
+	capture.size = int64(header.Len())
+	return nil
+}
+
+// Start starts capturing into a new file. It does nothing if capturing is already started.
+func (capture *Capture) Start() error {
+	defer capture.lock.Lock()()
+	if capture.file != nil {
+		return nil
+	}
+	capture.frames = 0
+	return capture.create()
+}
+
+// Stop stops capturing and closes the file.
+func (capture *Capture) Stop() error {
+	defer capture.lock.Lock()()
+	if capture.file == nil {
+		return nil
+	}
+	err := capture.file.Close()
+	capture.file = nil
+	return errp.WithStack(err)
+}
+
+// Status returns whether capturing is started.
+func (capture *Capture) Status() *CaptureStatus {
+	defer capture.lock.RLock()()
+	return &CaptureStatus{
+		Enabled:  capture.file != nil,
+		Filename: capture.filename,
+		Frames:   capture.frames,
+	}
+}
+
+// redactedPayload returns the payload to capture for a frame.
+func redactedPayload(cmd byte, payload []byte) []byte {
+	switch cmd {
+	case captureBootloaderCmd:
+		if len(payload) > captureBootloaderPrefix {
+			return payload[:captureBootloaderPrefix]
+		}
+		return payload
+	case hwwCMD:
+		message := map[string]interface{}{}
+		if err := json.Unmarshal(bytes.TrimRight(payload, " \t\r\n\x00"), &message); err != nil {
+			// Encrypted commands are sent as base64 encoded cipher text.
+			return []byte(fmt.Sprintf("[%d bytes redacted]", len(payload)))
+		}
+		for _, key := range captureRedactedKeys {
+			if value, ok := message[key]; ok {
+				message[key] = fmt.Sprintf("[%d bytes redacted]", len(fmt.Sprint(value)))
+			}
+		}
+		redacted, err := json.Marshal(message)
+		if err != nil {
+			return []byte(fmt.Sprintf("[%d bytes redacted]", len(payload)))
+		}
+		return redacted
+	default:
+		return payload
+	}
+}
+
+// record captures a frame if capturing is started. Errors are ignored, as capturing must not
+// interfere with the communication.
+func (capture *Capture) record(direction byte, cid uint32, cmd byte, payload []byte) {
+	if capture == nil {
+		return
+	}
+	defer capture.lock.Lock()()
+	if capture.file == nil {
+		return
+	}
+	packet := new(bytes.Buffer)
+	packet.WriteByte(direction)
+	_ = binary.Write(packet, binary.BigEndian, cid)
+	packet.WriteByte(cmd)
+	packet.Write(redactedPayload(cmd, payload))
+	if packet.Len() > pcapSnapLen {
+		packet.Truncate(pcapSnapLen)
+	}
+	now := time.Now()
+	record := new(bytes.Buffer)
+	_ = binary.Write(record, binary.LittleEndian, &pcapRecordHeader{
+		Seconds:        uint32(now.Unix()),
+		Microseconds:   uint32(now.Nanosecond() / 1000),
+		CapturedLength: uint32(packet.Len()),
+		Length:         uint32(packet.Len()),
+	})
+	record.Write(packet.Bytes())
+	if capture.size+int64(record.Len()) > capture.maxSize {
+		_ = capture.file.Close()
+		capture.file = nil
+		if err := os.Rename(capture.filename, capture.filename+".1"); err != nil {
+			return
+		}
+		if err := capture.create(); err != nil {
+			return
+		}
+	}
+	if _, err := capture.file.Write(record.Bytes()); err != nil {
+		return
+	}
+	capture.size += int64(record.Len())
+	capture.frames++
+}
+
+// SetCapture makes the communication record its frames in the given capture. It must be called
+// before the communication is used. Channels created afterwards share the capture.
+func (communication *Communication) SetCapture(capture *Capture) {
+	communication.capture = capture
+}
+
+// sendFrame sends a frame over the transport and captures it.
+func (communication *Communication) sendFrame(cid uint32, cmd byte, msg []byte) error {
+	communication.capture.record(captureOut, cid, cmd, msg)
+	return communication.transport.SendFrame(cid, cmd, msg)
+}
+
+// readFrame reads a frame from the transport and captures it.
+func (communication *Communication) readFrame(cid uint32, cmd byte) ([]byte, error) {
+	msg, err := communication.transport.ReadFrame(cid, cmd)
+	if err == nil {
+		communication.capture.record(captureIn, cid, cmd, msg)
+	}
+	return msg, err
+}
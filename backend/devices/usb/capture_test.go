@@ -0,0 +1,125 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// capturedFrame is a packet read from a capture file.
+type capturedFrame struct {
+	direction byte
+	cid       uint32
+	cmd       byte
+	payload   string
+}
+
+func readCapture(t *testing.T, filename string) []capturedFrame {
+	contents, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	reader := bytes.NewReader(contents)
+	header := pcapHeader{}
+	require.NoError(t, binary.Read(reader, binary.LittleEndian, &header))
+	require.Equal(t, uint32(pcapMagic), header.Magic)
+	require.Equal(t, uint32(pcapLinkTypeUser0), header.LinkType)
+	frames := []capturedFrame{}
+	for reader.Len() > 0 {
+		recordHeader := pcapRecordHeader{}
+		require.NoError(t, binary.Read(reader, binary.LittleEndian, &recordHeader))
+		require.Equal(t, recordHeader.Length, recordHeader.CapturedLength)
+		packet := make([]byte, recordHeader.CapturedLength)
+		_, err := reader.Read(packet)
+		require.NoError(t, err)
+		frames = append(frames, capturedFrame{
+			direction: packet[0],
+			cid:       binary.BigEndian.Uint32(packet[1:]),
+			cmd:       packet[5],
+			payload:   string(packet[6:]),
+		})
+	}
+	return frames
+}
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	filename := path.Join(dir, "usb.pcap")
+
+	capture := NewCapture(filename, 1024*1024)
+	communication := NewCommunication(&u2fDevice{nextCID: 0x100}, 64, 64)
+	communication.SetCapture(capture)
+
+	// Nothing is captured until capturing is started.
+	_, err = communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, &CaptureStatus{Filename: filename}, capture.Status())
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, capture.Start())
+	channel := communication.NewChannel()
+	for _, msg := range []string{`{"password":"secret"}`, "c2VjcmV0IGNvbW1hbmQ="} {
+		_, err = channel.SendPlain(context.Background(), msg)
+		require.NoError(t, err)
+	}
+	require.NoError(t, capture.Stop())
+	require.Equal(t, &CaptureStatus{Filename: filename, Frames: 6}, capture.Status())
+
+	frames := readCapture(t, filename)
+	require.Len(t, frames, 6)
+	require.Equal(t, captureOut, frames[0].direction)
+	require.Equal(t, uint32(u2fHIDBroadcastCID), frames[0].cid)
+	require.Equal(t, byte(u2fHIDInit), frames[0].cmd)
+	require.Equal(t, captureIn, frames[1].direction)
+	require.Equal(t, byte(u2fHIDInit), frames[1].cmd)
+	require.Equal(t, capturedFrame{captureOut, 0x102, hwwCMD, `{"password":"[6 bytes redacted]"}`}, frames[2])
+	require.Equal(t, capturedFrame{captureIn, 0x102, hwwCMD, `{"ping":"password"}`}, frames[3])
+	require.Equal(t, capturedFrame{captureOut, 0x102, hwwCMD, "[20 bytes redacted]"}, frames[4])
+	require.NotContains(t, string(mustReadFile(t, filename)), "secret")
+	require.NotContains(t, string(mustReadFile(t, filename)), "c2VjcmV0")
+}
+
+func TestCaptureRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	filename := path.Join(dir, "usb.pcap")
+
+	// Room for the header and three frames of 40 bytes.
+	capture := NewCapture(filename, 24+3*40)
+	require.NoError(t, capture.Start())
+	for i := 0; i < 5; i++ {
+		capture.record(captureOut, hwwCID, hwwCMD, []byte(`{"ping":"1234567"}`))
+	}
+	require.NoError(t, capture.Stop())
+	require.Len(t, readCapture(t, filename+".1"), 3)
+	require.Len(t, readCapture(t, filename), 2)
+	require.Equal(t, 5, capture.Status().Frames)
+}
+
+func mustReadFile(t *testing.T, filename string) []byte {
+	contents, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	return contents
+}
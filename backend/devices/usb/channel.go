@@ -0,0 +1,146 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// u2fHIDErrInvalidCID is the U2FHID error code if the channel is not allocated.
+	u2fHIDErrInvalidCID = 0x0b
+	// u2fHIDInitNonceSize is the size of the nonce identifying the reply to U2FHID_INIT.
+	u2fHIDInitNonceSize = 8
+	// u2fHIDInitReplySize is the size of the reply to U2FHID_INIT: the nonce, the channel ID, the
+	// protocol version, the device version (3 bytes) and the capabilities.
+	u2fHIDInitReplySize = u2fHIDInitNonceSize + 9
+	// maxForeignFrames bounds the number of frames of other channels skipped while waiting for a
+	// reply.
+	maxForeignFrames = 64
+)
+
+// U2FHIDError is returned if the device replied with a U2FHID_ERROR frame.
+type U2FHIDError struct {
+	Code byte
+}
+
+func (err *U2FHIDError) Error() string {
+	return fmt.Sprintf("U2F HID error %d", err.Code)
+}
+
+// DisableChannelAllocation makes the communication use the fixed channel hwwCID instead of
+// allocating one. This is needed for firmware versions without U2F support. It must be called
+// before the first round trip.
+func (communication *Communication) DisableChannelAllocation() {
+	communication.allocateChannels = false
+}
+
+// NewChannel returns a communication over a new logical channel to the same device. The channels
+// share the transport and take turns, but each one allocates its own channel ID, so their replies
+// can't be confused. Closing any of them closes the transport.
+func (communication *Communication) NewChannel() *Communication {
+	channel := &Communication{
+		transport:        communication.transport,
+		commandQueue:     communication.commandQueue,
+		log:              communication.log,
+		health:           health.NewRecorder(),
+		commandTimeouts:  map[string]time.Duration{},
+		allocateChannels: communication.allocateChannels,
+		capture:          communication.capture,
+		events:           communication.events,
+		// The channel establishes its own Noise channel, as the device keeps one per channel ID.
+		noiseStatic: communication.noiseStatic,
+	}
+	communication.noiseLock.Lock()
+	channel.noisePinned = communication.noisePinned
+	communication.noiseLock.Unlock()
+	if communication.nonces != nil {
+		channel.enableNonces()
+	}
+	return channel
+}
+
+// channelID returns the channel of the communication, allocating it on first use. It must be called
+// during a round trip.
+func (communication *Communication) channelID() (uint32, error) {
+	if !communication.allocateChannels {
+		return hwwCID, nil
+	}
+	if communication.cid == 0 {
+		cid, err := communication.allocateChannel()
+		if err != nil {
+			return 0, errp.WithMessage(err, "Failed to allocate a channel")
+		}
+		communication.log.WithField("cid", fmt.Sprintf("%08x", cid)).Debug("Allocated channel")
+		communication.cid = cid
+	}
+	return communication.cid, nil
+}
+
+// allocateChannel sends U2FHID_INIT on the broadcast channel and returns the channel ID assigned
+// by the device. Replies to the U2FHID_INIT requests of other software are skipped by comparing
+// the nonce.
+func (communication *Communication) allocateChannel() (uint32, error) {
+	nonce := make([]byte, u2fHIDInitNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, errp.WithStack(err)
+	}
+	if err := communication.sendFrame(u2fHIDBroadcastCID, u2fHIDInit, nonce); err != nil {
+		return 0, err
+	}
+	for i := 0; i < maxForeignFrames; i++ {
+		reply, err := communication.readFrame(u2fHIDBroadcastCID, u2fHIDInit)
+		if err != nil {
+			return 0, err
+		}
+		if len(reply) < u2fHIDInitReplySize {
+			return 0, errp.Newf("U2FHID_INIT reply too short (%d bytes)", len(reply))
+		}
+		if !bytes.Equal(reply[:u2fHIDInitNonceSize], nonce) {
+			continue
+		}
+		cid := binary.BigEndian.Uint32(reply[u2fHIDInitNonceSize:])
+		if cid == 0 || cid == u2fHIDBroadcastCID {
+			return 0, errp.Newf("invalid channel %08x", cid)
+		}
+		return cid, nil
+	}
+	return 0, errp.New("no reply to U2FHID_INIT")
+}
+
+// readChannelFrame reads the next frame of the given channel into read and returns its length.
+// Frames of other channels, which belong to other software talking to the device or to other
+// logical channels, are skipped.
+func (transport *ReportTransport) readChannelFrame(read []byte, cid uint32) (int, error) {
+	for i := 0; i < maxForeignFrames; i++ {
+		readLen, err := transport.device.Read(read)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if readLen < 4 || binary.BigEndian.Uint32(read[:4]) == cid {
+			return readLen, nil
+		}
+	}
+	return 0, errors.New("USB command ID mismatch")
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// u2fDevice allocates channels and replies to each command with {"ping":"password"} on the channel
+// of the command. Before each reply, it sends a frame on a channel of other software.
+type u2fDevice struct {
+	nextCID  uint32
+	channels []uint32
+	replies  [][]byte
+}
+
+func u2fFrame(cid uint32, cmd byte, data []byte) []byte {
+	frame := make([]byte, 64)
+	binary.BigEndian.PutUint32(frame, cid)
+	frame[4] = cmd
+	binary.BigEndian.PutUint16(frame[5:], uint16(len(data)))
+	copy(frame[7:], data)
+	return frame
+}
+
+func (device *u2fDevice) Write(p []byte) (int, error) {
+	cid := binary.BigEndian.Uint32(p)
+	if p[4]&u2fHIDTypeInit == 0 {
+		// Continuation frame.
+		return len(p), nil
+	}
+	device.channels = append(device.channels, cid)
+	device.replies = append(device.replies, u2fFrame(0x0a0b0c0d, hwwCMD, []byte(`{"foreign":""}`)))
+	switch p[4] {
+	case u2fHIDInit:
+		device.nextCID++
+		reply := make([]byte, u2fHIDInitReplySize)
+		copy(reply, p[7:7+u2fHIDInitNonceSize])
+		binary.BigEndian.PutUint32(reply[u2fHIDInitNonceSize:], device.nextCID)
+		device.replies = append(device.replies, u2fFrame(cid, u2fHIDInit, reply))
+	case hwwCMD:
+		device.replies = append(device.replies, u2fFrame(cid, hwwCMD, []byte(`{"ping":"password"}`)))
+	}
+	return len(p), nil
+}
+
+func (device *u2fDevice) Read(p []byte) (int, error) {
+	reply := device.replies[0]
+	device.replies = device.replies[1:]
+	return copy(p, reply), nil
+}
+
+func (device *u2fDevice) Close() error {
+	return nil
+}
+
+func TestChannelAllocation(t *testing.T) {
+	device := &u2fDevice{nextCID: 0x100}
+	communication := NewCommunication(device, 64, 64)
+	for i := 0; i < 2; i++ {
+		reply, err := communication.SendPlain(context.Background(), `{"ping":""}`)
+		require.NoError(t, err)
+		require.Equal(t, "password", reply["ping"])
+	}
+	// The channel is allocated once on the broadcast channel.
+	require.Equal(t, []uint32{u2fHIDBroadcastCID, 0x101, 0x101}, device.channels)
+
+	channel := communication.NewChannel()
+	_, err := channel.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{u2fHIDBroadcastCID, 0x102}, device.channels[3:])
+
+	fixed := NewCommunication(device, 64, 64)
+	fixed.DisableChannelAllocation()
+	_, err = fixed.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, uint32(hwwCID), device.channels[5])
+}
@@ -16,147 +16,323 @@ package usb
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
-	"io"
+	"fmt"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 	"unicode"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/pkg/errors"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/queue"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/flynn/noise"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 const (
+	// hwwCID is the channel used if channels are not allocated, see channelID().
 	hwwCID = 0xff000000
+	// u2fHIDBroadcastCID is the channel on which new channels are allocated.
+	u2fHIDBroadcastCID = 0xffffffff
 	// initial frame identifier
 	u2fHIDTypeInit = 0x80
+	// u2fHIDInit allocates a channel.
+	u2fHIDInit = u2fHIDTypeInit | 0x06
+	// u2fHIDError is the reply if the device could not process a frame.
+	u2fHIDError = u2fHIDTypeInit | 0x3f
 	// first vendor defined command
 	u2fHIDVendorFirst = u2fHIDTypeInit | 0x40
 	hwwCMD            = u2fHIDVendorFirst | 0x01
 )
 
+const (
+	// defaultCommandTimeout applies to commands without a specific timeout.
+	defaultCommandTimeout = 30 * time.Second
+	// bootloaderCommandTimeout applies to the commands in bootloader mode. Erasing and writing the
+	// firmware are the slowest.
+	bootloaderCommandTimeout = time.Minute
+)
+
+// defaultCommandTimeouts are the timeouts by command name, see commandName(). Commands waiting for
+// the user to confirm on the device have long timeouts. The device itself aborts a touch request
+// after a while, so these only apply if the device stopped responding.
+var defaultCommandTimeouts = map[string]time.Duration{
+	"ping":        5 * time.Second,
+	"device":      10 * time.Second,
+	"led":         10 * time.Second,
+	"random":      10 * time.Second,
+	"password":    time.Minute,
+	"xpub":        time.Minute,
+	"seed":        5 * time.Minute,
+	"backup":      5 * time.Minute,
+	"reset":       3 * time.Minute,
+	"bootloader":  3 * time.Minute,
+	"feature_set": 3 * time.Minute,
+	"ecdh":        3 * time.Minute,
+	"noise":       10 * time.Second,
+	"nonce":       10 * time.Second,
+	"sign":        10 * time.Minute,
+	"batch":       3 * time.Minute,
+}
+
+// defaultCommandPriorities are the queue priorities by command name, see commandName(). Status
+// polls yield to the other commands, and signing requests are served first. Other commands have
+// queue.PriorityNormal.
+var defaultCommandPriorities = map[string]queue.Priority{
+	"ping":   queue.PriorityLow,
+	"device": queue.PriorityLow,
+	"sign":   queue.PriorityHigh,
+}
+
 // Communication encodes JSON messages to/from a bitbox. The serialized messages are sent/received
-// as USB packets, following the ISO 7816-4 standard.
+// as U2FHID frames over a Transport, e.g. as USB packets, following the ISO 7816-4 standard.
 type Communication struct {
-	device             io.ReadWriteCloser
-	mutex              sync.Mutex
-	log                *logrus.Entry
-	usbWriteReportSize int
-	usbReadReportSize  int
+	transport Transport
+	// commandQueue grants the round trips exclusive access to the transport by priority, see
+	// roundTrip().
+	commandQueue *queue.Queue
+	log          *logrus.Entry
+	health       *health.Recorder
+
+	commandTimeouts     map[string]time.Duration
+	commandTimeoutsLock sync.RWMutex
+
+	// allocateChannels is false if the firmware does not support U2FHID_INIT, in which case hwwCID
+	// is used.
+	allocateChannels bool
+	// cid is the allocated channel, or 0 if it has not been allocated yet. It is only accessed
+	// during a round trip.
+	cid uint32
+	// capture records the frames, if not nil. See SetCapture().
+	capture *Capture
+	// events reads the frames in the background if the events of the device are enabled, see
+	// enableEvents(). It is also the transport in that case.
+	events *frameDemux
+
+	// noiseStatic is the static key of the app if the Noise channel is enabled, see enableNoise().
+	noiseStatic *noise.DHKey
+	// noise is the established Noise channel, or nil. It is reset if an encrypted command fails, as
+	// the nonces of the app and the device could be out of sync. Protected by noiseLock.
+	noise *noiseSession
+	// noiseUnsupported is true if the device refused the Noise channel, in which case the legacy
+	// encryption is used. Protected by noiseLock.
+	noiseUnsupported bool
+	// noisePinned is the static key with which the device has to identify in the Noise handshake,
+	// or nil. See PinNoise(). Protected by noiseLock.
+	noisePinned []byte
+	// noiseLock protects the state of the Noise channel. It is only held briefly, the order of the
+	// encrypted commands is kept by their round trips.
+	noiseLock sync.Mutex
+	// noiseHandshakeLock makes concurrent encrypted commands wait for the same handshake.
+	noiseHandshakeLock sync.Mutex
+	// nonces generates the nonces protecting the encrypted commands against replays, or is nil if
+	// the firmware does not support them. See enableNonces().
+	nonces *nonceCounter
+	// noBatch is set to 1 if the device does not support batches of commands, see
+	// SendEncryptBatch().
+	noBatch int32
 }
 
 // CommunicationErr is returned if there was an error with the device IO.
 type CommunicationErr error
 
-// NewCommunication creates a new Communication.
-func NewCommunication(device io.ReadWriteCloser, usbWriteReportSize, usbReadReportSize int) *Communication {
+// TimeoutErr is returned if the device did not reply within the timeout of the command, i.e. it is
+// not responding.
+type TimeoutErr struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (err *TimeoutErr) Error() string {
+	return fmt.Sprintf("device did not reply to %s within %s", err.Command, err.Timeout)
+}
+
+// NewCommunication creates a new Communication over a USB HID device with the given report sizes.
+func NewCommunication(device DeviceTransport, usbWriteReportSize, usbReadReportSize int) *Communication {
+	transport := NewReportTransport(device, usbWriteReportSize, usbReadReportSize)
+	transport.prefixReportID = runtime.GOOS != "windows"
+	return NewTransportCommunication(transport)
+}
+
+// NewTransportCommunication creates a new Communication over the given transport.
+func NewTransportCommunication(transport Transport) *Communication {
 	return &Communication{
-		device:             device,
-		mutex:              sync.Mutex{},
-		log:                logging.Get().WithGroup("usb"),
-		usbWriteReportSize: usbWriteReportSize,
-		usbReadReportSize:  usbReadReportSize,
+		transport:        transport,
+		commandQueue:     queue.New(),
+		log:              logging.Get().WithGroup("usb"),
+		health:           health.NewRecorder(),
+		commandTimeouts:  map[string]time.Duration{},
+		allocateChannels: true,
 	}
 }
 
-// Close closes the underlying device.
-func (communication *Communication) Close() {
-	if err := communication.device.Close(); err != nil {
-		communication.log.WithError(err).Panic(err)
-		panic(err)
+// commandPriority returns the priority at which the command is queued: the one set in the context
+// with queue.WithPriority(), or else the default priority of the command.
+func commandPriority(ctx context.Context, command string) queue.Priority {
+	if priority, ok := queue.PriorityFromContext(ctx); ok {
+		return priority
+	}
+	if priority, ok := defaultCommandPriorities[command]; ok {
+		return priority
 	}
+	return queue.PriorityNormal
 }
 
-func (communication *Communication) sendFrame(msg string) error {
-	dataLen := len(msg)
-	if dataLen == 0 {
-		return nil
-	}
-	send := func(header []byte, readFrom *bytes.Buffer) error {
-		buf := new(bytes.Buffer)
-		buf.Write(header)
-		buf.Write(readFrom.Next(communication.usbWriteReportSize - buf.Len()))
-		for buf.Len() < communication.usbWriteReportSize {
-			buf.WriteByte(0xee)
-		}
-		_, err := communication.device.Write(buf.Bytes())
-		return errors.WithMessage(errors.WithStack(err), "Failed to send message")
+// CommandQueue returns the command in flight and the commands waiting for the device, including
+// those of the other channels to the same device.
+func (communication *Communication) CommandQueue() *queue.Status {
+	return communication.commandQueue.Status()
+}
+
+// SetCommandTimeout overrides the timeout of a command, identified by its name (e.g. "sign"), or by
+// "bootloader:" followed by the command byte in bootloader mode.
+func (communication *Communication) SetCommandTimeout(command string, timeout time.Duration) {
+	communication.commandTimeoutsLock.Lock()
+	defer communication.commandTimeoutsLock.Unlock()
+	communication.commandTimeouts[command] = timeout
+}
+
+func (communication *Communication) commandTimeout(command string) time.Duration {
+	communication.commandTimeoutsLock.RLock()
+	defer communication.commandTimeoutsLock.RUnlock()
+	if timeout, ok := communication.commandTimeouts[command]; ok {
+		return timeout
 	}
-	readBuffer := bytes.NewBuffer([]byte(msg))
-	// init frame
-	header := new(bytes.Buffer)
-	if err := binary.Write(header, binary.BigEndian, uint32(hwwCID)); err != nil {
-		return errp.WithStack(err)
+	if timeout, ok := defaultCommandTimeouts[command]; ok {
+		return timeout
 	}
-	if err := binary.Write(header, binary.BigEndian, uint8(hwwCMD)); err != nil {
-		return errp.WithStack(err)
+	if strings.HasPrefix(command, "bootloader:") {
+		return bootloaderCommandTimeout
 	}
-	if err := binary.Write(header, binary.BigEndian, uint16(dataLen&0xFFFF)); err != nil {
-		return errp.WithStack(err)
+	return defaultCommandTimeout
+}
+
+// withTimeout calls send with a context which is also canceled when the timeout of the command
+// expires. The timeout is returned as a *TimeoutErr.
+func (communication *Communication) withTimeout(
+	ctx context.Context, command string, send func(context.Context) error) error {
+	timeout := communication.commandTimeout(command)
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := send(timeoutCtx)
+	if err != nil && ctx.Err() == nil && timeoutCtx.Err() == context.DeadlineExceeded {
+		return errp.WithStack(&TimeoutErr{Command: command, Timeout: timeout})
 	}
-	if err := send(header.Bytes(), readBuffer); err != nil {
-		return err
+	return err
+}
+
+// Health returns the round trip latencies and error counts of the commands sent so far, and the
+// assessment of the connection.
+func (communication *Communication) Health() *health.Stats {
+	return communication.health.Stats()
+}
+
+// recordRoundTrip adds the round trip of the command which started at start to the health
+// statistics. Errors other than error replies of the device and canceled requests are counted as
+// faults of the connection.
+func (communication *Communication) recordRoundTrip(command string, start time.Time, err error) {
+	communication.health.Record(command, time.Since(start), err)
+	if err == nil || errp.Cause(err) == context.Canceled {
+		return
 	}
-	for seq := 0; readBuffer.Len() > 0; seq++ {
-		// cont frame
-		header = new(bytes.Buffer)
-		if err := binary.Write(header, binary.BigEndian, uint32(hwwCID)); err != nil {
-			return errp.WithStack(err)
-		}
-		if err := binary.Write(header, binary.BigEndian, uint8(seq)); err != nil {
-			return errp.WithStack(err)
-		}
-		if err := send(header.Bytes(), readBuffer); err != nil {
-			return err
-		}
+	if _, ok := errp.Cause(err).(*bitbox.Error); ok {
+		return
 	}
-	return nil
+	communication.health.RecordFault(command)
 }
 
-func (communication *Communication) readFrame() ([]byte, error) {
-	read := make([]byte, communication.usbReadReportSize)
-	readLen, err := communication.device.Read(read)
-	if err != nil {
-		return nil, errors.WithStack(err)
+// commandName returns the name of the command in the JSON message, which is its top level key.
+func commandName(msg string) string {
+	cmd := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(msg), &cmd); err != nil || len(cmd) == 0 {
+		return "unknown"
 	}
-	if readLen < 7 {
-		return nil, errors.New("expected minimum read length of 7")
+	keys := make([]string, 0, len(cmd))
+	for key := range cmd {
+		keys = append(keys, key)
 	}
-	if read[0] != 0xff || read[1] != 0 || read[2] != 0 || read[3] != 0 {
-		return nil, errors.New("USB command ID mismatch")
+	sort.Strings(keys)
+	return keys[0]
+}
+
+// Close closes the underlying transport.
+func (communication *Communication) Close() {
+	if err := communication.transport.Close(); err != nil {
+		communication.log.WithError(err).Panic(err)
+		panic(err)
 	}
-	if read[4] != hwwCMD {
-		return nil, errp.Newf("USB command frame mismatch (%d, expected %d)", read[4], hwwCMD)
+}
+
+// roundTrip runs f, which sends the command and reads the reply, exclusively. Round trips waiting
+// for the device are served by the priority of their command, see commandPriority(). It returns
+// early with the context error if the context is canceled while waiting in the queue or for f. In
+// that case, f keeps running in the background until the device replies or is closed, so that its
+// reply is not mistaken for the reply of the next command, which waits for it.
+func (communication *Communication) roundTrip(ctx context.Context, command string, f func() error) error {
+	release, err := communication.commandQueue.Acquire(ctx, command, commandPriority(ctx, command))
+	if err != nil {
+		return err
 	}
-	data := new(bytes.Buffer)
-	dataLen := int(read[5])*256 + int(read[6])
-	data.Write(read[7:readLen])
-	idx := len(read) - 7
-	for idx < dataLen {
-		readLen, err = communication.device.Read(read)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		if readLen < 5 {
-			return nil, errors.New("expected minimum read length of 7")
-		}
-		data.Write(read[5:readLen])
-		idx += readLen - 5
+	done := make(chan error, 1)
+	go func() {
+		defer release()
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		communication.log.WithError(ctx.Err()).Warning("Round trip canceled, waiting for the reply in the background")
+		return errp.WithStack(ctx.Err())
 	}
-	return data.Bytes(), nil
 }
 
 // SendBootloader sends a message in the format the bootloader expects and fetches the response.
-func (communication *Communication) SendBootloader(msg []byte) ([]byte, error) {
-	communication.mutex.Lock()
-	defer communication.mutex.Unlock()
+// The context can cancel the round trip, see roundTrip(). If the device does not reply within the
+// timeout of the command, a *TimeoutErr is returned.
+func (communication *Communication) SendBootloader(ctx context.Context, msg []byte) ([]byte, error) {
+	return communication.SendBootloaderWithProgress(ctx, msg, nil)
+}
+
+// SendBootloaderWithProgress is like SendBootloader, but calls onProgress after each USB report
+// written with the number of bytes sent so far and the total number of bytes of the padded
+// message. onProgress is called from the round trip, which keeps running in the background if the
+// context is canceled. It can be nil.
+func (communication *Communication) SendBootloaderWithProgress(
+	ctx context.Context, msg []byte, onProgress func(sent, total int)) ([]byte, error) {
+	start := time.Now()
+	command := "bootloader"
+	if len(msg) > 0 {
+		// The bootloader commands are identified by their first byte.
+		command += ":" + string(msg[:1])
+	}
+	var reply []byte
+	err := communication.withTimeout(ctx, command, func(ctx context.Context) error {
+		return communication.roundTrip(ctx, command, func() error {
+			var err error
+			reply, err = communication.sendBootloader(msg, onProgress)
+			return err
+		})
+	})
+	communication.recordRoundTrip(command, start, err)
+	if err != nil {
+		// The reply variable can still be written by the canceled round trip.
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (communication *Communication) sendBootloader(msg []byte, onProgress func(sent, total int)) ([]byte, error) {
 	const (
 		// the bootloader expects 4098 bytes as one message.
 		sendLen = 4098
@@ -165,12 +341,18 @@ func (communication *Communication) SendBootloader(msg []byte) ([]byte, error) {
 		// the device HID interface sends 64 bytes at once.
 		readPacketSize = 64
 	)
+	// The bootloader protocol consists of raw HID reports instead of U2FHID frames.
+	reports, ok := communication.transport.(*ReportTransport)
+	if !ok {
+		return nil, errp.New("The transport does not support the bootloader")
+	}
 	if len(msg) > sendLen {
 		communication.log.WithFields(logrus.Fields{"message-length": len(msg),
 			"max-send-length": sendLen}).Panic("Message too long")
 		panic("message too long")
 	}
 
+	communication.capture.record(captureOut, 0, captureBootloaderCmd, msg)
 	paddedMsg := new(bytes.Buffer)
 	paddedMsg.Write(msg)
 	paddedMsg.Write(bytes.Repeat([]byte{0}, sendLen-len(msg)))
@@ -179,32 +361,37 @@ func (communication *Communication) SendBootloader(msg []byte) ([]byte, error) {
 
 	written := 0
 	for written < sendLen {
-		chunk := paddedMsg.Next(communication.usbWriteReportSize)
+		chunk := paddedMsg.Next(reports.writeReportSize)
 		chunkLen := len(chunk)
-		if runtime.GOOS != "windows" {
+		if reports.prefixReportID {
 			// packets have a 0 byte report ID in front. The karalabe hid library adds it
 			// automatically for windows, and not for unix, as there, it is stripped by the signal11
 			// hid library.  Since we are padding with zeroes, we have to add it (to be stripped by
 			// signal11), as otherwise, it would strip our 0 byte that is just padding.
 			chunk = append([]byte{0}, chunk...)
 		}
-		_, err := communication.device.Write(chunk)
+		_, err := reports.device.Write(chunk)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 		written += chunkLen
+		if onProgress != nil {
+			onProgress(written, sendLen)
+		}
 	}
 
 	var read bytes.Buffer
 	for read.Len() < readLen {
-		currentRead := make([]byte, communication.usbReadReportSize)
-		readLen, err := communication.device.Read(currentRead)
+		currentRead := make([]byte, reports.readReportSize)
+		readLen, err := reports.device.Read(currentRead)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 		read.Write(currentRead[:readLen])
 	}
-	return bytes.TrimRight(read.Bytes(), "\x00\t\r\n"), nil
+	reply := bytes.TrimRight(read.Bytes(), "\x00\t\r\n")
+	communication.capture.record(captureIn, 0, captureBootloaderCmd, reply)
+	return reply, nil
 }
 
 func hideValues(cmd map[string]interface{}) {
@@ -239,35 +426,88 @@ func logCensoredCmd(log *logrus.Entry, msg string, receiving bool) error {
 	return nil
 }
 
-// SendPlain sends an unecrypted message. The response is json-deserialized into a map.
-func (communication *Communication) SendPlain(msg string) (map[string]interface{}, error) {
+// SendPlain sends an unecrypted message. The response is json-deserialized into a map. The context
+// can cancel the round trip, see roundTrip(). If the device does not reply within the timeout of the
+// command, a *TimeoutErr is returned.
+func (communication *Communication) SendPlain(ctx context.Context, msg string) (map[string]interface{}, error) {
+	start := time.Now()
+	command := commandName(msg)
+	var jsonResult map[string]interface{}
+	err := communication.withTimeout(ctx, command, func(ctx context.Context) error {
+		return communication.retransmit(ctx, command, func() error {
+			var err error
+			jsonResult, err = communication.sendPlain(ctx, command, msg)
+			return err
+		})
+	})
+	communication.recordRoundTrip(command, start, err)
+	return jsonResult, err
+}
+
+func (communication *Communication) sendPlain(ctx context.Context, command, msg string) (map[string]interface{}, error) {
 	if err := logCensoredCmd(communication.log, msg, false); err != nil {
 		communication.log.WithField("msg", msg).Debug("Sending (encrypted) command")
 	}
-	communication.mutex.Lock()
-	defer communication.mutex.Unlock()
-	if err := communication.sendFrame(msg); err != nil {
+	var reply []byte
+	err := communication.roundTrip(ctx, command, func() error {
+		var err error
+		reply, err = communication.exchange([]byte(msg))
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
 		return nil, CommunicationErr(err)
 	}
-	reply, err := communication.readFrame()
+	jsonResult, err := communication.decodeReply(reply)
 	if err != nil {
-		return nil, CommunicationErr(err)
+		return nil, err
 	}
-	reply = bytes.TrimRightFunc(reply, func(r rune) bool { return unicode.IsSpace(r) || r == 0 })
-	err = logCensoredCmd(communication.log, string(reply), true)
+	if err := maybeDBBErr(jsonResult); err != nil {
+		return nil, err
+	}
+	return jsonResult, nil
+}
+
+// exchange sends the message on the channel of the communication and reads the reply. It must be
+// called during a round trip.
+func (communication *Communication) exchange(msg []byte) ([]byte, error) {
+	cid, err := communication.channelID()
 	if err != nil {
-		return nil, errp.WithContext(err, errp.Context{"reply": string(reply)})
+		return nil, err
+	}
+	if err := communication.sendFrame(cid, hwwCMD, msg); err != nil {
+		return nil, err
+	}
+	reply, err := communication.readFrame(cid, hwwCMD)
+	if u2fHIDErr, ok := errp.Cause(err).(*U2FHIDError); ok && u2fHIDErr.Code == u2fHIDErrInvalidCID {
+		// The device forgot the channel, e.g. because it restarted. Allocate a new one next time.
+		communication.cid = 0
 	}
+	return reply, err
+}
+
+// decodeReply decodes the JSON reply of the device.
+func (communication *Communication) decodeReply(reply []byte) (map[string]interface{}, error) {
+	reply = bytes.TrimRightFunc(reply, func(r rune) bool { return unicode.IsSpace(r) || r == 0 })
 	jsonResult := map[string]interface{}{}
 	if err := json.Unmarshal(reply, &jsonResult); err != nil {
-		return nil, errp.Wrap(err, "Failed to unmarshal reply")
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "invalid JSON"})
 	}
-	if err := maybeDBBErr(jsonResult); err != nil {
-		return nil, err
+	err := logCensoredCmd(communication.log, string(reply), true)
+	if err != nil {
+		return nil, errp.WithContext(err, errp.Context{"reply": string(reply)})
 	}
 	return jsonResult, nil
 }
 
+// isErrorReply returns true if the reply only consists of an error of the device, see maybeDBBErr().
+func isErrorReply(jsonResult map[string]interface{}) bool {
+	_, ok := jsonResult["error"]
+	return ok && len(jsonResult) == 1
+}
+
 func maybeDBBErr(jsonResult map[string]interface{}) error {
 	if errMap, ok := jsonResult["error"].(map[string]interface{}); ok {
 		errMsg, ok := errMap["message"].(string)
@@ -284,37 +524,122 @@ func maybeDBBErr(jsonResult map[string]interface{}) error {
 }
 
 // SendEncrypt sends an encrypted message. The response is json-deserialized into a map. If the
-// response contains an error field, it is returned as a DBBErr.
-func (communication *Communication) SendEncrypt(msg, password string) (map[string]interface{}, error) {
+// response contains an error field, it is returned as a DBBErr. The context can cancel the round
+// trip, see roundTrip(). If the device does not reply within the timeout of the command, a
+// *TimeoutErr is returned. Each command carries a new nonce, and a *ReplayErr is returned if the
+// reply does not echo it.
+func (communication *Communication) SendEncrypt(ctx context.Context, msg, password string) (map[string]interface{}, error) {
+	start := time.Now()
+	command := commandName(msg)
+	var jsonResult map[string]interface{}
+	err := communication.withTimeout(ctx, command, func(ctx context.Context) error {
+		return communication.retransmit(ctx, command, func() error {
+			var err error
+			jsonResult, err = communication.sendEncrypt(ctx, msg, password)
+			return err
+		})
+	})
+	communication.recordRoundTrip(command, start, err)
+	return jsonResult, err
+}
+
+func (communication *Communication) sendEncrypt(ctx context.Context, msg, password string) (map[string]interface{}, error) {
 	if err := logCensoredCmd(communication.log, msg, false); err != nil {
 		return nil, errp.WithMessage(err, "Invalid JSON passed. Continuing anyway")
 	}
+	if err := communication.establishNoise(ctx); err != nil {
+		return nil, errp.WithMessage(err, "Failed to establish the Noise channel")
+	}
 	secret := chainhash.DoubleHashB([]byte(password))
-	cipherText, err := crypto.Encrypt([]byte(msg), secret)
+	var jsonResult map[string]interface{}
+	// The command is encrypted and its reply decrypted during the round trip, so that the nonces
+	// keep the order in which the device receives the commands.
+	err := communication.roundTrip(ctx, commandName(msg), func() error {
+		var nonce uint64
+		msgWithNonce := msg
+		if communication.nonces != nil {
+			nonce = communication.nonces.next()
+			var err error
+			msgWithNonce, err = withNonce(msg, nonce)
+			if err != nil {
+				return errp.WithMessage(err, "Failed to add the nonce to the command")
+			}
+		}
+		cipherText, err := crypto.Encrypt([]byte(msgWithNonce), secret)
+		if err != nil {
+			return errp.WithMessage(err, "Failed to encrypt command")
+		}
+		cipherText, session, err := communication.noiseEncrypt(cipherText)
+		if err != nil {
+			return errp.WithMessage(err, "Failed to encrypt command for the Noise channel")
+		}
+		reply, err := communication.exchange([]byte(base64.StdEncoding.EncodeToString(cipherText)))
+		if err != nil {
+			// The device might not have received the command, or its reply was lost.
+			communication.resetNoise()
+			return CommunicationErr(errp.WithMessage(err, "Failed to send cipher text"))
+		}
+		jsonResult, err = communication.openReply(reply, session, nonce, secret)
+		return err
+	})
 	if err != nil {
-		return nil, errp.WithMessage(err, "Failed to encrypt command")
+		return nil, err
 	}
-	jsonResult, err := communication.SendPlain(base64.StdEncoding.EncodeToString(cipherText))
+	return jsonResult, nil
+}
+
+// openReply decrypts and verifies the reply to an encrypted command. The session is the Noise
+// channel the command was sent on, or nil. Once the Noise channel is established or the nonces are
+// enabled, the device can only reply in plain text with an error, see ErrUnprotectedReply. Such a
+// reply carries no nonce, but it can't be mistaken for the result of the command either.
+func (communication *Communication) openReply(
+	reply []byte, session *noiseSession, nonce uint64, secret []byte) (map[string]interface{}, error) {
+	jsonResult, err := communication.decodeReply(reply)
 	if err != nil {
-		return nil, errp.WithMessage(err, "Failed to send cipher text")
+		communication.resetNoise()
+		return nil, err
 	}
-	if cipherText, ok := jsonResult["ciphertext"].(string); ok {
-		decodedMsg, err := base64.StdEncoding.DecodeString(cipherText)
-		if err != nil {
-			return nil, errp.WithMessage(err, "Failed to decode reply")
+	cipherText, ok := jsonResult["ciphertext"].(string)
+	if !ok {
+		if session != nil {
+			// The device might not have decrypted the command, so the nonces could be out of sync.
+			communication.resetNoise()
 		}
-		plainText, err := crypto.Decrypt(decodedMsg, secret)
-		if err != nil {
-			return nil, errp.WithMessage(err, "Failed to decrypt reply")
+		if (session != nil || communication.nonces != nil) && !isErrorReply(jsonResult) {
+			return nil, errp.WithStack(ErrUnprotectedReply)
 		}
-		err = logCensoredCmd(communication.log, string(plainText), true)
-		if err != nil {
-			return nil, errp.WithContext(err, errp.Context{"reply": string(plainText)})
+		if err := maybeDBBErr(jsonResult); err != nil {
+			return nil, err
 		}
-		jsonResult = map[string]interface{}{}
-		if err := json.Unmarshal(plainText, &jsonResult); err != nil {
-			return nil, errp.Wrap(err, "Failed to unmarshal reply")
+		return jsonResult, nil
+	}
+	decodedMsg, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
+		communication.resetNoise()
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "invalid base64 cipher text"})
+	}
+	decodedMsg, err = noiseDecrypt(session, decodedMsg)
+	if err != nil {
+		communication.resetNoise()
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "failed to decrypt: " + err.Error()})
+	}
+	plainText, err := crypto.Decrypt(decodedMsg, secret)
+	if err != nil {
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "failed to decrypt: " + err.Error()})
+	}
+	jsonResult = map[string]interface{}{}
+	if err := json.Unmarshal(plainText, &jsonResult); err != nil {
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "invalid decrypted JSON"})
+	}
+	err = logCensoredCmd(communication.log, string(plainText), true)
+	if err != nil {
+		return nil, errp.WithContext(err, errp.Context{"reply": string(plainText)})
+	}
+	if communication.nonces != nil {
+		if err := checkReply(plainText, nonce); err != nil {
+			return nil, err
 		}
+		delete(jsonResult, nonceKey)
 	}
 	if err := maybeDBBErr(jsonResult); err != nil {
 		return nil, err
@@ -0,0 +1,112 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingDevice accepts all writes and blocks reads until it is closed.
+type blockingDevice struct {
+	closed chan struct{}
+}
+
+func (device *blockingDevice) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (device *blockingDevice) Read(p []byte) (int, error) {
+	<-device.closed
+	return 0, errors.New("closed")
+}
+
+func (device *blockingDevice) Close() error {
+	close(device.closed)
+	return nil
+}
+
+func TestSendCanceled(t *testing.T) {
+	device := &blockingDevice{closed: make(chan struct{})}
+	communication := NewCommunication(device, 64, 64)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := communication.SendPlain(ctx, `{"ping":""}`)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	// The canceled round trip still waits for the reply, so the next one can't start.
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	_, err = communication.SendBootloader(ctx2, []byte("v"))
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	// Closing the device ends the pending round trip.
+	communication.Close()
+	_, err = communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.Error(t, err)
+	require.NotEqual(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
+func TestSendTimeout(t *testing.T) {
+	device := &blockingDevice{closed: make(chan struct{})}
+	communication := NewCommunication(device, 64, 64)
+	defer communication.Close()
+	require.Equal(t, 5*time.Second, communication.commandTimeout("ping"))
+	require.Equal(t, 10*time.Minute, communication.commandTimeout("sign"))
+	require.Equal(t, time.Minute, communication.commandTimeout("bootloader:e"))
+	require.Equal(t, 30*time.Second, communication.commandTimeout("unknown"))
+
+	communication.SetCommandTimeout("ping", 10*time.Millisecond)
+	_, err := communication.SendPlain(context.Background(), `{"ping":""}`)
+	timeoutErr, ok := errors.Cause(err).(*TimeoutErr)
+	require.True(t, ok)
+	require.Equal(t, "ping", timeoutErr.Command)
+	require.Equal(t, 10*time.Millisecond, timeoutErr.Timeout)
+}
+
+// bootloaderDevice accepts all writes and replies to each bootloader command with "w0".
+type bootloaderDevice struct{}
+
+func (device *bootloaderDevice) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (device *bootloaderDevice) Read(p []byte) (int, error) {
+	copy(p, "w0")
+	return len(p), nil
+}
+
+func (device *bootloaderDevice) Close() error {
+	return nil
+}
+
+func TestSendBootloaderWithProgress(t *testing.T) {
+	communication := NewCommunication(&bootloaderDevice{}, 1024, 64)
+	defer communication.Close()
+	sent := []int{}
+	reply, err := communication.SendBootloaderWithProgress(context.Background(), []byte("w"),
+		func(bytesSent, total int) {
+			require.Equal(t, 4098, total)
+			sent = append(sent, bytesSent)
+		})
+	require.NoError(t, err)
+	require.Equal(t, "w0", string(reply[:2]))
+	require.Equal(t, []int{1024, 2048, 3072, 4096, 4098}, sent)
+}
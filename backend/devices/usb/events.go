@@ -0,0 +1,244 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+const (
+	// hwwEventCMD is the command of the frames the device sends on its own, e.g. while the user
+	// touches the button. The message is a JSON object with the name of the event in the "event"
+	// field.
+	hwwEventCMD = u2fHIDVendorFirst | 0x02
+	// eventBufferSize is the number of device events buffered until they are handled.
+	eventBufferSize = 32
+	// replyBufferSize is the number of frames buffered per channel until they are read.
+	replyBufferSize = 4
+)
+
+// frameResult is a frame read by the frameDemux, or the error reading it.
+type frameResult struct {
+	cmd byte
+	msg []byte
+	err error
+}
+
+// frameDemux implements Transport over a ReportTransport, reading the frames in the background.
+// Otherwise, the device is only read during a round trip, and a frame the device sent on its own
+// would be taken for the reply to the next command. The event frames (hwwEventCMD) are handled by
+// the callback set in setOnEvent(), and the other frames are returned by the ReadFrame() of their
+// channel.
+type frameDemux struct {
+	transport *ReportTransport
+	capture   *Capture
+	log       *logrus.Entry
+
+	lock locker.Locker
+	// replies buffers the frames of the channels a frame was sent on, by channel ID. The frames of
+	// other channels belong to other software talking to the device, and are dropped.
+	replies map[uint32]chan *frameResult
+	onEvent func(*bitbox.DeviceEvent)
+
+	events chan *bitbox.DeviceEvent
+	// done is closed when reading failed, e.g. because the transport was closed. err is the error.
+	done chan struct{}
+	err  error
+}
+
+// newFrameDemux creates a frameDemux and starts reading the frames.
+func newFrameDemux(transport *ReportTransport, capture *Capture, log *logrus.Entry) *frameDemux {
+	demux := &frameDemux{
+		transport: transport,
+		capture:   capture,
+		log:       log,
+		replies:   map[uint32]chan *frameResult{},
+		events:    make(chan *bitbox.DeviceEvent, eventBufferSize),
+		done:      make(chan struct{}),
+	}
+	go demux.read()
+	go demux.dispatchEvents()
+	return demux
+}
+
+// readAnyFrame reads the next frame of any channel and returns its channel ID. Continuation frames
+// without an init frame are skipped. A failed read of the device is returned as the error, while a
+// frame which could not be read is returned as the error of its frameResult.
+func (transport *ReportTransport) readAnyFrame() (uint32, *frameResult, error) {
+	read := make([]byte, transport.readReportSize)
+	for {
+		readLen, err := transport.device.Read(read)
+		if err != nil {
+			return 0, nil, errors.WithStack(err)
+		}
+		if readLen < 5 || read[4]&u2fHIDTypeInit == 0 {
+			continue
+		}
+		cid := binary.BigEndian.Uint32(read[:4])
+		if readLen < 7 {
+			return cid, &frameResult{
+				err: errp.WithStack(&CorruptReplyErr{Reason: "init frame too short"})}, nil
+		}
+		cmd := read[4]
+		msg, err := transport.readFrameData(read, readLen, cid)
+		return cid, &frameResult{cmd: cmd, msg: msg, err: err}, nil
+	}
+}
+
+// replyChannel returns the buffer of the frames of the given channel, creating it on first use.
+func (demux *frameDemux) replyChannel(cid uint32) chan *frameResult {
+	defer demux.lock.Lock()()
+	replies, ok := demux.replies[cid]
+	if !ok {
+		replies = make(chan *frameResult, replyBufferSize)
+		demux.replies[cid] = replies
+	}
+	return replies
+}
+
+func (demux *frameDemux) read() {
+	for {
+		cid, result, err := demux.transport.readAnyFrame()
+		if err != nil {
+			demux.err = err
+			close(demux.done)
+			close(demux.events)
+			return
+		}
+		if result.err == nil && result.cmd == hwwEventCMD {
+			demux.capture.record(captureIn, cid, hwwEventCMD, result.msg)
+			demux.queueEvent(result.msg)
+			continue
+		}
+		unlock := demux.lock.RLock()
+		replies, ok := demux.replies[cid]
+		unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case replies <- result:
+		default:
+			demux.log.WithField("cid", fmt.Sprintf("%08x", cid)).Debug("Dropping a frame nobody reads")
+		}
+	}
+}
+
+// queueEvent decodes the message of an event frame and queues the event for dispatchEvents().
+func (demux *frameDemux) queueEvent(msg []byte) {
+	msg = bytes.TrimRightFunc(msg, func(r rune) bool { return unicode.IsSpace(r) || r == 0 })
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(msg, &data); err != nil {
+		demux.log.WithError(err).Warning("Dropping a device event with invalid JSON")
+		return
+	}
+	name, ok := data["event"].(string)
+	if !ok {
+		demux.log.Warning("Dropping a device event without name")
+		return
+	}
+	delete(data, "event")
+	select {
+	case demux.events <- &bitbox.DeviceEvent{Name: name, Data: data}:
+	default:
+		demux.log.WithField("event", name).Warning("Too many pending device events, dropping one")
+	}
+}
+
+// dispatchEvents passes the queued events to the callback, in order, until reading stopped.
+func (demux *frameDemux) dispatchEvents() {
+	for event := range demux.events {
+		unlock := demux.lock.RLock()
+		onEvent := demux.onEvent
+		unlock()
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
+
+func (demux *frameDemux) setOnEvent(onEvent func(*bitbox.DeviceEvent)) {
+	defer demux.lock.Lock()()
+	demux.onEvent = onEvent
+}
+
+// SendFrame implements Transport.
+func (demux *frameDemux) SendFrame(cid uint32, cmd byte, msg []byte) error {
+	// The reply can arrive before ReadFrame() is called.
+	demux.replyChannel(cid)
+	return demux.transport.SendFrame(cid, cmd, msg)
+}
+
+// ReadFrame implements Transport.
+func (demux *frameDemux) ReadFrame(cid uint32, cmd byte) ([]byte, error) {
+	replies := demux.replyChannel(cid)
+	var result *frameResult
+	select {
+	case result = <-replies:
+	case <-demux.done:
+		select {
+		case result = <-replies:
+		default:
+			return nil, demux.err
+		}
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	if result.cmd == u2fHIDError && len(result.msg) > 0 {
+		return nil, errp.WithStack(&U2FHIDError{Code: result.msg[0]})
+	}
+	if result.cmd != cmd {
+		return nil, errp.Newf("USB command frame mismatch (%d, expected %d)", result.cmd, cmd)
+	}
+	return result.msg, nil
+}
+
+// Close implements Transport. Reading stops once the device is closed.
+func (demux *frameDemux) Close() error {
+	return demux.transport.Close()
+}
+
+// enableEvents makes the communication receive the events the device sends on its own, see
+// SetOnDeviceEvent(). The frames are then read in the background. It must be called before the
+// first round trip, and has no effect unless the transport is a ReportTransport.
+func (communication *Communication) enableEvents() {
+	reports, ok := communication.transport.(*ReportTransport)
+	if !ok {
+		return
+	}
+	communication.events = newFrameDemux(reports, communication.capture, communication.log)
+	communication.transport = communication.events
+}
+
+// SetOnDeviceEvent implements bitbox.DeviceEventSource. The callback is shared by all channels to
+// the device. If the events are not enabled, it is never called.
+func (communication *Communication) SetOnDeviceEvent(onEvent func(*bitbox.DeviceEvent)) {
+	if communication.events == nil {
+		return
+	}
+	communication.events.setOnEvent(onEvent)
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+)
+
+// eventDevice replies to each command with {"ping":"password"}, sending a button event before the
+// reply, and a frame of another channel. Reads block until the device sends a report.
+type eventDevice struct {
+	reports   chan []byte
+	closeOnce sync.Once
+}
+
+func (device *eventDevice) Write(p []byte) (int, error) {
+	cid := binary.BigEndian.Uint32(p)
+	if p[4] == hwwCMD {
+		device.reports <- u2fFrame(cid, hwwEventCMD, []byte(`{"event":"button","progress":50}`))
+		device.reports <- u2fFrame(0x0a0b0c0d, hwwCMD, []byte(`{"foreign":""}`))
+		device.reports <- u2fFrame(cid, hwwCMD, []byte(`{"ping":"password"}`))
+	}
+	return len(p), nil
+}
+
+func (device *eventDevice) Read(p []byte) (int, error) {
+	report, ok := <-device.reports
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, report), nil
+}
+
+func (device *eventDevice) Close() error {
+	device.closeOnce.Do(func() { close(device.reports) })
+	return nil
+}
+
+func TestDeviceEvents(t *testing.T) {
+	device := &eventDevice{reports: make(chan []byte, 16)}
+	communication := NewCommunication(device, 64, 64)
+	communication.DisableChannelAllocation()
+	communication.enableEvents()
+	events := make(chan *bitbox.DeviceEvent, 1)
+	communication.SetOnDeviceEvent(func(event *bitbox.DeviceEvent) { events <- event })
+
+	reply, err := communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, "password", reply["ping"])
+	select {
+	case event := <-events:
+		require.Equal(t, &bitbox.DeviceEvent{
+			Name: "button",
+			Data: map[string]interface{}{"progress": float64(50)},
+		}, event)
+	case <-time.After(time.Second):
+		require.Fail(t, "no device event")
+	}
+
+	// Events which arrive while nobody waits for a reply are handled as well.
+	device.reports <- u2fFrame(hwwCID, hwwEventCMD, []byte(`{"event":"tamper"}`))
+	select {
+	case event := <-events:
+		require.Equal(t, "tamper", event.Name)
+	case <-time.After(time.Second):
+		require.Fail(t, "no device event")
+	}
+
+	// Reading the reply fails once the device is closed.
+	communication.Close()
+	_, err = communication.transport.ReadFrame(hwwCID, hwwCMD)
+	require.Error(t, err)
+}
+
+func TestDeviceEventsDisabled(t *testing.T) {
+	communication := NewCommunication(&eventDevice{reports: make(chan []byte, 16)}, 64, 64)
+	// Without the reader, the events can't be received, and the callback is never installed.
+	communication.SetOnDeviceEvent(func(*bitbox.DeviceEvent) { require.FailNow(t, "unexpected event") })
+	require.Nil(t, communication.events)
+}
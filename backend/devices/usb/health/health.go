@@ -0,0 +1,268 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram buckets. Round trips slower than the
+// last bound are counted in an additional overflow bucket. The bounds are generous since commands
+// like signing include the time the user takes to confirm on the device.
+var LatencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// AssessmentWindow is the period over which the recent round trips are assessed, see
+// Recorder.Assess().
+const AssessmentWindow = 10 * time.Minute
+
+const (
+	// maxRecent caps the number of recent round trips, faults and retries kept for the assessment.
+	maxRecent = 1000
+	// The connection is flaky if at least flakyMinFaults round trips in the window failed and they
+	// make up at least flakyFaultPercent of the round trips, or if replies had to be retransmitted
+	// at least flakyRetries times.
+	flakyMinFaults    = 2
+	flakyFaultPercent = 20
+	flakyRetries      = 3
+)
+
+// CommandStats holds the round trip statistics of one device command.
+type CommandStats struct {
+	Command string `json:"command"`
+	Count   int    `json:"count"`
+	Errors  int    `json:"errors"`
+	// Faults are the errors caused by the connection rather than by the device, e.g. timeouts.
+	Faults int `json:"faults"`
+	// Retries is how often the command was sent again because its reply was corrupted.
+	Retries int `json:"retries"`
+	// Histogram has one entry per bucket in LatencyBuckets plus one overflow entry.
+	Histogram []int `json:"histogram"`
+	// TotalMs and MaxMs are the summed and the maximum round trip latency in milliseconds.
+	TotalMs int64 `json:"totalMs"`
+	MaxMs   int64 `json:"maxMs"`
+	// LastError is the message of the most recent failure, if any.
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// AverageMs returns the mean round trip latency in milliseconds.
+func (stats *CommandStats) AverageMs() int64 {
+	if stats.Count == 0 {
+		return 0
+	}
+	return stats.TotalMs / int64(stats.Count)
+}
+
+// Stats is a snapshot of the statistics of all commands sent to a device.
+type Stats struct {
+	// BucketsMs are the histogram bucket bounds in milliseconds.
+	BucketsMs []int64         `json:"bucketsMs"`
+	Commands  []*CommandStats `json:"commands"`
+	Count     int             `json:"count"`
+	Errors    int             `json:"errors"`
+	Faults    int             `json:"faults"`
+	Retries   int             `json:"retries"`
+	Since     time.Time       `json:"since"`
+	// Assessment is the assessment of the connection at the time of the snapshot.
+	Assessment *Assessment `json:"assessment"`
+}
+
+// Assessment tells whether the connection to the device looks unreliable, e.g. because of a bad
+// cable or USB port, based on the round trips in the last AssessmentWindow.
+type Assessment struct {
+	Flaky      bool `json:"flaky"`
+	RoundTrips int  `json:"roundTrips"`
+	Faults     int  `json:"faults"`
+	Retries    int  `json:"retries"`
+	// Reason describes why the connection is flaky, or is empty.
+	Reason string `json:"reason,omitempty"`
+}
+
+type recentKind int
+
+const (
+	recentRoundTrip recentKind = iota
+	recentFault
+	recentRetry
+)
+
+type recentEvent struct {
+	kind recentKind
+	time time.Time
+}
+
+// Recorder collects round trip latencies, error and retry counts per command. It also watches the
+// recent faults and retries to assess the connection, see Assess(). It is safe for concurrent use.
+type Recorder struct {
+	commands map[string]*CommandStats
+	// recent are the round trips, faults and retries of the last AssessmentWindow, oldest first.
+	recent []recentEvent
+	since  time.Time
+	lock   locker.Locker
+}
+
+// NewRecorder creates a new, empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		commands: map[string]*CommandStats{},
+		since:    time.Now(),
+	}
+}
+
+func bucketIndex(latency time.Duration) int {
+	for index, bound := range LatencyBuckets {
+		if latency <= bound {
+			return index
+		}
+	}
+	return len(LatencyBuckets)
+}
+
+// commandStats returns the statistics of the command, creating them if needed. It must be called
+// with the lock held.
+func (recorder *Recorder) commandStats(command string) *CommandStats {
+	stats, ok := recorder.commands[command]
+	if !ok {
+		stats = &CommandStats{
+			Command:   command,
+			Histogram: make([]int, len(LatencyBuckets)+1),
+		}
+		recorder.commands[command] = stats
+	}
+	return stats
+}
+
+// addRecent adds an event to the assessment window and drops the events which fell out of it. It
+// must be called with the lock held.
+func (recorder *Recorder) addRecent(kind recentKind, now time.Time) {
+	drop := 0
+	for drop < len(recorder.recent) &&
+		(now.Sub(recorder.recent[drop].time) > AssessmentWindow || len(recorder.recent)-drop >= maxRecent) {
+		drop++
+	}
+	recorder.recent = append(recorder.recent[drop:], recentEvent{kind: kind, time: now})
+}
+
+// Record adds one round trip of the given command. err is the error the round trip resulted in, if
+// any. Errors caused by the connection should also be recorded with RecordFault().
+func (recorder *Recorder) Record(command string, latency time.Duration, err error) {
+	defer recorder.lock.Lock()()
+	stats := recorder.commandStats(command)
+	recorder.addRecent(recentRoundTrip, time.Now())
+	stats.Count++
+	stats.Histogram[bucketIndex(latency)]++
+	latencyMs := int64(latency / time.Millisecond)
+	stats.TotalMs += latencyMs
+	if latencyMs > stats.MaxMs {
+		stats.MaxMs = latencyMs
+	}
+	if err != nil {
+		now := time.Now()
+		stats.Errors++
+		stats.LastError = err.Error()
+		stats.LastErrorTime = &now
+	}
+}
+
+// RecordFault marks the last failed round trip of the given command as caused by the connection, as
+// opposed to an error reply of the device.
+func (recorder *Recorder) RecordFault(command string) {
+	defer recorder.lock.Lock()()
+	recorder.commandStats(command).Faults++
+	recorder.addRecent(recentFault, time.Now())
+}
+
+// RecordRetry counts that the given command was sent again because its reply was corrupted.
+func (recorder *Recorder) RecordRetry(command string) {
+	defer recorder.lock.Lock()()
+	recorder.commandStats(command).Retries++
+	recorder.addRecent(recentRetry, time.Now())
+}
+
+// Assess assesses the connection based on the round trips of the last AssessmentWindow.
+func (recorder *Recorder) Assess() *Assessment {
+	defer recorder.lock.RLock()()
+	return recorder.assess(time.Now())
+}
+
+// assess must be called with the lock held.
+func (recorder *Recorder) assess(now time.Time) *Assessment {
+	assessment := &Assessment{}
+	for _, event := range recorder.recent {
+		if now.Sub(event.time) > AssessmentWindow {
+			continue
+		}
+		switch event.kind {
+		case recentRoundTrip:
+			assessment.RoundTrips++
+		case recentFault:
+			assessment.Faults++
+		case recentRetry:
+			assessment.Retries++
+		}
+	}
+	switch {
+	case assessment.Faults >= flakyMinFaults &&
+		assessment.Faults*100 >= flakyFaultPercent*assessment.RoundTrips:
+		assessment.Flaky = true
+		assessment.Reason = fmt.Sprintf("%d of %d round trips failed", assessment.Faults, assessment.RoundTrips)
+	case assessment.Retries >= flakyRetries:
+		assessment.Flaky = true
+		assessment.Reason = fmt.Sprintf("%d corrupt replies", assessment.Retries)
+	}
+	return assessment
+}
+
+// Stats returns a snapshot of the recorded statistics, sorted by command name.
+func (recorder *Recorder) Stats() *Stats {
+	defer recorder.lock.RLock()()
+	result := &Stats{
+		BucketsMs:  make([]int64, len(LatencyBuckets)),
+		Commands:   make([]*CommandStats, 0, len(recorder.commands)),
+		Since:      recorder.since,
+		Assessment: recorder.assess(time.Now()),
+	}
+	for index, bound := range LatencyBuckets {
+		result.BucketsMs[index] = int64(bound / time.Millisecond)
+	}
+	for _, stats := range recorder.commands {
+		statsCopy := *stats
+		statsCopy.Histogram = append([]int(nil), stats.Histogram...)
+		result.Commands = append(result.Commands, &statsCopy)
+		result.Count += stats.Count
+		result.Errors += stats.Errors
+		result.Faults += stats.Faults
+		result.Retries += stats.Retries
+	}
+	sort.Slice(result.Commands, func(i, j int) bool {
+		return result.Commands[i].Command < result.Commands[j].Command
+	})
+	return result
+}
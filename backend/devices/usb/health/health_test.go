@@ -0,0 +1,107 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := health.NewRecorder()
+	stats := recorder.Stats()
+	require.Len(t, stats.BucketsMs, len(health.LatencyBuckets))
+	require.Empty(t, stats.Commands)
+
+	recorder.Record("ping", 10*time.Millisecond, nil)
+	recorder.Record("ping", 300*time.Millisecond, errors.New("timeout"))
+	recorder.Record("sign", 2*time.Minute, nil)
+
+	stats = recorder.Stats()
+	require.Equal(t, 3, stats.Count)
+	require.Equal(t, 1, stats.Errors)
+	require.Len(t, stats.Commands, 2)
+
+	ping := stats.Commands[0]
+	require.Equal(t, "ping", ping.Command)
+	require.Equal(t, 2, ping.Count)
+	require.Equal(t, 1, ping.Errors)
+	require.Equal(t, "timeout", ping.LastError)
+	require.NotNil(t, ping.LastErrorTime)
+	require.Equal(t, int64(300), ping.MaxMs)
+	require.Equal(t, int64(155), ping.AverageMs())
+	require.Equal(t, 1, ping.Histogram[0])
+	require.Equal(t, 1, ping.Histogram[3])
+
+	sign := stats.Commands[1]
+	require.Equal(t, "sign", sign.Command)
+	require.Equal(t, 1, sign.Histogram[len(health.LatencyBuckets)])
+
+	// The snapshot is not affected by later records.
+	recorder.Record("ping", 10*time.Millisecond, nil)
+	require.Equal(t, 2, ping.Count)
+	require.Equal(t, 1, ping.Histogram[0])
+}
+
+func TestAssess(t *testing.T) {
+	recorder := health.NewRecorder()
+	require.Equal(t, &health.Assessment{}, recorder.Assess())
+
+	// A single fault is not enough.
+	recorder.Record("ping", time.Millisecond, errors.New("timeout"))
+	recorder.RecordFault("ping")
+	require.False(t, recorder.Assess().Flaky)
+
+	// Error replies of the device are not faults.
+	for i := 0; i < 5; i++ {
+		recorder.Record("sign", time.Millisecond, errors.New("aborted by the user"))
+	}
+	require.False(t, recorder.Assess().Flaky)
+
+	recorder.Record("ping", time.Millisecond, errors.New("timeout"))
+	recorder.RecordFault("ping")
+	assessment := recorder.Assess()
+	require.Equal(t, &health.Assessment{
+		Flaky:      true,
+		RoundTrips: 7,
+		Faults:     2,
+		Reason:     "2 of 7 round trips failed",
+	}, assessment)
+
+	stats := recorder.Stats()
+	require.Equal(t, 2, stats.Faults)
+	require.Equal(t, 2, stats.Commands[0].Faults)
+	require.Equal(t, assessment, stats.Assessment)
+
+	// Faults are rare compared to the round trips.
+	for i := 0; i < 10; i++ {
+		recorder.Record("ping", time.Millisecond, nil)
+	}
+	require.False(t, recorder.Assess().Flaky)
+
+	// Corrupt replies.
+	for i := 0; i < 3; i++ {
+		recorder.RecordRetry("xpub")
+	}
+	assessment = recorder.Assess()
+	require.True(t, assessment.Flaky)
+	require.Equal(t, 3, assessment.Retries)
+	require.Equal(t, "3 corrupt replies", assessment.Reason)
+	require.Equal(t, 3, recorder.Stats().Retries)
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/karalabe/hid"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// HIDBackendHIDAPI opens the device using the hidapi library. This is the default.
+	HIDBackendHIDAPI = "hidapi"
+
+	// HIDBackendNative opens the device interface path with the Win32 API, sharing it with other
+	// processes, and reads and writes raw HID reports. Only supported on Windows, where some users
+	// can enumerate the device, but hidapi fails to open it, e.g. because another process holds it
+	// open. Neither WinRT nor libusb are used: WinRT needs COM bindings and libusb a driver swap,
+	// which are both out of scope for now.
+	HIDBackendNative = "native"
+)
+
+// hidBackends returns the backends to try in order when opening a device. The preferred backend
+// can be set with the BITBOX_HID_BACKEND environment variable.
+func hidBackends() []string {
+	if runtime.GOOS != "windows" {
+		return []string{HIDBackendHIDAPI}
+	}
+	if os.Getenv("BITBOX_HID_BACKEND") == HIDBackendNative {
+		return []string{HIDBackendNative, HIDBackendHIDAPI}
+	}
+	return []string{HIDBackendHIDAPI, HIDBackendNative}
+}
+
+// openDevice opens the device, falling back to the next backend if opening fails. It returns the
+// name of the backend which was used successfully. If all backends fail, the error of the first
+// backend is returned.
+func openDevice(deviceInfo hid.DeviceInfo) (io.ReadWriteCloser, string, error) {
+	var firstErr error
+	for _, backend := range hidBackends() {
+		var device io.ReadWriteCloser
+		var err error
+		switch backend {
+		case HIDBackendHIDAPI:
+			device, err = deviceInfo.Open()
+		case HIDBackendNative:
+			device, err = openNativeHIDDevice(deviceInfo.Path)
+		default:
+			panic(errp.Newf("unknown hid backend %s", backend))
+		}
+		if err == nil {
+			return device, backend, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", firstErr
+}
+
+// nativeHIDDevice reads and writes HID reports on the device handle, see openNativeHIDDevice().
+// Like hidapi on Windows, it prepends the zero report ID when writing and strips it when reading.
+type nativeHIDDevice struct {
+	file io.ReadWriteCloser
+}
+
+// Write implements io.Writer.
+func (device *nativeHIDDevice) Write(report []byte) (int, error) {
+	written, err := device.file.Write(append([]byte{0}, report...))
+	if written > 0 {
+		written--
+	}
+	return written, err
+}
+
+// Read implements io.Reader.
+func (device *nativeHIDDevice) Read(report []byte) (int, error) {
+	buf := make([]byte, len(report)+1)
+	read, err := device.file.Read(buf)
+	if read == 0 {
+		return 0, err
+	}
+	return copy(report, buf[1:read]), err
+}
+
+// Close implements io.Closer.
+func (device *nativeHIDDevice) Close() error {
+	return device.file.Close()
+}
@@ -0,0 +1,27 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package usb
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// openNativeHIDDevice is only supported on Windows, see HIDBackendNative.
+func openNativeHIDDevice(path string) (*nativeHIDDevice, error) {
+	return nil, errp.Newf("the %s HID backend is not supported on this platform", HIDBackendNative)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (buffer *bufferCloser) Close() error {
+	buffer.closed = true
+	return nil
+}
+
+func TestNativeHIDDevice(t *testing.T) {
+	file := &bufferCloser{}
+	device := &nativeHIDDevice{file: file}
+
+	written, err := device.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.Equal(t, 3, written)
+	require.Equal(t, []byte{0, 1, 2, 3}, file.Bytes())
+
+	report := make([]byte, 3)
+	read, err := device.Read(report)
+	require.NoError(t, err)
+	require.Equal(t, 3, read)
+	require.Equal(t, []byte{1, 2, 3}, report)
+
+	require.NoError(t, device.Close())
+	require.True(t, file.closed)
+}
+
+func TestHIDBackends(t *testing.T) {
+	backends := hidBackends()
+	require.Equal(t, HIDBackendHIDAPI, backends[0])
+	if runtime.GOOS != "windows" {
+		require.Equal(t, []string{HIDBackendHIDAPI}, backends)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// openNativeHIDDevice opens the device interface path for reading and writing reports. Unlike
+// hidapi, the device is opened in shared mode, so it can be opened even if another process, e.g.
+// a browser using WebHID, has it open as well.
+func openNativeHIDDevice(path string) (*nativeHIDDevice, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	handle, err := syscall.CreateFile(pathPtr,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE,
+		nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, errp.WithStack(&os.PathError{Op: "open", Path: path, Err: err})
+	}
+	return &nativeHIDDevice{file: os.NewFile(uintptr(handle), path)}, nil
+}
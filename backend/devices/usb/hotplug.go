@@ -0,0 +1,218 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"time"
+
+	"github.com/karalabe/hid"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
+)
+
+// HotplugType is the type of a HotplugEvent.
+type HotplugType string
+
+const (
+	// HotplugAttached is emitted when a device was registered or its session was resumed.
+	HotplugAttached HotplugType = "attached"
+	// HotplugDetached is emitted when a registered device was removed.
+	HotplugDetached HotplugType = "detached"
+	// HotplugRejected is emitted when a device was enumerated, but could not be registered. See
+	// the Reject* constants for the reasons.
+	HotplugRejected HotplugType = "rejected"
+)
+
+// RejectReason is the reason why an enumerated device was not registered.
+type RejectReason string
+
+const (
+	// RejectWrongInterface means that the device was enumerated only with other interfaces than
+	// the one used by the app.
+	RejectWrongInterface RejectReason = "wrongInterface"
+	// RejectNotReady means that the device does not report its product name and serial number.
+	// This is normal for a moment after the device was inserted, but on Linux it persists if the
+	// udev rules are missing.
+	RejectNotReady RejectReason = "notReady"
+	// RejectPermissionDenied means that the device could not be opened due to missing permissions.
+	RejectPermissionDenied RejectReason = "permissionDenied"
+	// RejectUnsupportedFirmware means that the firmware version could not be read from the serial
+	// number, e.g. because the firmware is too old.
+	RejectUnsupportedFirmware RejectReason = "unsupportedFirmware"
+	// RejectFailed means that the device could not be opened or initialized. The event contains
+	// the error.
+	RejectFailed RejectReason = "failed"
+)
+
+const (
+	// hotplugSubject is the subject of the observable events, see HotplugEvents().
+	hotplugSubject = "devices/hotplug"
+	// maxHotplugEvents is the number of events kept for HotplugEvents().
+	maxHotplugEvents = 50
+	// skippedEnumerations is the number of consecutive enumerations in which a device has to be
+	// skipped before it is rejected, as the interfaces are not ready for a moment after each
+	// insertion.
+	skippedEnumerations = 3
+)
+
+// HotplugEvent describes a change of the USB devices.
+type HotplugEvent struct {
+	Type HotplugType `json:"type"`
+	Time time.Time   `json:"time"`
+	// DeviceID is set if the device is registered.
+	DeviceID  string `json:"deviceID,omitempty"`
+	Path      string `json:"path"`
+	VendorID  uint16 `json:"vendorID,omitempty"`
+	ProductID uint16 `json:"productID,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Serial    string `json:"serial,omitempty"`
+	Interface int    `json:"interface"`
+	// Reason and Error are set if the device was rejected.
+	Reason RejectReason `json:"reason,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func newHotplugEvent(hotplugType HotplugType, deviceInfo hid.DeviceInfo) *HotplugEvent {
+	return &HotplugEvent{
+		Type:      hotplugType,
+		Time:      time.Now(),
+		Path:      deviceInfo.Path,
+		VendorID:  deviceInfo.VendorID,
+		ProductID: deviceInfo.ProductID,
+		Product:   deviceInfo.Product,
+		Serial:    deviceInfo.Serial,
+		Interface: deviceInfo.Interface,
+	}
+}
+
+// rejection is a reason why the device at a path is not registered.
+type rejection struct {
+	reason RejectReason
+	// enumerations is the number of consecutive enumerations with the same reason.
+	enumerations int
+}
+
+// rejectReason returns why the enumerated device is skipped, or the empty string if it should be
+// registered.
+func rejectReason(deviceInfo hid.DeviceInfo) RejectReason {
+	if deviceInfo.Interface != 0 && deviceInfo.UsagePage != 0xffff {
+		return RejectWrongInterface
+	}
+	// If Enumerate() is called too quickly after a device is inserted, the HID device input
+	// report is not yet ready.
+	if deviceInfo.Serial == "" || deviceInfo.Product == "" {
+		return RejectNotReady
+	}
+	return ""
+}
+
+// HotplugEvents returns the most recent hotplug events, oldest first. New events are also notified
+// to the observers of the manager with the subject "devices/hotplug".
+func (manager *Manager) HotplugEvents() []*HotplugEvent {
+	defer manager.statusLock.RLock()()
+	return append([]*HotplugEvent{}, manager.hotplugEvents...)
+}
+
+func (manager *Manager) notifyHotplug(event *HotplugEvent) {
+	unlock := manager.statusLock.Lock()
+	manager.hotplugEvents = append(manager.hotplugEvents, event)
+	if len(manager.hotplugEvents) > maxHotplugEvents {
+		manager.hotplugEvents = manager.hotplugEvents[len(manager.hotplugEvents)-maxHotplugEvents:]
+	}
+	unlock()
+	manager.Notify(observable.Event{
+		Subject: hotplugSubject,
+		Action:  action.Append,
+		Object:  event,
+	})
+}
+
+// attached notifies that the device at the path of the given device info was registered.
+func (manager *Manager) attached(deviceID string, deviceInfo hid.DeviceInfo) {
+	delete(manager.rejected, deviceInfo.Path)
+	event := newHotplugEvent(HotplugAttached, deviceInfo)
+	event.DeviceID = deviceID
+	manager.notifyHotplug(event)
+}
+
+// detached notifies that the registered device was removed.
+func (manager *Manager) detached(deviceID string, path string) {
+	manager.notifyHotplug(&HotplugEvent{
+		Type:     HotplugDetached,
+		Time:     time.Now(),
+		DeviceID: deviceID,
+		Path:     path,
+	})
+}
+
+// reject notifies that the device could not be registered, unless it was already rejected for the
+// same reason. err can be nil.
+func (manager *Manager) reject(deviceInfo hid.DeviceInfo, reason RejectReason, err error) {
+	previous, ok := manager.rejected[deviceInfo.Path]
+	if !ok || previous.reason != reason {
+		previous = &rejection{reason: reason}
+		manager.rejected[deviceInfo.Path] = previous
+	}
+	previous.enumerations++
+	threshold := 1
+	if reason == RejectWrongInterface || reason == RejectNotReady {
+		threshold = skippedEnumerations
+	}
+	if previous.enumerations != threshold {
+		return
+	}
+	event := newHotplugEvent(HotplugRejected, deviceInfo)
+	event.Reason = reason
+	if err != nil {
+		event.Error = errp.Cause(err).Error()
+	}
+	manager.log.WithField("path", deviceInfo.Path).WithField("reason", reason).Info("Rejected device")
+	manager.notifyHotplug(event)
+}
+
+// enumerate returns the devices to register. The other enumerated devices are rejected.
+func (manager *Manager) enumerate() []hid.DeviceInfo {
+	all := hid.Enumerate(vendorID, productID)
+	deviceInfos := []hid.DeviceInfo{}
+	usedSerials := map[string]bool{}
+	for _, deviceInfo := range all {
+		if rejectReason(deviceInfo) == "" {
+			deviceInfos = append(deviceInfos, deviceInfo)
+			usedSerials[deviceInfo.Serial] = true
+		}
+	}
+	present := map[string]bool{}
+	for _, deviceInfo := range all {
+		present[deviceInfo.Path] = true
+		reason := rejectReason(deviceInfo)
+		if reason == "" {
+			continue
+		}
+		if reason == RejectWrongInterface && usedSerials[deviceInfo.Serial] {
+			// The device is used through another interface.
+			continue
+		}
+		manager.reject(deviceInfo, reason, nil)
+	}
+	// Report devices again if they are reinserted.
+	for path := range manager.rejected {
+		if !present[path] {
+			delete(manager.rejected, path)
+		}
+	}
+	return deviceInfos
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/karalabe/hid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+)
+
+func TestRejectReason(t *testing.T) {
+	deviceInfo := hid.DeviceInfo{Product: "Digital Bitbox", Serial: "dbb.fw:v4.0.0"}
+	require.Equal(t, RejectReason(""), rejectReason(deviceInfo))
+	deviceInfo.Interface = 1
+	require.Equal(t, RejectWrongInterface, rejectReason(deviceInfo))
+	deviceInfo.UsagePage = 0xffff
+	require.Equal(t, RejectReason(""), rejectReason(deviceInfo))
+	deviceInfo.Serial = ""
+	require.Equal(t, RejectNotReady, rejectReason(deviceInfo))
+}
+
+func TestHotplugEvents(t *testing.T) {
+	manager := NewManager("", func(device.Interface) error { return nil }, func(string) {}, func() {})
+	notified := []*HotplugEvent{}
+	manager.Observe(func(event observable.Event) {
+		require.Equal(t, hotplugSubject, event.Subject)
+		notified = append(notified, event.Object.(*HotplugEvent))
+	})
+	deviceInfo := hid.DeviceInfo{Path: "path", VendorID: vendorID, ProductID: productID}
+
+	// Devices which are not ready are only rejected after a few enumerations.
+	for i := 0; i < skippedEnumerations+2; i++ {
+		manager.reject(deviceInfo, RejectNotReady, nil)
+		if i < skippedEnumerations-1 {
+			require.Empty(t, notified)
+		}
+	}
+	require.Len(t, notified, 1)
+	require.Equal(t, HotplugRejected, notified[0].Type)
+	require.Equal(t, RejectNotReady, notified[0].Reason)
+	require.Equal(t, uint16(vendorID), notified[0].VendorID)
+
+	// A different reason is reported right away, once.
+	manager.reject(deviceInfo, RejectPermissionDenied, errors.New("permission denied"))
+	manager.reject(deviceInfo, RejectPermissionDenied, errors.New("permission denied"))
+	require.Len(t, notified, 2)
+	require.Equal(t, RejectPermissionDenied, notified[1].Reason)
+	require.Equal(t, "permission denied", notified[1].Error)
+
+	manager.attached("id", deviceInfo)
+	require.Empty(t, manager.rejected)
+	manager.detached("id", deviceInfo.Path)
+	require.Len(t, notified, 4)
+	require.Equal(t, HotplugAttached, notified[2].Type)
+	require.Equal(t, "id", notified[2].DeviceID)
+	require.Equal(t, HotplugDetached, notified[3].Type)
+	require.Equal(t, notified, manager.HotplugEvents())
+
+	for i := 0; i < maxHotplugEvents; i++ {
+		manager.detached("id", deviceInfo.Path)
+	}
+	require.Len(t, manager.HotplugEvents(), maxHotplugEvents)
+}
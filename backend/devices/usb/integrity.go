@@ -0,0 +1,88 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRetransmits is how often a command is sent again after its reply was corrupted.
+const maxRetransmits = 2
+
+// idempotentCommands can be sent again if their reply was corrupted, as executing them twice has
+// no effect on the device. Other commands, e.g. "seed" or "sign", are never retransmitted.
+var idempotentCommands = map[string]bool{
+	"ping":   true,
+	"device": true,
+	"xpub":   true,
+	"random": true,
+}
+
+// CorruptReplyErr is returned if the reply of the device was garbled in transit, e.g. by a noisy USB
+// hub: its frames were out of sequence, or it could not be decoded. The USB protocol has no
+// checksum, so the integrity of a reply is verified by decoding it.
+type CorruptReplyErr struct {
+	Reason string
+}
+
+func (err *CorruptReplyErr) Error() string {
+	return "corrupt reply from the device: " + err.Reason
+}
+
+// isCorruptReply returns true if the error is a *CorruptReplyErr.
+func isCorruptReply(err error) bool {
+	_, ok := errp.Cause(err).(*CorruptReplyErr)
+	return ok
+}
+
+// retransmit runs the round trip f, and runs it again up to maxRetransmits times if the reply was
+// corrupted and the command is idempotent.
+func (communication *Communication) retransmit(ctx context.Context, command string, f func() error) error {
+	return communication.retransmitIf(ctx, command, idempotentCommands[command], f)
+}
+
+// retransmitIf is like retransmit(), but whether the command is idempotent is given by the caller,
+// e.g. for a batch of commands.
+func (communication *Communication) retransmitIf(
+	ctx context.Context, command string, idempotent bool, f func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if !isCorruptReply(err) || !idempotent || attempt == maxRetransmits ||
+			ctx.Err() != nil {
+			return err
+		}
+		communication.log.WithError(err).WithFields(logrus.Fields{"command": command,
+			"attempt": attempt + 1}).Warning("Corrupt reply, sending the command again")
+		communication.health.RecordRetry(command)
+	}
+}
+
+// readInitFrame reads the first frame of a reply of the given channel into read and returns its
+// length. Continuation frames left over from a corrupt reply are skipped.
+func (transport *ReportTransport) readInitFrame(read []byte, cid uint32) (int, error) {
+	for i := 0; i < maxForeignFrames; i++ {
+		readLen, err := transport.readChannelFrame(read, cid)
+		if err != nil {
+			return 0, err
+		}
+		if readLen < 5 || read[4]&u2fHIDTypeInit != 0 {
+			return readLen, nil
+		}
+	}
+	return 0, errp.WithStack(&CorruptReplyErr{Reason: "no init frame"})
+}
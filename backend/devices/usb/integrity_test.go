@@ -0,0 +1,111 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedDevice replies to the commands with the given frames, in order.
+type scriptedDevice struct {
+	commands int
+	frames   [][]byte
+}
+
+func (device *scriptedDevice) Write(p []byte) (int, error) {
+	if p[4]&u2fHIDTypeInit != 0 {
+		device.commands++
+	}
+	return len(p), nil
+}
+
+func (device *scriptedDevice) Read(p []byte) (int, error) {
+	frame := device.frames[0]
+	device.frames = device.frames[1:]
+	return copy(p, frame), nil
+}
+
+func (device *scriptedDevice) Close() error {
+	return nil
+}
+
+func reply(data string) []byte {
+	return u2fFrame(hwwCID, hwwCMD, []byte(data))
+}
+
+func continuationFrame(seq byte, data string) []byte {
+	frame := make([]byte, 64)
+	binary.BigEndian.PutUint32(frame, hwwCID)
+	frame[4] = seq
+	copy(frame[5:], data)
+	return frame
+}
+
+func newScriptedCommunication(frames ...[]byte) (*Communication, *scriptedDevice) {
+	device := &scriptedDevice{frames: frames}
+	communication := NewCommunication(device, 64, 64)
+	communication.DisableChannelAllocation()
+	return communication, device
+}
+
+func TestRetransmit(t *testing.T) {
+	communication, device := newScriptedCommunication(
+		reply(`{"ping":"pass`), reply(`{"ping":"password"}`))
+	result, err := communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, "password", result["ping"])
+	require.Equal(t, 2, device.commands)
+	stats := communication.Health()
+	require.Equal(t, 1, stats.Retries)
+	require.Equal(t, 0, stats.Faults)
+
+	// Gives up after maxRetransmits.
+	communication, device = newScriptedCommunication(
+		reply(`{`), reply(`{`), reply(`{`), reply(`{"ping":"password"}`))
+	_, err = communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.IsType(t, &CorruptReplyErr{}, errors.Cause(err))
+	require.Equal(t, 1+maxRetransmits, device.commands)
+	stats = communication.Health()
+	require.Equal(t, maxRetransmits, stats.Retries)
+	require.Equal(t, 1, stats.Faults)
+
+	// Commands with side effects are not sent again.
+	communication, device = newScriptedCommunication(reply(`{"seed":`), reply(`{"seed":"success"}`))
+	_, err = communication.SendPlain(context.Background(), `{"seed":""}`)
+	require.IsType(t, &CorruptReplyErr{}, errors.Cause(err))
+	require.Equal(t, 1, device.commands)
+}
+
+func TestFrameSequence(t *testing.T) {
+	long := `{"ping":"password","padding":"` + strings.Repeat("x", 150) + `"}`
+	init := u2fFrame(hwwCID, hwwCMD, []byte(long[:57]))
+	binary.BigEndian.PutUint16(init[5:], uint16(len(long)))
+
+	// The first continuation frame is lost, so the reply is out of sequence and the command is sent
+	// again. The remaining continuation frame is skipped when reading the new reply.
+	communication, device := newScriptedCommunication(
+		init, continuationFrame(1, long[57+59:57+2*59]), continuationFrame(2, long[57+2*59:]),
+		reply(`{"ping":"password"}`))
+	result, err := communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, "password", result["ping"])
+	require.Equal(t, 2, device.commands)
+}
@@ -15,37 +15,46 @@
 package usb
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path"
 	"regexp"
 	"time"
 
+	"github.com/flynn/noise"
 	"github.com/karalabe/hid"
 	"github.com/sirupsen/logrus"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
 )
 
 const (
 	vendorID  = 0x03eb
 	productID = 0x2402
+
+	// reconnectTimeout is how long the session with a disconnected device is kept in case the device
+	// is reconnected, e.g. after the computer resumed from sleep or the USB hub reset the port.
+	reconnectTimeout = 15 * time.Second
+
+	// captureFilename is the file in the channel config dir to which the USB traffic is captured.
+	captureFilename = "usb-capture.pcap"
+	// captureMaxSize is the size at which the capture file is rotated.
+	captureMaxSize = 4 * 1024 * 1024
 )
 
 // DeviceInfos returns a slice of all found bitbox devices.
 func DeviceInfos() []hid.DeviceInfo {
 	deviceInfos := []hid.DeviceInfo{}
 	for _, deviceInfo := range hid.Enumerate(vendorID, productID) {
-		if deviceInfo.Interface != 0 && deviceInfo.UsagePage != 0xffff {
-			continue
-		}
-		// If Enumerate() is called too quickly after a device is inserted, the HID device input
-		// report is not yet ready.
-		if deviceInfo.Serial == "" || deviceInfo.Product == "" {
+		if rejectReason(deviceInfo) != "" {
 			continue
 		}
 		deviceInfos = append(deviceInfos, deviceInfo)
@@ -53,19 +62,75 @@ func DeviceInfos() []hid.DeviceInfo {
 	return deviceInfos
 }
 
+// resumableDevice is implemented by devices whose session can be resumed after a transient
+// disconnect, see bitbox.Device.Resume().
+type resumableDevice interface {
+	device.Interface
+	Resumable() bool
+	Suspend()
+	Resume(bitbox.CommunicationInterface) error
+}
+
+// disconnectedDevice is a device which disappeared and is waiting to be reconnected.
+type disconnectedDevice struct {
+	device          resumableDevice
+	firmwareVersion *semver.SemVer
+	since           time.Time
+}
+
 // Manager listens for devices and notifies when a device has been inserted or removed.
 type Manager struct {
-	devices          map[string]device.Interface
+	observable.Implementation
+
+	devices map[string]device.Interface
+	// paths maps the device IDs to the HID path of the device. A reconnected device keeps its ID
+	// even if it was enumerated under a different path.
+	paths map[string]string
+	// firmwareVersions maps the device IDs to the firmware version read from the serial number.
+	firmwareVersions map[string]*semver.SemVer
+	// disconnected contains the devices which were removed while the user was logged in. They are
+	// unregistered if they are not reconnected within reconnectTimeout.
+	disconnected     map[string]*disconnectedDevice
 	channelConfigDir string // passed to each device during initialization
 
-	onRegister   func(device.Interface) error
-	onUnregister func(string)
+	onRegister         func(device.Interface) error
+	onUnregister       func(string)
+	onPermissionDenied func()
+
+	// permissionDenied is true if a device was enumerated but could not be opened due to missing
+	// permissions (e.g. missing udev rules on Linux).
+	permissionDenied bool
+	// hidBackends maps the device IDs to the HID backend used to open the device.
+	hidBackends map[string]string
+	// reportSizes maps the device IDs to the report sizes used to communicate with the device.
+	reportSizes map[string]*ReportSizes
+	// hidFallbacks counts how often the default HID backend failed to open a device and another
+	// backend was used instead.
+	hidFallbacks int
+	statusLock   locker.Locker
+
+	// simulatorAddress is the TCP address of the device simulator, or empty. See SetSimulator().
+	simulatorAddress string
+	// simulator is the connection to the simulator, or nil if it was never connected.
+	simulator *simulatorConn
+
+	// capture records the USB traffic of all devices while enabled, see Capture().
+	capture *Capture
+	// noiseStatic is the static key with which the app establishes Noise channels to the devices,
+	// or nil if the Noise channel is disabled. See SetNoise(). Protected by statusLock.
+	noiseStatic *noise.DHKey
+
+	// rejected contains the enumerated devices which are not registered by path, see reject().
+	rejected map[string]*rejection
+	// hotplugEvents are the most recent events, see HotplugEvents(). Protected by statusLock.
+	hotplugEvents []*HotplugEvent
 
 	log *logrus.Entry
 }
 
 // NewManager creates a new Manager. onRegister is called when a device has been
-// inserted. onUnregister is called when the device has been removed.
+// inserted. onUnregister is called when the device has been removed. onPermissionDenied is called
+// when a device is present, but can't be opened due to missing permissions.
 //
 // The channelConfigDir argument is passed to each device during initialization,
 // before onRegister is called.
@@ -73,13 +138,91 @@ func NewManager(
 	channelConfigDir string,
 	onRegister func(device.Interface) error,
 	onUnregister func(string),
+	onPermissionDenied func(),
 ) *Manager {
 	return &Manager{
-		devices:          map[string]device.Interface{},
-		channelConfigDir: channelConfigDir,
-		onRegister:       onRegister,
-		onUnregister:     onUnregister,
-		log:              logging.Get().WithGroup("manager"),
+		devices:            map[string]device.Interface{},
+		paths:              map[string]string{},
+		firmwareVersions:   map[string]*semver.SemVer{},
+		disconnected:       map[string]*disconnectedDevice{},
+		channelConfigDir:   channelConfigDir,
+		onRegister:         onRegister,
+		onUnregister:       onUnregister,
+		onPermissionDenied: onPermissionDenied,
+		hidBackends:        map[string]string{},
+		reportSizes:        map[string]*ReportSizes{},
+		rejected:           map[string]*rejection{},
+		capture:            NewCapture(path.Join(channelConfigDir, captureFilename), captureMaxSize),
+		log:                logging.Get().WithGroup("manager"),
+	}
+}
+
+// Capture returns the capture of the USB traffic, which is stopped initially.
+func (manager *Manager) Capture() *Capture {
+	return manager.capture
+}
+
+// SetNoise enables or disables the Noise channel for the devices registered from now on. If it is
+// enabled, the encrypted commands go through a Noise channel if the firmware supports it. The
+// static key of the app is generated anew each time it is enabled.
+func (manager *Manager) SetNoise(enabled bool) error {
+	var static *noise.DHKey
+	if enabled {
+		var err error
+		static, err = newNoiseKeyPair()
+		if err != nil {
+			return err
+		}
+	}
+	defer manager.statusLock.Lock()()
+	manager.noiseStatic = static
+	return nil
+}
+
+// PermissionDenied returns true if a device is present, but could not be opened due to missing
+// permissions.
+func (manager *Manager) PermissionDenied() bool {
+	defer manager.statusLock.RLock()()
+	return manager.permissionDenied
+}
+
+// Diagnostics contains information about the USB layer which is useful for troubleshooting.
+type Diagnostics struct {
+	PermissionDenied bool              `json:"permissionDenied"`
+	HIDBackends      map[string]string `json:"hidBackends"`
+	HIDFallbacks     int               `json:"hidFallbacks"`
+	// ReportSizes are the report sizes used for each device, and whether they were declared by the
+	// device.
+	ReportSizes map[string]*ReportSizes `json:"reportSizes"`
+}
+
+// Diagnostics returns information about the USB layer for the diagnostics report.
+func (manager *Manager) Diagnostics() *Diagnostics {
+	defer manager.statusLock.RLock()()
+	hidBackends := map[string]string{}
+	for deviceID, backend := range manager.hidBackends {
+		hidBackends[deviceID] = backend
+	}
+	reportSizes := map[string]*ReportSizes{}
+	for deviceID, sizes := range manager.reportSizes {
+		sizesCopy := *sizes
+		reportSizes[deviceID] = &sizesCopy
+	}
+	return &Diagnostics{
+		PermissionDenied: manager.permissionDenied,
+		HIDBackends:      hidBackends,
+		ReportSizes:      reportSizes,
+		HIDFallbacks:     manager.hidFallbacks,
+	}
+}
+
+func (manager *Manager) setPermissionDenied(permissionDenied bool) {
+	unlock := manager.statusLock.Lock()
+	changed := manager.permissionDenied != permissionDenied
+	manager.permissionDenied = permissionDenied
+	unlock()
+	if changed && permissionDenied && manager.onPermissionDenied != nil {
+		manager.onPermissionDenied()
 	}
 }
 
@@ -90,43 +233,122 @@ func deviceIdentifier(productName string, path string) string {
 func (manager *Manager) register(deviceInfo hid.DeviceInfo) error {
 	deviceID := deviceIdentifier(bitbox.ProductName, deviceInfo.Path)
 	// Skip if already registered.
-	if _, ok := manager.devices[deviceID]; ok {
-		return nil
+	for _, path := range manager.paths {
+		if path == deviceInfo.Path {
+			return nil
+		}
 	}
 	manager.log.WithField("device-id", deviceID).Info("Registering device")
-	bootloader := deviceInfo.Product == "bootloader" || deviceInfo.Product == "Digital Bitbox bootloader"
-	match := regexp.MustCompile(`v([0-9]+\.[0-9]+\.[0-9]+)`).FindStringSubmatch(deviceInfo.Serial)
-	if len(match) != 2 {
-		manager.log.WithField("serial", deviceInfo.Serial).Error("Serial number is malformed")
-		return errp.Newf("Could not find the firmware version in '%s'.", deviceInfo.Serial)
-	}
-	firmwareVersion, err := semver.NewSemVerFromString(match[1])
+	bootloader, firmwareVersion, err := parseDeviceInfo(deviceInfo.Product, deviceInfo.Serial)
 	if err != nil {
-		return errp.WithContext(errp.WithMessage(err, "Failed to read version from serial number"),
-			errp.Context{"serial": deviceInfo.Serial})
+		manager.reject(deviceInfo, RejectUnsupportedFirmware, err)
+		return err
 	}
 
-	hidDevice, err := deviceInfo.Open()
+	hidDevice, hidBackend, err := openDevice(deviceInfo)
 	if err != nil {
+		if isPermissionError(err) {
+			manager.setPermissionDenied(true)
+			manager.reject(deviceInfo, RejectPermissionDenied, err)
+			return errp.WithMessage(ErrPermissionDenied, err.Error())
+		}
+		manager.reject(deviceInfo, RejectFailed, err)
 		return errp.WithMessage(err, "Failed to open device")
 	}
+	manager.setPermissionDenied(false)
+	manager.log.WithField("hid-backend", hidBackend).Info("Opened device")
+	unlock := manager.statusLock.Lock()
+	if hidBackend != hidBackends()[0] {
+		manager.hidFallbacks++
+	}
+	unlock()
 
-	usbWriteReportSize := 64
-	usbReadReportSize := 64
-	if bootloader && !firmwareVersion.AtLeast(semver.NewSemVer(3, 0, 0)) {
-		// Bootloader 3.0.0 changed to composite USB. Since then, the report lengths are 65/65,
-		// not 4099/256 (including report ID).  See dev->output_report_length at
-		// https://github.com/signal11/hidapi/blob/a6a622ffb680c55da0de787ff93b80280498330f/windows/hid.c#L626
-		usbWriteReportSize = 4098
-		usbReadReportSize = 256
+	sizes := deviceReportSizes(deviceInfo, bootloader, firmwareVersion, manager.log)
+	manager.log.WithFields(logrus.Fields{"write": sizes.Write, "read": sizes.Read, "source": sizes.Source}).
+		Info("Report sizes")
+	communication := NewCommunication(hidDevice, sizes.Write, sizes.Read)
+	if err := manager.registerDevice(
+		deviceID, deviceInfo.Path, bootloader, firmwareVersion, hidBackend, communication); err != nil {
+		manager.reject(deviceInfo, RejectFailed, err)
+		return err
 	}
-	manager.log.Infof("usbWriteReportSize=%d, usbReadReportSize=%d", usbWriteReportSize, usbReadReportSize)
+	// The ID differs if the session of a disconnected device was resumed.
+	for registeredID, path := range manager.paths {
+		if path == deviceInfo.Path {
+			deviceID = registeredID
+		}
+	}
+	unlock = manager.statusLock.Lock()
+	manager.reportSizes[deviceID] = sizes
+	unlock()
+	manager.attached(deviceID, deviceInfo)
+	return nil
+}
+
+// parseDeviceInfo returns whether the device is in bootloader mode and its firmware version, given
+// the product name and the serial number it reports.
+func parseDeviceInfo(product string, serial string) (bool, *semver.SemVer, error) {
+	bootloader := product == "bootloader" || product == "Digital Bitbox bootloader"
+	match := regexp.MustCompile(`v([0-9]+\.[0-9]+\.[0-9]+)`).FindStringSubmatch(serial)
+	if len(match) != 2 {
+		return false, nil, errp.Newf("Could not find the firmware version in '%s'.", serial)
+	}
+	firmwareVersion, err := semver.NewSemVerFromString(match[1])
+	if err != nil {
+		return false, nil, errp.WithContext(errp.WithMessage(err, "Failed to read version from serial number"),
+			errp.Context{"serial": serial})
+	}
+	return bootloader, firmwareVersion, nil
+}
+
+// registerDevice registers the device with the given communication, or resumes the session of a
+// disconnected device. The path identifies the connection of the device, and hidBackend is
+// reported in the diagnostics.
+func (manager *Manager) registerDevice(
+	deviceID string,
+	path string,
+	bootloader bool,
+	firmwareVersion *semver.SemVer,
+	hidBackend string,
+	communication *Communication,
+) error {
+	communication.SetCapture(manager.capture)
+	unlock := manager.statusLock.RLock()
+	noiseStatic := manager.noiseStatic
+	unlock()
+	if noiseStatic != nil && !bootloader {
+		communication.enableNoise(noiseStatic)
+	}
+	if bootloader || !firmwareVersion.AtLeast(semver.NewSemVer(2, 0, 0)) {
+		// Channels are allocated using U2FHID_INIT, which is supported since U2F was introduced.
+		communication.DisableChannelAllocation()
+	}
+	if !bootloader {
+		if err := communication.negotiateNonces(context.Background()); err != nil {
+			return err
+		}
+	}
+	if !bootloader {
+		// Firmware which does not send events is not affected, as its frames are all replies.
+		communication.enableEvents()
+	}
+	if !bootloader && manager.resume(path, firmwareVersion, hidBackend, communication) {
+		return nil
+	}
+	// A resumed device keeps the ID derived from its previous path, which can now be taken by
+	// another device.
+	for i := 1; manager.devices[deviceID] != nil || manager.disconnected[deviceID] != nil; i++ {
+		deviceID = deviceIdentifier(bitbox.ProductName, fmt.Sprintf("%s#%d", path, i))
+	}
+	unlock = manager.statusLock.Lock()
+	manager.hidBackends[deviceID] = hidBackend
+	unlock()
 	device, err := bitbox.NewDevice(
 		deviceID,
 		bootloader,
 		firmwareVersion,
 		manager.channelConfigDir,
-		NewCommunication(hidDevice, usbWriteReportSize, usbReadReportSize),
+		communication,
 	)
 	if err != nil {
 		return errp.WithMessage(err, "Failed to establish communication to device")
@@ -135,6 +357,17 @@ func (manager *Manager) register(deviceInfo hid.DeviceInfo) error {
 		return errp.WithMessage(err, "Failed to execute on-register")
 	}
 	manager.devices[deviceID] = device
+	manager.paths[deviceID] = path
+	manager.firmwareVersions[deviceID] = firmwareVersion
+
+	// Re-flash the firmware right away if a previous upgrade was interrupted.
+	if device.RecoveryPending() {
+		go func() {
+			if err := device.BootloaderRecover(); err != nil {
+				manager.log.WithError(err).Error("Failed to recover the firmware")
+			}
+		}()
+	}
 
 	// Unlock the device automatically if the user set the PIN as an environment variable.
 	pin := os.Getenv("BITBOX_PIN")
@@ -148,14 +381,66 @@ func (manager *Manager) register(deviceInfo hid.DeviceInfo) error {
 	return nil
 }
 
+// resume tries to continue the session of a disconnected device with the same firmware version
+// using the communication to the newly inserted device. It returns true if a session was resumed.
+// If the inserted device is not the disconnected device, the disconnected device is unregistered, as
+// it would have to be unlocked again anyway.
+func (manager *Manager) resume(
+	path string,
+	firmwareVersion *semver.SemVer,
+	hidBackend string,
+	communication bitbox.CommunicationInterface,
+) bool {
+	for deviceID, disconnected := range manager.disconnected {
+		if !disconnected.firmwareVersion.AtLeast(firmwareVersion) ||
+			!firmwareVersion.AtLeast(disconnected.firmwareVersion) {
+			continue
+		}
+		log := manager.log.WithField("device-id", deviceID)
+		if err := disconnected.device.Resume(communication); err != nil {
+			log.WithError(err).Info("Failed to resume the session")
+			manager.unregister(deviceID, disconnected.device)
+			continue
+		}
+		delete(manager.disconnected, deviceID)
+		manager.devices[deviceID] = disconnected.device
+		manager.paths[deviceID] = path
+		manager.firmwareVersions[deviceID] = firmwareVersion
+		unlock := manager.statusLock.Lock()
+		manager.hidBackends[deviceID] = hidBackend
+		unlock()
+		log.Info("Resumed the session with the reconnected device")
+		return true
+	}
+	return false
+}
+
+// unregister closes the device and notifies that it was removed.
+func (manager *Manager) unregister(deviceID string, device device.Interface) {
+	device.Close()
+	delete(manager.devices, deviceID)
+	delete(manager.paths, deviceID)
+	delete(manager.firmwareVersions, deviceID)
+	delete(manager.disconnected, deviceID)
+	unlock := manager.statusLock.Lock()
+	delete(manager.hidBackends, deviceID)
+	delete(manager.reportSizes, deviceID)
+	unlock()
+	manager.onUnregister(deviceID)
+	manager.log.WithField("device-id", deviceID).Info("Unregistered device")
+}
+
 // checkIfRemoved returns true if a device was plugged in, but is not plugged in anymore.
-func (manager *Manager) checkIfRemoved(deviceID string) bool {
+func (manager *Manager) checkIfRemoved(path string) bool {
+	if manager.simulatorAddress != "" && path == manager.simulatorPath() {
+		return manager.simulatorRemoved()
+	}
 	// In edge cases, device enumeration hangs waiting for the device, and can be empty for a very
 	// short amount of time even though the device is still plugged in. The workaround is to check
 	// multiple times.
 	for i := 0; i < 5; i++ {
 		for _, deviceInfo := range DeviceInfos() {
-			if deviceIdentifier(bitbox.ProductName, deviceInfo.Path) == deviceID {
+			if deviceInfo.Path == path {
 				return false
 			}
 		}
@@ -169,21 +454,51 @@ func (manager *Manager) ListenHID() {
 	for {
 		for deviceID, device := range manager.devices {
 			// Check if device was removed.
-			if manager.checkIfRemoved(deviceID) {
-				device.Close()
+			if !manager.checkIfRemoved(manager.paths[deviceID]) {
+				continue
+			}
+			manager.detached(deviceID, manager.paths[deviceID])
+			// Keep the session in case the device reappears shortly, see resume().
+			if resumable, ok := device.(resumableDevice); ok && resumable.Resumable() {
+				resumable.Suspend()
 				delete(manager.devices, deviceID)
-				manager.onUnregister(deviceID)
-				manager.log.WithField("device-id", deviceID).Info("Unregistered device")
+				delete(manager.paths, deviceID)
+				manager.disconnected[deviceID] = &disconnectedDevice{
+					device:          resumable,
+					firmwareVersion: manager.firmwareVersions[deviceID],
+					since:           time.Now(),
+				}
+				manager.log.WithField("device-id", deviceID).Info("Device disconnected")
+				continue
+			}
+			manager.unregister(deviceID, device)
+		}
+		for deviceID, disconnected := range manager.disconnected {
+			if time.Since(disconnected.since) > reconnectTimeout {
+				manager.unregister(deviceID, disconnected.device)
 			}
 		}
 
 		// Check if device was inserted.
-		deviceInfos := DeviceInfos()
+		deviceInfos := manager.enumerate()
+		if len(deviceInfos) == 0 {
+			manager.setPermissionDenied(false)
+		}
+		permissionDenied := manager.PermissionDenied()
 		for _, deviceInfo := range deviceInfos {
 			if err := manager.register(deviceInfo); err != nil {
+				if errp.Cause(err) == ErrPermissionDenied && permissionDenied {
+					// Already reported, don't flood the log every second.
+					continue
+				}
 				manager.log.WithError(err).Error("Failed to register device")
 			}
 		}
+		if manager.simulatorAddress != "" {
+			if err := manager.registerSimulator(); err != nil {
+				manager.log.WithError(err).Debug("Failed to register the simulator")
+			}
+		}
 		time.Sleep(time.Second)
 	}
 }
@@ -0,0 +1,240 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mocks contains in-memory implementations of the USB layer interfaces for tests.
+package mocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// frameTypeInit is set in the command byte of init frames.
+const frameTypeInit = 0x80
+
+// Exchange is a scripted request of the host and the reply of the device. The framing is decoded
+// and encoded independently of the usb package, so that its framing is verified.
+type Exchange struct {
+	// CID is the expected channel of the request, or 0 for any channel. The reply is sent on the
+	// channel of the request.
+	CID uint32
+	// Command is the expected command byte of the request. The reply has the same command.
+	Command byte
+	// Request is the expected message, or nil for any message.
+	Request []byte
+	// Reply is the reply message. If ReplyFunc is set, the reply is returned by it instead.
+	Reply     []byte
+	ReplyFunc func(request []byte) []byte
+}
+
+// DeviceTransport implements usb.DeviceTransport as an in-memory device which answers the scripted
+// exchanges in order. It checks that the request reports are framed correctly, and splits the
+// replies into reports.
+type DeviceTransport struct {
+	requestReportSize int
+	replyReportSize   int
+	exchanges         []*Exchange
+
+	// request is the message being received, and requestLen its announced length.
+	request    *bytes.Buffer
+	requestLen int
+	requestCID uint32
+	requestCmd byte
+	nextSeq    int
+
+	// replies are the reports waiting to be read.
+	replies [][]byte
+	// reports counts the written reports.
+	reports int
+	closed  bool
+	err     error
+	lock    sync.Mutex
+}
+
+// NewDeviceTransport creates a device exchanging reports of the given size.
+func NewDeviceTransport(reportSize int, exchanges ...*Exchange) *DeviceTransport {
+	return NewAsymmetricDeviceTransport(reportSize, reportSize, exchanges...)
+}
+
+// NewAsymmetricDeviceTransport creates a device which receives requests in reports of
+// requestReportSize bytes and sends replies in reports of replyReportSize bytes.
+func NewAsymmetricDeviceTransport(
+	requestReportSize int, replyReportSize int, exchanges ...*Exchange) *DeviceTransport {
+	return &DeviceTransport{
+		requestReportSize: requestReportSize,
+		replyReportSize:   replyReportSize,
+		exchanges:         exchanges,
+	}
+}
+
+// fail records the first error, which is returned by all further reads and writes.
+func (transport *DeviceTransport) fail(format string, args ...interface{}) error {
+	if transport.err == nil {
+		transport.err = errp.Newf(format, args...)
+	}
+	return transport.err
+}
+
+// Write implements io.Writer. It receives one report.
+func (transport *DeviceTransport) Write(report []byte) (int, error) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	if transport.err != nil {
+		return 0, transport.err
+	}
+	if transport.closed {
+		return 0, transport.fail("write after close")
+	}
+	if len(report) != transport.requestReportSize {
+		return 0, transport.fail("report of %d bytes, expected %d", len(report), transport.requestReportSize)
+	}
+	transport.reports++
+	cid := binary.BigEndian.Uint32(report)
+	if report[4]&frameTypeInit != 0 {
+		if transport.request != nil {
+			return 0, transport.fail("init frame before the previous request was complete")
+		}
+		transport.request = new(bytes.Buffer)
+		transport.requestLen = int(binary.BigEndian.Uint16(report[5:7]))
+		transport.requestCID = cid
+		transport.requestCmd = report[4]
+		transport.nextSeq = 0
+		transport.receive(report[7:])
+	} else {
+		if transport.request == nil {
+			return 0, transport.fail("continuation frame without init frame")
+		}
+		if cid != transport.requestCID {
+			return 0, transport.fail("continuation frame on channel %08x, expected %08x", cid, transport.requestCID)
+		}
+		if int(report[4]) != transport.nextSeq {
+			return 0, transport.fail("continuation frame %d, expected %d", report[4], transport.nextSeq)
+		}
+		transport.nextSeq++
+		transport.receive(report[5:])
+	}
+	if transport.request.Len() == transport.requestLen {
+		if err := transport.answer(); err != nil {
+			return 0, err
+		}
+	}
+	return len(report), nil
+}
+
+// receive appends the data of a frame to the request, without the padding of the last frame.
+func (transport *DeviceTransport) receive(data []byte) {
+	if missing := transport.requestLen - transport.request.Len(); len(data) > missing {
+		data = data[:missing]
+	}
+	transport.request.Write(data)
+}
+
+// answer matches the complete request with the next exchange and queues the reply.
+func (transport *DeviceTransport) answer() error {
+	request := transport.request.Bytes()
+	transport.request = nil
+	if len(transport.exchanges) == 0 {
+		return transport.fail("unexpected request %q", request)
+	}
+	exchange := transport.exchanges[0]
+	transport.exchanges = transport.exchanges[1:]
+	if exchange.CID != 0 && exchange.CID != transport.requestCID {
+		return transport.fail("request on channel %08x, expected %08x", transport.requestCID, exchange.CID)
+	}
+	if exchange.Command != transport.requestCmd {
+		return transport.fail("command %02x, expected %02x", transport.requestCmd, exchange.Command)
+	}
+	if exchange.Request != nil && !bytes.Equal(exchange.Request, request) {
+		return transport.fail("request %q, expected %q", request, exchange.Request)
+	}
+	reply := exchange.Reply
+	if exchange.ReplyFunc != nil {
+		reply = exchange.ReplyFunc(request)
+	}
+	transport.replies = append(transport.replies,
+		Frames(transport.replyReportSize, transport.requestCID, transport.requestCmd, reply)...)
+	return nil
+}
+
+// Frames splits the message into the reports of a frame, padded with zeros.
+func Frames(reportSize int, cid uint32, cmd byte, msg []byte) [][]byte {
+	newReport := func() []byte {
+		report := make([]byte, reportSize)
+		binary.BigEndian.PutUint32(report, cid)
+		return report
+	}
+	report := newReport()
+	report[4] = cmd
+	binary.BigEndian.PutUint16(report[5:], uint16(len(msg)))
+	msg = msg[copy(report[7:], msg):]
+	reports := [][]byte{report}
+	for seq := 0; len(msg) > 0; seq++ {
+		report = newReport()
+		report[4] = byte(seq)
+		msg = msg[copy(report[5:], msg):]
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Read implements io.Reader. It returns the next report of the replies, or an error if there is
+// none, as the device would never reply.
+func (transport *DeviceTransport) Read(report []byte) (int, error) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	if transport.err != nil {
+		return 0, transport.err
+	}
+	if transport.closed {
+		return 0, transport.fail("read after close")
+	}
+	if len(transport.replies) == 0 {
+		return 0, transport.fail("read without a pending reply")
+	}
+	n := copy(report, transport.replies[0])
+	transport.replies = transport.replies[1:]
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (transport *DeviceTransport) Close() error {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	transport.closed = true
+	return nil
+}
+
+// Reports returns the number of reports written so far.
+func (transport *DeviceTransport) Reports() int {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	return transport.reports
+}
+
+// Verify returns an error if a request did not match the script, or if not all exchanges took
+// place.
+func (transport *DeviceTransport) Verify() error {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	if transport.err != nil {
+		return transport.err
+	}
+	if len(transport.exchanges) != 0 {
+		return errp.Newf("%d scripted exchanges did not take place", len(transport.exchanges))
+	}
+	return nil
+}
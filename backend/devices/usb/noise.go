@@ -0,0 +1,285 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+	"github.com/flynn/noise"
+)
+
+const (
+	// NoiseVersionLegacy is the protocol version if the device does not support the Noise channel.
+	// The commands are then only encrypted with the double SHA256 of the password.
+	NoiseVersionLegacy = 0
+	// NoiseVersionXX is the protocol version of the Noise_XX_25519_ChaChaPoly_SHA256 channel.
+	NoiseVersionXX = 1
+)
+
+// noiseVersions are the protocol versions offered to the device, in order of preference.
+var noiseVersions = []int{NoiseVersionXX}
+
+// noisePrologue binds the offered versions to the handshake, so that an attacker can't strip the
+// newer versions from the offer without the handshake failing. An attacker can still reply to the
+// whole offer with an error, as if the device did not know the noise command, which is why a device
+// that established the Noise channel before is not allowed to refuse it, see PinNoise().
+func noisePrologue(versions []int) []byte {
+	prologue := []byte("bitbox-noise")
+	for _, version := range versions {
+		prologue = append(prologue, byte(version))
+	}
+	return prologue
+}
+
+// ErrNoiseDowngrade is returned if a device refuses the Noise channel although it established it
+// before, see PinNoise().
+var ErrNoiseDowngrade = errors.New("the device refused the Noise channel it established before")
+
+// ErrNoiseIdentity is returned if the device identifies with a different static key in the Noise
+// handshake than before, see PinNoise().
+var ErrNoiseIdentity = errors.New("the device presented a different Noise static key than before")
+
+// ErrUnprotectedReply is returned if the device replies to an encrypted command in plain text
+// although the Noise channel is established or the nonces are enabled. Only the errors of the
+// device are expected in plain text, as the device can't encrypt the reply if it could not decrypt
+// the command.
+var ErrUnprotectedReply = errors.New("unencrypted reply to an encrypted command")
+
+// noiseCipherSuite are the primitives of the Noise_XX_25519_ChaChaPoly_SHA256 channel.
+var noiseCipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// newNoiseKeyPair creates a random Curve25519 key pair.
+func newNoiseKeyPair() (*noise.DHKey, error) {
+	keyPair, err := noiseCipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return &keyPair, nil
+}
+
+// newNoiseHandshake starts the handshake of the XX pattern:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+func newNoiseHandshake(initiator bool, static *noise.DHKey, prologue []byte) (*noise.HandshakeState, error) {
+	handshake, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   noiseCipherSuite,
+		Random:        rand.Reader,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     initiator,
+		Prologue:      prologue,
+		StaticKeypair: *static,
+	})
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return handshake, nil
+}
+
+// noiseSession encrypts the messages after the handshake. The nonces are counters, so every message
+// has to be decrypted in the order it was encrypted.
+type noiseSession struct {
+	send         *noise.CipherState
+	receive      *noise.CipherState
+	remoteStatic []byte
+}
+
+// enableNoise makes the encrypted commands go through a Noise channel if the device supports it.
+// The channel is established before the first encrypted command, identifying the app with the
+// given static key. The password encryption is kept inside the channel, so that the device still
+// verifies the password, but a passive observer of the USB traffic can't brute force a weak
+// password from the recorded cipher texts anymore. It must be called before the first round trip.
+func (communication *Communication) enableNoise(static *noise.DHKey) {
+	communication.noiseStatic = static
+}
+
+// NoiseEnabled returns true if the encrypted commands go through a Noise channel if the device
+// supports it, see enableNoise().
+func (communication *Communication) NoiseEnabled() bool {
+	return communication.noiseStatic != nil
+}
+
+// NoiseVersion returns the negotiated protocol version, see NoiseVersionLegacy and
+// NoiseVersionXX, and the static key of the device if the Noise channel is established.
+func (communication *Communication) NoiseVersion() (int, []byte) {
+	communication.noiseLock.Lock()
+	defer communication.noiseLock.Unlock()
+	if communication.noise == nil {
+		return NoiseVersionLegacy, nil
+	}
+	return NoiseVersionXX, append([]byte(nil), communication.noise.remoteStatic...)
+}
+
+// PinNoise makes the device identify with the given static key in all Noise handshakes from now
+// on, e.g. with the key it presented on a previous connection, see NoiseVersion(). A device with a
+// pinned key is also not allowed to fall back to the legacy encryption, see ErrNoiseDowngrade. The
+// key of the first handshake is pinned automatically.
+func (communication *Communication) PinNoise(remoteStatic []byte) {
+	communication.noiseLock.Lock()
+	defer communication.noiseLock.Unlock()
+	communication.noisePinned = append([]byte(nil), remoteStatic...)
+}
+
+// establishNoise establishes the Noise channel if it is enabled and not established yet. Concurrent
+// encrypted commands wait for the same handshake.
+func (communication *Communication) establishNoise(ctx context.Context) error {
+	if communication.noiseStatic == nil {
+		return nil
+	}
+	communication.noiseHandshakeLock.Lock()
+	defer communication.noiseHandshakeLock.Unlock()
+	communication.noiseLock.Lock()
+	established := communication.noise != nil || communication.noiseUnsupported
+	pinned := communication.noisePinned
+	communication.noiseLock.Unlock()
+	if established {
+		return nil
+	}
+	session, err := communication.noiseHandshake(ctx, pinned)
+	if err != nil {
+		return err
+	}
+	communication.noiseLock.Lock()
+	defer communication.noiseLock.Unlock()
+	if session == nil {
+		communication.noiseUnsupported = true
+		return nil
+	}
+	communication.noise = session
+	communication.noisePinned = session.remoteStatic
+	return nil
+}
+
+// noiseHandshake runs the Noise handshake and returns the established session. The versions are
+// offered with the first handshake message. If the device does not know the noise command, it
+// replies with an error and nil is returned, so that the legacy encryption is used from then on,
+// unless the static key of the device is pinned. The device has to identify with the pinned key,
+// if it is not nil.
+func (communication *Communication) noiseHandshake(ctx context.Context, pinned []byte) (*noiseSession, error) {
+	handshake, err := newNoiseHandshake(true, communication.noiseStatic, noisePrologue(noiseVersions))
+	if err != nil {
+		return nil, err
+	}
+	message, _, _, err := handshake.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	reply, err := communication.sendPlain(ctx, "noise", string(jsonp.MustMarshal(map[string]interface{}{
+		"noise": map[string]interface{}{
+			"versions": noiseVersions,
+			"message":  base64.StdEncoding.EncodeToString(message),
+		},
+	})))
+	if _, ok := errp.Cause(err).(*bitbox.Error); ok {
+		if pinned != nil {
+			communication.log.WithError(err).Error("The device refused the Noise channel it established before")
+			return nil, errp.WithStack(ErrNoiseDowngrade)
+		}
+		communication.log.WithError(err).Info("The device does not support the Noise channel")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to start the Noise handshake")
+	}
+	noiseReply, ok := reply["noise"].(map[string]interface{})
+	if !ok {
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "unexpected Noise handshake reply"})
+	}
+	if version, _ := noiseReply["version"].(float64); int(version) != NoiseVersionXX {
+		return nil, errp.Newf("The device chose the unsupported Noise version %v", noiseReply["version"])
+	}
+	encodedMessage, _ := noiseReply["message"].(string)
+	message, err = base64.StdEncoding.DecodeString(encodedMessage)
+	if err != nil {
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "invalid base64 Noise handshake message"})
+	}
+	if _, _, _, err := handshake.ReadMessage(nil, message); err != nil {
+		return nil, errp.Wrap(err, "Invalid Noise handshake message")
+	}
+	if pinned != nil && !bytes.Equal(handshake.PeerStatic(), pinned) {
+		communication.log.Error("The device presented a different Noise static key than before")
+		return nil, errp.WithStack(ErrNoiseIdentity)
+	}
+	message, send, receive, err := handshake.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	_, err = communication.sendPlain(ctx, "noise", string(jsonp.MustMarshal(map[string]interface{}{
+		"noise": map[string]interface{}{
+			"message": base64.StdEncoding.EncodeToString(message),
+		},
+	})))
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to finish the Noise handshake")
+	}
+	communication.log.Info("Established the Noise channel")
+	return &noiseSession{
+		send:         send,
+		receive:      receive,
+		remoteStatic: append([]byte(nil), handshake.PeerStatic()...),
+	}, nil
+}
+
+// noiseEncrypt encrypts the password encrypted command for the Noise channel and returns the
+// session with which the reply is decrypted, see noiseDecrypt(). It returns the command unchanged
+// and a nil session if the Noise channel is disabled or not supported by the device. The channel
+// must have been established with establishNoise(). Must be called during the round trip of the
+// command, so that the commands are encrypted in the order in which the device receives them.
+func (communication *Communication) noiseEncrypt(cipherText []byte) ([]byte, *noiseSession, error) {
+	if communication.noiseStatic == nil {
+		return cipherText, nil, nil
+	}
+	communication.noiseLock.Lock()
+	session, unsupported := communication.noise, communication.noiseUnsupported
+	communication.noiseLock.Unlock()
+	if session == nil {
+		if unsupported {
+			return cipherText, nil, nil
+		}
+		// The channel was reset by a failed command since it was established.
+		return nil, nil, errp.New("The Noise channel is not established")
+	}
+	encrypted, err := session.send.Encrypt(nil, nil, cipherText)
+	if err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	return encrypted, session, nil
+}
+
+// noiseDecrypt decrypts the reply received over the Noise channel of the given session. Must be
+// called during the round trip of the command.
+func noiseDecrypt(session *noiseSession, cipherText []byte) ([]byte, error) {
+	if session == nil {
+		return cipherText, nil
+	}
+	plainText, err := session.receive.Decrypt(nil, nil, cipherText)
+	return plainText, errp.WithStack(err)
+}
+
+// resetNoise drops the Noise channel after a failed command, as the nonces of the app and the
+// device could be out of sync. The next encrypted command establishes a new one.
+func (communication *Communication) resetNoise() {
+	communication.noiseLock.Lock()
+	defer communication.noiseLock.Unlock()
+	communication.noise = nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/flynn/noise"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+)
+
+// noiseDevice implements Transport as the responder of the Noise handshake. It replies to each
+// encrypted command with the command itself, or with plainReply if it is set.
+type noiseDevice struct {
+	t          *testing.T
+	supported  bool
+	static     *noise.DHKey
+	password   string
+	handshake  *noise.HandshakeState
+	receive    *noise.CipherState
+	send       *noise.CipherState
+	reply      []byte
+	plainReply []byte
+}
+
+func (device *noiseDevice) SendFrame(cid uint32, cmd byte, msg []byte) error {
+	command := map[string]map[string]interface{}{}
+	if json.Unmarshal(msg, &command) == nil {
+		if !device.supported {
+			device.reply = []byte(`{"error":{"message":"Command not found.","code":103}}`)
+			return nil
+		}
+		message, err := base64.StdEncoding.DecodeString(command["noise"]["message"].(string))
+		require.NoError(device.t, err)
+		if _, ok := command["noise"]["versions"]; ok {
+			device.handshake, err = newNoiseHandshake(false, device.static, noisePrologue(noiseVersions))
+			require.NoError(device.t, err)
+			_, _, _, err = device.handshake.ReadMessage(nil, message)
+			require.NoError(device.t, err)
+			message, _, _, err = device.handshake.WriteMessage(nil, nil)
+			require.NoError(device.t, err)
+			device.reply = jsonp.MustMarshal(map[string]interface{}{
+				"noise": map[string]interface{}{
+					"version": NoiseVersionXX,
+					"message": base64.StdEncoding.EncodeToString(message),
+				},
+			})
+			return nil
+		}
+		_, device.receive, device.send, err = device.handshake.ReadMessage(nil, message)
+		require.NoError(device.t, err)
+		device.reply = []byte(`{"noise":"success"}`)
+		return nil
+	}
+	secret := chainhash.DoubleHashB([]byte(device.password))
+	cipherText, err := base64.StdEncoding.DecodeString(string(msg))
+	require.NoError(device.t, err)
+	if device.receive != nil {
+		cipherText, err = device.receive.Decrypt(nil, nil, cipherText)
+		require.NoError(device.t, err)
+	}
+	plainText, err := crypto.Decrypt(cipherText, secret)
+	require.NoError(device.t, err)
+	if device.plainReply != nil {
+		device.reply = device.plainReply
+		return nil
+	}
+	cipherText, err = crypto.Encrypt(plainText, secret)
+	require.NoError(device.t, err)
+	if device.send != nil {
+		cipherText, err = device.send.Encrypt(nil, nil, cipherText)
+		require.NoError(device.t, err)
+	}
+	device.reply = jsonp.MustMarshal(map[string]string{
+		"ciphertext": base64.StdEncoding.EncodeToString(cipherText),
+	})
+	return nil
+}
+
+func (device *noiseDevice) ReadFrame(cid uint32, cmd byte) ([]byte, error) {
+	return device.reply, nil
+}
+
+func (device *noiseDevice) Close() error {
+	return nil
+}
+
+func TestNoise(t *testing.T) {
+	deviceStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	appStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	device := &noiseDevice{t: t, supported: true, static: deviceStatic, password: "password"}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNoise(appStatic)
+
+	for i := 0; i < 3; i++ {
+		reply, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"ping": ""}, reply)
+	}
+	version, remoteStatic := communication.NoiseVersion()
+	require.Equal(t, NoiseVersionXX, version)
+	require.Equal(t, deviceStatic.Public, remoteStatic)
+	require.Equal(t, appStatic.Public, device.handshake.PeerStatic())
+	require.Equal(t, uint64(3), device.receive.Nonce())
+}
+
+func TestNoiseUnsupported(t *testing.T) {
+	appStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	device := &noiseDevice{t: t, password: "password"}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNoise(appStatic)
+
+	reply, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"ping": ""}, reply)
+	version, remoteStatic := communication.NoiseVersion()
+	require.Equal(t, NoiseVersionLegacy, version)
+	require.Nil(t, remoteStatic)
+}
+
+func TestNoiseUnprotectedReply(t *testing.T) {
+	deviceStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	appStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	device := &noiseDevice{t: t, supported: true, static: deviceStatic, password: "password"}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNoise(appStatic)
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.NoError(t, err)
+
+	device.plainReply = []byte(`{"ping":""}`)
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.Equal(t, ErrUnprotectedReply, errp.Cause(err))
+
+	// Errors of the device are accepted in plain text.
+	device.plainReply = []byte(`{"error":{"message":"Wrong password.","code":102}}`)
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	dbbErr, ok := errp.Cause(err).(*bitbox.Error)
+	require.True(t, ok)
+	require.Equal(t, float64(102), dbbErr.Code)
+}
+
+func TestNoiseDowngrade(t *testing.T) {
+	deviceStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	appStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	device := &noiseDevice{t: t, supported: true, static: deviceStatic, password: "password"}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNoise(appStatic)
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.NoError(t, err)
+
+	// The device established the Noise channel before, so it can't fall back to the legacy
+	// encryption.
+	device.supported = false
+	communication.resetNoise()
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.Equal(t, ErrNoiseDowngrade, errp.Cause(err))
+
+	// Another device can't take its place.
+	otherStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	device.supported = true
+	device.static = otherStatic
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.Equal(t, ErrNoiseIdentity, errp.Cause(err))
+
+	// A pinned key is checked in the first handshake already.
+	pinned := NewTransportCommunication(device)
+	pinned.DisableChannelAllocation()
+	pinned.enableNoise(appStatic)
+	pinned.PinNoise(deviceStatic.Public)
+	_, err = pinned.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.Equal(t, ErrNoiseIdentity, errp.Cause(err))
+	device.static = deviceStatic
+	_, err = pinned.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.NoError(t, err)
+}
+
+func TestNoiseHandshakeTampered(t *testing.T) {
+	appStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	deviceStatic, err := newNoiseKeyPair()
+	require.NoError(t, err)
+	initiator, err := newNoiseHandshake(true, appStatic, noisePrologue(noiseVersions))
+	require.NoError(t, err)
+	// The device sees a different offer, e.g. because the newer versions were stripped.
+	responder, err := newNoiseHandshake(false, deviceStatic, noisePrologue(nil))
+	require.NoError(t, err)
+	message, _, _, err := initiator.WriteMessage(nil, nil)
+	require.NoError(t, err)
+	_, _, _, err = responder.ReadMessage(nil, message)
+	require.NoError(t, err)
+	message, _, _, err = responder.WriteMessage(nil, nil)
+	require.NoError(t, err)
+	_, _, _, err = initiator.ReadMessage(nil, message)
+	require.Error(t, err)
+}
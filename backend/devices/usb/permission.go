@@ -0,0 +1,67 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// UdevRulesFilename is the location of the udev rules which grant the logged in user access to
+	// the BitBox on Linux.
+	UdevRulesFilename = "/etc/udev/rules.d/52-hid-digitalbitbox.rules"
+
+	// UdevRules is the content of the udev rules file.
+	UdevRules = `SUBSYSTEM=="usb", TAG+="uaccess", TAG+="udev-acl", SYMLINK+="dbb%n", ATTRS{idVendor}=="03eb", ATTRS{idProduct}=="2402"
+KERNEL=="hidraw*", SUBSYSTEM=="hidraw", ATTRS{idVendor}=="03eb", ATTRS{idProduct}=="2402", TAG+="uaccess", TAG+="udev-acl", SYMLINK+="dbbf%n"
+`
+)
+
+// ErrPermissionDenied is returned when a device is present but can't be opened due to missing
+// permissions, as opposed to no device being present at all.
+var ErrPermissionDenied = errors.New("permission denied to open the device")
+
+// UdevRulesInstalled returns true if the udev rules file exists. On other platforms than Linux,
+// true is returned as no rules are needed.
+func UdevRulesInstalled() bool {
+	if runtime.GOOS != "linux" {
+		return true
+	}
+	_, err := os.Stat(UdevRulesFilename)
+	return err == nil
+}
+
+// isPermissionError returns true if the error returned when opening an enumerated device was
+// caused by insufficient permissions (EACCES or EPERM). Other failures, e.g. a device which is
+// busy or was unplugged, are not permission problems. The hid library only reports the message of
+// the errno, so the message is checked as well.
+func isPermissionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errp.Cause(err)
+	if os.IsPermission(cause) || cause == syscall.EACCES || cause == syscall.EPERM {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "permission denied") ||
+		strings.Contains(message, "operation not permitted")
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func TestIsPermissionError(t *testing.T) {
+	require.False(t, isPermissionError(nil))
+	require.True(t, isPermissionError(syscall.EACCES))
+	require.True(t, isPermissionError(syscall.EPERM))
+	require.True(t, isPermissionError(
+		&os.PathError{Op: "open", Path: "/dev/hidraw0", Err: syscall.EACCES}))
+	require.True(t, isPermissionError(errp.WithStack(os.ErrPermission)))
+	require.True(t, isPermissionError(errors.New("hidapi: open /dev/hidraw0: Permission denied")))
+
+	// Other failures to open a device are not permission problems.
+	require.False(t, isPermissionError(
+		&os.PathError{Op: "open", Path: "/dev/hidraw0", Err: syscall.EBUSY}))
+	require.False(t, isPermissionError(errp.WithStack(syscall.ENOENT)))
+	require.False(t, isPermissionError(errors.New("hidapi: failed to open device")))
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue orders the commands sent to a device. Only one command can be in flight at a time,
+// so commands wait in the queue until the device is free. Waiting commands are served by priority
+// and then in the order they arrived, so that e.g. a signing request does not wait behind status
+// polls. A running command is never interrupted.
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// Priority orders the waiting commands. Higher priorities are served first.
+type Priority int
+
+const (
+	// PriorityLow is for background requests, like status polls.
+	PriorityLow Priority = iota
+	// PriorityNormal is the default priority.
+	PriorityNormal
+	// PriorityHigh is for requests the user is waiting for, like signing.
+	PriorityHigh
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+// String returns the name of the priority.
+func (priority Priority) String() string {
+	if name, ok := priorityNames[priority]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the priority by its name.
+func (priority Priority) MarshalText() ([]byte, error) {
+	return []byte(priority.String()), nil
+}
+
+// Entry describes a running or waiting command.
+type Entry struct {
+	Command  string   `json:"command"`
+	Priority Priority `json:"priority"`
+	// Since is when the command started running, or when it was queued if it is waiting.
+	Since time.Time `json:"since"`
+}
+
+// Status is a snapshot of the queue.
+type Status struct {
+	// Running is the command in flight, or nil if the device is idle.
+	Running *Entry `json:"running"`
+	// Waiting are the queued commands in the order they will run.
+	Waiting []*Entry `json:"waiting"`
+}
+
+type waiter struct {
+	entry    Entry
+	sequence uint64
+	// ready is closed when the waiter is next.
+	ready chan struct{}
+}
+
+// Queue grants exclusive access to a device, one command at a time. It is safe for concurrent use.
+type Queue struct {
+	running  *Entry
+	waiting  []*waiter
+	sequence uint64
+	lock     locker.Locker
+}
+
+// New creates an empty queue.
+func New() *Queue {
+	return &Queue{waiting: []*waiter{}}
+}
+
+// before returns true if waiter runs before other.
+func (waiter *waiter) before(other *waiter) bool {
+	if waiter.entry.Priority != other.entry.Priority {
+		return waiter.entry.Priority > other.entry.Priority
+	}
+	return waiter.sequence < other.sequence
+}
+
+// Acquire waits until the command can run and returns the function to call when it is done. It
+// returns the context error if the context is canceled while waiting.
+func (queue *Queue) Acquire(ctx context.Context, command string, priority Priority) (func(), error) {
+	unlock := queue.lock.Lock()
+	if queue.running == nil && len(queue.waiting) == 0 {
+		queue.running = &Entry{Command: command, Priority: priority, Since: time.Now()}
+		unlock()
+		return queue.release, nil
+	}
+	queue.sequence++
+	waiter := &waiter{
+		entry:    Entry{Command: command, Priority: priority, Since: time.Now()},
+		sequence: queue.sequence,
+		ready:    make(chan struct{}),
+	}
+	index := len(queue.waiting)
+	for i, other := range queue.waiting {
+		if waiter.before(other) {
+			index = i
+			break
+		}
+	}
+	queue.waiting = append(queue.waiting, nil)
+	copy(queue.waiting[index+1:], queue.waiting[index:])
+	queue.waiting[index] = waiter
+	unlock()
+
+	select {
+	case <-waiter.ready:
+		return queue.release, nil
+	case <-ctx.Done():
+	}
+	defer queue.lock.Lock()()
+	for i, other := range queue.waiting {
+		if other == waiter {
+			queue.waiting = append(queue.waiting[:i], queue.waiting[i+1:]...)
+			return nil, errp.WithStack(ctx.Err())
+		}
+	}
+	// The command was granted concurrently with the cancellation. Pass the turn on.
+	queue.next()
+	return nil, errp.WithStack(ctx.Err())
+}
+
+func (queue *Queue) release() {
+	defer queue.lock.Lock()()
+	queue.next()
+}
+
+// next hands the device to the first waiting command. Must be called with the lock held.
+func (queue *Queue) next() {
+	if len(queue.waiting) == 0 {
+		queue.running = nil
+		return
+	}
+	waiter := queue.waiting[0]
+	queue.waiting = queue.waiting[1:]
+	waiter.entry.Since = time.Now()
+	queue.running = &waiter.entry
+	close(waiter.ready)
+}
+
+// Status returns a snapshot of the running and the waiting commands.
+func (queue *Queue) Status() *Status {
+	defer queue.lock.RLock()()
+	status := &Status{Waiting: make([]*Entry, len(queue.waiting))}
+	if queue.running != nil {
+		running := *queue.running
+		status.Running = &running
+	}
+	for index, waiter := range queue.waiting {
+		entry := waiter.entry
+		status.Waiting[index] = &entry
+	}
+	return status
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context which queues the commands sent with it at the given priority,
+// instead of the default priority of the command.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set with WithPriority(), if any.
+func PriorityFromContext(ctx context.Context) (Priority, bool) {
+	priority, ok := ctx.Value(priorityKey{}).(Priority)
+	return priority, ok
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/queue"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// waitForWaiting waits until the given number of commands wait in the queue.
+func waitForWaiting(t *testing.T, commandQueue *queue.Queue, count int) {
+	for i := 0; i < 1000; i++ {
+		if len(commandQueue.Status().Waiting) == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.FailNow(t, "commands not queued")
+}
+
+func TestPriorities(t *testing.T) {
+	commandQueue := queue.New()
+	require.Nil(t, commandQueue.Status().Running)
+
+	release, err := commandQueue.Acquire(context.Background(), "upgrade", queue.PriorityNormal)
+	require.NoError(t, err)
+
+	order := make(chan string, 4)
+	acquire := func(command string, priority queue.Priority) {
+		release, err := commandQueue.Acquire(context.Background(), command, priority)
+		require.NoError(t, err)
+		order <- command
+		release()
+	}
+	go acquire("ping", queue.PriorityLow)
+	waitForWaiting(t, commandQueue, 1)
+	go acquire("device", queue.PriorityLow)
+	waitForWaiting(t, commandQueue, 2)
+	go acquire("xpub", queue.PriorityNormal)
+	waitForWaiting(t, commandQueue, 3)
+	go acquire("sign", queue.PriorityHigh)
+	waitForWaiting(t, commandQueue, 4)
+
+	status := commandQueue.Status()
+	require.Equal(t, "upgrade", status.Running.Command)
+	commands := []string{}
+	for _, entry := range status.Waiting {
+		commands = append(commands, entry.Command)
+	}
+	require.Equal(t, []string{"sign", "xpub", "ping", "device"}, commands)
+
+	release()
+	for _, expected := range []string{"sign", "xpub", "ping", "device"} {
+		require.Equal(t, expected, <-order)
+	}
+	waitForWaiting(t, commandQueue, 0)
+	for i := 0; i < 1000 && commandQueue.Status().Running != nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	require.Nil(t, commandQueue.Status().Running)
+}
+
+func TestCancel(t *testing.T) {
+	commandQueue := queue.New()
+	release, err := commandQueue.Acquire(context.Background(), "sign", queue.PriorityHigh)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error)
+	go func() {
+		_, err := commandQueue.Acquire(ctx, "ping", queue.PriorityLow)
+		errChan <- err
+	}()
+	waitForWaiting(t, commandQueue, 1)
+	cancel()
+	require.Equal(t, context.Canceled, errp.Cause(<-errChan))
+	require.Empty(t, commandQueue.Status().Waiting)
+
+	release()
+	release, err = commandQueue.Acquire(context.Background(), "device", queue.PriorityLow)
+	require.NoError(t, err)
+	require.Equal(t, "device", commandQueue.Status().Running.Command)
+	release()
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	_, ok := queue.PriorityFromContext(context.Background())
+	require.False(t, ok)
+	priority, ok := queue.PriorityFromContext(
+		queue.WithPriority(context.Background(), queue.PriorityHigh))
+	require.True(t, ok)
+	require.Equal(t, queue.PriorityHigh, priority)
+	text, err := priority.MarshalText()
+	require.NoError(t, err)
+	require.Equal(t, "high", string(text))
+}
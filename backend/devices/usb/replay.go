@@ -0,0 +1,124 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// nonceKey is the key of the nonce added to every encrypted command if the firmware supports it,
+// see negotiateNonces(). Such firmware rejects commands whose nonce is not larger than the one of
+// the previous command, and echoes the nonce in the encrypted reply. Older firmware neither checks
+// nor echoes it, so the encrypted commands are not protected against replays at all.
+const nonceKey = "nonce"
+
+// ReplayErr is returned if the encrypted reply of the device does not carry the nonce of the
+// command, i.e. if it was recorded earlier and replayed by someone on the USB path.
+type ReplayErr struct {
+	Expected uint64
+	// Received is the nonce in the reply, or nil if the reply had none.
+	Received *uint64
+}
+
+func (err *ReplayErr) Error() string {
+	if err.Received == nil {
+		return fmt.Sprintf("replayed reply from the device: nonce %d missing", err.Expected)
+	}
+	return fmt.Sprintf("replayed reply from the device: nonce %d instead of %d", *err.Received, err.Expected)
+}
+
+// nonceCounter generates the nonces of the encrypted commands of a communication.
+type nonceCounter struct {
+	last uint64
+	lock sync.Mutex
+}
+
+// newNonceCounter creates a counter starting at the current time in microseconds, so that the
+// nonces keep increasing if the app is restarted while the device stays connected. The nonces stay
+// below 2^53, so that they survive being decoded as JSON numbers.
+func newNonceCounter() *nonceCounter {
+	return &nonceCounter{last: uint64(time.Now().UnixNano() / int64(time.Microsecond))}
+}
+
+// next returns the next nonce.
+func (counter *nonceCounter) next() uint64 {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.last++
+	return counter.last
+}
+
+// enableNonces adds a nonce to every encrypted command and rejects the replies which do not echo
+// it. It must only be called if the firmware supports nonces, and before the first encrypted round
+// trip.
+func (communication *Communication) enableNonces() {
+	communication.nonces = newNonceCounter()
+}
+
+// negotiateNonces asks the device whether its firmware checks the nonces of the encrypted commands,
+// and enables them if it does, see enableNonces(). Firmware which does not know the nonce command
+// rejects it with an error, like the batches in SendEncryptBatch(). The reply is not encrypted, so
+// someone on the USB path could hide the support, but not fake it, as the encrypted replies would
+// then miss the nonces. It must be called before the first encrypted round trip.
+func (communication *Communication) negotiateNonces(ctx context.Context) error {
+	reply, err := communication.SendPlain(ctx, `{"nonce":""}`)
+	if _, ok := errp.Cause(err).(*bitbox.Error); ok {
+		communication.log.WithError(err).Info("The firmware does not check the nonces")
+		return nil
+	}
+	if err != nil {
+		return errp.WithMessage(err, "Failed to ask the device whether it checks the nonces")
+	}
+	if supported, _ := reply[nonceKey].(bool); supported {
+		communication.enableNonces()
+	}
+	return nil
+}
+
+// withNonce adds the nonce to the JSON command.
+func withNonce(msg string, nonce uint64) (string, error) {
+	command := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(msg), &command); err != nil {
+		return "", errp.WithStack(err)
+	}
+	command[nonceKey] = json.RawMessage(fmt.Sprintf("%d", nonce))
+	withNonce, err := json.Marshal(command)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	return string(withNonce), nil
+}
+
+// checkReply returns a *ReplayErr if the decrypted reply does not carry the given nonce of the
+// command.
+func checkReply(plainText []byte, nonce uint64) error {
+	reply := struct {
+		Nonce *uint64 `json:"nonce"`
+	}{}
+	if err := json.Unmarshal(plainText, &reply); err != nil {
+		return errp.WithStack(&CorruptReplyErr{Reason: "invalid decrypted JSON"})
+	}
+	if reply.Nonce == nil || *reply.Nonce != nonce {
+		return errp.WithStack(&ReplayErr{Expected: nonce, Received: reply.Nonce})
+	}
+	return nil
+}
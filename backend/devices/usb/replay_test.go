@@ -0,0 +1,172 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+)
+
+// nonceDevice implements Transport and replies to each encrypted command with the command itself,
+// including its nonce unless echoNonce is false. If replay is set, it is sent instead of the reply.
+// The nonce command is answered with the support of the nonces, which is echoNonce.
+type nonceDevice struct {
+	t         *testing.T
+	echoNonce bool
+	nonces    []uint64
+	reply     []byte
+	replay    []byte
+}
+
+func (device *nonceDevice) SendFrame(cid uint32, cmd byte, msg []byte) error {
+	if string(msg) == `{"nonce":""}` {
+		if device.echoNonce {
+			device.reply = []byte(`{"nonce":true}`)
+		} else {
+			device.reply = []byte(`{"error":{"message":"Invalid command.","code":104}}`)
+		}
+		return nil
+	}
+	secret := chainhash.DoubleHashB([]byte("password"))
+	cipherText, err := base64.StdEncoding.DecodeString(string(msg))
+	require.NoError(device.t, err)
+	plainText, err := crypto.Decrypt(cipherText, secret)
+	require.NoError(device.t, err)
+	command := map[string]interface{}{}
+	require.NoError(device.t, json.Unmarshal(plainText, &command))
+	nonce := struct {
+		Nonce *uint64 `json:"nonce"`
+	}{}
+	require.NoError(device.t, json.Unmarshal(plainText, &nonce))
+	if nonce.Nonce != nil {
+		device.nonces = append(device.nonces, *nonce.Nonce)
+	}
+	if !device.echoNonce {
+		delete(command, nonceKey)
+	}
+	cipherText, err = crypto.Encrypt(jsonp.MustMarshal(command), secret)
+	require.NoError(device.t, err)
+	device.reply = jsonp.MustMarshal(map[string]string{
+		"ciphertext": base64.StdEncoding.EncodeToString(cipherText),
+	})
+	return nil
+}
+
+func (device *nonceDevice) ReadFrame(cid uint32, cmd byte) ([]byte, error) {
+	if device.replay != nil {
+		return device.replay, nil
+	}
+	return device.reply, nil
+}
+
+func (device *nonceDevice) Close() error {
+	return nil
+}
+
+func TestSendEncryptNonce(t *testing.T) {
+	device := &nonceDevice{t: t, echoNonce: true}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNonces()
+	for i := 0; i < 2; i++ {
+		reply, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"ping": ""}, reply)
+	}
+	require.Len(t, device.nonces, 2)
+	require.Equal(t, device.nonces[0]+1, device.nonces[1])
+
+	// A recorded reply is rejected.
+	device.replay = device.reply
+	_, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	replayErr, ok := errp.Cause(err).(*ReplayErr)
+	require.True(t, ok)
+	require.Equal(t, device.nonces[2], replayErr.Expected)
+	require.Equal(t, device.nonces[1], *replayErr.Received)
+
+	// Replies without the nonce are rejected.
+	device.replay = nil
+	device.echoNonce = false
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	replayErr, ok = errp.Cause(err).(*ReplayErr)
+	require.True(t, ok)
+	require.Nil(t, replayErr.Received)
+}
+
+func TestSendEncryptNonceMissing(t *testing.T) {
+	// The first reply has to carry the nonce as well.
+	device := &nonceDevice{t: t}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNonces()
+	_, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	replayErr, ok := errp.Cause(err).(*ReplayErr)
+	require.True(t, ok)
+	require.Nil(t, replayErr.Received)
+}
+
+func TestSendEncryptNonceUnsupported(t *testing.T) {
+	// No nonce is sent to firmware which does not support it.
+	device := &nonceDevice{t: t}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	reply, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"ping": ""}, reply)
+	require.Empty(t, device.nonces)
+}
+
+func TestNegotiateNonces(t *testing.T) {
+	device := &nonceDevice{t: t, echoNonce: true}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	require.NoError(t, communication.negotiateNonces(context.Background()))
+	require.NotNil(t, communication.nonces)
+
+	device.echoNonce = false
+	communication = NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	require.NoError(t, communication.negotiateNonces(context.Background()))
+	require.Nil(t, communication.nonces)
+}
+
+func TestSendEncryptNonceUnprotectedReply(t *testing.T) {
+	device := &nonceDevice{t: t, echoNonce: true}
+	communication := NewTransportCommunication(device)
+	communication.DisableChannelAllocation()
+	communication.enableNonces()
+
+	// A plain text reply carries no nonce, so it could have been recorded earlier.
+	device.replay = []byte(`{"ping":""}`)
+	_, err := communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	require.Equal(t, ErrUnprotectedReply, errp.Cause(err))
+
+	// Errors of the device are accepted in plain text.
+	device.replay = []byte(`{"error":{"message":"Wrong password.","code":102}}`)
+	_, err = communication.SendEncrypt(context.Background(), `{"ping":""}`, "password")
+	dbbErr, ok := errp.Cause(err).(*bitbox.Error)
+	require.True(t, ok)
+	require.Equal(t, float64(102), dbbErr.Code)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"github.com/karalabe/hid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+)
+
+const (
+	// ReportSizesDeclared means that the report sizes were declared by the device, in its HID report
+	// descriptor.
+	ReportSizesDeclared = "declared"
+	// ReportSizesDefault means that the report sizes are the ones known for the firmware, as the
+	// device did not declare them or they could not be read on this platform.
+	ReportSizesDefault = "default"
+)
+
+// ReportSizes are the sizes in bytes of the reports written to and read from a device, excluding
+// the report ID. They can differ, e.g. behind some USB-C adapters.
+type ReportSizes struct {
+	Write int `json:"write"`
+	Read  int `json:"read"`
+	// Source is ReportSizesDeclared or ReportSizesDefault.
+	Source string `json:"source"`
+}
+
+// errNoReportDescriptor is returned by readReportSizes() if the report sizes of the device can not
+// be determined on this platform.
+var errNoReportDescriptor = errp.New("the report descriptor is not available on this platform")
+
+// reportSizes returns the sizes of the reports written to and read from the device which are known
+// for the firmware.
+func reportSizes(bootloader bool, firmwareVersion *semver.SemVer) (int, int) {
+	if bootloader && !firmwareVersion.AtLeast(semver.NewSemVer(3, 0, 0)) {
+		// Bootloader 3.0.0 changed to composite USB. Since then, the report lengths are 65/65,
+		// not 4099/256 (including report ID).  See dev->output_report_length at
+		// https://github.com/signal11/hidapi/blob/a6a622ffb680c55da0de787ff93b80280498330f/windows/hid.c#L626
+		return 4098, 256
+	}
+	return 64, 64
+}
+
+// deviceReportSizes returns the report sizes declared by the device, or the ones known for the
+// firmware if they can't be read.
+func deviceReportSizes(
+	deviceInfo hid.DeviceInfo,
+	bootloader bool,
+	firmwareVersion *semver.SemVer,
+	log *logrus.Entry,
+) *ReportSizes {
+	defaultWrite, defaultRead := reportSizes(bootloader, firmwareVersion)
+	write, read, err := readReportSizes(deviceInfo)
+	if err != nil {
+		log.WithError(err).Info("Using the default report sizes")
+		return &ReportSizes{Write: defaultWrite, Read: defaultRead, Source: ReportSizesDefault}
+	}
+	if write != defaultWrite || read != defaultRead {
+		log.WithFields(logrus.Fields{"write": write, "read": read, "default-write": defaultWrite,
+			"default-read": defaultRead}).Warning("The device declares unusual report sizes")
+	}
+	return &ReportSizes{Write: write, Read: read, Source: ReportSizesDeclared}
+}
+
+// HID report descriptor items, see section 6.2.2 of the Device Class Definition for HID 1.11.
+const (
+	hidItemTypeMain   = 0
+	hidItemTypeGlobal = 1
+
+	hidTagInput  = 0x8
+	hidTagOutput = 0x9
+
+	hidTagReportSize  = 0x7
+	hidTagReportID    = 0x8
+	hidTagReportCount = 0x9
+	hidTagPush        = 0xa
+	hidTagPop         = 0xb
+
+	// hidLongItem is the prefix of long items, which carry no report information.
+	hidLongItem = 0xfe
+
+	// maxReportSize is the largest report size accepted from a report descriptor.
+	maxReportSize = 1 << 16
+)
+
+// parseReportDescriptor returns the sizes of the output and input reports declared in the HID report
+// descriptor, in bytes. Numbered reports are not supported, as the reports exchanged with
+// the device are not prefixed by their ID.
+func parseReportDescriptor(descriptor []byte) (int, int, error) {
+	type globals struct {
+		reportSize  uint64
+		reportCount uint64
+	}
+	current := globals{}
+	stack := []globals{}
+	var outputBits, inputBits uint64
+	for index := 0; index < len(descriptor); {
+		prefix := descriptor[index]
+		if prefix == hidLongItem {
+			if index+1 >= len(descriptor) {
+				return 0, 0, errp.New("truncated long item in the report descriptor")
+			}
+			index += 3 + int(descriptor[index+1])
+			continue
+		}
+		size := int(prefix & 0x3)
+		if size == 3 {
+			size = 4
+		}
+		if index+1+size > len(descriptor) {
+			return 0, 0, errp.New("truncated item in the report descriptor")
+		}
+		var data uint64
+		for i := 0; i < size; i++ {
+			data |= uint64(descriptor[index+1+i]) << (8 * uint(i))
+		}
+		index += 1 + size
+		itemType := (prefix >> 2) & 0x3
+		tag := prefix >> 4
+		switch {
+		case itemType == hidItemTypeGlobal && tag == hidTagReportSize:
+			current.reportSize = data
+		case itemType == hidItemTypeGlobal && tag == hidTagReportCount:
+			current.reportCount = data
+		case itemType == hidItemTypeGlobal && tag == hidTagReportID:
+			return 0, 0, errp.New("numbered reports are not supported")
+		case itemType == hidItemTypeGlobal && tag == hidTagPush:
+			stack = append(stack, current)
+		case itemType == hidItemTypeGlobal && tag == hidTagPop:
+			if len(stack) == 0 {
+				return 0, 0, errp.New("unbalanced pop in the report descriptor")
+			}
+			current = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case itemType == hidItemTypeMain && tag == hidTagOutput:
+			outputBits += current.reportSize * current.reportCount
+		case itemType == hidItemTypeMain && tag == hidTagInput:
+			inputBits += current.reportSize * current.reportCount
+		}
+	}
+	write, read := (outputBits+7)/8, (inputBits+7)/8
+	if write == 0 || read == 0 {
+		return 0, 0, errp.New("the report descriptor declares no input or output reports")
+	}
+	if write > maxReportSize || read > maxReportSize {
+		return 0, 0, errp.Newf("the declared report sizes %d/%d are too large", write, read)
+	}
+	return int(write), int(read), nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/karalabe/hid"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// sysfsRoot is where sysfs is mounted. It is changed in tests.
+var sysfsRoot = "/sys"
+
+// readReportSizes reads the report descriptor of the device from sysfs.
+func readReportSizes(deviceInfo hid.DeviceInfo) (int, int, error) {
+	filename, err := reportDescriptorFilename(deviceInfo.Path)
+	if err != nil {
+		return 0, 0, err
+	}
+	descriptor, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, 0, errp.WithStack(err)
+	}
+	return parseReportDescriptor(descriptor)
+}
+
+// reportDescriptorFilename returns the sysfs file of the report descriptor of the device with the
+// given hidapi path, which is either a hidraw device, e.g. "/dev/hidraw3", or the bus number, the
+// device address and the interface number of a libusb device in hex, e.g. "0001:0005:00".
+func reportDescriptorFilename(path string) (string, error) {
+	if strings.HasPrefix(path, "/dev/hidraw") {
+		return filepath.Join(sysfsRoot, "class", "hidraw", filepath.Base(path), "device",
+			"report_descriptor"), nil
+	}
+	var bus, address, iface int
+	if _, err := fmt.Sscanf(path, "%x:%x:%x", &bus, &address, &iface); err != nil {
+		return "", errp.Newf("unexpected device path %s", path)
+	}
+	devices, err := filepath.Glob(filepath.Join(sysfsRoot, "bus", "usb", "devices", "*"))
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	readNumber := func(filename string) int {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return -1
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+		if err != nil {
+			return -1
+		}
+		return number
+	}
+	for _, device := range devices {
+		if readNumber(filepath.Join(device, "busnum")) != bus ||
+			readNumber(filepath.Join(device, "devnum")) != address {
+			continue
+		}
+		// The interfaces are named after the device, the configuration and the interface number,
+		// e.g. "1-2:1.0", and contain the HID device, e.g. "0003:03EB:2402.0005".
+		matches, err := filepath.Glob(filepath.Join(
+			fmt.Sprintf("%s:*.%d", device, iface), "*:*:*.*", "report_descriptor"))
+		if err != nil {
+			return "", errp.WithStack(err)
+		}
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	return "", errp.Newf("no report descriptor found for %s", path)
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karalabe/hid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+func TestReadReportSizes(t *testing.T) {
+	root := test.TstTempDir("reportsizes_test")
+	defer func() { sysfsRoot = "/sys" }()
+	sysfsRoot = root
+	writeFile := func(filename string, contents []byte) {
+		filename = filepath.Join(root, filename)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0700))
+		require.NoError(t, ioutil.WriteFile(filename, contents, 0600))
+	}
+	writeFile("class/hidraw/hidraw3/device/report_descriptor", hidDescriptor(64, 128))
+	writeFile("bus/usb/devices/1-2/busnum", []byte("1\n"))
+	writeFile("bus/usb/devices/1-2/devnum", []byte("17\n"))
+	writeFile("bus/usb/devices/1-2:1.1/0003:03EB:2402.0005/report_descriptor", hidDescriptor(32, 64))
+
+	write, read, err := readReportSizes(hid.DeviceInfo{Path: "/dev/hidraw3"})
+	require.NoError(t, err)
+	require.Equal(t, []int{64, 128}, []int{write, read})
+	write, read, err = readReportSizes(hid.DeviceInfo{Path: "0001:0011:01"})
+	require.NoError(t, err)
+	require.Equal(t, []int{32, 64}, []int{write, read})
+	_, _, err = readReportSizes(hid.DeviceInfo{Path: "0001:0011:00"})
+	require.Error(t, err)
+
+	log := logging.Get().WithGroup("reportsizes_test")
+	require.Equal(t, &ReportSizes{Write: 64, Read: 128, Source: ReportSizesDeclared},
+		deviceReportSizes(hid.DeviceInfo{Path: "/dev/hidraw3"}, false, semver.NewSemVer(5, 0, 0), log))
+	require.Equal(t, &ReportSizes{Write: 4098, Read: 256, Source: ReportSizesDefault},
+		deviceReportSizes(hid.DeviceInfo{Path: "/dev/hidraw4"}, true, semver.NewSemVer(2, 0, 0), log))
+}
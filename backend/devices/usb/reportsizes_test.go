@@ -0,0 +1,76 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// hidDescriptor returns a vendor defined report descriptor with unnumbered input and output
+// reports of the given sizes in bytes.
+func hidDescriptor(write, read int) []byte {
+	return []byte{
+		0x06, 0x00, 0xff, // Usage Page (Vendor Defined)
+		0x09, 0x01, // Usage
+		0xa1, 0x01, // Collection (Application)
+		0x09, 0x20, // Usage
+		0x15, 0x00, // Logical Minimum (0)
+		0x26, 0xff, 0x00, // Logical Maximum (255)
+		0x75, 0x08, // Report Size (8)
+		0x96, byte(read), byte(read >> 8), // Report Count
+		0x81, 0x02, // Input
+		0x09, 0x21, // Usage
+		0xa4,       // Push
+		0x75, 0x10, // Report Size (16), restored by the pop
+		0xb4,                                // Pop
+		0x96, byte(write), byte(write >> 8), // Report Count
+		0x91, 0x02, // Output
+		0xc0, // End Collection
+	}
+}
+
+func TestParseReportDescriptor(t *testing.T) {
+	write, read, err := parseReportDescriptor(hidDescriptor(64, 64))
+	require.NoError(t, err)
+	require.Equal(t, 64, write)
+	require.Equal(t, 64, read)
+
+	write, read, err = parseReportDescriptor(hidDescriptor(32, 128))
+	require.NoError(t, err)
+	require.Equal(t, 32, write)
+	require.Equal(t, 128, read)
+
+	// Long items are skipped.
+	write, read, err = parseReportDescriptor(append([]byte{0xfe, 0x02, 0x00, 0x01, 0x02},
+		hidDescriptor(64, 60)...))
+	require.NoError(t, err)
+	require.Equal(t, 64, write)
+	require.Equal(t, 60, read)
+
+	descriptor := hidDescriptor(64, 64)
+	_, _, err = parseReportDescriptor(descriptor[:len(descriptor)-4])
+	require.Error(t, err, "no output report")
+	_, _, err = parseReportDescriptor(descriptor[:len(descriptor)-3])
+	require.Error(t, err, "truncated")
+	_, _, err = parseReportDescriptor(append([]byte{0x85, 0x01}, descriptor...))
+	require.Error(t, err, "numbered reports")
+	_, _, err = parseReportDescriptor([]byte{0xb4})
+	require.Error(t, err, "unbalanced pop")
+	_, _, err = parseReportDescriptor([]byte{0x77, 0xff, 0xff, 0xff, 0xff, 0x95, 0x01, 0x81, 0x02,
+		0x91, 0x02})
+	require.Error(t, err, "too large")
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/karalabe/hid"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+var (
+	hidDLL                    = syscall.NewLazyDLL("hid.dll")
+	procHidDGetPreparsedData  = hidDLL.NewProc("HidD_GetPreparsedData")
+	procHidDFreePreparsedData = hidDLL.NewProc("HidD_FreePreparsedData")
+	procHidPGetCaps           = hidDLL.NewProc("HidP_GetCaps")
+)
+
+// hidpStatusSuccess is the NTSTATUS returned by HidP_GetCaps on success.
+const hidpStatusSuccess = 0x00110000
+
+// hidpCaps is the HIDP_CAPS structure.
+type hidpCaps struct {
+	Usage                     uint16
+	UsagePage                 uint16
+	InputReportByteLength     uint16
+	OutputReportByteLength    uint16
+	FeatureReportByteLength   uint16
+	Reserved                  [17]uint16
+	NumberLinkCollectionNodes uint16
+	NumberCaps                [9]uint16
+}
+
+// readReportSizes queries the report lengths of the device, which Windows derives from its report
+// descriptor. They include the report ID, which is not part of the reports exchanged with the
+// device.
+func readReportSizes(deviceInfo hid.DeviceInfo) (int, int, error) {
+	path, err := syscall.UTF16PtrFromString(deviceInfo.Path)
+	if err != nil {
+		return 0, 0, errp.WithStack(err)
+	}
+	// No access rights are needed to query the capabilities, so this works while the device is
+	// open.
+	handle, err := syscall.CreateFile(path, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE,
+		nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return 0, 0, errp.WithStack(err)
+	}
+	defer func() {
+		_ = syscall.CloseHandle(handle)
+	}()
+	var preparsedData uintptr
+	if ok, _, err := procHidDGetPreparsedData.Call(
+		uintptr(handle), uintptr(unsafe.Pointer(&preparsedData))); ok == 0 {
+		return 0, 0, errp.Wrap(err, "HidD_GetPreparsedData failed")
+	}
+	defer func() {
+		_, _, _ = procHidDFreePreparsedData.Call(preparsedData)
+	}()
+	caps := hidpCaps{}
+	if status, _, _ := procHidPGetCaps.Call(
+		preparsedData, uintptr(unsafe.Pointer(&caps))); status != hidpStatusSuccess {
+		return 0, 0, errp.Newf("HidP_GetCaps failed with status 0x%x", status)
+	}
+	if caps.OutputReportByteLength < 2 || caps.InputReportByteLength < 2 {
+		return 0, 0, errp.New("the device declares no input or output reports")
+	}
+	return int(caps.OutputReportByteLength) - 1, int(caps.InputReportByteLength) - 1, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// simulatorDialTimeout is the timeout of connecting to the simulator.
+	simulatorDialTimeout = 3 * time.Second
+	// maxSimulatorHelloSize bounds the size of the hello line of the simulator.
+	maxSimulatorHelloSize = 1024
+	// simulatorHIDBackend is reported as the HID backend of the simulator in the diagnostics.
+	simulatorHIDBackend = "tcp"
+)
+
+// simulatorHello is sent by the simulator as the first line after accepting the connection. It
+// contains the product name and serial number the device reports over USB.
+type simulatorHello struct {
+	Product string `json:"product"`
+	Serial  string `json:"serial"`
+}
+
+// simulatorConn exchanges reports with a device simulator over TCP. Each report is sent as is, with
+// the report size the device uses over USB.
+type simulatorConn struct {
+	conn net.Conn
+	// broken is set to 1 when the connection failed, so that the device is unregistered.
+	broken int32
+}
+
+// Read implements io.Reader. It reads exactly one report.
+func (simulator *simulatorConn) Read(report []byte) (int, error) {
+	n, err := io.ReadFull(simulator.conn, report)
+	if err != nil {
+		atomic.StoreInt32(&simulator.broken, 1)
+	}
+	return n, err
+}
+
+// Write implements io.Writer.
+func (simulator *simulatorConn) Write(report []byte) (int, error) {
+	n, err := simulator.conn.Write(report)
+	if err != nil {
+		atomic.StoreInt32(&simulator.broken, 1)
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (simulator *simulatorConn) Close() error {
+	atomic.StoreInt32(&simulator.broken, 1)
+	return simulator.conn.Close()
+}
+
+// isBroken returns true if the connection failed or was closed.
+func (simulator *simulatorConn) isBroken() bool {
+	return atomic.LoadInt32(&simulator.broken) == 1
+}
+
+// readSimulatorHello reads the hello line of the simulator byte by byte, so that no part of the
+// first report is consumed.
+func readSimulatorHello(conn net.Conn) (*simulatorHello, error) {
+	line := new(bytes.Buffer)
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if line.Len() == maxSimulatorHelloSize {
+			return nil, errp.New("simulator hello too long")
+		}
+		line.WriteByte(b[0])
+	}
+	hello := &simulatorHello{}
+	if err := json.Unmarshal(line.Bytes(), hello); err != nil {
+		return nil, errp.WithMessage(err, "invalid simulator hello")
+	}
+	return hello, nil
+}
+
+// SetSimulator makes ListenHID also connect to a device simulator listening on the given TCP
+// address. The simulator speaks the same framing as the device over USB, so that all flows can be
+// run without hardware. It must be called before ListenHID.
+func (manager *Manager) SetSimulator(address string) {
+	manager.simulatorAddress = address
+}
+
+// simulatorPath returns the path identifying the simulator.
+func (manager *Manager) simulatorPath() string {
+	return "tcp://" + manager.simulatorAddress
+}
+
+// simulatorRemoved returns true if the connection to the simulator failed or was closed.
+func (manager *Manager) simulatorRemoved() bool {
+	return manager.simulator == nil || manager.simulator.isBroken()
+}
+
+// registerSimulator connects to the simulator and registers it, unless it is registered already.
+func (manager *Manager) registerSimulator() error {
+	path := manager.simulatorPath()
+	for _, registeredPath := range manager.paths {
+		if registeredPath == path {
+			return nil
+		}
+	}
+	conn, err := net.DialTimeout("tcp", manager.simulatorAddress, simulatorDialTimeout)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to connect to the simulator")
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(simulatorDialTimeout)); err != nil {
+		_ = conn.Close()
+		return errp.WithStack(err)
+	}
+	hello, err := readSimulatorHello(conn)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return errp.WithStack(err)
+	}
+	bootloader, firmwareVersion, err := parseDeviceInfo(hello.Product, hello.Serial)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	deviceID := deviceIdentifier(bitbox.ProductName, path)
+	manager.log.WithField("device-id", deviceID).Info("Registering simulator")
+	manager.simulator = &simulatorConn{conn: conn}
+	writeReportSize, readReportSize := reportSizes(bootloader, firmwareVersion)
+	communication := NewTransportCommunication(
+		NewReportTransport(manager.simulator, writeReportSize, readReportSize))
+	return manager.registerDevice(
+		deviceID, path, bootloader, firmwareVersion, simulatorHIDBackend, communication)
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+// serveSimulator accepts one connection and answers the reports like the u2fDevice.
+func serveSimulator(t *testing.T, listener net.Listener, hello string) {
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte(hello + "\n")); err != nil {
+		return
+	}
+	device := &u2fDevice{nextCID: 0x100}
+	report := make([]byte, 64)
+	for {
+		if _, err := io.ReadFull(conn, report); err != nil {
+			return
+		}
+		if _, err := device.Write(report); err != nil {
+			return
+		}
+		for len(device.replies) > 0 {
+			reply := make([]byte, 64)
+			_, _ = device.Read(reply)
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go serveSimulator(t, listener, `{"product":"Digital Bitbox","serial":"dbb.fw:v4.0.0"}`)
+
+	channelConfigDir := test.TstTempDir("simulator_test")
+	defer func() { _ = os.RemoveAll(channelConfigDir) }()
+	var registered *bitbox.Device
+	manager := NewManager(channelConfigDir,
+		func(theDevice device.Interface) error {
+			registered = theDevice.(*bitbox.Device)
+			return nil
+		},
+		func(string) {},
+		func() {},
+	)
+	manager.SetSimulator(listener.Addr().String())
+	require.True(t, manager.checkIfRemoved(manager.simulatorPath()))
+
+	require.NoError(t, manager.registerSimulator())
+	require.NotNil(t, registered)
+	require.False(t, manager.checkIfRemoved(manager.simulatorPath()))
+	require.Equal(t, simulatorHIDBackend, manager.Diagnostics().HIDBackends[registered.Identifier()])
+	// Registering again is a no-op while the simulator is connected.
+	require.NoError(t, manager.registerSimulator())
+
+	initialized, err := registered.Ping()
+	require.NoError(t, err)
+	require.True(t, initialized)
+
+	registered.Close()
+	require.True(t, manager.checkIfRemoved(manager.simulatorPath()))
+}
+
+func TestSimulatorInvalidHello(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go serveSimulator(t, listener, `{"product":"Digital Bitbox","serial":"no version"}`)
+
+	manager := NewManager("", func(device.Interface) error { return nil }, func(string) {}, func() {})
+	manager.SetSimulator(listener.Addr().String())
+	require.Error(t, manager.registerSimulator())
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Transport sends and receives U2FHID frames. The framing into reports is shared by the transports
+// exchanging fixed size reports, see ReportTransport.
+type Transport interface {
+	// SendFrame sends the message as a frame of the command on the given channel.
+	SendFrame(cid uint32, cmd byte, msg []byte) error
+	// ReadFrame reads the message of the next frame of the command on the given channel.
+	ReadFrame(cid uint32, cmd byte) ([]byte, error)
+	// Close closes the connection to the device.
+	Close() error
+}
+
+// DeviceTransport exchanges the raw reports with the device. Each Write sends one report, and each
+// Read returns one report. It is implemented by the HID devices, the connection to the simulator
+// and the BLE link, and by mocks.DeviceTransport in tests.
+type DeviceTransport interface {
+	io.ReadWriteCloser
+}
+
+// ReportTransport implements Transport over a device exchanging reports of a fixed size, e.g. a USB
+// HID device or a BLE characteristic.
+type ReportTransport struct {
+	device          DeviceTransport
+	writeReportSize int
+	readReportSize  int
+	// prefixReportID is true if the raw reports of the bootloader are prefixed with the report ID,
+	// see Communication.sendBootloader().
+	prefixReportID bool
+}
+
+// NewReportTransport creates a new ReportTransport. Each write to the device is one report of
+// writeReportSize bytes, and each read returns one report of at most readReportSize bytes.
+func NewReportTransport(device DeviceTransport, writeReportSize, readReportSize int) *ReportTransport {
+	return &ReportTransport{
+		device:          device,
+		writeReportSize: writeReportSize,
+		readReportSize:  readReportSize,
+	}
+}
+
+// Close implements Transport.
+func (transport *ReportTransport) Close() error {
+	return transport.device.Close()
+}
+
+// SendFrame implements Transport. The frame is split into an init frame and continuation frames,
+// each padded to the write report size.
+func (transport *ReportTransport) SendFrame(cid uint32, cmd byte, msg []byte) error {
+	dataLen := len(msg)
+	if dataLen == 0 {
+		return nil
+	}
+	send := func(header []byte, readFrom *bytes.Buffer) error {
+		buf := new(bytes.Buffer)
+		buf.Write(header)
+		buf.Write(readFrom.Next(transport.writeReportSize - buf.Len()))
+		for buf.Len() < transport.writeReportSize {
+			buf.WriteByte(0xee)
+		}
+		_, err := transport.device.Write(buf.Bytes())
+		return errors.WithMessage(errors.WithStack(err), "Failed to send message")
+	}
+	readBuffer := bytes.NewBuffer(msg)
+	// init frame
+	header := new(bytes.Buffer)
+	if err := binary.Write(header, binary.BigEndian, cid); err != nil {
+		return errp.WithStack(err)
+	}
+	if err := binary.Write(header, binary.BigEndian, cmd); err != nil {
+		return errp.WithStack(err)
+	}
+	if err := binary.Write(header, binary.BigEndian, uint16(dataLen&0xFFFF)); err != nil {
+		return errp.WithStack(err)
+	}
+	if err := send(header.Bytes(), readBuffer); err != nil {
+		return err
+	}
+	for seq := 0; readBuffer.Len() > 0; seq++ {
+		// cont frame
+		header = new(bytes.Buffer)
+		if err := binary.Write(header, binary.BigEndian, cid); err != nil {
+			return errp.WithStack(err)
+		}
+		if err := binary.Write(header, binary.BigEndian, uint8(seq)); err != nil {
+			return errp.WithStack(err)
+		}
+		if err := send(header.Bytes(), readBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFrame implements Transport.
+func (transport *ReportTransport) ReadFrame(cid uint32, cmd byte) ([]byte, error) {
+	read := make([]byte, transport.readReportSize)
+	readLen, err := transport.readInitFrame(read, cid)
+	if err != nil {
+		return nil, err
+	}
+	if readLen < 7 {
+		return nil, errp.WithStack(&CorruptReplyErr{Reason: "init frame too short"})
+	}
+	if read[4] == u2fHIDError && readLen > 7 {
+		return nil, errp.WithStack(&U2FHIDError{Code: read[7]})
+	}
+	if read[4] != cmd {
+		return nil, errp.Newf("USB command frame mismatch (%d, expected %d)", read[4], cmd)
+	}
+	return transport.readFrameData(read, readLen, cid)
+}
+
+// readFrameData returns the message of the frame whose init frame of readLen bytes is in read,
+// reading its continuation frames.
+func (transport *ReportTransport) readFrameData(read []byte, readLen int, cid uint32) ([]byte, error) {
+	data := new(bytes.Buffer)
+	dataLen := int(read[5])*256 + int(read[6])
+	data.Write(read[7:readLen])
+	idx := readLen - 7
+	for seq := 0; idx < dataLen; seq++ {
+		var err error
+		readLen, err = transport.readChannelFrame(read, cid)
+		if err != nil {
+			return nil, err
+		}
+		if readLen < 5 {
+			return nil, errp.WithStack(&CorruptReplyErr{Reason: "continuation frame too short"})
+		}
+		if int(read[4]) != seq {
+			return nil, errp.WithStack(&CorruptReplyErr{
+				Reason: fmt.Sprintf("continuation frame %d out of sequence, expected %d", read[4], seq)})
+		}
+		data.Write(read[5:readLen])
+		idx += readLen - 5
+	}
+	// Strip the padding of the last frame.
+	if data.Len() > dataLen {
+		data.Truncate(dataLen)
+	}
+	return data.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usb
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/mocks"
+)
+
+func TestReportTransport(t *testing.T) {
+	request := []byte(strings.Repeat("r", 200))
+	reply := []byte(strings.Repeat("a", 150))
+	device := mocks.NewDeviceTransport(64, &mocks.Exchange{
+		CID: 0x01020304, Command: hwwCMD, Request: request, Reply: reply})
+	transport := NewReportTransport(device, 64, 64)
+
+	require.NoError(t, transport.SendFrame(0x01020304, hwwCMD, request))
+	// The init frame carries 57 bytes, each continuation frame 59 bytes.
+	require.Equal(t, 4, device.Reports())
+	received, err := transport.ReadFrame(0x01020304, hwwCMD)
+	require.NoError(t, err)
+	require.Equal(t, reply, received)
+	require.NoError(t, device.Verify())
+
+	// Messages which fit into the init frame.
+	device = mocks.NewDeviceTransport(64, &mocks.Exchange{
+		Command: hwwCMD, Request: []byte("{}"), Reply: []byte(`{"a":1}`)})
+	transport = NewReportTransport(device, 64, 64)
+	require.NoError(t, transport.SendFrame(hwwCID, hwwCMD, []byte("{}")))
+	require.Equal(t, 1, device.Reports())
+	received, err = transport.ReadFrame(hwwCID, hwwCMD)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"a":1}`), received)
+
+	// The report sizes can differ per direction, and from the usual 64 bytes.
+	device = mocks.NewAsymmetricDeviceTransport(32, 100, &mocks.Exchange{
+		CID: 0x01020304, Command: hwwCMD, Request: request, Reply: reply})
+	transport = NewReportTransport(device, 32, 100)
+	require.NoError(t, transport.SendFrame(0x01020304, hwwCMD, request))
+	// The init frame carries 25 bytes, each continuation frame 27 bytes.
+	require.Equal(t, 8, device.Reports())
+	received, err = transport.ReadFrame(0x01020304, hwwCMD)
+	require.NoError(t, err)
+	require.Equal(t, reply, received)
+	require.NoError(t, device.Verify())
+
+	// The reply of another command is rejected.
+	device = mocks.NewDeviceTransport(64, &mocks.Exchange{Command: hwwCMD, Reply: []byte("{}")})
+	transport = NewReportTransport(device, 64, 64)
+	require.NoError(t, transport.SendFrame(hwwCID, hwwCMD, []byte("{}")))
+	_, err = transport.ReadFrame(hwwCID, u2fHIDInit)
+	require.Error(t, err)
+}
+
+func TestCommunicationWithMockTransport(t *testing.T) {
+	const cid = 0x0a0b0c0d
+	allocate := &mocks.Exchange{
+		CID:     u2fHIDBroadcastCID,
+		Command: u2fHIDInit,
+		ReplyFunc: func(nonce []byte) []byte {
+			reply := make([]byte, u2fHIDInitReplySize)
+			copy(reply, nonce)
+			binary.BigEndian.PutUint32(reply[u2fHIDInitNonceSize:], cid)
+			return reply
+		},
+	}
+	longReply := `{"random":"` + strings.Repeat("f", 100) + `"}`
+	device := mocks.NewDeviceTransport(64,
+		allocate,
+		&mocks.Exchange{CID: cid, Command: hwwCMD, Request: []byte(`{"ping":""}`),
+			Reply: []byte(`{"ping":"password"}`)},
+		&mocks.Exchange{CID: cid, Command: hwwCMD, Request: []byte(`{"random":"pseudo"}`),
+			Reply: []byte(longReply)},
+	)
+	communication := NewCommunication(device, 64, 64)
+	reply, err := communication.SendPlain(context.Background(), `{"ping":""}`)
+	require.NoError(t, err)
+	require.Equal(t, "password", reply["ping"])
+	reply, err = communication.SendPlain(context.Background(), `{"random":"pseudo"}`)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("f", 100), reply["random"])
+	require.NoError(t, device.Verify())
+
+	// A request which does not match the script fails.
+	device = mocks.NewDeviceTransport(64, &mocks.Exchange{
+		Command: hwwCMD, Request: []byte(`{"ping":""}`), Reply: []byte(`{"ping":""}`)})
+	communication = NewCommunication(device, 64, 64)
+	communication.DisableChannelAllocation()
+	_, err = communication.SendPlain(context.Background(), `{"led":"blink"}`)
+	require.Error(t, err)
+	require.Error(t, device.Verify())
+}
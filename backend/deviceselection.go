@@ -0,0 +1,118 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ActiveDevice describes which of the connected devices provides the keystore of the accounts.
+type ActiveDevice struct {
+	// DeviceID is the active device, or empty if no device keystore is registered.
+	DeviceID string `json:"deviceID"`
+	// Available are the devices whose keystore is available, i.e. which are unlocked. Any of them
+	// can be selected with SelectDevice().
+	Available []string `json:"available"`
+}
+
+// ActiveDevice returns the active device and the devices which can be selected.
+func (backend *Backend) ActiveDevice() *ActiveDevice {
+	defer backend.deviceSelectionLock.RLock()()
+	result := &ActiveDevice{DeviceID: backend.activeDeviceID, Available: []string{}}
+	for deviceID := range backend.keystoreDevices {
+		result.Available = append(result.Available, deviceID)
+	}
+	return result
+}
+
+// SelectDevice makes the accounts use the keystore of the device with the given ID, which must be
+// unlocked. The accounts of the previously active device are closed. Not supported in multisig
+// mode, where the keystores of all devices are used.
+func (backend *Backend) SelectDevice(deviceID string) error {
+	if backend.arguments.Multisig() {
+		return errp.New("Selecting a device is not supported in multisig mode.")
+	}
+	unlock := backend.deviceSelectionLock.Lock()
+	theDevice, ok := backend.keystoreDevices[deviceID]
+	if !ok {
+		unlock()
+		return errp.New("The device is not connected or not unlocked.")
+	}
+	if backend.activeDeviceID == deviceID {
+		unlock()
+		return nil
+	}
+	backend.activeDeviceID = deviceID
+	unlock()
+	backend.log.WithField("device-id", deviceID).Info("Selecting device")
+	backend.DeregisterKeystore()
+	backend.registerDeviceKeystore(theDevice)
+	return nil
+}
+
+// registerDeviceKeystore replaces the registered keystores with the keystore of the device.
+func (backend *Backend) registerDeviceKeystore(theDevice device.Interface) {
+	backend.keystores = keystore.NewKeystores()
+	backend.RegisterKeystore(theDevice.KeystoreForConfiguration(nil, backend.keystores.Count()))
+	backend.events <- backendEvent{Type: "devices", Data: "activeChanged"}
+}
+
+// onKeystoreAvailable is called when the keystore of a device becomes available. It is used by the
+// accounts if no other device is active.
+func (backend *Backend) onKeystoreAvailable(theDevice device.Interface) {
+	if backend.arguments.Multisig() {
+		backend.RegisterKeystore(
+			theDevice.KeystoreForConfiguration(nil, backend.keystores.Count()))
+		return
+	}
+	unlock := backend.deviceSelectionLock.Lock()
+	backend.keystoreDevices[theDevice.Identifier()] = theDevice
+	activate := backend.activeDeviceID == "" || backend.activeDeviceID == theDevice.Identifier()
+	if activate {
+		backend.activeDeviceID = theDevice.Identifier()
+	}
+	unlock()
+	if activate {
+		// The keystore is replaced if it becomes available again, e.g. after a reset.
+		backend.registerDeviceKeystore(theDevice)
+	} else {
+		backend.events <- backendEvent{Type: "devices", Data: "activeChanged"}
+	}
+}
+
+// onKeystoreGone is called when the keystore of a device becomes unavailable or the device is
+// removed. The accounts are closed if the device was the active device.
+func (backend *Backend) onKeystoreGone(deviceID string) {
+	if backend.arguments.Multisig() {
+		backend.DeregisterKeystore()
+		return
+	}
+	unlock := backend.deviceSelectionLock.Lock()
+	_, available := backend.keystoreDevices[deviceID]
+	delete(backend.keystoreDevices, deviceID)
+	active := backend.activeDeviceID == deviceID
+	if active {
+		backend.activeDeviceID = ""
+	}
+	unlock()
+	if active {
+		backend.DeregisterKeystore()
+	}
+	if active || available {
+		backend.events <- backendEvent{Type: "devices", Data: "activeChanged"}
+	}
+}
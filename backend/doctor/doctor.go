@@ -0,0 +1,203 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package doctor checks the integrity of the data directory on startup and repairs what is safe to
+// repair, so that corrupted files neither crash the app nor get silently overwritten with defaults.
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bbolt "github.com/coreos/bbolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Action is what was done about a problem.
+type Action string
+
+const (
+	// ActionNone means that the problem is only reported, e.g. because the file is in use.
+	ActionNone Action = "none"
+	// ActionDeleted means that the file was a cache and was deleted. It is rebuilt from the network.
+	ActionDeleted Action = "deleted"
+	// ActionQuarantined means that the file was renamed (see Finding.QuarantinedAs), so that the app
+	// starts without it and the data can still be recovered manually.
+	ActionQuarantined Action = "quarantined"
+)
+
+const openTimeout = time.Second
+
+// Finding is a problem found in the data directory.
+type Finding struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+	Action  Action `json:"action"`
+	// QuarantinedAs is the new path of a quarantined file.
+	QuarantinedAs string `json:"quarantinedAs,omitempty"`
+}
+
+// Report is the result of Run().
+type Report struct {
+	Time time.Time `json:"time"`
+	// Checked is the number of files checked.
+	Checked  int       `json:"checked"`
+	Findings []Finding `json:"findings"`
+}
+
+type doctor struct {
+	report *Report
+	log    *logrus.Entry
+}
+
+// Run checks the config file and the JSON and database files directly in the given directories.
+// Invalid JSON files and databases are quarantined, except for the headers databases, which are
+// deleted. It must be called before any of the files is opened.
+func Run(configFilename string, directories []string, log *logrus.Entry) *Report {
+	doctor := &doctor{
+		report: &Report{Time: time.Now(), Findings: []Finding{}},
+		log:    log.WithField("group", "doctor"),
+	}
+	doctor.checkJSON(configFilename)
+	for _, directory := range directories {
+		files, err := ioutil.ReadDir(directory)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				doctor.log.WithError(err).Error("Could not list the data directory")
+			}
+			continue
+		}
+		names := []string{}
+		for _, file := range files {
+			if file.Mode().IsRegular() {
+				names = append(names, file.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			filename := filepath.Join(directory, name)
+			switch {
+			case filename == configFilename:
+			case strings.HasSuffix(name, ".json"):
+				doctor.checkJSON(filename)
+			case strings.HasSuffix(name, ".db"):
+				doctor.checkDB(filename, strings.HasPrefix(name, "headers-"))
+			}
+		}
+	}
+	doctor.log.WithFields(logrus.Fields{
+		"checked":  doctor.report.Checked,
+		"findings": len(doctor.report.Findings),
+	}).Info("Checked the data directory")
+	return doctor.report
+}
+
+func (doctor *doctor) checkJSON(filename string) {
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return
+	}
+	doctor.report.Checked++
+	if err != nil {
+		doctor.add(filename, err.Error(), ActionNone, "")
+		return
+	}
+	var value interface{}
+	if err := json.Unmarshal(jsonBytes, &value); err != nil {
+		doctor.quarantine(filename, "invalid JSON: "+err.Error())
+	}
+}
+
+// checkDB opens the database read-only and checks its consistency.
+func (doctor *doctor) checkDB(filename string, cache bool) {
+	doctor.report.Checked++
+	problem, err := checkDB(filename)
+	if err == bbolt.ErrTimeout {
+		doctor.add(filename, "in use by another process", ActionNone, "")
+		return
+	}
+	if err != nil {
+		problem = err.Error()
+	}
+	if problem == "" {
+		return
+	}
+	if !cache {
+		doctor.quarantine(filename, problem)
+		return
+	}
+	if err := os.Remove(filename); err != nil {
+		doctor.add(filename, problem, ActionNone, "")
+		doctor.log.WithError(err).Error("Could not delete the database")
+		return
+	}
+	doctor.add(filename, problem, ActionDeleted, "")
+}
+
+// checkDB returns the first problem found in the database, or an empty string. Corrupted pages can
+// make bbolt panic, which is reported as a problem as well.
+func checkDB(filename string) (problem string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			problem = fmt.Sprintf("database corrupted: %v", recovered)
+			err = nil
+		}
+	}()
+	db, err := bbolt.Open(filename, 0600, &bbolt.Options{ReadOnly: true, Timeout: openTimeout})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = db.Close() }()
+	err = db.View(func(tx *bbolt.Tx) error {
+		for checkErr := range tx.Check() {
+			if problem == "" {
+				problem = "database corrupted: " + checkErr.Error()
+			}
+		}
+		return nil
+	})
+	return problem, errp.WithStack(err)
+}
+
+func (doctor *doctor) quarantine(filename string, problem string) {
+	quarantinedAs := fmt.Sprintf("%s.corrupt-%d", filename, doctor.report.Time.Unix())
+	if err := os.Rename(filename, quarantinedAs); err != nil {
+		doctor.log.WithError(err).Error("Could not quarantine the file")
+		doctor.add(filename, problem, ActionNone, "")
+		return
+	}
+	doctor.add(filename, problem, ActionQuarantined, quarantinedAs)
+}
+
+func (doctor *doctor) add(filename string, problem string, action Action, quarantinedAs string) {
+	doctor.log.WithFields(logrus.Fields{
+		"file":    filename,
+		"problem": problem,
+		"action":  action,
+	}).Warning("Problem in the data directory")
+	doctor.report.Findings = append(doctor.report.Findings, Finding{
+		File:          filename,
+		Problem:       problem,
+		Action:        action,
+		QuarantinedAs: quarantinedAs,
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package doctor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bbolt "github.com/coreos/bbolt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/doctor"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDB(t *testing.T, filename string) {
+	db, err := bbolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("transactions"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("key"), []byte("value"))
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestRun(t *testing.T) {
+	mainDir := test.TstTempDir("doctor")
+	defer func() { _ = os.RemoveAll(mainDir) }()
+	cacheDir := filepath.Join(mainDir, "cache")
+	require.NoError(t, os.MkdirAll(cacheDir, 0700))
+
+	configFilename := filepath.Join(mainDir, "config.json")
+	require.NoError(t, ioutil.WriteFile(configFilename, []byte(`{"backend":`), 0600))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(mainDir, "api-tokens.json"), []byte(`[]`), 0600))
+	garbage := make([]byte, 8192)
+	for i := range garbage {
+		garbage[i] = byte(i)
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, "headers-btc.db"), garbage, 0600))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(cacheDir, "account-abc-btc-p2pkh.db"), garbage, 0600))
+	writeDB(t, filepath.Join(cacheDir, "account-abc-btc-p2wpkh.db"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, "other.txt"), garbage, 0600))
+
+	report := doctor.Run(configFilename, []string{mainDir, cacheDir}, logging.Get().WithGroup("test"))
+	require.Equal(t, 5, report.Checked)
+	require.Len(t, report.Findings, 3)
+
+	require.Equal(t, configFilename, report.Findings[0].File)
+	require.Equal(t, doctor.ActionQuarantined, report.Findings[0].Action)
+	_, err := os.Stat(configFilename)
+	require.True(t, os.IsNotExist(err))
+	quarantined, err := ioutil.ReadFile(report.Findings[0].QuarantinedAs)
+	require.NoError(t, err)
+	require.Equal(t, `{"backend":`, string(quarantined))
+
+	require.Equal(t, filepath.Join(cacheDir, "account-abc-btc-p2pkh.db"), report.Findings[1].File)
+	require.Equal(t, doctor.ActionQuarantined, report.Findings[1].Action)
+
+	require.Equal(t, filepath.Join(cacheDir, "headers-btc.db"), report.Findings[2].File)
+	require.Equal(t, doctor.ActionDeleted, report.Findings[2].Action)
+	_, err = os.Stat(report.Findings[2].File)
+	require.True(t, os.IsNotExist(err))
+
+	// Nothing left to repair.
+	report = doctor.Run(configFilename, []string{mainDir, cacheDir}, logging.Get().WithGroup("test"))
+	require.Equal(t, 2, report.Checked)
+	require.Empty(t, report.Findings)
+}
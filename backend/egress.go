@@ -0,0 +1,94 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coinmetadata"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// egressDestinations enumerates the external hosts the backend is expected to contact, given the
+// current config. Hosts listed in the egress config are included with an empty purpose.
+func (backend *Backend) egressDestinations() []egress.Destination {
+	backendConfig := backend.config.Config().Backend
+	destinations := []egress.Destination{
+		{Host: btc.RatesHost, Purpose: egress.PurposeRates},
+		{Host: updateFileURL, Purpose: egress.PurposeUpdate},
+		{Host: coinmetadata.BundleURL, Purpose: egress.PurposeUpdate},
+		{Host: bitbox.FirmwareReleasesURL, Purpose: egress.PurposeUpdate},
+		{Host: features.BundleURL, Purpose: egress.PurposeUpdate},
+		{Host: string(relay.DefaultServer), Purpose: egress.PurposeRelay},
+	}
+	servers := []*rpc.ServerInfo{}
+	for _, code := range []string{"btc", "tbtc", "ltc", "tltc"} {
+		if backend.arguments.DevMode() {
+			servers = append(servers, defaultDevServers(code)...)
+		} else {
+			servers = append(servers, backend.defaultProdServers(code)...)
+		}
+	}
+	func() {
+		defer backend.coinsLock.RLock()()
+		for _, definition := range backend.customCoins {
+			servers = append(servers, definition.ElectrumServers...)
+		}
+	}()
+	for _, serverInfo := range servers {
+		destinations = append(destinations,
+			egress.Destination{Host: serverInfo.Server, Purpose: egress.PurposeElectrum})
+	}
+	if backendConfig.DNS.Resolver == config.ResolverDoH {
+		destinations = append(destinations,
+			egress.Destination{Host: backendConfig.DNS.DoHURL, Purpose: egress.PurposeDNS})
+	}
+	if backendConfig.RatesStreamAPIKey != "" {
+		destinations = append(destinations,
+			egress.Destination{Host: btc.RatesStreamHost, Purpose: egress.PurposeRates})
+	}
+	if backendConfig.RelayServer != "" {
+		destinations = append(destinations,
+			egress.Destination{Host: backendConfig.RelayServer, Purpose: egress.PurposeRelay})
+	}
+	if backendConfig.MetadataBackup.Target != "" {
+		destinations = append(destinations, egress.Destination{
+			Host:    backendConfig.MetadataBackup.URL,
+			Purpose: egress.PurposeMetadataBackup,
+		})
+	}
+	if release := backend.firmwareChannel.Latest(); release != nil {
+		destinations = append(destinations,
+			egress.Destination{Host: release.URL, Purpose: egress.PurposeUpdate})
+	}
+	for _, address := range backend.remoteSignerDestinations() {
+		destinations = append(destinations,
+			egress.Destination{Host: address, Purpose: egress.PurposeRemoteSigner})
+	}
+	for _, host := range backendConfig.Egress.AllowedHosts {
+		destinations = append(destinations, egress.Destination{Host: host})
+	}
+	return destinations
+}
+
+// Egress returns the external hosts the backend is expected to contact and the recorded
+// connection attempts.
+func (backend *Backend) Egress() egress.Report {
+	return backend.egress.Report()
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+)
+
+// Features returns the feature flags of the installation.
+func (backend *Backend) Features() *features.Flags {
+	return backend.features
+}
+
+// refreshFeatures fetches the latest feature rules and notifies the frontend if they changed.
+// Features which were enabled or disabled by the new rules take effect when their endpoints are
+// used next.
+func (backend *Backend) refreshFeatures() {
+	updated, err := backend.features.Refresh()
+	if err != nil {
+		backend.log.WithError(err).Error("Refreshing the feature rules failed")
+		return
+	}
+	if updated {
+		backend.events <- backendEvent{Type: "backend", Data: "featuresChanged"}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+)
+
+// FirmwareChannel returns the channel providing the BitBox firmware releases.
+func (backend *Backend) FirmwareChannel() *bitbox.FirmwareChannel {
+	return backend.firmwareChannel
+}
+
+// refreshFirmwareReleases fetches the latest firmware releases and notifies the frontend if they
+// changed, so that the devices can offer the upgrade.
+func (backend *Backend) refreshFirmwareReleases() {
+	updated, err := backend.firmwareChannel.Refresh()
+	if err != nil {
+		backend.log.WithError(err).Error("Refreshing the firmware releases failed")
+		return
+	}
+	if updated {
+		backend.events <- backendEvent{Type: "devices", Data: "firmwareReleasesChanged"}
+	}
+}
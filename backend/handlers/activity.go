@@ -0,0 +1,76 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 200
+)
+
+// getActivityHandler returns a page of the activity feed, the newest first. The optional query
+// parameter `before` is the ID of the last entry of the previous page, `limit` the page size.
+func (handlers *Handlers) getActivityHandler(r *http.Request) (interface{}, error) {
+	var before int64
+	if value := r.URL.Query().Get("before"); value != "" {
+		var err error
+		before, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+	}
+	limit := defaultActivityLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if limit < 1 || limit > maxActivityLimit {
+			return nil, errp.Newf("limit must be between 1 and %d", maxActivityLimit)
+		}
+	}
+	entries, more := handlers.backend.Activity(before, limit)
+	result := []map[string]interface{}{}
+	for _, entry := range entries {
+		result = append(result, map[string]interface{}{
+			"id":      entry.ID,
+			"time":    entry.Time.Format(time.RFC3339),
+			"type":    entry.Type,
+			"account": entry.Account,
+			"event":   entry.Event,
+			"data":    entry.Data,
+		})
+	}
+	return map[string]interface{}{
+		"entries": result,
+		"more":    more,
+		"unread":  handlers.backend.ActivityUnread(),
+	}, nil
+}
+
+func (handlers *Handlers) postActivityReadHandler(_ *http.Request) (interface{}, error) {
+	if err := handlers.backend.MarkActivityRead(); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
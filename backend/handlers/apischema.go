@@ -0,0 +1,141 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	accountHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/handlers"
+	bitboxHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// pathParamRegexp matches the variables of a mux path template, e.g. {code} or {code:[a-z]+}.
+var pathParamRegexp = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// instancePathRegexp matches the routes registered per account and per device, which are
+// described by the templates returned by instanceRoutes() instead.
+var instancePathRegexp = regexp.MustCompile(`^/api/(account|devices)/[^/]+/`)
+
+// instanceRoutes registers the account and device routes on a new router, with the account code and
+// the device ID as path variables.
+func instanceRoutes(log *logrus.Entry) *mux.Router {
+	router := mux.NewRouter()
+	handleFunc := func(subrouter *mux.Router) func(
+		string, func(*http.Request) (interface{}, error)) *mux.Route {
+		return func(path string, f func(*http.Request) (interface{}, error)) *mux.Route {
+			return subrouter.HandleFunc(path, func(http.ResponseWriter, *http.Request) {})
+		}
+	}
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	accountHandlers.NewHandlers(handleFunc(apiRouter.PathPrefix("/account/{code}").Subrouter()), log)
+	bitboxHandlers.NewHandlers(
+		handleFunc(apiRouter.PathPrefix("/devices/{deviceID}").Subrouter()), nil, log)
+	return router
+}
+
+// apiSchema returns an OpenAPI description of the routes of the given routers. Request and response
+// bodies are JSON, but their structure is not described, as the handlers are not typed. The result
+// serializes deterministically: encoding/json sorts map keys, and all lists are sorted.
+func apiSchema(routers ...*mux.Router) (map[string]interface{}, error) {
+	paths := map[string]map[string]interface{}{}
+	for _, router := range routers {
+		err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			pathTemplate, err := route.GetPathTemplate()
+			if err != nil {
+				return errp.WithStack(err)
+			}
+			methods, err := route.GetMethods()
+			// Path prefixes of subrouters and the websocket have no methods.
+			if err != nil || len(methods) == 0 {
+				return nil
+			}
+			if instancePathRegexp.MatchString(pathTemplate) &&
+				!strings.Contains(pathTemplate, "{") {
+				return nil
+			}
+			operations, ok := paths[pathTemplate]
+			if !ok {
+				operations = map[string]interface{}{}
+				paths[pathTemplate] = operations
+			}
+			for _, method := range methods {
+				operations[strings.ToLower(method)] = apiOperation(pathTemplate, method)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "BitBox Wallet backend API",
+			"version": backend.Version.String(),
+		},
+		"paths": paths,
+		"x-websocket": map[string]interface{}{
+			"/api/events": map[string]interface{}{
+				"description": "Pushes backend, account and device events as JSON objects " +
+					"with the fields type, code (for accounts), deviceID (for devices), data and meta.",
+			},
+		},
+	}, nil
+}
+
+func apiOperation(pathTemplate string, method string) map[string]interface{} {
+	operation := map[string]interface{}{
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "JSON response. Errors are returned as " +
+					"{\"success\": false, \"errorCode\": ..., \"errorMessage\": ...}.",
+				"content": map[string]interface{}{"application/json": map[string]interface{}{}},
+			},
+		},
+	}
+	parameters := []map[string]interface{}{}
+	for _, match := range pathParamRegexp.FindAllStringSubmatch(pathTemplate, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	sort.Slice(parameters, func(i, j int) bool {
+		return parameters[i]["name"].(string) < parameters[j]["name"].(string)
+	})
+	if len(parameters) != 0 {
+		operation["parameters"] = parameters
+	}
+	if method == http.MethodPost {
+		operation["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{"application/json": map[string]interface{}{}},
+		}
+	}
+	return operation
+}
+
+func (handlers *Handlers) getAPISchemaHandler(_ *http.Request) (interface{}, error) {
+	return apiSchema(handlers.Router, instanceRoutes(handlers.log))
+}
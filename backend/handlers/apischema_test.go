@@ -0,0 +1,65 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+func TestAPISchema(t *testing.T) {
+	noop := func(http.ResponseWriter, *http.Request) {}
+	router := mux.NewRouter()
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.HandleFunc("/config", noop).Methods("GET")
+	apiRouter.HandleFunc("/config", noop).Methods("POST")
+	apiRouter.HandleFunc("/devices/registered", noop).Methods("GET")
+	// Routes of an initialized account are described by the templates of instanceRoutes().
+	apiRouter.HandleFunc("/account/btc-p2wpkh/balance", noop).Methods("GET")
+	apiRouter.HandleFunc("/events", noop)
+
+	schema, err := apiSchema(router, instanceRoutes(logging.Get().WithGroup("apischema_test")))
+	require.NoError(t, err)
+	paths := schema["paths"].(map[string]map[string]interface{})
+
+	require.Contains(t, paths["/api/config"], "get")
+	require.Contains(t, paths["/api/config"], "post")
+	require.NotContains(t, paths["/api/config"]["get"], "requestBody")
+	require.Contains(t, paths["/api/config"]["post"], "requestBody")
+	require.Contains(t, paths, "/api/devices/registered")
+	require.NotContains(t, paths, "/api/account/btc-p2wpkh/balance")
+	require.NotContains(t, paths, "/api/events")
+
+	balance := paths["/api/account/{code}/balance"]["get"].(map[string]interface{})
+	parameters := balance["parameters"].([]map[string]interface{})
+	require.Len(t, parameters, 1)
+	require.Equal(t, "code", parameters[0]["name"])
+	require.Contains(t, paths, "/api/devices/{deviceID}/status")
+
+	// The serialization is deterministic.
+	first, err := json.Marshal(schema)
+	require.NoError(t, err)
+	schema, err = apiSchema(router, instanceRoutes(logging.Get().WithGroup("apischema_test")))
+	require.NoError(t, err)
+	second, err := json.Marshal(schema)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+}
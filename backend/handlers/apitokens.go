@@ -0,0 +1,170 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apitokens"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+type contextKey string
+
+// apiTokenContextKey stores the read-only API token of a request in the request context.
+const apiTokenContextKey contextKey = "apiToken"
+
+// readOnlyRoutes are the GET endpoints which can be accessed with a read-only API token with the
+// respective scope. All other endpoints require the token of the app.
+var readOnlyRoutes = map[apitokens.Scope][]*regexp.Regexp{
+	apitokens.ScopeBalances: {
+		regexp.MustCompile(`^/api/accounts$`),
+		regexp.MustCompile(`^/api/coins/rates$`),
+		regexp.MustCompile(`^/api/chart$`),
+		regexp.MustCompile(`^/api/account/[^/]+/balance$`),
+		regexp.MustCompile(`^/api/account/[^/]+/balance-snapshots$`),
+	},
+	apitokens.ScopeHistory: {
+		regexp.MustCompile(`^/api/account/[^/]+/transactions$`),
+	},
+}
+
+// redactedKeys are removed from all responses to read-only API tokens without the addresses scope.
+// The address notes of a transaction are keyed by address.
+var redactedKeys = map[string]struct{}{
+	"address":      {},
+	"addresses":    {},
+	"addressNotes": {},
+}
+
+// readOnlyAccessAllowed returns true if the token grants access to the requested endpoint.
+func readOnlyAccessAllowed(token *apitokens.Token, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for scope, routes := range readOnlyRoutes {
+		if !token.HasScope(scope) {
+			continue
+		}
+		for _, route := range routes {
+			if route.MatchString(r.URL.Path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureReadOnlyAccess serves requests authorized with a read-only API token ("Authorization:
+// Bearer <secret>"), limited to the endpoints granted by its scopes. All other requests are passed
+// on to next.
+func ensureReadOnlyAccess(h http.Handler, next http.Handler, tokens *apitokens.Store, log *logrus.Entry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		methodLogEntry := log.WithField("path", r.URL.Path)
+		token := tokens.Lookup(strings.TrimPrefix(authorization, "Bearer "))
+		if token == nil {
+			methodLogEntry.Error("Incorrect read-only token in API request")
+			http.Error(w, "incorrect token", http.StatusUnauthorized)
+			return
+		}
+		if !readOnlyAccessAllowed(token, r) {
+			methodLogEntry.WithField("token", token.ID).Error("Read-only token not allowed for request")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenContextKey, token)))
+	})
+}
+
+// redactForToken removes the addresses from the response if the request was made with a read-only
+// token lacking the addresses scope.
+func redactForToken(r *http.Request, value interface{}) (interface{}, error) {
+	token, ok := r.Context().Value(apiTokenContextKey).(*apitokens.Token)
+	if !ok || token.HasScope(apitokens.ScopeAddresses) {
+		return value, nil
+	}
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(jsonBytes, &generic); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return redact(generic), nil
+}
+
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, element := range value {
+			if _, ok := redactedKeys[key]; ok {
+				delete(value, key)
+				continue
+			}
+			value[key] = redact(element)
+		}
+	case []interface{}:
+		for index, element := range value {
+			value[index] = redact(element)
+		}
+	}
+	return value
+}
+
+func (handlers *Handlers) getAPITokensHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.APITokens().Tokens(), nil
+}
+
+func (handlers *Handlers) postAPITokensHandler(r *http.Request) (interface{}, error) {
+	jsonBody := struct {
+		Name   string            `json:"name"`
+		Scopes []apitokens.Scope `json:"scopes"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	token, secret, err := handlers.backend.APITokens().Mint(jsonBody.Name, jsonBody.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	// The secret is only shown once, it is not stored.
+	return map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	}, nil
+}
+
+func (handlers *Handlers) postAPITokensRevokeHandler(r *http.Request) (interface{}, error) {
+	var id string
+	if err := json.NewDecoder(r.Body).Decode(&id); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.APITokens().Revoke(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
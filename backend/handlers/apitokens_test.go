@@ -0,0 +1,52 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apitokens"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyAPIToken(t *testing.T) {
+	backend := backend.NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-apitokens-"), false, false, false, false, "", ""))
+	handlers := handlers.NewHandlers(backend, handlers.NewConnectionData(8082, "apptoken"))
+	_, secret, err := backend.APITokens().Mint("dashboard", []apitokens.Scope{apitokens.ScopeBalances})
+	require.NoError(t, err)
+
+	status := func(method, path, authorization string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, nil)
+		request.Header.Set("Authorization", authorization)
+		handlers.Router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	require.Equal(t, http.StatusOK, status("GET", "/api/accounts", "Bearer "+secret))
+	require.Equal(t, http.StatusOK, status("GET", "/api/coins/rates", "Bearer "+secret))
+	require.Equal(t, http.StatusForbidden, status("GET", "/api/config", "Bearer "+secret))
+	require.Equal(t, http.StatusForbidden, status("GET", "/api/api-tokens", "Bearer "+secret))
+	require.Equal(t, http.StatusForbidden, status("POST", "/api/config", "Bearer "+secret))
+	require.Equal(t, http.StatusUnauthorized, status("GET", "/api/accounts", "Bearer wrong"))
+	require.Equal(t, http.StatusOK, status("GET", "/api/config", "Basic apptoken"))
+	require.Equal(t, http.StatusUnauthorized, status("GET", "/api/config", "Basic wrong"))
+}
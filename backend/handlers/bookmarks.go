@@ -0,0 +1,111 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func bookmarkJSON(bookmark *backend.BookmarkStatus) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       bookmark.ID,
+		"coinCode": bookmark.CoinCode,
+		"address":  bookmark.Address,
+		"label":    bookmark.Label,
+		"created":  bookmark.Created.Format(time.RFC3339),
+		"status":   bookmark.Status,
+	}
+}
+
+// bookmarkError returns the API error of a bookmark which can't be added.
+func bookmarkError(err error) *apierror.Error {
+	if bitbox.IsErrorAbort(err) {
+		return apierror.Wrap(apierror.CodeAborted, err)
+	}
+	if validationErr, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		if validationErr == "invalid address" {
+			return apierror.Wrap(apierror.CodeInvalidAddress, err)
+		}
+		return apierror.Wrap(apierror.CodeInvalidInput, err)
+	}
+	return apierror.FromError(err)
+}
+
+func (handlers *Handlers) getBookmarksHandler(_ *http.Request) (interface{}, error) {
+	bookmarks, err := handlers.backend.Bookmarks()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for _, bookmark := range bookmarks {
+		result = append(result, bookmarkJSON(bookmark))
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) postBookmarksHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		CoinCode string `json:"coinCode"`
+		Address  string `json:"address"`
+		Label    string `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	bookmark, err := handlers.backend.AddBookmark(input.CoinCode, input.Address, input.Label)
+	if err != nil {
+		return nil, bookmarkError(err)
+	}
+	return map[string]interface{}{"success": true, "id": bookmark.ID}, nil
+}
+
+func (handlers *Handlers) postBookmarksRemoveHandler(r *http.Request) (interface{}, error) {
+	var id string
+	if err := json.NewDecoder(r.Body).Decode(&id); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.RemoveBookmark(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// postBookmarksCheckHandler returns the bookmark of the recipient address, or null if the address
+// is not bookmarked. The frontend warns before sending if its status is "invalid".
+func (handlers *Handlers) postBookmarksCheckHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		CoinCode string `json:"coinCode"`
+		Address  string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	bookmark, err := handlers.backend.CheckBookmark(input.CoinCode, input.Address)
+	if err != nil {
+		return nil, err
+	}
+	if bookmark == nil {
+		return nil, nil
+	}
+	return bookmarkJSON(bookmark), nil
+}
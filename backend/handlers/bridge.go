@@ -0,0 +1,89 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// getBridgeHandler returns the status of the bridge for web wallets and the approved origins.
+func (handlers *Handlers) getBridgeHandler(_ *http.Request) (interface{}, error) {
+	pairings, err := handlers.backend.BridgePairings()
+	if err != nil {
+		return nil, err
+	}
+	pairingsJSON := []map[string]interface{}{}
+	for _, pairing := range pairings {
+		pairingsJSON = append(pairingsJSON, map[string]interface{}{
+			"origin":  pairing.Origin,
+			"created": pairing.Created.Format(time.RFC3339),
+			"status":  pairing.Status,
+		})
+	}
+	status := handlers.backend.BridgeStatus()
+	return map[string]interface{}{
+		"enabled":       handlers.backend.Config().Config().Backend.Bridge.Enabled,
+		"running":       status.Running,
+		"address":       status.Address,
+		"pendingOrigin": status.PendingOrigin,
+		"pendingPSBT":   status.PendingPSBT,
+		"pairings":      pairingsJSON,
+	}, nil
+}
+
+func (handlers *Handlers) postBridgeHandler(r *http.Request) (interface{}, error) {
+	jsonBody := struct {
+		Enabled bool `json:"enabled"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.SetBridge(jsonBody.Enabled); err != nil {
+		return nil, err
+	}
+	handlers.publishConfigChanged()
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postBridgePairingsRemoveHandler(r *http.Request) (interface{}, error) {
+	var origin string
+	if err := json.NewDecoder(r.Body).Decode(&origin); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.RemoveBridgePairing(origin); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// postBridgePSBTReviewHandler approves or rejects signing the PSBT waiting for review. The ID of
+// the reviewed PSBT has to match the pending one.
+func (handlers *Handlers) postBridgePSBTReviewHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		ID       string `json:"id"`
+		Approved bool   `json:"approved"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.ReviewBridgePSBT(jsonBody.ID, jsonBody.Approved); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
+)
+
+// Capability is a group of endpoints which can be granted to a session token. The mobile shell
+// requests a session token with only the capabilities a screen needs, so that a compromised
+// webview can't use the rest of the API.
+type Capability string
+
+const (
+	// CapabilityReadOnly grants access to all GET endpoints and the events websocket.
+	CapabilityReadOnly Capability = "read-only"
+	// CapabilitySend grants access to the endpoints which create, sign and broadcast transactions.
+	CapabilitySend Capability = "send"
+	// CapabilityDeviceAdmin grants access to all other endpoints, which manage the devices, the
+	// accounts and the app configuration.
+	CapabilityDeviceAdmin Capability = "device-admin"
+)
+
+// sendRoutes are the endpoints in the send capability.
+var sendRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/api/account/[^/]+/(tx-proposal|sendtx|offline-tx|broadcast-tx)$`),
+	regexp.MustCompile(`^/api/account/[^/]+/(fee-bump-proposal|fee-bump)$`),
+	regexp.MustCompile(`^/api/account/[^/]+/(fork-sweep-proposal|fork-sweep)$`),
+	regexp.MustCompile(`^/api/account/[^/]+/vault/(deposit|unvault)$`),
+	regexp.MustCompile(`^/api/cold-storage/sweep-proposal$`),
+	regexp.MustCompile(`^/api/psbt/(summary|sign)$`),
+	regexp.MustCompile(`^/api/send-batch$`),
+	regexp.MustCompile(`^/api/migration/(plan|migrate)$`),
+}
+
+// adminRoutes are the GET endpoints which are not in the read-only capability. The app
+// configuration contains secrets, e.g. the password of the metadata backup.
+var adminRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/api/api-tokens`),
+	regexp.MustCompile(`^/api/config$`),
+}
+
+// requiredCapability returns the capability needed for the request.
+func requiredCapability(r *http.Request) Capability {
+	for _, route := range adminRoutes {
+		if route.MatchString(r.URL.Path) {
+			return CapabilityDeviceAdmin
+		}
+	}
+	if r.Method == http.MethodGet {
+		return CapabilityReadOnly
+	}
+	for _, route := range sendRoutes {
+		if route.MatchString(r.URL.Path) {
+			return CapabilitySend
+		}
+	}
+	return CapabilityDeviceAdmin
+}
+
+// GrantCapabilities returns a new session token granting the given capabilities. Requests are
+// authorized with the header "Authorization: Session <token>".
+func (connectionData *ConnectionData) GrantCapabilities(capabilities []Capability) (string, error) {
+	for _, capability := range capabilities {
+		switch capability {
+		case CapabilityReadOnly, CapabilitySend, CapabilityDeviceAdmin:
+		default:
+			return "", errp.Newf("unknown capability %s", capability)
+		}
+	}
+	token, err := random.HexString(16)
+	if err != nil {
+		return "", err
+	}
+	defer connectionData.sessionsLock.Lock()()
+	connectionData.sessions[token] = append([]Capability{}, capabilities...)
+	return token, nil
+}
+
+// RevokeCapabilities invalidates the session token.
+func (connectionData *ConnectionData) RevokeCapabilities(token string) {
+	defer connectionData.sessionsLock.Lock()()
+	delete(connectionData.sessions, token)
+}
+
+// sessionAllows returns whether the session token exists, and whether it grants the capability.
+func (connectionData *ConnectionData) sessionAllows(token string, capability Capability) (bool, bool) {
+	defer connectionData.sessionsLock.RLock()()
+	capabilities, ok := connectionData.sessions[token]
+	if !ok {
+		return false, false
+	}
+	for _, granted := range capabilities {
+		if granted == capability {
+			return true, true
+		}
+	}
+	return true, false
+}
+
+// isSessionAuthorization returns true if the authorization value is a session token, see
+// GrantCapabilities().
+func isSessionAuthorization(authorization string) bool {
+	return strings.HasPrefix(authorization, "Session ")
+}
+
+// websocketSessionAllowed returns true if the websocket authorization message contains a session
+// token with the read-only capability.
+func (connectionData *ConnectionData) websocketSessionAllowed(msg string) bool {
+	authorization := strings.TrimPrefix(msg, "Authorization: ")
+	if !isSessionAuthorization(authorization) {
+		return false
+	}
+	_, allowed := connectionData.sessionAllows(strings.TrimPrefix(authorization, "Session "), CapabilityReadOnly)
+	return allowed
+}
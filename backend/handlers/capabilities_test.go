@@ -0,0 +1,62 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	backend := backend.NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-capabilities-"), false, false, false, false, "", ""))
+	connectionData := handlers.NewConnectionData(8082, "apptoken")
+	apiHandlers := handlers.NewHandlers(backend, connectionData)
+
+	_, err := connectionData.GrantCapabilities([]handlers.Capability{"everything"})
+	require.Error(t, err)
+	readOnly, err := connectionData.GrantCapabilities([]handlers.Capability{handlers.CapabilityReadOnly})
+	require.NoError(t, err)
+	admin, err := connectionData.GrantCapabilities([]handlers.Capability{handlers.CapabilityDeviceAdmin})
+	require.NoError(t, err)
+
+	status := func(method, path, token string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, nil)
+		request.Header.Set("Authorization", "Session "+token)
+		apiHandlers.Router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	require.Equal(t, http.StatusOK, status("GET", "/api/version", readOnly))
+	require.Equal(t, http.StatusForbidden, status("GET", "/api/config", readOnly))
+	require.Equal(t, http.StatusForbidden, status("POST", "/api/config", readOnly))
+	require.Equal(t, http.StatusForbidden, status("GET", "/api/api-tokens", readOnly))
+	require.Equal(t, http.StatusForbidden, status("POST", "/api/psbt/sign", readOnly))
+	require.Equal(t, http.StatusForbidden, status("POST", "/api/psbt/sign", admin))
+	require.Equal(t, http.StatusForbidden, status("GET", "/api/version", admin))
+	require.Equal(t, http.StatusOK, status("GET", "/api/config", admin))
+	require.Equal(t, http.StatusOK, status("GET", "/api/api-tokens", admin))
+	require.Equal(t, http.StatusUnauthorized, status("GET", "/api/version", "wrong"))
+
+	connectionData.RevokeCapabilities(readOnly)
+	require.Equal(t, http.StatusUnauthorized, status("GET", "/api/version", readOnly))
+}
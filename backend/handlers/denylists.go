@@ -0,0 +1,64 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func (handlers *Handlers) getDenylistsHandler(_ *http.Request) (interface{}, error) {
+	result := []map[string]interface{}{}
+	for _, list := range handlers.backend.Denylists() {
+		result = append(result, map[string]interface{}{
+			"source":   list.Source,
+			"imported": list.Imported.Format(time.RFC3339),
+			"count":    list.Count,
+			"bundled":  list.Bundled,
+		})
+	}
+	return result, nil
+}
+
+// postDenylistsHandler imports a list of addresses, one per line, under the given source.
+func (handlers *Handlers) postDenylistsHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		Source string `json:"source"`
+		List   string `json:"list"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	count, err := handlers.backend.ImportDenylist(input.Source, input.List)
+	if err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	return map[string]interface{}{"success": true, "count": count}, nil
+}
+
+func (handlers *Handlers) postDenylistsRemoveHandler(r *http.Request) (interface{}, error) {
+	var source string
+	if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.RemoveDenylist(source); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
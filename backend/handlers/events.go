@@ -0,0 +1,182 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// subscriberQueueSize is the number of events queued for a slow frontend before it is
+// disconnected. The frontend has to reload its state when reconnecting, instead of silently missing
+// events.
+const subscriberQueueSize = 1000
+
+// eventTopic returns the topic of a marshalled event, which frontends can subscribe to: the type
+// of the event (e.g. "account", "devices"), or the first segment of the subject of an observable
+// event (e.g. "coins").
+func eventTopic(event []byte) string {
+	var fields struct {
+		Type    string `json:"type"`
+		Subject string `json:"subject"`
+	}
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return ""
+	}
+	if fields.Type != "" {
+		return fields.Type
+	}
+	return strings.SplitN(fields.Subject, "/", 2)[0]
+}
+
+// subscriber is a connected frontend.
+type subscriber struct {
+	queue chan []byte
+	// topics are the topics the frontend subscribed to. nil means all topics.
+	topics     map[string]struct{}
+	topicsLock locker.Locker
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{queue: make(chan []byte, subscriberQueueSize)}
+}
+
+// handleMessage handles a message of the frontend. The only message is a subscription to a list
+// of topics: {"subscribe": ["account", "devices"]}.
+func (subscriber *subscriber) handleMessage(message []byte, log *logrus.Entry) {
+	var subscription struct {
+		Subscribe []string `json:"subscribe"`
+	}
+	if err := json.Unmarshal(message, &subscription); err != nil {
+		log.WithError(err).Error("Invalid message from the frontend")
+		return
+	}
+	subscriber.setTopics(subscription.Subscribe)
+}
+
+// setTopics restricts the events sent to the frontend to the given topics. No topics means all
+// topics.
+func (subscriber *subscriber) setTopics(topics []string) {
+	defer subscriber.topicsLock.Lock()()
+	if len(topics) == 0 {
+		subscriber.topics = nil
+		return
+	}
+	subscriber.topics = map[string]struct{}{}
+	for _, topic := range topics {
+		subscriber.topics[topic] = struct{}{}
+	}
+}
+
+func (subscriber *subscriber) subscribed(topic string) bool {
+	defer subscriber.topicsLock.RLock()()
+	if subscriber.topics == nil {
+		return true
+	}
+	_, ok := subscriber.topics[topic]
+	return ok
+}
+
+// eventHub fans out the backend events to all connected frontends, so that every frontend (e.g.
+// the desktop app and a browser tab in dev mode) gets every event it subscribed to.
+type eventHub struct {
+	events      <-chan interface{}
+	startOnce   sync.Once
+	subscribers map[*subscriber]struct{}
+	lock        locker.Locker
+	log         *logrus.Entry
+}
+
+func newEventHub(events <-chan interface{}, log *logrus.Entry) *eventHub {
+	return &eventHub{
+		events:      events,
+		subscribers: map[*subscriber]struct{}{},
+		log:         log,
+	}
+}
+
+// subscribe adds a frontend, whose events are sent to send. send is closed when the frontend is
+// unsubscribed, see unsubscribe(). The events are consumed from the first subscription on, so that
+// the events stay queued in the backend until a frontend connects.
+func (hub *eventHub) subscribe(newSubscriber *subscriber, send chan<- []byte, quit <-chan struct{}) {
+	hub.startOnce.Do(func() { go hub.run() })
+	func() {
+		defer hub.lock.Lock()()
+		hub.subscribers[newSubscriber] = struct{}{}
+		hub.log.WithField("subscribers", len(hub.subscribers)).Info("Frontend connected")
+	}()
+	go func() {
+		defer close(send)
+		for {
+			select {
+			case <-quit:
+				hub.unsubscribe(newSubscriber)
+				return
+			case event, ok := <-newSubscriber.queue:
+				if !ok {
+					return
+				}
+				select {
+				case send <- event:
+				case <-quit:
+					hub.unsubscribe(newSubscriber)
+					return
+				}
+			}
+		}
+	}()
+}
+
+// unsubscribe removes the frontend. Its queue is closed, which closes the connection.
+func (hub *eventHub) unsubscribe(subscriber *subscriber) {
+	defer hub.lock.Lock()()
+	if _, ok := hub.subscribers[subscriber]; !ok {
+		return
+	}
+	delete(hub.subscribers, subscriber)
+	close(subscriber.queue)
+	hub.log.WithField("subscribers", len(hub.subscribers)).Info("Frontend disconnected")
+}
+
+// publish sends the event to all frontends subscribed to its topic.
+func (hub *eventHub) publish(event interface{}) {
+	message := jsonp.MustMarshal(event)
+	topic := eventTopic(message)
+	defer hub.lock.Lock()()
+	for subscriber := range hub.subscribers {
+		if !subscriber.subscribed(topic) {
+			continue
+		}
+		select {
+		case subscriber.queue <- message:
+		default:
+			hub.log.Warning("Disconnecting a frontend which does not keep up with the events")
+			delete(hub.subscribers, subscriber)
+			close(subscriber.queue)
+		}
+	}
+}
+
+func (hub *eventHub) run() {
+	for event := range hub.events {
+		hub.publish(event)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+)
+
+func TestEventTopic(t *testing.T) {
+	require.Equal(t, "account", eventTopic([]byte(`{"type":"account","code":"btc","data":"syncdone"}`)))
+	require.Equal(t, "coins", eventTopic([]byte(`{"subject":"coins/rates","action":"replace"}`)))
+	require.Equal(t, "", eventTopic([]byte(`"invalid"`)))
+}
+
+func receive(t *testing.T, send <-chan []byte) string {
+	t.Helper()
+	select {
+	case message := <-send:
+		return string(message)
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return ""
+	}
+}
+
+func TestEventHub(t *testing.T) {
+	events := make(chan interface{})
+	hub := newEventHub(events, logrus.NewEntry(logrus.StandardLogger()))
+
+	send1, quit1 := make(chan []byte), make(chan struct{})
+	subscriber1 := newSubscriber()
+	hub.subscribe(subscriber1, send1, quit1)
+	send2, quit2 := make(chan []byte), make(chan struct{})
+	subscriber2 := newSubscriber()
+	hub.subscribe(subscriber2, send2, quit2)
+
+	// Every frontend receives every event.
+	events <- map[string]string{"type": "backend", "data": "configChanged"}
+	require.Equal(t, `{"data":"configChanged","type":"backend"}`, receive(t, send1))
+	require.Equal(t, `{"data":"configChanged","type":"backend"}`, receive(t, send2))
+
+	// Subscriptions restrict the events of a frontend.
+	subscriber2.handleMessage([]byte(`{"subscribe":["coins"]}`), hub.log)
+	events <- map[string]string{"type": "account", "data": "syncdone"}
+	events <- observable.Event{Subject: "coins/rates"}
+	require.Equal(t, `{"data":"syncdone","type":"account"}`, receive(t, send1))
+	require.Equal(t, `{"subject":"coins/rates","action":"","object":null}`, receive(t, send1))
+	require.Equal(t, `{"subject":"coins/rates","action":"","object":null}`, receive(t, send2))
+
+	// A disconnected frontend is unsubscribed, and its send channel is closed.
+	close(quit1)
+	_, ok := <-send1
+	require.False(t, ok)
+	events <- map[string]string{"type": "coins"}
+	require.Equal(t, `{"type":"coins"}`, receive(t, send2))
+	require.Len(t, hub.subscribers, 1)
+}
+
+func TestEventHubSlowFrontend(t *testing.T) {
+	hub := newEventHub(nil, logrus.NewEntry(logrus.StandardLogger()))
+	// Not started, so that the queue is not consumed.
+	slow := newSubscriber()
+	hub.subscribers[slow] = struct{}{}
+	for i := 0; i < subscriberQueueSize; i++ {
+		hub.publish(i)
+	}
+	require.Len(t, hub.subscribers, 1)
+	// The queue is full, so the frontend is disconnected.
+	hub.publish(0)
+	require.Empty(t, hub.subscribers)
+	for range slow.queue {
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// requireFeature wraps the handler of an endpoint which belongs to the feature, so that it fails
+// with apierror.CodeFeatureDisabled while the feature is disabled.
+func (handlers *Handlers) requireFeature(
+	feature features.Feature,
+	f func(*http.Request) (interface{}, error)) func(*http.Request) (interface{}, error) {
+	return func(r *http.Request) (interface{}, error) {
+		if !handlers.backend.Features().Enabled(feature) {
+			return apierror.New(apierror.CodeFeatureDisabled,
+				fmt.Sprintf("The feature %s is disabled", feature)).Response(), nil
+		}
+		return f(r)
+	}
+}
+
+func (handlers *Handlers) getFeaturesHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.Features().Status(), nil
+}
+
+// postFeatureOverrideHandler enables or disables a feature regardless of the rules. If enabled is
+// null, the override is removed.
+func (handlers *Handlers) postFeatureOverrideHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Feature features.Feature `json:"feature"`
+		Enabled *bool            `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.Features().SetOverride(jsonBody.Feature, jsonBody.Enabled); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	return handlers.backend.Features().Status(), nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	accountHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/graphql"
+)
+
+// value returns a resolver for a field without arguments.
+func value(f func() interface{}) graphql.Resolver {
+	return func(map[string]interface{}) (interface{}, error) {
+		return f(), nil
+	}
+}
+
+// graphqlAccount resolves the fields of an account. The balance and the transactions are null until
+// the account finished the initial sync.
+func graphqlAccount(account *btc.Account) graphql.Object {
+	return graphql.Object{
+		"code":     value(func() interface{} { return account.Code() }),
+		"name":     value(func() interface{} { return account.Name() }),
+		"coinCode": value(func() interface{} { return account.Coin().Name() }),
+		"synced":   value(func() interface{} { return account.InitialSyncDone() }),
+		"offline":  value(func() interface{} { return account.Offline() }),
+		"balance": value(func() interface{} {
+			if !account.InitialSyncDone() {
+				return nil
+			}
+			return accountHandlers.NewBalance(account)
+		}),
+		"transactions": func(args map[string]interface{}) (interface{}, error) {
+			if !account.InitialSyncDone() {
+				return nil, nil
+			}
+			txs := accountHandlers.NewTransactions(account)
+			if limit, ok := args["limit"]; ok {
+				limit, ok := limit.(float64)
+				if !ok || limit < 0 {
+					return nil, errp.New("limit must be a positive number")
+				}
+				if int(limit) < len(txs) {
+					txs = txs[:int(limit)]
+				}
+			}
+			return txs, nil
+		},
+	}
+}
+
+// graphqlRoot resolves the top level fields of a query.
+func (handlers *Handlers) graphqlRoot() graphql.Object {
+	return graphql.Object{
+		"accounts": value(func() interface{} {
+			accounts := []graphql.Object{}
+			for _, account := range handlers.backend.Accounts() {
+				accounts = append(accounts, graphqlAccount(account))
+			}
+			return accounts
+		}),
+		"account": func(args map[string]interface{}) (interface{}, error) {
+			code, ok := args["code"].(string)
+			if !ok {
+				return nil, errp.New("the argument code is required")
+			}
+			for _, account := range handlers.backend.Accounts() {
+				if account.Code() == code {
+					return graphqlAccount(account), nil
+				}
+			}
+			return nil, nil
+		},
+		"rates": value(func() interface{} { return handlers.backend.Rates() }),
+	}
+}
+
+func (handlers *Handlers) postGraphQLHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return graphql.Execute(handlers.graphqlRoot(), input.Query), nil
+}
@@ -18,30 +18,51 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/btcsuite/btcutil"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
-	qrcode "github.com/skip2/go-qrcode"
 	"golang.org/x/text/language"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/activity"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apitokens"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bookmarks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/bridge"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/chart"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
 	accountHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coinmetadata"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/denylist"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
 	bitboxHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/handlers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/doctor"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers/requestid"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/i18n"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/metadatabackup"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/remotesigner"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
-	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/fixtures"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/qr"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/system"
 )
 
@@ -51,9 +72,12 @@ type Backend interface {
 	DefaultConfig() config.AppConfig
 	Coin(string) coin.Coin
 	AccountsStatus() string
+	ResetAccount(code string) error
 	Testing() bool
+	DemoMode() bool
 	Accounts() []*btc.Account
 	UserLanguage() language.Tag
+	Localizer() *i18n.Localizer
 	OnAccountInit(f func(*btc.Account))
 	OnAccountUninit(f func(*btc.Account))
 	OnDeviceInit(f func(device.Interface))
@@ -66,8 +90,65 @@ type Backend interface {
 	Register(device device.Interface) error
 	Deregister(deviceID string)
 	Rates() map[string]map[string]float64
+	CoinMetadata() []*coinmetadata.Coin
+	FirmwareChannel() *bitbox.FirmwareChannel
+	Features() *features.Flags
+	RemoteSignerStatus() (*backend.RemoteSignerStatus, error)
+	SetRemoteSignerMode(enabled bool) error
+	ApproveRemoteSignerPairing(code string, approved bool) error
+	ApproveRemoteSignerPSBT(id string, approved bool) error
+	PairRemoteSigner(address string) (*remotesigner.Peer, error)
+	ConnectRemoteSigner(publicKey string) error
+	DisconnectRemoteSigner() error
+	RemoveRemoteSignerPeer(publicKey string, role remotesigner.Role) error
 	DownloadCert(string) (string, error)
 	CheckElectrumServer(string, string) error
+	USBPermissionDenied() bool
+	InstallUdevRules() error
+	USBDiagnostics() *usb.Diagnostics
+	USBHotplugEvents() []*usb.HotplugEvent
+	ActiveDevice() *backend.ActiveDevice
+	SelectDevice(deviceID string) error
+	PairedDevices() []*backend.PairedDevice
+	UpdatePairedDevice(fingerprint string, nickname string, trusted bool) error
+	ForgetPairedDevice(fingerprint string) error
+	USBCapture() *usb.CaptureStatus
+	SetUSBCapture(enabled bool) error
+	ElectrumRecording() *fixtures.RecorderStatus
+	SetElectrumRecording(enabled bool) error
+	Chart(accountCode string, fiat string, granularity chart.Granularity) ([]chart.Point, error)
+	ColdStorageSuggestions() []*backend.ColdStorageSuggestion
+	ColdStorageSweepProposal(string) (*backend.ColdStorageSweep, error)
+	APITokens() *apitokens.Store
+	PSBTSummary(string) (*backend.PSBTSummary, error)
+	SignPSBT(string) (string, error)
+	UploadMetadataBackup(passphrase string) error
+	RestoreMetadataBackup(passphrase string) (*backend.MetadataRestoreResult, error)
+	AddBookmark(coinCode, address, label string) (*bookmarks.Bookmark, error)
+	Bookmarks() ([]*backend.BookmarkStatus, error)
+	RemoveBookmark(id string) error
+	CheckBookmark(coinCode, address string) (*backend.BookmarkStatus, error)
+	Denylists() []*denylist.Summary
+	ImportDenylist(source string, list string) (int, error)
+	RemoveDenylist(source string) error
+	BridgeStatus() *bridge.Status
+	SetBridge(enabled bool) error
+	BridgePairings() ([]*backend.BridgePairingStatus, error)
+	RemoveBridgePairing(origin string) error
+	ReviewBridgePSBT(id string, approved bool) error
+	Activity(before int64, limit int) ([]*activity.Entry, bool)
+	ActivityUnread() int
+	MarkActivityRead() error
+	Egress() egress.Report
+	DataDirectoryReport() *doctor.Report
+	CreateSupportBundle() (*backend.SupportBundle, error)
+	SendTxBatch([]*btc.PreparedTx) ([]*backend.BatchTxResult, error)
+	MaxSpendable(accountCodes []string, feeRatePerKb btcutil.Amount) (*backend.MaxSpendable, error)
+	SetPaymentCodeAccount(coinCode string, accountCode string) error
+	MigrationSource() (string, bool)
+	MigrationAccounts() ([]*backend.MigrationAccount, error)
+	MigrationPlan(destinations map[string]string) ([]*backend.MigrationSweep, error)
+	Migrate(sweeps []*backend.ConfirmedSweep) ([]*backend.BatchTxResult, error)
 }
 
 // Handlers provides a web api to the backend.
@@ -78,7 +159,7 @@ type Handlers struct {
 	// backend to secure the API call. The data is fed into the static javascript app
 	// that is served, so the client knows where and how to connect to.
 	apiData           *ConnectionData
-	backendEvents     <-chan interface{}
+	events            *eventHub
 	websocketUpgrader websocket.Upgrader
 	log               *logrus.Entry
 }
@@ -88,15 +169,20 @@ type ConnectionData struct {
 	port    int
 	token   string
 	devMode bool
+
+	// sessions maps the session tokens to the capabilities they grant, see GrantCapabilities().
+	sessions     map[string][]Capability
+	sessionsLock locker.Locker
 }
 
 // NewConnectionData creates a connection data struct which holds the port and token for the API.
 // If the port is -1 or the token is empty, we assume dev-mode.
 func NewConnectionData(port int, token string) *ConnectionData {
 	return &ConnectionData{
-		port:    port,
-		token:   token,
-		devMode: len(token) == 0,
+		port:     port,
+		token:    token,
+		devMode:  len(token) == 0,
+		sessions: map[string][]Capability{},
 	}
 }
 
@@ -126,8 +212,9 @@ func NewHandlers(
 
 	getAPIRouter := func(subrouter *mux.Router) func(string, func(*http.Request) (interface{}, error)) *mux.Route {
 		return func(path string, f func(*http.Request) (interface{}, error)) *mux.Route {
-			return subrouter.Handle(path, ensureAPITokenValid(handlers.apiMiddleware(connData.isDev(), f),
-				connData, log))
+			h := handlers.apiMiddleware(connData.isDev(), f)
+			return subrouter.Handle(path, requestid.Middleware(ensureReadOnlyAccess(h,
+				ensureAPITokenValid(h, connData, log), backend.APITokens(), log)))
 		}
 	}
 
@@ -136,25 +223,106 @@ func NewHandlers(
 	getAPIRouter(apiRouter)("/config", handlers.getConfigHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/config/default", handlers.getDefaultConfigHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/config", handlers.postConfigHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/config/patch", handlers.postConfigPatchHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/egress", handlers.getEgressHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/data-directory/report", handlers.getDataDirectoryReportHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/support-bundle", handlers.postSupportBundleHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/open", handlers.postOpenHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/version", handlers.getVersionHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/api-schema", handlers.getAPISchemaHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/graphql",
+		handlers.requireFeature(features.FeatureGraphQL, handlers.postGraphQLHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/features", handlers.getFeaturesHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/features/override", handlers.postFeatureOverrideHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/testing", handlers.getTestingHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/demo-mode", handlers.getDemoModeHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/accounts", handlers.getAccountsHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/accounts/reset", handlers.postAccountsResetHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/accounts-status", handlers.getAccountsStatusHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/test/register", handlers.registerTestKeyStoreHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/test/deregister", handlers.deregisterTestKeyStoreHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/coins/rates", handlers.getRatesHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/metadata", handlers.getCoinMetadataHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/convertToFiat", handlers.getConvertToFiatHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/convertFromFiat", handlers.getConvertFromFiatHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/tltc/headers/status", handlers.getHeadersStatus("tltc")).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/tbtc/headers/status", handlers.getHeadersStatus("tbtc")).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/ltc/headers/status", handlers.getHeadersStatus("ltc")).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/btc/headers/status", handlers.getHeadersStatus("btc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/tltc/tip", handlers.getTip("tltc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/tbtc/tip", handlers.getTip("tbtc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/ltc/tip", handlers.getTip("ltc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/btc/tip", handlers.getTip("btc")).Methods("GET")
+	getAPIRouter(apiRouter)("/electrum-recording", handlers.getElectrumRecordingHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/electrum-recording", handlers.postElectrumRecordingHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/certs/download", handlers.postCertsDownloadHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/certs/check", handlers.postCertsCheckHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/chart", handlers.getChartHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/cold-storage/suggestions", handlers.getColdStorageSuggestionsHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/cold-storage/sweep-proposal", handlers.postColdStorageSweepProposalHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/psbt/summary", handlers.postPSBTSummaryHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/psbt/sign", handlers.postPSBTSignHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/send-batch", handlers.postSendBatchHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/max-spendable", handlers.postMaxSpendableHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/payment-code-account", handlers.postPaymentCodeAccountHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/migration/accounts", handlers.getMigrationAccountsHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/migration/plan", handlers.postMigrationPlanHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/migration/migrate", handlers.postMigrateHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/metadata-backup/upload", handlers.postMetadataBackupUploadHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/metadata-backup/restore", handlers.postMetadataBackupRestoreHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/api-tokens", handlers.getAPITokensHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/api-tokens", handlers.postAPITokensHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/api-tokens/revoke", handlers.postAPITokensRevokeHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/bookmarks", handlers.getBookmarksHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/bookmarks", handlers.postBookmarksHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/bookmarks/remove", handlers.postBookmarksRemoveHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/bookmarks/check", handlers.postBookmarksCheckHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/denylists", handlers.getDenylistsHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/denylists", handlers.postDenylistsHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/denylists/remove", handlers.postDenylistsRemoveHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/keystores/fingerprints", handlers.getRootFingerprintsHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/keystores/fingerprints/verify",
+		handlers.postVerifyRootFingerprintHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/paired-devices", handlers.getPairedDevicesHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/paired-devices/update",
+		handlers.postUpdatePairedDeviceHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/paired-devices/forget",
+		handlers.postForgetPairedDeviceHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/bridge", handlers.getBridgeHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/bridge", handlers.postBridgeHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/bridge/pairings/remove", handlers.postBridgePairingsRemoveHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/bridge/psbt/review", handlers.postBridgePSBTReviewHandler).Methods("POST")
+	remoteSigning := func(f func(*http.Request) (interface{}, error)) func(*http.Request) (interface{}, error) {
+		return handlers.requireFeature(features.FeatureRemoteSigning, f)
+	}
+	getAPIRouter(apiRouter)("/remote-signer", remoteSigning(handlers.getRemoteSignerHandler)).Methods("GET")
+	getAPIRouter(apiRouter)("/remote-signer/mode",
+		remoteSigning(handlers.postRemoteSignerModeHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/remote-signer/approve",
+		remoteSigning(handlers.postRemoteSignerApproveHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/remote-signer/psbt/approve",
+		remoteSigning(handlers.postRemoteSignerPSBTApproveHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/remote-signer/pair",
+		remoteSigning(handlers.postRemoteSignerPairHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/remote-signer/connect",
+		remoteSigning(handlers.postRemoteSignerConnectHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/remote-signer/disconnect",
+		remoteSigning(handlers.postRemoteSignerDisconnectHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/remote-signer/peers/remove",
+		remoteSigning(handlers.postRemoteSignerPeersRemoveHandler)).Methods("POST")
+	getAPIRouter(apiRouter)("/activity", handlers.getActivityHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/activity/read", handlers.postActivityReadHandler).Methods("POST")
 
 	devicesRouter := getAPIRouter(apiRouter.PathPrefix("/devices").Subrouter())
 	devicesRouter("/registered", handlers.getDevicesRegisteredHandler).Methods("GET")
+	devicesRouter("/active", handlers.getActiveDeviceHandler).Methods("GET")
+	devicesRouter("/select", handlers.postSelectDeviceHandler).Methods("POST")
+	devicesRouter("/usb-permission", handlers.getUSBPermissionHandler).Methods("GET")
+	devicesRouter("/install-udev-rules", handlers.postInstallUdevRulesHandler).Methods("POST")
+	devicesRouter("/usb-diagnostics", handlers.getUSBDiagnosticsHandler).Methods("GET")
+	devicesRouter("/hotplug", handlers.getUSBHotplugEventsHandler).Methods("GET")
+	devicesRouter("/usb-capture", handlers.getUSBCaptureHandler).Methods("GET")
+	devicesRouter("/usb-capture", handlers.postUSBCaptureHandler).Methods("POST")
 
 	handlersMapLock := locker.Locker{}
 
@@ -185,20 +353,28 @@ func NewHandlers(
 		if _, ok := deviceHandlersMap[deviceID]; !ok {
 			deviceHandlersMap[deviceID] = bitboxHandlers.NewHandlers(getAPIRouter(
 				apiRouter.PathPrefix(fmt.Sprintf("/devices/%s", deviceID)).Subrouter(),
-			), log)
+			), backend.FirmwareChannel(), log)
 		}
 		return deviceHandlersMap[deviceID]
 	}
-	backend.OnDeviceInit(func(device device.Interface) {
-		getDeviceHandlers(device.Identifier()).Init(device.(*bitbox.Device))
+	backend.OnDeviceInit(func(theDevice device.Interface) {
+		// Devices of other drivers have no device specific endpoints.
+		if bitboxDevice, ok := theDevice.(*bitbox.Device); ok {
+			getDeviceHandlers(theDevice.Identifier()).Init(bitboxDevice)
+		}
 	})
 	backend.OnDeviceUninit(func(deviceID string) {
-		getDeviceHandlers(deviceID).Uninit()
+		unlock := handlersMapLock.RLock()
+		_, ok := deviceHandlersMap[deviceID]
+		unlock()
+		if ok {
+			getDeviceHandlers(deviceID).Uninit()
+		}
 	})
 
 	apiRouter.HandleFunc("/events", handlers.eventsHandler)
 
-	handlers.backendEvents = backend.Start()
+	handlers.events = newEventHub(backend.Start(), handlers.log)
 
 	return handlers
 }
@@ -210,22 +386,36 @@ func writeJSON(w http.ResponseWriter, value interface{}) {
 }
 
 func (handlers *Handlers) getQRCodeHandler(r *http.Request) (interface{}, error) {
-	data := r.URL.Query().Get("data")
-	qr, err := qrcode.New(data, qrcode.Medium)
-	if err != nil {
-		return nil, errp.WithStack(err)
-	}
-	bytes, err := qr.PNG(256)
-	if err != nil {
-		return nil, errp.WithStack(err)
-	}
-	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(bytes), nil
+	return qr.PNGDataURL(r.URL.Query().Get("data"), 256)
 }
 
 func (handlers *Handlers) getConfigHandler(_ *http.Request) (interface{}, error) {
 	return handlers.backend.Config().Config(), nil
 }
 
+func (handlers *Handlers) getEgressHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.Egress(), nil
+}
+
+func (handlers *Handlers) getDataDirectoryReportHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.DataDirectoryReport(), nil
+}
+
+// postSupportBundleHandler creates a support bundle. The archive is returned as a data URL, so that
+// the frontend can offer it for download.
+func (handlers *Handlers) postSupportBundleHandler(_ *http.Request) (interface{}, error) {
+	bundle, err := handlers.backend.CreateSupportBundle()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"filename": bundle.Filename,
+		"sha256":   bundle.SHA256,
+		"manifest": bundle.Manifest,
+		"dataURL":  "data:application/zip;base64," + base64.StdEncoding.EncodeToString(bundle.Archive),
+	}, nil
+}
+
 func (handlers *Handlers) getDefaultConfigHandler(_ *http.Request) (interface{}, error) {
 	return handlers.backend.DefaultConfig(), nil
 }
@@ -235,7 +425,29 @@ func (handlers *Handlers) postConfigHandler(r *http.Request) (interface{}, error
 	if err := json.NewDecoder(r.Body).Decode(&appConfig); err != nil {
 		return nil, errp.WithStack(err)
 	}
-	return nil, handlers.backend.Config().Set(appConfig)
+	if err := handlers.backend.Config().Set(appConfig); err != nil {
+		return nil, err
+	}
+	handlers.publishConfigChanged()
+	return nil, nil
+}
+
+func (handlers *Handlers) postConfigPatchHandler(r *http.Request) (interface{}, error) {
+	patchJSON, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	appConfig, err := handlers.backend.Config().Patch(patchJSON)
+	if err != nil {
+		return nil, err
+	}
+	handlers.publishConfigChanged()
+	return appConfig, nil
+}
+
+// publishConfigChanged notifies all frontends of a config change, so that they can reload it.
+func (handlers *Handlers) publishConfigChanged() {
+	handlers.events.publish(map[string]string{"type": "backend", "data": "configChanged"})
 }
 
 func (handlers *Handlers) postOpenHandler(r *http.Request) (interface{}, error) {
@@ -254,6 +466,10 @@ func (handlers *Handlers) getTestingHandler(_ *http.Request) (interface{}, error
 	return handlers.backend.Testing(), nil
 }
 
+func (handlers *Handlers) getDemoModeHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.DemoMode(), nil
+}
+
 func (handlers *Handlers) getAccountsHandler(_ *http.Request) (interface{}, error) {
 	return handlers.backend.Accounts(), nil
 }
@@ -262,10 +478,95 @@ func (handlers *Handlers) getAccountsStatusHandler(_ *http.Request) (interface{}
 	return handlers.backend.AccountsStatus(), nil
 }
 
+// postAccountsResetHandler deletes the cached data of the account with the given code, which is
+// then synced again from scratch.
+func (handlers *Handlers) postAccountsResetHandler(r *http.Request) (interface{}, error) {
+	var code string
+	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.ResetAccount(code); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
 func (handlers *Handlers) getDevicesRegisteredHandler(_ *http.Request) (interface{}, error) {
 	return handlers.backend.DevicesRegistered(), nil
 }
 
+func (handlers *Handlers) getActiveDeviceHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.ActiveDevice(), nil
+}
+
+func (handlers *Handlers) postSelectDeviceHandler(r *http.Request) (interface{}, error) {
+	var deviceID string
+	if err := json.NewDecoder(r.Body).Decode(&deviceID); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.SelectDevice(deviceID); err != nil {
+		return nil, err
+	}
+	return handlers.backend.ActiveDevice(), nil
+}
+
+func (handlers *Handlers) getUSBPermissionHandler(_ *http.Request) (interface{}, error) {
+	if handlers.backend.USBPermissionDenied() {
+		return apierror.New(apierror.CodeUSBPermissionDenied, "USB permission denied").Response(), nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) getUSBDiagnosticsHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.USBDiagnostics(), nil
+}
+
+func (handlers *Handlers) getUSBHotplugEventsHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.USBHotplugEvents(), nil
+}
+
+func (handlers *Handlers) getUSBCaptureHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.USBCapture(), nil
+}
+
+func (handlers *Handlers) postUSBCaptureHandler(r *http.Request) (interface{}, error) {
+	jsonBody := struct {
+		Enabled bool `json:"enabled"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.SetUSBCapture(jsonBody.Enabled); err != nil {
+		return nil, err
+	}
+	return handlers.backend.USBCapture(), nil
+}
+
+func (handlers *Handlers) getElectrumRecordingHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.ElectrumRecording(), nil
+}
+
+func (handlers *Handlers) postElectrumRecordingHandler(r *http.Request) (interface{}, error) {
+	jsonBody := struct {
+		Enabled bool `json:"enabled"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.SetElectrumRecording(jsonBody.Enabled); err != nil {
+		return nil, err
+	}
+	return handlers.backend.ElectrumRecording(), nil
+}
+
+func (handlers *Handlers) postInstallUdevRulesHandler(_ *http.Request) (interface{}, error) {
+	if err := handlers.backend.InstallUdevRules(); err != nil {
+		handlers.log.WithError(err).Error("Failed to install the udev rules")
+		return apierror.Wrap(apierror.CodeUdevRulesInstallFailed, err).Response(), nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
 func (handlers *Handlers) registerTestKeyStoreHandler(r *http.Request) (interface{}, error) {
 	jsonBody := map[string]string{}
 	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
@@ -287,16 +588,17 @@ func (handlers *Handlers) getRatesHandler(_ *http.Request) (interface{}, error)
 	return handlers.backend.Rates(), nil
 }
 
+func (handlers *Handlers) getCoinMetadataHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.CoinMetadata(), nil
+}
+
 func (handlers *Handlers) getConvertToFiatHandler(r *http.Request) (interface{}, error) {
 	from := r.URL.Query().Get("from")
 	to := r.URL.Query().Get("to")
 	amount := r.URL.Query().Get("amount")
 	amountAsFloat, err := strconv.ParseFloat(amount, 64)
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"errMsg":  "invalid amount",
-		}, nil
+		return apierror.New(apierror.CodeInvalidAmount, "invalid amount").Response(), nil
 	}
 	rate := handlers.backend.Rates()[from][to]
 	return map[string]interface{}{
@@ -311,10 +613,7 @@ func (handlers *Handlers) getConvertFromFiatHandler(r *http.Request) (interface{
 	amount := r.URL.Query().Get("amount")
 	amountAsFloat, err := strconv.ParseFloat(amount, 64)
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"errMsg":  "invalid amount",
-		}, nil
+		return apierror.New(apierror.CodeInvalidAmount, "invalid amount").Response(), nil
 	}
 	rate := handlers.backend.Rates()[to][from]
 	result := 0.0
@@ -327,12 +626,220 @@ func (handlers *Handlers) getConvertFromFiatHandler(r *http.Request) (interface{
 	}, nil
 }
 
+func (handlers *Handlers) getChartHandler(r *http.Request) (interface{}, error) {
+	granularity, err := chart.ParseGranularity(r.URL.Query().Get("granularity"))
+	if err != nil {
+		return nil, err
+	}
+	points, err := handlers.backend.Chart(
+		r.URL.Query().Get("account"), r.URL.Query().Get("fiat"), granularity)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, len(points))
+	for index, point := range points {
+		result[index] = map[string]interface{}{
+			"time":  point.Time.Unix(),
+			"value": point.Value,
+		}
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) getColdStorageSuggestionsHandler(_ *http.Request) (interface{}, error) {
+	result := []map[string]interface{}{}
+	for _, suggestion := range handlers.backend.ColdStorageSuggestions() {
+		coin := suggestion.SpendingAccount.Coin()
+		result = append(result, map[string]interface{}{
+			"spendingAccount": suggestion.Rule.SpendingAccount,
+			"savingsAccount":  suggestion.Rule.SavingsAccount,
+			"fiatUnit":        suggestion.Rule.FiatUnit,
+			"threshold":       suggestion.Rule.Threshold,
+			"fiatBalance":     strconv.FormatFloat(suggestion.FiatBalance, 'f', 2, 64),
+			"balance":         coin.FormatAmountAsJSON(int64(suggestion.Balance)),
+			"sweepAmount":     coin.FormatAmountAsJSON(int64(suggestion.SweepAmount)),
+		})
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) postColdStorageSweepProposalHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		SpendingAccount string `json:"spendingAccount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	sweep, err := handlers.backend.ColdStorageSweepProposal(input.SpendingAccount)
+	if err != nil {
+		return nil, err
+	}
+	coin := sweep.SpendingAccount.Coin()
+	return map[string]interface{}{
+		"success":          true,
+		"recipientAddress": sweep.RecipientAddress,
+		"amount":           coin.FormatAmountAsJSON(int64(sweep.Amount)),
+		"fee":              coin.FormatAmountAsJSON(int64(sweep.Fee)),
+		"total":            coin.FormatAmountAsJSON(int64(sweep.Total)),
+		"feeTarget":        sweep.FeeTargetCode,
+	}, nil
+}
+
+// psbtError returns the API error of a PSBT which can't be parsed or signed.
+func psbtError(err error) *apierror.Error {
+	if _, ok := errp.Cause(err).(btc.TxValidationError); ok {
+		return apierror.Wrap(apierror.CodeInvalidInput, err)
+	}
+	return apierror.FromError(err)
+}
+
+func (handlers *Handlers) postPSBTSummaryHandler(r *http.Request) (interface{}, error) {
+	var encoded string
+	if err := json.NewDecoder(r.Body).Decode(&encoded); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	summary, err := handlers.backend.PSBTSummary(encoded)
+	if err != nil {
+		return nil, psbtError(err)
+	}
+	coin := handlers.backend.Coin(summary.Coin)
+	inputs := []map[string]interface{}{}
+	for _, input := range summary.Inputs {
+		inputs = append(inputs, map[string]interface{}{
+			"value":   coin.FormatAmountAsJSON(int64(input.Value)),
+			"account": input.Account,
+		})
+	}
+	outputs := []map[string]interface{}{}
+	for _, output := range summary.Outputs {
+		outputs = append(outputs, map[string]interface{}{
+			"value":   coin.FormatAmountAsJSON(int64(output.Value)),
+			"address": output.Address,
+			"account": output.Account,
+		})
+	}
+	accountValues := map[string]interface{}{}
+	for accountCode, value := range summary.AccountValues {
+		accountValues[accountCode] = coin.FormatAmountAsJSON(int64(value))
+	}
+	result := map[string]interface{}{
+		"coin":          summary.Coin,
+		"inputs":        inputs,
+		"outputs":       outputs,
+		"accountValues": accountValues,
+		"fee":           nil,
+	}
+	if summary.Fee != nil {
+		result["fee"] = coin.FormatAmountAsJSON(int64(*summary.Fee))
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) postPSBTSignHandler(r *http.Request) (interface{}, error) {
+	var encoded string
+	if err := json.NewDecoder(r.Body).Decode(&encoded); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	signed, err := handlers.backend.SignPSBT(encoded)
+	if bitbox.IsErrorAbort(err) {
+		return apierror.Wrap(apierror.CodeAborted, err).Response(), nil
+	}
+	if err != nil {
+		return nil, psbtError(err)
+	}
+	return map[string]interface{}{"success": true, "psbt": signed}, nil
+}
+
+// metadataBackupError returns the API error of a failed metadata backup upload or restore.
+func metadataBackupError(err error) *apierror.Error {
+	if _, ok := errp.Cause(err).(*metadatabackup.TargetError); ok {
+		return apierror.Wrap(apierror.CodeBackupTargetFailed, err)
+	}
+	switch errp.Cause(err) {
+	case metadatabackup.ErrWrongPassphrase:
+		return apierror.Wrap(apierror.CodeWrongBackupPassphrase, err)
+	case metadatabackup.ErrWrongKeystore:
+		return apierror.Wrap(apierror.CodeWrongBackupKeystore, err)
+	}
+	return apierror.FromError(err)
+}
+
+func (handlers *Handlers) postMetadataBackupUploadHandler(r *http.Request) (interface{}, error) {
+	var passphrase string
+	if err := json.NewDecoder(r.Body).Decode(&passphrase); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.UploadMetadataBackup(passphrase); err != nil {
+		return nil, metadataBackupError(err)
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postMetadataBackupRestoreHandler(r *http.Request) (interface{}, error) {
+	var passphrase string
+	if err := json.NewDecoder(r.Body).Decode(&passphrase); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	result, err := handlers.backend.RestoreMetadataBackup(passphrase)
+	if err != nil {
+		return nil, metadataBackupError(err)
+	}
+	return map[string]interface{}{
+		"success":              true,
+		"created":              result.Created,
+		"restoredNotes":        result.RestoredNotes,
+		"restoredAddressNotes": result.RestoredAddressNotes,
+		"skippedAccounts":      result.SkippedAccounts,
+	}, nil
+}
+
 func (handlers *Handlers) getHeadersStatus(coinCode string) func(*http.Request) (interface{}, error) {
 	return func(_ *http.Request) (interface{}, error) {
 		return handlers.backend.Coin(coinCode).(*btc.Coin).Headers().Status()
 	}
 }
 
+// getTip returns the verified tip of the coin. If the query contains a block height ("height") or
+// a unix timestamp ("time"), the time at which the height is reached or the height reached at the
+// time is estimated.
+func (handlers *Handlers) getTip(coinCode string) func(*http.Request) (interface{}, error) {
+	return func(r *http.Request) (interface{}, error) {
+		theHeaders := handlers.backend.Coin(coinCode).(*btc.Coin).Headers()
+		tip, err := theHeaders.VerifiedTip()
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]interface{}{"tip": tip}
+		var estimate *headers.HeightEstimate
+		if height := r.URL.Query().Get("height"); height != "" {
+			targetHeight, err := strconv.Atoi(height)
+			if err != nil {
+				return nil, errp.WithStack(err)
+			}
+			estimate = tip.EstimateHeight(targetHeight, time.Now())
+		} else if unix := r.URL.Query().Get("time"); unix != "" {
+			seconds, err := strconv.ParseInt(unix, 10, 64)
+			if err != nil {
+				return nil, errp.WithStack(err)
+			}
+			estimate = tip.EstimateTime(time.Unix(seconds, 0), time.Now())
+		}
+		if estimate != nil {
+			if estimate.Reached {
+				header, err := theHeaders.HeaderByHeight(estimate.Height)
+				if err != nil {
+					return nil, err
+				}
+				if header != nil {
+					estimate.Time = header.Timestamp
+				}
+			}
+			result["estimate"] = estimate
+		}
+		return result, nil
+	}
+}
+
 func (handlers *Handlers) postCertsDownloadHandler(r *http.Request) (interface{}, error) {
 	var server string
 	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
@@ -340,10 +847,7 @@ func (handlers *Handlers) postCertsDownloadHandler(r *http.Request) (interface{}
 	}
 	pemCert, err := handlers.backend.DownloadCert(server)
 	if err != nil {
-		return map[string]interface{}{
-			"success":      false,
-			"errorMessage": err.Error(),
-		}, nil
+		return apierror.Wrap(apierror.CodeCertDownloadFailed, err).Response(), nil
 	}
 	return map[string]interface{}{
 		"success": true,
@@ -363,10 +867,7 @@ func (handlers *Handlers) postCertsCheckHandler(r *http.Request) (interface{}, e
 	if err := handlers.backend.CheckElectrumServer(
 		server.Server,
 		server.PEMCert); err != nil {
-		return map[string]interface{}{
-			"success":      false,
-			"errorMessage": err.Error(),
-		}, nil
+		return apierror.Wrap(apierror.CodeServerCheckFailed, err).Response(), nil
 	}
 	return map[string]interface{}{
 		"success": true,
@@ -379,22 +880,10 @@ func (handlers *Handlers) eventsHandler(w http.ResponseWriter, r *http.Request)
 		panic(err)
 	}
 
-	sendChan, quitChan := runWebsocket(conn, handlers.apiData, handlers.log)
-	go func() {
-		for {
-			select {
-			case <-quitChan:
-				return
-			default:
-				select {
-				case <-quitChan:
-					return
-				case event := <-handlers.backendEvents:
-					sendChan <- jsonp.MustMarshal(event)
-				}
-			}
-		}
-	}()
+	subscriber := newSubscriber()
+	sendChan, quitChan := runWebsocket(conn, handlers.apiData, handlers.log,
+		func(message []byte) { subscriber.handleMessage(message, handlers.log) })
+	handlers.events.subscribe(subscriber, sendChan, quitChan)
 }
 
 // isAPITokenValid checks whether we are in dev or prod mode and, if we are in prod mode, verifies
@@ -408,6 +897,21 @@ func isAPITokenValid(w http.ResponseWriter, r *http.Request, apiData *Connection
 	}
 	methodLogEntry.Debug("Checking API token")
 
+	if authorization := r.Header.Get("Authorization"); isSessionAuthorization(authorization) {
+		capability := requiredCapability(r)
+		exists, allowed := apiData.sessionAllows(strings.TrimPrefix(authorization, "Session "), capability)
+		switch {
+		case !exists:
+			methodLogEntry.Error("Incorrect session token in API request")
+			http.Error(w, "incorrect token", http.StatusUnauthorized)
+			return false
+		case !allowed:
+			methodLogEntry.WithField("capability", capability).Error("Session token lacks the capability")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
 	if len(r.Header.Get("Authorization")) == 0 {
 		methodLogEntry.Error("Missing token in API request. WARNING: this could be an attack on the API")
 		http.Error(w, "missing token "+r.URL.Path, http.StatusUnauthorized)
@@ -429,13 +933,37 @@ func ensureAPITokenValid(h http.Handler, apiData *ConnectionData, log *logrus.En
 	})
 }
 
+// errorResponse is the response written when a handler fails. "error" contains the message for
+// clients which don't know about the structured error fields. "requestID" identifies the request in
+// the log.
+func errorResponse(r *http.Request, err *apierror.Error) map[string]interface{} {
+	response := err.Response()
+	response["error"] = err.Message
+	response["requestID"] = requestid.FromRequest(r)
+	return response
+}
+
+// localize translates the message of an error response into the language of the user, so that
+// backend messages don't show up in English in a translated UI. The untranslated message stays in
+// "error" for clients which match on it.
+func (handlers *Handlers) localize(response map[string]interface{}) {
+	messageKey, ok := response["messageKey"].(string)
+	if !ok {
+		return
+	}
+	if message, ok := handlers.backend.Localizer().Translate(messageKey); ok {
+		response["errorMessage"] = message
+	}
+}
+
 func (handlers *Handlers) apiMiddleware(devMode bool, h func(*http.Request) (interface{}, error)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := requestid.Log(r, handlers.log)
 		defer func() {
 			// recover from all panics and log error before panicking again
-			if r := recover(); r != nil {
-				handlers.log.WithField("panic", true).Errorf("%v\n%s", r, string(debug.Stack()))
-				writeJSON(w, map[string]string{"error": fmt.Sprintf("%v", r)})
+			if recovered := recover(); recovered != nil {
+				log.WithField("panic", true).Errorf("%v\n%s", recovered, string(debug.Stack()))
+				writeJSON(w, errorResponse(r, apierror.New(apierror.CodeInternal, fmt.Sprintf("%v", recovered))))
 			}
 		}()
 
@@ -447,8 +975,22 @@ func (handlers *Handlers) apiMiddleware(devMode bool, h func(*http.Request) (int
 		}
 		value, err := h(r)
 		if err != nil {
-			handlers.log.WithError(err).Error("endpoint failed")
-			writeJSON(w, map[string]string{"error": err.Error()})
+			log.WithError(err).WithField("path", r.URL.Path).Error("endpoint failed")
+			response := errorResponse(r, apierror.FromError(err))
+			handlers.localize(response)
+			writeJSON(w, response)
+			return
+		}
+		if response, ok := value.(map[string]interface{}); ok {
+			handlers.localize(response)
+			if success, ok := response["success"].(bool); ok && !success {
+				response["requestID"] = requestid.FromRequest(r)
+			}
+		}
+		value, err = redactForToken(r, value)
+		if err != nil {
+			log.WithError(err).Error("redacting response failed")
+			writeJSON(w, errorResponse(r, apierror.FromError(err)))
 			return
 		}
 		writeJSON(w, value)
@@ -0,0 +1,65 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// rootFingerprintJSON is the root fingerprint of a keystore.
+type rootFingerprintJSON struct {
+	CosignerIndex int `json:"cosignerIndex"`
+	// Fingerprint is hex encoded, as expected by multisig coordinators.
+	Fingerprint string `json:"fingerprint"`
+	// CanVerify is true if the fingerprint can be shown on a secure output of the keystore.
+	CanVerify bool `json:"canVerify"`
+}
+
+func (handlers *Handlers) getRootFingerprintsHandler(_ *http.Request) (interface{}, error) {
+	keystores := handlers.backend.Keystores()
+	fingerprints, err := keystores.RootFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	result := []*rootFingerprintJSON{}
+	for index, fingerprint := range fingerprints {
+		result = append(result, &rootFingerprintJSON{
+			CosignerIndex: index,
+			Fingerprint:   hex.EncodeToString(fingerprint),
+			CanVerify:     keystores.CanOutputRootFingerprint(index),
+		})
+	}
+	return result, nil
+}
+
+// postVerifyRootFingerprintHandler shows the root fingerprint of a keystore on its secure output,
+// e.g. the paired mobile of a BitBox.
+func (handlers *Handlers) postVerifyRootFingerprintHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		CosignerIndex int `json:"cosignerIndex"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.Keystores().OutputRootFingerprint(jsonBody.CosignerIndex); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true}, nil
+}
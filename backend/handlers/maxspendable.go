@@ -0,0 +1,73 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// postMaxSpendableHandler computes the total amount which can be sent from the selected accounts
+// at the given fee rate, formatted in the unit of their coin.
+func (handlers *Handlers) postMaxSpendableHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		AccountCodes []string `json:"accountCodes"`
+		// FeeRatePerKb is in the unit of the coin per kB, like the fee rates of the fee targets.
+		FeeRatePerKb string `json:"feeRatePerKb"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	feeRate, err := strconv.ParseFloat(jsonBody.FeeRatePerKb, 64)
+	if err != nil {
+		return apierror.New(apierror.CodeInvalidInput, "invalid fee rate").Response(), nil
+	}
+	feeRatePerKb, err := btcutil.NewAmount(feeRate)
+	if err != nil || feeRatePerKb <= 0 {
+		return apierror.New(apierror.CodeInvalidInput, "invalid fee rate").Response(), nil
+	}
+	maxSpendable, err := handlers.backend.MaxSpendable(jsonBody.AccountCodes, feeRatePerKb)
+	if err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	coin := maxSpendable.Coin
+	formatAmount := func(amount btcutil.Amount) interface{} {
+		return coin.FormatAmountAsJSON(int64(amount))
+	}
+	accounts := make([]map[string]interface{}, len(maxSpendable.Accounts))
+	for index, account := range maxSpendable.Accounts {
+		accounts[index] = map[string]interface{}{
+			"accountCode": account.AccountCode,
+			"amount":      formatAmount(account.Amount),
+			"fee":         formatAmount(account.Fee),
+			"inputs":      account.Inputs,
+			"uneconomic":  formatAmount(account.Uneconomic),
+		}
+	}
+	return map[string]interface{}{
+		"success":    true,
+		"coinCode":   coin.Name(),
+		"accounts":   accounts,
+		"amount":     formatAmount(maxSpendable.Amount),
+		"fee":        formatAmount(maxSpendable.Fee),
+		"uneconomic": formatAmount(maxSpendable.Uneconomic),
+	}, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func (handlers *Handlers) getMigrationAccountsHandler(_ *http.Request) (interface{}, error) {
+	deviceID, ok := handlers.backend.MigrationSource()
+	if !ok {
+		return map[string]interface{}{"available": false}, nil
+	}
+	accounts, err := handlers.backend.MigrationAccounts()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for _, migrationAccount := range accounts {
+		coin := migrationAccount.Account.Coin()
+		result = append(result, map[string]interface{}{
+			"code":     migrationAccount.Account.Code(),
+			"coinCode": coin.Name(),
+			"balance":  coin.FormatAmountAsJSON(int64(migrationAccount.Balance)),
+			"incoming": coin.FormatAmountAsJSON(int64(migrationAccount.Incoming)),
+		})
+	}
+	return map[string]interface{}{
+		"available": true,
+		"deviceID":  deviceID,
+		"accounts":  result,
+	}, nil
+}
+
+// decodeMigrationDestinations decodes the request body, which maps the account codes of the
+// BitBox01 to the receive addresses of the new wallet.
+func decodeMigrationDestinations(r *http.Request) (map[string]string, error) {
+	var destinations map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&destinations); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return destinations, nil
+}
+
+func (handlers *Handlers) postMigrationPlanHandler(r *http.Request) (interface{}, error) {
+	destinations, err := decodeMigrationDestinations(r)
+	if err != nil {
+		return nil, err
+	}
+	sweeps, err := handlers.backend.MigrationPlan(destinations)
+	if err != nil {
+		return sendBatchError(err).Response(), nil
+	}
+	result := make([]map[string]interface{}, len(sweeps))
+	for index, sweep := range sweeps {
+		coin := sweep.Account.Coin()
+		result[index] = map[string]interface{}{
+			"accountCode":      sweep.Account.Code(),
+			"recipientAddress": sweep.RecipientAddress,
+			"amount":           coin.FormatAmountAsJSON(int64(sweep.Amount)),
+			"fee":              coin.FormatAmountAsJSON(int64(sweep.Fee)),
+			"feeTarget":        sweep.FeeTargetCode,
+		}
+	}
+	return map[string]interface{}{"success": true, "sweeps": result}, nil
+}
+
+// parseMigrationAmount parses an amount formatted as in the response of the plan, e.g. "0.0001".
+func parseMigrationAmount(formatted string) (btcutil.Amount, error) {
+	amount, err := strconv.ParseFloat(formatted, 64)
+	if err != nil {
+		return 0, errp.WithStack(btc.TxValidationError("invalid amount"))
+	}
+	btcAmount, err := btcutil.NewAmount(amount)
+	if err != nil {
+		return 0, errp.WithStack(btc.TxValidationError("invalid amount"))
+	}
+	return btcAmount, nil
+}
+
+// decodeConfirmedSweeps decodes the request body, which contains the sweeps of the plan confirmed
+// by the user.
+func (handlers *Handlers) decodeConfirmedSweeps(r *http.Request) ([]*backend.ConfirmedSweep, error) {
+	var jsonBody []struct {
+		AccountCode      string `json:"accountCode"`
+		RecipientAddress string `json:"recipientAddress"`
+		FeeTarget        string `json:"feeTarget"`
+		Amount           string `json:"amount"`
+		Fee              string `json:"fee"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	sweeps := make([]*backend.ConfirmedSweep, len(jsonBody))
+	for index, sweep := range jsonBody {
+		feeTargetCode, err := btc.NewFeeTargetCode(sweep.FeeTarget, handlers.log)
+		if err != nil {
+			return nil, errp.WithStack(btc.TxValidationError("invalid fee target"))
+		}
+		amount, err := parseMigrationAmount(sweep.Amount)
+		if err != nil {
+			return nil, err
+		}
+		fee, err := parseMigrationAmount(sweep.Fee)
+		if err != nil {
+			return nil, err
+		}
+		sweeps[index] = &backend.ConfirmedSweep{
+			AccountCode:      sweep.AccountCode,
+			RecipientAddress: sweep.RecipientAddress,
+			FeeTargetCode:    feeTargetCode,
+			Amount:           amount,
+			Fee:              fee,
+		}
+	}
+	return sweeps, nil
+}
+
+// postMigrateHandler executes the migration plan confirmed by the user, given as the list of
+// sweeps returned by postMigrationPlanHandler, with the amounts and fees in the unit of the coin.
+func (handlers *Handlers) postMigrateHandler(r *http.Request) (interface{}, error) {
+	sweeps, err := handlers.decodeConfirmedSweeps(r)
+	if err != nil {
+		return sendBatchError(err).Response(), nil
+	}
+	if len(sweeps) == 0 {
+		return apierror.New(apierror.CodeInvalidInput, "no accounts to migrate").Response(), nil
+	}
+	results, err := handlers.backend.Migrate(sweeps)
+	if err != nil {
+		return sendBatchError(err).Response(), nil
+	}
+	return map[string]interface{}{"success": true, "results": results}, nil
+}
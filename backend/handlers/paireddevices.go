@@ -0,0 +1,63 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func (handlers *Handlers) getPairedDevicesHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.PairedDevices(), nil
+}
+
+// postUpdatePairedDeviceHandler sets the nickname of a device in the pairing registry and whether
+// it is trusted.
+func (handlers *Handlers) postUpdatePairedDeviceHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Fingerprint string `json:"fingerprint"`
+		Nickname    string `json:"nickname"`
+		Trusted     bool   `json:"trusted"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := backend.ValidateDeviceNickname(jsonBody.Nickname); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	if err := handlers.backend.UpdatePairedDevice(
+		jsonBody.Fingerprint, jsonBody.Nickname, jsonBody.Trusted); err != nil {
+		return nil, err
+	}
+	return handlers.backend.PairedDevices(), nil
+}
+
+// postForgetPairedDeviceHandler removes a device from the pairing registry.
+func (handlers *Handlers) postForgetPairedDeviceHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.ForgetPairedDevice(jsonBody.Fingerprint); err != nil {
+		return nil, err
+	}
+	return handlers.backend.PairedDevices(), nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// postPaymentCodeAccountHandler sets the account receiving to the payment code of a coin. An empty
+// account code disables receiving to the payment code.
+func (handlers *Handlers) postPaymentCodeAccountHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		CoinCode    string `json:"coinCode"`
+		AccountCode string `json:"accountCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.SetPaymentCodeAccount(jsonBody.CoinCode, jsonBody.AccountCode); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, err).Response(), nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
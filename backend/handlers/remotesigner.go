@@ -0,0 +1,128 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/remotesigner"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func (handlers *Handlers) getRemoteSignerHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.RemoteSignerStatus()
+}
+
+// postRemoteSignerModeHandler enables or disables the signer mode.
+func (handlers *Handlers) postRemoteSignerModeHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.SetRemoteSignerMode(jsonBody.Enabled); err != nil {
+		return nil, err
+	}
+	handlers.publishConfigChanged()
+	return handlers.backend.RemoteSignerStatus()
+}
+
+// postRemoteSignerApproveHandler approves or rejects the app waiting to be paired. The pairing
+// code has to match the pending pairing.
+func (handlers *Handlers) postRemoteSignerApproveHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Code     string `json:"code"`
+		Approved bool   `json:"approved"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.ApproveRemoteSignerPairing(jsonBody.Code, jsonBody.Approved); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// postRemoteSignerPSBTApproveHandler approves or rejects signing the PSBT waiting for review. The
+// ID of the reviewed PSBT has to match the pending one.
+func (handlers *Handlers) postRemoteSignerPSBTApproveHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		ID       string `json:"id"`
+		Approved bool   `json:"approved"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.ApproveRemoteSignerPSBT(jsonBody.ID, jsonBody.Approved); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// postRemoteSignerPairHandler pairs with the app in signer mode at the given host:port. It returns
+// when the pairing is approved or rejected on the signer.
+func (handlers *Handlers) postRemoteSignerPairHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if _, _, err := net.SplitHostPort(jsonBody.Address); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	peer, err := handlers.backend.PairRemoteSigner(jsonBody.Address)
+	if err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// postRemoteSignerConnectHandler uses the keystore of the paired signer with the given public key.
+func (handlers *Handlers) postRemoteSignerConnectHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.ConnectRemoteSigner(jsonBody.PublicKey); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (handlers *Handlers) postRemoteSignerDisconnectHandler(_ *http.Request) (interface{}, error) {
+	return nil, handlers.backend.DisconnectRemoteSigner()
+}
+
+// postRemoteSignerPeersRemoveHandler unpairs an app.
+func (handlers *Handlers) postRemoteSignerPeersRemoveHandler(r *http.Request) (interface{}, error) {
+	var jsonBody struct {
+		PublicKey string            `json:"publicKey"`
+		Role      remotesigner.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return apierror.Wrap(apierror.CodeInvalidInput, errp.WithStack(err)).Response(), nil
+	}
+	if err := handlers.backend.RemoveRemoteSignerPeer(jsonBody.PublicKey, jsonBody.Role); err != nil {
+		return nil, err
+	}
+	return handlers.backend.RemoteSignerStatus()
+}
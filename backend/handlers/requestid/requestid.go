@@ -0,0 +1,66 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestid tags API requests with an ID, which is logged by the handlers and returned in
+// error responses, so a failure reported by a user can be found in the log.
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
+)
+
+// Header is the HTTP header carrying the request ID. A client can set it to correlate its own logs,
+// otherwise a random ID is generated. The ID is always returned in the response header.
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+// validRegexp restricts IDs provided by clients, so they can't inject anything into the log.
+var validRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Middleware tags each request with an ID.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(Header)
+		if !validRegexp.MatchString(requestID) {
+			var err error
+			requestID, err = random.HexString(8)
+			if err != nil {
+				panic(err)
+			}
+		}
+		w.Header().Set(Header, requestID)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, requestID)))
+	})
+}
+
+// FromRequest returns the ID of the request, or an empty string if it was not tagged.
+func FromRequest(r *http.Request) string {
+	requestID, _ := r.Context().Value(contextKey{}).(string)
+	return requestID
+}
+
+// Log returns the log entry with the ID of the request added as a field.
+func Log(r *http.Request, log *logrus.Entry) *logrus.Entry {
+	if requestID := FromRequest(r); requestID != "" {
+		return log.WithField("request-id", requestID)
+	}
+	return log
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers/requestid"
+)
+
+func TestMiddleware(t *testing.T) {
+	var requestID string
+	handler := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = requestid.FromRequest(r)
+	}))
+
+	// Generated.
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/version", nil))
+	require.Len(t, requestID, 16)
+	require.Equal(t, requestID, recorder.Header().Get(requestid.Header))
+	previous := requestID
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/version", nil))
+	require.NotEqual(t, previous, requestID)
+
+	// Provided by the client.
+	request := httptest.NewRequest("GET", "/api/version", nil)
+	request.Header.Set(requestid.Header, "frontend-42")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	require.Equal(t, "frontend-42", requestID)
+	require.Equal(t, "frontend-42", recorder.Header().Get(requestid.Header))
+
+	// Invalid IDs are replaced.
+	request = httptest.NewRequest("GET", "/api/version", nil)
+	request.Header.Set(requestid.Header, "bad id\nwith newline")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	require.Len(t, requestID, 16)
+
+	require.Empty(t, requestid.FromRequest(httptest.NewRequest("GET", "/api/version", nil)))
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/apierror"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	accountHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// sendBatchError returns the API error of a batch which could not be prepared or signed.
+func sendBatchError(err error) *apierror.Error {
+	if bitbox.IsErrorAbort(err) {
+		return apierror.Wrap(apierror.CodeAborted, err)
+	}
+	switch errp.Cause(err) {
+	case maketx.ErrInsufficientFunds:
+		return apierror.Wrap(apierror.CodeInsufficientFunds, err)
+	case maketx.ErrFeeCapExceeded:
+		return apierror.Wrap(apierror.CodeFeeCapExceeded, err)
+	}
+	switch errp.Cause(err).(type) {
+	case maketx.PolicyViolationError:
+		return apierror.Wrap(apierror.CodePolicyViolation, err)
+	case btc.TxValidationError:
+		return apierror.Wrap(apierror.CodeInvalidInput, err)
+	case *btc.DenylistedAddressError:
+		return apierror.Wrap(apierror.CodeAddressDenylisted, err)
+	}
+	return apierror.FromError(err)
+}
+
+// postSendBatchHandler signs and sends multiple transactions in one keystore session. Each
+// transaction is given in the format of the /sendtx endpoint of its account.
+func (handlers *Handlers) postSendBatchHandler(r *http.Request) (interface{}, error) {
+	var jsonBody []struct {
+		AccountCode string          `json:"accountCode"`
+		Transaction json.RawMessage `json:"transaction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if len(jsonBody) == 0 {
+		return apierror.New(apierror.CodeInvalidInput, "no transactions").Response(), nil
+	}
+	accounts := map[string]*btc.Account{}
+	for _, account := range handlers.backend.Accounts() {
+		accounts[account.Code()] = account
+	}
+	preparedTxs := make([]*btc.PreparedTx, len(jsonBody))
+	for index, request := range jsonBody {
+		account, ok := accounts[request.AccountCode]
+		if !ok {
+			return apierror.New(apierror.CodeInvalidInput, "unknown account "+request.AccountCode).Response(), nil
+		}
+		preparedTx, err := accountHandlers.PrepareTx(account, request.Transaction, handlers.log)
+		if err != nil {
+			response := sendBatchError(err).Response()
+			response["index"] = index
+			return response, nil
+		}
+		preparedTxs[index] = preparedTx
+	}
+	results, err := handlers.backend.SendTxBatch(preparedTxs)
+	if err != nil {
+		return sendBatchError(err).Response(), nil
+	}
+	return map[string]interface{}{"success": true, "results": results}, nil
+}
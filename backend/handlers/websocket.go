@@ -26,10 +26,18 @@ import (
 // It returns two channels: one to send messages to the client, and one which notifies
 // when the connection was closed.
 //
+// The first message of the client must be the authorization token. The following messages are
+// passed to onMessage, if it is not nil.
+//
 // Closing msg makes runWebsocket's goroutines quit.
 // The goroutines close conn upon exit, due to a send/receive error or when msg is closed.
 // runWebsocket never closes msg.
-func runWebsocket(conn *websocket.Conn, apiData *ConnectionData, log *logrus.Entry) (msg chan<- []byte, quit <-chan struct{}) {
+func runWebsocket(
+	conn *websocket.Conn,
+	apiData *ConnectionData,
+	log *logrus.Entry,
+	onMessage func([]byte),
+) (msg chan<- []byte, quit <-chan struct{}) {
 	// Time allowed to read the next pong message from the peer.
 	const pongWait = 60 * time.Second
 	// Send pings to peer with this period. Must be less than pongWait.
@@ -54,6 +62,7 @@ func runWebsocket(conn *websocket.Conn, apiData *ConnectionData, log *logrus.Ent
 			_ = conn.SetReadDeadline(time.Now().Add(pongWait))
 			return nil
 		})
+		authorized := false
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
@@ -62,12 +71,21 @@ func runWebsocket(conn *websocket.Conn, apiData *ConnectionData, log *logrus.Ent
 				}
 				break
 			}
-			if string(msg) != "Authorization: Basic "+apiData.token {
+			if string(msg) == "Authorization: Basic "+apiData.token || apiData.websocketSessionAllowed(string(msg)) {
+				if !authorized {
+					authorized = true
+					authorizedChan <- struct{}{}
+				}
+				continue
+			}
+			if !authorized {
 				log.Error("Expected authorization token as first message. Closing websocket.")
 				_ = conn.Close()
 				return
 			}
-			authorizedChan <- struct{}{}
+			if onMessage != nil {
+				onMessage(msg)
+			}
 		}
 	}
 
@@ -90,7 +90,7 @@ func TestRunWebsocket(t *testing.T) {
 	}()
 
 	cdata := &ConnectionData{token: "auth-token"}
-	send, quit := runWebsocket(server, cdata, logrus.NewEntry(logrus.StandardLogger()))
+	send, quit := runWebsocket(server, cdata, logrus.NewEntry(logrus.StandardLogger()), nil)
 
 	// Send a message to the queue but do not expect to receive it just yet
 	// because the client hasn't been authorized.
@@ -155,7 +155,7 @@ func TestRunWebsocketNoAuthz(t *testing.T) {
 	defer cleanup()
 
 	cdata := &ConnectionData{token: "auth-token"}
-	_, quit := runWebsocket(server, cdata, logrus.NewEntry(logrus.StandardLogger()))
+	_, quit := runWebsocket(server, cdata, logrus.NewEntry(logrus.StandardLogger()), nil)
 	if err := client.WriteMessage(websocket.TextMessage, []byte("no authz")); err != nil {
 		t.Fatalf("client.WriteMessage: %v", err)
 	}
@@ -181,7 +181,7 @@ func TestRunWebsocketCloseSend(t *testing.T) {
 	defer cleanup()
 
 	cdata := &ConnectionData{token: "auth-token"}
-	send, quit := runWebsocket(server, cdata, logrus.NewEntry(logrus.StandardLogger()))
+	send, quit := runWebsocket(server, cdata, logrus.NewEntry(logrus.StandardLogger()), nil)
 
 	close(send)
 	select {
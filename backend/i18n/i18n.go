@@ -0,0 +1,67 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package i18n translates messages which originate in the backend, like device and API errors,
+// into the language of the user interface.
+package i18n
+
+import (
+	"golang.org/x/text/language"
+)
+
+// Languages returns the languages the backend has translations for. The first one is the default.
+func Languages() []language.Tag {
+	return []language.Tag{
+		language.English,
+		language.German,
+	}
+}
+
+// Match returns the supported language which matches the given BCP 47 tag best, e.g. "de-CH"
+// matches German. English is returned if nothing matches.
+func Match(tag string) language.Tag {
+	matched, _, _ := language.NewMatcher(Languages()).Match(language.Make(tag))
+	base, _ := matched.Base()
+	for _, supported := range Languages() {
+		if supportedBase, _ := supported.Base(); supportedBase == base {
+			return supported
+		}
+	}
+	return language.English
+}
+
+// Localizer translates messages into one language.
+type Localizer struct {
+	language language.Tag
+}
+
+// NewLocalizer creates a localizer for the given language, which must be one of Languages().
+func NewLocalizer(language language.Tag) *Localizer {
+	return &Localizer{language: language}
+}
+
+// Language returns the language of the localizer.
+func (localizer *Localizer) Language() language.Tag {
+	return localizer.language
+}
+
+// Translate returns the message with the given key. If there is no translation in the language of
+// the localizer, the English message is returned. false is returned if the key is unknown.
+func (localizer *Localizer) Translate(key string) (string, bool) {
+	if message, ok := translations[localizer.language][key]; ok {
+		return message, true
+	}
+	message, ok := translations[language.English][key]
+	return message, ok
+}
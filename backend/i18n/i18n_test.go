@@ -0,0 +1,49 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package i18n_test
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/i18n"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestMatch(t *testing.T) {
+	require.Equal(t, language.German, i18n.Match("de"))
+	require.Equal(t, language.German, i18n.Match("de-CH"))
+	require.Equal(t, language.English, i18n.Match("en-US"))
+	require.Equal(t, language.English, i18n.Match("fr"))
+	require.Equal(t, language.English, i18n.Match(""))
+}
+
+func TestTranslate(t *testing.T) {
+	message, ok := i18n.NewLocalizer(language.German).Translate("error.invalidAmount")
+	require.True(t, ok)
+	require.Equal(t, "Ungültiger Betrag", message)
+
+	message, ok = i18n.NewLocalizer(language.English).Translate("error.invalidAmount")
+	require.True(t, ok)
+	require.Equal(t, "Invalid amount", message)
+
+	// Password policy violations are not reported as a wrong password.
+	message, ok = i18n.NewLocalizer(language.English).Translate("error.passwordPolicy")
+	require.True(t, ok)
+	require.Equal(t, "The password does not meet the requirements", message)
+
+	_, ok = i18n.NewLocalizer(language.German).Translate("error.device")
+	require.False(t, ok)
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package i18n
+
+import (
+	"golang.org/x/text/language"
+)
+
+// translations contains the messages by language and key. Keys of API errors are
+// "error.<code>", see the apierror package. Codes without a meaningful generic message, like
+// "error.device", are not translated, so that the original message is shown.
+var translations = map[language.Tag]map[string]string{
+	language.English: {
+		"error.invalidAddress":         "Invalid address",
+		"error.invalidAmount":          "Invalid amount",
+		"error.insufficientFunds":      "Insufficient funds",
+		"error.feeBumpNotPossible":     "The fee of this transaction can't be increased",
+		"error.feeCapExceeded":         "The fee is unusually high. Please confirm that you want to pay it",
+		"error.policyViolation":        "The transaction was rejected by a safety check and was not signed",
+		"error.usbPermissionDenied":    "The app is not allowed to access the USB device",
+		"error.udevRulesInstallFailed": "The udev rules could not be installed",
+		"error.wrongPassword":          "Wrong password",
+		"error.passwordPolicy":         "The password does not meet the requirements",
+		"error.aborted":                "Aborted on the device",
+		"error.sdCard":                 "Please insert the micro SD card",
+		"error.deviceBusy":             "The device is starting up, please try again",
+		"error.firmwareDowngrade":      "The firmware is older than the one on your BitBox and can't be installed",
+		"error.certDownloadFailed":     "The certificate of the server could not be downloaded",
+		"error.serverCheckFailed":      "Could not connect to the server",
+		"error.wrongBackupPassphrase":  "Wrong backup passphrase",
+		"error.wrongBackupKeystore":    "The backup belongs to a different wallet",
+		"error.backupTargetFailed":     "Could not connect to the backup storage",
+	},
+	language.German: {
+		"error.invalidAddress":         "Ungültige Adresse",
+		"error.invalidAmount":          "Ungültiger Betrag",
+		"error.insufficientFunds":      "Ungenügendes Guthaben",
+		"error.feeBumpNotPossible":     "Die Gebühr dieser Transaktion kann nicht erhöht werden",
+		"error.feeCapExceeded":         "Die Gebühr ist ungewöhnlich hoch. Bitte bestätigen, dass sie bezahlt werden soll",
+		"error.policyViolation":        "Die Transaktion wurde von einer Sicherheitsprüfung abgelehnt und nicht signiert",
+		"error.usbPermissionDenied":    "Die App hat keinen Zugriff auf das USB-Gerät",
+		"error.udevRulesInstallFailed": "Die udev-Regeln konnten nicht installiert werden",
+		"error.wrongPassword":          "Falsches Passwort",
+		"error.passwordPolicy":         "Das Passwort erfüllt die Anforderungen nicht",
+		"error.aborted":                "Auf dem Gerät abgebrochen",
+		"error.sdCard":                 "Bitte die microSD-Karte einstecken",
+		"error.deviceBusy":             "Das Gerät startet, bitte erneut versuchen",
+		"error.firmwareDowngrade":      "Die Firmware ist älter als die auf der BitBox und kann nicht installiert werden",
+		"error.certDownloadFailed":     "Das Zertifikat des Servers konnte nicht heruntergeladen werden",
+		"error.serverCheckFailed":      "Verbindung zum Server fehlgeschlagen",
+		"error.wrongBackupPassphrase":  "Falsche Backup-Passphrase",
+		"error.wrongBackupKeystore":    "Das Backup gehört zu einer anderen Wallet",
+		"error.backupTargetFailed":     "Verbindung zum Backup-Speicher fehlgeschlagen",
+	},
+}
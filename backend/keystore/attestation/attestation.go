@@ -0,0 +1,120 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package attestation signs records stored by the app, e.g. address bookmarks, with the keystores,
+// so that records which were tampered with on disk can be detected.
+package attestation
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Attestation are the signatures of a record by the keystores. It is embedded in the records, so
+// that its fields are stored with them.
+type Attestation struct {
+	// PublicKeys are the hex encoded compressed public keys at the keypath of the record, ordered by
+	// cosigner index.
+	PublicKeys []string `json:"publicKeys"`
+	// Signatures are the hex encoded DER signatures of the hash of the record, ordered by cosigner
+	// index.
+	Signatures []string `json:"signatures"`
+}
+
+func publicKeys(keystores keystore.Keystores, keypath string) ([]*btcec.PublicKey, error) {
+	absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+	if err != nil {
+		return nil, err
+	}
+	extendedPublicKeys, err := keystores.ExtendedPublicKeys(absoluteKeypath)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*btcec.PublicKey, len(extendedPublicKeys))
+	for index, extendedPublicKey := range extendedPublicKeys {
+		result[index], err = extendedPublicKey.ECPubKey()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+	}
+	return result, nil
+}
+
+// Sign signs the hash with the keys of the keystores at the given keypath. Hardware keystores ask
+// the user to confirm on the device, but do not display what is signed.
+func Sign(keystores keystore.Keystores, keypath string, hash []byte) (*Attestation, error) {
+	if keystores.Count() == 0 {
+		return nil, errp.New("no keystore to sign with")
+	}
+	publicKeys, err := publicKeys(keystores, keypath)
+	if err != nil {
+		return nil, err
+	}
+	absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := keystores.SignHash(absoluteKeypath, hash)
+	if err != nil {
+		return nil, err
+	}
+	attestation := &Attestation{PublicKeys: []string{}, Signatures: []string{}}
+	for index, signature := range signatures {
+		// Make sure the keystore signed with the expected key.
+		if !signature.Verify(hash, publicKeys[index]) {
+			return nil, errp.New("the keystore returned an invalid signature")
+		}
+		attestation.PublicKeys = append(attestation.PublicKeys,
+			hex.EncodeToString(publicKeys[index].SerializeCompressed()))
+		attestation.Signatures = append(attestation.Signatures,
+			hex.EncodeToString(signature.Serialize()))
+	}
+	return attestation, nil
+}
+
+// Verify returns whether the signatures of the hash were made by the keys of the keystores at the
+// given keypath. At least one keystore has to be connected.
+func (attestation *Attestation) Verify(
+	keystores keystore.Keystores, keypath string, hash []byte) (bool, error) {
+	if keystores.Count() == 0 {
+		return false, errp.New("no keystore to verify with")
+	}
+	publicKeys, err := publicKeys(keystores, keypath)
+	if err != nil {
+		return false, err
+	}
+	if len(publicKeys) != len(attestation.PublicKeys) ||
+		len(publicKeys) != len(attestation.Signatures) {
+		return false, nil
+	}
+	for index, publicKey := range publicKeys {
+		if hex.EncodeToString(publicKey.SerializeCompressed()) != attestation.PublicKeys[index] {
+			return false, nil
+		}
+		serializedSignature, err := hex.DecodeString(attestation.Signatures[index])
+		if err != nil {
+			return false, nil
+		}
+		signature, err := btcec.ParseDERSignature(serializedSignature, btcec.S256())
+		if err != nil || !signature.Verify(hash, publicKey) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
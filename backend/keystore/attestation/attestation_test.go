@@ -0,0 +1,58 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attestation_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/attestation"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+)
+
+const keypath = "m/20180'/1'"
+
+func TestSignVerify(t *testing.T) {
+	keystores := keystore.NewKeystores(software.NewKeystoreFromPIN(0, "1234"))
+	hash := chainhash.DoubleHashB([]byte("record"))
+	signed, err := attestation.Sign(keystores, keypath, hash)
+	require.NoError(t, err)
+	require.Len(t, signed.PublicKeys, 1)
+	require.Len(t, signed.Signatures, 1)
+
+	valid, err := signed.Verify(keystores, keypath, hash)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	// Another record, keypath or wallet.
+	valid, err = signed.Verify(keystores, keypath, chainhash.DoubleHashB([]byte("other")))
+	require.NoError(t, err)
+	require.False(t, valid)
+	valid, err = signed.Verify(keystores, "m/20180'/2'", hash)
+	require.NoError(t, err)
+	require.False(t, valid)
+	valid, err = signed.Verify(
+		keystore.NewKeystores(software.NewKeystoreFromPIN(0, "4321")), keypath, hash)
+	require.NoError(t, err)
+	require.False(t, valid)
+
+	_, err = signed.Verify(keystore.NewKeystores(), keypath, hash)
+	require.Error(t, err)
+	_, err = attestation.Sign(keystore.NewKeystores(), keypath, hash)
+	require.Error(t, err)
+}
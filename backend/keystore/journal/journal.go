@@ -0,0 +1,149 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal persists the progress of multi-step keystore operations, like signing a
+// transaction in several batches. If the device disconnects in the middle of an operation, the
+// operation can be resumed from the last completed step instead of starting from scratch.
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+// operation holds the results of the completed steps of one operation.
+type operation struct {
+	Started time.Time                  `json:"started"`
+	Steps   map[string]json.RawMessage `json:"steps"`
+}
+
+// Journal records the completed steps of operations in a file. Operations are identified by an ID
+// chosen by the caller, which must change if the inputs of the operation change. Operations which
+// are older than maxAge are discarded, so that stale results are not reused.
+type Journal struct {
+	filename   string
+	maxAge     time.Duration
+	operations map[string]*operation
+	lock       locker.Locker
+	log        *logrus.Entry
+}
+
+// NewJournal creates a journal persisted in the given file. The journal only contains progress
+// which can be recreated by repeating the operation, so an unreadable file is discarded.
+func NewJournal(filename string, maxAge time.Duration, log *logrus.Entry) *Journal {
+	journal := &Journal{
+		filename:   filename,
+		maxAge:     maxAge,
+		operations: map[string]*operation{},
+		log:        log.WithField("group", "journal"),
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return journal
+	}
+	if err == nil {
+		err = json.Unmarshal(jsonBytes, &journal.operations)
+	}
+	if err != nil {
+		journal.log.WithError(err).Error("Discarding the unreadable journal")
+		journal.operations = map[string]*operation{}
+	}
+	journal.expire()
+	return journal
+}
+
+// expire removes the operations which are older than maxAge. Must be called with the lock held or
+// during initialization.
+func (journal *Journal) expire() {
+	for id, operation := range journal.operations {
+		if time.Since(operation.Started) > journal.maxAge {
+			journal.log.WithField("operation", id).Info("Rolling back an expired operation")
+			delete(journal.operations, id)
+		}
+	}
+}
+
+func (journal *Journal) save() error {
+	if len(journal.operations) == 0 {
+		if err := os.Remove(journal.filename); err != nil && !os.IsNotExist(err) {
+			return errp.WithStack(err)
+		}
+		return nil
+	}
+	jsonBytes, err := json.Marshal(journal.operations)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(journal.filename, jsonBytes, 0600))
+}
+
+// Step loads the result of a completed step of the given operation into result. It returns false
+// if the step has not been completed yet.
+func (journal *Journal) Step(operationID, step string, result interface{}) (bool, error) {
+	defer journal.lock.Lock()()
+	journal.expire()
+	operation, ok := journal.operations[operationID]
+	if !ok {
+		return false, nil
+	}
+	stepResult, ok := operation.Steps[step]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(stepResult, result); err != nil {
+		return false, errp.WithStack(err)
+	}
+	return true, nil
+}
+
+// Record stores the result of a completed step of the given operation. The operation is started
+// if it is not in the journal yet.
+func (journal *Journal) Record(operationID, step string, result interface{}) error {
+	defer journal.lock.Lock()()
+	stepResult, err := json.Marshal(result)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	op, ok := journal.operations[operationID]
+	if !ok {
+		op = &operation{Started: time.Now(), Steps: map[string]json.RawMessage{}}
+		journal.operations[operationID] = op
+	}
+	op.Steps[step] = stepResult
+	return journal.save()
+}
+
+// Finish removes the operation from the journal. It is called when the operation completed, or
+// to roll it back when it was aborted and should not be resumed.
+func (journal *Journal) Finish(operationID string) error {
+	defer journal.lock.Lock()()
+	if _, ok := journal.operations[operationID]; !ok {
+		return nil
+	}
+	delete(journal.operations, operationID)
+	return journal.save()
+}
+
+// Pending returns the number of operations which were interrupted and can be resumed.
+func (journal *Journal) Pending() int {
+	defer journal.lock.Lock()()
+	journal.expire()
+	return len(journal.operations)
+}
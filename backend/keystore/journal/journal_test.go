@@ -0,0 +1,87 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/journal"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	dir := test.TstTempDir("journal_test")
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "journal.json")
+	log := logging.Get().WithGroup("journal_test")
+
+	j := journal.NewJournal(filename, time.Hour, log)
+	var result []string
+	done, err := j.Step("op", "step-0", &result)
+	require.NoError(t, err)
+	require.False(t, done)
+
+	require.NoError(t, j.Record("op", "step-0", []string{"a", "b"}))
+	require.Equal(t, 1, j.Pending())
+
+	// The progress survives a restart.
+	j = journal.NewJournal(filename, time.Hour, log)
+	done, err = j.Step("op", "step-0", &result)
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Equal(t, []string{"a", "b"}, result)
+	done, err = j.Step("op", "step-1", &result)
+	require.NoError(t, err)
+	require.False(t, done)
+
+	require.NoError(t, j.Finish("op"))
+	require.Equal(t, 0, j.Pending())
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestJournalExpiry(t *testing.T) {
+	dir := test.TstTempDir("journal_test")
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "journal.json")
+	log := logging.Get().WithGroup("journal_test")
+
+	j := journal.NewJournal(filename, time.Hour, log)
+	require.NoError(t, j.Record("op", "step-0", true))
+
+	j = journal.NewJournal(filename, 0, log)
+	require.Equal(t, 0, j.Pending())
+	var result bool
+	done, err := j.Step("op", "step-0", &result)
+	require.NoError(t, err)
+	require.False(t, done)
+}
+
+func TestJournalCorrupt(t *testing.T) {
+	dir := test.TstTempDir("journal_test")
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "journal.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("{"), 0600))
+
+	j := journal.NewJournal(filename, time.Hour, logging.Get().WithGroup("journal_test"))
+	require.Equal(t, 0, j.Pending())
+	require.NoError(t, j.Record("op", "step-0", true))
+}
@@ -15,12 +15,15 @@
 package keystore
 
 import (
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 )
 
 // Keystore supports hardened key derivation according to BIP32 and signing of transactions.
+// Devices integrated with a device.Driver provide their keys through it. Optional capabilities are
+// declared by implementing the interfaces below, e.g. MessageSigner.
 //go:generate mockery -name Keystore
 type Keystore interface {
 	// // Configuration returns the configuration of the keystore.
@@ -45,8 +48,51 @@ type Keystore interface {
 	// ExtendedPublicKey returns the extended public key at the given absolute keypath.
 	ExtendedPublicKey(signing.AbsoluteKeypath) (*hdkeychain.ExtendedKey, error)
 
-	// SignMessage(string, *signing.AbsoluteKeypath, coin.Coin) (*big.Int, error)
+	// SignHash signs the given 32 byte hash with the key at the given absolute keypath. Hardware
+	// keystores ask the user to confirm on the device.
+	SignHash(signing.AbsoluteKeypath, []byte) (*btcec.Signature, error)
 
 	// SignTransaction signs the given transaction proposal.
 	SignTransaction(coin.ProposedTransaction) error
 }
+
+// BatchSigner is implemented by keystores which can sign multiple transactions in one session,
+// e.g. with a single unlock. The user still confirms each transaction.
+type BatchSigner interface {
+	// SignTransactions signs the given proposed transactions in order.
+	SignTransactions([]coin.ProposedTransaction) error
+}
+
+// ExtendedPublicKeysBatcher is implemented by keystores which can fetch several extended public
+// keys at once faster than one by one, e.g. in fewer round trips to a device.
+type ExtendedPublicKeysBatcher interface {
+	// ExtendedPublicKeys returns the extended public keys at the given keypaths, in the same order.
+	ExtendedPublicKeys([]signing.AbsoluteKeypath) ([]*hdkeychain.ExtendedKey, error)
+}
+
+// RootFingerprintOutput is implemented by keystores which can output their root fingerprint
+// securely, so that the user can compare it with the fingerprint shown in the app before using it,
+// e.g. to add the keystore as a cosigner in a multisig coordinator.
+type RootFingerprintOutput interface {
+	// OutputRootFingerprint outputs the fingerprint of the master public key. It is only supported
+	// if the keystore has a secure output channel.
+	OutputRootFingerprint() error
+}
+
+// SharedSecretDeriver is implemented by keystores which can derive an ECDH shared secret with one
+// of their keys without revealing the private key, e.g. to receive payments to a BIP47 payment
+// code.
+type SharedSecretDeriver interface {
+	// SharedSecret returns the x coordinate of the product of the private key at the given keypath
+	// and the given public key, as 32 bytes.
+	SharedSecret(signing.AbsoluteKeypath, *btcec.PublicKey) ([]byte, error)
+}
+
+// MessageSigner is implemented by keystores which can sign messages with the key of an address,
+// e.g. to prove the ownership of the address. Hardware keystores show the message to the user.
+type MessageSigner interface {
+	// SignMessage signs the message with the key at the given keypath, for an address of the given
+	// script type of the given coin. The signature has the format of BIP137: 65 bytes, the first of
+	// which encodes the recovery ID and the script type.
+	SignMessage(signing.AbsoluteKeypath, signing.ScriptType, coin.Coin, []byte) ([]byte, error)
+}
@@ -15,6 +15,8 @@
 package keystore
 
 import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
@@ -42,8 +44,51 @@ type Keystores interface {
 	// SignTransaction signs the given proposed transaction on all keystores.
 	SignTransaction(coin.ProposedTransaction) error
 
+	// SignTransactions signs the given proposed transactions on all keystores. Keystores
+	// implementing BatchSigner sign them in one session.
+	SignTransactions([]coin.ProposedTransaction) error
+
+	// SignHash signs the given hash with the key at the given path on all keystores. The signatures
+	// are ordered by cosigner index.
+	SignHash(signing.AbsoluteKeypath, []byte) ([]*btcec.Signature, error)
+
+	// ExtendedPublicKeys returns the extended public keys at the given path of all keystores, ordered
+	// by cosigner index.
+	ExtendedPublicKeys(signing.AbsoluteKeypath) ([]*hdkeychain.ExtendedKey, error)
+
+	// BatchExtendedPublicKeys is like ExtendedPublicKeys, but for several paths at once. Keystores
+	// implementing ExtendedPublicKeysBatcher fetch them in one go.
+	BatchExtendedPublicKeys([]signing.AbsoluteKeypath) ([][]*hdkeychain.ExtendedKey, error)
+
 	// Configuration returns the configuration at the given path with the given signing threshold.
 	Configuration(signing.ScriptType, signing.AbsoluteKeypath, int) (*signing.Configuration, error)
+
+	// RootFingerprints returns the fingerprints of the master public keys of all keystores (first
+	// four bytes of the hash160 of the public key, see BIP32), ordered by cosigner index.
+	RootFingerprints() ([][]byte, error)
+
+	// CanOutputRootFingerprint returns whether the keystore with the given cosigner index
+	// implements RootFingerprintOutput and has a secure output.
+	CanOutputRootFingerprint(int) bool
+
+	// OutputRootFingerprint outputs the root fingerprint of the keystore with the given cosigner
+	// index. See CanOutputRootFingerprint().
+	OutputRootFingerprint(int) error
+
+	// CanDeriveSharedSecrets returns whether there is exactly one keystore and it implements
+	// SharedSecretDeriver.
+	CanDeriveSharedSecrets() bool
+
+	// SharedSecret derives the shared secret of the key at the given path and the given public key
+	// on the keystore. See CanDeriveSharedSecrets().
+	SharedSecret(signing.AbsoluteKeypath, *btcec.PublicKey) ([]byte, error)
+
+	// CanSignMessages returns whether there is exactly one keystore and it implements
+	// MessageSigner.
+	CanSignMessages() bool
+
+	// SignMessage signs the message on the keystore. See CanSignMessages().
+	SignMessage(signing.AbsoluteKeypath, signing.ScriptType, coin.Coin, []byte) ([]byte, error)
 }
 
 type implementation struct {
@@ -128,23 +173,214 @@ func (keystores *implementation) SignTransaction(proposedTransaction coin.Propos
 	return nil
 }
 
+// SignTransactions implements the above interface.
+func (keystores *implementation) SignTransactions(proposedTransactions []coin.ProposedTransaction) error {
+	for _, keystore := range keystores.keystores {
+		if batchSigner, ok := keystore.(BatchSigner); ok {
+			if err := batchSigner.SignTransactions(proposedTransactions); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, proposedTransaction := range proposedTransactions {
+			if err := keystore.SignTransaction(proposedTransaction); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SignHash implements the above interface.
+func (keystores *implementation) SignHash(
+	absoluteKeypath signing.AbsoluteKeypath, hash []byte) ([]*btcec.Signature, error) {
+	signatures := make([]*btcec.Signature, len(keystores.keystores))
+	for index, keystore := range keystores.keystores {
+		if keystore.CosignerIndex() != index {
+			return nil, errp.New("The keystores are in the wrong order.")
+		}
+		signature, err := keystore.SignHash(absoluteKeypath, hash)
+		if err != nil {
+			return nil, err
+		}
+		signatures[index] = signature
+	}
+	return signatures, nil
+}
+
+// ExtendedPublicKeys implements the above interface.
+func (keystores *implementation) ExtendedPublicKeys(
+	absoluteKeypath signing.AbsoluteKeypath) ([]*hdkeychain.ExtendedKey, error) {
+	extendedPublicKeys := make([]*hdkeychain.ExtendedKey, len(keystores.keystores))
+	for index, keystore := range keystores.keystores {
+		if keystore.CosignerIndex() != index {
+			return nil, errp.New("The keystores are in the wrong order.")
+		}
+		extendedPublicKey, err := keystore.ExtendedPublicKey(absoluteKeypath)
+		if err != nil {
+			return nil, err
+		}
+		extendedPublicKeys[index] = extendedPublicKey
+	}
+	return extendedPublicKeys, nil
+}
+
+// BatchExtendedPublicKeys implements the above interface.
+func (keystores *implementation) BatchExtendedPublicKeys(
+	absoluteKeypaths []signing.AbsoluteKeypath) ([][]*hdkeychain.ExtendedKey, error) {
+	extendedPublicKeys := make([][]*hdkeychain.ExtendedKey, len(absoluteKeypaths))
+	for i := range extendedPublicKeys {
+		extendedPublicKeys[i] = make([]*hdkeychain.ExtendedKey, len(keystores.keystores))
+	}
+	for index, keystore := range keystores.keystores {
+		if keystore.CosignerIndex() != index {
+			return nil, errp.New("The keystores are in the wrong order.")
+		}
+		if batcher, ok := keystore.(ExtendedPublicKeysBatcher); ok {
+			batch, err := batcher.ExtendedPublicKeys(absoluteKeypaths)
+			if err != nil {
+				return nil, err
+			}
+			if len(batch) != len(absoluteKeypaths) {
+				return nil, errp.New("Unexpected number of extended public keys")
+			}
+			for i, extendedPublicKey := range batch {
+				extendedPublicKeys[i][index] = extendedPublicKey
+			}
+			continue
+		}
+		for i, absoluteKeypath := range absoluteKeypaths {
+			extendedPublicKey, err := keystore.ExtendedPublicKey(absoluteKeypath)
+			if err != nil {
+				return nil, err
+			}
+			extendedPublicKeys[i][index] = extendedPublicKey
+		}
+	}
+	return extendedPublicKeys, nil
+}
+
 // Configuration implements the above interface.
 func (keystores *implementation) Configuration(
 	scriptType signing.ScriptType,
 	absoluteKeypath signing.AbsoluteKeypath,
 	signingThreshold int,
 ) (*signing.Configuration, error) {
-	extendedPublicKeys := make([]*hdkeychain.ExtendedKey, len(keystores.keystores))
+	extendedPublicKeys, err := keystores.ExtendedPublicKeys(absoluteKeypath)
+	if err != nil {
+		return nil, err
+	}
+	return signing.NewConfiguration(
+		scriptType, absoluteKeypath, extendedPublicKeys, signingThreshold), nil
+}
+
+// RootFingerprints implements the above interface.
+func (keystores *implementation) RootFingerprints() ([][]byte, error) {
+	fingerprints := make([][]byte, len(keystores.keystores))
 	for index, keystore := range keystores.keystores {
 		if keystore.CosignerIndex() != index {
 			return nil, errp.New("The keystores are in the wrong order.")
 		}
-		extendedPublicKey, err := keystore.ExtendedPublicKey(absoluteKeypath)
+		extendedPublicKey, err := keystore.ExtendedPublicKey(signing.NewEmptyAbsoluteKeypath())
 		if err != nil {
 			return nil, err
 		}
-		extendedPublicKeys[index] = extendedPublicKey
+		publicKey, err := extendedPublicKey.ECPubKey()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		fingerprints[index] = btcutil.Hash160(publicKey.SerializeCompressed())[:4]
 	}
-	return signing.NewConfiguration(
-		scriptType, absoluteKeypath, extendedPublicKeys, signingThreshold), nil
+	return fingerprints, nil
+}
+
+// rootFingerprintOutput returns the keystore with the given cosigner index if it can output its
+// root fingerprint securely.
+func (keystores *implementation) rootFingerprintOutput(cosignerIndex int) (RootFingerprintOutput, error) {
+	for _, keystore := range keystores.keystores {
+		if keystore.CosignerIndex() != cosignerIndex {
+			continue
+		}
+		output, ok := keystore.(RootFingerprintOutput)
+		if !ok || !keystore.HasSecureOutput() {
+			return nil, errp.New("The keystore can't securely output its root fingerprint.")
+		}
+		return output, nil
+	}
+	return nil, errp.Newf("There is no keystore with cosigner index %d.", cosignerIndex)
+}
+
+// CanOutputRootFingerprint implements the above interface.
+func (keystores *implementation) CanOutputRootFingerprint(cosignerIndex int) bool {
+	_, err := keystores.rootFingerprintOutput(cosignerIndex)
+	return err == nil
+}
+
+// OutputRootFingerprint implements the above interface.
+func (keystores *implementation) OutputRootFingerprint(cosignerIndex int) error {
+	output, err := keystores.rootFingerprintOutput(cosignerIndex)
+	if err != nil {
+		return err
+	}
+	return output.OutputRootFingerprint()
+}
+
+// sharedSecretDeriver returns the keystore if it is the only one and can derive shared secrets.
+func (keystores *implementation) sharedSecretDeriver() (SharedSecretDeriver, error) {
+	if len(keystores.keystores) != 1 {
+		return nil, errp.New("Shared secrets can only be derived with a single keystore.")
+	}
+	deriver, ok := keystores.keystores[0].(SharedSecretDeriver)
+	if !ok {
+		return nil, errp.New("The keystore can't derive shared secrets.")
+	}
+	return deriver, nil
+}
+
+// CanDeriveSharedSecrets implements the above interface.
+func (keystores *implementation) CanDeriveSharedSecrets() bool {
+	_, err := keystores.sharedSecretDeriver()
+	return err == nil
+}
+
+// SharedSecret implements the above interface.
+func (keystores *implementation) SharedSecret(
+	absoluteKeypath signing.AbsoluteKeypath, publicKey *btcec.PublicKey) ([]byte, error) {
+	deriver, err := keystores.sharedSecretDeriver()
+	if err != nil {
+		return nil, err
+	}
+	return deriver.SharedSecret(absoluteKeypath, publicKey)
+}
+
+// messageSigner returns the keystore if it is the only one and can sign messages.
+func (keystores *implementation) messageSigner() (MessageSigner, error) {
+	if len(keystores.keystores) != 1 {
+		return nil, errp.New("Messages can only be signed with a single keystore.")
+	}
+	signer, ok := keystores.keystores[0].(MessageSigner)
+	if !ok {
+		return nil, errp.New("The keystore can't sign messages.")
+	}
+	return signer, nil
+}
+
+// CanSignMessages implements the above interface.
+func (keystores *implementation) CanSignMessages() bool {
+	_, err := keystores.messageSigner()
+	return err == nil
+}
+
+// SignMessage implements the above interface.
+func (keystores *implementation) SignMessage(
+	absoluteKeypath signing.AbsoluteKeypath,
+	scriptType signing.ScriptType,
+	theCoin coin.Coin,
+	message []byte,
+) ([]byte, error) {
+	signer, err := keystores.messageSigner()
+	if err != nil {
+		return nil, err
+	}
+	return signer.SignMessage(absoluteKeypath, scriptType, theCoin, message)
 }
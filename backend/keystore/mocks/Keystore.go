@@ -1,6 +1,7 @@
 // Code generated by mockery v1.0.0. DO NOT EDIT.
 package mocks
 
+import btcec "github.com/btcsuite/btcd/btcec"
 import coin "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 import hdkeychain "github.com/btcsuite/btcutil/hdkeychain"
 
@@ -98,6 +99,29 @@ func (_m *Keystore) OutputAddress(_a0 signing.AbsoluteKeypath, _a1 signing.Scrip
 	return r0
 }
 
+// SignHash provides a mock function with given fields: _a0, _a1
+func (_m *Keystore) SignHash(_a0 signing.AbsoluteKeypath, _a1 []byte) (*btcec.Signature, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *btcec.Signature
+	if rf, ok := ret.Get(0).(func(signing.AbsoluteKeypath, []byte) *btcec.Signature); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*btcec.Signature)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(signing.AbsoluteKeypath, []byte) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // SignTransaction provides a mock function with given fields: _a0
 func (_m *Keystore) SignTransaction(_a0 coin.ProposedTransaction) error {
 	ret := _m.Called(_a0)
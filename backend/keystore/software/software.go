@@ -103,6 +103,55 @@ func (keystore *Keystore) ExtendedPublicKey(
 	return extendedPrivateKey.Neuter()
 }
 
+// SharedSecret implements keystore.SharedSecretDeriver.
+func (keystore *Keystore) SharedSecret(
+	absoluteKeypath signing.AbsoluteKeypath,
+	publicKey *btcec.PublicKey,
+) ([]byte, error) {
+	xprv, err := absoluteKeypath.Derive(keystore.master)
+	if err != nil {
+		return nil, err
+	}
+	prv, err := xprv.ECPrivKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	x, _ := btcec.S256().ScalarMult(publicKey.X, publicKey.Y, prv.D.Bytes())
+	sharedSecret := make([]byte, 32)
+	xBytes := x.Bytes()
+	copy(sharedSecret[32-len(xBytes):], xBytes)
+	return sharedSecret, nil
+}
+
+// SignMessage implements keystore.MessageSigner.
+func (keystore *Keystore) SignMessage(
+	absoluteKeypath signing.AbsoluteKeypath,
+	scriptType signing.ScriptType,
+	theCoin coin.Coin,
+	message []byte,
+) ([]byte, error) {
+	btcCoin, ok := theCoin.(*btc.Coin)
+	if !ok {
+		return nil, errp.New("Only BTC and LTC messages can be signed.")
+	}
+	xprv, err := absoluteKeypath.Derive(keystore.master)
+	if err != nil {
+		return nil, err
+	}
+	prv, err := xprv.ECPrivKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	signature, err := btcec.SignCompact(btcec.S256(), prv, btc.SignedMessageHash(btcCoin, message), true)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := btc.SetMessageSignatureHeader(signature, scriptType); err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
 func (keystore *Keystore) sign(
 	signatureHashes [][]byte,
 	keyPaths []signing.AbsoluteKeypath,
@@ -130,6 +179,18 @@ func (keystore *Keystore) sign(
 	return signatures, nil
 }
 
+// SignHash implements keystore.Keystore.
+func (keystore *Keystore) SignHash(
+	absoluteKeypath signing.AbsoluteKeypath,
+	hash []byte,
+) (*btcec.Signature, error) {
+	signatures, err := keystore.sign([][]byte{hash}, []signing.AbsoluteKeypath{absoluteKeypath})
+	if err != nil {
+		return nil, err
+	}
+	return &signatures[0], nil
+}
+
 // SignTransaction implements keystore.Keystore.
 func (keystore *Keystore) SignTransaction(
 	proposedTransaction coin.ProposedTransaction,
@@ -142,7 +203,13 @@ func (keystore *Keystore) SignTransaction(
 	signatureHashes := [][]byte{}
 	keyPaths := []signing.AbsoluteKeypath{}
 	transaction := btcProposedTx.TXProposal.Transaction
+	// signedInputs are the indices of the inputs the signatures are for.
+	signedInputs := []int{}
 	for index, txIn := range transaction.TxIn {
+		if _, ok := btcProposedTx.SkipInputs[index]; ok {
+			continue
+		}
+		signedInputs = append(signedInputs, index)
 		spentOutput, ok := btcProposedTx.PreviousOutputs[txIn.PreviousOutPoint]
 		if !ok {
 			keystore.log.Panic("There needs to be exactly one output being spent per input!")
@@ -176,12 +243,12 @@ func (keystore *Keystore) SignTransaction(
 	if err != nil {
 		return errp.WithMessage(err, "Failed to sign signature hash")
 	}
-	if len(signatures) != len(transaction.TxIn) {
+	if len(signatures) != len(signedInputs) {
 		panic("number of signatures doesn't match number of inputs")
 	}
 	for i, signature := range signatures {
 		signature := signature
-		btcProposedTx.Signatures[i][keystore.CosignerIndex()] = &signature
+		btcProposedTx.Signatures[signedInputs[i]][keystore.CosignerIndex()] = &signature
 	}
 	return nil
 }
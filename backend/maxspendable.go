@@ -0,0 +1,80 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// AccountMaxSpendable is the most one account can send, see MaxSpendable().
+type AccountMaxSpendable struct {
+	AccountCode string
+	*maketx.MaxSpendable
+}
+
+// MaxSpendable is the most that can be sent from several accounts of the same coin, e.g. to plan a
+// large purchase spread across them.
+type MaxSpendable struct {
+	Coin *btc.Coin
+	// Accounts are the amounts of the accounts, in the requested order.
+	Accounts []*AccountMaxSpendable
+	// Amount is the total spendable amount, after the fees of one tx per account.
+	Amount btcutil.Amount
+	// Fee is the total of the fees, i.e. the cost of consolidating the coins of each account.
+	Fee btcutil.Amount
+	// Uneconomic is the total value of the coins which are worth less than the fee to spend them.
+	Uneconomic btcutil.Amount
+}
+
+// MaxSpendable computes the most that can be sent from the accounts with the given codes at the
+// given fee rate, each account spending its coins in one tx. The accounts must be of the same coin.
+func (backend *Backend) MaxSpendable(
+	accountCodes []string, feeRatePerKb btcutil.Amount) (*MaxSpendable, error) {
+	if len(accountCodes) == 0 {
+		return nil, errp.New("no accounts selected")
+	}
+	accounts := map[string]*btc.Account{}
+	for _, account := range backend.Accounts() {
+		accounts[account.Code()] = account
+	}
+	result := &MaxSpendable{Accounts: []*AccountMaxSpendable{}}
+	for _, accountCode := range accountCodes {
+		account, ok := accounts[accountCode]
+		if !ok {
+			return nil, errp.Newf("unknown account %s", accountCode)
+		}
+		if result.Coin == nil {
+			result.Coin = account.Coin()
+		} else if account.Coin() != result.Coin {
+			return nil, errp.Newf("the account %s is not a %s account", accountCode, result.Coin.Name())
+		}
+		maxSpendable, err := account.MaxSpendable(feeRatePerKb)
+		if err != nil {
+			return nil, err
+		}
+		result.Accounts = append(result.Accounts, &AccountMaxSpendable{
+			AccountCode:  accountCode,
+			MaxSpendable: maxSpendable,
+		})
+		result.Amount += maxSpendable.Amount
+		result.Fee += maxSpendable.Fee
+		result.Uneconomic += maxSpendable.Uneconomic
+	}
+	return result, nil
+}
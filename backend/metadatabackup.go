@@ -0,0 +1,187 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/metadatabackup"
+)
+
+// MetadataRestoreResult summarizes a restored metadata backup.
+type MetadataRestoreResult struct {
+	Created time.Time
+	// RestoredNotes is the number of tx notes which were restored.
+	RestoredNotes int
+	// RestoredAddressNotes is the number of address notes which were restored.
+	RestoredAddressNotes int
+	// SkippedAccounts are the accounts in the backup which are not loaded, e.g. because a different
+	// keystore is connected. Their notes are not restored.
+	SkippedAccounts []string
+}
+
+// restoredConfig returns the local config with the account and frontend settings of the backup.
+// Settings which belong to this installation are kept: the network and privacy settings, like the
+// egress policy, DNS, proxy and servers, the backup target, paired devices and security settings,
+// like the bridge, the remote signer and custom coins.
+func restoredConfig(local config.AppConfig, backup config.AppConfig) config.AppConfig {
+	restored := local
+	restored.Frontend = backup.Frontend
+	restored.Backend.BitcoinP2PKHActive = backup.Backend.BitcoinP2PKHActive
+	restored.Backend.BitcoinP2WPKHP2SHActive = backup.Backend.BitcoinP2WPKHP2SHActive
+	restored.Backend.BitcoinP2WPKHActive = backup.Backend.BitcoinP2WPKHActive
+	restored.Backend.LitecoinP2WPKHP2SHActive = backup.Backend.LitecoinP2WPKHP2SHActive
+	restored.Backend.LitecoinP2WPKHActive = backup.Backend.LitecoinP2WPKHActive
+	restored.Backend.FeeBumpPolicy = backup.Backend.FeeBumpPolicy
+	restored.Backend.FeeCap = backup.Backend.FeeCap
+	restored.Backend.ColdStorageRules = backup.Backend.ColdStorageRules
+	restored.Backend.VaultAccounts = backup.Backend.VaultAccounts
+	restored.Backend.PaymentCodeAccounts = backup.Backend.PaymentCodeAccounts
+	restored.Backend.AccountNicknames = backup.Backend.AccountNicknames
+	return restored
+}
+
+// metadataBackupTarget returns the configured backup target.
+func (backend *Backend) metadataBackupTarget() (metadatabackup.Target, error) {
+	return metadatabackup.NewTarget(backend.config.Config().Backend.MetadataBackup)
+}
+
+// metadataBackupKey returns the key new backups are encrypted with. The passphrase is ignored if
+// the backups are encrypted with the keystore key.
+func (backend *Backend) metadataBackupKey(passphrase string) (metadatabackup.Key, error) {
+	if backend.config.Config().Backend.MetadataBackup.UseKeystoreKey {
+		return metadatabackup.KeystoreKey(backend.keystores)
+	}
+	return metadatabackup.PassphraseKey(passphrase)
+}
+
+// UploadMetadataBackup encrypts the tx and address notes of all loaded accounts and the app config
+// and uploads them to the configured backup target, replacing the previous backup. The backup is
+// encrypted with the passphrase, or with the keystore key if configured.
+func (backend *Backend) UploadMetadataBackup(passphrase string) error {
+	target, err := backend.metadataBackupTarget()
+	if err != nil {
+		return err
+	}
+	key, err := backend.metadataBackupKey(passphrase)
+	if err != nil {
+		return err
+	}
+	appConfig := backend.config.Config()
+	// The backup settings contain the credentials of the target.
+	appConfig.Backend.MetadataBackup = config.MetadataBackup{}
+	metadata := &metadatabackup.Metadata{
+		Created:      time.Now(),
+		TxNotes:      map[string]map[string]string{},
+		AddressNotes: map[string]map[string]string{},
+		Config:       appConfig,
+	}
+	for _, account := range backend.Accounts() {
+		notes, err := account.TxNotes()
+		if err != nil {
+			return err
+		}
+		if len(notes) != 0 {
+			metadata.TxNotes[account.Code()] = notes
+		}
+		addressNotes, err := account.AddressNotes()
+		if err != nil {
+			return err
+		}
+		if len(addressNotes) != 0 {
+			metadata.AddressNotes[account.Code()] = addressNotes
+		}
+	}
+	encrypted, err := metadatabackup.Encrypt(metadata, key)
+	if err != nil {
+		return err
+	}
+	if err := target.Upload(encrypted); err != nil {
+		return err
+	}
+	backend.log.WithField("accounts", len(metadata.TxNotes)).Info("Uploaded metadata backup")
+	return nil
+}
+
+// RestoreMetadataBackup downloads the backup from the configured backup target, decrypts it with
+// the passphrase or the keystore key, depending on how it was encrypted, and restores the account
+// and frontend settings and the tx and address notes of the loaded accounts. The other settings
+// are kept, see restoredConfig().
+func (backend *Backend) RestoreMetadataBackup(passphrase string) (*MetadataRestoreResult, error) {
+	target, err := backend.metadataBackupTarget()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := target.Download()
+	if err != nil {
+		return nil, err
+	}
+	keys := []metadatabackup.Key{}
+	if key, err := metadatabackup.PassphraseKey(passphrase); err == nil {
+		keys = append(keys, key)
+	}
+	if backend.keystores.Count() > 0 {
+		key, err := metadatabackup.KeystoreKey(backend.keystores)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	metadata, err := metadatabackup.Decrypt(encrypted, keys...)
+	if err != nil {
+		return nil, err
+	}
+	appConfig := restoredConfig(backend.config.Config(), metadata.Config)
+	if err := backend.config.Set(appConfig); err != nil {
+		return nil, err
+	}
+	result := &MetadataRestoreResult{Created: metadata.Created, SkippedAccounts: []string{}}
+	for accountCode, notes := range metadata.TxNotes {
+		account := backend.account(accountCode)
+		if account == nil {
+			result.SkippedAccounts = append(result.SkippedAccounts, accountCode)
+			continue
+		}
+		for txID, note := range notes {
+			if err := account.SetTxNote(txID, note); err != nil {
+				return nil, err
+			}
+			result.RestoredNotes++
+		}
+	}
+	for accountCode, notes := range metadata.AddressNotes {
+		account := backend.account(accountCode)
+		if account == nil {
+			if _, ok := metadata.TxNotes[accountCode]; !ok {
+				result.SkippedAccounts = append(result.SkippedAccounts, accountCode)
+			}
+			continue
+		}
+		for address, note := range notes {
+			if err := account.SetAddressNote(address, note); err != nil {
+				return nil, err
+			}
+			result.RestoredAddressNotes++
+		}
+	}
+	backend.log.WithFields(logrus.Fields{
+		"notes":        result.RestoredNotes,
+		"addressNotes": result.RestoredAddressNotes,
+	}).Info("Restored metadata backup")
+	return result, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadatabackup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// kdfScrypt derives the key from a passphrase using scrypt.
+	kdfScrypt = "scrypt"
+	// kdfKeystore derives the key from signatures of the keystores.
+	kdfKeystore = "keystore-signature"
+
+	// scrypt parameters, as recommended for interactive use in 2017.
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	keyLength = 32
+
+	// minPassphraseLength is the minimum length of the backup passphrase.
+	minPassphraseLength = 8
+
+	// keystoreKeypath is the keypath of the keys signing keystoreMessage. The purpose is not used
+	// by any coin, so the keys sign nothing else.
+	keystoreKeypath = "m/20180'/0'"
+	// keystoreMessage is signed by the keystores to derive the keystore key.
+	keystoreMessage = "BitBox metadata backup"
+)
+
+var (
+	// ErrWrongPassphrase is returned when a backup can't be decrypted, either because the
+	// passphrase is wrong or because the backup was modified.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	// ErrWrongKeystore is returned when a backup encrypted with the keystore key can't be
+	// decrypted, because no or a different wallet is connected, or because the backup was modified.
+	ErrWrongKeystore = errors.New("the backup belongs to a different wallet")
+)
+
+// Key is the secret a backup is encrypted with.
+type Key interface {
+	// kdf identifies the kind of key. It is stored in the backup.
+	kdf() string
+	// derive returns the encryption key of a backup with the given salt.
+	derive(salt []byte) ([]byte, error)
+	// errWrongKey is returned if the derived key can't decrypt the backup.
+	errWrongKey() error
+}
+
+type passphraseKey string
+
+// PassphraseKey returns a key derived from the passphrase with scrypt.
+func PassphraseKey(passphrase string) (Key, error) {
+	if len(passphrase) < minPassphraseLength {
+		return nil, errp.Newf("the passphrase must have at least %d characters", minPassphraseLength)
+	}
+	return passphraseKey(passphrase), nil
+}
+
+func (key passphraseKey) kdf() string {
+	return kdfScrypt
+}
+
+func (key passphraseKey) derive(salt []byte) ([]byte, error) {
+	derived, err := scrypt.Key([]byte(key), salt, scryptN, scryptR, scryptP, keyLength)
+	return derived, errp.WithStack(err)
+}
+
+func (key passphraseKey) errWrongKey() error {
+	return ErrWrongPassphrase
+}
+
+type keystoreKey struct {
+	keystores keystore.Keystores
+}
+
+// KeystoreKey returns a key derived from signatures of the keystores over a fixed message, made
+// with the keys at a dedicated keypath. ECDSA signatures are deterministic (RFC6979) on all
+// supported keystores, so only the seed determines the key, and restoring the seed restores access
+// to the backups without having to remember another passphrase. Unlike public keys, the signatures
+// can't be computed without the private keys. The keystores are only asked to sign when the key is
+// used, which hardware keystores confirm on the device.
+func KeystoreKey(keystores keystore.Keystores) (Key, error) {
+	if keystores.Count() == 0 {
+		return nil, errp.WithStack(ErrWrongKeystore)
+	}
+	return &keystoreKey{keystores: keystores}, nil
+}
+
+// secret signs keystoreMessage with all keystores and hashes the signatures. The signatures are
+// verified, so that a faulty keystore can't make the app encrypt backups with a garbage key.
+func (key *keystoreKey) secret() ([]byte, error) {
+	keypath, err := signing.NewAbsoluteKeypath(keystoreKeypath)
+	if err != nil {
+		return nil, err
+	}
+	extendedPublicKeys, err := key.keystores.ExtendedPublicKeys(keypath)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(keystoreMessage))
+	signatures, err := key.keystores.SignHash(keypath, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(signatures) != len(extendedPublicKeys) {
+		return nil, errp.New("unexpected number of signatures")
+	}
+	mac := hmac.New(sha256.New, []byte(keystoreMessage))
+	for index, signature := range signatures {
+		publicKey, err := extendedPublicKeys[index].ECPubKey()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if !signature.Verify(hash[:], publicKey) {
+			return nil, errp.New("invalid keystore signature")
+		}
+		_, _ = mac.Write(signature.Serialize())
+	}
+	return mac.Sum(nil), nil
+}
+
+func (key *keystoreKey) kdf() string {
+	return kdfKeystore
+}
+
+func (key *keystoreKey) derive(salt []byte) ([]byte, error) {
+	secret, err := key.secret()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(salt)
+	return mac.Sum(nil), nil
+}
+
+func (key *keystoreKey) errWrongKey() error {
+	return ErrWrongKeystore
+}
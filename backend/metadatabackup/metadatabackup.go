@@ -0,0 +1,146 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metadatabackup backs up the non-secret app metadata, like transaction and address notes
+// and the app configuration, to a remote storage target, so that it survives the loss of the
+// machine. The backup is encrypted end-to-end with a key derived from a user passphrase or from the
+// keystore, so the storage provider can't read it.
+package metadatabackup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// version is the version of the encrypted envelope.
+	version = 1
+
+	saltLength = 16
+)
+
+// Metadata is the content of a backup.
+type Metadata struct {
+	Created time.Time `json:"created"`
+	// TxNotes maps account codes to the notes of the account by tx ID.
+	TxNotes map[string]map[string]string `json:"txNotes"`
+	// AddressNotes maps account codes to the notes of the account by receive address. Backups
+	// created before address notes were added don't have them.
+	AddressNotes map[string]map[string]string `json:"addressNotes"`
+	// Config is the app config, containing the account settings and the frontend customizations.
+	// The backup settings themselves are not included.
+	Config config.AppConfig `json:"config"`
+}
+
+// envelope is the encrypted format of a backup which is stored on the target.
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errp.WithStack(err)
+}
+
+// Encrypt serializes and encrypts the metadata with AES-GCM, using an encryption key derived from
+// the given key with a random salt.
+func Encrypt(metadata *Metadata, key Key) ([]byte, error) {
+	plaintext, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	encryptionKey, err := key.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	result, err := json.Marshal(&envelope{
+		Version:    version,
+		KDF:        key.kdf(),
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	})
+	return result, errp.WithStack(err)
+}
+
+// Decrypt decrypts a backup created by Encrypt(). The key matching the kind of key the backup was
+// encrypted with is used, so the caller can pass all keys available.
+func Decrypt(encrypted []byte, keys ...Key) (*Metadata, error) {
+	var env envelope
+	if err := json.Unmarshal(encrypted, &env); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if env.Version != version || (env.KDF != kdfScrypt && env.KDF != kdfKeystore) {
+		return nil, errp.Newf("unsupported backup version %d (%s)", env.Version, env.KDF)
+	}
+	var key Key
+	for _, candidate := range keys {
+		if candidate.kdf() == env.KDF {
+			key = candidate
+			break
+		}
+	}
+	if key == nil {
+		if env.KDF == kdfKeystore {
+			return nil, errp.WithStack(ErrWrongKeystore)
+		}
+		return nil, errp.WithStack(ErrWrongPassphrase)
+	}
+	encryptionKey, err := key.derive(env.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, errp.New("invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, errp.WithStack(key.errWrongKey())
+	}
+	metadata := &Metadata{}
+	if err := json.Unmarshal(plaintext, metadata); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return metadata, nil
+}
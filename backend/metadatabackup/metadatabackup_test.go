@@ -0,0 +1,104 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadatabackup_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/metadatabackup"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func passphraseKey(t *testing.T, passphrase string) metadatabackup.Key {
+	key, err := metadatabackup.PassphraseKey(passphrase)
+	require.NoError(t, err)
+	return key
+}
+
+func keystoreKey(t *testing.T, pin string) metadatabackup.Key {
+	key, err := metadatabackup.KeystoreKey(keystore.NewKeystores(software.NewKeystoreFromPIN(0, pin)))
+	require.NoError(t, err)
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	metadata := &metadatabackup.Metadata{
+		TxNotes: map[string]map[string]string{"btc-p2wpkh": {"txid": "rent for may"}},
+		AddressNotes: map[string]map[string]string{
+			"btc-p2wpkh": {"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4": "given to alice"}},
+		Config: config.NewDefaultConfig(),
+	}
+	_, err := metadatabackup.PassphraseKey("short")
+	require.Error(t, err)
+
+	encrypted, err := metadatabackup.Encrypt(metadata, passphraseKey(t, "correct horse"))
+	require.NoError(t, err)
+	// The note contains spaces, so it can't appear in the base64 ciphertext by chance.
+	require.NotContains(t, string(encrypted), "rent for may")
+	require.NotContains(t, string(encrypted), "given to alice")
+
+	decrypted, err := metadatabackup.Decrypt(encrypted, passphraseKey(t, "correct horse"))
+	require.NoError(t, err)
+	require.Equal(t, metadata.TxNotes, decrypted.TxNotes)
+	require.Equal(t, metadata.AddressNotes, decrypted.AddressNotes)
+
+	_, err = metadatabackup.Decrypt(encrypted, passphraseKey(t, "wrong horse"))
+	require.Equal(t, metadatabackup.ErrWrongPassphrase, errp.Cause(err))
+	_, err = metadatabackup.Decrypt(encrypted, keystoreKey(t, "1234"))
+	require.Equal(t, metadatabackup.ErrWrongPassphrase, errp.Cause(err))
+}
+
+// TestKeystoreKey checks that a backup encrypted with the keystore key can be decrypted with the
+// same seed, without a passphrase.
+func TestKeystoreKey(t *testing.T) {
+	metadata := &metadatabackup.Metadata{TxNotes: map[string]map[string]string{}}
+	_, err := metadatabackup.KeystoreKey(keystore.NewKeystores())
+	require.Equal(t, metadatabackup.ErrWrongKeystore, errp.Cause(err))
+
+	encrypted, err := metadatabackup.Encrypt(metadata, keystoreKey(t, "1234"))
+	require.NoError(t, err)
+
+	_, err = metadatabackup.Decrypt(encrypted, passphraseKey(t, "correct horse"), keystoreKey(t, "1234"))
+	require.NoError(t, err)
+	_, err = metadatabackup.Decrypt(encrypted, keystoreKey(t, "5678"))
+	require.Equal(t, metadatabackup.ErrWrongKeystore, errp.Cause(err))
+	_, err = metadatabackup.Decrypt(encrypted, passphraseKey(t, "correct horse"))
+	require.Equal(t, metadatabackup.ErrWrongKeystore, errp.Cause(err))
+}
+
+// TestKeystoreKeyNeedsPrivateKey checks that the keystore key can't be derived from the public keys
+// alone.
+func TestKeystoreKeyNeedsPrivateKey(t *testing.T) {
+	keypath, err := signing.NewAbsoluteKeypath("m/20180'/0'")
+	require.NoError(t, err)
+	extendedPublicKey, err := software.NewKeystoreFromPIN(0, "1234").ExtendedPublicKey(keypath)
+	require.NoError(t, err)
+	watchOnly := &mocks.Keystore{}
+	watchOnly.On("CosignerIndex").Return(0)
+	watchOnly.On("ExtendedPublicKey", keypath).Return(extendedPublicKey, nil)
+	watchOnly.On("SignHash", keypath, mock.Anything).Return(nil, errors.New("watch-only"))
+	key, err := metadatabackup.KeystoreKey(keystore.NewKeystores(watchOnly))
+	require.NoError(t, err)
+	_, err = metadatabackup.Encrypt(&metadatabackup.Metadata{}, key)
+	require.Error(t, err)
+}
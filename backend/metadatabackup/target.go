@@ -0,0 +1,200 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadatabackup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// TargetWebDAV stores the backup on a WebDAV server, e.g. Nextcloud, using basic auth.
+	TargetWebDAV = "webdav"
+	// TargetS3 stores the backup in an S3-compatible object storage, e.g. AWS, Wasabi or Minio.
+	TargetS3 = "s3"
+	// TargetHTTP stores the backup on a self-hosted endpoint accepting PUT and GET, using a bearer
+	// token.
+	TargetHTTP = "http"
+
+	timeout = 30 * time.Second
+)
+
+// TargetError is returned when the target could not be reached or refused the request.
+type TargetError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (err *TargetError) Error() string {
+	return err.Err.Error()
+}
+
+// Target stores the encrypted backup.
+type Target interface {
+	Upload([]byte) error
+	Download() ([]byte, error)
+}
+
+// NewTarget creates the target configured in the backend config. The URL has to use https, as the
+// credentials are sent with every request, unless the target runs on the same computer.
+func NewTarget(backupConfig config.MetadataBackup) (Target, error) {
+	parsed, err := url.Parse(backupConfig.URL)
+	if err != nil || backupConfig.URL == "" {
+		return nil, errp.New("invalid backup URL")
+	}
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if !isLoopback(parsed.Hostname()) {
+			return nil, errp.New("the backup URL has to use https")
+		}
+	default:
+		return nil, errp.Newf("unsupported backup URL scheme %s", parsed.Scheme)
+	}
+	var authorize func(*http.Request, []byte)
+	switch backupConfig.Target {
+	case TargetWebDAV:
+		authorize = func(request *http.Request, _ []byte) {
+			request.SetBasicAuth(backupConfig.Username, backupConfig.Password)
+		}
+	case TargetHTTP:
+		authorize = func(request *http.Request, _ []byte) {
+			request.Header.Set("Authorization", "Bearer "+backupConfig.Password)
+		}
+	case TargetS3:
+		authorize = func(request *http.Request, body []byte) {
+			signS3(request, body, backupConfig.Region, backupConfig.Username,
+				backupConfig.Password, time.Now())
+		}
+	case "":
+		return nil, errp.New("metadata backup not configured")
+	default:
+		return nil, errp.Newf("unknown backup target %s", backupConfig.Target)
+	}
+	return &httpTarget{
+		url:       backupConfig.URL,
+		authorize: authorize,
+		client:    egress.HTTPClient(timeout),
+	}, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// httpTarget uploads the backup with PUT and downloads it with GET. WebDAV, S3 and the self-hosted
+// endpoint only differ in how requests are authorized.
+type httpTarget struct {
+	url       string
+	authorize func(*http.Request, []byte)
+	client    *http.Client
+}
+
+func (target *httpTarget) do(method string, body []byte) ([]byte, error) {
+	request, err := http.NewRequest(method, target.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	target.authorize(request, body)
+	response, err := egress.Do(target.client, request, egress.PurposeMetadataBackup)
+	if err != nil {
+		return nil, errp.WithStack(&TargetError{Err: err})
+	}
+	defer func() { _ = response.Body.Close() }()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, errp.WithStack(&TargetError{
+			Err: errp.Newf("%s %s failed: %s", method, target.url, response.Status),
+		})
+	}
+	return responseBody, nil
+}
+
+// Upload implements Target.
+func (target *httpTarget) Upload(encrypted []byte) error {
+	_, err := target.do(http.MethodPut, encrypted)
+	return err
+}
+
+// Download implements Target.
+func (target *httpTarget) Download() ([]byte, error) {
+	return target.do(http.MethodGet, nil)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// signS3 adds an AWS Signature Version 4 to the request. Only the host and the x-amz-* headers are
+// signed, which is enough for single object PUT and GET requests.
+func signS3(
+	request *http.Request, body []byte, region, accessKeyID, secretAccessKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+			request.URL.Host, payloadHash, amzDate),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+)
+
+func TestRestoredConfig(t *testing.T) {
+	local := config.AppConfig{Frontend: map[string]interface{}{"darkmode": false}}
+	local.Backend.Egress.Strict = true
+	local.Backend.DNS.Resolver = config.ResolverDoH
+	local.Backend.Network.SOCKSProxy = "127.0.0.1:9050"
+	local.Backend.MetadataBackup.URL = "https://backup.example.com"
+	local.Backend.BitcoinP2PKHActive = false
+
+	backup := config.AppConfig{Frontend: map[string]interface{}{"darkmode": true}}
+	backup.Backend.Network.SOCKSProxy = ""
+	backup.Backend.DNS.Resolver = config.ResolverSystem
+	backup.Backend.BitcoinP2PKHActive = true
+	backup.Backend.AccountNicknames = map[string]config.AccountNickname{
+		"btc-p2wpkh": {Name: "Savings"},
+	}
+
+	restored := restoredConfig(local, backup)
+	// Account and frontend settings are restored.
+	require.Equal(t, backup.Frontend, restored.Frontend)
+	require.True(t, restored.Backend.BitcoinP2PKHActive)
+	require.Equal(t, backup.Backend.AccountNicknames, restored.Backend.AccountNicknames)
+	// Network and privacy settings are kept.
+	require.True(t, restored.Backend.Egress.Strict)
+	require.Equal(t, config.ResolverDoH, restored.Backend.DNS.Resolver)
+	require.Equal(t, "127.0.0.1:9050", restored.Backend.Network.SOCKSProxy)
+	require.Equal(t, local.Backend.MetadataBackup, restored.Backend.MetadataBackup)
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// MigrationAccount is a funded account of the BitBox01 which should be migrated.
+type MigrationAccount struct {
+	Account *btc.Account
+	// Balance is the available balance of the account.
+	Balance btcutil.Amount
+	// Incoming is the unconfirmed incoming balance, which can only be swept once confirmed.
+	Incoming btcutil.Amount
+}
+
+// MigrationSweep is a planned tx sweeping all funds of one account to the new wallet.
+type MigrationSweep struct {
+	Account          *btc.Account
+	RecipientAddress string
+	Amount           btcutil.Amount
+	Fee              btcutil.Amount
+	FeeTargetCode    btc.FeeTargetCode
+}
+
+// MigrationProgress is the meta data of eventMigrationProgress.
+type MigrationProgress struct {
+	Done    int              `json:"done"`
+	Total   int              `json:"total"`
+	Results []*BatchTxResult `json:"results"`
+}
+
+// eventMigrationProgress is fired as a backend event after each broadcast sweep of a migration.
+const eventMigrationProgress = "migrationProgress"
+
+type migrationEvent struct {
+	Type string            `json:"type"`
+	Data string            `json:"data"`
+	Meta MigrationProgress `json:"meta"`
+}
+
+// MigrationSource returns the ID of the registered BitBox01 whose accounts can be migrated, or
+// false if none is registered.
+func (backend *Backend) MigrationSource() (string, bool) {
+	for deviceID, device := range backend.devices {
+		if device.ProductName() == bitbox.ProductName {
+			return deviceID, true
+		}
+	}
+	return "", false
+}
+
+// MigrationAccounts returns the funded accounts of the registered BitBox01. Accounts which did not
+// finish their initial sync are skipped, as their balance is not known yet.
+func (backend *Backend) MigrationAccounts() ([]*MigrationAccount, error) {
+	if _, ok := backend.MigrationSource(); !ok {
+		return nil, errp.New("no BitBox01 registered")
+	}
+	result := []*MigrationAccount{}
+	for _, account := range backend.Accounts() {
+		if !account.InitialSyncDone() {
+			continue
+		}
+		balance := account.Balance()
+		if balance.Available == 0 && balance.Incoming == 0 {
+			continue
+		}
+		result = append(result, &MigrationAccount{
+			Account:  account,
+			Balance:  balance.Available,
+			Incoming: balance.Incoming,
+		})
+	}
+	return result, nil
+}
+
+// cheapestFeeTarget returns the available fee target of the account with the lowest fee rate. A
+// migration is not urgent, so the funds are swept with the lowest fee.
+func cheapestFeeTarget(account *btc.Account) (btc.FeeTargetCode, error) {
+	feeTargets, _ := account.FeeTargets()
+	var cheapest *btc.FeeTarget
+	for _, feeTarget := range feeTargets {
+		if cheapest == nil || *feeTarget.FeeRatePerKb < *cheapest.FeeRatePerKb {
+			cheapest = feeTarget
+		}
+	}
+	if cheapest == nil {
+		return "", errp.New("fee estimation not available")
+	}
+	return cheapest.Code, nil
+}
+
+// ConfirmedSweep is a sweep planned by MigrationPlan() and confirmed by the user.
+type ConfirmedSweep struct {
+	AccountCode      string
+	RecipientAddress string
+	FeeTargetCode    btc.FeeTargetCode
+	Amount           btcutil.Amount
+	Fee              btcutil.Amount
+}
+
+// addressOwner is implemented by *btc.Account.
+type addressOwner interface {
+	OwnsAddress(string) bool
+}
+
+// checkMigrationDestination returns an error if the destination address belongs to one of the
+// accounts of the BitBox01, as the coins would not leave the wallet being migrated away from.
+func checkMigrationDestination(recipientAddress string, sourceAccounts []addressOwner) error {
+	for _, account := range sourceAccounts {
+		if account.OwnsAddress(recipientAddress) {
+			return errp.WithStack(btc.TxValidationError("the destination belongs to the BitBox01"))
+		}
+	}
+	return nil
+}
+
+// checkConfirmedSweep returns an error if the tx does not send the amount with the fee confirmed by
+// the user, e.g. because the fee estimate or the coins of the account changed since the plan.
+func checkConfirmedSweep(sweep *ConfirmedSweep, txProposal *maketx.TxProposal) error {
+	if txProposal.Amount != sweep.Amount || txProposal.Fee != sweep.Fee {
+		return errp.WithStack(btc.TxValidationError(
+			"the sweep of " + sweep.AccountCode + " changed since it was confirmed, please review it again"))
+	}
+	return nil
+}
+
+// migrationSweepAccounts returns the accounts of the BitBox01 with the given codes, in the order of
+// the codes. It checks that none of the destinations belongs to the BitBox01.
+func (backend *Backend) migrationSweepAccounts(
+	accountCodes []string, recipientAddresses []string) ([]*btc.Account, error) {
+	if _, ok := backend.MigrationSource(); !ok {
+		return nil, errp.New("no BitBox01 registered")
+	}
+	if len(accountCodes) == 0 {
+		return nil, errp.New("no accounts to migrate")
+	}
+	sourceAccounts := []addressOwner{}
+	accountsByCode := map[string]*btc.Account{}
+	for _, account := range backend.Accounts() {
+		sourceAccounts = append(sourceAccounts, account)
+		accountsByCode[account.Code()] = account
+	}
+	accounts := make([]*btc.Account, len(accountCodes))
+	for index, accountCode := range accountCodes {
+		account, ok := accountsByCode[accountCode]
+		if !ok {
+			return nil, errp.New("unknown account in migration")
+		}
+		for _, previous := range accounts[:index] {
+			if previous == account {
+				return nil, errp.New("duplicate account in migration")
+			}
+		}
+		if err := checkMigrationDestination(recipientAddresses[index], sourceAccounts); err != nil {
+			return nil, err
+		}
+		accounts[index] = account
+	}
+	return accounts, nil
+}
+
+// MigrationPlan proposes one tx per account sweeping all its coins to the given destinations,
+// which map account codes of the BitBox01 to receive addresses of the new wallet, e.g. of a
+// BitBox02. Sweeping all coins of an account in one tx at the cheapest fee target minimizes the
+// fees of the migration. The destinations must not belong to the BitBox01.
+func (backend *Backend) MigrationPlan(destinations map[string]string) ([]*MigrationSweep, error) {
+	accountCodes := []string{}
+	recipientAddresses := []string{}
+	// Keep the order of the accounts, so that the sweeps are confirmed in a stable order.
+	for _, account := range backend.Accounts() {
+		if recipientAddress, ok := destinations[account.Code()]; ok {
+			accountCodes = append(accountCodes, account.Code())
+			recipientAddresses = append(recipientAddresses, recipientAddress)
+		}
+	}
+	if len(accountCodes) != len(destinations) {
+		return nil, errp.New("unknown account in migration")
+	}
+	accounts, err := backend.migrationSweepAccounts(accountCodes, recipientAddresses)
+	if err != nil {
+		return nil, err
+	}
+	sweeps := make([]*MigrationSweep, len(accounts))
+	for index, account := range accounts {
+		feeTargetCode, err := cheapestFeeTarget(account)
+		if err != nil {
+			return nil, err
+		}
+		amount, fee, _, _, err := account.TxProposal(
+			recipientAddresses[index], btc.NewSendAmountAll(), feeTargetCode, nil, false, false, "")
+		if err != nil {
+			return nil, errp.WithMessage(err, "Failed to plan the sweep of "+account.Code())
+		}
+		sweeps[index] = &MigrationSweep{
+			Account:          account,
+			RecipientAddress: recipientAddresses[index],
+			Amount:           amount,
+			Fee:              fee.Amount,
+			FeeTargetCode:    feeTargetCode,
+		}
+	}
+	return sweeps, nil
+}
+
+// Migrate sweeps all coins of the given accounts to the new wallet as planned by MigrationPlan()
+// and confirmed by the user. The sweeps use the planned fee targets, and fail if the amount or the
+// fee differ from the confirmed ones. All sweeps are signed in one session of the BitBox01 and then
+// broadcast, firing eventMigrationProgress after each broadcast tx.
+func (backend *Backend) Migrate(sweeps []*ConfirmedSweep) ([]*BatchTxResult, error) {
+	accountCodes := make([]string, len(sweeps))
+	recipientAddresses := make([]string, len(sweeps))
+	for index, sweep := range sweeps {
+		accountCodes[index] = sweep.AccountCode
+		recipientAddresses[index] = sweep.RecipientAddress
+	}
+	accounts, err := backend.migrationSweepAccounts(accountCodes, recipientAddresses)
+	if err != nil {
+		return nil, err
+	}
+	preparedTxs := make([]*btc.PreparedTx, len(accounts))
+	for index, account := range accounts {
+		sweep := sweeps[index]
+		preparedTx, err := account.PrepareTx(
+			sweep.RecipientAddress, btc.NewSendAmountAll(), sweep.FeeTargetCode, nil,
+			"BitBox01 migration", "", false, false)
+		if err != nil {
+			return nil, errp.WithMessage(err, "Failed to prepare the sweep of "+account.Code())
+		}
+		if err := checkConfirmedSweep(sweep, preparedTx.TxProposal()); err != nil {
+			return nil, err
+		}
+		preparedTxs[index] = preparedTx
+	}
+	backend.log.WithFields(logrus.Fields{"accounts": len(accounts)}).Info("Migrating BitBox01 accounts")
+	return backend.sendTxBatch(preparedTxs, func(results []*BatchTxResult) {
+		backend.events <- migrationEvent{
+			Type: "backend",
+			Data: eventMigrationProgress,
+			Meta: MigrationProgress{Done: len(results), Total: len(preparedTxs), Results: results},
+		}
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+type addressOwnerMock map[string]bool
+
+func (mock addressOwnerMock) OwnsAddress(address string) bool {
+	return mock[address]
+}
+
+func TestCheckMigrationDestination(t *testing.T) {
+	sourceAccounts := []addressOwner{
+		addressOwnerMock{"address1": true},
+		addressOwnerMock{"address2": true},
+	}
+	require.NoError(t, checkMigrationDestination("address3", sourceAccounts))
+	for _, address := range []string{"address1", "address2"} {
+		err := checkMigrationDestination(address, sourceAccounts)
+		require.Error(t, err)
+		_, ok := errp.Cause(err).(btc.TxValidationError)
+		require.True(t, ok)
+	}
+}
+
+func TestCheckConfirmedSweep(t *testing.T) {
+	sweep := &ConfirmedSweep{AccountCode: "btc", Amount: 100000, Fee: 500}
+	require.NoError(t, checkConfirmedSweep(sweep, &maketx.TxProposal{Amount: 100000, Fee: 500}))
+	// The fee estimate changed since the plan.
+	require.Error(t, checkConfirmedSweep(sweep, &maketx.TxProposal{Amount: 99000, Fee: 1500}))
+	// The coins of the account changed since the plan.
+	require.Error(t, checkConfirmedSweep(sweep, &maketx.TxProposal{Amount: 200000, Fee: 500}))
+}
+
+func TestMigrateWithoutBitBox01(t *testing.T) {
+	backend := &Backend{}
+	_, err := backend.Migrate([]*ConfirmedSweep{{AccountCode: "btc"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/resolver"
+)
+
+// dialerConfig returns the config of all outbound connections, see egress.SetDialerConfig().
+func (backend *Backend) dialerConfig() egress.DialerConfig {
+	networkConfig := backend.config.Config().Backend.Network
+	hostTimeouts := map[string]time.Duration{}
+	for host, seconds := range networkConfig.HostTimeoutSeconds {
+		hostTimeouts[egress.Host(host)] = time.Duration(seconds) * time.Second
+	}
+	return egress.DialerConfig{
+		SOCKSProxy:   networkConfig.SOCKSProxy,
+		IPPreference: networkConfig.IPPreference,
+		Timeout:      time.Duration(networkConfig.TimeoutSeconds) * time.Second,
+		HostTimeouts: hostTimeouts,
+	}
+}
+
+// lookupIP resolves the host names of all direct outbound connections with the resolver selected in
+// the config. If the configured resolver fails, the system resolver is not tried, as that would leak
+// the host name in a plaintext DNS query.
+func (backend *Backend) lookupIP(host string) ([]net.IP, error) {
+	dnsConfig := backend.config.Config().Backend.DNS
+	switch dnsConfig.Resolver {
+	case config.ResolverDoH:
+		return backend.dohResolver(dnsConfig.DoHURL).LookupIP(host)
+	case config.ResolverTor:
+		return resolver.NewTor(dnsConfig.TorSOCKSAddress).LookupIP(host)
+	default:
+		return resolver.System.LookupIP(host)
+	}
+}
+
+// dohResolver returns the DoH resolver for the endpoint, keeping its cache as long as the endpoint
+// does not change.
+func (backend *Backend) dohResolver(endpoint string) *resolver.DoH {
+	defer backend.dohLock.Lock()()
+	if backend.doh == nil || backend.doh.Endpoint() != endpoint {
+		backend.doh = resolver.NewDoH(endpoint, nil)
+	}
+	return backend.doh
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDeviceNicknameLength is the maximum number of characters of the nickname of a device.
+const maxDeviceNicknameLength = 32
+
+// PairedDevice is a device in the pairing registry, see PairedDevices().
+type PairedDevice struct {
+	config.PairedDevice
+	Fingerprint string `json:"fingerprint"`
+	// DeviceID is the ID of the device if it is connected and unlocked, or empty.
+	DeviceID string `json:"deviceID"`
+}
+
+// pairedDevices returns a copy of the pairing registry, which can be modified and stored with
+// setPairedDevices().
+func (backend *Backend) pairedDevices() map[string]config.PairedDevice {
+	devices := map[string]config.PairedDevice{}
+	for fingerprint, paired := range backend.config.Config().Backend.PairedDevices {
+		devices[fingerprint] = paired
+	}
+	return devices
+}
+
+func (backend *Backend) setPairedDevices(devices map[string]config.PairedDevice) error {
+	appConfig := backend.config.Config()
+	appConfig.Backend.PairedDevices = devices
+	if err := backend.config.Set(appConfig); err != nil {
+		return err
+	}
+	backend.events <- backendEvent{Type: "devices", Data: "pairedChanged"}
+	return nil
+}
+
+// recordPairedDevice adds the unlocked device to the pairing registry, or updates when it was last
+// seen. The first device of a product is trusted. A device of the same product which is not in the
+// registry yet is recorded as untrusted, and the "untrustedDevice" event is fired, so that the user
+// can be warned that a different device than usual is used.
+func (backend *Backend) recordPairedDevice(theDevice device.Interface) {
+	fingerprinter, ok := theDevice.(device.Fingerprinter)
+	if !ok {
+		return
+	}
+	fingerprint := fingerprinter.Fingerprint()
+	if fingerprint == "" {
+		return
+	}
+	unlock := backend.pairedDevicesLock.Lock()
+	backend.deviceFingerprints[theDevice.Identifier()] = fingerprint
+	devices := backend.pairedDevices()
+	paired, known := devices[fingerprint]
+	now := time.Now()
+	if !known {
+		paired = config.PairedDevice{Product: theDevice.ProductName(), Trusted: true, FirstSeen: now}
+		for _, other := range devices {
+			if other.Product == paired.Product {
+				paired.Trusted = false
+				break
+			}
+		}
+	}
+	paired.LastSeen = now
+	devices[fingerprint] = paired
+	err := backend.setPairedDevices(devices)
+	unlock()
+	if err != nil {
+		backend.log.WithError(err).Error("Failed to record the paired device")
+	}
+	if !paired.Trusted {
+		backend.log.WithFields(logrus.Fields{"device-id": theDevice.Identifier(), "known": known}).
+			Warning("An untrusted device was unlocked")
+		backend.events <- deviceEvent{
+			DeviceID: theDevice.Identifier(),
+			Type:     "device",
+			Data:     "untrustedDevice",
+			Meta:     map[string]interface{}{"fingerprint": fingerprint, "known": known},
+		}
+	}
+}
+
+// forgetDeviceFingerprint is called when the device is removed.
+func (backend *Backend) forgetDeviceFingerprint(deviceID string) {
+	defer backend.pairedDevicesLock.Lock()()
+	delete(backend.deviceFingerprints, deviceID)
+}
+
+// PairedDevices returns the devices in the pairing registry, the most recently seen first.
+func (backend *Backend) PairedDevices() []*PairedDevice {
+	defer backend.pairedDevicesLock.RLock()()
+	connected := map[string]string{}
+	for deviceID, fingerprint := range backend.deviceFingerprints {
+		connected[fingerprint] = deviceID
+	}
+	result := []*PairedDevice{}
+	for fingerprint, paired := range backend.pairedDevices() {
+		result = append(result, &PairedDevice{
+			PairedDevice: paired,
+			Fingerprint:  fingerprint,
+			DeviceID:     connected[fingerprint],
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].LastSeen.After(result[j].LastSeen)
+		}
+		return result[i].Fingerprint < result[j].Fingerprint
+	})
+	return result
+}
+
+// newDeviceNickname validates the nickname of a device entered by the user. An empty nickname
+// removes it.
+func newDeviceNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	if !utf8.ValidString(nickname) || utf8.RuneCountInString(nickname) > maxDeviceNicknameLength {
+		return "", errp.Newf("the nickname must be at most %d characters long", maxDeviceNicknameLength)
+	}
+	for _, r := range nickname {
+		if unicode.IsControl(r) {
+			return "", errp.New("the nickname must not contain control characters")
+		}
+	}
+	return nickname, nil
+}
+
+// ValidateDeviceNickname returns an error if the nickname can't be given to a device, see
+// UpdatePairedDevice().
+func ValidateDeviceNickname(nickname string) error {
+	_, err := newDeviceNickname(nickname)
+	return err
+}
+
+// UpdatePairedDevice sets the nickname of the device with the given fingerprint, and whether it is
+// trusted. The nickname must be valid, see ValidateDeviceNickname().
+func (backend *Backend) UpdatePairedDevice(fingerprint string, nickname string, trusted bool) error {
+	nickname, err := newDeviceNickname(nickname)
+	if err != nil {
+		return err
+	}
+	defer backend.pairedDevicesLock.Lock()()
+	devices := backend.pairedDevices()
+	paired, ok := devices[fingerprint]
+	if !ok {
+		return errp.New("unknown device")
+	}
+	paired.Nickname = nickname
+	paired.Trusted = trusted
+	devices[fingerprint] = paired
+	return backend.setPairedDevices(devices)
+}
+
+// ForgetPairedDevice removes the device with the given fingerprint from the pairing registry. If
+// it is unlocked again, it is treated like a new device.
+func (backend *Backend) ForgetPairedDevice(fingerprint string) error {
+	defer backend.pairedDevicesLock.Lock()()
+	devices := backend.pairedDevices()
+	if _, ok := devices[fingerprint]; !ok {
+		return errp.New("unknown device")
+	}
+	delete(devices, fingerprint)
+	return backend.setPairedDevices(devices)
+}
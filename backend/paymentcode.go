@@ -0,0 +1,62 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// SetPaymentCodeAccount makes the account with the given code receive to the BIP47 payment code of
+// the coin, instead of the account which received to it before. If the account code is empty, no
+// account of the coin receives to the payment code. The affected accounts are reset, so that they
+// resync with or without the addresses of the payment code.
+func (backend *Backend) SetPaymentCodeAccount(coinCode string, accountCode string) error {
+	if accountCode != "" {
+		account := backend.account(accountCode)
+		if account == nil {
+			return errp.Newf("unknown account %s", accountCode)
+		}
+		if account.Coin().Name() != coinCode {
+			return errp.Newf("the account %s is not a %s account", accountCode, coinCode)
+		}
+	}
+	appConfig := backend.config.Config()
+	previous := appConfig.Backend.PaymentCodeAccounts[coinCode]
+	if previous == accountCode {
+		return nil
+	}
+	paymentCodeAccounts := map[string]string{}
+	for coin, account := range appConfig.Backend.PaymentCodeAccounts {
+		paymentCodeAccounts[coin] = account
+	}
+	if accountCode == "" {
+		delete(paymentCodeAccounts, coinCode)
+	} else {
+		paymentCodeAccounts[coinCode] = accountCode
+	}
+	appConfig.Backend.PaymentCodeAccounts = paymentCodeAccounts
+	if err := backend.config.Set(appConfig); err != nil {
+		return err
+	}
+	for _, code := range []string{previous, accountCode} {
+		if code == "" || backend.account(code) == nil {
+			continue
+		}
+		if err := backend.ResetAccount(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// PSBTInput is an input of a PSBT.
+type PSBTInput struct {
+	// Value is the value of the spent output, 0 if the PSBT does not contain it.
+	Value btcutil.Amount
+	// Account is the code of the account the input belongs to, or "" if it is not ours.
+	Account string
+}
+
+// PSBTOutput is an output of a PSBT.
+type PSBTOutput struct {
+	Value btcutil.Amount
+	// Address is "" if the output does not pay to a standard address.
+	Address string
+	// Account is the code of the account the output pays to, or "" if it is not ours.
+	Account string
+}
+
+// PSBTSummary describes an externally created PSBT, so that the user can review it before signing.
+type PSBTSummary struct {
+	// Coin is the coin of the accounts with inputs in the PSBT.
+	Coin    string
+	Inputs  []*PSBTInput
+	Outputs []*PSBTOutput
+	// Fee is nil if the values of some inputs are unknown.
+	Fee *btcutil.Amount
+	// AccountValues are the amounts paid by each account involved, minus the amounts received back.
+	AccountValues map[string]btcutil.Amount
+}
+
+// decodePSBT parses the base64 encoded PSBT. An invalid PSBT is reported as a validation error, as
+// it was supplied by the user.
+func decodePSBT(encoded string) (*psbt.Packet, error) {
+	packet, err := psbt.DecodeBase64(encoded)
+	if err != nil {
+		return nil, errp.WithStack(btc.TxValidationError("invalid PSBT: " + err.Error()))
+	}
+	return packet, nil
+}
+
+// psbtAccounts returns the accounts which can sign inputs of the PSBT. An error is returned if
+// there are none, or if they belong to different coins.
+func (backend *Backend) psbtAccounts(packet *psbt.Packet) ([]*btc.Account, error) {
+	signers := []*btc.Account{}
+	for _, account := range backend.Accounts() {
+		ownInputs, err := account.PSBTOwnInputs(packet)
+		if err != nil {
+			return nil, err
+		}
+		if len(ownInputs) == 0 {
+			continue
+		}
+		if len(signers) != 0 && signers[0].Coin() != account.Coin() {
+			return nil, errp.New("the PSBT spends coins of different networks")
+		}
+		signers = append(signers, account)
+	}
+	if len(signers) == 0 {
+		return nil, errp.WithStack(btc.TxValidationError("no input belongs to the wallet"))
+	}
+	return signers, nil
+}
+
+// PSBTSummary parses the base64 encoded PSBT and matches its inputs and outputs to the accounts.
+func (backend *Backend) PSBTSummary(encoded string) (*PSBTSummary, error) {
+	packet, err := decodePSBT(encoded)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := backend.psbtAccounts(packet)
+	if err != nil {
+		return nil, err
+	}
+	coin := signers[0].Coin()
+	summary := &PSBTSummary{
+		Coin:          coin.Name(),
+		Inputs:        []*PSBTInput{},
+		Outputs:       []*PSBTOutput{},
+		AccountValues: map[string]btcutil.Amount{},
+	}
+	fee := btcutil.Amount(0)
+	feeKnown := true
+	for index := range packet.UnsignedTx.TxIn {
+		input := &PSBTInput{}
+		summary.Inputs = append(summary.Inputs, input)
+		previousOutput, err := packet.PreviousOutput(index)
+		if err != nil {
+			feeKnown = false
+			continue
+		}
+		input.Value = btcutil.Amount(previousOutput.Value)
+		fee += input.Value
+		for _, account := range signers {
+			if account.OwnsScript(previousOutput.PkScript) {
+				input.Account = account.Code()
+				summary.AccountValues[account.Code()] += input.Value
+			}
+		}
+	}
+	for _, txOut := range packet.UnsignedTx.TxOut {
+		output := &PSBTOutput{Value: btcutil.Amount(txOut.Value)}
+		summary.Outputs = append(summary.Outputs, output)
+		fee -= output.Value
+		_, outputAddresses, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, coin.Net())
+		if err == nil && len(outputAddresses) == 1 {
+			output.Address = outputAddresses[0].EncodeAddress()
+		}
+		for _, account := range signers {
+			if account.OwnsScript(txOut.PkScript) {
+				output.Account = account.Code()
+				summary.AccountValues[account.Code()] -= output.Value
+			}
+		}
+	}
+	if feeKnown {
+		summary.Fee = &fee
+	}
+	return summary, nil
+}
+
+// SignPSBT signs all inputs of the base64 encoded PSBT which belong to the accounts and returns
+// the PSBT with the added signatures, base64 encoded.
+func (backend *Backend) SignPSBT(encoded string) (string, error) {
+	packet, err := decodePSBT(encoded)
+	if err != nil {
+		return "", err
+	}
+	signers, err := backend.psbtAccounts(packet)
+	if err != nil {
+		return "", err
+	}
+	for _, account := range signers {
+		if err := account.SignPSBT(packet); err != nil {
+			return "", err
+		}
+	}
+	return packet.EncodeBase64()
+}
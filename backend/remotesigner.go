@@ -0,0 +1,268 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"os"
+	"path"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config/features"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/remotesigner"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+)
+
+// RemoteSignerStatus describes the remote signing of this app, in both directions.
+type RemoteSignerStatus struct {
+	// Enabled is true if the signer mode is enabled in the config.
+	Enabled bool                       `json:"enabled"`
+	Signer  *remotesigner.SignerStatus `json:"signer"`
+	// Peers are the paired apps, in signer mode or using this app in signer mode.
+	Peers []*remotesigner.Peer `json:"peers"`
+	// Connected is the public key of the signer whose keystore is in use, or empty.
+	Connected string `json:"connected"`
+	// PairingCode is the code to compare while a signer is being paired, or empty.
+	PairingCode string `json:"pairingCode"`
+}
+
+// hostname identifies this app to the apps it is paired with.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "BitBox App"
+	}
+	return name
+}
+
+// remoteSigning returns the store of the paired apps and the signer. They are created on first
+// use, so that the key pair identifying the app is only created if the feature is used. Must be
+// called with the remoteSignerLock held.
+func (backend *Backend) remoteSigning() (*remotesigner.Store, *remotesigner.Signer, error) {
+	if !backend.features.Enabled(features.FeatureRemoteSigning) {
+		return nil, nil, errp.New("remote signing is disabled")
+	}
+	if backend.remoteSignerStore == nil {
+		store, err := remotesigner.NewStore(
+			path.Join(backend.arguments.MainDirectoryPath(), "remote-signer.json"))
+		if err != nil {
+			return nil, nil, err
+		}
+		backend.remoteSignerStore = store
+		backend.remoteSigner = remotesigner.NewSigner(
+			backend, store, hostname(), logging.Get().WithGroup("remotesigner"))
+		backend.remoteSigner.Observe(func(event observable.Event) { backend.events <- event })
+	}
+	return backend.remoteSignerStore, backend.remoteSigner, nil
+}
+
+func (backend *Backend) notifyRemoteSigner() {
+	backend.events <- backendEvent{Type: "remoteSigner", Data: "statusChanged"}
+}
+
+// RemoteSignerStatus returns the state of the remote signing.
+func (backend *Backend) RemoteSignerStatus() (*RemoteSignerStatus, error) {
+	defer backend.remoteSignerLock.Lock()()
+	store, signer, err := backend.remoteSigning()
+	if err != nil {
+		return nil, err
+	}
+	status := &RemoteSignerStatus{
+		Enabled:     backend.config.Config().Backend.RemoteSigner.Enabled,
+		Signer:      signer.Status(),
+		Peers:       store.Peers(),
+		PairingCode: backend.remotePairingCode,
+	}
+	if backend.remoteKeystore != nil {
+		status.Connected = backend.remoteKeystore.Peer().PublicKey
+	}
+	return status, nil
+}
+
+// SetRemoteSignerMode starts or stops serving the keystores of this app to the paired apps, and
+// stores the setting in the config, so that the signer mode is started again with the app.
+func (backend *Backend) SetRemoteSignerMode(enabled bool) error {
+	defer backend.remoteSignerLock.Lock()()
+	_, signer, err := backend.remoteSigning()
+	if err != nil {
+		return err
+	}
+	appConfig := backend.config.Config()
+	appConfig.Backend.RemoteSigner.Enabled = enabled
+	if err := backend.config.Set(appConfig); err != nil {
+		return err
+	}
+	if !enabled {
+		return signer.Stop()
+	}
+	return signer.Start(appConfig.Backend.RemoteSigner.Port)
+}
+
+// startRemoteSigner starts the signer mode if it is enabled in the config.
+func (backend *Backend) startRemoteSigner() {
+	signerConfig := backend.config.Config().Backend.RemoteSigner
+	if !signerConfig.Enabled || !backend.features.Enabled(features.FeatureRemoteSigning) {
+		return
+	}
+	defer backend.remoteSignerLock.Lock()()
+	_, signer, err := backend.remoteSigning()
+	if err == nil {
+		err = signer.Start(signerConfig.Port)
+	}
+	if err != nil {
+		backend.log.WithError(err).Error("Failed to start the signer mode")
+	}
+}
+
+// ApproveRemoteSignerPairing approves or rejects the app waiting to be paired with this app in
+// signer mode. The code is the pairing code shown to the user.
+func (backend *Backend) ApproveRemoteSignerPairing(code string, approved bool) error {
+	defer backend.remoteSignerLock.Lock()()
+	_, signer, err := backend.remoteSigning()
+	if err != nil {
+		return err
+	}
+	return signer.ApprovePairing(code, approved)
+}
+
+// ApproveRemoteSignerPSBT approves or rejects signing the PSBT a paired app asked to sign in signer
+// mode, after the user reviewed the PSBT with the given ID.
+func (backend *Backend) ApproveRemoteSignerPSBT(id string, approved bool) error {
+	defer backend.remoteSignerLock.Lock()()
+	_, signer, err := backend.remoteSigning()
+	if err != nil {
+		return err
+	}
+	return signer.ApprovePSBT(id, approved)
+}
+
+// PairRemoteSigner pairs this app with the app in signer mode at the given address. The pairing
+// code is part of the status while the user compares it and approves the pairing on the signer.
+func (backend *Backend) PairRemoteSigner(address string) (*remotesigner.Peer, error) {
+	store, err := func() (*remotesigner.Store, error) {
+		defer backend.remoteSignerLock.Lock()()
+		store, _, err := backend.remoteSigning()
+		return store, err
+	}()
+	if err != nil {
+		return nil, err
+	}
+	setPairingCode := func(code string) {
+		func() {
+			defer backend.remoteSignerLock.Lock()()
+			backend.remotePairingCode = code
+		}()
+		backend.notifyRemoteSigner()
+	}
+	defer setPairingCode("")
+	peer, err := remotesigner.Pair(store, address, hostname(), setPairingCode)
+	if err != nil {
+		return nil, err
+	}
+	backend.log.WithField("address", address).Info("Paired with a remote signer")
+	return peer, nil
+}
+
+// ConnectRemoteSigner connects to the paired signer with the given public key and uses its
+// keystore. No other keystore may be in use.
+func (backend *Backend) ConnectRemoteSigner(publicKey string) error {
+	store, err := func() (*remotesigner.Store, error) {
+		defer backend.remoteSignerLock.Lock()()
+		store, _, err := backend.remoteSigning()
+		return store, err
+	}()
+	if err != nil {
+		return err
+	}
+	var peer *remotesigner.Peer
+	for _, candidate := range store.Peers() {
+		if candidate.PublicKey == publicKey && candidate.Role == remotesigner.RoleSigner {
+			peer = candidate
+		}
+	}
+	if peer == nil {
+		return errp.New("unknown signer")
+	}
+	// The lock is not held while connecting, as the egress policy looks up the paired signers.
+	remoteKeystore, err := remotesigner.Connect(store, peer, logging.Get().WithGroup("remotesigner"))
+	if err != nil {
+		return err
+	}
+	defer backend.remoteSignerLock.Lock()()
+	if backend.remoteKeystore != nil || backend.keystores.Count() != 0 {
+		_ = remoteKeystore.Close()
+		return errp.New("a keystore is already in use")
+	}
+	backend.remoteKeystore = remoteKeystore
+	backend.RegisterKeystore(remoteKeystore)
+	backend.notifyRemoteSigner()
+	return nil
+}
+
+// disconnectRemoteSigner stops using the keystore of the connected signer, if any. Must be called
+// with the remoteSignerLock held.
+func (backend *Backend) disconnectRemoteSigner() error {
+	if backend.remoteKeystore == nil {
+		return nil
+	}
+	remoteKeystore := backend.remoteKeystore
+	backend.remoteKeystore = nil
+	backend.DeregisterKeystore()
+	backend.notifyRemoteSigner()
+	return remoteKeystore.Close()
+}
+
+// DisconnectRemoteSigner stops using the keystore of the connected signer.
+func (backend *Backend) DisconnectRemoteSigner() error {
+	defer backend.remoteSignerLock.Lock()()
+	return backend.disconnectRemoteSigner()
+}
+
+// RemoveRemoteSignerPeer unpairs the app with the given public key and role. The connection is
+// closed if it is the connected signer.
+func (backend *Backend) RemoveRemoteSignerPeer(publicKey string, role remotesigner.Role) error {
+	defer backend.remoteSignerLock.Lock()()
+	store, _, err := backend.remoteSigning()
+	if err != nil {
+		return err
+	}
+	if role == remotesigner.RoleSigner && backend.remoteKeystore != nil &&
+		backend.remoteKeystore.Peer().PublicKey == publicKey {
+		if err := backend.disconnectRemoteSigner(); err != nil {
+			backend.log.WithError(err).Error("Failed to close the connection to the signer")
+		}
+	}
+	if err := store.Remove(publicKey, role); err != nil {
+		return err
+	}
+	backend.notifyRemoteSigner()
+	return nil
+}
+
+// remoteSignerDestinations returns the addresses of the paired signers, which this app connects
+// to.
+func (backend *Backend) remoteSignerDestinations() []string {
+	defer backend.remoteSignerLock.RLock()()
+	if backend.remoteSignerStore == nil {
+		return nil
+	}
+	addresses := []string{}
+	for _, peer := range backend.remoteSignerStore.Peers() {
+		if peer.Role == remotesigner.RoleSigner {
+			addresses = append(addresses, peer.Address)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,237 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotesigner
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/flynn/noise"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// prologue identifies the protocol and its version. It is part of the handshake transcript, so
+	// both parties have to speak the same version.
+	prologue = "bitbox-remote-signer/1"
+	// keySize is the size of the Curve25519 keys and of the commitment nonce.
+	keySize = 32
+	// handshakeTimeout limits how long the handshake may take.
+	handshakeTimeout = 10 * time.Second
+)
+
+// cipherSuite are the primitives of the Noise_XX_25519_ChaChaPoly_SHA256 channel.
+var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// KeyPair is a Curve25519 key pair. The static key pair of an app identifies it to its peers.
+type KeyPair = noise.DHKey
+
+// NewKeyPair creates a random key pair.
+func NewKeyPair() (*KeyPair, error) {
+	keyPair, err := cipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return &keyPair, nil
+}
+
+func keyPairFromPrivate(private []byte) (*KeyPair, error) {
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return &KeyPair{Private: append([]byte{}, private...), Public: public}, nil
+}
+
+// secureConn is an encrypted and mutually authenticated connection between two apps, see
+// handshake(). Messages have to be read and written in order by one goroutine per direction.
+type secureConn struct {
+	conn net.Conn
+
+	send    *noise.CipherState
+	receive *noise.CipherState
+
+	// remoteStatic is the static public key of the other app.
+	remoteStatic []byte
+	// transcript is the hash of the handshake messages.
+	transcript []byte
+}
+
+func writeFrame(conn net.Conn, payload []byte) error {
+	frame := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	_, err := conn.Write(append(frame, payload...))
+	return errp.WithStack(err)
+}
+
+func readFrame(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxMessageSize+chacha20poly1305.Overhead {
+		return nil, errp.Newf("message of %d bytes too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return payload, nil
+}
+
+// commitment is the hash committing the initiator to its static key before it learns the keys of
+// the responder. The nonce hides the static key from passive observers.
+func commitment(nonce []byte, static []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, nonce...), static...))
+	return hash[:]
+}
+
+// handshake establishes the encrypted channel over the connection with the Noise_XX handshake:
+//
+//	initiator -> responder: e, commitment
+//	responder -> initiator: e, ee, s, es
+//	initiator -> responder: s, se, nonce
+//
+// Only the holders of the two static keys can talk on the channel. The initiator commits to its
+// static key in the first message, before it sees the keys of the responder, and opens the
+// commitment in the last message. Otherwise an attacker in the middle could choose its keys after
+// seeing those of both parties, until the pairing codes of both sides match, see pairingCode().
+func handshake(conn net.Conn, initiator bool, static *KeyPair) (*secureConn, error) {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	handshakeState, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   cipherSuite,
+		Random:        rand.Reader,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     initiator,
+		Prologue:      []byte(prologue),
+		StaticKeypair: *static,
+	})
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	var initiatorCipher, responderCipher *noise.CipherState
+	if initiator {
+		nonce := make([]byte, keySize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		message, _, _, err := handshakeState.WriteMessage(nil, commitment(nonce, static.Public))
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if err := writeFrame(conn, message); err != nil {
+			return nil, err
+		}
+		if message, err = readFrame(conn); err != nil {
+			return nil, err
+		}
+		if _, _, _, err := handshakeState.ReadMessage(nil, message); err != nil {
+			return nil, errp.New("invalid handshake message")
+		}
+		message, initiatorCipher, responderCipher, err = handshakeState.WriteMessage(nil, nonce)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if err := writeFrame(conn, message); err != nil {
+			return nil, err
+		}
+	} else {
+		message, err := readFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		committed, _, _, err := handshakeState.ReadMessage(nil, message)
+		if err != nil || len(committed) != sha256.Size {
+			return nil, errp.New("invalid handshake message")
+		}
+		message, _, _, err = handshakeState.WriteMessage(nil, nil)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if err := writeFrame(conn, message); err != nil {
+			return nil, err
+		}
+		if message, err = readFrame(conn); err != nil {
+			return nil, err
+		}
+		var nonce []byte
+		nonce, initiatorCipher, responderCipher, err = handshakeState.ReadMessage(nil, message)
+		if err != nil {
+			return nil, errp.New("invalid handshake message")
+		}
+		if !bytes.Equal(commitment(nonce, handshakeState.PeerStatic()), committed) {
+			return nil, errp.New("the static key does not match the commitment")
+		}
+	}
+	if initiatorCipher == nil || responderCipher == nil {
+		return nil, errp.New("the handshake did not finish")
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	secure := &secureConn{
+		conn:         conn,
+		remoteStatic: append([]byte{}, handshakeState.PeerStatic()...),
+		transcript:   handshakeState.ChannelBinding(),
+	}
+	if initiator {
+		secure.send, secure.receive = initiatorCipher, responderCipher
+	} else {
+		secure.send, secure.receive = responderCipher, initiatorCipher
+	}
+	return secure, nil
+}
+
+// pairingCode returns a six digit code derived from the handshake. Both apps show it when they
+// are paired, and the user confirms that they match. They only match if no one is in the middle.
+func (secure *secureConn) pairingCode() string {
+	hash := sha256.Sum256(append([]byte("pairing code"), secure.transcript...))
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(hash[:4])%1000000)
+}
+
+func (secure *secureConn) write(message []byte) error {
+	cipherText, err := secure.send.Encrypt(nil, nil, message)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return writeFrame(secure.conn, cipherText)
+}
+
+func (secure *secureConn) read() ([]byte, error) {
+	cipherText, err := readFrame(secure.conn)
+	if err != nil {
+		return nil, err
+	}
+	message, err := secure.receive.Decrypt(nil, nil, cipherText)
+	if err != nil {
+		return nil, errp.New("failed to decrypt the message")
+	}
+	return message, nil
+}
+
+func (secure *secureConn) Close() error {
+	return errp.WithStack(secure.conn.Close())
+}
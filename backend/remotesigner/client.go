@@ -0,0 +1,159 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotesigner
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// connection is a connection of this app to an app in signer mode.
+type connection struct {
+	secure *secureConn
+	nextID int
+	// lock keeps one request at a time on the connection, as the responses carry no nonce of their
+	// own.
+	lock locker.Locker
+}
+
+func dial(address string, identity *KeyPair) (*connection, error) {
+	conn, err := egress.Dial("tcp", address, egress.PurposeRemoteSigner)
+	if err != nil {
+		return nil, err
+	}
+	secure, err := handshake(conn, true, identity)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errp.WithMessage(err, "Remote signer handshake failed")
+	}
+	return &connection{secure: secure}, nil
+}
+
+// call sends the request and decodes the result of the response into result, unless it is nil.
+// Failed requests return an *Error.
+func (connection *connection) call(method string, params interface{}, result interface{}) error {
+	defer connection.lock.Lock()()
+	connection.nextID++
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	requestBytes, err := json.Marshal(&request{
+		ID:     connection.nextID,
+		Method: method,
+		Params: paramsBytes,
+	})
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if err := connection.secure.write(requestBytes); err != nil {
+		return err
+	}
+	responseBytes, err := connection.secure.read()
+	if err != nil {
+		return err
+	}
+	var resp response
+	if err := json.Unmarshal(responseBytes, &resp); err != nil {
+		return errp.WithStack(err)
+	}
+	if resp.ID != connection.nextID {
+		return errp.Newf("unexpected response %d to request %d", resp.ID, connection.nextID)
+	}
+	if resp.Error != nil {
+		return errp.WithStack(&Error{Code: resp.Error.Code, Message: resp.Error.Message})
+	}
+	if result == nil {
+		return nil
+	}
+	return errp.WithStack(json.Unmarshal(resp.Result, result))
+}
+
+func (connection *connection) close() error {
+	return connection.secure.Close()
+}
+
+// Pair pairs this app with the app in signer mode at the given address, e.g. "192.168.1.10:8179".
+// onCode is called with the pairing code as soon as it is known. The user compares it with the code
+// shown by the signer, and approves the pairing there. This app is identified by the given name,
+// e.g. the host name. Pair blocks until the pairing is approved or rejected. The signer is stored
+// and can be used with Connect() afterwards.
+func Pair(store *Store, address string, name string, onCode func(string)) (*Peer, error) {
+	connection, err := dial(address, store.Identity())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = connection.close() }()
+	onCode(connection.secure.pairingCode())
+	var result struct {
+		Name string `json:"name"`
+	}
+	if err := connection.call("pair", map[string]string{"name": name}, &result); err != nil {
+		return nil, err
+	}
+	peer := &Peer{
+		PublicKey: hex.EncodeToString(connection.secure.remoteStatic),
+		Role:      RoleSigner,
+		Name:      result.Name,
+		Address:   address,
+		Created:   time.Now(),
+	}
+	if err := store.Add(peer); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// Connect connects to the paired app in signer mode and returns its keystore. The keystore can be
+// used until it is closed, or until the connection fails.
+func Connect(store *Store, peer *Peer, log *logrus.Entry) (*Keystore, error) {
+	if peer.Role != RoleSigner {
+		return nil, errp.New("the app is not a signer")
+	}
+	publicKey, err := hex.DecodeString(peer.PublicKey)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	connection, err := dial(peer.Address, store.Identity())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(connection.secure.remoteStatic, publicKey) {
+		_ = connection.close()
+		return nil, errp.Newf("the app at %s is not the paired signer", peer.Address)
+	}
+	var info struct {
+		RootFingerprint string `json:"rootFingerprint"`
+	}
+	if err := connection.call("info", nil, &info); err != nil {
+		_ = connection.close()
+		return nil, err
+	}
+	log.WithField("address", peer.Address).Info("Connected to the remote signer")
+	return &Keystore{
+		connection:      connection,
+		peer:            peer,
+		rootFingerprint: info.RootFingerprint,
+		log:             log,
+	}, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotesigner
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Keystore is the keystore of an app in signer mode, see Connect(). It can only be the only
+// keystore of the app, as the signer serves exactly one keystore.
+type Keystore struct {
+	connection      *connection
+	peer            *Peer
+	rootFingerprint string
+
+	log *logrus.Entry
+}
+
+// Peer returns the signer the keystore belongs to.
+func (keystore *Keystore) Peer() *Peer {
+	return keystore.peer
+}
+
+// Close closes the connection to the signer.
+func (keystore *Keystore) Close() error {
+	return keystore.connection.close()
+}
+
+// CosignerIndex implements keystore.Keystore.
+func (keystore *Keystore) CosignerIndex() int {
+	return 0
+}
+
+// Identifier implements keystore.Keystore. It is the hex encoded root fingerprint of the keystore
+// of the signer.
+func (keystore *Keystore) Identifier() (string, error) {
+	return keystore.rootFingerprint, nil
+}
+
+// HasSecureOutput implements keystore.Keystore. Addresses can't be verified on the device of the
+// signer, as it would have to be confirmed on the other machine.
+func (keystore *Keystore) HasSecureOutput() bool {
+	return false
+}
+
+// OutputAddress implements keystore.Keystore.
+func (keystore *Keystore) OutputAddress(signing.AbsoluteKeypath, signing.ScriptType, coin.Coin) error {
+	return errp.New("the remote signer can't output addresses")
+}
+
+// ExtendedPublicKey implements keystore.Keystore.
+func (keystore *Keystore) ExtendedPublicKey(
+	keypath signing.AbsoluteKeypath) (*hdkeychain.ExtendedKey, error) {
+	extendedPublicKeys, err := keystore.ExtendedPublicKeys([]signing.AbsoluteKeypath{keypath})
+	if err != nil {
+		return nil, err
+	}
+	return extendedPublicKeys[0], nil
+}
+
+// ExtendedPublicKeys implements keystore.ExtendedPublicKeysBatcher, fetching all keys in one
+// request.
+func (keystore *Keystore) ExtendedPublicKeys(
+	keypaths []signing.AbsoluteKeypath) ([]*hdkeychain.ExtendedKey, error) {
+	encoded := make([]string, len(keypaths))
+	for index, keypath := range keypaths {
+		encoded[index] = keypath.Encode()
+	}
+	var xpubs []string
+	if err := keystore.connection.call(
+		"xpubs", map[string][]string{"keypaths": encoded}, &xpubs); err != nil {
+		return nil, err
+	}
+	if len(xpubs) != len(keypaths) {
+		return nil, errp.New("the signer returned an unexpected number of xpubs")
+	}
+	extendedPublicKeys := make([]*hdkeychain.ExtendedKey, len(xpubs))
+	for index, xpub := range xpubs {
+		extendedPublicKey, err := hdkeychain.NewKeyFromString(xpub)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		extendedPublicKeys[index] = extendedPublicKey
+	}
+	return extendedPublicKeys, nil
+}
+
+// SignHash implements keystore.Keystore. The signer only signs PSBTs which the user reviewed, as
+// a hash can't be shown to the user.
+func (keystore *Keystore) SignHash(signing.AbsoluteKeypath, []byte) (*btcec.Signature, error) {
+	return nil, errp.New("the remote signer can't sign hashes")
+}
+
+// SignTransaction implements keystore.Keystore. The transaction is sent to the signer as a PSBT,
+// so that the signer can show it to the user with its own accounts, like any PSBT to sign.
+func (keystore *Keystore) SignTransaction(proposedTx coin.ProposedTransaction) error {
+	btcProposedTx, ok := proposedTx.(*btc.ProposedTransaction)
+	if !ok {
+		panic("only btc")
+	}
+	keystore.log.Info("Sign transaction remotely")
+	transaction := btcProposedTx.TXProposal.Transaction
+	unsignedTx := transaction.Copy()
+	packet := &psbt.Packet{
+		UnsignedTx: unsignedTx,
+		Inputs:     make([]*psbt.Input, len(unsignedTx.TxIn)),
+		Outputs:    make([]*psbt.Output, len(unsignedTx.TxOut)),
+	}
+	for index, txIn := range unsignedTx.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+		packet.Inputs[index] = &psbt.Input{}
+		if _, ok := btcProposedTx.SkipInputs[index]; ok {
+			continue
+		}
+		spentOutput, ok := btcProposedTx.PreviousOutputs[txIn.PreviousOutPoint]
+		if !ok {
+			return errp.Newf("input %d: the spent output is missing", index)
+		}
+		packet.Inputs[index].WitnessUTXO = spentOutput.TxOut
+	}
+	for index := range packet.Outputs {
+		packet.Outputs[index] = &psbt.Output{}
+	}
+	encoded, err := packet.EncodeBase64()
+	if err != nil {
+		return err
+	}
+	var result string
+	if err := keystore.connection.call("signPSBT", map[string]string{"psbt": encoded}, &result); err != nil {
+		return err
+	}
+	signed, err := psbt.DecodeBase64(result)
+	if err != nil {
+		return err
+	}
+	if signed.UnsignedTx.TxHash() != unsignedTx.TxHash() {
+		return errp.New("the signer returned a different transaction")
+	}
+	for index, txIn := range transaction.TxIn {
+		if _, ok := btcProposedTx.SkipInputs[index]; ok {
+			continue
+		}
+		signature, err := keystore.partialSignature(btcProposedTx, signed, index, txIn)
+		if err != nil {
+			return err
+		}
+		btcProposedTx.Signatures[index][keystore.CosignerIndex()] = signature
+	}
+	return nil
+}
+
+// partialSignature returns the signature of the input at the given index by the key of the
+// keystore from the signed PSBT.
+func (keystore *Keystore) partialSignature(
+	btcProposedTx *btc.ProposedTransaction,
+	signed *psbt.Packet,
+	index int,
+	txIn *wire.TxIn,
+) (*btcec.Signature, error) {
+	spentOutput := btcProposedTx.PreviousOutputs[txIn.PreviousOutPoint]
+	address := btcProposedTx.GetAddress(spentOutput.ScriptHashHex())
+	publicKey := address.Configuration.PublicKeys()[keystore.CosignerIndex()].SerializeCompressed()
+	for _, partialSig := range signed.Inputs[index].PartialSigs {
+		if !bytes.Equal(partialSig.PubKey, publicKey) || len(partialSig.Signature) == 0 {
+			continue
+		}
+		// The last byte is the sighash type.
+		signature, err := btcec.ParseDERSignature(
+			partialSig.Signature[:len(partialSig.Signature)-1], btcec.S256())
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		return signature, nil
+	}
+	return nil, errp.Newf("the signer did not sign input %d", index)
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotesigner lets the app relay its signing requests to a keystore attached to another
+// app on the local network, so that the machine running the node and the machine with the device
+// can differ. The app with the keystore runs in signer mode, see Signer, and the other app connects
+// to it with Connect(). The apps talk over an encrypted and mutually authenticated channel, see
+// handshake(). They have to be paired once with Pair(), the user confirming on both apps that the
+// same pairing code is shown.
+package remotesigner
+
+import (
+	"encoding/json"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+)
+
+// DefaultPort is the port the signer listens on if none is configured.
+const DefaultPort = 8179
+
+// maxMessageSize is the maximum size of a request or response, large enough for PSBTs with many
+// inputs.
+const maxMessageSize = 1 << 20
+
+// Error codes of failed requests.
+const (
+	ErrorCodeInvalidRequest = "invalidRequest"
+	ErrorCodeNoKeystore     = "noKeystore"
+	ErrorCodeNotPaired      = "notPaired"
+	ErrorCodeRejected       = "rejected"
+	ErrorCodeFailed         = "failed"
+)
+
+// Wallet provides the keystores and the signing of the app in signer mode.
+type Wallet interface {
+	Keystores() keystore.Keystores
+	// SignPSBT signs the inputs of the base64 encoded PSBT belonging to the accounts.
+	SignPSBT(string) (string, error)
+}
+
+type request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type responseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *responseError  `json:"error,omitempty"`
+}
+
+// Error is returned if the signer fails a request.
+type Error struct {
+	// Code is one of the ErrorCode* constants.
+	Code    string
+	Message string
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotesigner
+
+import (
+	"encoding/hex"
+	"net"
+	"path"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/flynn/noise"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+type testWallet struct {
+	keystores keystore.Keystores
+}
+
+func (wallet *testWallet) Keystores() keystore.Keystores {
+	return wallet.keystores
+}
+
+func (wallet *testWallet) SignPSBT(encoded string) (string, error) {
+	return "signed:" + encoded, nil
+}
+
+func newStore(t *testing.T, name string) *Store {
+	store, err := NewStore(path.Join(test.TstTempDir("remotesigner-"), name+".json"))
+	require.NoError(t, err)
+	return store
+}
+
+func TestHandshake(t *testing.T) {
+	initiatorStatic, err := NewKeyPair()
+	require.NoError(t, err)
+	responderStatic, err := NewKeyPair()
+	require.NoError(t, err)
+	initiatorConn, responderConn := net.Pipe()
+	responderResult := make(chan *secureConn)
+	go func() {
+		secure, err := handshake(responderConn, false, responderStatic)
+		require.NoError(t, err)
+		responderResult <- secure
+	}()
+	initiator, err := handshake(initiatorConn, true, initiatorStatic)
+	require.NoError(t, err)
+	responder := <-responderResult
+
+	require.Equal(t, responderStatic.Public[:], initiator.remoteStatic)
+	require.Equal(t, initiatorStatic.Public[:], responder.remoteStatic)
+	require.Equal(t, initiator.pairingCode(), responder.pairingCode())
+	require.Len(t, initiator.pairingCode(), 6)
+
+	go func() {
+		require.NoError(t, initiator.write([]byte("request")))
+	}()
+	message, err := responder.read()
+	require.NoError(t, err)
+	require.Equal(t, []byte("request"), message)
+
+	// A message encrypted for another channel is rejected.
+	go func() {
+		forged := make([]byte, 6+chacha20poly1305.Overhead)
+		require.NoError(t, writeFrame(initiatorConn, forged))
+	}()
+	_, err = responder.read()
+	require.Error(t, err)
+}
+
+func TestHandshakeCommitment(t *testing.T) {
+	initiatorStatic, err := NewKeyPair()
+	require.NoError(t, err)
+	responderStatic, err := NewKeyPair()
+	require.NoError(t, err)
+	initiatorConn, responderConn := net.Pipe()
+	responderResult := make(chan error)
+	go func() {
+		_, err := handshake(responderConn, false, responderStatic)
+		responderResult <- err
+	}()
+	// The initiator does not open the commitment of its first message.
+	initiator, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		Prologue:      []byte(prologue),
+		StaticKeypair: *initiatorStatic,
+	})
+	require.NoError(t, err)
+	message, _, _, err := initiator.WriteMessage(nil, commitment(make([]byte, keySize), initiatorStatic.Public))
+	require.NoError(t, err)
+	require.NoError(t, writeFrame(initiatorConn, message))
+	message, err = readFrame(initiatorConn)
+	require.NoError(t, err)
+	_, _, _, err = initiator.ReadMessage(nil, message)
+	require.NoError(t, err)
+	otherNonce := make([]byte, keySize)
+	otherNonce[0] = 1
+	message, _, _, err = initiator.WriteMessage(nil, otherNonce)
+	require.NoError(t, err)
+	require.NoError(t, writeFrame(initiatorConn, message))
+	require.Error(t, <-responderResult)
+}
+
+func TestStore(t *testing.T) {
+	filename := path.Join(test.TstTempDir("remotesigner-"), "remote-signer.json")
+	store, err := NewStore(filename)
+	require.NoError(t, err)
+	require.Empty(t, store.Peers())
+	peer := &Peer{PublicKey: "01", Role: RoleNode, Name: "node"}
+	require.NoError(t, store.Add(peer))
+
+	loaded, err := NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, store.Identity(), loaded.Identity())
+	require.Len(t, loaded.Peers(), 1)
+	require.NotNil(t, loaded.Lookup([]byte{1}, RoleNode))
+	require.Nil(t, loaded.Lookup([]byte{1}, RoleSigner))
+
+	require.NoError(t, loaded.Remove("01", RoleNode))
+	require.Error(t, loaded.Remove("01", RoleNode))
+	require.Empty(t, loaded.Peers())
+}
+
+func errorCode(err error) string {
+	if remoteErr, ok := errp.Cause(err).(*Error); ok {
+		return remoteErr.Code
+	}
+	return ""
+}
+
+func TestSigner(t *testing.T) {
+	softwareKeystore := software.NewKeystoreFromPIN(0, "1234")
+	wallet := &testWallet{keystores: keystore.NewKeystores(softwareKeystore)}
+	signerStore := newStore(t, "signer")
+	signer := NewSigner(wallet, signerStore, "signer", logging.Get().WithGroup("remotesigner"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	signer.listener = listener
+	go signer.accept(listener)
+	defer func() { require.NoError(t, signer.Stop()) }()
+	address := listener.Addr().String()
+	log := logging.Get().WithGroup("remotesigner")
+
+	nodeStore := newStore(t, "node")
+	unpaired := &Peer{
+		PublicKey: hex.EncodeToString(signerStore.Identity().Public[:]),
+		Role:      RoleSigner,
+		Address:   address,
+	}
+	_, err = Connect(nodeStore, unpaired, log)
+	require.Equal(t, ErrorCodeNotPaired, errorCode(err))
+
+	// The user rejects the pairing.
+	var approve bool
+	var reviewed *PendingPSBT
+	signer.Observe(func(event observable.Event) {
+		status := event.Object.(*SignerStatus)
+		if status.PendingPairing != nil {
+			require.Error(t, signer.ApprovePairing("", approve))
+			require.NoError(t, signer.ApprovePairing(status.PendingPairing.Code, approve))
+		}
+		if status.PendingPSBT != nil {
+			reviewed = status.PendingPSBT
+			require.Error(t, signer.ApprovePSBT("other", approve))
+			require.NoError(t, signer.ApprovePSBT(status.PendingPSBT.ID, approve))
+		}
+	})
+	_, err = Pair(nodeStore, address, "node", func(string) {})
+	require.Equal(t, ErrorCodeRejected, errorCode(err))
+	require.Empty(t, signer.Peers())
+	require.Error(t, signer.ApprovePairing("", true))
+
+	approve = true
+	var code string
+	peer, err := Pair(nodeStore, address, "node", func(pairingCode string) { code = pairingCode })
+	require.NoError(t, err)
+	require.Len(t, code, 6)
+	require.Equal(t, "signer", peer.Name)
+	require.Equal(t, []*Peer{peer}, nodeStore.Peers())
+	require.Len(t, signer.Peers(), 1)
+	require.Equal(t, "node", signer.Peers()[0].Name)
+	require.Nil(t, signer.Status().PendingPairing)
+
+	remoteKeystore, err := Connect(nodeStore, peer, log)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, remoteKeystore.Close()) }()
+	rootFingerprints, err := wallet.keystores.RootFingerprints()
+	require.NoError(t, err)
+	identifier, err := remoteKeystore.Identifier()
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(rootFingerprints[0]), identifier)
+
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/1'/0'")
+	require.NoError(t, err)
+	expected, err := softwareKeystore.ExtendedPublicKey(keypath)
+	require.NoError(t, err)
+	extendedPublicKey, err := remoteKeystore.ExtendedPublicKey(keypath)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), extendedPublicKey.String())
+
+	// The keys signing the data of the app are not handed out, and hashes are not signed blindly.
+	err = remoteKeystore.connection.call(
+		"xpubs", map[string][]string{"keypaths": {"m/84'/1'/0'", "m/20180'/0'"}}, nil)
+	require.Equal(t, ErrorCodeInvalidRequest, errorCode(err))
+	err = remoteKeystore.connection.call("signHash", map[string]string{
+		"keypath": "m/20180'/1'",
+		"hash":    hex.EncodeToString(chainhash.DoubleHashB([]byte("message"))),
+	}, nil)
+	require.Equal(t, ErrorCodeInvalidRequest, errorCode(err))
+	_, err = remoteKeystore.SignHash(keypath, chainhash.DoubleHashB([]byte("message")))
+	require.Error(t, err)
+
+	var signed string
+	require.NoError(t, remoteKeystore.connection.call(
+		"signPSBT", map[string]string{"psbt": "cHNidP8="}, &signed))
+	require.Equal(t, "signed:cHNidP8=", signed)
+	require.Equal(t, "node", reviewed.Name)
+	require.Equal(t, "cHNidP8=", reviewed.PSBT)
+	require.Nil(t, signer.Status().PendingPSBT)
+
+	approve = false
+	err = remoteKeystore.connection.call("signPSBT", map[string]string{"psbt": "cHNidP8="}, &signed)
+	require.Equal(t, ErrorCodeRejected, errorCode(err))
+
+	err = remoteKeystore.connection.call("unknown", nil, nil)
+	require.Equal(t, ErrorCodeInvalidRequest, errorCode(err))
+
+	// Another app can't pretend to be the paired signer.
+	impostor := *peer
+	impostor.PublicKey = hex.EncodeToString(nodeStore.Identity().Public[:])
+	_, err = Connect(nodeStore, &impostor, log)
+	require.Error(t, err)
+}
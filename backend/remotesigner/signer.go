@@ -0,0 +1,486 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotesigner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
+)
+
+// approvalTimeout is how long a pairing or a PSBT waits for the approval of the user.
+const approvalTimeout = 2 * time.Minute
+
+// PendingPairing is an app waiting for the user to approve its pairing, see Signer.ApprovePairing().
+type PendingPairing struct {
+	Name string `json:"name"`
+	// Address is the address the app connected from.
+	Address string `json:"address"`
+	// Code is the pairing code, which the other app shows as well.
+	Code string `json:"code"`
+}
+
+// PendingPSBT is a PSBT which a paired app asks to sign, waiting for the user to review it, see
+// Signer.ApprovePSBT().
+type PendingPSBT struct {
+	// ID identifies the request, so that a decision is not applied to another PSBT submitted after
+	// the review timed out.
+	ID string `json:"id"`
+	// Name is the name of the paired app.
+	Name string `json:"name"`
+	// PSBT is the base64 encoded PSBT, which the app summarizes for the review.
+	PSBT string `json:"psbt"`
+}
+
+// SignerStatus describes the state of the signer mode.
+type SignerStatus struct {
+	Running bool   `json:"running"`
+	Address string `json:"address"`
+	// PendingPairing is nil if no app waits for approval.
+	PendingPairing *PendingPairing `json:"pendingPairing"`
+	// PendingPSBT is nil if no PSBT waits for review.
+	PendingPSBT *PendingPSBT `json:"pendingPSBT"`
+}
+
+// Signer serves the keystores of the app to the paired apps on the local network. Observers are
+// notified with the status when an app asks to be paired, and when the pairing is finished.
+type Signer struct {
+	observable.Implementation
+
+	wallet Wallet
+	store  *Store
+	// name identifies the signer to the apps being paired, e.g. the host name.
+	name string
+
+	listener net.Listener
+	// conns are the open connections, which are closed by Stop().
+	conns map[net.Conn]struct{}
+	lock  locker.Locker
+
+	// approvalLock makes sure only one app at a time asks for approval.
+	approvalLock locker.Locker
+	pending      *PendingPairing
+	pendingPSBT  *PendingPSBT
+	// approval receives the decision of the user on the pending pairing or PSBT.
+	approval chan *approvalDecision
+
+	log *logrus.Entry
+}
+
+// NewSigner creates the signer of the wallet, identified by the key pair of the store, which also
+// keeps the paired apps, and by the given name. It does not listen until Start() is called.
+func NewSigner(wallet Wallet, store *Store, name string, log *logrus.Entry) *Signer {
+	return &Signer{
+		wallet:   wallet,
+		store:    store,
+		name:     name,
+		conns:    map[net.Conn]struct{}{},
+		approval: make(chan *approvalDecision, 1),
+		log:      log,
+	}
+}
+
+// Start listens on all interfaces at the given port, or DefaultPort if it is 0.
+func (signer *Signer) Start(port int) error {
+	defer signer.lock.Lock()()
+	if signer.listener != nil {
+		return nil
+	}
+	if port == 0 {
+		port = DefaultPort
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	signer.listener = listener
+	go signer.accept(listener)
+	signer.log.WithField("address", listener.Addr().String()).Info("Started the signer mode")
+	return nil
+}
+
+func (signer *Signer) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go signer.serve(conn)
+	}
+}
+
+// Stop closes the listener and all connections.
+func (signer *Signer) Stop() error {
+	defer signer.lock.Lock()()
+	if signer.listener == nil {
+		return nil
+	}
+	err := signer.listener.Close()
+	var pendingID string
+	if signer.pending != nil {
+		pendingID = signer.pending.Code
+	} else if signer.pendingPSBT != nil {
+		pendingID = signer.pendingPSBT.ID
+	}
+	if pendingID != "" {
+		select {
+		case signer.approval <- &approvalDecision{id: pendingID}:
+		default:
+		}
+	}
+	for conn := range signer.conns {
+		_ = conn.Close()
+	}
+	signer.listener = nil
+	signer.log.Info("Stopped the signer mode")
+	return errp.WithStack(err)
+}
+
+// Status returns whether the signer is listening, and where.
+func (signer *Signer) Status() *SignerStatus {
+	defer signer.lock.RLock()()
+	status := &SignerStatus{PendingPairing: signer.pending, PendingPSBT: signer.pendingPSBT}
+	if signer.listener != nil {
+		status.Running = true
+		status.Address = signer.listener.Addr().String()
+	}
+	return status
+}
+
+func (signer *Signer) notifyStatus() {
+	signer.Notify(observable.Event{
+		Subject: "remoteSigner",
+		Action:  action.Replace,
+		Object:  signer.Status(),
+	})
+}
+
+func (signer *Signer) setPending(pending *PendingPairing) {
+	func() {
+		defer signer.lock.Lock()()
+		signer.pending = pending
+	}()
+	signer.notifyStatus()
+}
+
+func (signer *Signer) setPendingPSBT(pendingPSBT *PendingPSBT) {
+	func() {
+		defer signer.lock.Lock()()
+		signer.pendingPSBT = pendingPSBT
+	}()
+	signer.notifyStatus()
+}
+
+// approvalDecision is the decision of the user on the pending pairing with the given pairing
+// code, or on the pending PSBT with the given ID.
+type approvalDecision struct {
+	id       string
+	approved bool
+}
+
+// ApprovePairing approves or rejects the pending pairing, after the user compared the pairing
+// codes of both apps. The code is the one shown to the user, so that the decision is not applied
+// to another app asking to be paired.
+func (signer *Signer) ApprovePairing(code string, approved bool) error {
+	defer signer.lock.RLock()()
+	if signer.pending == nil {
+		return errp.New("no pairing to approve")
+	}
+	if signer.pending.Code != code {
+		return errp.New("the approved pairing is no longer pending")
+	}
+	select {
+	case signer.approval <- &approvalDecision{id: code, approved: approved}:
+		return nil
+	default:
+		return errp.New("the pairing was already approved or rejected")
+	}
+}
+
+// ApprovePSBT approves or rejects signing the pending PSBT with the given ID, after the user
+// reviewed its summary.
+func (signer *Signer) ApprovePSBT(id string, approved bool) error {
+	defer signer.lock.RLock()()
+	if signer.pendingPSBT == nil {
+		return errp.New("no PSBT to approve")
+	}
+	if signer.pendingPSBT.ID != id {
+		return errp.New("the reviewed PSBT is no longer pending")
+	}
+	select {
+	case signer.approval <- &approvalDecision{id: id, approved: approved}:
+		return nil
+	default:
+		return errp.New("the PSBT was already approved or rejected")
+	}
+}
+
+// awaitApproval waits for the decision of the user on the pending pairing or PSBT with the given
+// ID. Decisions on other IDs are dropped. It must be called with the approvalLock held.
+func (signer *Signer) awaitApproval(subject string, id string) error {
+	timeout := time.After(approvalTimeout)
+	for {
+		select {
+		case decision := <-signer.approval:
+			if decision.id != id {
+				continue
+			}
+			if !decision.approved {
+				return errp.WithStack(&Error{Code: ErrorCodeRejected, Message: "the " + subject + " was rejected"})
+			}
+			return nil
+		case <-timeout:
+			return errp.WithStack(&Error{Code: ErrorCodeRejected, Message: "the " + subject + " timed out"})
+		}
+	}
+}
+
+// dropApproval drops a decision made while nothing was pending. It must be called with the
+// approvalLock held.
+func (signer *Signer) dropApproval() {
+	select {
+	case <-signer.approval:
+	default:
+	}
+}
+
+// Peers returns the apps paired to use the signer.
+func (signer *Signer) Peers() []*Peer {
+	peers := []*Peer{}
+	for _, peer := range signer.store.Peers() {
+		if peer.Role == RoleNode {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (signer *Signer) serve(conn net.Conn) {
+	func() {
+		defer signer.lock.Lock()()
+		signer.conns[conn] = struct{}{}
+	}()
+	defer func() {
+		defer signer.lock.Lock()()
+		delete(signer.conns, conn)
+		_ = conn.Close()
+	}()
+	log := signer.log.WithField("remote", conn.RemoteAddr().String())
+	secure, err := handshake(conn, false, signer.store.Identity())
+	if err != nil {
+		log.WithError(err).Warning("Remote signer handshake failed")
+		return
+	}
+	log.Info("Remote signer connection opened")
+	for {
+		message, err := secure.read()
+		if err != nil {
+			log.Info("Remote signer connection closed")
+			return
+		}
+		responseBytes, err := json.Marshal(signer.handle(secure, message, log))
+		if err != nil {
+			log.WithError(err).Error("Failed to encode the response")
+			return
+		}
+		if err := secure.write(responseBytes); err != nil {
+			return
+		}
+	}
+}
+
+// failed returns the response of a failed request. The code is ErrorCodeFailed unless the error
+// is an *Error.
+func failed(id int, err error) *response {
+	code := ErrorCodeFailed
+	if remoteErr, ok := errp.Cause(err).(*Error); ok {
+		code = remoteErr.Code
+	}
+	return &response{ID: id, Error: &responseError{Code: code, Message: err.Error()}}
+}
+
+func succeeded(id int, result interface{}) *response {
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return failed(id, errp.WithStack(err))
+	}
+	return &response{ID: id, Result: resultBytes}
+}
+
+// handle processes a request of the app on the other end of the connection. Requests are processed
+// one after the other per connection, as the device can only show one confirmation at a time.
+func (signer *Signer) handle(secure *secureConn, message []byte, log *logrus.Entry) *response {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return failed(0, invalidRequest(err))
+	}
+	if req.Method == "pair" {
+		var params struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return failed(req.ID, invalidRequest(err))
+		}
+		if err := signer.pair(secure, params.Name, log); err != nil {
+			return failed(req.ID, err)
+		}
+		return succeeded(req.ID, map[string]string{"name": signer.name})
+	}
+	peer := signer.store.Lookup(secure.remoteStatic, RoleNode)
+	if peer == nil {
+		return failed(req.ID, &Error{Code: ErrorCodeNotPaired, Message: "the app is not paired"})
+	}
+	keystores := signer.wallet.Keystores()
+	if keystores.Count() != 1 {
+		return failed(req.ID, &Error{
+			Code:    ErrorCodeNoKeystore,
+			Message: "exactly one keystore has to be connected to the signer",
+		})
+	}
+	result, err := signer.call(keystores, peer, &req, log)
+	if err != nil {
+		return failed(req.ID, err)
+	}
+	return succeeded(req.ID, result)
+}
+
+// invalidRequest is the error of a request which could not be decoded.
+func invalidRequest(err error) error {
+	return errp.WithStack(&Error{Code: ErrorCodeInvalidRequest, Message: err.Error()})
+}
+
+func decodeKeypaths(encoded []string) ([]signing.AbsoluteKeypath, error) {
+	keypaths := make([]signing.AbsoluteKeypath, len(encoded))
+	for index, keypath := range encoded {
+		absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+		if err != nil {
+			return nil, invalidRequest(err)
+		}
+		keypaths[index] = absoluteKeypath
+	}
+	return keypaths, nil
+}
+
+// call executes the request of a paired app with the keystore of the signer. Only the keys of
+// accounts are handed out, and only PSBTs reviewed by the user are signed, so the paired app can't
+// get at the keys signing the data of the app, e.g. the bookmarks.
+func (signer *Signer) call(
+	keystores keystore.Keystores, peer *Peer, req *request, log *logrus.Entry) (interface{}, error) {
+	switch req.Method {
+	case "info":
+		rootFingerprints, err := keystores.RootFingerprints()
+		if err != nil {
+			return nil, err
+		}
+		if len(rootFingerprints) != 1 {
+			return nil, errp.Newf("expected one root fingerprint, got %d", len(rootFingerprints))
+		}
+		return map[string]string{"rootFingerprint": hex.EncodeToString(rootFingerprints[0])}, nil
+	case "xpubs":
+		var params struct {
+			Keypaths []string `json:"keypaths"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidRequest(err)
+		}
+		keypaths, err := decodeKeypaths(params.Keypaths)
+		if err != nil {
+			return nil, err
+		}
+		for index, keypath := range keypaths {
+			if !keypath.IsAccount() {
+				return nil, invalidRequest(
+					errp.Newf("%s is not the keypath of an account", params.Keypaths[index]))
+			}
+		}
+		extendedPublicKeys, err := keystores.BatchExtendedPublicKeys(keypaths)
+		if err != nil {
+			return nil, err
+		}
+		xpubs := make([]string, len(extendedPublicKeys))
+		for index, cosigners := range extendedPublicKeys {
+			xpubs[index] = cosigners[0].String()
+		}
+		return xpubs, nil
+	case "signPSBT":
+		var params struct {
+			PSBT string `json:"psbt"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidRequest(err)
+		}
+		if err := signer.reviewPSBT(peer, params.PSBT, log); err != nil {
+			return nil, err
+		}
+		log.Info("Signing a PSBT for a paired app")
+		return signer.wallet.SignPSBT(params.PSBT)
+	}
+	return nil, invalidRequest(errp.Newf("unknown method %q", req.Method))
+}
+
+// pair asks the user to approve the app on the other end of the connection, after comparing the
+// pairing code shown by both apps.
+func (signer *Signer) pair(secure *secureConn, name string, log *logrus.Entry) error {
+	defer signer.approvalLock.Lock()()
+	if signer.store.Lookup(secure.remoteStatic, RoleNode) != nil {
+		return nil
+	}
+	signer.dropApproval()
+	log.WithField("name", name).Info("Asking to approve the pairing of an app")
+	code := secure.pairingCode()
+	signer.setPending(&PendingPairing{
+		Name:    name,
+		Address: secure.conn.RemoteAddr().String(),
+		Code:    code,
+	})
+	defer signer.setPending(nil)
+	if err := signer.awaitApproval("pairing", code); err != nil {
+		return err
+	}
+	return signer.store.Add(&Peer{
+		PublicKey: hex.EncodeToString(secure.remoteStatic),
+		Role:      RoleNode,
+		Name:      name,
+		Created:   time.Now(),
+	})
+}
+
+// reviewPSBT asks the user to review the PSBT of the paired app, and returns an error unless the
+// user approved signing it. The PSBT is signed as it was shown, so the app can't swap it afterwards.
+func (signer *Signer) reviewPSBT(peer *Peer, encodedPSBT string, log *logrus.Entry) error {
+	defer signer.approvalLock.Lock()()
+	signer.dropApproval()
+	log.WithField("name", peer.Name).Info("Asking to review a PSBT of a paired app")
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return errp.WithStack(err)
+	}
+	pendingPSBT := &PendingPSBT{ID: hex.EncodeToString(id[:]), Name: peer.Name, PSBT: encodedPSBT}
+	signer.setPendingPSBT(pendingPSBT)
+	defer signer.setPendingPSBT(nil)
+	return signer.awaitApproval("PSBT", pendingPSBT.ID)
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotesigner
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// Role is the role of a paired app.
+type Role string
+
+const (
+	// RoleSigner is an app in signer mode, to which this app relays signing requests.
+	RoleSigner Role = "signer"
+	// RoleNode is an app which relays its signing requests to this app in signer mode.
+	RoleNode Role = "node"
+)
+
+// Peer is a paired app.
+type Peer struct {
+	// PublicKey is the hex encoded static public key of the app.
+	PublicKey string `json:"publicKey"`
+	Role      Role   `json:"role"`
+	// Name is the host name of the app, as reported by the app when it was paired.
+	Name string `json:"name"`
+	// Address is the host:port of the app in signer mode. It is empty for RoleNode.
+	Address string    `json:"address"`
+	Created time.Time `json:"created"`
+}
+
+type storeData struct {
+	// Identity is the hex encoded static private key of this app.
+	Identity string  `json:"identity"`
+	Peers    []*Peer `json:"peers"`
+}
+
+// Store persists the static key pair of this app and the paired apps in a JSON file.
+type Store struct {
+	filename string
+	identity *KeyPair
+	peers    []*Peer
+	lock     locker.Locker
+}
+
+// NewStore creates a store persisted in the given file. The key pair and the paired apps are
+// loaded from the file if it exists. Otherwise a new key pair is created and stored.
+func NewStore(filename string) (*Store, error) {
+	store := &Store{filename: filename, peers: []*Peer{}}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if err == nil {
+		var data storeData
+		if err := json.Unmarshal(jsonBytes, &data); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		private, err := hex.DecodeString(data.Identity)
+		if err != nil || len(private) != keySize {
+			return nil, errp.New("invalid identity key")
+		}
+		store.identity, err = keyPairFromPrivate(private)
+		if err != nil {
+			return nil, errp.New("invalid identity key")
+		}
+		if data.Peers != nil {
+			store.peers = data.Peers
+		}
+		return store, nil
+	}
+	identity, err := NewKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	store.identity = identity
+	return store, store.save()
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.MarshalIndent(&storeData{
+		Identity: hex.EncodeToString(store.identity.Private[:]),
+		Peers:    store.peers,
+	}, "", "  ")
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// Identity returns the static key pair of this app.
+func (store *Store) Identity() *KeyPair {
+	return store.identity
+}
+
+// Add stores the peer, replacing an existing peer with the same public key and role.
+func (store *Store) Add(peer *Peer) error {
+	defer store.lock.Lock()()
+	previous := store.peers
+	peers := []*Peer{}
+	for _, existing := range store.peers {
+		if existing.PublicKey != peer.PublicKey || existing.Role != peer.Role {
+			peers = append(peers, existing)
+		}
+	}
+	store.peers = append(peers, peer)
+	if err := store.save(); err != nil {
+		store.peers = previous
+		return err
+	}
+	return nil
+}
+
+// Peers returns all paired apps.
+func (store *Store) Peers() []*Peer {
+	defer store.lock.RLock()()
+	return append([]*Peer{}, store.peers...)
+}
+
+// Remove unpairs the app with the given public key and role.
+func (store *Store) Remove(publicKey string, role Role) error {
+	defer store.lock.Lock()()
+	for index, peer := range store.peers {
+		if peer.PublicKey == publicKey && peer.Role == role {
+			store.peers = append(store.peers[:index:index], store.peers[index+1:]...)
+			return store.save()
+		}
+	}
+	return errp.Newf("unknown %s %s", role, publicKey)
+}
+
+// Lookup returns the paired app with the given public key and role, or nil if there is none.
+func (store *Store) Lookup(publicKey []byte, role Role) *Peer {
+	defer store.lock.RLock()()
+	encoded := hex.EncodeToString(publicKey)
+	for _, peer := range store.peers {
+		if peer.PublicKey == encoded && peer.Role == role {
+			return peer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+)
+
+// BatchTxResult is the outcome of broadcasting one transaction of a batch, see SendTxBatch().
+type BatchTxResult struct {
+	AccountCode string `json:"accountCode"`
+	TxID        string `json:"txID,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SendTxBatch signs the prepared transactions of the accounts of the registered keystores in one
+// session, e.g. to consolidate the coins of several accounts, and broadcasts them. The user unlocks
+// the keystore once and confirms each transaction. If signing fails, no transaction is broadcast.
+// Otherwise, all transactions are broadcast and the results are returned in the same order.
+func (backend *Backend) SendTxBatch(preparedTxs []*btc.PreparedTx) ([]*BatchTxResult, error) {
+	return backend.sendTxBatch(preparedTxs, nil)
+}
+
+// sendTxBatch implements SendTxBatch(). If not nil, onProgress is called with the results so far
+// after each broadcast tx.
+func (backend *Backend) sendTxBatch(
+	preparedTxs []*btc.PreparedTx, onProgress func([]*BatchTxResult)) ([]*BatchTxResult, error) {
+	backend.log.WithField("transactions", len(preparedTxs)).Info("Sending transaction batch")
+	if err := btc.SignTransactions(backend.keystores, preparedTxs); err != nil {
+		return nil, err
+	}
+	results := make([]*BatchTxResult, len(preparedTxs))
+	for index, preparedTx := range preparedTxs {
+		result := &BatchTxResult{AccountCode: preparedTx.Account().Code()}
+		if err := preparedTx.Broadcast(); err != nil {
+			backend.log.WithError(err).WithField("account", result.AccountCode).Error(
+				"Failed to broadcast a transaction of the batch")
+			result.Error = err.Error()
+		} else {
+			result.TxID = preparedTx.TxProposal().Transaction.TxHash().String()
+		}
+		results[index] = result
+		if onProgress != nil {
+			onProgress(results[:index+1])
+		}
+	}
+	return results, nil
+}
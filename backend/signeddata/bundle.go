@@ -0,0 +1,177 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signeddata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// SignedBundle is a bundle as it is published and cached.
+type SignedBundle struct {
+	// Bundle is the JSON encoded bundle. It is kept as a string so that the signed bytes are
+	// preserved exactly.
+	Bundle string `json:"bundle"`
+	// Signature is the hex encoded DER signature of Bundle, see Hash().
+	Signature string `json:"signature"`
+}
+
+// Bundle is a decoded and validated bundle.
+type Bundle interface {
+	// BundleVersion increases with every published bundle. Bundles with a lower or equal version
+	// than the current one are ignored.
+	BundleVersion() uint32
+}
+
+// BundleKind describes a kind of bundles, e.g. the coin metadata.
+type BundleKind struct {
+	Domain Domain
+	// URL is where the latest signed bundle is published.
+	URL string
+	// Name describes the bundles in errors and logs, e.g. "coin metadata".
+	Name string
+	// Decode decodes and validates the JSON encoded bundle after its signature was verified.
+	Decode func([]byte) (Bundle, error)
+}
+
+// Verify checks the signature of the signed bundle against the given public key and returns the
+// decoded bundle.
+func (kind *BundleKind) Verify(signed *SignedBundle, publicKey *btcec.PublicKey) (Bundle, error) {
+	if err := Verify(kind.Domain, []byte(signed.Bundle), signed.Signature, publicKey); err != nil {
+		return nil, errp.WithMessage(err, "Failed to verify the "+kind.Name)
+	}
+	return kind.Decode([]byte(signed.Bundle))
+}
+
+// BundleCache serves the newest bundle of a kind known: the one built into the app, if any, or a
+// newer verified one which was fetched and cached in a file.
+type BundleCache struct {
+	kind          *BundleKind
+	cacheFilename string
+	// publicKey verifies fetched and cached bundles. If nil, only the built in bundle is used.
+	publicKey *btcec.PublicKey
+
+	// bundle is the bundle in use, or nil.
+	bundle Bundle
+	lock   locker.Locker
+
+	log *logrus.Entry
+}
+
+// NewBundleCache creates a cache which stores fetched bundles in the given file. The cached bundle
+// is used if it is valid and newer than the built in one, which can be nil.
+func NewBundleCache(
+	kind *BundleKind,
+	cacheFilename string,
+	publicKey *btcec.PublicKey,
+	builtin Bundle,
+	log *logrus.Entry) *BundleCache {
+	cache := &BundleCache{
+		kind:          kind,
+		cacheFilename: cacheFilename,
+		publicKey:     publicKey,
+		bundle:        builtin,
+		log:           log,
+	}
+	cache.load()
+	return cache
+}
+
+func (cache *BundleCache) load() {
+	if cache.publicKey == nil {
+		return
+	}
+	jsonBytes, err := ioutil.ReadFile(cache.cacheFilename)
+	if err != nil {
+		return
+	}
+	signed := &SignedBundle{}
+	if err := json.Unmarshal(jsonBytes, signed); err != nil {
+		cache.log.WithError(err).Errorf("Ignoring the cached %s", cache.kind.Name)
+		return
+	}
+	if _, err := cache.update(signed, false); err != nil {
+		cache.log.WithError(err).Errorf("Ignoring the cached %s", cache.kind.Name)
+	}
+}
+
+// Bundle returns the bundle in use, or nil if there is none.
+func (cache *BundleCache) Bundle() Bundle {
+	defer cache.lock.RLock()()
+	return cache.bundle
+}
+
+// Update verifies the signed bundle and uses it if it is newer than the current one. The bundle is
+// then cached, so that it is used after a restart. Returns true if the bundle was applied.
+func (cache *BundleCache) Update(signed *SignedBundle) (bool, error) {
+	return cache.update(signed, true)
+}
+
+func (cache *BundleCache) update(signed *SignedBundle, store bool) (bool, error) {
+	if cache.publicKey == nil {
+		return false, errp.Newf("This build does not support %s updates", cache.kind.Name)
+	}
+	bundle, err := cache.kind.Verify(signed, cache.publicKey)
+	if err != nil {
+		return false, err
+	}
+	defer cache.lock.Lock()()
+	if cache.bundle != nil && bundle.BundleVersion() <= cache.bundle.BundleVersion() {
+		return false, nil
+	}
+	if store {
+		jsonBytes, err := json.Marshal(signed)
+		if err != nil {
+			return false, errp.WithStack(err)
+		}
+		if err := ioutil.WriteFile(cache.cacheFilename, jsonBytes, 0600); err != nil {
+			return false, errp.WithStack(err)
+		}
+	}
+	cache.bundle = bundle
+	cache.log.WithField("version", bundle.BundleVersion()).Infof("Using updated %s", cache.kind.Name)
+	return true, nil
+}
+
+// Refresh fetches the latest signed bundle from the URL of the kind and applies it if it is newer.
+// Returns true if the bundle changed.
+func (cache *BundleCache) Refresh() (bool, error) {
+	if cache.publicKey == nil {
+		return false, nil
+	}
+	response, err := egress.Get(cache.kind.URL, egress.PurposeUpdate)
+	if err != nil {
+		return false, errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return false, errp.Newf("fetching the %s failed with status %d", cache.kind.Name, response.StatusCode)
+	}
+	signed := &SignedBundle{}
+	if err := json.NewDecoder(response.Body).Decode(signed); err != nil {
+		return false, errp.WithStack(err)
+	}
+	return cache.Update(signed)
+}
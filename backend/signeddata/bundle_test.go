@@ -0,0 +1,104 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signeddata_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+type testBundle struct {
+	Version uint32 `json:"version"`
+}
+
+func (bundle *testBundle) BundleVersion() uint32 {
+	return bundle.Version
+}
+
+var testKind = &signeddata.BundleKind{
+	Domain: signeddata.DomainFeatureRules,
+	Name:   "test bundle",
+	Decode: func(bundleBytes []byte) (signeddata.Bundle, error) {
+		bundle := &testBundle{}
+		if err := json.Unmarshal(bundleBytes, bundle); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		return bundle, nil
+	},
+}
+
+func signBundle(t *testing.T, privateKey *btcec.PrivateKey, version uint32) *signeddata.SignedBundle {
+	bundleBytes, err := json.Marshal(&testBundle{Version: version})
+	require.NoError(t, err)
+	signature, err := privateKey.Sign(signeddata.Hash(testKind.Domain, bundleBytes))
+	require.NoError(t, err)
+	return &signeddata.SignedBundle{
+		Bundle:    string(bundleBytes),
+		Signature: hex.EncodeToString(signature.Serialize()),
+	}
+}
+
+func TestBundleCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signeddata_test")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	cacheFilename := path.Join(dir, "bundle.json")
+	log := logging.Get().WithGroup("signeddata_test")
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+
+	cache := signeddata.NewBundleCache(testKind, cacheFilename, privateKey.PubKey(), nil, log)
+	require.Nil(t, cache.Bundle())
+	_, err = cache.Update(signBundle(t, otherKey, 1))
+	require.Error(t, err)
+	updated, err := cache.Update(signBundle(t, privateKey, 2))
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, uint32(2), cache.Bundle().BundleVersion())
+	// Older bundles are ignored.
+	updated, err = cache.Update(signBundle(t, privateKey, 2))
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	// The cached bundle replaces an older built in one, but only with the right key.
+	cache = signeddata.NewBundleCache(testKind, cacheFilename, privateKey.PubKey(), &testBundle{Version: 1}, log)
+	require.Equal(t, uint32(2), cache.Bundle().BundleVersion())
+	cache = signeddata.NewBundleCache(testKind, cacheFilename, privateKey.PubKey(), &testBundle{Version: 3}, log)
+	require.Equal(t, uint32(3), cache.Bundle().BundleVersion())
+	cache = signeddata.NewBundleCache(testKind, cacheFilename, otherKey.PubKey(), &testBundle{Version: 1}, log)
+	require.Equal(t, uint32(1), cache.Bundle().BundleVersion())
+
+	// Without a key, only the built in bundle is used.
+	cache = signeddata.NewBundleCache(testKind, cacheFilename, nil, &testBundle{Version: 1}, log)
+	require.Equal(t, uint32(1), cache.Bundle().BundleVersion())
+	_, err = cache.Update(signBundle(t, privateKey, 4))
+	require.Error(t, err)
+	updated, err = cache.Refresh()
+	require.NoError(t, err)
+	require.False(t, updated)
+}
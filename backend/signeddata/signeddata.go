@@ -0,0 +1,94 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signeddata verifies data signed by the app developers, e.g. coin definitions or feature
+// rules. The same key signs all kinds of data, so each kind has its own domain tag, which is hashed
+// together with the data (a tagged hash as in BIP340). A signature of one kind of data is thus never
+// valid for another kind, even if the signed bytes happen to parse as both. Versioned bundles of
+// signed data are fetched and cached with a BundleCache.
+//
+// The public key trusted to sign the data is set at build time with
+// -ldflags "-X github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata.trustedPublicKeyHex=<hex>".
+// Builds without it do not accept signed data.
+package signeddata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// trustedPublicKeyHex is the compressed public key, in hex format, which signs the data.
+var trustedPublicKeyHex string
+
+// Domain is the tag of a kind of signed data.
+type Domain string
+
+const (
+	// DomainCoinDefinition tags the definitions of custom coins.
+	DomainCoinDefinition Domain = "BitBoxApp/coinDefinition"
+	// DomainCoinMetadata tags the bundles of coin metadata.
+	DomainCoinMetadata Domain = "BitBoxApp/coinMetadata"
+	// DomainFeatureRules tags the bundles of feature rules.
+	DomainFeatureRules Domain = "BitBoxApp/featureRules"
+)
+
+// TrustedPublicKey returns the public key trusted to sign the data, or an error if the build does
+// not accept signed data.
+func TrustedPublicKey() (*btcec.PublicKey, error) {
+	if trustedPublicKeyHex == "" {
+		return nil, errp.New("This build does not accept signed data")
+	}
+	publicKeyBytes, err := hex.DecodeString(trustedPublicKeyHex)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	publicKey, err := btcec.ParsePubKey(publicKeyBytes, btcec.S256())
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return publicKey, nil
+}
+
+// Hash returns the hash which is signed for the data of the given domain:
+// sha256(sha256(domain) || sha256(domain) || data).
+func Hash(domain Domain, data []byte) []byte {
+	tagHash := sha256.Sum256([]byte(domain))
+	hasher := sha256.New()
+	// Writing to a hash.Hash never returns an error.
+	_, _ = hasher.Write(tagHash[:])
+	_, _ = hasher.Write(tagHash[:])
+	_, _ = hasher.Write(data)
+	return hasher.Sum(nil)
+}
+
+// Verify checks the hex encoded DER signature of the data of the given domain against the public
+// key.
+func Verify(domain Domain, data []byte, signatureHex string, publicKey *btcec.PublicKey) error {
+	signatureBytes, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	signature, err := btcec.ParseDERSignature(signatureBytes, btcec.S256())
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if !signature.Verify(Hash(domain, data), publicKey) {
+		return errp.New("The signature is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signeddata_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signeddata"
+)
+
+func TestVerify(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	data := []byte(`{"version":1}`)
+	signature, err := privateKey.Sign(signeddata.Hash(signeddata.DomainFeatureRules, data))
+	require.NoError(t, err)
+	signatureHex := hex.EncodeToString(signature.Serialize())
+
+	require.NoError(t, signeddata.Verify(
+		signeddata.DomainFeatureRules, data, signatureHex, privateKey.PubKey()))
+	// The signature is not valid for the same data in another domain.
+	require.Error(t, signeddata.Verify(
+		signeddata.DomainCoinMetadata, data, signatureHex, privateKey.PubKey()))
+	require.Error(t, signeddata.Verify(
+		signeddata.DomainFeatureRules, []byte(`{"version":2}`), signatureHex, privateKey.PubKey()))
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	require.Error(t, signeddata.Verify(
+		signeddata.DomainFeatureRules, data, signatureHex, otherKey.PubKey()))
+	require.Error(t, signeddata.Verify(
+		signeddata.DomainFeatureRules, data, "not hex", privateKey.PubKey()))
+}
+
+func TestTrustedPublicKey(t *testing.T) {
+	// No key is set in test builds.
+	_, err := signeddata.TrustedPublicKey()
+	require.Error(t, err)
+}
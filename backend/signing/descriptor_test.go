@@ -0,0 +1,37 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing_test
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	// Test vectors from BIP380 and BIP381.
+	checksum, err := signing.DescriptorChecksum("raw(deadbeef)")
+	assert.NoError(t, err)
+	assert.Equal(t, "89f8spxm", checksum)
+
+	checksum, err = signing.DescriptorChecksum(
+		"pkh([d34db33f/44'/0'/0']xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/1/*)")
+	assert.NoError(t, err)
+	assert.Equal(t, "ml40v0wf", checksum)
+
+	_, err = signing.DescriptorChecksum("raw(deadbeef)é")
+	assert.Error(t, err)
+}
@@ -185,6 +185,25 @@ func (absoluteKeypath AbsoluteKeypath) Append(suffix RelativeKeypath) AbsoluteKe
 	return append(newKeypath, suffix...)
 }
 
+// IsAccount returns whether the keypath is the keypath of a singlesig account, i.e.
+// m/purpose'/coin'/account' with the purpose of BIP44, BIP49 or BIP84. Keys at other keypaths, e.g.
+// the ones signing the data of the app, must not be handed out to other apps.
+func (absoluteKeypath AbsoluteKeypath) IsAccount() bool {
+	if len(absoluteKeypath) != 3 {
+		return false
+	}
+	for _, node := range absoluteKeypath {
+		if !node.hardened {
+			return false
+		}
+	}
+	switch absoluteKeypath[0].index {
+	case 44, 49, 84:
+		return true
+	}
+	return false
+}
+
 // Derive derives the extended key at this path from the given extended key.
 func (absoluteKeypath AbsoluteKeypath) Derive(
 	extendedKey *hdkeychain.ExtendedKey,
@@ -39,3 +39,18 @@ func TestKeypath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, absoluteKeypath.Encode(), decodedKeypath.Encode())
 }
+
+func TestIsAccount(t *testing.T) {
+	for _, keypath := range []string{"m/44'/0'/0'", "m/49'/1'/0'", "m/84'/0'/3'"} {
+		absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+		assert.NoError(t, err)
+		assert.True(t, absoluteKeypath.IsAccount(), keypath)
+	}
+	for _, keypath := range []string{
+		"m/", "m/84'/0'", "m/84'/0'/0'/0", "m/84'/0'/0", "m/20180'/0'/0'", "m/47'/0'/0'",
+	} {
+		absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+		assert.NoError(t, err)
+		assert.False(t, absoluteKeypath.IsAccount(), keypath)
+	}
+}
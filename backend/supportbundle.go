@@ -0,0 +1,176 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb/health"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/supportbundle"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// maxSupportLogSize is the size of the end of the log included in a support bundle.
+const maxSupportLogSize = 2 * 1024 * 1024
+
+// SupportBundle is a created support bundle.
+type SupportBundle struct {
+	// Filename is where the archive was written.
+	Filename string `json:"filename"`
+	// SHA256 is the hash of the archive, which the user can tell support to identify it.
+	SHA256   string                  `json:"sha256"`
+	Manifest *supportbundle.Manifest `json:"manifest"`
+	// Archive is the zip archive.
+	Archive []byte `json:"-"`
+}
+
+type supportDevice struct {
+	ProductName string        `json:"productName"`
+	Health      *health.Stats `json:"health,omitempty"`
+}
+
+type supportAccount struct {
+	Code            string `json:"code"`
+	Coin            string `json:"coin"`
+	Offline         bool   `json:"offline"`
+	InitialSyncDone bool   `json:"initialSyncDone"`
+}
+
+// supportDiagnostics collects the reports of the backend for a support bundle. It does not include
+// addresses, amounts or extended public keys.
+func (backend *Backend) supportDiagnostics() map[string]interface{} {
+	devices := map[string]*supportDevice{}
+	for deviceID, device := range backend.devices {
+		supportDevice := &supportDevice{ProductName: device.ProductName()}
+		if bitboxDevice, ok := device.(*bitbox.Device); ok {
+			supportDevice.Health = bitboxDevice.Health()
+		}
+		devices[deviceID] = supportDevice
+	}
+	accounts := []*supportAccount{}
+	func() {
+		defer backend.accountsLock.RLock()()
+		for _, account := range backend.accounts {
+			accounts = append(accounts, &supportAccount{
+				Code:            account.Code(),
+				Coin:            account.Coin().Name(),
+				Offline:         account.Offline(),
+				InitialSyncDone: account.InitialSyncDone(),
+			})
+		}
+	}()
+	return map[string]interface{}{
+		"dataDirectory":  backend.dataDirectoryReport,
+		"usb":            backend.usbManager.Diagnostics(),
+		"egress":         backend.egress.Report(),
+		"accountsStatus": backend.AccountsStatus(),
+		"devices":        devices,
+		"accounts":       accounts,
+	}
+}
+
+// supportLog returns the end of the log file, or a note if the log is not written to a file.
+func supportLog() []byte {
+	filename := logging.Get().Filename()
+	if filename == "" {
+		return []byte("The log is not written to a file.\n")
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return []byte(fmt.Sprintf("The log could not be read: %v\n", err))
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if info, err := file.Stat(); err == nil && info.Size() > maxSupportLogSize {
+		if _, err := file.Seek(info.Size()-maxSupportLogSize, 0); err != nil {
+			return []byte(fmt.Sprintf("The log could not be read: %v\n", err))
+		}
+	}
+	log, err := ioutil.ReadAll(file)
+	if err != nil {
+		return []byte(fmt.Sprintf("The log could not be read: %v\n", err))
+	}
+	return supportbundle.RedactLog(log)
+}
+
+// CreateSupportBundle writes an archive with the version, the config without secrets, the
+// diagnostic reports and the end of the log to the support directory in the app directory.
+func (backend *Backend) CreateSupportBundle() (*SupportBundle, error) {
+	created := time.Now()
+	marshal := func(value interface{}) ([]byte, error) {
+		jsonBytes, err := json.MarshalIndent(value, "", "  ")
+		return jsonBytes, errp.WithStack(err)
+	}
+	version, err := marshal(map[string]interface{}{
+		"version":   Version.String(),
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+		"goVersion": runtime.Version(),
+		"testing":   backend.arguments.Testing(),
+		"regtest":   backend.arguments.Regtest(),
+		"multisig":  backend.arguments.Multisig(),
+		"demoMode":  backend.demoFixture != nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	appConfig, err := marshal(supportbundle.RedactConfig(backend.config.Config()))
+	if err != nil {
+		return nil, err
+	}
+	diagnostics, err := marshal(backend.supportDiagnostics())
+	if err != nil {
+		return nil, err
+	}
+	var archive bytes.Buffer
+	manifest, err := supportbundle.Write(&archive, map[string][]byte{
+		"version.json":     version,
+		"config.json":      appConfig,
+		"diagnostics.json": diagnostics,
+		"log.txt":          supportLog(),
+	}, created)
+	if err != nil {
+		return nil, err
+	}
+	directory := path.Join(backend.arguments.MainDirectoryPath(), "support")
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	filename := path.Join(directory,
+		fmt.Sprintf("bitbox-support-%s.zip", created.Format("2006-01-02-15-04-05")))
+	if err := ioutil.WriteFile(filename, archive.Bytes(), 0600); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	hash := sha256.Sum256(archive.Bytes())
+	backend.log.WithField("filename", filename).Info("Created support bundle")
+	return &SupportBundle{
+		Filename: filename,
+		SHA256:   hex.EncodeToString(hash[:]),
+		Manifest: manifest,
+		Archive:  archive.Bytes(),
+	}, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package supportbundle creates the archives users send to support. The archives contain the
+// logs, the config and diagnostic reports, with secrets removed, and a manifest with the SHA256
+// hash of each file, so that support can check that the archive is complete and unmodified.
+package supportbundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ManifestName is the name of the manifest in the archive.
+const ManifestName = "manifest.json"
+
+// Redacted replaces the removed secrets.
+const Redacted = "<redacted>"
+
+// extendedKeyRegexp matches serialized extended public keys, e.g. xpub, ypub, zpub and tpub. They
+// would reveal the addresses and thus the balances of the user.
+var extendedKeyRegexp = regexp.MustCompile(`\b[xyzuvt]pub[1-9A-HJ-NP-Za-km-z]{100,112}\b`)
+
+// RedactLog removes the extended public keys from the log.
+func RedactLog(log []byte) []byte {
+	return extendedKeyRegexp.ReplaceAll(log, []byte(Redacted))
+}
+
+// redactURL removes the credentials from the URL, if any.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Redacted
+	}
+	if parsed.User != nil {
+		parsed.User = url.User(Redacted)
+	}
+	return parsed.String()
+}
+
+// RedactConfig returns a copy of the config without credentials and API keys.
+func RedactConfig(appConfig config.AppConfig) config.AppConfig {
+	backup := &appConfig.Backend.MetadataBackup
+	if backup.Username != "" {
+		backup.Username = Redacted
+	}
+	if backup.Password != "" {
+		backup.Password = Redacted
+	}
+	if backup.URL != "" {
+		backup.URL = redactURL(backup.URL)
+	}
+	if appConfig.Backend.RatesStreamAPIKey != "" {
+		appConfig.Backend.RatesStreamAPIKey = Redacted
+	}
+	return appConfig
+}
+
+// FileHash is the manifest entry of a file in the archive.
+type FileHash struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists the files of an archive.
+type Manifest struct {
+	Created time.Time   `json:"created"`
+	Files   []*FileHash `json:"files"`
+}
+
+func hashHex(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// Write writes a zip archive with the given files, by name, and the manifest. The files are
+// sorted by name and the manifest comes last.
+func Write(writer io.Writer, files map[string][]byte, created time.Time) (*Manifest, error) {
+	if _, ok := files[ManifestName]; ok {
+		return nil, errp.Newf("%s is reserved for the manifest", ManifestName)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	manifest := &Manifest{Created: created, Files: make([]*FileHash, len(names))}
+	archive := zip.NewWriter(writer)
+	writeFile := func(name string, content []byte) error {
+		fileWriter, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: created,
+		})
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		_, err = fileWriter.Write(content)
+		return errp.WithStack(err)
+	}
+	for index, name := range names {
+		content := files[name]
+		if err := writeFile(name, content); err != nil {
+			return nil, err
+		}
+		manifest.Files[index] = &FileHash{Name: name, Size: len(content), SHA256: hashHex(content)}
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := writeFile(ManifestName, manifestJSON); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return manifest, nil
+}
+
+// Verify checks that the archive contains exactly the files listed in its manifest, with the
+// listed hashes, and returns the manifest.
+func Verify(archive []byte) (*Manifest, error) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	contents := map[string][]byte{}
+	for _, file := range reader.File {
+		fileReader, err := file.Open()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		content, err := ioutil.ReadAll(fileReader)
+		_ = fileReader.Close()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		contents[file.Name] = content
+	}
+	manifestJSON, ok := contents[ManifestName]
+	if !ok {
+		return nil, errp.New("the manifest is missing")
+	}
+	delete(contents, ManifestName)
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestJSON, manifest); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if len(manifest.Files) != len(contents) {
+		return nil, errp.New("the archive does not match the manifest")
+	}
+	for _, fileHash := range manifest.Files {
+		content, ok := contents[fileHash.Name]
+		if !ok {
+			return nil, errp.Newf("%s is missing", fileHash.Name)
+		}
+		if hashHex(content) != fileHash.SHA256 {
+			return nil, errp.Newf("%s was modified", fileHash.Name)
+		}
+	}
+	return manifest, nil
+}
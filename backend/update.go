@@ -16,8 +16,8 @@ package backend
 
 import (
 	"encoding/json"
-	"net/http"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
 )
@@ -48,7 +48,7 @@ type updateEvent struct {
 
 // CheckForUpdate checks whether a newer version of this application has been released.
 func (backend *Backend) checkForUpdate() error {
-	response, err := http.Get(updateFileURL)
+	response, err := egress.Get(updateFileURL, egress.PurposeUpdate)
 	if err != nil {
 		return errp.WithStack(err)
 	}
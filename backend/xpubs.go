@@ -0,0 +1,57 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+)
+
+// prefetchExtendedPublicKeys fetches the extended public keys of all accounts at once, instead of
+// one by one when each account is initialized, which takes a round trip to the device per query. If
+// it fails, the accounts fetch their keys themselves.
+func (backend *Backend) prefetchExtendedPublicKeys() {
+	extendedPublicKeys := map[string][]*hdkeychain.ExtendedKey{}
+	if len(backend.accountKeypaths) > 0 {
+		batch, err := backend.keystores.BatchExtendedPublicKeys(backend.accountKeypaths)
+		if err != nil {
+			backend.log.WithError(err).Warning("Prefetching the extended public keys failed")
+		} else {
+			for i, keypath := range backend.accountKeypaths {
+				extendedPublicKeys[keypath.Encode()] = batch[i]
+			}
+		}
+	}
+	defer backend.extendedPublicKeysLock.Lock()()
+	backend.extendedPublicKeys = extendedPublicKeys
+}
+
+// prefetchedExtendedPublicKeys returns the extended public keys of all keystores at the keypath, if
+// they were prefetched for the current keystores.
+func (backend *Backend) prefetchedExtendedPublicKeys(
+	keypath signing.AbsoluteKeypath) ([]*hdkeychain.ExtendedKey, bool) {
+	defer backend.extendedPublicKeysLock.RLock()()
+	extendedPublicKeys, ok := backend.extendedPublicKeys[keypath.Encode()]
+	if !ok || len(extendedPublicKeys) != backend.keystores.Count() {
+		return nil, false
+	}
+	return extendedPublicKeys, true
+}
+
+// clearExtendedPublicKeys drops the prefetched keys, e.g. when the keystores change.
+func (backend *Backend) clearExtendedPublicKeys() {
+	defer backend.extendedPublicKeysLock.Lock()()
+	backend.extendedPublicKeys = nil
+}
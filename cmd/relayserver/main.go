@@ -0,0 +1,47 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// pollTimeout matches the polling interval of the default relay server.
+const pollTimeout = 10 * time.Second
+
+// relayserver relays the messages between the app and the mobile app used for 2FA, as an
+// alternative to the default relay server, e.g. in the local network. Set its URL as the relay
+// server in the app settings before pairing.
+func main() {
+	address := flag.String("address", "0.0.0.0", "address to listen on")
+	port := flag.Int("port", 8083, "port to listen on")
+	flag.Parse()
+
+	logging.Set(&logging.Configuration{Output: "STDERR", Level: logrus.InfoLevel})
+	log := logging.Get().WithGroup("relayserver")
+	log.WithFields(logrus.Fields{"address": *address, "port": *port}).Info("Listening for HTTP")
+	listen := fmt.Sprintf("%s:%d", *address, *port)
+	if err := http.ListenAndServe(listen, relay.NewMailbox(pollTimeout)); err != nil {
+		log.WithError(err).Error("Failed to listen for HTTP")
+	}
+}
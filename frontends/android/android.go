@@ -16,14 +16,23 @@ package android
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
 	backendHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/handlers"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
 )
 
+var (
+	// connectionData is set by Serve() and used to grant capabilities to the screens of the app.
+	connectionData     *backendHandlers.ConnectionData
+	connectionDataLock locker.Locker
+)
+
 // Serve serves the BitBox Wallet API for use in a mobile client.
 func Serve() {
 	log := logging.Get().WithGroup("android")
@@ -31,11 +40,41 @@ func Serve() {
 	if err != nil {
 		log.WithError(err).Fatal("Failed to generate random string")
 	}
-	connectionData := backendHandlers.NewConnectionData(8082, token)
-	backend := backend.NewBackend(arguments.NewArguments(".", false, false, false, false))
-	handlers := backendHandlers.NewHandlers(backend, connectionData)
+	connData := backendHandlers.NewConnectionData(8082, token)
+	unlock := connectionDataLock.Lock()
+	connectionData = connData
+	unlock()
+	backend := backend.NewBackend(arguments.NewArguments(".", false, false, false, false, "", ""))
+	handlers := backendHandlers.NewHandlers(backend, connData)
 	err = http.ListenAndServe("localhost:8082", handlers.Router)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// GrantCapabilities returns a session token for the API which grants only the given
+// comma-separated capabilities ("read-only", "send", "device-admin"). The mobile shell requests a
+// token for each screen with the capabilities the screen needs, and passes it to the webview
+// instead of the token of the app.
+func GrantCapabilities(capabilities string) (string, error) {
+	defer connectionDataLock.RLock()()
+	if connectionData == nil {
+		return "", errp.New("the API is not served yet")
+	}
+	granted := []backendHandlers.Capability{}
+	for _, capability := range strings.Split(capabilities, ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			granted = append(granted, backendHandlers.Capability(capability))
+		}
+	}
+	return connectionData.GrantCapabilities(granted)
+}
+
+// RevokeCapabilities invalidates a session token returned by GrantCapabilities(), e.g. when the
+// screen using it is closed.
+func RevokeCapabilities(token string) {
+	defer connectionDataLock.RLock()()
+	if connectionData != nil {
+		connectionData.RevokeCapabilities(token)
+	}
+}
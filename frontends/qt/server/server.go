@@ -156,7 +156,7 @@ func serve(pushNotificationsCallback C.pushNotificationsCallback, theResponseCal
 	const port = -1
 	connectionData := backendHandlers.NewConnectionData(port, token)
 	theBackend := backend.NewBackend(arguments.NewArguments(
-		config.AppDir(), *testnet, false, false, false))
+		config.AppDir(), *testnet, false, false, false, "", ""))
 	events := theBackend.Events()
 	go func() {
 		for {
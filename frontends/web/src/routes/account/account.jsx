@@ -37,6 +37,7 @@ export default class Account extends Component {
         connected: false,
         balance: null,
         hasCard: false,
+        watchedTxs: [],
     }
 
     componentWillMount() {
@@ -103,6 +104,9 @@ export default class Account extends Component {
         case 'syncdone':
             this.onAccountChanged();
             break;
+        case 'watchedTxAlert':
+            this.loadWatchedTxs();
+            break;
         }
     }
 
@@ -123,6 +127,13 @@ export default class Account extends Component {
         });
     }
 
+    loadWatchedTxs = () => {
+        if (!this.props.code) return;
+        apiGet(`account/${this.props.code}/watched-txs`).then(watchedTxs => {
+            this.setState({ watchedTxs });
+        });
+    }
+
     onAccountChanged = () => {
         if (!this.props.code) return;
         if (this.state.initialized && this.state.connected) {
@@ -132,6 +143,7 @@ export default class Account extends Component {
             apiGet(`account/${this.props.code}/transactions`).then(transactions => {
                 this.setState({ transactions });
             });
+            this.loadWatchedTxs();
         } else {
             this.setState({ balance: null });
             this.setState({ transactions: [] });
@@ -151,6 +163,7 @@ export default class Account extends Component {
         connected,
         balance,
         hasCard,
+        watchedTxs,
     }) {
         if (!accounts) return null;
         const account = accounts.find(({ code }) => code === this.props.code);
@@ -193,6 +206,13 @@ export default class Account extends Component {
                                     </Status>
                                 )
                             }
+                            {
+                                watchedTxs.filter(({ state }) => state === 'broadcastable' || state === 'conflicted').map(({ txID, state }) => (
+                                    <Status key={txID} type="warning">
+                                        <p>{t(`account.watchedTx.${state}`, { txID })}</p>
+                                    </Status>
+                                ))
+                            }
                         </div>
                     </div>
                     <div class={['innerContainer', ''].join(' ')}>
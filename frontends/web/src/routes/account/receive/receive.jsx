@@ -24,6 +24,9 @@ import Status from '../../../components/status/status';
 import QRCode from '../../../components/qrcode/qrcode';
 import style from './receive.css';
 
+// batchSize is the number of addresses verified at once, e.g. to hand out several invoice addresses.
+const batchSize = 5;
+
 @translate()
 export default class Receive extends Component {
     state = {
@@ -69,6 +72,19 @@ export default class Receive extends Component {
         });
     }
 
+    verifyAddresses = () => {
+        this.setState({ verifying: true });
+        apiPost('account/' + this.props.code + '/verify-addresses', batchSize).then(verifications => {
+            const receiveAddresses = this.state.receiveAddresses.map(receiveAddress => {
+                const verification = verifications.find(v => v.scriptHashHex === receiveAddress.scriptHashHex);
+                return verification ? Object.assign({}, receiveAddress, { verification }) : receiveAddress;
+            });
+            this.setState({ verifying: false, receiveAddresses });
+        }).catch(() => {
+            this.setState({ verifying: false });
+        });
+    }
+
     previous = () => {
         this.setState(({ activeIndex, receiveAddresses }) => ({
             activeIndex: (activeIndex + receiveAddresses.length - 1) % receiveAddresses.length
@@ -131,6 +147,11 @@ export default class Receive extends Component {
                         {t('button.next')}
                     </Button>
                 </p>
+                { receiveAddresses[activeIndex].verification && (
+                    <p class="label">
+                        {t(`receive.verification.${receiveAddresses[activeIndex].verification.status}`)}
+                    </p>
+                ) }
                 { code === 'ltc-p2wpkh-p2sh' && (
                     <div>
                         <p>{t('receive.ltcLegacy.info')}</p>
@@ -172,6 +193,12 @@ export default class Receive extends Component {
                                 href={`/account/${code}`}>
                                 {t('button.back')}
                             </ButtonLink>
+                            <Button
+                                secondary
+                                disabled={verifying || paired === false}
+                                onClick={this.verifyAddresses}>
+                                {t('receive.verifyBatch', { count: batchSize })}
+                            </Button>
                             <Button
                                 primary
                                 disabled={verifying}
@@ -42,6 +42,7 @@ export default class Send extends Component {
             amount: null,
             feeTarget: null,
             proposedFee: null,
+            proposedFeeDetails: null,
             proposedAmount: null,
             proposedTotal: null,
             valid: false,
@@ -57,6 +58,10 @@ export default class Send extends Component {
             signProgress: null,
             signConfirm: null, // show visual BitBox in dialog when instructed to sign.
             coinControl: false,
+            overrideFeeCap: false,
+            disableRBF: false,
+            bookmark: null,
+            offlineTx: null,
         };
         this.selectedUTXOs = [];
     }
@@ -112,6 +117,7 @@ export default class Send extends Component {
                     recipientAddress: null,
                     proposedAmount: null,
                     proposedFee: null,
+                    proposedFeeDetails: null,
                     proposedTotal: null,
                     fiatAmount: null,
                     amount: null,
@@ -133,12 +139,46 @@ export default class Send extends Component {
         });
     }
 
+    signOffline = () => {
+        this.setState({ signProgress: null, isConfirming: true, offlineTx: null });
+        apiPost('account/' + this.getAccount().code + '/offline-tx', this.txInput()).then(result => {
+            if (result.success) {
+                this.setState({ offlineTx: result });
+            } else if (result.errorCode === 'aborted') {
+                this.setState({ isAborted: true });
+                setTimeout(() => this.setState({ isAborted: false }), 5000);
+            } else if (result.errorMessage) {
+                alert(result.errorMessage); // eslint-disable-line no-alert
+            }
+            this.setState({ isConfirming: false, signProgress: null, signConfirm: null });
+        }).catch(() => {
+            this.setState({ isConfirming: false, signProgress: null, signConfirm: null });
+        });
+    }
+
+    cancelOfflineTx = () => {
+        const { offlineTx } = this.state;
+        if (!confirm(this.props.t('send.offline.cancelConfirm'))) { // eslint-disable-line no-alert
+            return;
+        }
+        apiPost('account/' + this.getAccount().code + '/offline-tx/cancel', offlineTx.txID).then(result => {
+            if (result.success) {
+                this.setState({ offlineTx: null });
+            } else if (result.errorMessage) {
+                alert(result.errorMessage); // eslint-disable-line no-alert
+            }
+        });
+    }
+
     txInput = () => ({
         address: this.state.recipientAddress,
         amount: this.state.amount,
         feeTarget: this.state.feeTarget,
         sendAll: this.state.sendAll ? 'yes' : 'no',
         selectedUTXOs: Object.keys(this.selectedUTXOs),
+        overrideFeeCap: this.state.overrideFeeCap,
+        disableRBF: this.state.disableRBF,
+        fiatUnit: this.state.fiatUnit,
     })
 
     sendDisabled = () => {
@@ -161,6 +201,7 @@ export default class Send extends Component {
             if (result.success) {
                 this.setState({
                     proposedFee: result.fee,
+                    proposedFeeDetails: result.feeDetails,
                     proposedAmount: result.amount,
                     proposedTotal: result.total,
                 });
@@ -168,15 +209,23 @@ export default class Send extends Component {
                     this.convertToFiat(result.amount.amount);
                 }
             } else {
-                const error = result.errMsg;
-                switch (error) {
-                case 'invalid address':
+                const error = result.errorMessage;
+                switch (result.errorCode) {
+                case 'invalidAddress':
                     this.setState({ addressError: error });
                     break;
-                case 'invalid amount':
-                case 'insufficient funds':
+                case 'invalidAmount':
+                case 'insufficientFunds':
                     this.setState({ amountError: error });
                     break;
+                case 'feeCapExceeded':
+                    if (confirm(error)) { // eslint-disable-line no-alert
+                        this.setState({ overrideFeeCap: true });
+                        this.validateAndDisplayFee(updateFiat);
+                    } else {
+                        this.setState({ proposedFee: null });
+                    }
+                    break;
                 default:
                     this.setState({ proposedFee: null });
                     if (error) {
@@ -201,10 +250,24 @@ export default class Send extends Component {
         } else if (event.target.id === 'amount') {
             this.convertToFiat(value);
         }
-        this.setState({ [event.target.id]: value });
+        this.setState({ [event.target.id]: value, overrideFeeCap: false });
+        if (event.target.id === 'recipientAddress') {
+            this.checkBookmark(value);
+        }
         this.validateAndDisplayFee(true);
     }
 
+    checkBookmark = address => {
+        apiPost('bookmarks/check', {
+            coinCode: this.getAccount().coinCode,
+            address,
+        }).then(bookmark => {
+            if (address === this.state.recipientAddress) {
+                this.setState({ bookmark });
+            }
+        });
+    }
+
     handleFiatInput = event => {
         const value = event.target.value;
         this.setState({ fiatAmount: value });
@@ -284,6 +347,7 @@ export default class Send extends Component {
     }, {
         balance,
         proposedFee,
+        proposedFeeDetails,
         proposedTotal,
         recipientAddress,
         proposedAmount,
@@ -302,6 +366,9 @@ export default class Send extends Component {
         signProgress,
         signConfirm,
         coinControl,
+        disableRBF,
+        bookmark,
+        offlineTx,
     }) {
         const account = this.getAccount();
         if (!account) return null;
@@ -365,6 +432,14 @@ export default class Send extends Component {
                                     value={recipientAddress}
                                     autofocus
                                 />
+                                { bookmark && bookmark.status === 'valid' && (
+                                    <p>{t('send.bookmark.valid', { label: bookmark.label })}</p>
+                                ) }
+                                { bookmark && bookmark.status === 'invalid' && (
+                                    <Status type="warning">
+                                        {t('send.bookmark.invalid', { label: bookmark.label })}
+                                    </Status>
+                                ) }
                                 { debug && (
                                     <span id="sendToSelf" className={style.action} onClick={this.sendToSelf}>
                                         {t('send.toSelf')}
@@ -422,6 +497,11 @@ export default class Send extends Component {
                                     */}
                                 </div>
                                 <p class={style.feeDescription}>{t('send.feeTarget.description.' + (feeTarget || 'loading'))}</p>
+                                <Checkbox
+                                    label={t('send.disableRBF')}
+                                    id="disableRBF"
+                                    onChange={this.handleFormChange}
+                                    checked={disableRBF} />
                             </div>
                             <div class="row buttons flex flex-row flex-between flex-start">
                                 <ButtonLink
@@ -429,10 +509,29 @@ export default class Send extends Component {
                                     href={`/account/${code}`}>
                                     {t('button.back')}
                                 </ButtonLink>
+                                <Button secondary onClick={this.signOffline} disabled={this.sendDisabled() || !valid}>
+                                    {t('send.offline.button')}
+                                </Button>
                                 <Button primary onClick={this.send} disabled={this.sendDisabled() || !valid}>
                                     {t('send.button')}
                                 </Button>
                             </div>
+                            { offlineTx && (
+                                <div class="row">
+                                    <p>{t('send.offline.description', { txID: offlineTx.txID })}</p>
+                                    { offlineTx.warnings.includes('staleData') && (
+                                        <Status type="warning">
+                                            {offlineTx.lastSync
+                                                ? t('send.offline.stale', { lastSync: new Date(offlineTx.lastSync).toLocaleString() })
+                                                : t('send.offline.neverSynced')}
+                                        </Status>
+                                    ) }
+                                    <textarea class={style.rawTx} readOnly rows="6" value={offlineTx.rawTx} />
+                                    <Button secondary onClick={this.cancelOfflineTx}>
+                                        {t('send.offline.cancel')}
+                                    </Button>
+                                </div>
+                            ) }
                         </div>
                     </div>
                     {
@@ -482,10 +581,18 @@ export default class Send extends Component {
                                                     <td>{proposedFee && proposedFee.unit || 'N/A'}</td>
                                                 </tr>
                                                 {
-                                                    proposedFee && proposedFee.conversions && (
+                                                    proposedFeeDetails && proposedFeeDetails.fiat && (
                                                         <tr>
-                                                            <td>{proposedFee.conversions[fiatUnit]}</td>
-                                                            <td>{fiatUnit}</td>
+                                                            <td>{proposedFeeDetails.fiat.amount}</td>
+                                                            <td>{proposedFeeDetails.fiat.unit}</td>
+                                                        </tr>
+                                                    )
+                                                }
+                                                {
+                                                    proposedFeeDetails && (
+                                                        <tr>
+                                                            <td>{proposedFeeDetails.satPerVByte.toFixed(1)}</td>
+                                                            <td>sat/vB</td>
                                                         </tr>
                                                     )
                                                 }
@@ -35,6 +35,7 @@ import style from './device.css';
 
 const DeviceStatus = Object.freeze({
     BOOTLOADER: 'bootloader',
+    BOOTLOADER_RECOVERY: 'bootloader_recovery',
     INITIALIZED: 'initialized',
     UNINITIALIZED: 'uninitialized',
     LOGGED_IN: 'logged_in',
@@ -165,6 +166,7 @@ export default class Device extends Component {
         }
         switch (deviceStatus) {
         case DeviceStatus.BOOTLOADER:
+        case DeviceStatus.BOOTLOADER_RECOVERY:
             return <Bootloader deviceID={deviceID} guide={guide} />;
         case DeviceStatus.REQUIRE_FIRMWARE_UPGRADE:
             return <RequireUpgrade deviceID={deviceID} guide={guide} />;
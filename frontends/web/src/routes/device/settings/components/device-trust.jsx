@@ -0,0 +1,79 @@
+/**
+ * Copyright 2018 Shift Devices AG
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import { Component } from 'preact';
+import { translate } from 'react-i18next';
+import { Button } from '../../../../components/forms';
+import { apiGet, apiPost } from '../../../../utils/request';
+import { apiWebsocket } from '../../../../utils/websocket';
+
+@translate()
+export default class DeviceTrust extends Component {
+    state = {
+        pairedDevice: null,
+    }
+
+    componentDidMount() {
+        this.update();
+        this.unsubscribe = apiWebsocket(({ type, data }) => {
+            if (type === 'devices' && data === 'pairedChanged') {
+                this.update();
+            }
+        });
+    }
+
+    componentWillUnmount() {
+        this.unsubscribe();
+    }
+
+    update = () => {
+        apiGet('paired-devices').then(pairedDevices => {
+            const pairedDevice = pairedDevices.find(({ deviceID }) => deviceID === this.props.deviceID);
+            this.setState({ pairedDevice: pairedDevice || null });
+        });
+    }
+
+    setTrusted = trusted => {
+        const { fingerprint, nickname } = this.state.pairedDevice;
+        apiPost('paired-devices/update', { fingerprint, nickname, trusted }).then(() => this.update());
+    }
+
+    render({ t }, { pairedDevice }) {
+        if (!pairedDevice) {
+            return null;
+        }
+        return (
+            <div>
+                <dl>
+                    <div>
+                        <dt>{t('deviceSettings.trust.label')}</dt>
+                        <dd>{t(`deviceSettings.trust.${pairedDevice.trusted}`)}</dd>
+                    </div>
+                </dl>
+                {!pairedDevice.trusted && (
+                    <div>
+                        <p>{t('deviceSettings.trust.warning')}</p>
+                        <div class="buttons wrapped flex flex-row flex-start flex-wrap">
+                            <Button primary onClick={() => this.setTrusted(true)}>
+                                {t('deviceSettings.trust.button')}
+                            </Button>
+                        </div>
+                    </div>
+                )}
+            </div>
+        );
+    }
+}
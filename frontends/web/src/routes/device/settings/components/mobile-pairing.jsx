@@ -18,7 +18,7 @@ import { Component } from 'preact';
 import { translate } from 'react-i18next';
 import { Button } from '../../../../components/forms';
 import QRCode from '../../../../components/qrcode/qrcode';
-import { apiPost } from '../../../../utils/request';
+import { apiGet, apiPost } from '../../../../utils/request';
 import Dialog from '../../../../components/dialog/dialog';
 import { apiWebsocket } from '../../../../utils/websocket';
 
@@ -27,10 +27,14 @@ export default class MobilePairing extends Component {
     state = {
         channel: null,
         status: false,
+        identityMismatch: false,
     }
 
     componentDidMount() {
         this.unsubscribe = apiWebsocket(this.onDeviceStatus);
+        apiGet('devices/' + this.props.deviceID + '/pairing/identity-mismatch').then(identityMismatch => {
+            this.setState({ identityMismatch });
+        });
     }
 
     componentWillUnmount() {
@@ -53,7 +57,10 @@ export default class MobilePairing extends Component {
                 this.setState({ status: 'error' });
                 break;
             case 'pairingSuccess':
-                this.setState({ status: 'success' });
+                this.setState({ status: 'success', identityMismatch: false });
+                break;
+            case 'pairingIdentityMismatch':
+                this.setState({ identityMismatch: true });
                 break;
             }
         }
@@ -86,6 +93,7 @@ export default class MobilePairing extends Component {
     }, {
         channel,
         status,
+        identityMismatch,
     }) {
         let content;
         if (status === 'start') {
@@ -98,6 +106,11 @@ export default class MobilePairing extends Component {
 
         return (
             <div>
+                {
+                    identityMismatch && (
+                        <p style="color: var(--color-error);">{t('pairing.identityMismatch')}</p>
+                    )
+                }
                 <Button primary onClick={this.startPairing}>
                     {!deviceLocked && t('pairing.button')}
                     {deviceLocked && !mobilePaired && t(`pairing.connectOnly.button`)}
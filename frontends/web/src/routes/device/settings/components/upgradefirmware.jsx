@@ -22,6 +22,19 @@ import WaitDialog from '../../../../components/wait-dialog/wait-dialog';
 import { apiGet, apiPost } from '../../../../utils/request';
 import componentStyle from '../../../../components/style.css';
 
+// compareVersions returns a negative number if a is older than b, 0 if they are equal and a positive
+// number if a is newer than b. Versions are in the format "major.minor.patch".
+function compareVersions(a, b) {
+    const partsA = a.split('.').map(Number);
+    const partsB = b.split('.').map(Number);
+    for (let i = 0; i < 3; i++) {
+        if (partsA[i] !== partsB[i]) {
+            return partsA[i] - partsB[i];
+        }
+    }
+    return 0;
+}
+
 @translate()
 export default class UpgradeFirmware extends Component {
     state = {
@@ -29,6 +42,7 @@ export default class UpgradeFirmware extends Component {
         newVersion: '',
         isConfirming: false,
         activeDialog: false,
+        errorMessage: null,
     }
 
     upgradeFirmware = () => {
@@ -36,10 +50,17 @@ export default class UpgradeFirmware extends Component {
             activeDialog: false,
             isConfirming: true,
         });
-        apiPost('devices/' + this.props.deviceID + '/unlock-bootloader').then((success) => {
+        apiPost('devices/' + this.props.deviceID + '/unlock-bootloader').then((result) => {
+            if (result && result.success === false) {
+                this.setState({
+                    isConfirming: false,
+                    errorMessage: result.errorMessage,
+                });
+                return;
+            }
             this.setState({
-                unlocked: success,
-                isConfirming: success,
+                unlocked: result,
+                isConfirming: result,
             });
         }).catch(e => {
             this.setState({
@@ -63,7 +84,12 @@ export default class UpgradeFirmware extends Component {
         newVersion,
         isConfirming,
         activeDialog,
+        errorMessage,
     }) {
+        const isDowngrade = currentVersion !== null && newVersion !== ''
+            && compareVersions(newVersion, currentVersion) < 0;
+        const isUpgrade = currentVersion !== null && newVersion !== ''
+            && compareVersions(newVersion, currentVersion) > 0;
         return (
             <div>
                 <Button
@@ -72,7 +98,7 @@ export default class UpgradeFirmware extends Component {
                     disabled={disabled}>
                     {t('upgradeFirmware.button')}
                     {
-                        currentVersion !== null && newVersion !== currentVersion && (
+                        isUpgrade && (
                             <div class={componentStyle.badge}>1</div>
                         )
                     }
@@ -80,20 +106,40 @@ export default class UpgradeFirmware extends Component {
                 {
                     activeDialog && (
                         <Dialog title={t('upgradeFirmware.title')}>
-                            <p>{t('upgradeFirmware.description', {
-                                currentVersion, newVersion
-                            })}</p>
+                            {
+                                isDowngrade ? (
+                                    <p>{t('upgradeFirmware.downgrade', {
+                                        currentVersion, newVersion
+                                    })}</p>
+                                ) : (
+                                    <p>{t('upgradeFirmware.description', {
+                                        currentVersion, newVersion
+                                    })}</p>
+                                )
+                            }
                             <div class={['flex', 'flex-row', 'flex-end', 'buttons'].join(' ')}>
                                 <Button secondary onClick={() => this.setState({ activeDialog: false })}>
                                     {t('button.back')}
                                 </Button>
-                                <Button primary onClick={this.upgradeFirmware}>
+                                <Button primary onClick={this.upgradeFirmware} disabled={isDowngrade}>
                                     {t('button.upgrade')}
                                 </Button>
                             </div>
                         </Dialog>
                     )
                 }
+                {
+                    errorMessage && (
+                        <Dialog title={t('upgradeFirmware.title')}>
+                            <p>{errorMessage}</p>
+                            <div class={['flex', 'flex-row', 'flex-end', 'buttons'].join(' ')}>
+                                <Button secondary onClick={() => this.setState({ errorMessage: null })}>
+                                    {t('button.back')}
+                                </Button>
+                            </div>
+                        </Dialog>
+                    )
+                }
                 {
                     isConfirming && (
                         <WaitDialog title={t('upgradeFirmware.title')} includeDefault={!unlocked}>
@@ -28,6 +28,7 @@ import ChangePIN from './components/changepin';
 import Reset from './components/reset';
 import MobilePairing from './components/mobile-pairing';
 import DeviceLock from './components/device-lock';
+import DeviceTrust from './components/device-trust';
 import UpgradeFirmware from './components/upgradefirmware';
 
 @translate()
@@ -159,6 +160,7 @@ export default class Settings extends Component {
                                         onLock={() => this.setState({ lock: true })}
                                         disabled={lock || !paired} />
                                 </div>
+                                <DeviceTrust deviceID={deviceID} />
 
                                 <hr />
 
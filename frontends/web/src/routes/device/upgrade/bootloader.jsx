@@ -30,7 +30,9 @@ export default class Bootloader extends Component {
             upgrading: false,
             errMsg: null,
             progress: 0,
-            upgradeSuccessful: false
+            upgradeSuccessful: false,
+            recovery: false,
+            recoveryReason: null,
         };
     }
 
@@ -56,12 +58,14 @@ export default class Bootloader extends Component {
 
     onStatusChanged = () => {
         apiGet('devices/' + this.props.deviceID + '/bootloader-status')
-            .then(({ upgrading, progress, upgradeSuccessful, errMsg }) => {
+            .then(({ upgrading, progress, upgradeSuccessful, errMsg, recovery, recoveryReason }) => {
                 this.setState({
                     upgrading,
                     progress,
                     upgradeSuccessful,
                     errMsg,
+                    recovery,
+                    recoveryReason,
                 });
             });
     }
@@ -70,6 +74,10 @@ export default class Bootloader extends Component {
         apiPost('devices/' + this.props.deviceID + '/bootloader/upgrade-firmware');
     }
 
+    recover = () => {
+        apiPost('devices/' + this.props.deviceID + '/bootloader/recover');
+    }
+
     render({
         t
     }, {
@@ -77,6 +85,8 @@ export default class Bootloader extends Component {
         progress,
         upgradeSuccessful,
         errMsg,
+        recovery,
+        recoveryReason,
     }) {
         let UpgradeOrStatus;
 
@@ -94,6 +104,17 @@ export default class Bootloader extends Component {
                     </div>
                 );
             }
+        } else if (recovery) {
+            UpgradeOrStatus = (
+                <div>
+                    <p>{t(`bootloader.recovery.${recoveryReason}`)}</p>
+                    <Button
+                        primary
+                        onClick={this.recover}>
+                        {t('bootloader.recovery.button')}
+                    </Button>
+                </div>
+            );
         } else {
             UpgradeOrStatus = (
                 <Button
@@ -136,6 +136,12 @@ export default class Settings extends Component {
                                                     onChange={this.handleToggleCoinControl}
                                                     label={t('settings.expert.coinControl')}
                                                     className="text-medium" />
+                                                <Checkbox
+                                                    checked={config.backend.customCoinsEnabled}
+                                                    id="customCoinsEnabled"
+                                                    onChange={this.handleToggleAccount}
+                                                    label={t('settings.expert.customCoins')}
+                                                    className="text-medium" />
                                             </div>
                                             <div class={style.column}>
                                                 <ButtonLink primary href="/settings/electrum">{t('settings.expert.electrum.title')}</ButtonLink>
@@ -15,7 +15,7 @@
  */
 
 import './polyfill';
-import { apiGet, apiPost } from './request';
+import { apiPost } from './request';
 
 // extConfig is a way to set config values which are inserted
 // externally by templating engines (code generation). A default value
@@ -34,19 +34,8 @@ export const userLanguage = extConfig('{{ LANG }}', 'en');
 // expects an object with a backend or frontend key
 // i.e. { frontend: { language }}
 // returns a promise and passes the new config
-let pendingConfig = {};
+// the backend merges the given keys into the current config atomically,
+// so that several connected frontends do not overwrite each other's changes
 export function setConfig(object) {
-    return apiGet('config')
-        .then((currentConfig = {}) => {
-            const nextConfig = Object.assign(currentConfig, {
-                backend: Object.assign({}, currentConfig.backend, pendingConfig.backend, object.backend),
-                frontend: Object.assign({}, currentConfig.frontend, pendingConfig.frontend, object.frontend)
-            });
-            pendingConfig = nextConfig;
-            return apiPost('config', nextConfig)
-                .then(() => {
-                    pendingConfig = {};
-                    return nextConfig;
-                });
-        });
+    return apiPost('config/patch', object);
 }
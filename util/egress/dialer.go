@@ -0,0 +1,217 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package egress
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/resolver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socks"
+)
+
+const (
+	// IPAny connects to the addresses of a host in the order returned by the resolver.
+	IPAny = ""
+	// IPv4Only connects only to IPv4 addresses.
+	IPv4Only = "ipv4"
+	// IPv6Only connects only to IPv6 addresses.
+	IPv6Only = "ipv6"
+	// PreferIPv4 connects to the IPv4 addresses of a host first.
+	PreferIPv4 = "preferIPv4"
+	// PreferIPv6 connects to the IPv6 addresses of a host first.
+	PreferIPv6 = "preferIPv6"
+
+	defaultDialTimeout = 30 * time.Second
+)
+
+// DialerConfig configures all outbound connections.
+type DialerConfig struct {
+	// SOCKSProxy is the address of a SOCKS5 proxy, e.g. "127.0.0.1:9050" for Tor. If set, all
+	// connections go through the proxy, which also resolves the host names, so that the installed
+	// resolver and the IP preference are not used.
+	SOCKSProxy string
+	// IPPreference is one of IPAny, IPv4Only, IPv6Only, PreferIPv4 and PreferIPv6.
+	IPPreference string
+	// Timeout is the connect timeout. 0 means 30 seconds.
+	Timeout time.Duration
+	// HostTimeouts overrides the connect timeout for the given host names.
+	HostTimeouts map[string]time.Duration
+}
+
+func (config DialerConfig) timeout(host string) time.Duration {
+	if timeout, ok := config.HostTimeouts[host]; ok && timeout > 0 {
+		return timeout
+	}
+	if config.Timeout > 0 {
+		return config.Timeout
+	}
+	return defaultDialTimeout
+}
+
+// orderIPs filters and orders the addresses according to the IP preference.
+func (config DialerConfig) orderIPs(ips []net.IP) []net.IP {
+	ipv4 := []net.IP{}
+	ipv6 := []net.IP{}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, ip)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	switch config.IPPreference {
+	case IPv4Only:
+		return ipv4
+	case IPv6Only:
+		return ipv6
+	case PreferIPv4:
+		return append(ipv4, ipv6...)
+	case PreferIPv6:
+		return append(ipv6, ipv4...)
+	default:
+		return ips
+	}
+}
+
+var (
+	dialerConfig     = func() DialerConfig { return DialerConfig{} }
+	hostResolver     = resolver.System
+	dialerConfigLock locker.Locker
+)
+
+// SetDialerConfig installs the function returning the dialer config, which is called for every
+// connection so that configuration changes apply immediately. nil installs the default config.
+func SetDialerConfig(newDialerConfig func() DialerConfig) {
+	defer dialerConfigLock.Lock()()
+	if newDialerConfig == nil {
+		newDialerConfig = func() DialerConfig { return DialerConfig{} }
+	}
+	dialerConfig = newDialerConfig
+}
+
+// SetResolver installs the resolver used for all outbound connections not going through a proxy.
+// nil installs the system resolver.
+func SetResolver(newResolver resolver.Resolver) {
+	defer dialerConfigLock.Lock()()
+	if newResolver == nil {
+		newResolver = resolver.System
+	}
+	hostResolver = newResolver
+}
+
+func currentDialer() (DialerConfig, resolver.Resolver) {
+	defer dialerConfigLock.RLock()()
+	return dialerConfig(), hostResolver
+}
+
+// dialContext is the single place where outbound connections are made. It connects through the
+// SOCKS proxy if one is configured, or to the first reachable address of the host otherwise.
+func dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	config, hostResolver := currentDialer()
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	timeout := config.timeout(Host(host))
+	dialer := net.Dialer{Timeout: timeout}
+	if config.SOCKSProxy != "" {
+		portNumber, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", config.SOCKSProxy)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			_ = conn.Close()
+			return nil, errp.WithStack(err)
+		}
+		if err := socks.Connect(conn, host, uint16(portNumber)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			_ = conn.Close()
+			return nil, errp.WithStack(err)
+		}
+		return conn, nil
+	}
+	ips, err := hostResolver.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	ips = config.orderIPs(ips)
+	err = errp.Newf("no usable addresses found for %s", host)
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, errp.WithStack(err)
+}
+
+var (
+	usedSOCKSProxy     string
+	usedSOCKSProxyLock locker.Locker
+)
+
+// proxy uses the HTTP proxy from the environment, unless a SOCKS proxy is configured. If the SOCKS
+// proxy changed, the idle connections, which were made differently, are not reused.
+func proxy(request *http.Request) (*url.URL, error) {
+	config, _ := currentDialer()
+	func() {
+		defer usedSOCKSProxyLock.Lock()()
+		if config.SOCKSProxy != usedSOCKSProxy {
+			usedSOCKSProxy = config.SOCKSProxy
+			transport.CloseIdleConnections()
+		}
+	}()
+	if config.SOCKSProxy != "" {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(request)
+}
+
+// transport is shared by all HTTP clients, so that connections are reused across them. Its Proxy
+// is set in init(), as proxy() refers to transport.
+var transport = &http.Transport{
+	DialContext:           dialContext,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   4,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+func init() {
+	transport.Proxy = proxy
+}
+
+// HTTPClient returns a client making its connections with dialContext(). The hosts are not
+// checked, use Do() to send requests with the client, which checks the host of the request and of
+// every redirect.
+func HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package egress controls which external hosts the backend contacts. Every outbound connection is
+// checked against the installed Checker, which by default allows everything. The backend installs
+// a Policy which enumerates the hosts it is expected to contact and, in strict mode, blocks all
+// others. The connections are made by the Dialer, see dialer.go.
+package egress
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+const (
+	// PurposeElectrum is the purpose of connections to Electrum servers.
+	PurposeElectrum = "electrum"
+	// PurposeRates is the purpose of requests for exchange rates.
+	PurposeRates = "rates"
+	// PurposeUpdate is the purpose of requests checking for a new version of the app.
+	PurposeUpdate = "update"
+	// PurposeRelay is the purpose of requests to the relay server used to communicate with the
+	// mobile app.
+	PurposeRelay = "relay"
+	// PurposeMetadataBackup is the purpose of requests storing or fetching the metadata backup.
+	PurposeMetadataBackup = "metadataBackup"
+	// PurposeRemoteSigner is the purpose of connections to a paired app in signer mode on the local
+	// network.
+	PurposeRemoteSigner = "remoteSigner"
+	// PurposeDNS is the purpose of queries to a DNS-over-HTTPS resolver.
+	PurposeDNS = "dns"
+)
+
+// BlockedError is returned when a host may not be contacted.
+type BlockedError struct {
+	Host    string
+	Purpose string
+}
+
+func (err *BlockedError) Error() string {
+	return "egress to " + err.Host + " (" + err.Purpose + ") blocked by policy"
+}
+
+// IsErrorBlocked returns whether the error is a BlockedError.
+func IsErrorBlocked(err error) bool {
+	_, ok := errp.Cause(err).(*BlockedError)
+	return ok
+}
+
+// Checker decides whether a host may be contacted.
+type Checker interface {
+	// Check returns a *BlockedError if the host may not be contacted for the given purpose.
+	Check(host string, purpose string) error
+}
+
+type allowAll struct{}
+
+func (allowAll) Check(string, string) error { return nil }
+
+var (
+	checker     Checker = allowAll{}
+	checkerLock locker.Locker
+)
+
+// SetChecker installs the checker consulted for all outbound connections. nil allows everything.
+func SetChecker(newChecker Checker) {
+	defer checkerLock.Lock()()
+	if newChecker == nil {
+		newChecker = allowAll{}
+	}
+	checker = newChecker
+}
+
+// Dial checks the host of the address and connects to it.
+func Dial(network string, address string, purpose string) (net.Conn, error) {
+	if err := Check(address, purpose); err != nil {
+		return nil, err
+	}
+	return dialContext(context.Background(), network, address)
+}
+
+// DialTLS checks the host of the address and connects to it using TLS. If the config has no
+// ServerName, the host of the address is used, like tls.Dial() does.
+func DialTLS(network string, address string, config *tls.Config, purpose string) (*tls.Conn, error) {
+	conn, err := Dial(network, address, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			_ = conn.Close()
+			return nil, errp.WithStack(err)
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, errp.WithStack(err)
+	}
+	return tlsConn, nil
+}
+
+// Host returns the lower-cased host name of an address ("host:port"), a URL or a plain host name.
+func Host(address string) string {
+	if strings.Contains(address, "://") {
+		if parsed, err := url.Parse(address); err == nil {
+			return strings.ToLower(parsed.Hostname())
+		}
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(address)
+}
+
+// Check asks the installed checker whether the host of the address (see Host()) may be contacted.
+func Check(address string, purpose string) error {
+	defer checkerLock.RLock()()
+	return checker.Check(Host(address), purpose)
+}
+
+// maxRedirects is the number of redirects followed, like the default policy of http.Client.
+const maxRedirects = 10
+
+// checkRedirect checks the host of every redirect target, so that a redirect can't be used to
+// contact a host which may not be contacted.
+func checkRedirect(purpose string) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errp.Newf("stopped after %d redirects", maxRedirects)
+		}
+		return Check(request.URL.Host, purpose)
+	}
+}
+
+// Get is http.Get, checking the host of the URL and of every redirect first.
+func Get(url string, purpose string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return Do(HTTPClient(0), request, purpose)
+}
+
+// Post is http.Post, checking the host of the URL and of every redirect first.
+func Post(url string, contentType string, body io.Reader, purpose string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	return Do(HTTPClient(0), request, purpose)
+}
+
+// Do sends the request with the given client, checking the host of the request URL and of every
+// redirect first. The CheckRedirect policy of the client is replaced.
+func Do(client *http.Client, request *http.Request, purpose string) (*http.Response, error) {
+	if err := Check(request.URL.Host, purpose); err != nil {
+		return nil, err
+	}
+	checkedClient := *client
+	checkedClient.CheckRedirect = checkRedirect(purpose)
+	response, err := checkedClient.Do(request)
+	// http.Client wraps the error of CheckRedirect.
+	if urlErr, ok := err.(*url.Error); ok && IsErrorBlocked(urlErr.Err) {
+		return nil, urlErr.Err
+	}
+	return response, err
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package egress_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/egress"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/resolver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost(t *testing.T) {
+	assert.Equal(t, "btc.shiftcrypto.ch", egress.Host("btc.shiftcrypto.ch:443"))
+	assert.Equal(t, "shiftcrypto.ch", egress.Host("https://Shiftcrypto.ch/updates/desktop.json"))
+	assert.Equal(t, "example.com", egress.Host("example.com"))
+	assert.Equal(t, "::1", egress.Host("[::1]:50001"))
+}
+
+func TestPolicy(t *testing.T) {
+	strict := false
+	policy := egress.NewPolicy(
+		func() []egress.Destination {
+			return []egress.Destination{
+				{Host: "btc.shiftcrypto.ch:443", Purpose: egress.PurposeElectrum},
+				{Host: "https://shiftcrypto.ch/updates/desktop.json", Purpose: egress.PurposeUpdate},
+				{Host: "btc.shiftcrypto.ch:51002", Purpose: egress.PurposeElectrum},
+			}
+		},
+		func() bool { return strict },
+	)
+	egress.SetChecker(policy)
+	defer egress.SetChecker(nil)
+
+	require.NoError(t, egress.Check("btc.shiftcrypto.ch:50002", egress.PurposeElectrum))
+	require.NoError(t, egress.Check("example.com:50002", egress.PurposeElectrum))
+	strict = true
+	require.NoError(t, egress.Check("BTC.shiftcrypto.ch:443", egress.PurposeElectrum))
+	err := egress.Check("example.com:50002", egress.PurposeElectrum)
+	require.Error(t, err)
+	require.True(t, egress.IsErrorBlocked(err))
+	_, err = egress.Get("https://example.com/rates", egress.PurposeRates)
+	require.True(t, egress.IsErrorBlocked(err))
+
+	report := policy.Report()
+	require.True(t, report.Strict)
+	require.Equal(t, []egress.Destination{
+		{Host: "btc.shiftcrypto.ch", Purpose: egress.PurposeElectrum},
+		{Host: "shiftcrypto.ch", Purpose: egress.PurposeUpdate},
+	}, report.Destinations)
+	require.Len(t, report.Contacts, 3)
+	require.Equal(t, "btc.shiftcrypto.ch", report.Contacts[0].Host)
+	require.Equal(t, 2, report.Contacts[0].Attempts)
+	require.Equal(t, 0, report.Contacts[0].Blocked)
+	require.Equal(t, "example.com", report.Contacts[1].Host)
+	require.Equal(t, egress.PurposeElectrum, report.Contacts[1].Purpose)
+	require.Equal(t, 2, report.Contacts[1].Attempts)
+	require.Equal(t, 1, report.Contacts[1].Blocked)
+	require.Equal(t, egress.PurposeRates, report.Contacts[2].Purpose)
+	require.Equal(t, 1, report.Contacts[2].Blocked)
+}
+
+type checkerFunc func(host string, purpose string) error
+
+func (f checkerFunc) Check(host string, purpose string) error { return f(host, purpose) }
+
+func TestRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	_, port, err := net.SplitHostPort(target.Listener.Addr().String())
+	require.NoError(t, err)
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/allowed" {
+			http.Redirect(w, r, target.URL, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "http://localhost:"+port+"/", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	checked := []string{}
+	egress.SetChecker(checkerFunc(func(host string, purpose string) error {
+		checked = append(checked, host)
+		if host == "localhost" {
+			return &egress.BlockedError{Host: host, Purpose: purpose}
+		}
+		return nil
+	}))
+	defer egress.SetChecker(nil)
+
+	_, err = egress.Get(redirector.URL, egress.PurposeRates)
+	require.True(t, egress.IsErrorBlocked(err))
+	request, err := http.NewRequest(http.MethodGet, redirector.URL, nil)
+	require.NoError(t, err)
+	_, err = egress.Do(egress.HTTPClient(0), request, egress.PurposeMetadataBackup)
+	require.True(t, egress.IsErrorBlocked(err))
+	require.Equal(t, []string{"127.0.0.1", "localhost", "127.0.0.1", "localhost"}, checked)
+
+	// Redirects to allowed hosts are followed.
+	response, err := egress.Get(redirector.URL+"/allowed", egress.PurposeRates)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	require.Equal(t, "target", string(body))
+}
+
+func TestAllowAll(t *testing.T) {
+	require.NoError(t, egress.Check("example.com:50002", egress.PurposeElectrum))
+}
+
+func TestDialResolver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	resolved := []string{}
+	egress.SetResolver(resolver.Func(func(host string) ([]net.IP, error) {
+		resolved = append(resolved, host)
+		return []net.IP{net.ParseIP("127.0.0.1")}, nil
+	}))
+	defer egress.SetResolver(nil)
+	conn, err := egress.Dial("tcp", "electrum.example:"+port, egress.PurposeElectrum)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Equal(t, []string{"electrum.example"}, resolved)
+}
+
+func TestDialIPPreference(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	egress.SetResolver(resolver.Func(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("127.0.0.1")}, nil
+	}))
+	defer egress.SetResolver(nil)
+	preference := egress.IPv6Only
+	egress.SetDialerConfig(func() egress.DialerConfig {
+		return egress.DialerConfig{IPPreference: preference, Timeout: time.Second}
+	})
+	defer egress.SetDialerConfig(nil)
+
+	_, err = egress.Dial("tcp", "electrum.example:"+port, egress.PurposeElectrum)
+	require.Error(t, err)
+	preference = egress.IPv4Only
+	conn, err := egress.Dial("tcp", "electrum.example:"+port, egress.PurposeElectrum)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestDialSOCKSProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	requests := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte{5, 0})
+		request := make([]byte, 5+len("electrum.example")+2)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		requests <- request
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		_, _ = conn.Write([]byte("hello"))
+	}()
+
+	egress.SetResolver(resolver.Func(func(host string) ([]net.IP, error) {
+		t.Fatal("the proxy resolves the host names")
+		return nil, nil
+	}))
+	defer egress.SetResolver(nil)
+	egress.SetDialerConfig(func() egress.DialerConfig {
+		return egress.DialerConfig{SOCKSProxy: listener.Addr().String()}
+	})
+	defer egress.SetDialerConfig(nil)
+
+	conn, err := egress.Dial("tcp", "electrum.example:50002", egress.PurposeElectrum)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	require.Equal(t,
+		append(append([]byte{5, 1, 0, 3, byte(len("electrum.example"))}, "electrum.example"...), 0xc3, 0x52),
+		<-requests)
+	hello := make([]byte, 5)
+	_, err = io.ReadFull(conn, hello)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(hello))
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package egress
+
+import (
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Destination is an external host the backend is expected to contact.
+type Destination struct {
+	Host    string `json:"host"`
+	Purpose string `json:"purpose"`
+}
+
+// Contact summarizes the attempts to contact a host for a purpose.
+type Contact struct {
+	Host     string    `json:"host"`
+	Purpose  string    `json:"purpose"`
+	Attempts int       `json:"attempts"`
+	Blocked  int       `json:"blocked"`
+	Last     time.Time `json:"last"`
+}
+
+// Report describes the state of a policy.
+type Report struct {
+	Strict bool `json:"strict"`
+	// Destinations are the hosts the backend is expected to contact, sorted by host.
+	Destinations []Destination `json:"destinations"`
+	// Contacts are the recorded attempts, sorted by host and purpose.
+	Contacts []Contact `json:"contacts"`
+}
+
+type contactKey struct {
+	host    string
+	purpose string
+}
+
+// Policy is a Checker allowing the destinations returned by a function. In strict mode, all other
+// hosts are blocked. The functions are called on every check, so that configuration changes apply
+// immediately. All attempts are recorded, see Report().
+type Policy struct {
+	destinations func() []Destination
+	strict       func() bool
+
+	contacts     map[contactKey]*Contact
+	contactsLock locker.Locker
+
+	now func() time.Time
+	log *logrus.Entry
+}
+
+// NewPolicy creates a new policy.
+func NewPolicy(destinations func() []Destination, strict func() bool) *Policy {
+	return &Policy{
+		destinations: destinations,
+		strict:       strict,
+		contacts:     map[contactKey]*Contact{},
+		now:          time.Now,
+		log:          logging.Get().WithGroup("egress"),
+	}
+}
+
+func (policy *Policy) listed(host string) bool {
+	for _, destination := range policy.destinations() {
+		if Host(destination.Host) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Check implements Checker.
+func (policy *Policy) Check(host string, purpose string) error {
+	blocked := !policy.listed(host) && policy.strict()
+	defer policy.contactsLock.Lock()()
+	key := contactKey{host: host, purpose: purpose}
+	contact, ok := policy.contacts[key]
+	if !ok {
+		contact = &Contact{Host: host, Purpose: purpose}
+		policy.contacts[key] = contact
+	}
+	contact.Attempts++
+	contact.Last = policy.now()
+	if blocked {
+		contact.Blocked++
+		policy.log.WithFields(logrus.Fields{"host": host, "purpose": purpose}).
+			Warning("Blocked connection to unlisted host")
+		return &BlockedError{Host: host, Purpose: purpose}
+	}
+	return nil
+}
+
+// Report returns the destinations and the recorded contacts.
+func (policy *Policy) Report() Report {
+	destinations := []Destination{}
+	seen := map[Destination]bool{}
+	for _, destination := range policy.destinations() {
+		destination.Host = Host(destination.Host)
+		if seen[destination] {
+			continue
+		}
+		seen[destination] = true
+		destinations = append(destinations, destination)
+	}
+	sort.Slice(destinations, func(i, j int) bool {
+		if destinations[i].Host != destinations[j].Host {
+			return destinations[i].Host < destinations[j].Host
+		}
+		return destinations[i].Purpose < destinations[j].Purpose
+	})
+	contacts := []Contact{}
+	func() {
+		defer policy.contactsLock.RLock()()
+		for _, contact := range policy.contacts {
+			contacts = append(contacts, *contact)
+		}
+	}()
+	sort.Slice(contacts, func(i, j int) bool {
+		if contacts[i].Host != contacts[j].Host {
+			return contacts[i].Host < contacts[j].Host
+		}
+		return contacts[i].Purpose < contacts[j].Purpose
+	})
+	return Report{
+		Strict:       policy.strict(),
+		Destinations: destinations,
+		Contacts:     contacts,
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package graphql executes GraphQL queries against lazily resolved objects. It supports the subset
+// of the language needed to select fields: a single query operation with nested selection sets,
+// aliases and literal arguments. Fragments, variables, directives and mutations are not supported.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Resolver returns the value of a field given its arguments. The value is either an Object, a
+// slice, or any value which can be encoded as JSON, in which case subfields are selected from its
+// JSON encoding.
+type Resolver func(args map[string]interface{}) (interface{}, error)
+
+// Object is a value whose fields are only resolved if they are selected.
+type Object map[string]Resolver
+
+// Field is a selected field of a query.
+type Field struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Selections []*Field
+}
+
+func (field *Field) key() string {
+	if field.Alias != "" {
+		return field.Alias
+	}
+	return field.Name
+}
+
+// Error is an error which occurred while parsing or executing a query.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the result of a query.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenNumber
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func isNameStart(char byte) bool {
+	return char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func tokenize(query string) ([]token, error) {
+	tokens := []token{}
+	for index := 0; index < len(query); {
+		char := query[index]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == ',':
+			index++
+		case char == '#':
+			for index < len(query) && query[index] != '\n' {
+				index++
+			}
+		case strings.IndexByte("{}():", char) != -1:
+			tokens = append(tokens, token{kind: tokenPunctuator, value: string(char)})
+			index++
+		case isNameStart(char):
+			start := index
+			for index < len(query) && (isNameStart(query[index]) || isDigit(query[index])) {
+				index++
+			}
+			tokens = append(tokens, token{kind: tokenName, value: query[start:index]})
+		case char == '-' || isDigit(char):
+			start := index
+			index++
+			for index < len(query) && strings.IndexByte("0123456789.eE+-", query[index]) != -1 {
+				index++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: query[start:index]})
+		case char == '"':
+			start := index
+			index++
+			for index < len(query) && query[index] != '"' {
+				if query[index] == '\\' {
+					index++
+				}
+				index++
+			}
+			if index >= len(query) {
+				return nil, errp.New("unterminated string")
+			}
+			index++
+			var value string
+			if err := json.Unmarshal([]byte(query[start:index]), &value); err != nil {
+				return nil, errp.Newf("invalid string %s", query[start:index])
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value})
+		default:
+			return nil, errp.Newf("unexpected character %q", char)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// maxDepth is the maximum nesting of selection sets, so that a malicious query can't exhaust the
+// stack of the recursive parser.
+const maxDepth = 16
+
+type parser struct {
+	tokens []token
+	index  int
+	// depth is the number of selection sets being parsed.
+	depth int
+}
+
+func (parser *parser) peek() token {
+	return parser.tokens[parser.index]
+}
+
+func (parser *parser) next() token {
+	token := parser.tokens[parser.index]
+	if token.kind != tokenEOF {
+		parser.index++
+	}
+	return token
+}
+
+func (parser *parser) expect(kind tokenKind, value string) (token, error) {
+	token := parser.next()
+	if token.kind != kind || (value != "" && token.value != value) {
+		if token.kind == tokenEOF {
+			return token, errp.New("unexpected end of query")
+		}
+		return token, errp.Newf("unexpected %q", token.value)
+	}
+	return token, nil
+}
+
+func (parser *parser) parseSelectionSet() ([]*Field, error) {
+	if _, err := parser.expect(tokenPunctuator, "{"); err != nil {
+		return nil, err
+	}
+	parser.depth++
+	defer func() { parser.depth-- }()
+	if parser.depth > maxDepth {
+		return nil, errp.Newf("the query is nested more than %d levels deep", maxDepth)
+	}
+	fields := []*Field{}
+	for {
+		if token := parser.peek(); token.kind == tokenPunctuator && token.value == "}" {
+			parser.next()
+			break
+		}
+		field, err := parser.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, errp.New("empty selection set")
+	}
+	return fields, nil
+}
+
+func (parser *parser) parseField() (*Field, error) {
+	name, err := parser.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	field := &Field{Name: name.value, Args: map[string]interface{}{}}
+	if token := parser.peek(); token.kind == tokenPunctuator && token.value == ":" {
+		parser.next()
+		name, err := parser.expect(tokenName, "")
+		if err != nil {
+			return nil, err
+		}
+		field.Alias = field.Name
+		field.Name = name.value
+	}
+	if token := parser.peek(); token.kind == tokenPunctuator && token.value == "(" {
+		parser.next()
+		for {
+			if token := parser.peek(); token.kind == tokenPunctuator && token.value == ")" {
+				parser.next()
+				break
+			}
+			argName, err := parser.expect(tokenName, "")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := parser.expect(tokenPunctuator, ":"); err != nil {
+				return nil, err
+			}
+			value, err := parser.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			field.Args[argName.value] = value
+		}
+	}
+	if token := parser.peek(); token.kind == tokenPunctuator && token.value == "{" {
+		field.Selections, err = parser.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (parser *parser) parseValue() (interface{}, error) {
+	token := parser.next()
+	switch token.kind {
+	case tokenString:
+		return token.value, nil
+	case tokenNumber:
+		value, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, errp.Newf("invalid number %s", token.value)
+		}
+		return value, nil
+	case tokenName:
+		switch token.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	case tokenEOF:
+		return nil, errp.New("unexpected end of query")
+	}
+	return nil, errp.Newf("unsupported value %q", token.value)
+}
+
+// Parse parses a query and returns its top level fields.
+func Parse(query string) ([]*Field, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	parser := &parser{tokens: tokens}
+	if token := parser.peek(); token.kind == tokenName {
+		if token.value != "query" {
+			return nil, errp.Newf("unsupported operation %s", token.value)
+		}
+		parser.next()
+		if parser.peek().kind == tokenName {
+			parser.next()
+		}
+	}
+	fields, err := parser.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parser.expect(tokenEOF, ""); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+type executor struct {
+	errors []*Error
+}
+
+func (executor *executor) fail(path []interface{}, format string, args ...interface{}) {
+	executor.errors = append(executor.errors, &Error{
+		Message: fmt.Sprintf(format, args...),
+		Path:    append([]interface{}{}, path...),
+	})
+}
+
+func (executor *executor) resolve(value interface{}, fields []*Field, path []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if object, ok := value.(Object); ok {
+		if len(fields) == 0 {
+			executor.fail(path, "a selection of subfields is required")
+			return nil
+		}
+		result := map[string]interface{}{}
+		for _, field := range fields {
+			fieldPath := append(path, field.key())
+			resolver, ok := object[field.Name]
+			if !ok {
+				executor.fail(fieldPath, "unknown field %s", field.Name)
+				result[field.key()] = nil
+				continue
+			}
+			fieldValue, err := resolver(field.Args)
+			if err != nil {
+				executor.fail(fieldPath, "%s", err.Error())
+				result[field.key()] = nil
+				continue
+			}
+			result[field.key()] = executor.resolve(fieldValue, field.Selections, fieldPath)
+		}
+		return result
+	}
+	reflectValue := reflect.ValueOf(value)
+	switch reflectValue.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if reflectValue.IsNil() {
+			return nil
+		}
+	}
+	if reflectValue.Kind() == reflect.Slice || reflectValue.Kind() == reflect.Array {
+		// The elements might be objects requiring a selection.
+		result := make([]interface{}, reflectValue.Len())
+		for index := range result {
+			result[index] = executor.resolve(
+				reflectValue.Index(index).Interface(), fields, append(path, index))
+		}
+		return result
+	}
+	if len(fields) == 0 {
+		return value
+	}
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		executor.fail(path, "%s", err.Error())
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(jsonBytes, &generic); err != nil {
+		executor.fail(path, "%s", err.Error())
+		return nil
+	}
+	object, ok := generic.(map[string]interface{})
+	if !ok {
+		if _, isSlice := generic.([]interface{}); isSlice {
+			return executor.resolve(generic, fields, path)
+		}
+		executor.fail(path, "scalar fields have no subfields")
+		return nil
+	}
+	result := map[string]interface{}{}
+	for _, field := range fields {
+		if len(field.Args) != 0 {
+			executor.fail(append(path, field.key()), "field %s takes no arguments", field.Name)
+		}
+		fieldValue, ok := object[field.Name]
+		if !ok {
+			executor.fail(append(path, field.key()), "unknown field %s", field.Name)
+		}
+		result[field.key()] = executor.resolve(fieldValue, field.Selections, append(path, field.key()))
+	}
+	return result
+}
+
+// Execute parses and executes the query against the root object. Errors of single fields are
+// reported in the response, with the field set to null; parse errors result in no data.
+func Execute(root Object, query string) *Response {
+	fields, err := Parse(query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	executor := &executor{}
+	data := executor.resolve(root, fields, []interface{}{})
+	return &Response{Data: data, Errors: executor.errors}
+}
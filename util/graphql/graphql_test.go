@@ -0,0 +1,128 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphql_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/graphql"
+	"github.com/stretchr/testify/require"
+)
+
+type balance struct {
+	Available string `json:"available"`
+	Incoming  string `json:"incoming"`
+}
+
+func testRoot() graphql.Object {
+	account := func(code string) graphql.Object {
+		return graphql.Object{
+			"code": func(map[string]interface{}) (interface{}, error) { return code, nil },
+			"balance": func(map[string]interface{}) (interface{}, error) {
+				return &balance{Available: "1.5", Incoming: "0"}, nil
+			},
+			"failing": func(map[string]interface{}) (interface{}, error) {
+				return nil, errors.New("failed")
+			},
+		}
+	}
+	return graphql.Object{
+		"accounts": func(map[string]interface{}) (interface{}, error) {
+			return []graphql.Object{account("btc"), account("ltc")}, nil
+		},
+		"account": func(args map[string]interface{}) (interface{}, error) {
+			code, ok := args["code"].(string)
+			if !ok {
+				return nil, errors.New("code required")
+			}
+			return account(code), nil
+		},
+		"rates": func(map[string]interface{}) (interface{}, error) {
+			return map[string]map[string]float64{"BTC": {"USD": 6000, "EUR": 5000}}, nil
+		},
+	}
+}
+
+func requireJSON(t *testing.T, expected string, response *graphql.Response) {
+	jsonBytes, err := json.Marshal(response)
+	require.NoError(t, err)
+	require.JSONEq(t, expected, string(jsonBytes))
+}
+
+func TestExecute(t *testing.T) {
+	requireJSON(t,
+		`{"data": {"accounts": [
+			{"code": "btc", "balance": {"available": "1.5"}},
+			{"code": "ltc", "balance": {"available": "1.5"}}
+		]}}`,
+		graphql.Execute(testRoot(), `{ accounts { code balance { available } } }`))
+
+	requireJSON(t,
+		`{"data": {"btc": {"code": "btc"}, "usd": {"BTC": {"USD": 6000}}}}`,
+		graphql.Execute(testRoot(), `
+			query Overview {
+				# aliases and arguments
+				btc: account(code: "btc") { code }
+				usd: rates { BTC { USD } }
+			}`))
+}
+
+func TestExecuteErrors(t *testing.T) {
+	requireJSON(t,
+		`{"data": {"account": {"code": "btc", "failing": null}},
+		  "errors": [{"message": "failed", "path": ["account", "failing"]}]}`,
+		graphql.Execute(testRoot(), `{ account(code: "btc") { code failing } }`))
+
+	requireJSON(t,
+		`{"data": {"account": null},
+		  "errors": [{"message": "code required", "path": ["account"]}]}`,
+		graphql.Execute(testRoot(), `{ account { code } }`))
+
+	requireJSON(t,
+		`{"data": {"unknown": null},
+		  "errors": [{"message": "unknown field unknown", "path": ["unknown"]}]}`,
+		graphql.Execute(testRoot(), `{ unknown }`))
+
+	requireJSON(t,
+		`{"data": {"accounts": [null, null]},
+		  "errors": [{"message": "a selection of subfields is required", "path": ["accounts", 0]},
+		             {"message": "a selection of subfields is required", "path": ["accounts", 1]}]}`,
+		graphql.Execute(testRoot(), `{ accounts }`))
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`{`,
+		`{ }`,
+		`{ accounts { code }`,
+		`mutation { accounts { code } }`,
+		`{ account(code: ) { code } }`,
+		`{ account(code: "btc) { code } }`,
+		`{ accounts } }`,
+		`{ accounts @include }`,
+		strings.Repeat(`{ a `, 17) + strings.Repeat(`}`, 17),
+		strings.Repeat(`{ a `, 1000000),
+	} {
+		response := graphql.Execute(testRoot(), query)
+		require.Nil(t, response.Data, query)
+		require.Len(t, response.Errors, 1, query)
+	}
+	_, err := graphql.Parse(strings.Repeat(`{ a `, 16) + strings.Repeat(`}`, 16))
+	require.NoError(t, err)
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fixtures records the traffic of JSON RPC connections into fixtures and replays them, so
+// that the code talking to a server can be tested against real responses, e.g. to reproduce a sync
+// bug from the traffic recorded by a user. The recorder sits between the client and the connection
+// to the server, see Recorder.Backend(), and the replay backend stands in for the server, see
+// NewReplayBackend().
+package fixtures
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Entry is a method call and its response, or a notification of the server.
+type Entry struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	// Result and Error are the response to a method call. Both are empty for notifications.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+	// Notification is true if the server sent the entry without being asked.
+	Notification bool `json:"notification,omitempty"`
+}
+
+// key identifies the call of the entry, i.e. the method and the params.
+func (entry *Entry) key() string {
+	return callKey(entry.Method, entry.Params)
+}
+
+// callKey returns a key for the method call which does not depend on the formatting of the params.
+func callKey(method string, params json.RawMessage) string {
+	var decoded interface{}
+	if err := json.Unmarshal(params, &decoded); err != nil {
+		return method + string(params)
+	}
+	if decoded == nil {
+		// Missing params are the same as no params.
+		decoded = []interface{}{}
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return method + string(params)
+	}
+	return method + string(encoded)
+}
+
+// Fixture is a recorded session. The entries are in the order in which the responses and
+// notifications were received.
+type Fixture struct {
+	Entries []*Entry `json:"entries"`
+}
+
+// Sanitizer redacts an entry before it is recorded, e.g. the information identifying the server.
+// It returns false if the entry must not be recorded at all.
+type Sanitizer func(*Entry) bool
+
+// LoadFixture reads a fixture from a file.
+func LoadFixture(filename string) (*Fixture, error) {
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	fixture := &Fixture{}
+	if err := json.Unmarshal(jsonBytes, fixture); err != nil {
+		return nil, errp.Wrap(err, "Failed to decode the fixture")
+	}
+	return fixture, nil
+}
+
+// Save writes the fixture to a file.
+func (fixture *Fixture) Save(filename string) error {
+	jsonBytes, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(filename, jsonBytes, 0600))
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/fixtures"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func raw(s string) json.RawMessage {
+	return json.RawMessage(s)
+}
+
+func testFixture() *fixtures.Fixture {
+	return &fixtures.Fixture{Entries: []*fixtures.Entry{
+		{Method: "server.version", Params: raw(`["0.0.1","1.2"]`), Result: raw(`["ElectrumX 1.8.7","1.2"]`)},
+		{Method: "get_balance", Params: raw(`["a"]`), Result: raw(`1`)},
+		{Method: "get_balance", Params: raw(`["a"]`), Result: raw(`2`)},
+		{Method: "subscribe", Params: raw(`["a"]`), Result: raw(`"status1"`)},
+		{Method: "subscribe", Params: raw(`["a","status2"]`), Notification: true},
+		{Method: "fail", Params: raw(`[]`), Error: raw(`{"message":"failed"}`)},
+	}}
+}
+
+func newClient(backend rpc.Backend) *jsonrpc.RPCClient {
+	client := jsonrpc.NewRPCClient([]rpc.Backend{backend}, logging.Get().WithGroup("fixtures_test"))
+	client.OnConnect(func() error { return nil })
+	return client
+}
+
+func TestReplay(t *testing.T) {
+	client := newClient(fixtures.NewReplayBackend(testFixture()))
+	notifications := make(chan string, 1)
+	client.SubscribeNotifications("subscribe", func(params []byte) {
+		notifications <- string(params)
+	})
+
+	var version []string
+	require.NoError(t, client.MethodSync(&version, "server.version", "0.0.1", "1.2"))
+	require.Equal(t, []string{"ElectrumX 1.8.7", "1.2"}, version)
+
+	// The responses are replayed in order, and the last one is repeated.
+	var balance int
+	for _, expected := range []int{1, 2, 2} {
+		require.NoError(t, client.MethodSync(&balance, "get_balance", "a"))
+		require.Equal(t, expected, balance)
+	}
+
+	// The notification follows the response.
+	var status string
+	require.NoError(t, client.MethodSync(&status, "subscribe", "a"))
+	require.Equal(t, "status1", status)
+	require.Equal(t, `["a","status2"]`, <-notifications)
+}
+
+// call sends a request over the connection and returns the reply.
+func call(t *testing.T, backend rpc.Backend, request string) map[string]interface{} {
+	t.Helper()
+	conn, err := backend.EstablishConnection()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, conn.Close())
+	}()
+	_, err = conn.Write([]byte(request + "\n"))
+	require.NoError(t, err)
+	reply, err := bufio.NewReader(conn).ReadBytes('\n')
+	require.NoError(t, err)
+	decoded := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(reply, &decoded))
+	return decoded
+}
+
+func TestReplayErrors(t *testing.T) {
+	backend := fixtures.NewReplayBackend(testFixture())
+	require.Equal(t,
+		map[string]interface{}{"jsonrpc": "2.0", "id": 3.0, "error": map[string]interface{}{"message": "failed"}},
+		call(t, backend, `{"id":3,"method":"fail","params":[]}`))
+
+	// Calls which were not recorded fail.
+	reply := call(t, backend, `{"id":4,"method":"get_balance","params":["b"]}`)
+	require.Equal(t, 4.0, reply["id"])
+	require.Contains(t, reply, "error")
+}
+
+func TestRecorder(t *testing.T) {
+	filename := path.Join(test.TstTempDir("fixtures_test"), "fixture.json")
+	recorder := fixtures.NewRecorder(filename, func(entry *fixtures.Entry) bool {
+		if entry.Method == "server.version" {
+			entry.Result = raw(`["redacted","1.2"]`)
+		}
+		return entry.Method != "fail"
+	})
+	require.Equal(t, &fixtures.RecorderStatus{Filename: filename}, recorder.Status())
+
+	client := newClient(recorder.Backend(fixtures.NewReplayBackend(testFixture())))
+	client.SubscribeNotifications("subscribe", func([]byte) {})
+	// Not recorded, as recording is not started yet.
+	require.NoError(t, client.MethodSync(nil, "get_balance", "a"))
+
+	require.NoError(t, recorder.Start())
+	require.NoError(t, client.MethodSync(nil, "server.version", "0.0.1", "1.2"))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, client.MethodSync(nil, "get_balance", "a"))
+	}
+	require.NoError(t, client.MethodSync(nil, "subscribe", "a"))
+	require.NoError(t, client.MethodSync(nil, "server.version", "0.0.1", "1.2"))
+	status := recorder.Status()
+	require.True(t, status.Enabled)
+	require.NoError(t, recorder.Stop())
+	require.False(t, recorder.Status().Enabled)
+
+	// The heartbeat and the repeated balance are recorded once.
+	fixture, err := fixtures.LoadFixture(filename)
+	require.NoError(t, err)
+	expected, err := json.Marshal(&fixtures.Fixture{Entries: []*fixtures.Entry{
+		{Method: "server.version", Params: raw(`["0.0.1","1.2"]`), Result: raw(`["redacted","1.2"]`)},
+		{Method: "get_balance", Params: raw(`["a"]`), Result: raw(`2`)},
+		{Method: "subscribe", Params: raw(`["a"]`), Result: raw(`"status1"`)},
+		{Method: "subscribe", Params: raw(`["a","status2"]`), Notification: true},
+	}})
+	require.NoError(t, err)
+	actual, err := json.Marshal(fixture)
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual))
+	require.Equal(t, len(fixture.Entries), status.Entries)
+}
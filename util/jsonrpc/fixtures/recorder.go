@@ -0,0 +1,234 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// RecorderStatus describes the state of a Recorder.
+type RecorderStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Filename string `json:"filename"`
+	// Entries is the number of entries recorded since recording was started.
+	Entries int `json:"entries"`
+}
+
+// Recorder records the traffic of the connections established through the backends wrapped by
+// Backend() while it is started. The entries are sanitized, and repeated calls with the same
+// response, e.g. the heartbeats, are recorded once. When it is stopped, the fixture is written to
+// the file. It is safe for concurrent use.
+type Recorder struct {
+	filename string
+	sanitize Sanitizer
+
+	// entries is nil if recording is stopped.
+	entries []*Entry
+	// recorded are the keys of the recorded responses, see responseKey().
+	recorded map[string]bool
+	lock     locker.Locker
+}
+
+// NewRecorder creates a stopped recorder which writes to the given file. sanitize can be nil if the
+// entries don't need to be sanitized.
+func NewRecorder(filename string, sanitize Sanitizer) *Recorder {
+	if sanitize == nil {
+		sanitize = func(*Entry) bool { return true }
+	}
+	return &Recorder{filename: filename, sanitize: sanitize}
+}
+
+// Start starts recording a new fixture. It does nothing if recording is already started.
+func (recorder *Recorder) Start() error {
+	defer recorder.lock.Lock()()
+	if recorder.entries != nil {
+		return nil
+	}
+	recorder.entries = []*Entry{}
+	recorder.recorded = map[string]bool{}
+	return nil
+}
+
+// Stop stops recording and writes the fixture to the file. It does nothing if recording is not
+// started.
+func (recorder *Recorder) Stop() error {
+	defer recorder.lock.Lock()()
+	if recorder.entries == nil {
+		return nil
+	}
+	fixture := &Fixture{Entries: recorder.entries}
+	recorder.entries = nil
+	recorder.recorded = nil
+	return fixture.Save(recorder.filename)
+}
+
+// Status returns whether recording is started.
+func (recorder *Recorder) Status() *RecorderStatus {
+	defer recorder.lock.RLock()()
+	return &RecorderStatus{
+		Enabled:  recorder.entries != nil,
+		Filename: recorder.filename,
+		Entries:  len(recorder.entries),
+	}
+}
+
+func (recorder *Recorder) started() bool {
+	defer recorder.lock.RLock()()
+	return recorder.entries != nil
+}
+
+// responseKey identifies the call and its response, so that repeated calls are recorded once.
+func responseKey(entry *Entry) string {
+	return entry.key() + "\n" + string(entry.Result) + "\n" + string(entry.Error)
+}
+
+// add records the entry if recording is started.
+func (recorder *Recorder) add(entry *Entry) {
+	defer recorder.lock.Lock()()
+	if recorder.entries == nil || !recorder.sanitize(entry) {
+		return
+	}
+	if !entry.Notification {
+		key := responseKey(entry)
+		if recorder.recorded[key] {
+			return
+		}
+		recorder.recorded[key] = true
+	}
+	recorder.entries = append(recorder.entries, entry)
+}
+
+// Backend returns a backend which connects through the given backend and records the traffic
+// while the recorder is started.
+func (recorder *Recorder) Backend(backend rpc.Backend) rpc.Backend {
+	return &recordingBackend{backend: backend, recorder: recorder}
+}
+
+type recordingBackend struct {
+	backend  rpc.Backend
+	recorder *Recorder
+}
+
+// EstablishConnection implements rpc.Backend.
+func (backend *recordingBackend) EstablishConnection() (io.ReadWriteCloser, error) {
+	conn, err := backend.backend.EstablishConnection()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{
+		ReadWriteCloser: conn,
+		recorder:        backend.recorder,
+		pending:         map[int]*Entry{},
+	}, nil
+}
+
+// ServerInfo implements rpc.Backend.
+func (backend *recordingBackend) ServerInfo() *rpc.ServerInfo {
+	return backend.backend.ServerInfo()
+}
+
+// recordingConn passes the traffic through to the connection and records the requests and the
+// responses. The messages are separated by newlines.
+type recordingConn struct {
+	io.ReadWriteCloser
+	recorder *Recorder
+
+	// written and read are the incomplete messages sent and received so far.
+	written []byte
+	read    []byte
+	// pending are the requests waiting for their response, by ID.
+	pending map[int]*Entry
+	lock    sync.Mutex
+}
+
+// splitMessages calls f with each complete message in the buffer and returns the rest.
+func splitMessages(buffer []byte, f func([]byte)) []byte {
+	for {
+		index := bytes.IndexByte(buffer, '\n')
+		if index < 0 {
+			return append([]byte(nil), buffer...)
+		}
+		f(buffer[:index])
+		buffer = buffer[index+1:]
+	}
+}
+
+// Write implements io.Writer. The request is registered before it is sent, so that its response
+// can't arrive first.
+func (conn *recordingConn) Write(p []byte) (int, error) {
+	if conn.recorder.started() {
+		conn.lock.Lock()
+		conn.written = splitMessages(append(conn.written, p...), conn.request)
+		conn.lock.Unlock()
+	}
+	return conn.ReadWriteCloser.Write(p)
+}
+
+// Read implements io.Reader.
+func (conn *recordingConn) Read(p []byte) (int, error) {
+	n, err := conn.ReadWriteCloser.Read(p)
+	if n > 0 && conn.recorder.started() {
+		conn.lock.Lock()
+		conn.read = splitMessages(append(conn.read, p[:n]...), conn.response)
+		conn.lock.Unlock()
+	}
+	return n, err
+}
+
+// request must be called with the lock held.
+func (conn *recordingConn) request(message []byte) {
+	request := &struct {
+		ID     *int            `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}{}
+	if err := json.Unmarshal(message, request); err != nil || request.ID == nil {
+		return
+	}
+	conn.pending[*request.ID] = &Entry{Method: request.Method, Params: request.Params}
+}
+
+// response must be called with the lock held.
+func (conn *recordingConn) response(message []byte) {
+	response := &struct {
+		ID     *int            `json:"id"`
+		Method *string         `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}{}
+	if err := json.Unmarshal(message, response); err != nil {
+		return
+	}
+	if response.ID != nil {
+		if entry, ok := conn.pending[*response.ID]; ok {
+			delete(conn.pending, *response.ID)
+			entry.Result = response.Result
+			if string(response.Error) != "null" {
+				entry.Error = response.Error
+			}
+			conn.recorder.add(entry)
+		}
+	}
+	if response.Method != nil {
+		conn.recorder.add(&Entry{Method: *response.Method, Params: response.Params, Notification: true})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// ReplayBackend is an rpc.Backend which serves the responses of a fixture instead of connecting to
+// a server. A call is answered with the recorded responses to the same method and params in the
+// order in which they were recorded, the last one being repeated. Calls which were not recorded
+// fail. The notifications are sent after the response they followed in the recording.
+type ReplayBackend struct {
+	fixture *Fixture
+}
+
+// NewReplayBackend creates a backend which replays the given fixture.
+func NewReplayBackend(fixture *Fixture) *ReplayBackend {
+	return &ReplayBackend{fixture: fixture}
+}
+
+// ServerInfo implements rpc.Backend.
+func (backend *ReplayBackend) ServerInfo() *rpc.ServerInfo {
+	return &rpc.ServerInfo{Server: "replay"}
+}
+
+// EstablishConnection implements rpc.Backend. Each connection replays the fixture from the start.
+func (backend *ReplayBackend) EstablishConnection() (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+	go newReplaySession(backend.fixture, server).serve()
+	return client, nil
+}
+
+// replaySession serves the fixture over one connection.
+type replaySession struct {
+	fixture *Fixture
+	conn    net.Conn
+
+	// responses are the indices of the recorded responses, by call key.
+	responses map[string][]int
+	// replayed is how many responses were replayed, by call key.
+	replayed map[string]int
+	// notified are the indices of the notifications already sent.
+	notified map[int]bool
+
+	// outbox holds the messages to be sent. They are sent in the background, so that serve() keeps
+	// reading requests while the client is busy, as the pipe is unbuffered.
+	outbox     [][]byte
+	outboxCond *sync.Cond
+	closed     bool
+}
+
+func newReplaySession(fixture *Fixture, conn net.Conn) *replaySession {
+	session := &replaySession{
+		fixture:    fixture,
+		conn:       conn,
+		responses:  map[string][]int{},
+		replayed:   map[string]int{},
+		notified:   map[int]bool{},
+		outboxCond: sync.NewCond(&sync.Mutex{}),
+	}
+	for index, entry := range fixture.Entries {
+		if !entry.Notification {
+			session.responses[entry.key()] = append(session.responses[entry.key()], index)
+		}
+	}
+	return session
+}
+
+func (session *replaySession) serve() {
+	go session.send()
+	defer func() {
+		session.outboxCond.L.Lock()
+		session.closed = true
+		session.outboxCond.L.Unlock()
+		session.outboxCond.Signal()
+	}()
+	reader := bufio.NewReader(session.conn)
+	for {
+		message, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		request := &struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}{}
+		if err := json.Unmarshal(message, request); err != nil || request.ID == nil {
+			return
+		}
+		replies := session.reply(*request.ID, request.Method, request.Params)
+		session.outboxCond.L.Lock()
+		session.outbox = append(session.outbox, replies...)
+		session.outboxCond.L.Unlock()
+		session.outboxCond.Signal()
+	}
+}
+
+// send writes the messages in the outbox until the session is closed.
+func (session *replaySession) send() {
+	defer func() {
+		_ = session.conn.Close()
+	}()
+	for {
+		session.outboxCond.L.Lock()
+		for len(session.outbox) == 0 && !session.closed {
+			session.outboxCond.Wait()
+		}
+		if session.closed {
+			session.outboxCond.L.Unlock()
+			return
+		}
+		message := session.outbox[0]
+		session.outbox = session.outbox[1:]
+		session.outboxCond.L.Unlock()
+		if _, err := session.conn.Write(message); err != nil {
+			return
+		}
+	}
+}
+
+// reply returns the messages to send in reply to a request: the response, followed by the
+// notifications which followed it in the recording and were not sent yet.
+func (session *replaySession) reply(id int, method string, params json.RawMessage) [][]byte {
+	key := callKey(method, params)
+	indices := session.responses[key]
+	if len(indices) == 0 {
+		return [][]byte{encodeMessage(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      id,
+			"error": map[string]interface{}{
+				"message": fmt.Sprintf("the fixture has no response to %s%s", method, params),
+			},
+		})}
+	}
+	replayed := session.replayed[key]
+	if replayed >= len(indices) {
+		replayed = len(indices) - 1
+	}
+	session.replayed[key] = replayed + 1
+	index := indices[replayed]
+	entry := session.fixture.Entries[index]
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if len(entry.Error) != 0 {
+		response["error"] = entry.Error
+	} else {
+		response["result"] = entry.Result
+	}
+	messages := [][]byte{encodeMessage(response)}
+	for index++; index < len(session.fixture.Entries); index++ {
+		notification := session.fixture.Entries[index]
+		if !notification.Notification {
+			break
+		}
+		if session.notified[index] {
+			continue
+		}
+		session.notified[index] = true
+		messages = append(messages, encodeMessage(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  notification.Method,
+			"params":  notification.Params,
+		}))
+	}
+	return messages
+}
+
+func encodeMessage(message map[string]interface{}) []byte {
+	return append(jsonp.MustMarshal(message), '\n')
+}
@@ -27,6 +27,10 @@ import (
 // Logger adds a method to the logrus logger.
 type Logger struct {
 	logrus.Logger
+
+	// filename is the file the entries are written to, or "" if they are written to STDOUT or
+	// STDERR.
+	filename string
 }
 
 // NewLogger returns a new logger based on the given configuration.
@@ -50,12 +54,19 @@ func NewLogger(configuration *Configuration) *Logger {
 			panic(errp.WithStack(err))
 		}
 		logger.Out = file
+		logger.filename = configuration.Output
 	}
 	logger.Level = configuration.Level
 	fmt.Printf("Logging into '%s' from '%s'.\n", configuration.Output, configuration.Level)
 	return &logger
 }
 
+// Filename returns the file the entries are written to, or "" if they are written to STDOUT or
+// STDERR.
+func (logger *Logger) Filename() string {
+	return logger.filename
+}
+
 // WithGroup sets a trace group for the log entry.
 func (logger *Logger) WithGroup(group string) *logrus.Entry {
 	return logger.WithField("group", group)
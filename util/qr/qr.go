@@ -0,0 +1,64 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package qr renders QR codes, so that all frontends show identical codes.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	qrcode "github.com/skip2/go-qrcode"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// PNGDataURL returns the QR code of the data as a PNG image of the given size in pixels, encoded
+// as a data URL.
+func PNGDataURL(data string, size int) (string, error) {
+	qr, err := qrcode.New(data, qrcode.Medium)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	png, err := qr.PNG(size)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// SVG returns the QR code of the data as an SVG image. Each module is one unit, so the image scales
+// to any size without blurring.
+func SVG(data string) (string, error) {
+	qr, err := qrcode.New(data, qrcode.Medium)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	bitmap := qr.Bitmap()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf,
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		len(bitmap), len(bitmap))
+	buf.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.String(), nil
+}
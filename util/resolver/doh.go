@@ -0,0 +1,226 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+
+	dohTimeout = 10 * time.Second
+)
+
+type dohCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// DoH resolves host names with DNS queries over HTTPS (RFC 8484). The host of the endpoint itself is
+// resolved by the system resolver, so the endpoint should be given by its IP address, e.g.
+// https://1.1.1.1/dns-query, to avoid any plaintext DNS query.
+type DoH struct {
+	endpoint string
+	client   *http.Client
+
+	cache     map[string]dohCacheEntry
+	cacheLock locker.Locker
+	now       func() time.Time
+}
+
+// NewDoH creates a new DoH resolver querying the given endpoint URL. If client is nil, a client
+// with a default timeout is used.
+func NewDoH(endpoint string, client *http.Client) *DoH {
+	if client == nil {
+		client = &http.Client{Timeout: dohTimeout}
+	}
+	return &DoH{
+		endpoint: endpoint,
+		client:   client,
+		cache:    map[string]dohCacheEntry{},
+		now:      time.Now,
+	}
+}
+
+// Endpoint returns the URL of the DoH endpoint.
+func (doh *DoH) Endpoint() string {
+	return doh.endpoint
+}
+
+// LookupIP implements Resolver. The IPv4 addresses are queried first, the IPv6 addresses only if
+// there are no IPv4 addresses. The results are cached according to their TTL.
+func (doh *DoH) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ips, ok := doh.cached(host); ok {
+		return ips, nil
+	}
+	for _, queryType := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		ips, ttl, err := doh.query(host, queryType)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 {
+			func() {
+				defer doh.cacheLock.Lock()()
+				doh.cache[host] = dohCacheEntry{
+					ips:     ips,
+					expires: doh.now().Add(time.Duration(ttl) * time.Second),
+				}
+			}()
+			return ips, nil
+		}
+	}
+	return nil, errp.Newf("no addresses found for %s", host)
+}
+
+func (doh *DoH) cached(host string) ([]net.IP, bool) {
+	defer doh.cacheLock.RLock()()
+	entry, ok := doh.cache[host]
+	if !ok || doh.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.ips, true
+}
+
+func (doh *DoH) query(host string, queryType uint16) ([]net.IP, uint32, error) {
+	query, err := encodeQuery(host, queryType)
+	if err != nil {
+		return nil, 0, err
+	}
+	request, err := http.NewRequest(http.MethodGet,
+		doh.endpoint+"?dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+	if err != nil {
+		return nil, 0, errp.WithStack(err)
+	}
+	request.Header.Set("Accept", "application/dns-message")
+	response, err := doh.client.Do(request)
+	if err != nil {
+		return nil, 0, errp.WithStack(err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, errp.Newf("DNS query for %s failed: %s", host, response.Status)
+	}
+	message, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, errp.WithStack(err)
+	}
+	return decodeAnswers(message, queryType)
+}
+
+// encodeQuery encodes a DNS query message for the host. The ID is 0, as recommended for DoH.
+func encodeQuery(host string, queryType uint16) ([]byte, error) {
+	// ID 0, recursion desired, one question.
+	message := []byte{0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errp.Newf("invalid host name %s", host)
+		}
+		message = append(message, byte(len(label)))
+		message = append(message, label...)
+	}
+	message = append(message, 0)
+	message = append(message, byte(queryType>>8), byte(queryType), 0, dnsClassIN)
+	return message, nil
+}
+
+// skipName returns the offset after the (possibly compressed) name starting at offset.
+func skipName(message []byte, offset int) (int, error) {
+	for {
+		if offset >= len(message) {
+			return 0, errp.New("truncated DNS message")
+		}
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			// Compression pointer, which always terminates the name.
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
+
+// decodeAnswers returns the addresses of the given type in the answers of a DNS response message,
+// and the minimum TTL of those answers.
+func decodeAnswers(message []byte, queryType uint16) ([]net.IP, uint32, error) {
+	if len(message) < 12 {
+		return nil, 0, errp.New("truncated DNS message")
+	}
+	if rcode := message[3] & 0x0f; rcode != 0 {
+		// 3 (NXDOMAIN) means that the host does not exist.
+		return nil, 0, errp.Newf("DNS query failed with code %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(message[4:6]))
+	answers := int(binary.BigEndian.Uint16(message[6:8]))
+	offset := 12
+	for i := 0; i < questions; i++ {
+		var err error
+		offset, err = skipName(message, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += 4
+	}
+	ips := []net.IP{}
+	var minTTL uint32
+	for i := 0; i < answers; i++ {
+		var err error
+		offset, err = skipName(message, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if offset+10 > len(message) {
+			return nil, 0, errp.New("truncated DNS message")
+		}
+		recordType := binary.BigEndian.Uint16(message[offset : offset+2])
+		ttl := binary.BigEndian.Uint32(message[offset+4 : offset+8])
+		length := int(binary.BigEndian.Uint16(message[offset+8 : offset+10]))
+		offset += 10
+		if offset+length > len(message) {
+			return nil, 0, errp.New("truncated DNS message")
+		}
+		data := message[offset : offset+length]
+		offset += length
+		// CNAME records are skipped, the resolver includes the records of the target.
+		if recordType != queryType ||
+			(recordType == dnsTypeA && length != net.IPv4len) ||
+			(recordType == dnsTypeAAAA && length != net.IPv6len) {
+			continue
+		}
+		ips = append(ips, net.IP(append([]byte{}, data...)))
+		if len(ips) == 1 || ttl < minTTL {
+			minTTL = ttl
+		}
+	}
+	return ips, minTTL, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resolver provides the host name resolvers the backend can use for its outbound
+// connections instead of the system resolver, which leaks the contacted host names in plaintext
+// DNS queries to the local network.
+package resolver
+
+import (
+	"net"
+)
+
+// Resolver looks up the IP addresses of a host name.
+type Resolver interface {
+	LookupIP(host string) ([]net.IP, error)
+}
+
+// Func adapts a function to the Resolver interface.
+type Func func(host string) ([]net.IP, error)
+
+// LookupIP implements Resolver.
+func (f Func) LookupIP(host string) ([]net.IP, error) {
+	return f(host)
+}
+
+type system struct{}
+
+func (system) LookupIP(host string) ([]net.IP, error) {
+	return net.LookupIP(host)
+}
+
+// System is the resolver of the operating system.
+var System Resolver = system{}
@@ -0,0 +1,135 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver_test
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/resolver"
+	"github.com/stretchr/testify/require"
+)
+
+// dnsResponse answers the query with a CNAME record followed by one record per address, using
+// compression pointers to the question name.
+func dnsResponse(query []byte, ips []net.IP) []byte {
+	response := append([]byte{}, query...)
+	response[2] |= 0x80 // response
+	queryType := query[len(query)-3]
+	cname := []byte{0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 12}
+	response = append(response, cname...)
+	answers := 1
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if queryType != 1 {
+				continue
+			}
+			ip = ip.To4()
+		} else if queryType != 28 {
+			continue
+		}
+		response = append(response, 0xc0, 12, 0, queryType, 0, 1, 0, 0, 1, 0, 0, byte(len(ip)))
+		response = append(response, ip...)
+		answers++
+	}
+	response[7] = byte(answers)
+	return response
+}
+
+func TestDoH(t *testing.T) {
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		require.Equal(t, "application/dns-message", r.Header.Get("Accept"))
+		query, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		require.NoError(t, err)
+		var ips []net.IP
+		if string(query[12:len(query)-4]) == "\x03btc\x0bshiftcrypto\x02ch\x00" {
+			ips = []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("5.6.7.8")}
+		} else if string(query[12:len(query)-4]) == "\x04ipv6\x07example\x00" {
+			ips = []net.IP{net.ParseIP("2001:db8::1")}
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(dnsResponse(query, ips))
+	}))
+	defer server.Close()
+
+	doh := resolver.NewDoH(server.URL, nil)
+	ips, err := doh.LookupIP("BTC.shiftcrypto.ch")
+	require.NoError(t, err)
+	require.Len(t, ips, 2)
+	require.True(t, net.ParseIP("1.2.3.4").Equal(ips[0]))
+	require.True(t, net.ParseIP("5.6.7.8").Equal(ips[1]))
+	require.Equal(t, 1, queries)
+	// Cached.
+	_, err = doh.LookupIP("btc.shiftcrypto.ch")
+	require.NoError(t, err)
+	require.Equal(t, 1, queries)
+
+	ips, err = doh.LookupIP("ipv6.example")
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	require.True(t, net.ParseIP("2001:db8::1").Equal(ips[0]))
+	require.Equal(t, 3, queries)
+
+	_, err = doh.LookupIP("unknown.example")
+	require.Error(t, err)
+
+	ips, err = doh.LookupIP("10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, []net.IP{net.ParseIP("10.0.0.1")}, ips)
+}
+
+func TestTor(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte{5, 0})
+		request := make([]byte, 5)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		host := make([]byte, int(request[4])+2)
+		if _, err := io.ReadFull(conn, host); err != nil {
+			return
+		}
+		if request[1] != 0xf0 || string(host[:len(host)-2]) != "btc.shiftcrypto.ch" {
+			_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 1, 2, 3, 4, 0, 0})
+		_, _ = ioutil.ReadAll(conn)
+	}()
+
+	ips, err := resolver.NewTor(listener.Addr().String()).LookupIP("btc.shiftcrypto.ch")
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	require.True(t, net.ParseIP("1.2.3.4").Equal(ips[0]))
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"net"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socks"
+)
+
+const torTimeout = 30 * time.Second
+
+// Tor resolves host names through the Tor network, using the RESOLVE extension of the SOCKS port of
+// a running Tor client.
+type Tor struct {
+	socksAddress string
+}
+
+// NewTor creates a new Tor resolver using the SOCKS port at the given address, e.g.
+// "127.0.0.1:9050".
+func NewTor(socksAddress string) *Tor {
+	return &Tor{socksAddress: socksAddress}
+}
+
+// LookupIP implements Resolver. Tor only returns one address per host name.
+func (tor *Tor) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	conn, err := net.DialTimeout("tcp", tor.socksAddress, torTimeout)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetDeadline(time.Now().Add(torTimeout)); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	ip, err := socks.Resolve(conn, host)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Tor failed to resolve "+host)
+	}
+	return []net.IP{ip}, nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socks implements the client side of the SOCKS5 protocol (RFC 1928), without
+// authentication, as needed to connect through Tor or another local proxy.
+package socks
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	version = 5
+
+	commandConnect = 1
+	// commandResolve is Tor's extension of SOCKS5 resolving a host name through the Tor network,
+	// see https://gitweb.torproject.org/torspec.git/tree/socks-extensions.txt.
+	commandResolve = 0xf0
+
+	addressIPv4   = 1
+	addressDomain = 3
+	addressIPv6   = 4
+)
+
+// Error is returned if the proxy replies with an error code.
+type Error struct {
+	Code byte
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("SOCKS request failed with code %d", err.Code)
+}
+
+// greet negotiates the (absent) authentication.
+func greet(conn net.Conn) error {
+	if _, err := conn.Write([]byte{version, 1, 0}); err != nil {
+		return errp.WithStack(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return errp.WithStack(err)
+	}
+	if reply[0] != version || reply[1] != 0 {
+		return errp.New("SOCKS proxy rejected the authentication method")
+	}
+	return nil
+}
+
+// request sends a request for the host and returns the address of the reply.
+func request(conn net.Conn, command byte, host string, port uint16) (net.IP, error) {
+	if err := greet(conn); err != nil {
+		return nil, err
+	}
+	message := []byte{version, command, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			message = append(message, addressIPv4)
+			message = append(message, ip4...)
+		} else {
+			message = append(message, addressIPv6)
+			message = append(message, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, errp.Newf("invalid host name %s", host)
+		}
+		message = append(message, addressDomain, byte(len(host)))
+		message = append(message, host...)
+	}
+	message = append(message, byte(port>>8), byte(port))
+	if _, err := conn.Write(message); err != nil {
+		return nil, errp.WithStack(err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if header[0] != version {
+		return nil, errp.New("invalid SOCKS reply")
+	}
+	if header[1] != 0 {
+		return nil, errp.WithStack(&Error{Code: header[1]})
+	}
+	var length int
+	switch header[3] {
+	case addressIPv4:
+		length = net.IPv4len
+	case addressIPv6:
+		length = net.IPv6len
+	case addressDomain:
+		lengthByte := make([]byte, 1)
+		if _, err := io.ReadFull(conn, lengthByte); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		length = int(lengthByte[0])
+	default:
+		return nil, errp.Newf("unexpected SOCKS address type %d", header[3])
+	}
+	// The address is followed by a port.
+	address := make([]byte, length+2)
+	if _, err := io.ReadFull(conn, address); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if header[3] == addressDomain {
+		return nil, nil
+	}
+	return net.IP(address[:length]), nil
+}
+
+// Connect asks the proxy at the other end of conn to connect to the host and port. If the host is
+// a name, it is resolved by the proxy. Afterwards, conn is connected to the host.
+func Connect(conn net.Conn, host string, port uint16) error {
+	_, err := request(conn, commandConnect, host, port)
+	return err
+}
+
+// Resolve asks the Tor proxy at the other end of conn to resolve the host name. conn can't be used
+// afterwards.
+func Resolve(conn net.Conn, host string) (net.IP, error) {
+	ip, err := request(conn, commandResolve, host, 0)
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return nil, errp.New("SOCKS proxy did not return an IP address")
+	}
+	return ip, nil
+}
@@ -15,8 +15,11 @@
 package system
 
 import (
+	"bytes"
 	"os/exec"
 	"runtime"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 )
 
 // Open opens the given URL in the default browser of the user.
@@ -35,3 +38,23 @@ func Open(url string) error {
 	args = append(args, url)
 	return exec.Command(cmd, args...).Start()
 }
+
+// WriteFileElevated writes the content to the given file with administrator privileges, prompting
+// the user for authorization via polkit. The optional commands are run with the same privileges
+// after the file has been written. Only supported on Linux.
+func WriteFileElevated(filename string, content []byte, commands ...string) error {
+	if runtime.GOOS != "linux" {
+		return errp.Newf("Writing files with elevated permissions is not supported on %s", runtime.GOOS)
+	}
+	script := `cat > "$0"`
+	for _, command := range commands {
+		script += " && " + command
+	}
+	cmd := exec.Command("pkexec", "sh", "-c", script, filename)
+	cmd.Stdin = bytes.NewReader(content)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errp.WithContext(errp.WithMessage(err, "Failed to write file with elevated permissions"),
+			errp.Context{"filename": filename, "output": string(output)})
+	}
+	return nil
+}